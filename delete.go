@@ -73,7 +73,7 @@ status of "ubuntu01" as "stopped" the following will delete resources held for
 			return err
 		}
 		switch s {
-		case libcontainer.Stopped:
+		case libcontainer.Stopped, libcontainer.StoppedWithStragglers:
 			destroy(container)
 		case libcontainer.Created:
 			return killContainer(container)
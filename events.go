@@ -28,7 +28,9 @@ type stats struct {
 	Memory  memory             `json:"memory"`
 	Pids    pids               `json:"pids"`
 	Blkio   blkio              `json:"blkio"`
+	NetCls  netCls             `json:"net_cls"`
 	Hugetlb map[string]hugetlb `json:"hugetlb"`
+	Errors  map[string]string  `json:"errors,omitempty"`
 }
 
 type hugetlb struct {
@@ -53,6 +55,25 @@ type blkio struct {
 	IoMergedRecursive       []blkioEntry `json:"ioMergedRecursive,omitempty"`
 	IoTimeRecursive         []blkioEntry `json:"ioTimeRecursive,omitempty"`
 	SectorsRecursive        []blkioEntry `json:"sectorsRecursive,omitempty"`
+	PSI                     *psi         `json:"psi,omitempty"`
+}
+
+type psiData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+type psi struct {
+	Some psiData `json:"some,omitempty"`
+	Full psiData `json:"full,omitempty"`
+}
+
+type devicesUpdate struct {
+	Added   []libcontainer.DeviceRule `json:"added,omitempty"`
+	Removed []libcontainer.DeviceRule `json:"removed,omitempty"`
+	Error   string                    `json:"error,omitempty"`
 }
 
 type pids struct {
@@ -60,6 +81,10 @@ type pids struct {
 	Limit   uint64 `json:"limit,omitempty"`
 }
 
+type netCls struct {
+	Classid uint32 `json:"classid,omitempty"`
+}
+
 type throttling struct {
 	Periods          uint64 `json:"periods,omitempty"`
 	ThrottledPeriods uint64 `json:"throttledPeriods,omitempty"`
@@ -77,6 +102,7 @@ type cpuUsage struct {
 type cpu struct {
 	Usage      cpuUsage   `json:"usage,omitempty"`
 	Throttling throttling `json:"throttling,omitempty"`
+	PSI        *psi       `json:"psi,omitempty"`
 }
 
 type memoryEntry struct {
@@ -93,6 +119,7 @@ type memory struct {
 	Kernel    memoryEntry       `json:"kernel,omitempty"`
 	KernelTCP memoryEntry       `json:"kernelTCP,omitempty"`
 	Raw       map[string]uint64 `json:"raw,omitempty"`
+	PSI       *psi              `json:"psi,omitempty"`
 }
 
 var eventsCommand = cli.Command{
@@ -106,6 +133,7 @@ information is displayed once every 5 seconds.`,
 	Flags: []cli.Flag{
 		cli.DurationFlag{Name: "interval", Value: 5 * time.Second, Usage: "set the stats collection interval"},
 		cli.BoolFlag{Name: "stats", Usage: "display the container's stats then exit"},
+		cli.StringFlag{Name: "device-profile", Usage: "apply the device rules of a JSON file to the container whenever it changes"},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, exactArgs); err != nil {
@@ -123,7 +151,7 @@ information is displayed once every 5 seconds.`,
 		if err != nil {
 			return err
 		}
-		if status == libcontainer.Stopped {
+		if status == libcontainer.Stopped || status == libcontainer.StoppedWithStragglers {
 			return fmt.Errorf("container with id %s is not running", container.ID())
 		}
 		var (
@@ -165,8 +193,27 @@ information is displayed once every 5 seconds.`,
 		if err != nil {
 			return err
 		}
+		lifetime, err := container.NotifyLifetimeExceeded()
+		if err != nil {
+			return err
+		}
+		var devices <-chan libcontainer.DevicesUpdate
+		if path := context.String("device-profile"); path != "" {
+			if devices, err = container.WatchDeviceProfile(path); err != nil {
+				return err
+			}
+		}
 		for {
 			select {
+			case u, ok := <-devices:
+				if !ok {
+					devices = nil
+					break
+				}
+				events <- &event{Type: "devicesUpdated", ID: container.ID(), Data: convertDevicesUpdate(u)}
+			case <-lifetime:
+				events <- &event{Type: "lifetime", ID: container.ID()}
+				lifetime = nil
 			case _, ok := <-n:
 				if ok {
 					// this means an oom event was received, if it is !ok then
@@ -205,6 +252,7 @@ func convertLibcontainerStats(ls *libcontainer.Stats) *stats {
 	s.CPU.Throttling.Periods = cg.CpuStats.ThrottlingData.Periods
 	s.CPU.Throttling.ThrottledPeriods = cg.CpuStats.ThrottlingData.ThrottledPeriods
 	s.CPU.Throttling.ThrottledTime = cg.CpuStats.ThrottlingData.ThrottledTime
+	s.CPU.PSI = convertPSI(cg.CpuStats.PSI)
 
 	s.Memory.Cache = cg.MemoryStats.Cache
 	s.Memory.Kernel = convertMemoryEntry(cg.MemoryStats.KernelUsage)
@@ -212,6 +260,7 @@ func convertLibcontainerStats(ls *libcontainer.Stats) *stats {
 	s.Memory.Swap = convertMemoryEntry(cg.MemoryStats.SwapUsage)
 	s.Memory.Usage = convertMemoryEntry(cg.MemoryStats.Usage)
 	s.Memory.Raw = cg.MemoryStats.Stats
+	s.Memory.PSI = convertPSI(cg.MemoryStats.PSI)
 
 	s.Blkio.IoServiceBytesRecursive = convertBlkioEntry(cg.BlkioStats.IoServiceBytesRecursive)
 	s.Blkio.IoServicedRecursive = convertBlkioEntry(cg.BlkioStats.IoServicedRecursive)
@@ -221,14 +270,36 @@ func convertLibcontainerStats(ls *libcontainer.Stats) *stats {
 	s.Blkio.IoMergedRecursive = convertBlkioEntry(cg.BlkioStats.IoMergedRecursive)
 	s.Blkio.IoTimeRecursive = convertBlkioEntry(cg.BlkioStats.IoTimeRecursive)
 	s.Blkio.SectorsRecursive = convertBlkioEntry(cg.BlkioStats.SectorsRecursive)
+	s.Blkio.PSI = convertPSI(cg.BlkioStats.PSI)
+
+	s.NetCls.Classid = cg.NetClsStats.Classid
 
 	s.Hugetlb = make(map[string]hugetlb)
 	for k, v := range cg.HugetlbStats {
 		s.Hugetlb[k] = convertHugtlb(v)
 	}
+	s.Errors = cg.Errors
 	return &s
 }
 
+func convertDevicesUpdate(u libcontainer.DevicesUpdate) *devicesUpdate {
+	d := &devicesUpdate{Added: u.Added, Removed: u.Removed}
+	if u.Err != nil {
+		d.Error = u.Err.Error()
+	}
+	return d
+}
+
+func convertPSI(p *cgroups.PSIStats) *psi {
+	if p == nil {
+		return nil
+	}
+	return &psi{
+		Some: psiData(p.Some),
+		Full: psiData(p.Full),
+	}
+}
+
 func convertHugtlb(c cgroups.HugetlbStats) hugetlb {
 	return hugetlb{
 		Usage:   c.Usage,
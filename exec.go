@@ -75,6 +75,10 @@ following will output a list of processes running in the container:
 			Name:  "no-new-privs",
 			Usage: "set the no new privileges value for the process",
 		},
+		cli.StringFlag{
+			Name:  "host-binary",
+			Usage: "path to a binary on the host to execute in place of the first argument, which is only used as argv[0]",
+		},
 		cli.StringSliceFlag{
 			Name:  "cap, c",
 			Value: &cli.StringSlice{},
@@ -111,7 +115,7 @@ func execProcess(context *cli.Context) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	if status == libcontainer.Stopped {
+	if status == libcontainer.Stopped || status == libcontainer.StoppedWithStragglers {
 		return -1, fmt.Errorf("cannot exec a container that has stopped")
 	}
 	path := context.String("process")
@@ -136,6 +140,7 @@ func execProcess(context *cli.Context) (int, error) {
 		detach:          detach,
 		pidFile:         context.String("pid-file"),
 		action:          CT_ACT_RUN,
+		hostBinary:      context.String("host-binary"),
 	}
 	return r.run(p)
 }
@@ -0,0 +1,294 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/vishvananda/netlink/nl"
+
+	"golang.org/x/sys/unix"
+)
+
+// The stdio of a process of a batch which are sent to the runner along with
+// its init pipe. These must be kept in sync with BATCH_STDIN, BATCH_STDOUT
+// and BATCH_STDERR in nsenter/nsexec.c.
+const (
+	batchStdin byte = 1 << iota
+	batchStdout
+	batchStderr
+)
+
+// checkBatchProcess returns an error if p cannot be run in a batch.
+func checkBatchProcess(p *Process) error {
+	switch {
+	case p.Terminal || p.ConsoleSocket != nil:
+		return fmt.Errorf("a process of a batch cannot have a console")
+	case p.HostBinary != "":
+		return fmt.Errorf("a process of a batch cannot have a host binary")
+	case len(p.ExtraFiles) > 0:
+		return fmt.Errorf("a process of a batch cannot be passed extra files")
+	case p.CgroupOnly || p.Namespaces != nil || !joinsUserNS(p):
+		return fmt.Errorf("a process of a batch joins every namespace of the container")
+	case p.IdleTimeout > 0:
+		return fmt.Errorf("a process of a batch cannot have an idle timeout")
+	case p.Rlimits != nil:
+		return fmt.Errorf("a process of a batch has the rlimits of the container")
+	}
+	return nil
+}
+
+func (c *linuxContainer) ExecBatch(ctx context.Context, processes []*Process) ([]ProcessResult, error) {
+	for _, p := range processes {
+		if err := checkBatchProcess(p); err != nil {
+			return nil, newGenericError(err, ConfigInvalid)
+		}
+	}
+	if len(processes) == 0 {
+		return nil, nil
+	}
+	conn, child, err := newBatchSockPair()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "creating batch socket")
+	}
+	defer conn.Close()
+	runner := &Process{
+		Cwd:        "/",
+		ExtraFiles: []*os.File{child},
+		batch:      true,
+	}
+	err = c.startBatchRunner(runner)
+	child.Close()
+	if err != nil {
+		return nil, err
+	}
+	pid, err := runner.Pid()
+	if err != nil {
+		return nil, err
+	}
+
+	// nsexec made the runner the leader of a session of its own, which the
+	// processes it forks are part of.
+	done := make(chan struct{})
+	killed := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			unix.Kill(-pid, unix.SIGKILL)
+			close(killed)
+		case <-done:
+		}
+	}()
+	var (
+		results []ProcessResult
+		berr    error
+	)
+	for _, p := range processes {
+		result, err := c.execBatchProcess(conn, p)
+		if err != nil {
+			berr = err
+			break
+		}
+		results = append(results, result)
+		if result.Failed() && !p.ContinueOnError {
+			break
+		}
+	}
+	close(done)
+	// The runner exits once we hang up.
+	conn.Close()
+	if _, err := runner.Wait(); err != nil && berr == nil {
+		berr = newSystemErrorWithCause(err, "waiting for batch runner")
+	}
+	select {
+	case <-killed:
+		return results, ctx.Err()
+	default:
+	}
+	return results, berr
+}
+
+// startBatchRunner starts the runner of a batch in the running container.
+func (c *linuxContainer) startBatchRunner(runner *Process) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container is not running"), ContainerNotRunning)
+	}
+	return c.start(runner, false)
+}
+
+// execBatchProcess has the runner at the other end of conn fork the next
+// process of its batch, initialises it like any process executed in the
+// container, and returns how it ended.
+func (c *linuxContainer) execBatchProcess(conn *os.File, p *Process) (ProcessResult, error) {
+	config, err := c.newInitConfig(p)
+	if err != nil {
+		return ProcessResult{}, err
+	}
+	parentPipe, childPipe, err := utils.NewSockPair("batch-init")
+	if err != nil {
+		return ProcessResult{}, newSystemErrorWithCause(err, "creating new init pipe")
+	}
+	defer parentPipe.Close()
+	stdio, err := newBatchStdio(p)
+	if err != nil {
+		childPipe.Close()
+		return ProcessResult{}, newSystemErrorWithCause(err, "setting up stdio")
+	}
+	err = sendBatchMsg(conn, stdio.mask, append([]*os.File{childPipe}, stdio.files...))
+	childPipe.Close()
+	stdio.closeChildEnds()
+	if err != nil {
+		stdio.closeParentEnds()
+		return ProcessResult{}, newSystemErrorWithCause(err, "sending batch process")
+	}
+	pid, err := recvBatchInt(conn)
+	if err != nil {
+		stdio.closeParentEnds()
+		return ProcessResult{}, newSystemErrorWithCause(err, "receiving pid of batch process")
+	}
+	ierr := utils.WriteJSON(parentPipe, config)
+	if ierr == nil {
+		ierr = parseSync(parentPipe, func(sync *syncT) error {
+			return newGenericError(fmt.Errorf("unexpected %s from batch process", sync.Type), SyncProtocolError)
+		})
+	}
+	if ierr != nil {
+		// The process exits without being executed.
+		unix.Shutdown(int(parentPipe.Fd()), unix.SHUT_RDWR)
+	}
+	status, err := recvBatchInt(conn)
+	if err != nil {
+		stdio.closeParentEnds()
+		return ProcessResult{}, newSystemErrorWithCause(err, "receiving status of batch process")
+	}
+	stdio.wait()
+	return ProcessResult{
+		Pid:      int(pid),
+		ExitCode: utils.ExitStatus(unix.WaitStatus(status)),
+		Err:      ierr,
+	}, nil
+}
+
+// batchStdio are the stdio of a process of a batch. Those which are not
+// files are copied from and to pipes, like os/exec does.
+type batchStdio struct {
+	mask byte
+	// files are sent to the runner, and childEnds closed once they are.
+	files     []*os.File
+	childEnds []*os.File
+	// parentEnds are the ends of the pipes the output is copied from.
+	parentEnds []*os.File
+	copies     sync.WaitGroup
+}
+
+func newBatchStdio(p *Process) (*batchStdio, error) {
+	s := &batchStdio{}
+	if p.Stdin != nil {
+		s.mask |= batchStdin
+		if f, ok := p.Stdin.(*os.File); ok {
+			s.files = append(s.files, f)
+		} else {
+			r, w, err := os.Pipe()
+			if err != nil {
+				return nil, err
+			}
+			s.files = append(s.files, r)
+			s.childEnds = append(s.childEnds, r)
+			// Like with os/exec, this is not waited for: it ends once the
+			// process exits and the pipe breaks.
+			go func() {
+				io.Copy(w, p.Stdin)
+				w.Close()
+			}()
+		}
+	}
+	for i, out := range []io.Writer{p.Stdout, p.Stderr} {
+		if out == nil {
+			continue
+		}
+		s.mask |= batchStdout << uint(i)
+		if f, ok := out.(*os.File); ok {
+			s.files = append(s.files, f)
+			continue
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			s.closeChildEnds()
+			s.closeParentEnds()
+			return nil, err
+		}
+		s.files = append(s.files, w)
+		s.childEnds = append(s.childEnds, w)
+		s.parentEnds = append(s.parentEnds, r)
+		s.copies.Add(1)
+		go func(out io.Writer) {
+			defer s.copies.Done()
+			io.Copy(out, r)
+		}(out)
+	}
+	return s, nil
+}
+
+func (s *batchStdio) closeChildEnds() {
+	for _, f := range s.childEnds {
+		f.Close()
+	}
+}
+
+func (s *batchStdio) closeParentEnds() {
+	for _, f := range s.parentEnds {
+		f.Close()
+	}
+}
+
+// wait waits for the output of the process to be copied.
+func (s *batchStdio) wait() {
+	s.copies.Wait()
+	s.closeParentEnds()
+}
+
+func newBatchSockPair() (parent *os.File, child *os.File, err error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return os.NewFile(uintptr(fds[1]), "batch-p"), os.NewFile(uintptr(fds[0]), "batch-c"), nil
+}
+
+// sendBatchMsg sends the runner of a batch its next process: the stdio set
+// in mask, and files, the init pipe of the process followed by those stdio.
+func sendBatchMsg(conn *os.File, mask byte, files []*os.File) error {
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	return unix.Sendmsg(int(conn.Fd()), []byte{mask}, unix.UnixRights(fds...), nil, 0)
+}
+
+// recvBatchInt receives the pid or the wait status of a process of a batch
+// from its runner. It returns io.EOF once the runner is gone.
+func recvBatchInt(conn *os.File) (int32, error) {
+	buf := make([]byte, 4)
+	n, _, err := unix.Recvfrom(int(conn.Fd()), buf, 0)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	if n != len(buf) {
+		return 0, fmt.Errorf("invalid batch message of %d bytes", n)
+	}
+	return int32(nl.NativeEndian().Uint32(buf)), nil
+}
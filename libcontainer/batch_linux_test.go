@@ -0,0 +1,80 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestBatchMsg(t *testing.T) {
+	parent, child, err := newBatchSockPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	err = sendBatchMsg(parent, batchStdout, []*os.File{w})
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := unix.Recvmsg(int(child.Fd()), buf, oob, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || buf[0] != batchStdout {
+		t.Fatalf("expected the stdio mask, got %v", buf[:n])
+	}
+	scms, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(scms) != 1 {
+		t.Fatalf("expected the pipe to be passed, got %v, %v", scms, err)
+	}
+	fds, err := unix.ParseUnixRights(&scms[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("expected the pipe to be passed, got %v, %v", fds, err)
+	}
+	unix.Close(fds[0])
+
+	if _, err := child.Write([]byte{42, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := recvBatchInt(parent); err != nil || v != int32(nl.NativeEndian().Uint32([]byte{42, 0, 0, 0})) {
+		t.Fatalf("unexpected %d, %v", v, err)
+	}
+	child.Close()
+	if _, err := recvBatchInt(parent); err != io.EOF {
+		t.Fatalf("expected EOF once the runner is gone, got %v", err)
+	}
+}
+
+func TestCheckBatchProcess(t *testing.T) {
+	hostUser := false
+	for _, p := range []*Process{
+		{Terminal: true},
+		{HostBinary: "/bin/true"},
+		{ExtraFiles: []*os.File{os.Stdin}},
+		{CgroupOnly: true},
+		{JoinUserNS: &hostUser},
+		{Rlimits: []configs.Rlimit{}},
+		{Namespaces: []configs.NamespaceType{configs.NEWNET}},
+	} {
+		if err := checkBatchProcess(p); err == nil {
+			t.Errorf("expected %+v to be refused", p)
+		}
+	}
+	if err := checkBatchProcess(&Process{Args: []string{"true"}}); err != nil {
+		t.Fatal(err)
+	}
+}
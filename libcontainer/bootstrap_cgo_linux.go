@@ -0,0 +1,8 @@
+// +build linux,cgo
+
+package libcontainer
+
+// nsexecBootstrap is true if the nsexec shim of the nsenter package, which
+// sets up the namespaces of container processes before the Go runtime
+// starts, can be built in.
+const nsexecBootstrap = true
@@ -0,0 +1,138 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall" // only for SysProcAttr, SysProcIDMap and Credential
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// checkBootstrap returns an error if config cannot be started without the
+// nsexec shim, which is the case when it joins an existing namespace: by the
+// time our code runs in the child, the Go runtime is multithreaded and setns
+// is refused for most namespaces. Only new namespaces can be created, through
+// the clone flags.
+func checkBootstrap(config *configs.Config) error {
+	if nsexecBootstrap {
+		return nil
+	}
+	for _, ns := range config.Namespaces {
+		if !ns.Joined() {
+			// Cloned with the container process, the cgroup namespace would
+			// be rooted at our cgroup rather than the one of the container.
+			if ns.Type == configs.NEWCGROUP {
+				return fmt.Errorf("creating a cgroup namespace requires the nsexec shim, which is not built without cgo")
+			}
+			continue
+		}
+		path := ns.Path
+		if ns.File != nil {
+			path = ns.File.Name()
+		}
+		return fmt.Errorf("joining the existing %s namespace %q requires the nsexec shim, which is not built without cgo", configs.NsName(ns.Type), path)
+	}
+	return nil
+}
+
+// checkSetnsBootstrap returns an error if processes cannot be executed in a
+// container with the namespaces in nsPaths, which they would have to join.
+func checkSetnsBootstrap(nsPaths map[configs.NamespaceType]string) error {
+	if nsexecBootstrap {
+		return nil
+	}
+	for _, t := range configs.NamespaceTypes() {
+		if path, ok := nsPaths[t]; ok {
+			return fmt.Errorf("executing a process in the container requires the nsexec shim to join its %s namespace %q, which is not built without cgo", configs.NsName(t), path)
+		}
+	}
+	return nil
+}
+
+// clonedProcess is a container process which is cloned into its new
+// namespaces by the Go runtime rather than by the nsexec stub, so it is the
+// container process from the start. It does what nsexec would for a config
+// accepted by checkBootstrap.
+type clonedProcess struct {
+	cmd         *exec.Cmd
+	state       *os.ProcessState
+	oomScoreAdj int
+}
+
+// newClonedProcess sets up cmd to be started in the new namespaces of config.
+func newClonedProcess(cmd *exec.Cmd, config *configs.Config) *clonedProcess {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	// Like under nsexec, the container process leads a session of its own.
+	attr.Setpgid = false
+	attr.Setsid = true
+	attr.Cloneflags = config.Namespaces.CloneFlags()
+	if config.Namespaces.Contains(configs.NEWUSER) {
+		attr.UidMappings = sysProcIDMaps(config.UidMappings)
+		attr.GidMappings = sysProcIDMaps(config.GidMappings)
+		attr.GidMappingsEnableSetgroups = !config.Rootless
+		attr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: config.Rootless}
+	}
+	return &clonedProcess{cmd: cmd, oomScoreAdj: config.OomScoreAdj}
+}
+
+func sysProcIDMaps(maps []configs.IDMap) []syscall.SysProcIDMap {
+	var ids []syscall.SysProcIDMap
+	for _, m := range maps {
+		ids = append(ids, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return ids
+}
+
+// start starts the container process and sets its oom_score_adj, which
+// nsexec would have set before the namespaces were created.
+func (p *clonedProcess) start() error {
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/proc/%d/oom_score_adj", p.cmd.Process.Pid)
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(p.oomScoreAdj)), 0); err != nil {
+		p.kill()
+		p.waitContainer()
+		return err
+	}
+	return nil
+}
+
+// execSetns does nothing, as there is no stub to report the container
+// process.
+func (p *clonedProcess) execSetns(pipe *os.File) error {
+	return nil
+}
+
+func (p *clonedProcess) pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *clonedProcess) waitContainer() (*os.ProcessState, error) {
+	if p.state != nil {
+		return p.state, nil
+	}
+	err := p.cmd.Wait()
+	p.state = p.cmd.ProcessState
+	return p.state, err
+}
+
+func (p *clonedProcess) kill() error {
+	if p.cmd.Process == nil || p.state != nil {
+		return nil
+	}
+	return p.cmd.Process.Kill()
+}
+
+// abort kills the container process, which is its own stub.
+func (p *clonedProcess) abort() {
+	p.cmd.Process.Kill()
+}
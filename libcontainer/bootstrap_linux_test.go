@@ -0,0 +1,83 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestCheckBootstrap(t *testing.T) {
+	config := &configs.Config{Namespaces: configs.Namespaces{
+		{Type: configs.NEWNS},
+		{Type: configs.NEWNET, Path: "/var/run/netns/test"},
+	}}
+	err := checkBootstrap(config)
+	if nsexecBootstrap {
+		if err != nil {
+			t.Fatalf("expected any namespace to be joined with nsexec, got %v", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), `net namespace "/var/run/netns/test"`) {
+		t.Fatalf("expected joining the net namespace to be refused, got %v", err)
+	}
+	config.Namespaces = config.Namespaces[:1]
+	if err := checkBootstrap(config); err != nil {
+		t.Fatalf("expected new namespaces to be accepted, got %v", err)
+	}
+	config.Namespaces = append(config.Namespaces, configs.Namespace{Type: configs.NEWCGROUP})
+	if err := checkBootstrap(config); err == nil {
+		t.Fatal("expected creating a cgroup namespace to be refused")
+	}
+}
+
+func TestClonedProcess(t *testing.T) {
+	data, err := ioutil.ReadFile("/proc/self/oom_score_adj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adj, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("/bin/sh", "-c", "cat /proc/self/oom_score_adj; exit 3")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	p := newClonedProcess(cmd, &configs.Config{OomScoreAdj: adj})
+	if !cmd.SysProcAttr.Setsid {
+		t.Fatal("expected the container process to lead a session")
+	}
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.execSetns(nil); err != nil {
+		t.Fatal(err)
+	}
+	if p.pid() != cmd.Process.Pid {
+		t.Fatalf("expected pid %d, got %d", cmd.Process.Pid, p.pid())
+	}
+	state, err := p.waitContainer()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("expected an exit error, got %v", err)
+	}
+	if status := state.Sys().(syscall.WaitStatus); status.ExitStatus() != 3 {
+		t.Fatalf("expected exit status 3, got %d", status.ExitStatus())
+	}
+	if strings.TrimSpace(out.String()) != strconv.Itoa(adj) {
+		t.Fatalf("expected oom_score_adj %d, got %q", adj, out.String())
+	}
+	if again, _ := p.waitContainer(); again != state {
+		t.Fatal("expected the state to be kept once the process was waited for")
+	}
+	if err := p.kill(); err != nil {
+		t.Fatalf("expected killing an exited process to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+// +build linux,!cgo
+
+package libcontainer
+
+// nsexecBootstrap is false as the nsexec shim needs cgo. Container processes
+// are then cloned into new namespaces by the Go runtime instead, and configs
+// which join existing namespaces are refused.
+const nsexecBootstrap = false
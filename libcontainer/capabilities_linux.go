@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/syndtr/gocapability/capability"
 )
 
@@ -31,6 +32,30 @@ func init() {
 	}
 }
 
+// ambientSupported reports whether the kernel supports ambient capabilities.
+var ambientSupported = func() bool {
+	return system.GetFeatures().AmbientCapabilities
+}
+
+// checkAmbient returns the capabilities a process can run with. Setting
+// ambient capabilities fails on kernels before 4.3, so they are refused
+// there, or dropped with a warning if allowFallback is set.
+func checkAmbient(caps *configs.Capabilities, allowFallback bool) (*configs.Capabilities, *configs.Warning, error) {
+	if caps == nil || len(caps.Ambient) == 0 || ambientSupported() {
+		return caps, nil, nil
+	}
+	if !allowFallback {
+		return nil, nil, newGenericError(fmt.Errorf("ambient capabilities require kernel >= 4.3"), ConfigInvalid)
+	}
+	dropped := *caps
+	dropped.Ambient = nil
+	return &dropped, &configs.Warning{
+		Code:      configs.WarnAmbientCapsDropped,
+		FieldPath: "capabilities.ambient",
+		Message:   fmt.Sprintf("ambient capabilities are not supported by the kernel, dropping %s", strings.Join(caps.Ambient, ", ")),
+	}, nil
+}
+
 func newContainerCapList(capConfig *configs.Capabilities) (*containerCapabilities, error) {
 	bounding := []capability.Cap{}
 	for _, c := range capConfig.Bounding {
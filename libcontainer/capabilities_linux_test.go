@@ -0,0 +1,63 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestCheckAmbient(t *testing.T) {
+	defer func(f func() bool) { ambientSupported = f }(ambientSupported)
+	ambientSupported = func() bool { return false }
+	caps := &configs.Capabilities{
+		Bounding: []string{"CAP_NET_BIND_SERVICE"},
+		Ambient:  []string{"CAP_NET_BIND_SERVICE"},
+	}
+
+	_, _, err := checkAmbient(caps, false)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a ConfigInvalid error, got %v", err)
+	}
+	got, w, err := checkAmbient(caps, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w == nil || w.Code != configs.WarnAmbientCapsDropped {
+		t.Fatalf("expected a warning about the dropped capabilities, got %v", w)
+	}
+	if len(got.Ambient) != 0 || len(got.Bounding) != 1 {
+		t.Fatalf("expected only the ambient set to be dropped, got %+v", got)
+	}
+	if len(caps.Ambient) != 1 {
+		t.Fatal("expected the capabilities of the process not to be changed")
+	}
+
+	ambientSupported = func() bool { return true }
+	if got, w, err := checkAmbient(caps, false); err != nil || got != caps || w != nil {
+		t.Fatalf("expected the capabilities to be kept, got %+v, %v, %v", got, w, err)
+	}
+}
+
+func TestNewInitConfigDropsAmbient(t *testing.T) {
+	defer func(f func() bool) { ambientSupported = f }(ambientSupported)
+	ambientSupported = func() bool { return false }
+	c := &linuxContainer{id: "test", config: &configs.Config{
+		Capabilities:         &configs.Capabilities{Ambient: []string{"CAP_NET_BIND_SERVICE"}},
+		AllowAmbientFallback: true,
+	}}
+	// An exec'd process with no capabilities of its own runs with those of
+	// the container.
+	cfg, err := c.newInitConfig(&Process{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Capabilities == nil || len(cfg.Capabilities.Ambient) != 0 {
+		t.Fatalf("expected the ambient set of the container to be dropped, got %+v", cfg.Capabilities)
+	}
+	c.config.AllowAmbientFallback = false
+	if _, err := c.newInitConfig(&Process{Capabilities: &configs.Capabilities{Ambient: []string{"CAP_CHOWN"}}}); err == nil {
+		t.Fatal("expected the ambient set of the process to be refused")
+	}
+}
@@ -0,0 +1,250 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+// CgroupEventType is the type of a CgroupEvent.
+type CgroupEventType string
+
+const (
+	// CgroupPopulated reports whether any process is left in the cgroups of
+	// the container.
+	CgroupPopulated CgroupEventType = "populated"
+	// CgroupFrozen reports whether the cgroups of the container are frozen.
+	CgroupFrozen CgroupEventType = "frozen"
+)
+
+// CgroupEvent is a transition of the cgroups of a container.
+type CgroupEvent struct {
+	Type  CgroupEventType
+	Value bool
+}
+
+const (
+	// cgroupEventsBuffer is how many events are kept for a slow receiver of
+	// NotifyCgroupEvents on cgroup v1 before they are dropped.
+	cgroupEventsBuffer = 16
+	// freezerPollInterval is how often freezer.state is read on cgroup v1
+	// while the container is being frozen or thawed.
+	freezerPollInterval = 10 * time.Millisecond
+)
+
+func (c *linuxContainer) NotifyCgroupEvents() (<-chan CgroupEvent, error) {
+	if c.config.Rootless {
+		return nil, fmt.Errorf("cannot get cgroup events from rootless container")
+	}
+	// The first receiver after the container was adopted by LoadAll gets
+	// what changed since the receivers of the previous caller were told.
+	c.eventsMu.Lock()
+	cursor := c.eventsCursor
+	c.eventsCursor = nil
+	if c.eventsStop == nil {
+		c.eventsStop = make(chan struct{})
+	}
+	stop := c.eventsStop
+	c.eventsMu.Unlock()
+	if path := cgroupEventsPath(c.cgroupManager.GetPaths()); path != "" {
+		var since *cgroupEvents
+		if cursor != nil {
+			since = &cgroupEvents{populated: cursor.Populated, frozen: cursor.Frozen}
+		}
+		return watchCgroupEvents(path, since, stop)
+	}
+	// Without cgroup.events, the only transitions known are those of the
+	// freezes we make ourselves.
+	ch := make(chan CgroupEvent, cgroupEventsBuffer)
+	if cursor != nil {
+		if paused, err := c.isPaused(); err == nil && paused != cursor.Frozen {
+			ch <- CgroupEvent{Type: CgroupFrozen, Value: paused}
+		}
+	}
+	c.eventsMu.Lock()
+	c.eventSubs = append(c.eventSubs, ch)
+	c.eventsMu.Unlock()
+	return ch, nil
+}
+
+// sendCgroupEvent passes ev to the NotifyCgroupEvents receivers on cgroup v1.
+func (c *linuxContainer) sendCgroupEvent(ev CgroupEvent) {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+	for _, ch := range c.eventSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// closeCgroupEvents closes the NotifyCgroupEvents channels, stopping the
+// watchers of cgroup.events on cgroup v2.
+func (c *linuxContainer) closeCgroupEvents() {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+	for _, ch := range c.eventSubs {
+		close(ch)
+	}
+	c.eventSubs = nil
+	if c.eventsStop != nil {
+		close(c.eventsStop)
+		c.eventsStop = nil
+	}
+}
+
+// freeze sets the freezer state of the cgroups of the container and passes
+// the transitions to the NotifyCgroupEvents receivers on cgroup v1, where
+// freezer.state is polled until the freezer is done.
+func (c *linuxContainer) freeze(state configs.FreezerState) error {
+	paths := c.cgroupManager.GetPaths()
+	fcg := paths["freezer"]
+	if fcg == "" || cgroupEventsPath(paths) != "" {
+		if err := c.cgroupManager.Freeze(state, c.freezeTimeout); err != nil {
+			return err
+		}
+		c.sendCgroupEvent(CgroupEvent{Type: CgroupFrozen, Value: state == configs.Frozen})
+		return nil
+	}
+	var last bool
+	update := func() {
+		if frozen, err := freezerFrozen(fcg); err == nil && frozen != last {
+			last = frozen
+			c.sendCgroupEvent(CgroupEvent{Type: CgroupFrozen, Value: frozen})
+		}
+	}
+	last, _ = freezerFrozen(fcg)
+	done, polled := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(polled)
+		ticker := time.NewTicker(freezerPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+	err := c.cgroupManager.Freeze(state, c.freezeTimeout)
+	close(done)
+	<-polled
+	update()
+	return err
+}
+
+// freezerFrozen returns true if the freezer.state of the cgroup v1 freezer
+// at fcg is FROZEN.
+func freezerFrozen(fcg string) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(fcg, "freezer.state"))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(bytes.TrimSpace(data), []byte("FROZEN")), nil
+}
+
+// watchCgroupEvents sends a CgroupEvent for every change of the cgroup.events
+// file at path, until the cgroup is removed or stop is closed, starting with
+// those since the state since if it is set.
+func watchCgroupEvents(path string, since *cgroupEvents, stop <-chan struct{}) (<-chan CgroupEvent, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := unix.InotifyAddWatch(fd, path, unix.IN_MODIFY); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	last, err := readCgroupEvents(path)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// The read end of wake becomes readable once stop is closed, which wakes
+	// up the watcher polling the inotify fd.
+	var wake [2]int
+	if err := unix.Pipe2(wake[:], unix.O_CLOEXEC); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	var pending []CgroupEvent
+	if since != nil {
+		pending = cgroupEventsChanges(*since, last)
+	}
+	ch := make(chan CgroupEvent)
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-exited:
+		}
+		unix.Close(wake[1])
+	}()
+	go func() {
+		defer close(ch)
+		defer close(exited)
+		defer unix.Close(wake[0])
+		defer unix.Close(fd)
+		send := func(events []CgroupEvent) bool {
+			for _, ev := range events {
+				select {
+				case ch <- ev:
+				case <-stop:
+					return false
+				}
+			}
+			return true
+		}
+		if !send(pending) {
+			return
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}, {Fd: int32(wake[0]), Events: unix.POLLIN}}
+		buf := make([]byte, unix.SizeofInotifyEvent+unix.NAME_MAX+1)
+		for {
+			if _, err := unix.Poll(fds, -1); err != nil {
+				if err == unix.EINTR {
+					continue
+				}
+				return
+			}
+			if fds[1].Revents != 0 {
+				return
+			}
+			if _, err := unix.Read(fd, buf); err != nil && err != unix.EINTR {
+				return
+			}
+			events, err := readCgroupEvents(path)
+			if err != nil {
+				return
+			}
+			if !send(cgroupEventsChanges(last, events)) {
+				return
+			}
+			last = events
+		}
+	}()
+	return ch, nil
+}
+
+// cgroupEventsChanges returns the events of the changes from old to new.
+func cgroupEventsChanges(old, new cgroupEvents) []CgroupEvent {
+	var changes []CgroupEvent
+	if new.frozen != old.frozen {
+		changes = append(changes, CgroupEvent{Type: CgroupFrozen, Value: new.frozen})
+	}
+	if new.populated != old.populated {
+		changes = append(changes, CgroupEvent{Type: CgroupPopulated, Value: new.populated})
+	}
+	return changes
+}
@@ -0,0 +1,160 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func receiveCgroupEvent(t *testing.T, ch <-chan CgroupEvent) (CgroupEvent, bool) {
+	select {
+	case ev, ok := <-ch:
+		return ev, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("no cgroup event received")
+	}
+	return CgroupEvent{}, false
+}
+
+func TestWatchCgroupEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cgroup.events")
+	if err := ioutil.WriteFile(path, []byte("populated 1\nfrozen 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the file in a single write, as truncating it would let the
+	// watcher see it empty.
+	write := func(content string) {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt([]byte(content), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ch, err := watchCgroupEvents(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("populated 1\nfrozen 1\n")
+	if ev, _ := receiveCgroupEvent(t, ch); ev != (CgroupEvent{Type: CgroupFrozen, Value: true}) {
+		t.Fatalf("expected the cgroup to be frozen, got %+v", ev)
+	}
+	write("populated 0\nfrozen 1\n")
+	if ev, _ := receiveCgroupEvent(t, ch); ev != (CgroupEvent{Type: CgroupPopulated, Value: false}) {
+		t.Fatalf("expected the cgroup to be empty, got %+v", ev)
+	}
+	os.Remove(path)
+	if ev, ok := receiveCgroupEvent(t, ch); ok {
+		t.Fatalf("expected the channel to be closed, got %+v", ev)
+	}
+}
+
+func TestWatchCgroupEventsStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cgroup.events")
+	if err := ioutil.WriteFile(path, []byte("populated 1\nfrozen 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, since := range []*cgroupEvents{nil, {populated: false}} {
+		// The watcher stops whether it waits for a change or for its
+		// receiver.
+		stop := make(chan struct{})
+		ch, err := watchCgroupEvents(path, since, stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		close(stop)
+		for i := 0; ; i++ {
+			if _, ok := receiveCgroupEvent(t, ch); !ok {
+				break
+			}
+			if i == 1 {
+				t.Fatal("expected the channel to be closed")
+			}
+		}
+	}
+}
+
+// revertingFreezerManager is a mockCgroupManager with a cgroup v1 freezer,
+// which freezes and thaws the container back, as when the freeze times out.
+type revertingFreezerManager struct {
+	mockCgroupManager
+	t *testing.T
+}
+
+func (m *revertingFreezerManager) Freeze(state configs.FreezerState, timeout time.Duration) error {
+	path := filepath.Join(m.paths["freezer"], "freezer.state")
+	if err := ioutil.WriteFile(path, []byte("FROZEN\n"), 0644); err != nil {
+		m.t.Fatal(err)
+	}
+	time.Sleep(10 * freezerPollInterval)
+	if err := ioutil.WriteFile(path, []byte("THAWED\n"), 0644); err != nil {
+		m.t.Fatal(err)
+	}
+	return errors.New("timed out")
+}
+
+func TestCgroupEventsPollFreezer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "freezer.state"), []byte("THAWED\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &revertingFreezerManager{t: t}
+	m.paths = map[string]string{"freezer": dir}
+	c := &linuxContainer{config: &configs.Config{}, cgroupManager: m}
+	ch, err := c.NotifyCgroupEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.freeze(configs.Frozen); err == nil {
+		t.Fatal("expected the freeze to fail")
+	}
+	for _, frozen := range []bool{true, false} {
+		if ev, _ := receiveCgroupEvent(t, ch); ev != (CgroupEvent{Type: CgroupFrozen, Value: frozen}) {
+			t.Fatalf("expected frozen %v, got %+v", frozen, ev)
+		}
+	}
+	c.closeCgroupEvents()
+}
+
+func TestCgroupEventsWithoutCgroupEventsFile(t *testing.T) {
+	c := &linuxContainer{
+		config:        &configs.Config{},
+		cgroupManager: &mockCgroupManager{},
+	}
+	ch, err := c.NotifyCgroupEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.sendCgroupEvent(CgroupEvent{Type: CgroupFrozen, Value: true})
+	if ev, _ := receiveCgroupEvent(t, ch); ev != (CgroupEvent{Type: CgroupFrozen, Value: true}) {
+		t.Fatalf("expected the cgroup to be frozen, got %+v", ev)
+	}
+	c.closeCgroupEvents()
+	if _, ok := receiveCgroupEvent(t, ch); ok {
+		t.Fatal("expected the channel to be closed")
+	}
+}
@@ -0,0 +1,70 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// cgroupFileRegex matches the names of the files of a cgroup controller,
+// such as "memory.limit_in_bytes".
+var cgroupFileRegex = regexp.MustCompile(`^[a-z0-9_]+\.[a-z0-9_.]+$`)
+
+// cgroupFilePath returns the path of the file name of the given controller
+// in the container's cgroups. Only the controller's own files can be
+// accessed, i.e. those named "<controller>.<knob>".
+func (c *linuxContainer) cgroupFilePath(controller, name string) (string, error) {
+	if !cgroupFileRegex.MatchString(name) || !strings.HasPrefix(name, controller+".") {
+		return "", newGenericError(fmt.Errorf("invalid cgroup file %q for controller %q", name, controller), ConfigInvalid)
+	}
+	dir, ok := c.cgroupManager.GetPaths()[controller]
+	if !ok || dir == "" {
+		return "", newGenericError(fmt.Errorf("container has no %s cgroup", controller), ConfigInvalid)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func (c *linuxContainer) ReadCgroupFile(controller, name string) ([]byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	path, err := c.cgroupFilePath(controller, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, newSystemErrorWithCausef(err, "reading cgroup file %s", path)
+	}
+	return data, nil
+}
+
+func (c *linuxContainer) WriteCgroupFile(controller, name, value string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	path, err := c.cgroupFilePath(controller, name)
+	if err != nil {
+		return err
+	}
+	// The file must already exist, cgroup files are never created.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return newSystemErrorWithCausef(err, "opening cgroup file %s", path)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(value); err != nil {
+		return newSystemErrorWithCausef(err, "writing cgroup file %s", path)
+	}
+	logrus.WithFields(logrus.Fields{
+		"id":    c.id,
+		"path":  path,
+		"value": value,
+	}).Info("cgroup file written")
+	return nil
+}
@@ -0,0 +1,65 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestCgroupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testcgroupfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	limit := filepath.Join(dir, "memory.limit_in_bytes")
+	if err := ioutil.WriteFile(limit, []byte("1024\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	container := &linuxContainer{
+		id:            "myid",
+		config:        &configs.Config{},
+		cgroupManager: &mockCgroupManager{paths: map[string]string{"memory": dir}},
+	}
+
+	data, err := container.ReadCgroupFile("memory", "memory.limit_in_bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "1024\n" {
+		t.Fatalf("expected %q but received %q", "1024\n", data)
+	}
+	if err := container.WriteCgroupFile("memory", "memory.limit_in_bytes", "2048"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(limit); string(data) != "2048" {
+		t.Fatalf("expected %q but received %q", "2048", data)
+	}
+
+	for _, test := range []struct {
+		controller string
+		name       string
+	}{
+		{"memory", "../memory.limit_in_bytes"},
+		{"memory", "memory/limit_in_bytes"},
+		{"memory", "cgroup.procs"},
+		{"memory", "tasks"},
+		{"cpu", "cpu.shares"},
+	} {
+		err := container.WriteCgroupFile(test.controller, test.name, "0")
+		if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+			t.Errorf("%s %s: expected a ConfigInvalid error but received %v", test.controller, test.name, err)
+		}
+	}
+	if err := container.WriteCgroupFile("memory", "memory.missing", "0"); err == nil {
+		t.Error("expected writing a missing cgroup file to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "memory.missing")); !os.IsNotExist(err) {
+		t.Error("expected a missing cgroup file not to be created")
+	}
+}
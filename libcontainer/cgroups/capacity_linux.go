@@ -0,0 +1,111 @@
+// +build linux
+
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// defaultCpuPeriod is the CFS period of the kernel, which a CpuPercent is
+// resolved against if the resources do not set one.
+const defaultCpuPeriod = 100000
+
+var (
+	meminfoPath    = "/proc/meminfo"
+	onlineCPUsPath = "/sys/devices/system/cpu/online"
+)
+
+// ResolvePercentages sets the Memory and CpuQuota of r from its
+// MemoryPercent and CpuPercent, which are relative to the total memory and
+// the online CPUs of the host. It is called by the managers when applying
+// the resources, so that the limits follow the host the container runs on.
+func ResolvePercentages(r *configs.Resources) error {
+	if r == nil {
+		return nil
+	}
+	if r.MemoryPercent > 0 {
+		total, err := HostMemTotal()
+		if err != nil {
+			return fmt.Errorf("resolving memory percentage: %v", err)
+		}
+		r.Memory = int64(float64(total) * r.MemoryPercent / 100)
+	}
+	if r.CpuPercent > 0 {
+		cpus, err := OnlineCPUs()
+		if err != nil {
+			return fmt.Errorf("resolving cpu percentage: %v", err)
+		}
+		if r.CpuPeriod == 0 {
+			r.CpuPeriod = defaultCpuPeriod
+		}
+		r.CpuQuota = int64(float64(r.CpuPeriod) * float64(cpus) * r.CpuPercent / 100)
+	}
+	return nil
+}
+
+// HostMemTotal returns the total memory of the host in bytes.
+func HostMemTotal() (uint64, error) {
+	f, err := os.Open(meminfoPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseMemTotal(f)
+}
+
+func parseMemTotal(r io.Reader) (uint64, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 || fields[0] != "MemTotal:" || fields[2] != "kB" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no MemTotal in %s", meminfoPath)
+}
+
+// OnlineCPUs returns the number of online CPUs of the host, regardless of
+// the affinity of the calling process.
+func OnlineCPUs() (int, error) {
+	data, err := ioutil.ReadFile(onlineCPUsPath)
+	if err != nil {
+		return 0, err
+	}
+	return parseCPUList(strings.TrimSpace(string(data)))
+}
+
+// parseCPUList returns the number of CPUs in a list like "0-3,6".
+func parseCPUList(list string) (int, error) {
+	n := 0
+	for _, r := range strings.Split(list, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu list %q", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return 0, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		n += last - first + 1
+	}
+	return n, nil
+}
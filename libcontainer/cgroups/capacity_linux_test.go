@@ -0,0 +1,78 @@
+// +build linux
+
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for list, want := range map[string]int{
+		"0":       1,
+		"0-7":     8,
+		"0-3,6":   5,
+		"0,2,4-5": 4,
+	} {
+		got, err := parseCPUList(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected %d cpus in %q, got %d", want, list, got)
+		}
+	}
+	for _, list := range []string{"", "a", "3-1"} {
+		if _, err := parseCPUList(list); err == nil {
+			t.Errorf("expected %q to be rejected", list)
+		}
+	}
+}
+
+func TestParseMemTotal(t *testing.T) {
+	meminfo := "MemTotal:        8000000 kB\nMemFree:         1000 kB\n"
+	total, err := parseMemTotal(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 8000000*1024 {
+		t.Fatalf("unexpected total %d", total)
+	}
+	if _, err := parseMemTotal(strings.NewReader("MemFree: 1000 kB\n")); err == nil {
+		t.Fatal("expected an error without MemTotal")
+	}
+}
+
+func TestResolvePercentages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(m, c string) { meminfoPath, onlineCPUsPath = m, c }(meminfoPath, onlineCPUsPath)
+	meminfoPath = filepath.Join(dir, "meminfo")
+	onlineCPUsPath = filepath.Join(dir, "online")
+	if err := ioutil.WriteFile(meminfoPath, []byte("MemTotal: 1000 kB\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(onlineCPUsPath, []byte("0-7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &configs.Resources{MemoryPercent: 25, CpuPercent: 18.75}
+	if err := ResolvePercentages(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Memory != 256000 {
+		t.Errorf("expected a quarter of the memory, got %d", r.Memory)
+	}
+	// 18.75% of 8 cpus is 1.5 cpus.
+	if r.CpuPeriod != defaultCpuPeriod || r.CpuQuota != 150000 {
+		t.Errorf("expected 1.5 cpus, got a quota of %d in %d", r.CpuQuota, r.CpuPeriod)
+	}
+}
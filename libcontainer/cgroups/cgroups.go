@@ -4,6 +4,7 @@ package cgroups
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 )
@@ -21,8 +22,10 @@ type Manager interface {
 	// Returns statistics for the cgroup set
 	GetStats() (*Stats, error)
 
-	// Toggles the freezer cgroup according with specified state
-	Freeze(state configs.FreezerState) error
+	// Toggles the freezer cgroup according with specified state. If timeout
+	// is not zero and the state is not reached in time, the cgroup is thawed
+	// and an error is returned.
+	Freeze(state configs.FreezerState, timeout time.Duration) error
 
 	// Destroys the cgroup set
 	Destroy() error
@@ -41,6 +44,13 @@ type Manager interface {
 	Set(container *configs.Config) error
 }
 
+// Warner is implemented by the managers which can apply a configuration
+// only partially without failing. TakeWarnings returns what was left out
+// since it was last called.
+type Warner interface {
+	TakeWarnings() []configs.Warning
+}
+
 type NotFoundError struct {
 	Subsystem string
 }
@@ -0,0 +1,229 @@
+// +build linux
+
+// Package devices emulates the device access control of the devices
+// controller of cgroup v1, which cgroup v2 leaves to eBPF programs.
+package devices
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// Rules returns the device rules of r in the order they apply, turning
+// AllowAllDevices, AllowedDevices and DeniedDevices into rules if there are
+// no Devices.
+func Rules(r *configs.Resources) []*configs.Device {
+	if len(r.Devices) > 0 {
+		return r.Devices
+	}
+	var rules []*configs.Device
+	rule := func(dev *configs.Device, allow bool) {
+		d := *dev
+		d.Allow = allow
+		rules = append(rules, &d)
+	}
+	if r.AllowAllDevices != nil {
+		if !*r.AllowAllDevices {
+			rule(all, false)
+			for _, dev := range r.AllowedDevices {
+				rule(dev, true)
+			}
+			return rules
+		}
+		rule(all, true)
+	}
+	for _, dev := range r.DeniedDevices {
+		rule(dev, false)
+	}
+	return rules
+}
+
+// all is the rule for all devices, which resets the access.
+var all = &configs.Device{Type: 'a', Major: configs.Wildcard, Minor: configs.Wildcard, Permissions: "rwm"}
+
+type key struct {
+	typ          rune
+	major, minor int64
+}
+
+// perms is a set of the r, w and m permissions.
+type perms uint8
+
+func parsePerms(s string) (perms, error) {
+	var p perms
+	for _, c := range s {
+		i := strings.IndexRune("rwm", c)
+		if i < 0 {
+			return 0, fmt.Errorf("invalid device permissions %q", s)
+		}
+		p |= 1 << uint(i)
+	}
+	return p, nil
+}
+
+func (p perms) String() string {
+	var s []byte
+	for i, c := range []byte("rwm") {
+		if p&(1<<uint(i)) != 0 {
+			s = append(s, c)
+		}
+	}
+	return string(s)
+}
+
+// Emulator is the device access of a cgroup as the devices controller of
+// cgroup v1 keeps it: a default, and the exceptions to it.
+type Emulator struct {
+	defaultAllow bool
+	exceptions   map[key]perms
+}
+
+// NewEmulator returns the device access of a cgroup which was never
+// restricted, which allows every device.
+func NewEmulator() *Emulator {
+	return &Emulator{defaultAllow: true, exceptions: make(map[key]perms)}
+}
+
+// ParseList returns the device access listed in a devices.list file. Only
+// the exceptions to denying by default are listed, so when allowing by
+// default, as a cgroup which was never restricted does, the exceptions are
+// unknown and left out.
+func ParseList(data []byte) (*Emulator, error) {
+	e := &Emulator{exceptions: make(map[key]perms)}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		numbers := strings.SplitN(fields[1%len(fields)], ":", 2)
+		if len(fields) != 3 || len(fields[0]) != 1 || len(numbers) != 2 {
+			return nil, fmt.Errorf("invalid devices.list entry %q", line)
+		}
+		if fields[0] == "a" {
+			return NewEmulator(), nil
+		}
+		k := key{typ: rune(fields[0][0])}
+		var err error
+		if k.major, err = parseNumber(numbers[0]); err != nil {
+			return nil, err
+		}
+		if k.minor, err = parseNumber(numbers[1]); err != nil {
+			return nil, err
+		}
+		p, err := parsePerms(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		e.exceptions[k] |= p
+	}
+	return e, nil
+}
+
+func parseNumber(s string) (int64, error) {
+	if s == "*" {
+		return configs.Wildcard, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// DefaultAllow returns true if the devices without exceptions are allowed.
+func (e *Emulator) DefaultAllow() bool {
+	return e.defaultAllow
+}
+
+// Exceptions returns the exceptions to the default as rules, in order of
+// type, major and minor numbers. Those of a cgroup allowing by default
+// deny access, the others allow it.
+func (e *Emulator) Exceptions() []*configs.Device {
+	var rules []*configs.Device
+	for _, k := range sortedKeys(e.exceptions) {
+		rules = append(rules, exception(k, e.exceptions[k], !e.defaultAllow))
+	}
+	return rules
+}
+
+func exception(k key, p perms, allow bool) *configs.Device {
+	return &configs.Device{Type: k.typ, Major: k.major, Minor: k.minor, Permissions: p.String(), Allow: allow}
+}
+
+// Clone returns a copy of e.
+func (e *Emulator) Clone() *Emulator {
+	c := &Emulator{defaultAllow: e.defaultAllow, exceptions: make(map[key]perms, len(e.exceptions))}
+	for k, v := range e.exceptions {
+		c.exceptions[k] = v
+	}
+	return c
+}
+
+// Apply applies dev as the kernel does when it is written to devices.allow
+// or devices.deny: a rule for all devices resets the access, any other adds
+// to or removes from the exception for the same devices. Rules with invalid
+// permissions, which the kernel refuses, are ignored.
+func (e *Emulator) Apply(dev *configs.Device) {
+	if dev.Type == 'a' {
+		e.defaultAllow = dev.Allow
+		e.exceptions = make(map[key]perms)
+		return
+	}
+	p, err := parsePerms(dev.Permissions)
+	if err != nil {
+		return
+	}
+	k := key{typ: dev.Type, major: dev.Major, minor: dev.Minor}
+	if dev.Allow == e.defaultAllow {
+		if e.exceptions[k] &^= p; e.exceptions[k] == 0 {
+			delete(e.exceptions, k)
+		}
+		return
+	}
+	e.exceptions[k] |= p
+}
+
+// Transition returns the rules moving e to target, which denies by default.
+// Access is revoked before any is granted, so that every step allows no
+// more than e or target does.
+func (e *Emulator) Transition(target *Emulator) []*configs.Device {
+	var rules []*configs.Device
+	if e.defaultAllow {
+		rules = append(rules, all)
+		return append(rules, target.Exceptions()...)
+	}
+	for _, k := range sortedKeys(e.exceptions) {
+		if revoked := e.exceptions[k] &^ target.exceptions[k]; revoked != 0 {
+			rules = append(rules, exception(k, revoked, false))
+		}
+	}
+	for _, k := range sortedKeys(target.exceptions) {
+		if granted := target.exceptions[k] &^ e.exceptions[k]; granted != 0 {
+			rules = append(rules, exception(k, granted, true))
+		}
+	}
+	return rules
+}
+
+func sortedKeys(exceptions map[key]perms) []key {
+	keys := make([]key, 0, len(exceptions))
+	for k := range exceptions {
+		keys = append(keys, k)
+	}
+	sort.Sort(keySlice(keys))
+	return keys
+}
+
+type keySlice []key
+
+func (k keySlice) Len() int      { return len(k) }
+func (k keySlice) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k keySlice) Less(i, j int) bool {
+	if k[i].typ != k[j].typ {
+		return k[i].typ < k[j].typ
+	}
+	if k[i].major != k[j].major {
+		return k[i].major < k[j].major
+	}
+	return k[i].minor < k[j].minor
+}
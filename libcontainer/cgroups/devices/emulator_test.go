@@ -0,0 +1,57 @@
+// +build linux
+
+package devices
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestTransition(t *testing.T) {
+	current := &Emulator{exceptions: map[key]perms{
+		{'c', 1, 3}:    7,
+		{'c', 1, 5}:    7,
+		{'c', 10, 200}: 3,
+	}}
+	target := current.Clone()
+	for _, dev := range []*configs.Device{
+		{Type: 'a', Major: configs.Wildcard, Minor: configs.Wildcard, Permissions: "rwm"},
+		{Type: 'c', Major: 1, Minor: 3, Permissions: "rwm", Allow: true},
+		{Type: 'c', Major: 1, Minor: 5, Permissions: "r", Allow: true},
+		{Type: 'c', Major: 4, Minor: 1, Permissions: "rwm", Allow: true},
+	} {
+		target.Apply(dev)
+	}
+
+	expected := []string{"deny c 1:5 wm", "deny c 10:200 rw", "allow c 4:1 rwm"}
+	writes := current.Transition(target)
+	if len(writes) != len(expected) {
+		t.Fatalf("expected %d writes, got %d", len(expected), len(writes))
+	}
+	state := current.Clone()
+	for i, dev := range writes {
+		file := "deny"
+		if dev.Allow {
+			file = "allow"
+		}
+		if got := file + " " + dev.CgroupString(); got != expected[i] {
+			t.Fatalf("expected write %d to be %q, got %q", i, expected[i], got)
+		}
+		state.Apply(dev)
+		// No step may allow more than both the old and the new rules.
+		for key, perms := range state.exceptions {
+			if perms&^current.exceptions[key] != 0 && perms&^target.exceptions[key] != 0 {
+				t.Fatalf("write %d broadened access to %v", i, key)
+			}
+		}
+	}
+	if state.defaultAllow || len(state.exceptions) != len(target.exceptions) {
+		t.Fatalf("expected %+v, got %+v", target, state)
+	}
+	for key, perms := range target.exceptions {
+		if state.exceptions[key] != perms {
+			t.Fatalf("expected %v for %v, got %v", perms, key, state.exceptions[key])
+		}
+	}
+}
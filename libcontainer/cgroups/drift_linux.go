@@ -0,0 +1,100 @@
+// +build linux
+
+package cgroups
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// Drift is a cgroup file whose value is no longer the one the resources of
+// a container set it to, as when another agent rewrote it.
+type Drift struct {
+	// File is the name of the file, e.g. "memory.max".
+	File     string `json:"file"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", d.File, d.Expected, d.Actual)
+}
+
+// DriftChecker is implemented by the managers which can tell which files
+// Set writes for the resources of a container, and what to.
+type DriftChecker interface {
+	DriftChecks(container *configs.Config) []DriftCheck
+}
+
+// DriftCheck compares a cgroup file to the value Set wrote to it.
+type DriftCheck struct {
+	// Path is the path of the file.
+	Path     string
+	Expected string
+	// Same compares Expected to the content of the file, which the kernel
+	// may have normalized. The trimmed content must be Expected if unset.
+	Same func(expected, actual string) bool
+}
+
+// CheckDrift returns the drift of the files of checks.
+func CheckDrift(checks []DriftCheck) ([]Drift, error) {
+	var drift []Drift
+	for _, check := range checks {
+		data, err := ioutil.ReadFile(check.Path)
+		if err != nil {
+			return nil, err
+		}
+		actual := strings.TrimSpace(string(data))
+		same := actual == check.Expected
+		if check.Same != nil {
+			same = check.Same(check.Expected, actual)
+		}
+		if !same {
+			drift = append(drift, Drift{File: filepath.Base(check.Path), Expected: check.Expected, Actual: actual})
+		}
+	}
+	return drift, nil
+}
+
+// SameBytes compares memory limits, which the kernel rounds down to a
+// multiple of the page size.
+func SameBytes(expected, actual string) bool {
+	e, err := strconv.ParseInt(expected, 10, 64)
+	if err != nil {
+		return expected == actual
+	}
+	a, err := strconv.ParseInt(actual, 10, 64)
+	if err != nil {
+		return false
+	}
+	return a == e&^int64(os.Getpagesize()-1)
+}
+
+// SameCpuset compares cpuset lists, which the kernel rewrites in order and
+// with ranges.
+func SameCpuset(expected, actual string) bool {
+	e, err := ParseCpusetList(expected)
+	if err != nil {
+		return false
+	}
+	a, err := ParseCpusetList(actual)
+	if err != nil || len(a) != len(e) {
+		return false
+	}
+	ids := make(map[int]bool, len(e))
+	for _, id := range e {
+		ids[id] = true
+	}
+	for _, id := range a {
+		if !ids[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+// +build linux
+
+package cgroups
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDriftCompare(t *testing.T) {
+	page := int64(os.Getpagesize())
+	if !SameBytes(strconv.FormatInt(page+1, 10), strconv.FormatInt(page, 10)) {
+		t.Error("expected limits to be rounded down to the page size")
+	}
+	if SameBytes(strconv.FormatInt(page, 10), "max") || !SameBytes("max", "max") {
+		t.Error("expected max to only be max")
+	}
+	if !SameCpuset("0,1,2,5", "0-2,5") {
+		t.Error("expected normalized cpusets to be the same")
+	}
+	if SameCpuset("0-2", "0-3") {
+		t.Error("expected different cpusets to differ")
+	}
+}
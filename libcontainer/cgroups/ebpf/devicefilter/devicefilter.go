@@ -0,0 +1,139 @@
+// +build linux
+
+// Package devicefilter compiles the device rules of a container into the
+// eBPF program cgroup v2 checks the access to devices with, allowing the
+// same access as the devices controller of cgroup v1 would.
+package devicefilter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups/devices"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// license is the license the program is loaded under, which the kernel
+// checks to be GPL-compatible before it lets the program use GPL-only
+// helpers. The filter uses none.
+const license = "Apache"
+
+// The device types and access of struct bpf_cgroup_dev_ctx, from
+// linux/bpf.h.
+const (
+	bpfDevcgDevBlock = 1
+	bpfDevcgDevChar  = 2
+	bpfDevcgAccMknod = 1
+	bpfDevcgAccRead  = 2
+	bpfDevcgAccWrite = 4
+)
+
+// DeviceFilter returns the instructions of the program allowing the access
+// rules allow, applied in order to a cgroup allowing every device as they
+// would be on cgroup v1, along with its license.
+//
+// On cgroup v1, the rules leave a default and exceptions to it, and access
+// is allowed by default only if no exception covers any of it, otherwise
+// only if an exception covers all of it. The program checks the exceptions
+// the same way, then returns the default.
+func DeviceFilter(rules []*configs.Device) ([]ebpf.Instruction, string, error) {
+	e := devices.NewEmulator()
+	for _, rule := range rules {
+		e.Apply(rule)
+	}
+	// R2 is the type of the device, R3 the access asked for, R4 the major
+	// number and R5 the minor number.
+	insts := []ebpf.Instruction{
+		ebpf.LoadMem32(ebpf.R2, ebpf.R1, 0),
+		ebpf.Mov(ebpf.R3, ebpf.R2),
+		ebpf.And(ebpf.R2, 0xffff),
+		ebpf.RSh(ebpf.R3, 16),
+		ebpf.LoadMem32(ebpf.R4, ebpf.R1, 4),
+		ebpf.LoadMem32(ebpf.R5, ebpf.R1, 8),
+	}
+	for _, ex := range e.Exceptions() {
+		block, err := exceptionBlock(ex)
+		if err != nil {
+			return nil, "", err
+		}
+		insts = append(insts, block...)
+	}
+	return append(insts, ebpf.MovImm(ebpf.R0, boolInt(e.DefaultAllow())), ebpf.Exit()), license, nil
+}
+
+// exceptionBlock returns the instructions returning whether ex allows the
+// access if it matches, and going past them otherwise.
+func exceptionBlock(ex *configs.Device) ([]ebpf.Instruction, error) {
+	var typ int32
+	switch ex.Type {
+	case 'b':
+		typ = bpfDevcgDevBlock
+	case 'c':
+		typ = bpfDevcgDevChar
+	default:
+		return nil, fmt.Errorf("device type %q is not supported", ex.Type)
+	}
+	// The jumps are to past the block, which is only known once it is
+	// complete, so their offsets are set last.
+	var block []ebpf.Instruction
+	var jumps []int
+	jump := func(inst ebpf.Instruction) {
+		jumps = append(jumps, len(block))
+		block = append(block, inst)
+	}
+	jump(ebpf.JumpNotEqual(ebpf.R2, typ, 0))
+	block = append(block, ebpf.Mov(ebpf.R1, ebpf.R3))
+	access := bpfAccess(ex.Permissions)
+	if ex.Allow {
+		// Access beyond that of the exception is not allowed by it.
+		block = append(block, ebpf.And(ebpf.R1, ^access&0x7))
+		jump(ebpf.JumpNotEqual(ebpf.R1, 0, 0))
+	} else {
+		// Access overlapping that of the exception is denied.
+		block = append(block, ebpf.And(ebpf.R1, access))
+		jump(ebpf.JumpEqual(ebpf.R1, 0, 0))
+	}
+	for _, n := range []struct {
+		reg   ebpf.Register
+		value int64
+	}{
+		{ebpf.R4, ex.Major},
+		{ebpf.R5, ex.Minor},
+	} {
+		if n.value == configs.Wildcard {
+			continue
+		}
+		if n.value < 0 || n.value > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid device number %d", n.value)
+		}
+		jump(ebpf.JumpNotEqual(n.reg, int32(n.value), 0))
+	}
+	block = append(block, ebpf.MovImm(ebpf.R0, boolInt(ex.Allow)), ebpf.Exit())
+	for _, i := range jumps {
+		block[i].Offset = int16(len(block) - 1 - i)
+	}
+	return block, nil
+}
+
+func bpfAccess(permissions string) int32 {
+	var access int32
+	if strings.ContainsRune(permissions, 'r') {
+		access |= bpfDevcgAccRead
+	}
+	if strings.ContainsRune(permissions, 'w') {
+		access |= bpfDevcgAccWrite
+	}
+	if strings.ContainsRune(permissions, 'm') {
+		access |= bpfDevcgAccMknod
+	}
+	return access
+}
+
+func boolInt(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,254 @@
+// +build linux
+
+package devicefilter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups/devices"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf"
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	denyAll  = &configs.Device{Type: 'a', Major: configs.Wildcard, Minor: configs.Wildcard, Permissions: "rwm"}
+	allowAll = &configs.Device{Type: 'a', Major: configs.Wildcard, Minor: configs.Wildcard, Permissions: "rwm", Allow: true}
+)
+
+func TestDeviceFilterInstructions(t *testing.T) {
+	insts, license, err := DeviceFilter([]*configs.Device{
+		denyAll,
+		{Type: 'c', Major: 1, Minor: 3, Permissions: "rw", Allow: true},
+		{Type: 'b', Major: 8, Minor: configs.Wildcard, Permissions: "r", Allow: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if license == "" {
+		t.Fatal("expected a license")
+	}
+	expected := []ebpf.Instruction{
+		ebpf.LoadMem32(ebpf.R2, ebpf.R1, 0),
+		ebpf.Mov(ebpf.R3, ebpf.R2),
+		ebpf.And(ebpf.R2, 0xffff),
+		ebpf.RSh(ebpf.R3, 16),
+		ebpf.LoadMem32(ebpf.R4, ebpf.R1, 4),
+		ebpf.LoadMem32(ebpf.R5, ebpf.R1, 8),
+		// b 8:* r
+		ebpf.JumpNotEqual(ebpf.R2, bpfDevcgDevBlock, 6),
+		ebpf.Mov(ebpf.R1, ebpf.R3),
+		ebpf.And(ebpf.R1, bpfDevcgAccWrite|bpfDevcgAccMknod),
+		ebpf.JumpNotEqual(ebpf.R1, 0, 3),
+		ebpf.JumpNotEqual(ebpf.R4, 8, 2),
+		ebpf.MovImm(ebpf.R0, 1),
+		ebpf.Exit(),
+		// c 1:3 rw
+		ebpf.JumpNotEqual(ebpf.R2, bpfDevcgDevChar, 7),
+		ebpf.Mov(ebpf.R1, ebpf.R3),
+		ebpf.And(ebpf.R1, bpfDevcgAccMknod),
+		ebpf.JumpNotEqual(ebpf.R1, 0, 4),
+		ebpf.JumpNotEqual(ebpf.R4, 1, 3),
+		ebpf.JumpNotEqual(ebpf.R5, 3, 2),
+		ebpf.MovImm(ebpf.R0, 1),
+		ebpf.Exit(),
+		// Everything else is denied.
+		ebpf.MovImm(ebpf.R0, 0),
+		ebpf.Exit(),
+	}
+	assertInstructions(t, insts, expected)
+
+	// Allowing by default, the exceptions deny whatever access they share.
+	insts, _, err = DeviceFilter([]*configs.Device{
+		allowAll,
+		{Type: 'c', Major: 10, Minor: 200, Permissions: "w"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected[:6:6],
+		ebpf.JumpNotEqual(ebpf.R2, bpfDevcgDevChar, 7),
+		ebpf.Mov(ebpf.R1, ebpf.R3),
+		ebpf.And(ebpf.R1, bpfDevcgAccWrite),
+		ebpf.JumpEqual(ebpf.R1, 0, 4),
+		ebpf.JumpNotEqual(ebpf.R4, 10, 3),
+		ebpf.JumpNotEqual(ebpf.R5, 200, 2),
+		ebpf.MovImm(ebpf.R0, 0),
+		ebpf.Exit(),
+		ebpf.MovImm(ebpf.R0, 1),
+		ebpf.Exit(),
+	)
+	assertInstructions(t, insts, expected)
+
+	if _, _, err := DeviceFilter([]*configs.Device{denyAll, {Type: 'p', Permissions: "rwm", Allow: true}}); err == nil {
+		t.Fatal("expected an error for a fifo rule")
+	}
+}
+
+func assertInstructions(t *testing.T, insts, expected []ebpf.Instruction) {
+	if len(insts) != len(expected) {
+		t.Fatalf("expected %d instructions, got %d: %v", len(expected), len(insts), insts)
+	}
+	for i := range insts {
+		if insts[i] != expected[i] {
+			t.Fatalf("expected instruction %d to be %+v, got %+v", i, expected[i], insts[i])
+		}
+	}
+}
+
+// TestDeviceFilterMatchesV1 runs the programs for every access to a range
+// of devices, comparing their verdicts to those of the devices controller
+// of cgroup v1, as in devcgroup_legacy_check_permission.
+func TestDeviceFilterMatchesV1(t *testing.T) {
+	for _, rules := range [][]*configs.Device{
+		{denyAll},
+		{allowAll},
+		{allowAll, {Type: 'c', Major: 1, Minor: 3, Permissions: "rw"}, {Type: 'b', Major: configs.Wildcard, Minor: 5, Permissions: "m"}},
+		append([]*configs.Device{denyAll}, configs.DefaultAllowedDevices...),
+		{denyAll, {Type: 'c', Major: configs.Wildcard, Minor: configs.Wildcard, Permissions: "m", Allow: true}, {Type: 'c', Major: 1, Minor: 5, Permissions: "r", Allow: true}, {Type: 'c', Major: 1, Minor: 5, Permissions: "w", Allow: true}},
+		{denyAll, {Type: 'c', Major: 136, Minor: configs.Wildcard, Permissions: "rwm", Allow: true}, {Type: 'c', Major: 136, Minor: 3, Permissions: "rw"}},
+	} {
+		insts, _, err := DeviceFilter(rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := devices.NewEmulator()
+		for _, rule := range rules {
+			e.Apply(rule)
+		}
+		for _, typ := range []rune{'b', 'c'} {
+			for _, major := range []int64{1, 5, 8, 136} {
+				for _, minor := range []int64{0, 3, 5, 9} {
+					for access := int32(1); access <= 7; access++ {
+						expected := v1Allows(e, typ, major, minor, access)
+						if got := run(t, insts, typ, major, minor, access); got != expected {
+							t.Fatalf("rules %v: expected access %d to %c %d:%d to be allowed=%v", rules, access, typ, major, minor, expected)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// v1Allows returns whether the devices controller of cgroup v1 allows
+// access to a device of a cgroup with the device access of e.
+func v1Allows(e *devices.Emulator, typ rune, major, minor int64, access int32) bool {
+	for _, ex := range e.Exceptions() {
+		if ex.Type != typ || (ex.Major != configs.Wildcard && ex.Major != major) || (ex.Minor != configs.Wildcard && ex.Minor != minor) {
+			continue
+		}
+		covered := bpfAccess(ex.Permissions)
+		if e.DefaultAllow() {
+			if access&covered != 0 {
+				return false
+			}
+		} else if access&^covered == 0 {
+			return true
+		}
+	}
+	return e.DefaultAllow()
+}
+
+// run interprets insts for access to a device, as the kernel runs them.
+func run(t *testing.T, insts []ebpf.Instruction, typ rune, major, minor int64, access int32) bool {
+	devType := uint64(bpfDevcgDevChar)
+	if typ == 'b' {
+		devType = bpfDevcgDevBlock
+	}
+	ctx := []uint64{uint64(access)<<16 | devType, uint64(major), uint64(minor)}
+	var regs [11]uint64
+	for pc := 0; pc < len(insts); pc++ {
+		inst := insts[pc]
+		dst, imm := &regs[inst.Dst], uint64(int64(inst.Constant))
+		switch inst {
+		case ebpf.LoadMem32(inst.Dst, ebpf.R1, inst.Offset):
+			*dst = ctx[inst.Offset/4]
+		case ebpf.Mov(inst.Dst, inst.Src):
+			*dst = regs[inst.Src]
+		case ebpf.MovImm(inst.Dst, inst.Constant):
+			*dst = imm
+		case ebpf.And(inst.Dst, inst.Constant):
+			*dst &= imm
+		case ebpf.RSh(inst.Dst, inst.Constant):
+			*dst >>= imm
+		case ebpf.JumpEqual(inst.Dst, inst.Constant, inst.Offset):
+			if *dst == imm {
+				pc += int(inst.Offset)
+			}
+		case ebpf.JumpNotEqual(inst.Dst, inst.Constant, inst.Offset):
+			if *dst != imm {
+				pc += int(inst.Offset)
+			}
+		case ebpf.Exit():
+			return regs[ebpf.R0] == 1
+		default:
+			t.Fatalf("unexpected instruction %+v", inst)
+		}
+	}
+	t.Fatal("the program does not exit")
+	return false
+}
+
+func TestLoadAttachDeviceFilter(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	// The unified hierarchy is mounted there on hybrid hosts.
+	const cgroup2SuperMagic = 0x63677270
+	root := "/sys/fs/cgroup/unified"
+	var st unix.Statfs_t
+	if err := unix.Statfs(root, &st); err != nil || st.Type != cgroup2SuperMagic {
+		root = "/sys/fs/cgroup"
+		if err := unix.Statfs(root, &st); err != nil || st.Type != cgroup2SuperMagic {
+			t.Skip("requires cgroup v2")
+		}
+	}
+	dir, err := ioutil.TempDir(root, "devicefilter")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.Remove(dir)
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Opening /dev/null from the cgroup tells which filter is attached.
+	openNull := func() error {
+		cmd := exec.Command("/bin/sh", "-c", `echo $$ > "$0/cgroup.procs" && exec cat /dev/null`, dir)
+		out, err := cmd.CombinedOutput()
+		if err != nil && !strings.Contains(string(out), "not permitted") {
+			t.Fatalf("unexpected failure: %v: %s", err, out)
+		}
+		return err
+	}
+	for _, c := range []struct {
+		rules   []*configs.Device
+		allowed bool
+	}{
+		{[]*configs.Device{denyAll, {Type: 'c', Major: 1, Minor: 5, Permissions: "rwm", Allow: true}}, false},
+		{[]*configs.Device{denyAll, {Type: 'c', Major: 1, Minor: 3, Permissions: "r", Allow: true}}, true},
+	} {
+		insts, license, err := DeviceFilter(c.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ebpf.LoadAttachCgroupDeviceFilter(insts, license, int(f.Fd())); err != nil {
+			if strings.Contains(err.Error(), "operation not permitted") {
+				t.Skip(err)
+			}
+			t.Fatal(err)
+		}
+		// The filter attached before was replaced rather than kept along.
+		if err := openNull(); (err == nil) != c.allowed {
+			t.Fatalf("rules %v: expected /dev/null to be allowed=%v, got %v", c.rules, c.allowed, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// +build linux
+
+// Package ebpf loads eBPF programs and attaches them to cgroups, with just
+// enough of an assembler for the device filters of cgroup v2.
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/vishvananda/netlink/nl"
+
+	"golang.org/x/sys/unix"
+)
+
+// Register is a register of the eBPF machine. R0 holds the return value, R1
+// the context of the program on entry.
+type Register uint8
+
+const (
+	R0 Register = iota
+	R1
+	R2
+	R3
+	R4
+	R5
+)
+
+// Instruction is an eBPF instruction.
+type Instruction struct {
+	OpCode   uint8
+	Dst, Src Register
+	Offset   int16
+	Constant int32
+}
+
+// The opcodes of the instructions below, from linux/bpf.h.
+const (
+	opLdxMemW  = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+	opAndK     = 0x57 // BPF_ALU64 | BPF_AND | BPF_K
+	opRShK     = 0x77 // BPF_ALU64 | BPF_RSH | BPF_K
+	opMovK     = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+	opMovX     = 0xbf // BPF_ALU64 | BPF_MOV | BPF_X
+	opJEqK     = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	opJNEK     = 0x55 // BPF_JMP | BPF_JNE | BPF_K
+	opExit     = 0x95 // BPF_JMP | BPF_EXIT
+	insnLength = 8
+)
+
+// LoadMem32 loads the 32 bits at src+off into dst.
+func LoadMem32(dst, src Register, off int16) Instruction {
+	return Instruction{OpCode: opLdxMemW, Dst: dst, Src: src, Offset: off}
+}
+
+// And sets dst to dst & imm.
+func And(dst Register, imm int32) Instruction {
+	return Instruction{OpCode: opAndK, Dst: dst, Constant: imm}
+}
+
+// RSh shifts dst right by imm bits.
+func RSh(dst Register, imm int32) Instruction {
+	return Instruction{OpCode: opRShK, Dst: dst, Constant: imm}
+}
+
+// Mov sets dst to src.
+func Mov(dst, src Register) Instruction {
+	return Instruction{OpCode: opMovX, Dst: dst, Src: src}
+}
+
+// MovImm sets dst to imm.
+func MovImm(dst Register, imm int32) Instruction {
+	return Instruction{OpCode: opMovK, Dst: dst, Constant: imm}
+}
+
+// JumpEqual skips off instructions if dst is imm.
+func JumpEqual(dst Register, imm int32, off int16) Instruction {
+	return Instruction{OpCode: opJEqK, Dst: dst, Offset: off, Constant: imm}
+}
+
+// JumpNotEqual skips off instructions if dst is not imm.
+func JumpNotEqual(dst Register, imm int32, off int16) Instruction {
+	return Instruction{OpCode: opJNEK, Dst: dst, Offset: off, Constant: imm}
+}
+
+// Exit returns R0.
+func Exit() Instruction {
+	return Instruction{OpCode: opExit}
+}
+
+// marshal encodes insts as the kernel takes them, in the byte order of the
+// host.
+func marshal(insts []Instruction) []byte {
+	native := nl.NativeEndian()
+	buf := make([]byte, insnLength*len(insts))
+	for i, inst := range insts {
+		b := buf[i*insnLength:]
+		b[0] = inst.OpCode
+		// The registers are bit fields, whose order follows the byte order.
+		if native.Uint16([]byte{1, 0}) == 1 {
+			b[1] = uint8(inst.Dst) | uint8(inst.Src)<<4
+		} else {
+			b[1] = uint8(inst.Dst)<<4 | uint8(inst.Src)
+		}
+		native.PutUint16(b[2:], uint16(inst.Offset))
+		native.PutUint32(b[4:], uint32(inst.Constant))
+	}
+	return buf
+}
+
+// The bpf(2) commands, program type, attach type and flag used, from
+// linux/bpf.h.
+const (
+	bpfProgLoad             = 5
+	bpfProgAttach           = 8
+	bpfProgDetach           = 9
+	bpfProgGetFDByID        = 13
+	bpfProgQuery            = 16
+	bpfProgTypeCgroupDevice = 15
+	bpfCgroupDevice         = 6
+	bpfFAllowMulti          = 2
+
+	// maxCgroupProgs is how many programs of a type a cgroup takes.
+	maxCgroupProgs = 64
+)
+
+// The attributes of the bpf(2) commands used. Newer kernels write back to
+// fields beyond those, which the padding leaves room for.
+type progLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+	_           [128]byte
+}
+
+type progAttachAttr struct {
+	targetFD    uint32
+	attachBpfFD uint32
+	attachType  uint32
+	attachFlags uint32
+	_           [128]byte
+}
+
+type progQueryAttr struct {
+	targetFD    uint32
+	attachType  uint32
+	queryFlags  uint32
+	attachFlags uint32
+	progIDs     uint64
+	progCnt     uint32
+	_           [132]byte
+}
+
+type progGetFDByIDAttr struct {
+	progID uint32
+	_      [128]byte
+}
+
+func bpf(cmd uintptr, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, cmd, uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// LoadAttachCgroupDeviceFilter loads insts, under license, as the device
+// filter of the cgroup of dirFD, replacing those attached to it before. The
+// new filter is attached before the others are detached: while both are,
+// access has to be allowed by both, so the cgroup is never allowed more
+// than by either of them.
+func LoadAttachCgroupDeviceFilter(insts []Instruction, license string, dirFD int) error {
+	old, err := queryDeviceFilters(dirFD)
+	if err != nil {
+		return fmt.Errorf("querying the device filters: %v", err)
+	}
+	prog, err := loadDeviceFilter(insts, license)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(prog)
+	attach := progAttachAttr{
+		targetFD:    uint32(dirFD),
+		attachBpfFD: uint32(prog),
+		attachType:  bpfCgroupDevice,
+		attachFlags: bpfFAllowMulti,
+	}
+	if _, err := bpf(bpfProgAttach, unsafe.Pointer(&attach), unsafe.Sizeof(attach)); err != nil {
+		return fmt.Errorf("attaching the device filter: %v", err)
+	}
+	for _, id := range old {
+		if err := detachDeviceFilter(dirFD, id); err != nil {
+			return fmt.Errorf("detaching the device filter %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func loadDeviceFilter(insts []Instruction, license string) (int, error) {
+	code := marshal(insts)
+	lic := append([]byte(license), 0)
+	attr := progLoadAttr{
+		progType: bpfProgTypeCgroupDevice,
+		insnCnt:  uint32(len(insts)),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&lic[0]))),
+	}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err == nil {
+		runtime.KeepAlive(code)
+		runtime.KeepAlive(lic)
+		return fd, nil
+	}
+	// The program is loaded again for the log of the verifier to tell why
+	// it was refused.
+	log := make([]byte, 64*1024)
+	attr.logLevel = 1
+	attr.logSize = uint32(len(log))
+	attr.logBuf = uint64(uintptr(unsafe.Pointer(&log[0])))
+	if fd, lerr := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); lerr == nil {
+		unix.Close(fd)
+	}
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(lic)
+	if i := bytes.IndexByte(log, 0); i >= 0 {
+		log = log[:i]
+	}
+	return -1, fmt.Errorf("loading the device filter: %v: %s", err, bytes.TrimSpace(log))
+}
+
+// queryDeviceFilters returns the ids of the device filters attached to the
+// cgroup of dirFD.
+func queryDeviceFilters(dirFD int) ([]uint32, error) {
+	ids := make([]uint32, maxCgroupProgs)
+	attr := progQueryAttr{
+		targetFD:   uint32(dirFD),
+		attachType: bpfCgroupDevice,
+		progIDs:    uint64(uintptr(unsafe.Pointer(&ids[0]))),
+		progCnt:    uint32(len(ids)),
+	}
+	_, err := bpf(bpfProgQuery, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(ids)
+	if err != nil {
+		return nil, err
+	}
+	return ids[:attr.progCnt], nil
+}
+
+func detachDeviceFilter(dirFD int, id uint32) error {
+	get := progGetFDByIDAttr{progID: id}
+	prog, err := bpf(bpfProgGetFDByID, unsafe.Pointer(&get), unsafe.Sizeof(get))
+	if err != nil {
+		if err == unix.ENOENT {
+			return nil
+		}
+		return err
+	}
+	defer unix.Close(prog)
+	detach := progAttachAttr{
+		targetFD:    uint32(dirFD),
+		attachBpfFD: uint32(prog),
+		attachType:  bpfCgroupDevice,
+	}
+	if _, err := bpf(bpfProgDetach, unsafe.Pointer(&detach), unsafe.Sizeof(detach)); err != nil && err != unix.ENOENT {
+		return err
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -65,6 +66,9 @@ type Manager struct {
 	mu      sync.Mutex
 	Cgroups *configs.Cgroup
 	Paths   map[string]string
+
+	// pressure keeps the pressure files open between calls to GetStats.
+	pressure cgroups.PressureFiles
 }
 
 // The absolute path to the root of the cgroup hierarchies.
@@ -108,6 +112,9 @@ func (m *Manager) Apply(pid int) (err error) {
 	defer m.mu.Unlock()
 
 	var c = m.Cgroups
+	if err := cgroups.ResolvePercentages(c.Resources); err != nil {
+		return err
+	}
 
 	d, err := getCgroupData(m.Cgroups, pid)
 	if err != nil {
@@ -152,6 +159,9 @@ func (m *Manager) Apply(pid int) (err error) {
 }
 
 func (m *Manager) Destroy() error {
+	m.mu.Lock()
+	m.pressure.Close()
+	m.mu.Unlock()
 	if m.Cgroups.Paths != nil {
 		return nil
 	}
@@ -181,9 +191,15 @@ func (m *Manager) GetStats() (*cgroups.Stats, error) {
 			continue
 		}
 		if err := sys.GetStats(path, stats); err != nil {
-			return nil, err
+			if m.Cgroups != nil && m.Cgroups.StrictStats {
+				return nil, err
+			}
+			stats.AddError(name, err)
 		}
 	}
+	if err := m.pressure.GetStats(m.Paths, stats, m.Cgroups != nil && m.Cgroups.StrictStats); err != nil {
+		return nil, err
+	}
 	return stats, nil
 }
 
@@ -193,6 +209,9 @@ func (m *Manager) Set(container *configs.Config) error {
 	if m.Cgroups.Paths != nil {
 		return nil
 	}
+	if err := cgroups.ResolvePercentages(container.Cgroups.Resources); err != nil {
+		return err
+	}
 
 	paths := m.GetPaths()
 	for _, sys := range subsystems {
@@ -212,16 +231,12 @@ func (m *Manager) Set(container *configs.Config) error {
 
 // Freeze toggles the container's freezer cgroup depending on the state
 // provided
-func (m *Manager) Freeze(state configs.FreezerState) error {
+func (m *Manager) Freeze(state configs.FreezerState, timeout time.Duration) error {
 	paths := m.GetPaths()
 	dir := paths["freezer"]
 	prevState := m.Cgroups.Resources.Freezer
 	m.Cgroups.Resources.Freezer = state
-	freezer, err := subsystems.Get("freezer")
-	if err != nil {
-		return err
-	}
-	err = freezer.Set(dir, m.Cgroups)
+	err := SetFreezerState(dir, state, timeout)
 	if err != nil {
 		m.Cgroups.Resources.Freezer = prevState
 		return err
@@ -270,3 +270,37 @@ func TestInvalidAbsoluteCgroupNameAndParent(t *testing.T) {
 		t.Errorf("SECURITY: cgroup path() is outside cgroup mountpoint!")
 	}
 }
+
+func TestGetStatsPartial(t *testing.T) {
+	memory := NewCgroupTestUtil("memory", t)
+	defer memory.cleanup()
+	memory.writeFileContents(map[string]string{
+		"memory.stat": "cache garbage\n",
+	})
+	pids := NewCgroupTestUtil("pids", t)
+	defer pids.cleanup()
+	pids.writeFileContents(map[string]string{
+		"pids.current": "3\n",
+		"pids.max":     "max\n",
+	})
+	m := &Manager{
+		Cgroups: &configs.Cgroup{},
+		Paths:   map[string]string{"memory": memory.CgroupPath, "pids": pids.CgroupPath},
+	}
+
+	stats, err := m.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PidsStats.Current != 3 {
+		t.Fatalf("expected 3 pids but received %d", stats.PidsStats.Current)
+	}
+	if _, ok := stats.Errors["memory"]; !ok || len(stats.Errors) != 1 {
+		t.Fatalf("expected an error for the memory subsystem only, received %v", stats.Errors)
+	}
+
+	m.Cgroups.StrictStats = true
+	if _, err := m.GetStats(); err == nil {
+		t.Fatal("expected strict stats to fail")
+	}
+}
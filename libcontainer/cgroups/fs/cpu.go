@@ -83,6 +83,11 @@ func (s *CpuGroup) Set(path string, cgroup *configs.Cgroup) error {
 			return err
 		}
 	}
+	if cgroup.Resources.CPUIdle != nil {
+		if err := writeFile(path, "cpu.idle", strconv.FormatInt(*cgroup.Resources.CPUIdle, 10)); err != nil {
+			return err
+		}
+	}
 	if err := s.SetRtSched(path, cgroup); err != nil {
 		return err
 	}
@@ -119,6 +124,10 @@ func (s *CpuGroup) GetStats(path string, stats *cgroups.Stats) error {
 
 		case "throttled_time":
 			stats.CpuStats.ThrottlingData.ThrottledTime = v
+
+		case "throttled_usec":
+			// cgroup v2 reports the time in microseconds.
+			stats.CpuStats.ThrottlingData.ThrottledTime = v * 1000
 		}
 	}
 	return nil
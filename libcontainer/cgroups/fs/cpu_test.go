@@ -132,6 +132,52 @@ func TestCpuStats(t *testing.T) {
 	expectThrottlingDataEquals(t, expectedStats, actualStats.CpuStats.ThrottlingData)
 }
 
+func TestCpuStatsThrottledUsec(t *testing.T) {
+	helper := NewCgroupTestUtil("cpu", t)
+	defer helper.cleanup()
+
+	helper.writeFileContents(map[string]string{
+		"cpu.stat": "usage_usec 5000\nnr_periods 20\nnr_throttled 4\nthrottled_usec 300\n",
+	})
+
+	cpu := &CpuGroup{}
+	actualStats := *cgroups.NewStats()
+	if err := cpu.GetStats(helper.CgroupPath, &actualStats); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedStats := cgroups.ThrottlingData{
+		Periods:          20,
+		ThrottledPeriods: 4,
+		ThrottledTime:    300000}
+
+	expectThrottlingDataEquals(t, expectedStats, actualStats.CpuStats.ThrottlingData)
+}
+
+func TestCpuSetIdle(t *testing.T) {
+	helper := NewCgroupTestUtil("cpu", t)
+	defer helper.cleanup()
+
+	helper.writeFileContents(map[string]string{
+		"cpu.idle": "0",
+	})
+
+	idle := int64(1)
+	helper.CgroupData.config.Resources.CPUIdle = &idle
+	cpu := &CpuGroup{}
+	if err := cpu.Set(helper.CgroupPath, helper.CgroupData.config); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := getCgroupParamUint(helper.CgroupPath, "cpu.idle")
+	if err != nil {
+		t.Fatalf("Failed to parse cpu.idle - %s", err)
+	}
+	if value != 1 {
+		t.Fatal("Got the wrong value, set cpu.idle failed.")
+	}
+}
+
 func TestNoCpuStatFile(t *testing.T) {
 	helper := NewCgroupTestUtil("cpu", t)
 	defer helper.cleanup()
@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -30,13 +32,74 @@ func (s *CpusetGroup) Apply(d *cgroupData) error {
 }
 
 func (s *CpusetGroup) Set(path string, cgroup *configs.Cgroup) error {
-	if cgroup.Resources.CpusetCpus != "" {
-		if err := writeFile(path, "cpuset.cpus", cgroup.Resources.CpusetCpus); err != nil {
+	for _, f := range []struct {
+		file, value string
+	}{
+		{"cpuset.cpus", cgroup.Resources.CpusetCpus},
+		{"cpuset.mems", cgroup.Resources.CpusetMems},
+	} {
+		if f.value == "" {
+			continue
+		}
+		// The cgroups created below ours, e.g. by systemd in the container,
+		// must be a subset of ours, which the kernel refuses to narrow
+		// until they are.
+		ids, err := cgroups.ParseCpusetList(f.value)
+		if err != nil {
+			return err
+		}
+		allowed := make(map[int]bool, len(ids))
+		for _, id := range ids {
+			allowed[id] = true
+		}
+		if err := s.narrowDescendants(path, f.file, f.value, allowed); err != nil {
 			return err
 		}
+		if err := writeFile(path, f.file, f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// narrowDescendants removes from file of every cgroup below path the ids
+// which are not allowed by list, deepest first so that each is written
+// while its parent still has the old value. A cgroup left with nothing
+// fails the update.
+func (s *CpusetGroup) narrowDescendants(path, file, list string, allowed map[int]bool) error {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
 	}
-	if cgroup.Resources.CpusetMems != "" {
-		if err := writeFile(path, "cpuset.mems", cgroup.Resources.CpusetMems); err != nil {
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		child := filepath.Join(path, e.Name())
+		if err := s.narrowDescendants(child, file, list, allowed); err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(child, file))
+		if err != nil {
+			return err
+		}
+		current, err := cgroups.ParseCpusetList(string(data))
+		if err != nil {
+			return err
+		}
+		var narrowed []int
+		for _, id := range current {
+			if allowed[id] {
+				narrowed = append(narrowed, id)
+			}
+		}
+		if len(narrowed) == len(current) {
+			continue
+		}
+		if len(narrowed) == 0 {
+			return fmt.Errorf("cpuset: %s of %s has none of %s", file, child, list)
+		}
+		if err := writeFile(child, file, formatCpusetList(narrowed)); err != nil {
 			return err
 		}
 	}
@@ -151,6 +214,24 @@ func (s *CpusetGroup) copyIfNeeded(current, parent string) error {
 	return nil
 }
 
+// formatCpusetList formats ids, in order, as a list like "0-3,6".
+func formatCpusetList(ids []int) string {
+	var ranges []string
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(ids[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", ids[i], ids[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
 func (s *CpusetGroup) isEmpty(b []byte) bool {
 	return len(bytes.Trim(b, "\n")) == 0
 }
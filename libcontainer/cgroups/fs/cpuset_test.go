@@ -3,7 +3,11 @@
 package fs
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 )
 
 func TestCpusetSetCpus(t *testing.T) {
@@ -63,3 +67,69 @@ func TestCpusetSetMems(t *testing.T) {
 		t.Fatal("Got the wrong value, set cpuset.mems failed.")
 	}
 }
+
+func TestCpusetSetNarrowsChildren(t *testing.T) {
+	helper := NewCgroupTestUtil("cpuset", t)
+	defer helper.cleanup()
+
+	helper.writeFileContents(map[string]string{
+		"cpuset.cpus": "0-3",
+		"cpuset.mems": "0",
+	})
+	child := filepath.Join(helper.CgroupPath, "child")
+	grandchild := filepath.Join(child, "grandchild")
+	if err := os.MkdirAll(grandchild, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for dir, cpus := range map[string]string{child: "0-3", grandchild: "2-3"} {
+		if err := writeFile(dir, "cpuset.cpus", cpus); err != nil {
+			t.Fatal(err)
+		}
+		// An unset cpuset.mems is left alone.
+		if err := writeFile(dir, "cpuset.mems", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	helper.CgroupData.config.Resources.CpusetCpus = "1-2"
+	helper.CgroupData.config.Resources.CpusetMems = "0"
+	cpuset := &CpusetGroup{}
+	if err := cpuset.Set(helper.CgroupPath, helper.CgroupData.config); err != nil {
+		t.Fatal(err)
+	}
+	for dir, expected := range map[string]string{helper.CgroupPath: "1-2", child: "1-2", grandchild: "2"} {
+		value, err := getCgroupParamString(dir, "cpuset.cpus")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("expected cpuset.cpus of %s to be %q, got %q", dir, expected, value)
+		}
+	}
+
+	// A child cannot be left without any cpu.
+	helper.CgroupData.config.Resources.CpusetCpus = "0"
+	if err := cpuset.Set(helper.CgroupPath, helper.CgroupData.config); err == nil {
+		t.Fatal("expected the update to fail")
+	}
+}
+
+func TestCpusetList(t *testing.T) {
+	for list, expected := range map[string]string{
+		"":          "",
+		"0":         "0",
+		"0-3,6":     "0-3,6",
+		"1,2,3,5,7": "1-3,5,7",
+	} {
+		ids, err := cgroups.ParseCpusetList(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatCpusetList(ids); got != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, list, got)
+		}
+	}
+	if _, err := cgroups.ParseCpusetList("3-1"); err == nil {
+		t.Fatal("expected an invalid range to fail")
+	}
+}
@@ -3,7 +3,12 @@
 package fs
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/cgroups/devices"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/system"
 )
@@ -25,50 +30,57 @@ func (s *DevicesGroup) Apply(d *cgroupData) error {
 	return nil
 }
 
+// Set moves the device rules of the cgroup at path from those in
+// devices.list to those of cgroup. When the rules deny by default, as they
+// do once they start with denying everything, only the differences are
+// written, revoking before granting, so that the container never has more
+// access than either set of rules allows. The kernel can only switch to
+// allowing by default by dropping every rule though, so rules which do are
+// written as they are.
 func (s *DevicesGroup) Set(path string, cgroup *configs.Cgroup) error {
 	if system.RunningInUserNS() {
 		return nil
 	}
 
-	devices := cgroup.Resources.Devices
-	if len(devices) > 0 {
-		for _, dev := range devices {
-			file := "devices.deny"
-			if dev.Allow {
-				file = "devices.allow"
-			}
-			if err := writeFile(path, file, dev.CgroupString()); err != nil {
-				return err
-			}
-		}
+	rules := devices.Rules(cgroup.Resources)
+	if len(rules) == 0 {
 		return nil
 	}
-	if cgroup.Resources.AllowAllDevices != nil {
-		if *cgroup.Resources.AllowAllDevices == false {
-			if err := writeFile(path, "devices.deny", "a"); err != nil {
-				return err
-			}
-
-			for _, dev := range cgroup.Resources.AllowedDevices {
-				if err := writeFile(path, "devices.allow", dev.CgroupString()); err != nil {
-					return err
-				}
-			}
-			return nil
+	current, err := readDevicesList(path)
+	if err != nil {
+		return err
+	}
+	target := current.Clone()
+	for _, rule := range rules {
+		target.Apply(rule)
+	}
+	writes := rules
+	if !target.DefaultAllow() {
+		writes = current.Transition(target)
+	}
+	for _, dev := range writes {
+		file := "devices.deny"
+		if dev.Allow {
+			file = "devices.allow"
 		}
-
-		if err := writeFile(path, "devices.allow", "a"); err != nil {
+		if err := writeFile(path, file, dev.CgroupString()); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	for _, dev := range cgroup.Resources.DeniedDevices {
-		if err := writeFile(path, "devices.deny", dev.CgroupString()); err != nil {
-			return err
+// readDevicesList reads the device access of the cgroup at path from
+// devices.list. A cgroup without it is taken to be unrestricted.
+func readDevicesList(path string) (*devices.Emulator, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, "devices.list"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return devices.NewEmulator(), nil
 		}
+		return nil, err
 	}
-
-	return nil
+	return devices.ParseList(data)
 }
 
 func (s *DevicesGroup) Remove(d *cgroupData) error {
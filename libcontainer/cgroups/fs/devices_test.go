@@ -96,3 +96,32 @@ func TestDevicesSetDeny(t *testing.T) {
 		t.Fatal("Got the wrong value, set devices.deny failed.")
 	}
 }
+
+func TestDevicesSetUpdate(t *testing.T) {
+	helper := NewCgroupTestUtil("devices", t)
+	defer helper.cleanup()
+
+	helper.writeFileContents(map[string]string{
+		"devices.list": "c 1:3 rwm\nc 1:5 rwm\n",
+	})
+
+	helper.CgroupData.config.Resources.Devices = []*configs.Device{
+		{Type: 'a', Major: configs.Wildcard, Minor: configs.Wildcard, Permissions: "rwm"},
+		{Type: 'c', Major: 1, Minor: 3, Permissions: "rwm", Allow: true},
+	}
+	devices := &DevicesGroup{}
+	if err := devices.Set(helper.CgroupPath, helper.CgroupData.config); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := getCgroupParamString(helper.CgroupPath, "devices.deny")
+	if err != nil {
+		t.Fatalf("Failed to parse devices.deny - %s", err)
+	}
+	if value != "c 1:5 rwm" {
+		t.Fatalf("expected only the removed device to be denied, got %q", value)
+	}
+	if _, err := getCgroupParamString(helper.CgroupPath, "devices.allow"); err == nil {
+		t.Fatal("devices.allow shouldn't have been written when nothing is granted.")
+	}
+}
@@ -0,0 +1,97 @@
+// +build linux
+
+package fs
+
+import (
+	"path/filepath"
+	"strconv"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// DriftChecks returns the checks of the files Set writes for the resources
+// of container.
+func (m *Manager) DriftChecks(container *configs.Config) []cgroups.DriftCheck {
+	if m.Cgroups.Paths != nil {
+		return nil
+	}
+	return DriftChecks(m.GetPaths(), container.Cgroups.Resources)
+}
+
+// clampShares returns the cpu.shares the kernel keeps for shares, which it
+// clamps to its range.
+func clampShares(shares uint64) uint64 {
+	if shares < 2 {
+		return 2
+	}
+	if shares > 262144 {
+		return 262144
+	}
+	return shares
+}
+
+// DriftChecks returns the checks of the files of the cgroups at paths which
+// Set writes for r.
+func DriftChecks(paths map[string]string, r *configs.Resources) []cgroups.DriftCheck {
+	var checks []cgroups.DriftCheck
+	check := func(subsystem, file, expected string, same func(string, string) bool) {
+		if paths[subsystem] == "" {
+			return
+		}
+		checks = append(checks, cgroups.DriftCheck{
+			Path:     filepath.Join(paths[subsystem], file),
+			Expected: expected,
+			Same:     same,
+		})
+	}
+	// The shares of an idle cgroup read back as those of SCHED_IDLE.
+	if r.CpuShares != 0 && (r.CPUIdle == nil || *r.CPUIdle == 0) {
+		check("cpu", "cpu.shares", strconv.FormatUint(clampShares(r.CpuShares), 10), nil)
+	}
+	if r.CpuPeriod != 0 {
+		check("cpu", "cpu.cfs_period_us", strconv.FormatUint(r.CpuPeriod, 10), nil)
+	}
+	if r.CpuQuota != 0 {
+		// Any negative quota reads back as unlimited.
+		quota := r.CpuQuota
+		if quota < 0 {
+			quota = -1
+		}
+		check("cpu", "cpu.cfs_quota_us", strconv.FormatInt(quota, 10), nil)
+	}
+	if r.CPUIdle != nil {
+		check("cpu", "cpu.idle", strconv.FormatInt(*r.CPUIdle, 10), nil)
+	}
+	if r.CpusetCpus != "" {
+		check("cpuset", "cpuset.cpus", r.CpusetCpus, cgroups.SameCpuset)
+	}
+	if r.CpusetMems != "" {
+		check("cpuset", "cpuset.mems", r.CpusetMems, cgroups.SameCpuset)
+	}
+	// Unlimited reads back as the largest multiple of the page size rather
+	// than as -1, so only limits are checked.
+	if r.Memory > 0 {
+		check("memory", cgroupMemoryLimit, strconv.FormatInt(r.Memory, 10), cgroups.SameBytes)
+	}
+	if r.MemorySwap > 0 {
+		check("memory", cgroupMemorySwapLimit, strconv.FormatInt(r.MemorySwap, 10), cgroups.SameBytes)
+	}
+	if r.MemoryReservation > 0 {
+		check("memory", "memory.soft_limit_in_bytes", strconv.FormatInt(r.MemoryReservation, 10), cgroups.SameBytes)
+	}
+	if r.MemorySwappiness != nil && *r.MemorySwappiness <= 100 {
+		check("memory", "memory.swappiness", strconv.FormatUint(*r.MemorySwappiness, 10), nil)
+	}
+	if r.PidsLimit != 0 {
+		limit := "max"
+		if r.PidsLimit > 0 {
+			limit = strconv.FormatInt(r.PidsLimit, 10)
+		}
+		check("pids", "pids.max", limit, nil)
+	}
+	if r.BlkioWeight != 0 {
+		check("blkio", "blkio.weight", strconv.FormatUint(uint64(r.BlkioWeight), 10), nil)
+	}
+	return checks
+}
@@ -3,12 +3,14 @@
 package fs
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
 )
 
 type FreezerGroup struct {
@@ -29,27 +31,74 @@ func (s *FreezerGroup) Apply(d *cgroupData) error {
 func (s *FreezerGroup) Set(path string, cgroup *configs.Cgroup) error {
 	switch cgroup.Resources.Freezer {
 	case configs.Frozen, configs.Thawed:
-		if err := writeFile(path, "freezer.state", string(cgroup.Resources.Freezer)); err != nil {
-			return err
-		}
-
-		for {
-			state, err := readFile(path, "freezer.state")
-			if err != nil {
-				return err
-			}
-			if strings.TrimSpace(state) == string(cgroup.Resources.Freezer) {
-				break
-			}
-			time.Sleep(1 * time.Millisecond)
-		}
+		return SetFreezerState(path, cgroup.Resources.Freezer, 0)
 	case configs.Undefined:
 		return nil
 	default:
 		return fmt.Errorf("Invalid argument '%s' to freezer.state", string(cgroup.Resources.Freezer))
 	}
+}
 
-	return nil
+// SetFreezerState sets the freezer cgroup at path to state and waits for the
+// state to be reached. If timeout is not zero and it is not reached in time,
+// e.g. because a process is stuck in uninterruptible sleep on a dead NFS
+// server, the cgroup is thawed and the error lists its processes along with
+// their state.
+func SetFreezerState(path string, state configs.FreezerState, timeout time.Duration) error {
+	if err := writeFile(path, "freezer.state", string(state)); err != nil {
+		return err
+	}
+	err := waitFreezerState(path, state, timeout)
+	if err != errFreezerTimeout {
+		return err
+	}
+	procs := describeProcs(path)
+	if err := writeFile(path, "freezer.state", string(configs.Thawed)); err != nil {
+		return fmt.Errorf("freezer cgroup %s did not become %s within %s (processes: %s) and cannot be thawed: %v", path, state, timeout, procs, err)
+	}
+	return fmt.Errorf("freezer cgroup %s did not become %s within %s (processes: %s)", path, state, timeout, procs)
+}
+
+var errFreezerTimeout = errors.New("timed out waiting for freezer state")
+
+// waitFreezerState polls the freezer cgroup at path until it is in state,
+// or returns errFreezerTimeout once timeout, if not zero, has passed.
+func waitFreezerState(path string, state configs.FreezerState, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		current, err := readFile(path, "freezer.state")
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(current) == string(state) {
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return errFreezerTimeout
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+// describeProcs lists the processes of the cgroup at path with the state
+// character of their /proc/<pid>/stat, e.g. "42 (D)".
+func describeProcs(path string) string {
+	pids, err := cgroups.GetAllPids(path)
+	if err != nil {
+		return err.Error()
+	}
+	procs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		state := "?"
+		if stat, err := system.Stat(pid); err == nil {
+			state = string(stat.State)
+		}
+		procs = append(procs, fmt.Sprintf("%d (%s)", pid, state))
+	}
+	return strings.Join(procs, ", ")
 }
 
 func (s *FreezerGroup) Remove(d *cgroupData) error {
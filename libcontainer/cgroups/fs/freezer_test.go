@@ -4,6 +4,7 @@ package fs
 
 import (
 	"testing"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 )
@@ -45,3 +46,23 @@ func TestFreezerSetInvalidState(t *testing.T) {
 		t.Fatal("Failed to return invalid argument error")
 	}
 }
+
+func TestFreezerWaitStateTimeout(t *testing.T) {
+	helper := NewCgroupTestUtil("freezer", t)
+	defer helper.cleanup()
+
+	// A task in uninterruptible sleep keeps the cgroup FREEZING.
+	helper.writeFileContents(map[string]string{
+		"freezer.state": "FREEZING",
+	})
+
+	if err := waitFreezerState(helper.CgroupPath, configs.Frozen, 10*time.Millisecond); err != errFreezerTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	helper.writeFileContents(map[string]string{
+		"freezer.state": string(configs.Frozen),
+	})
+	if err := waitFreezerState(helper.CgroupPath, configs.Frozen, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@
 package fs
 
 import (
+	"fmt"
 	"strconv"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
@@ -39,5 +40,10 @@ func (s *NetClsGroup) Remove(d *cgroupData) error {
 }
 
 func (s *NetClsGroup) GetStats(path string, stats *cgroups.Stats) error {
+	classid, err := getCgroupParamUint(path, "net_cls.classid")
+	if err != nil {
+		return fmt.Errorf("failed to parse net_cls.classid - %s", err)
+	}
+	stats.NetClsStats.Classid = uint32(classid)
 	return nil
 }
@@ -5,6 +5,8 @@ package fs
 import (
 	"strconv"
 	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 )
 
 const (
@@ -37,3 +39,21 @@ func TestNetClsSetClassid(t *testing.T) {
 		t.Fatal("Got the wrong value, set net_cls.classid failed.")
 	}
 }
+
+func TestNetClsStats(t *testing.T) {
+	helper := NewCgroupTestUtil("net_cls", t)
+	defer helper.cleanup()
+
+	helper.writeFileContents(map[string]string{
+		"net_cls.classid": strconv.FormatUint(classidAfter, 10),
+	})
+
+	netcls := &NetClsGroup{}
+	stats := *cgroups.NewStats()
+	if err := netcls.GetStats(helper.CgroupPath, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.NetClsStats.Classid != classidAfter {
+		t.Fatalf("expected classid %#x but got %#x", classidAfter, stats.NetClsStats.Classid)
+	}
+}
@@ -3,6 +3,9 @@
 package fs
 
 import (
+	"fmt"
+	"net"
+
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
 )
@@ -24,6 +27,11 @@ func (s *NetPrioGroup) Apply(d *cgroupData) error {
 
 func (s *NetPrioGroup) Set(path string, cgroup *configs.Cgroup) error {
 	for _, prioMap := range cgroup.Resources.NetPrioIfpriomap {
+		// The kernel looks the interfaces up in the initial network namespace
+		// rather than in that of the container, so they must exist on the host.
+		if _, err := net.InterfaceByName(prioMap.Interface); err != nil {
+			return fmt.Errorf("net_prio: no interface %q on the host: %v", prioMap.Interface, err)
+		}
 		if err := writeFile(path, "net_prio.ifpriomap", prioMap.CgroupString()); err != nil {
 			return err
 		}
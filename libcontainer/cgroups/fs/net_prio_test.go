@@ -12,7 +12,7 @@ import (
 var (
 	prioMap = []*configs.IfPrioMap{
 		{
-			Interface: "test",
+			Interface: "lo",
 			Priority:  5,
 		},
 	}
@@ -32,7 +32,23 @@ func TestNetPrioSetIfPrio(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to parse net_prio.ifpriomap - %s", err)
 	}
-	if !strings.Contains(value, "test 5") {
+	if !strings.Contains(value, "lo 5") {
 		t.Fatal("Got the wrong value, set net_prio.ifpriomap failed.")
 	}
 }
+
+func TestNetPrioSetUnknownInterface(t *testing.T) {
+	helper := NewCgroupTestUtil("net_prio", t)
+	defer helper.cleanup()
+
+	helper.CgroupData.config.Resources.NetPrioIfpriomap = []*configs.IfPrioMap{
+		{
+			Interface: "nonexistent0",
+			Priority:  5,
+		},
+	}
+	netPrio := &NetPrioGroup{}
+	if err := netPrio.Set(helper.CgroupPath, helper.CgroupData.config); err == nil {
+		t.Fatal("expected setting the priority of an unknown interface to fail")
+	}
+}
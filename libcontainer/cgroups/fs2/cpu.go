@@ -0,0 +1,85 @@
+// +build linux
+
+package fs2
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// defaultCpuPeriod is the period of cpu.max when none is configured.
+const defaultCpuPeriod = 100000
+
+// cpuWeight converts the CPU shares of cgroup v1, from 2 to 262144, into
+// the CPU weight of cgroup v2, from 1 to 10000.
+func cpuWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + (shares-2)*9999/262142
+}
+
+func setCpu(dir string, r *configs.Resources) error {
+	if r.CpuShares != 0 {
+		if err := writeFile(dir, "cpu.weight", strconv.FormatUint(cpuWeight(r.CpuShares), 10)); err != nil {
+			return err
+		}
+	}
+	if r.CpuQuota != 0 || r.CpuPeriod != 0 {
+		period := r.CpuPeriod
+		if period == 0 {
+			period = defaultCpuPeriod
+		}
+		quota := "max"
+		if r.CpuQuota > 0 {
+			quota = strconv.FormatInt(r.CpuQuota, 10)
+		}
+		if err := writeFile(dir, "cpu.max", fmt.Sprintf("%s %d", quota, period)); err != nil {
+			return err
+		}
+	}
+	if r.CPUIdle != nil {
+		if err := writeFile(dir, "cpu.idle", strconv.FormatInt(*r.CPUIdle, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setCpuset(dir string, r *configs.Resources) error {
+	if r.CpusetCpus != "" {
+		if err := writeFile(dir, "cpuset.cpus", r.CpusetCpus); err != nil {
+			return err
+		}
+	}
+	if r.CpusetMems != "" {
+		if err := writeFile(dir, "cpuset.mems", r.CpusetMems); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statCpu(dir string, stats *cgroups.Stats) error {
+	values, err := readFlatKeyed(dir, "cpu.stat")
+	if err != nil {
+		return err
+	}
+	// cpu.stat is in microseconds, the stats in nanoseconds.
+	usage := &stats.CpuStats.CpuUsage
+	usage.TotalUsage = values["usage_usec"] * 1000
+	usage.UsageInUsermode = values["user_usec"] * 1000
+	usage.UsageInKernelmode = values["system_usec"] * 1000
+	// The throttling stats are missing without the cpu controller.
+	throttling := &stats.CpuStats.ThrottlingData
+	throttling.Periods = values["nr_periods"]
+	throttling.ThrottledPeriods = values["nr_throttled"]
+	throttling.ThrottledTime = values["throttled_usec"] * 1000
+	return nil
+}
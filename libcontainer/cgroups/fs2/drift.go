@@ -0,0 +1,71 @@
+// +build linux
+
+package fs2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// DriftChecks returns the checks of the files Set writes for the resources
+// of container.
+func (m *Manager) DriftChecks(container *configs.Config) []cgroups.DriftCheck {
+	dir := m.dir()
+	if m.Cgroups.Paths != nil || dir == "" {
+		return nil
+	}
+	r := container.Cgroups.Resources
+	var checks []cgroups.DriftCheck
+	check := func(file, expected string, same func(string, string) bool) {
+		checks = append(checks, cgroups.DriftCheck{Path: filepath.Join(dir, file), Expected: expected, Same: same})
+	}
+	// The weight of an idle cgroup reads back as that of SCHED_IDLE.
+	if r.CpuShares != 0 && (r.CPUIdle == nil || *r.CPUIdle == 0) {
+		check("cpu.weight", strconv.FormatUint(cpuWeight(r.CpuShares), 10), nil)
+	}
+	if r.CpuQuota != 0 || r.CpuPeriod != 0 {
+		period := r.CpuPeriod
+		if period == 0 {
+			period = defaultCpuPeriod
+		}
+		quota := "max"
+		if r.CpuQuota > 0 {
+			quota = strconv.FormatInt(r.CpuQuota, 10)
+		}
+		check("cpu.max", fmt.Sprintf("%s %d", quota, period), nil)
+	}
+	if r.CPUIdle != nil {
+		check("cpu.idle", strconv.FormatInt(*r.CPUIdle, 10), nil)
+	}
+	if r.CpusetCpus != "" {
+		check("cpuset.cpus", r.CpusetCpus, cgroups.SameCpuset)
+	}
+	if r.CpusetMems != "" {
+		check("cpuset.mems", r.CpusetMems, cgroups.SameCpuset)
+	}
+	// Set refused the resources if the swap limit is invalid.
+	if swap, err := swapLimit(r.Memory, r.MemorySwap); err == nil && swap != "" {
+		if _, err := os.Stat(filepath.Join(dir, "memory.swap.max")); err == nil || swap != "max" {
+			check("memory.swap.max", swap, cgroups.SameBytes)
+		}
+	}
+	if r.Memory != 0 {
+		check("memory.max", formatLimit(r.Memory), cgroups.SameBytes)
+	}
+	if r.MemoryReservation != 0 {
+		check("memory.low", formatLimit(r.MemoryReservation), cgroups.SameBytes)
+	}
+	if r.PidsLimit != 0 {
+		limit := "max"
+		if r.PidsLimit > 0 {
+			limit = strconv.FormatInt(r.PidsLimit, 10)
+		}
+		check("pids.max", limit, nil)
+	}
+	return checks
+}
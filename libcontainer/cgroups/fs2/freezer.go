@@ -0,0 +1,75 @@
+// +build linux
+
+package fs2
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+func setFreezer(dir string, r *configs.Resources) error {
+	switch r.Freezer {
+	case configs.Frozen, configs.Thawed:
+		return setFreezerState(dir, r.Freezer, 0)
+	case configs.Undefined:
+		return nil
+	default:
+		return fmt.Errorf("Invalid argument '%s' to cgroup.freeze", string(r.Freezer))
+	}
+}
+
+// setFreezerState freezes or thaws the cgroup dir, waiting for it to be done
+// as reported by cgroup.events. If timeout is not zero and the state is not
+// reached in time, the cgroup is thawed and an error is returned.
+func setFreezerState(dir string, state configs.FreezerState, timeout time.Duration) error {
+	value := "0"
+	if state == configs.Frozen {
+		value = "1"
+	}
+	if err := writeFile(dir, "cgroup.freeze", value); err != nil {
+		return err
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		events, err := readFlatKeyed(dir, "cgroup.events")
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(events["frozen"]) == value {
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	procs := describeProcs(dir)
+	if err := writeFile(dir, "cgroup.freeze", "0"); err != nil {
+		return fmt.Errorf("cgroup %s did not become %s within %s (processes: %s) and cannot be thawed: %v", dir, state, timeout, procs, err)
+	}
+	return fmt.Errorf("cgroup %s did not become %s within %s (processes: %s)", dir, state, timeout, procs)
+}
+
+func describeProcs(dir string) string {
+	pids, err := cgroups.GetAllPids(dir)
+	if err != nil {
+		return err.Error()
+	}
+	procs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		state := "?"
+		if stat, err := system.Stat(pid); err == nil {
+			state = string(stat.State)
+		}
+		procs = append(procs, fmt.Sprintf("%d (%s)", pid, state))
+	}
+	return strings.Join(procs, ", ")
+}
@@ -0,0 +1,330 @@
+// +build linux
+
+// Package fs2 manages the cgroups of containers in the cgroup v2 unified
+// hierarchy, where a container has a single cgroup for all its controllers.
+package fs2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/cgroups/devices"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf/devicefilter"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+	libcontainerUtils "github.com/opencontainers/runc/libcontainer/utils"
+)
+
+// UnifiedKey is the key of the cgroup of a container in the paths of a
+// Manager, as in those parsed from /proc/<pid>/cgroup.
+const UnifiedKey = ""
+
+type Manager struct {
+	mu      sync.Mutex
+	Cgroups *configs.Cgroup
+	// Paths maps UnifiedKey to the cgroup of the container.
+	Paths map[string]string
+
+	// pressure keeps the pressure files open between calls to GetStats.
+	pressure cgroups.PressureFiles
+	// warnings are those of Set not yet taken by TakeWarnings.
+	warnings []configs.Warning
+}
+
+// dirPath returns the path of the cgroup of c.
+func dirPath(c *configs.Cgroup) (string, error) {
+	if (c.Name != "" || c.Parent != "") && c.Path != "" {
+		return "", fmt.Errorf("cgroup: either Path or Name and Parent should be used")
+	}
+	// XXX: Do not remove this code. Path safety is important! -- cyphar
+	innerPath := libcontainerUtils.CleanPath(c.Path)
+	if innerPath == "" {
+		innerPath = filepath.Join(libcontainerUtils.CleanPath(c.Parent), libcontainerUtils.CleanPath(c.Name))
+	}
+	return cgroups.UnifiedPath(innerPath)
+}
+
+func (m *Manager) Apply(pid int) error {
+	if m.Cgroups == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.Cgroups
+	if err := cgroups.ResolvePercentages(c.Resources); err != nil {
+		return err
+	}
+	if c.Paths != nil {
+		m.Paths = make(map[string]string)
+		if path := c.Paths[UnifiedKey]; path != "" {
+			m.Paths[UnifiedKey] = path
+		}
+		return cgroups.EnterPid(m.Paths, pid)
+	}
+
+	dir, err := dirPath(c)
+	if err != nil {
+		return err
+	}
+	if err := createCgroup(cgroups.UnifiedMountpoint, dir); err != nil {
+		return err
+	}
+	if c.Threaded {
+		if err := writeFile(dir, "cgroup.type", "threaded"); err != nil {
+			return err
+		}
+	}
+	// The device rules are enforced before the process enters the cgroup.
+	if err := m.setDevices(dir, c.Resources); err != nil {
+		return err
+	}
+	if err := cgroups.WriteCgroupProc(dir, pid); err != nil {
+		return err
+	}
+	m.Paths = map[string]string{UnifiedKey: dir}
+	return nil
+}
+
+// createCgroup creates the cgroup dir below root, along with its missing
+// ancestors, enabling on each of them the controllers it has for its
+// children.
+func createCgroup(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("cgroup %s is not below %s", dir, root)
+	}
+	current := root
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		enableControllers(current)
+		current = filepath.Join(current, elem)
+		if err := os.Mkdir(current, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableControllers enables the controllers of the cgroup dir for its
+// children. They are enabled one at a time if they cannot be all at once:
+// a cgroup with processes of its own cannot enable the domain controllers,
+// and neither can a threaded one, whose children go without.
+func enableControllers(dir string) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		logrus.Debugf("reading the controllers of %s: %v", dir, err)
+		return
+	}
+	controllers := strings.Fields(string(data))
+	if len(controllers) == 0 {
+		return
+	}
+	if writeFile(dir, "cgroup.subtree_control", "+"+strings.Join(controllers, " +")) == nil {
+		return
+	}
+	for _, c := range controllers {
+		if err := writeFile(dir, "cgroup.subtree_control", "+"+c); err != nil {
+			logrus.Debugf("enabling the %s controller of %s: %v", c, dir, err)
+		}
+	}
+}
+
+func (m *Manager) Destroy() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pressure.Close()
+	if m.Cgroups.Paths != nil {
+		return nil
+	}
+	if err := cgroups.RemovePaths(m.Paths); err != nil {
+		return err
+	}
+	m.Paths = make(map[string]string)
+	return nil
+}
+
+func (m *Manager) GetPaths() map[string]string {
+	m.mu.Lock()
+	paths := m.Paths
+	m.mu.Unlock()
+	return paths
+}
+
+func (m *Manager) dir() string {
+	return m.GetPaths()[UnifiedKey]
+}
+
+func (m *Manager) GetPids() ([]int, error) {
+	return cgroups.GetPids(m.dir())
+}
+
+func (m *Manager) GetAllPids() ([]int, error) {
+	return cgroups.GetAllPids(m.dir())
+}
+
+func (m *Manager) GetStats() (*cgroups.Stats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir := m.Paths[UnifiedKey]
+	strict := m.Cgroups != nil && m.Cgroups.StrictStats
+	stats := cgroups.NewStats()
+	for _, s := range []struct {
+		name string
+		get  func(string, *cgroups.Stats) error
+	}{
+		{"memory", statMemory},
+		{"cpu", statCpu},
+		{"pids", statPids},
+		{"io", statIo},
+	} {
+		if err := s.get(dir, stats); err != nil {
+			if strict {
+				return nil, err
+			}
+			stats.AddError(s.name, err)
+		}
+	}
+	// The pressure files of every resource are in the same cgroup.
+	paths := map[string]string{"cpu": dir, "memory": dir, "blkio": dir}
+	if err := m.pressure.GetStats(paths, stats, strict); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (m *Manager) Set(container *configs.Config) error {
+	// If Paths are set, then we are just joining cgroups paths
+	// and there is no need to set any values.
+	if m.Cgroups.Paths != nil {
+		return nil
+	}
+	r := container.Cgroups.Resources
+	if err := cgroups.ResolvePercentages(r); err != nil {
+		return err
+	}
+	if err := checkResources(r); err != nil {
+		return err
+	}
+	dir := m.dir()
+	m.mu.Lock()
+	err := m.setDevices(dir, r)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, set := range []func(string, *configs.Resources) error{
+		setMemory,
+		setCpu,
+		setCpuset,
+		setPids,
+		setIo,
+		setHugetlb,
+		setFreezer,
+	} {
+		if err := set(dir, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) TakeWarnings() []configs.Warning {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	warnings := m.warnings
+	m.warnings = nil
+	return warnings
+}
+
+// checkResources returns an error for the resources which only exist on
+// cgroup v1.
+func checkResources(r *configs.Resources) error {
+	for _, v1 := range []struct {
+		name string
+		set  bool
+	}{
+		{"kernel memory", r.KernelMemory != 0},
+		{"kernel TCP memory", r.KernelMemoryTCP != 0},
+		{"memory swappiness", r.MemorySwappiness != nil},
+		{"disabling the OOM killer", r.OomKillDisable},
+		{"realtime CPU scheduling", r.CpuRtRuntime != 0 || r.CpuRtPeriod != 0},
+		{"blkio leaf weight", r.BlkioLeafWeight != 0},
+		{"net_cls class id", r.NetClsClassid != 0},
+		{"net_prio priorities", len(r.NetPrioIfpriomap) > 0},
+	} {
+		if v1.set {
+			return fmt.Errorf("%s is not supported on cgroup v2", v1.name)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) Freeze(state configs.FreezerState, timeout time.Duration) error {
+	prevState := m.Cgroups.Resources.Freezer
+	m.Cgroups.Resources.Freezer = state
+	if err := setFreezerState(m.dir(), state, timeout); err != nil {
+		m.Cgroups.Resources.Freezer = prevState
+		return err
+	}
+	return nil
+}
+
+func writeFile(dir, file, data string) error {
+	// Normally dir should not be empty, one case is that the cgroup was
+	// not created, we will get empty dir, and we want it fail here.
+	if dir == "" {
+		return fmt.Errorf("no such directory for %s", file)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0700); err != nil {
+		return fmt.Errorf("failed to write %v to %v: %v", data, file, err)
+	}
+	return nil
+}
+
+func readFile(dir, file string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	return string(data), err
+}
+
+// setDevices enforces the device rules of r on the cgroup dir with a device
+// filter, replacing the one attached before. Filters cannot be attached
+// from a user namespace, where the rules are left unenforced with a
+// warning. It is called with m.mu held.
+func (m *Manager) setDevices(dir string, r *configs.Resources) error {
+	if r == nil {
+		return nil
+	}
+	rules := devices.Rules(r)
+	if len(rules) == 0 {
+		return nil
+	}
+	if system.RunningInUserNS() {
+		m.warnings = append(m.warnings, configs.Warning{
+			Code:      configs.WarnDevicesNotEnforced,
+			FieldPath: "cgroups.resources.devices",
+			Message:   fmt.Sprintf("the device rules of cgroup %s are not enforced in a user namespace", dir),
+		})
+		return nil
+	}
+	insts, license, err := devicefilter.DeviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := ebpf.LoadAttachCgroupDeviceFilter(insts, license, int(f.Fd())); err != nil {
+		return fmt.Errorf("enforcing the device rules of cgroup %s: %v", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// +build linux
+
+package fs2
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// newMockCgroup returns a directory standing for a cgroup, with the files
+// of contents.
+func newMockCgroup(t *testing.T, contents map[string]string) string {
+	dir, err := ioutil.TempDir("", "fs2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func expectFile(t *testing.T, dir, file, expected string) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("expected %q in %s, got %q", expected, file, data)
+	}
+}
+
+func TestCreateCgroup(t *testing.T) {
+	root := newMockCgroup(t, map[string]string{"cgroup.controllers": "cpu memory pids\n"})
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "a", "b")
+	if err := createCgroup(root, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, root, "cgroup.subtree_control", "+cpu +memory +pids")
+	if err := createCgroup(root, filepath.Dir(root)); err == nil {
+		t.Fatal("expected a cgroup outside of the hierarchy to be rejected")
+	}
+}
+
+func TestSet(t *testing.T) {
+	dir := newMockCgroup(t, nil)
+	defer os.RemoveAll(dir)
+	m := &Manager{Cgroups: &configs.Cgroup{}, Paths: map[string]string{UnifiedKey: dir}}
+	config := &configs.Config{Cgroups: &configs.Cgroup{Resources: &configs.Resources{
+		Memory:            1 << 30,
+		MemorySwap:        3 << 29,
+		MemoryReservation: 1 << 29,
+		CpuShares:         1024,
+		CpuQuota:          50000,
+		CpusetCpus:        "0-1",
+		PidsLimit:         10,
+		BlkioWeight:       500,
+		BlkioThrottleReadBpsDevice: []*configs.ThrottleDevice{
+			configs.NewThrottleDevice(8, 0, 1048576),
+		},
+	}}}
+	if err := m.Set(config); err != nil {
+		t.Fatal(err)
+	}
+	for file, expected := range map[string]string{
+		"memory.max":      "1073741824",
+		"memory.swap.max": "536870912",
+		"memory.low":      "536870912",
+		"cpu.weight":      "39",
+		"cpu.max":         "50000 100000",
+		"cpuset.cpus":     "0-1",
+		"pids.max":        "10",
+		"io.weight":       "default 4950",
+		"io.max":          "8:0 rbps=1048576",
+	} {
+		expectFile(t, dir, file, expected)
+	}
+
+	config.Cgroups.Resources = &configs.Resources{KernelMemory: 1 << 20}
+	if err := m.Set(config); err == nil || !strings.Contains(err.Error(), "kernel memory") {
+		t.Fatalf("expected the kernel memory limit to be rejected, got %v", err)
+	}
+}
+
+func TestSetEnforcesDevices(t *testing.T) {
+	dir := newMockCgroup(t, nil)
+	defer os.RemoveAll(dir)
+	m := &Manager{Cgroups: &configs.Cgroup{}, Paths: map[string]string{UnifiedKey: dir}}
+	config := &configs.Config{Cgroups: &configs.Cgroup{Resources: &configs.Resources{
+		Devices: []*configs.Device{{Type: 'c', Major: 1, Minor: 3, Permissions: "rwm", Allow: true}},
+	}}}
+	// The device rules are no longer left unenforced, so a mock cgroup,
+	// which a device filter cannot be attached to, fails.
+	if err := m.Set(config); err == nil || !strings.Contains(err.Error(), "device rules") {
+		t.Fatalf("expected the device rules to be enforced, got %v", err)
+	}
+	if warnings := m.TakeWarnings(); len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %+v", warnings)
+	}
+}
+
+func TestSwapLimit(t *testing.T) {
+	for _, c := range []struct {
+		memory, memorySwap int64
+		expected           string
+		fails              bool
+	}{
+		{0, 0, "", false},
+		{1000, -1, "max", false},
+		{1000, 1000, "0", false},
+		{1000, 3000, "2000", false},
+		{0, 3000, "", true},
+		{-1, 3000, "", true},
+		{3000, 1000, "", true},
+	} {
+		swap, err := swapLimit(c.memory, c.memorySwap)
+		if (err != nil) != c.fails || swap != c.expected {
+			t.Errorf("expected %q (failing: %v) for %d and %d, got %q, %v", c.expected, c.fails, c.memory, c.memorySwap, swap, err)
+		}
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	dir := newMockCgroup(t, map[string]string{
+		"memory.stat":    "anon 4096\nfile 8192\n",
+		"memory.current": "12288\n",
+		"memory.max":     "max\n",
+		"memory.events":  "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"cpu.stat":       "usage_usec 100\nuser_usec 60\nsystem_usec 40\nnr_periods 5\nnr_throttled 2\nthrottled_usec 7\n",
+		"pids.current":   "4\n",
+		"pids.max":       "max\n",
+		"io.stat":        "8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0\n",
+	})
+	defer os.RemoveAll(dir)
+	m := &Manager{Cgroups: &configs.Cgroup{}, Paths: map[string]string{UnifiedKey: dir}}
+	stats, err := m.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Errors) > 0 {
+		t.Fatalf("unexpected errors %v", stats.Errors)
+	}
+	mem := stats.MemoryStats
+	if mem.Cache != 8192 || mem.Stats["anon"] != 4096 || mem.Usage.Usage != 12288 || mem.Usage.Limit != math.MaxUint64 || mem.Usage.Failcnt != 3 {
+		t.Errorf("unexpected memory stats %+v", mem)
+	}
+	cpu := stats.CpuStats
+	if cpu.CpuUsage.TotalUsage != 100000 || cpu.CpuUsage.UsageInUsermode != 60000 || cpu.CpuUsage.UsageInKernelmode != 40000 ||
+		cpu.ThrottlingData.Periods != 5 || cpu.ThrottlingData.ThrottledPeriods != 2 || cpu.ThrottlingData.ThrottledTime != 7000 {
+		t.Errorf("unexpected cpu stats %+v", cpu)
+	}
+	if stats.PidsStats.Current != 4 || stats.PidsStats.Limit != 0 {
+		t.Errorf("unexpected pids stats %+v", stats.PidsStats)
+	}
+	bytes := stats.BlkioStats.IoServiceBytesRecursive
+	if len(bytes) != 2 || bytes[0].Op != "Read" || bytes[0].Value != 1024 || bytes[1].Op != "Write" || bytes[1].Value != 2048 || bytes[0].Major != 8 {
+		t.Errorf("unexpected io stats %+v", bytes)
+	}
+	if ios := stats.BlkioStats.IoServicedRecursive; len(ios) != 2 || ios[1].Value != 2 {
+		t.Errorf("unexpected io stats %+v", ios)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	dir := newMockCgroup(t, map[string]string{"cgroup.events": "populated 1\nfrozen 1\n"})
+	defer os.RemoveAll(dir)
+	m := &Manager{Cgroups: &configs.Cgroup{Resources: &configs.Resources{}}, Paths: map[string]string{UnifiedKey: dir}}
+	if err := m.Freeze(configs.Frozen, 0); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, dir, "cgroup.freeze", "1")
+	if m.Cgroups.Resources.Freezer != configs.Frozen {
+		t.Fatalf("expected the cgroup to be recorded as frozen, got %s", m.Cgroups.Resources.Freezer)
+	}
+
+	// The events of the mock never change, so thawing times out.
+	if err := m.Freeze(configs.Thawed, 10*time.Millisecond); err == nil {
+		t.Fatal("expected thawing to time out")
+	}
+	if m.Cgroups.Resources.Freezer != configs.Frozen {
+		t.Fatalf("expected the state to be kept on failure, got %s", m.Cgroups.Resources.Freezer)
+	}
+}
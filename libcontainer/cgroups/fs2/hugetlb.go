@@ -0,0 +1,18 @@
+// +build linux
+
+package fs2
+
+import (
+	"strconv"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func setHugetlb(dir string, r *configs.Resources) error {
+	for _, hugetlb := range r.HugetlbLimit {
+		if err := writeFile(dir, "hugetlb."+hugetlb.Pagesize+".max", strconv.FormatUint(hugetlb.Limit, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// +build linux
+
+package fs2
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// ioWeight converts the blkio weight of cgroup v1, from 10 to 1000, into
+// the IO weight of cgroup v2, from 1 to 10000.
+func ioWeight(weight uint16) uint64 {
+	w := uint64(weight)
+	if w < 10 {
+		w = 10
+	}
+	if w > 1000 {
+		w = 1000
+	}
+	return 1 + (w-10)*9999/990
+}
+
+func setIo(dir string, r *configs.Resources) error {
+	if r.BlkioWeight != 0 {
+		if err := writeFile(dir, "io.weight", fmt.Sprintf("default %d", ioWeight(r.BlkioWeight))); err != nil {
+			return err
+		}
+	}
+	for _, wd := range r.BlkioWeightDevice {
+		if wd.Weight == 0 {
+			continue
+		}
+		if err := writeFile(dir, "io.weight", fmt.Sprintf("%d:%d %d", wd.Major, wd.Minor, ioWeight(wd.Weight))); err != nil {
+			return err
+		}
+	}
+	for _, t := range []struct {
+		key     string
+		devices []*configs.ThrottleDevice
+	}{
+		{"rbps", r.BlkioThrottleReadBpsDevice},
+		{"wbps", r.BlkioThrottleWriteBpsDevice},
+		{"riops", r.BlkioThrottleReadIOPSDevice},
+		{"wiops", r.BlkioThrottleWriteIOPSDevice},
+	} {
+		for _, td := range t.devices {
+			// A rate of 0 lifts the limit, like on cgroup v1.
+			rate := "max"
+			if td.Rate != 0 {
+				rate = strconv.FormatUint(td.Rate, 10)
+			}
+			if err := writeFile(dir, "io.max", fmt.Sprintf("%d:%d %s=%s", td.Major, td.Minor, t.key, rate)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// statIo reads io.stat, where each line holds the counters of a device:
+//
+//	8:0 rbytes=90112 wbytes=0 rios=3 wios=0 dbytes=0 dios=0
+func statIo(dir string, stats *cgroups.Stats) error {
+	f, err := os.Open(filepath.Join(dir, "io.stat"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var major, minor uint64
+		if _, err := fmt.Sscanf(fields[0], "%d:%d", &major, &minor); err != nil {
+			return fmt.Errorf("unable to parse io.stat: invalid device %q", fields[0])
+		}
+		for _, kv := range fields[1:] {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("unable to parse io.stat: invalid counter %q", kv)
+			}
+			v, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("unable to parse io.stat: %v", err)
+			}
+			entry := cgroups.BlkioStatEntry{Major: major, Minor: minor, Value: v}
+			switch parts[0] {
+			case "rbytes":
+				entry.Op = "Read"
+				stats.BlkioStats.IoServiceBytesRecursive = append(stats.BlkioStats.IoServiceBytesRecursive, entry)
+			case "wbytes":
+				entry.Op = "Write"
+				stats.BlkioStats.IoServiceBytesRecursive = append(stats.BlkioStats.IoServiceBytesRecursive, entry)
+			case "rios":
+				entry.Op = "Read"
+				stats.BlkioStats.IoServicedRecursive = append(stats.BlkioStats.IoServicedRecursive, entry)
+			case "wios":
+				entry.Op = "Write"
+				stats.BlkioStats.IoServicedRecursive = append(stats.BlkioStats.IoServicedRecursive, entry)
+			}
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,95 @@
+// +build linux
+
+package fs2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// swapLimit converts the memory+swap limit of cgroup v1 into the value of
+// memory.swap.max on cgroup v2, which excludes the memory. It returns an
+// empty string for no setting.
+func swapLimit(memory, memorySwap int64) (string, error) {
+	switch {
+	case memorySwap == 0:
+		return "", nil
+	case memorySwap == -1:
+		return "max", nil
+	case memory == 0 || memory == -1:
+		return "", fmt.Errorf("a memory+swap limit requires a memory limit")
+	case memorySwap < memory:
+		return "", fmt.Errorf("the memory+swap limit %d is below the memory limit %d", memorySwap, memory)
+	}
+	return strconv.FormatInt(memorySwap-memory, 10), nil
+}
+
+func setMemory(dir string, r *configs.Resources) error {
+	swap, err := swapLimit(r.Memory, r.MemorySwap)
+	if err != nil {
+		return err
+	}
+	// The swap limit is set first, as it is checked against the memory
+	// limit when it is lowered.
+	if swap != "" {
+		// Swap may be disabled on the host, where it needs no lifting.
+		if _, err := os.Stat(filepath.Join(dir, "memory.swap.max")); err == nil || swap != "max" {
+			if err := writeFile(dir, "memory.swap.max", swap); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Memory != 0 {
+		if err := writeFile(dir, "memory.max", formatLimit(r.Memory)); err != nil {
+			return err
+		}
+	}
+	if r.MemoryReservation != 0 {
+		if err := writeFile(dir, "memory.low", formatLimit(r.MemoryReservation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statMemory(dir string, stats *cgroups.Stats) error {
+	values, err := readFlatKeyed(dir, "memory.stat")
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		stats.MemoryStats.Stats[k] = v
+	}
+	stats.MemoryStats.Cache = values["file"]
+	// Memory is always accounted hierarchically.
+	stats.MemoryStats.UseHierarchy = true
+
+	usage := &stats.MemoryStats.Usage
+	if usage.Usage, err = readUint(dir, "memory.current"); err != nil {
+		return err
+	}
+	if usage.Limit, err = readUint(dir, "memory.max"); err != nil {
+		return err
+	}
+	events, err := readFlatKeyed(dir, "memory.events")
+	if err != nil {
+		return err
+	}
+	// The number of times the limit was hit.
+	usage.Failcnt = events["max"]
+
+	// The swap files are missing when swap is disabled on the host.
+	swap := &stats.MemoryStats.SwapUsage
+	if swap.Usage, err = readUint(dir, "memory.swap.current"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if swap.Limit, err = readUint(dir, "memory.swap.max"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+// +build linux
+
+package fs2
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func setPids(dir string, r *configs.Resources) error {
+	if r.PidsLimit == 0 {
+		return nil
+	}
+	// "max" is the fallback value.
+	limit := "max"
+	if r.PidsLimit > 0 {
+		limit = strconv.FormatInt(r.PidsLimit, 10)
+	}
+	return writeFile(dir, "pids.max", limit)
+}
+
+func statPids(dir string, stats *cgroups.Stats) error {
+	current, err := readUint(dir, "pids.current")
+	if err != nil {
+		return err
+	}
+	limit, err := readUint(dir, "pids.max")
+	if err != nil {
+		return err
+	}
+	// Like on cgroup v1, no limit is reported as 0.
+	if limit == math.MaxUint64 {
+		limit = 0
+	}
+	stats.PidsStats.Current = current
+	stats.PidsStats.Limit = limit
+	return nil
+}
@@ -0,0 +1,67 @@
+// +build linux
+
+package fs2
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseUint parses the value of a cgroup file, where "max" stands for no
+// limit.
+func parseUint(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "max" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func readUint(dir, file string) (uint64, error) {
+	data, err := readFile(dir, file)
+	if err != nil {
+		return 0, err
+	}
+	v, err := parseUint(data)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %s: %v", file, err)
+	}
+	return v, nil
+}
+
+// readFlatKeyed reads a file of "key value" lines, such as memory.stat.
+func readFlatKeyed(dir, file string) (map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]uint64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unable to parse %s: invalid line %q", file, s.Text())
+		}
+		v, err := parseUint(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %v", file, err)
+		}
+		values[fields[0]] = v
+	}
+	return values, s.Err()
+}
+
+// formatLimit formats a limit of a cgroup file, where -1 stands for no
+// limit.
+func formatLimit(v int64) string {
+	if v == -1 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
@@ -0,0 +1,129 @@
+// +build linux
+
+package cgroups
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// IdentityXattr is the extended attribute in which the identity of the
+// container owning a cgroup is recorded on the cgroup's directory.
+const IdentityXattr = "trusted.runc.id"
+
+// maxIdentitySize bounds the size of an identity read back from a cgroup.
+const maxIdentitySize = 4096
+
+// Identity identifies the container which owns a cgroup, so that tools
+// holding just the cgroup (e.g. its id from BPF) can attribute it without
+// reading runc's state.
+type Identity struct {
+	// ID is the id of the container.
+	ID string `json:"id"`
+	// BundleHash is the hex encoded SHA-256 of the container's bundle path.
+	BundleHash string `json:"bundle_hash,omitempty"`
+}
+
+// NewIdentity returns the identity of the container id with the given
+// bundle path.
+func NewIdentity(id, bundle string) Identity {
+	ident := Identity{ID: id}
+	if bundle != "" {
+		sum := sha256.Sum256([]byte(bundle))
+		ident.BundleHash = hex.EncodeToString(sum[:])
+	}
+	return ident
+}
+
+// WriteIdentity records ident on each of the cgroup directories in paths.
+// Filesystems which don't support trusted xattrs, like cgroup v1 hierarchies
+// on older kernels, are skipped.
+func WriteIdentity(paths map[string]string, ident Identity) error {
+	data, err := json.Marshal(ident)
+	if err != nil {
+		return err
+	}
+	for _, path := range uniquePaths(paths) {
+		if err := unix.Setxattr(path, IdentityXattr, data, 0); err != nil {
+			if err == unix.ENOTSUP || err == unix.EOPNOTSUPP || os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("setting %s on %s: %v", IdentityXattr, path, err)
+		}
+	}
+	return nil
+}
+
+// RemoveIdentity removes the identity recorded by WriteIdentity, for cgroups
+// which outlive the container.
+func RemoveIdentity(paths map[string]string) {
+	for _, path := range uniquePaths(paths) {
+		unix.Removexattr(path, IdentityXattr)
+	}
+}
+
+// CgroupFdToContainer returns the identity recorded on the cgroup directory
+// opened as fd.
+func CgroupFdToContainer(fd int) (*Identity, error) {
+	return readIdentity(fmt.Sprintf("/proc/self/fd/%d", fd))
+}
+
+// CgroupIDToContainer returns the identity recorded on the cgroup with the
+// given id, as reported by BPF helpers such as bpf_get_current_cgroup_id,
+// within the cgroup v2 hierarchy mounted where mountFd is opened. This
+// requires CAP_DAC_READ_SEARCH.
+func CgroupIDToContainer(mountFd int, cgroupID uint64) (*Identity, error) {
+	// The kernfs file handle of a cgroup is its 64-bit id.
+	handle := struct {
+		bytes uint32
+		typ   int32
+		id    uint64
+	}{
+		bytes: 8,
+		typ:   fileidKernfs,
+		id:    cgroupID,
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_OPEN_BY_HANDLE_AT, uintptr(mountFd), uintptr(unsafe.Pointer(&handle)), uintptr(unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC))
+	if errno != 0 {
+		return nil, fmt.Errorf("opening cgroup %d: %v", cgroupID, errno)
+	}
+	defer unix.Close(int(fd))
+	return CgroupFdToContainer(int(fd))
+}
+
+// fileidKernfs is the FILEID_KERNFS type of kernfs file handles.
+const fileidKernfs = 0xfe
+
+func readIdentity(path string) (*Identity, error) {
+	buf := make([]byte, maxIdentitySize)
+	n, err := unix.Getxattr(path, IdentityXattr, buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s of %s: %v", IdentityXattr, path, err)
+	}
+	var ident Identity
+	if err := json.Unmarshal(buf[:n], &ident); err != nil {
+		return nil, fmt.Errorf("parsing %s of %s: %v", IdentityXattr, path, err)
+	}
+	return &ident, nil
+}
+
+// uniquePaths returns the distinct paths in paths, as co-mounted controllers
+// share a directory.
+func uniquePaths(paths map[string]string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, path := range paths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		unique = append(unique, path)
+	}
+	return unique
+}
@@ -0,0 +1,48 @@
+// +build linux
+
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testidentity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := unix.Setxattr(dir, IdentityXattr, []byte("{}"), 0); err != nil {
+		t.Skipf("trusted xattrs are not supported: %v", err)
+	}
+
+	ident := NewIdentity("myid", "/bundle")
+	paths := map[string]string{"cpu": dir, "cpuacct": dir}
+	if err := WriteIdentity(paths, ident); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := CgroupFdToContainer(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != ident {
+		t.Fatalf("expected identity %+v but received %+v", ident, *got)
+	}
+	if len(got.BundleHash) != 64 {
+		t.Fatalf("expected a SHA-256 bundle hash, received %q", got.BundleHash)
+	}
+
+	RemoveIdentity(paths)
+	if _, err := CgroupFdToContainer(int(f.Fd())); err == nil {
+		t.Fatal("expected the identity to be removed")
+	}
+}
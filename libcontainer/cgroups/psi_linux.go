@@ -0,0 +1,155 @@
+// +build linux
+
+package cgroups
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// PressureFiles reads the pressure files of a cgroup, keeping them open so
+// that sampling the stats repeatedly does not reopen them every time. Its
+// zero value is ready to use.
+type PressureFiles struct {
+	// files maps the paths of the pressure files to their open file, or to
+	// nil if the kernel does not provide them.
+	files map[string]*os.File
+}
+
+// GetStats sets the PSI of the cpu, memory and blkio stats from the
+// pressure files found under the paths of these subsystems. Stats whose
+// file is missing, as when the kernel is built without CONFIG_PSI, are left
+// nil. Unless strict is set, errors are recorded in stats instead of being
+// returned.
+func (p *PressureFiles) GetStats(paths map[string]string, stats *Stats, strict bool) error {
+	for _, r := range []struct {
+		subsystem string
+		file      string
+		psi       **PSIStats
+	}{
+		{"cpu", "cpu.pressure", &stats.CpuStats.PSI},
+		{"memory", "memory.pressure", &stats.MemoryStats.PSI},
+		{"blkio", "io.pressure", &stats.BlkioStats.PSI},
+	} {
+		dir, ok := paths[r.subsystem]
+		if !ok {
+			continue
+		}
+		psi, err := p.read(filepath.Join(dir, r.file))
+		if err != nil {
+			if strict {
+				return err
+			}
+			stats.AddError(r.file, err)
+			continue
+		}
+		*r.psi = psi
+	}
+	return nil
+}
+
+func (p *PressureFiles) read(path string) (*PSIStats, error) {
+	f, ok := p.files[path]
+	if !ok {
+		var err error
+		if f, err = os.Open(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if p.files == nil {
+			p.files = make(map[string]*os.File)
+		}
+		p.files[path] = f
+	}
+	if f == nil {
+		return nil, nil
+	}
+	data, err := readFrom(f)
+	if err != nil {
+		if err == unix.EOPNOTSUPP || err == unix.ENOTSUP {
+			// The files exist but PSI was disabled at boot.
+			return nil, nil
+		}
+		// The cgroup may be gone, so open the file again next time.
+		f.Close()
+		delete(p.files, path)
+		return nil, err
+	}
+	return parsePSI(data)
+}
+
+// readFrom reads f from its start.
+func readFrom(f *os.File) ([]byte, error) {
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if perr, ok := err.(*os.PathError); ok {
+		err = perr.Err
+	}
+	return data, err
+}
+
+// Close closes the pressure files.
+func (p *PressureFiles) Close() {
+	for _, f := range p.files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	p.files = nil
+}
+
+// parsePSI parses the contents of a pressure file, such as
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//
+// Unknown lines and fields are ignored.
+func parsePSI(data []byte) (*PSIStats, error) {
+	psi := &PSIStats{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var d *PSIData
+		switch fields[0] {
+		case "some":
+			d = &psi.Some
+		case "full":
+			d = &psi.Full
+		default:
+			continue
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid pressure field %q", field)
+			}
+			var err error
+			switch kv[0] {
+			case "avg10":
+				d.Avg10, err = strconv.ParseFloat(kv[1], 64)
+			case "avg60":
+				d.Avg60, err = strconv.ParseFloat(kv[1], 64)
+			case "avg300":
+				d.Avg300, err = strconv.ParseFloat(kv[1], 64)
+			case "total":
+				d.Total, err = strconv.ParseUint(kv[1], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid pressure field %q: %v", field, err)
+			}
+		}
+	}
+	return psi, s.Err()
+}
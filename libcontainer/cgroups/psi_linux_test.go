@@ -0,0 +1,67 @@
+// +build linux
+
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePSI(t *testing.T) {
+	psi, err := parsePSI([]byte("some avg10=1.50 avg60=0.25 avg300=0.00 total=1234\nfull avg10=0.10 avg60=0.00 avg300=0.00 total=56\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if psi.Some.Avg10 != 1.5 || psi.Some.Avg60 != 0.25 || psi.Some.Total != 1234 {
+		t.Errorf("unexpected some pressure %+v", psi.Some)
+	}
+	if psi.Full.Avg10 != 0.1 || psi.Full.Total != 56 {
+		t.Errorf("unexpected full pressure %+v", psi.Full)
+	}
+	if _, err := parsePSI([]byte("some avg10=x\n")); err == nil {
+		t.Error("expected an invalid average to be rejected")
+	}
+}
+
+func TestPressureFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pressure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpu := filepath.Join(dir, "cpu.pressure")
+	if err := ioutil.WriteFile(cpu, []byte("some avg10=1.00 avg60=0.00 avg300=0.00 total=10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{"cpu": dir, "memory": dir}
+
+	var p PressureFiles
+	defer p.Close()
+	stats := NewStats()
+	if err := p.GetStats(paths, stats, true); err != nil {
+		t.Fatal(err)
+	}
+	if stats.CpuStats.PSI == nil || stats.CpuStats.PSI.Some.Total != 10 {
+		t.Fatalf("expected the cpu pressure, got %+v", stats.CpuStats.PSI)
+	}
+	if stats.MemoryStats.PSI != nil || stats.BlkioStats.PSI != nil {
+		t.Fatal("expected no pressure without a pressure file")
+	}
+
+	f := p.files[cpu]
+	if err := ioutil.WriteFile(cpu, []byte("some avg10=2.00 avg60=0.00 avg300=0.00 total=20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats = NewStats()
+	if err := p.GetStats(paths, stats, true); err != nil {
+		t.Fatal(err)
+	}
+	if stats.CpuStats.PSI == nil || stats.CpuStats.PSI.Some.Total != 20 {
+		t.Fatalf("expected the new cpu pressure, got %+v", stats.CpuStats.PSI)
+	}
+	if p.files[cpu] != f {
+		t.Fatal("expected the cpu pressure file to be kept open between reads")
+	}
+}
@@ -4,6 +4,7 @@ package rootless
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/cgroups/fs"
@@ -116,7 +117,7 @@ func (m *Manager) GetStats() (*cgroups.Stats, error) {
 	return nil, fmt.Errorf("cannot get cgroup stats in rootless container")
 }
 
-func (m *Manager) Freeze(state configs.FreezerState) error {
+func (m *Manager) Freeze(state configs.FreezerState, timeout time.Duration) error {
 	// TODO(cyphar): We can make this work if we figure out a way to allow usage
 	//               of cgroups with a rootless container.
 	return fmt.Errorf("cannot use freezer cgroup in rootless container")
@@ -11,6 +11,22 @@ type ThrottlingData struct {
 	ThrottledTime uint64 `json:"throttled_time,omitempty"`
 }
 
+// PSIData holds the pressure stall averages, as percentages of the last 10,
+// 60 and 300 seconds, and the total stall time in microseconds.
+type PSIData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+// PSIStats holds the pressure stall information of a resource, for when
+// some of the tasks of the cgroup stalled and when all of them did.
+type PSIStats struct {
+	Some PSIData `json:"some,omitempty"`
+	Full PSIData `json:"full,omitempty"`
+}
+
 // CpuUsage denotes the usage of a CPU.
 // All CPU stats are aggregate since container inception.
 type CpuUsage struct {
@@ -31,6 +47,8 @@ type CpuUsage struct {
 type CpuStats struct {
 	CpuUsage       CpuUsage       `json:"cpu_usage,omitempty"`
 	ThrottlingData ThrottlingData `json:"throttling_data,omitempty"`
+	// CPU pressure, or nil if the kernel does not report it.
+	PSI *PSIStats `json:"psi,omitempty"`
 }
 
 type MemoryData struct {
@@ -55,6 +73,8 @@ type MemoryStats struct {
 	UseHierarchy bool `json:"use_hierarchy"`
 
 	Stats map[string]uint64 `json:"stats,omitempty"`
+	// memory pressure, or nil if the kernel does not report it.
+	PSI *PSIStats `json:"psi,omitempty"`
 }
 
 type PidsStats struct {
@@ -64,6 +84,11 @@ type PidsStats struct {
 	Limit uint64 `json:"limit,omitempty"`
 }
 
+type NetClsStats struct {
+	// classid tagged on the packets of the cgroup
+	Classid uint32 `json:"classid,omitempty"`
+}
+
 type BlkioStatEntry struct {
 	Major uint64 `json:"major,omitempty"`
 	Minor uint64 `json:"minor,omitempty"`
@@ -81,6 +106,8 @@ type BlkioStats struct {
 	IoMergedRecursive       []BlkioStatEntry `json:"io_merged_recursive,omitempty"`
 	IoTimeRecursive         []BlkioStatEntry `json:"io_time_recursive,omitempty"`
 	SectorsRecursive        []BlkioStatEntry `json:"sectors_recursive,omitempty"`
+	// IO pressure, or nil if the kernel does not report it.
+	PSI *PSIStats `json:"psi,omitempty"`
 }
 
 type HugetlbStats struct {
@@ -97,8 +124,12 @@ type Stats struct {
 	MemoryStats MemoryStats `json:"memory_stats,omitempty"`
 	PidsStats   PidsStats   `json:"pids_stats,omitempty"`
 	BlkioStats  BlkioStats  `json:"blkio_stats,omitempty"`
+	NetClsStats NetClsStats `json:"net_cls_stats,omitempty"`
 	// the map is in the format "size of hugepage: stats of the hugepage"
 	HugetlbStats map[string]HugetlbStats `json:"hugetlb_stats,omitempty"`
+	// Errors maps the subsystems whose stats could not be read, which may be
+	// incomplete, to the error encountered.
+	Errors map[string]string `json:"errors,omitempty"`
 }
 
 func NewStats() *Stats {
@@ -106,3 +137,11 @@ func NewStats() *Stats {
 	hugetlbStats := make(map[string]HugetlbStats)
 	return &Stats{MemoryStats: memoryStats, HugetlbStats: hugetlbStats}
 }
+
+// AddError records that the stats of the subsystem name could not be read.
+func (s *Stats) AddError(name string, err error) {
+	if s.Errors == nil {
+		s.Errors = make(map[string]string)
+	}
+	s.Errors[name] = err.Error()
+}
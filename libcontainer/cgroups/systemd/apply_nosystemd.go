@@ -4,6 +4,7 @@ package systemd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -18,6 +19,10 @@ func UseSystemd() bool {
 	return false
 }
 
+func StartScope(unitName string, pid int) error {
+	return fmt.Errorf("Systemd not supported")
+}
+
 func (m *Manager) Apply(pid int) error {
 	return fmt.Errorf("Systemd not supported")
 }
@@ -46,7 +51,7 @@ func (m *Manager) Set(container *configs.Config) error {
 	return nil, fmt.Errorf("Systemd not supported")
 }
 
-func (m *Manager) Freeze(state configs.FreezerState) error {
+func (m *Manager) Freeze(state configs.FreezerState, timeout time.Duration) error {
 	return fmt.Errorf("Systemd not supported")
 }
 
@@ -5,6 +5,7 @@ package systemd
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -23,6 +24,9 @@ type Manager struct {
 	mu      sync.Mutex
 	Cgroups *configs.Cgroup
 	Paths   map[string]string
+
+	// pressure keeps the pressure files open between calls to GetStats.
+	pressure cgroups.PressureFiles
 }
 
 type subsystem interface {
@@ -75,8 +79,20 @@ var (
 	hasStartTransientSliceUnit      bool
 	hasTransientDefaultDependencies bool
 	hasDelegate                     bool
+	hasTasksMax                     bool
+	hasQuotaPeriod                  bool
 )
 
+// creationOnlyProperties are the properties which cannot be changed once a
+// unit is started.
+var creationOnlyProperties = map[string]bool{
+	"Slice":               true,
+	"Wants":               true,
+	"Delegate":            true,
+	"DefaultDependencies": true,
+	"PIDs":                true,
+}
+
 func newProp(name string, units interface{}) systemdDbus.Property {
 	return systemdDbus.Property{
 		Name:  name,
@@ -148,16 +164,10 @@ func UseSystemd() bool {
 		// Not critical because of the stop unit logic above.
 		theConn.StopUnit(scope, "replace", nil)
 
-		// Assume StartTransientUnit on a scope allows Delegate
-		hasDelegate = true
-		dl := newProp("Delegate", true)
-		if _, err := theConn.StartTransientUnit(scope, "replace", []systemdDbus.Property{dl}, nil); err != nil {
-			if dbusError, ok := err.(dbus.Error); ok {
-				if strings.Contains(dbusError.Name, "org.freedesktop.DBus.Error.PropertyReadOnly") {
-					hasDelegate = false
-				}
-			}
-		}
+		hasDelegate = scopeAllows(scope, newProp("Delegate", true))
+		// TasksMax and CPUQuotaPeriodUSec were added in systemd 227 and 242.
+		hasTasksMax = scopeAllows(scope, newProp("TasksMax", uint64(math.MaxUint64)))
+		hasQuotaPeriod = scopeAllows(scope, newProp("CPUQuotaPeriodUSec", uint64(100000)))
 
 		// Assume we have the ability to start a transient unit as a slice
 		// This was broken until systemd v229, but has been back-ported on RHEL environments >= 219
@@ -193,13 +203,27 @@ func UseSystemd() bool {
 	return hasStartTransientUnit
 }
 
+// scopeAllows returns true unless systemd refuses prop, as it does unknown
+// properties, when starting scope.
+func scopeAllows(scope string, prop systemdDbus.Property) bool {
+	_, err := theConn.StartTransientUnit(scope, "replace", []systemdDbus.Property{prop}, nil)
+	// Not critical because of the stop unit logic in UseSystemd.
+	theConn.StopUnit(scope, "replace", nil)
+	if dbusError, ok := err.(dbus.Error); ok {
+		return !strings.Contains(dbusError.Name, "org.freedesktop.DBus.Error.PropertyReadOnly")
+	}
+	return true
+}
+
 func (m *Manager) Apply(pid int) error {
 	var (
 		c          = m.Cgroups
 		unitName   = getUnitName(c)
-		slice      = "system.slice"
 		properties []systemdDbus.Property
 	)
+	if err := cgroups.ResolvePercentages(c.Resources); err != nil {
+		return err
+	}
 
 	if c.Paths != nil {
 		paths := make(map[string]string)
@@ -218,8 +242,13 @@ func (m *Manager) Apply(pid int) error {
 		return cgroups.EnterPid(m.Paths, pid)
 	}
 
-	if c.Parent != "" {
-		slice = c.Parent
+	slice, err := unitSlice(c)
+	if err != nil {
+		return err
+	}
+	extra, err := unitProperties(c)
+	if err != nil {
+		return err
 	}
 
 	properties = append(properties, systemdDbus.PropDescription("libcontainer container "+c.Name))
@@ -258,27 +287,7 @@ func (m *Manager) Apply(pid int) error {
 			newProp("DefaultDependencies", false))
 	}
 
-	if c.Resources.Memory != 0 {
-		properties = append(properties,
-			newProp("MemoryLimit", c.Resources.Memory))
-	}
-
-	if c.Resources.CpuShares != 0 {
-		properties = append(properties,
-			newProp("CPUShares", c.Resources.CpuShares))
-	}
-
-	// cpu.cfs_quota_us and cpu.cfs_period_us are controlled by systemd.
-	if c.Resources.CpuQuota != 0 && c.Resources.CpuPeriod != 0 {
-		cpuQuotaPerSecUSec := uint64(c.Resources.CpuQuota*1000000) / c.Resources.CpuPeriod
-		properties = append(properties,
-			newProp("CPUQuotaPerSecUSec", cpuQuotaPerSecUSec))
-	}
-
-	if c.Resources.BlkioWeight != 0 {
-		properties = append(properties,
-			newProp("BlockIOWeight", uint64(c.Resources.BlkioWeight)))
-	}
+	properties = mergeProperties(append(properties, resourceProperties(c.Resources)...), extra)
 
 	// We have to set kernel memory here, as we can't change it once
 	// processes have been attached to the cgroup.
@@ -289,7 +298,7 @@ func (m *Manager) Apply(pid int) error {
 	}
 
 	if _, err := theConn.StartTransientUnit(unitName, "replace", properties, nil); err != nil && !isUnitExists(err) {
-		return err
+		return propertyError(err, properties)
 	}
 
 	if err := joinCgroups(c, pid); err != nil {
@@ -312,7 +321,159 @@ func (m *Manager) Apply(pid int) error {
 	return nil
 }
 
+// StartScope starts the transient scope unitName holding pid, moving it out
+// of the unit of its parent so that it is not killed along with it.
+func StartScope(unitName string, pid int) error {
+	if !UseSystemd() {
+		return fmt.Errorf("systemd not running on this host, can't start a scope")
+	}
+	properties := []systemdDbus.Property{newProp("PIDs", []uint32{uint32(pid)})}
+	if hasTransientDefaultDependencies {
+		properties = append(properties, newProp("DefaultDependencies", false))
+	}
+	if _, err := theConn.StartTransientUnit(unitName, "replace", properties, nil); err != nil {
+		return propertyError(err, properties)
+	}
+	return nil
+}
+
+// resourceProperties returns the properties of a unit which systemd sets r
+// from itself.
+func resourceProperties(r *configs.Resources) []systemdDbus.Property {
+	var properties []systemdDbus.Property
+	if r.Memory != 0 {
+		properties = append(properties, newProp("MemoryLimit", unitLimit(r.Memory)))
+	}
+	if r.CpuShares != 0 {
+		properties = append(properties, newProp("CPUShares", r.CpuShares))
+	}
+	// cpu.cfs_quota_us and cpu.cfs_period_us are controlled by systemd.
+	if r.CpuQuota != 0 && r.CpuPeriod != 0 {
+		cpuQuotaPerSecUSec := uint64(math.MaxUint64)
+		if r.CpuQuota > 0 {
+			cpuQuotaPerSecUSec = uint64(r.CpuQuota*1000000) / r.CpuPeriod
+		}
+		properties = append(properties, newProp("CPUQuotaPerSecUSec", cpuQuotaPerSecUSec))
+		if hasQuotaPeriod {
+			properties = append(properties, newProp("CPUQuotaPeriodUSec", r.CpuPeriod))
+		}
+	}
+	if r.BlkioWeight != 0 {
+		properties = append(properties, newProp("BlockIOWeight", uint64(r.BlkioWeight)))
+	}
+	if r.PidsLimit != 0 && hasTasksMax {
+		properties = append(properties,
+			newProp("TasksAccounting", true),
+			newProp("TasksMax", unitLimit(r.PidsLimit)))
+	}
+	return properties
+}
+
+// unitLimit converts a limit of Resources, where -1 means none, to that of
+// a property, where the maximum value does.
+func unitLimit(limit int64) uint64 {
+	if limit < 0 {
+		return math.MaxUint64
+	}
+	return uint64(limit)
+}
+
+// unitProperties parses the SystemdProps of c.
+func unitProperties(c *configs.Cgroup) ([]systemdDbus.Property, error) {
+	var properties []systemdDbus.Property
+	for _, p := range c.SystemdProps {
+		v, err := dbus.ParseVariant(p.Value, dbus.Signature{})
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q of systemd property %s: %v", p.Value, p.Name, err)
+		}
+		properties = append(properties, systemdDbus.Property{Name: p.Name, Value: v})
+	}
+	return properties, nil
+}
+
+// mergeProperties returns properties with those of extra, which replace
+// those of the same name.
+func mergeProperties(properties, extra []systemdDbus.Property) []systemdDbus.Property {
+	merged := make([]systemdDbus.Property, 0, len(properties)+len(extra))
+	for _, p := range properties {
+		replaced := false
+		for _, e := range extra {
+			if e.Name == p.Name {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, p)
+		}
+	}
+	return append(merged, extra...)
+}
+
+// unitSlice returns the slice of the unit of c: that of its Slice property
+// if it has one, or its parent.
+func unitSlice(c *configs.Cgroup) (string, error) {
+	slice := "system.slice"
+	if c.Parent != "" {
+		slice = c.Parent
+	}
+	for _, p := range c.SystemdProps {
+		if p.Name != "Slice" {
+			continue
+		}
+		v, err := dbus.ParseVariant(p.Value, dbus.ParseSignatureMust("s"))
+		if err != nil {
+			return "", fmt.Errorf("invalid value %q of systemd property Slice: %v", p.Value, err)
+		}
+		slice = v.Value().(string)
+	}
+	return slice, nil
+}
+
+// propertyError names the property in properties which systemd rejected
+// with err, if its message names one.
+func propertyError(err error, properties []systemdDbus.Property) error {
+	dbusError, ok := err.(dbus.Error)
+	if !ok {
+		return err
+	}
+	words := strings.FieldsFunc(dbusError.Error(), func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for _, w := range words {
+		for _, p := range properties {
+			if p.Name == w {
+				return fmt.Errorf("systemd property %s: %v", p.Name, err)
+			}
+		}
+	}
+	return err
+}
+
+// setUnitProperties sets the properties of the unit of c again, except those
+// which cannot be changed, one at a time so that the one systemd rejects is
+// known.
+func setUnitProperties(c *configs.Cgroup) error {
+	extra, err := unitProperties(c)
+	if err != nil {
+		return err
+	}
+	unitName := getUnitName(c)
+	for _, p := range mergeProperties(resourceProperties(c.Resources), extra) {
+		if creationOnlyProperties[p.Name] {
+			continue
+		}
+		if err := theConn.SetUnitProperties(unitName, true, p); err != nil {
+			return fmt.Errorf("setting systemd property %s of %s: %v", p.Name, unitName, err)
+		}
+	}
+	return nil
+}
+
 func (m *Manager) Destroy() error {
+	m.mu.Lock()
+	m.pressure.Close()
+	m.mu.Unlock()
 	if m.Cgroups.Paths != nil {
 		return nil
 	}
@@ -433,11 +594,10 @@ func getSubsystemPath(c *configs.Cgroup, subsystem string) (string, error) {
 	// if pid 1 is systemd 226 or later, it will be in init.scope, not the root
 	initPath = strings.TrimSuffix(filepath.Clean(initPath), "init.scope")
 
-	slice := "system.slice"
-	if c.Parent != "" {
-		slice = c.Parent
+	slice, err := unitSlice(c)
+	if err != nil {
+		return "", err
 	}
-
 	slice, err = ExpandSlice(slice)
 	if err != nil {
 		return "", err
@@ -446,18 +606,14 @@ func getSubsystemPath(c *configs.Cgroup, subsystem string) (string, error) {
 	return filepath.Join(mountpoint, initPath, slice, getUnitName(c)), nil
 }
 
-func (m *Manager) Freeze(state configs.FreezerState) error {
+func (m *Manager) Freeze(state configs.FreezerState, timeout time.Duration) error {
 	path, err := getSubsystemPath(m.Cgroups, "freezer")
 	if err != nil {
 		return err
 	}
 	prevState := m.Cgroups.Resources.Freezer
 	m.Cgroups.Resources.Freezer = state
-	freezer, err := subsystems.Get("freezer")
-	if err != nil {
-		return err
-	}
-	err = freezer.Set(path, m.Cgroups)
+	err = fs.SetFreezerState(path, state, timeout)
 	if err != nil {
 		m.Cgroups.Resources.Freezer = prevState
 		return err
@@ -491,10 +647,16 @@ func (m *Manager) GetStats() (*cgroups.Stats, error) {
 			continue
 		}
 		if err := sys.GetStats(path, stats); err != nil {
-			return nil, err
+			if m.Cgroups != nil && m.Cgroups.StrictStats {
+				return nil, err
+			}
+			stats.AddError(name, err)
 		}
 	}
 
+	if err := m.pressure.GetStats(m.Paths, stats, m.Cgroups != nil && m.Cgroups.StrictStats); err != nil {
+		return nil, err
+	}
 	return stats, nil
 }
 
@@ -504,6 +666,14 @@ func (m *Manager) Set(container *configs.Config) error {
 	if m.Cgroups.Paths != nil {
 		return nil
 	}
+	if err := cgroups.ResolvePercentages(container.Cgroups.Resources); err != nil {
+		return err
+	}
+	if err := setUnitProperties(container.Cgroups); err != nil {
+		return err
+	}
+	// The cgroup files are still written, for the resources which systemd
+	// does not set.
 	for _, sys := range subsystems {
 		// Get the subsystem path, but don't error out for not found cgroups.
 		path, err := getSubsystemPath(container.Cgroups, sys.Name())
@@ -524,6 +694,13 @@ func (m *Manager) Set(container *configs.Config) error {
 	return nil
 }
 
+func (m *Manager) DriftChecks(container *configs.Config) []cgroups.DriftCheck {
+	if m.Cgroups.Paths != nil {
+		return nil
+	}
+	return fs.DriftChecks(m.GetPaths(), container.Cgroups.Resources)
+}
+
 func getUnitName(c *configs.Cgroup) string {
 	// by default, we create a scope unless the user explicitly asks for a slice.
 	if !strings.HasSuffix(c.Name, ".slice") {
@@ -0,0 +1,97 @@
+// +build linux
+
+package systemd
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	systemdDbus "github.com/coreos/go-systemd/dbus"
+	"github.com/godbus/dbus"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func propertyValues(properties []systemdDbus.Property) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, p := range properties {
+		values[p.Name] = p.Value.Value()
+	}
+	return values
+}
+
+func TestResourceProperties(t *testing.T) {
+	hasTasksMax, hasQuotaPeriod = true, false
+	defer func() { hasTasksMax, hasQuotaPeriod = false, false }()
+
+	values := propertyValues(resourceProperties(&configs.Resources{
+		Memory:    -1,
+		CpuShares: 512,
+		CpuQuota:  50000,
+		CpuPeriod: 100000,
+		PidsLimit: 100,
+	}))
+	for name, expected := range map[string]interface{}{
+		"MemoryLimit":        uint64(math.MaxUint64),
+		"CPUShares":          uint64(512),
+		"CPUQuotaPerSecUSec": uint64(500000),
+		"TasksAccounting":    true,
+		"TasksMax":           uint64(100),
+	} {
+		if values[name] != expected {
+			t.Errorf("expected %v for %s, got %v", expected, name, values[name])
+		}
+	}
+	if _, ok := values["CPUQuotaPeriodUSec"]; ok {
+		t.Error("expected no CPUQuotaPeriodUSec without support for it")
+	}
+}
+
+func TestUnitProperties(t *testing.T) {
+	c := &configs.Cgroup{
+		Parent: "parent.slice",
+		SystemdProps: []configs.SystemdProperty{
+			{Name: "CPUShares", Value: "uint64 2048"},
+			{Name: "Slice", Value: "'custom.slice'"},
+		},
+		Resources: &configs.Resources{CpuShares: 512, BlkioWeight: 100},
+	}
+	extra, err := unitProperties(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := mergeProperties(resourceProperties(c.Resources), extra)
+	if len(merged) != 3 {
+		t.Fatalf("expected the overridden property to be dropped, got %v", merged)
+	}
+	values := propertyValues(merged)
+	if values["CPUShares"] != uint64(2048) || values["BlockIOWeight"] != uint64(100) || values["Slice"] != "custom.slice" {
+		t.Errorf("unexpected properties %v", values)
+	}
+	if slice, err := unitSlice(c); err != nil || slice != "custom.slice" {
+		t.Errorf("expected the slice of the Slice property, got %q, %v", slice, err)
+	}
+
+	c.SystemdProps = []configs.SystemdProperty{{Name: "TasksMax", Value: "uint64 x"}}
+	if _, err := unitProperties(c); err == nil || !strings.Contains(err.Error(), "TasksMax") {
+		t.Errorf("expected an error naming TasksMax, got %v", err)
+	}
+	if slice, err := unitSlice(c); err != nil || slice != "parent.slice" {
+		t.Errorf("expected the parent slice, got %q, %v", slice, err)
+	}
+}
+
+func TestPropertyError(t *testing.T) {
+	properties := []systemdDbus.Property{newProp("TasksMax", uint64(1)), newProp("Tasks", true)}
+	err := propertyError(dbus.Error{
+		Name: "org.freedesktop.DBus.Error.PropertyReadOnly",
+		Body: []interface{}{"Cannot set property TasksMax, or unknown property."},
+	}, properties)
+	if !strings.HasPrefix(err.Error(), "systemd property TasksMax: ") {
+		t.Errorf("expected the error to name TasksMax, got %v", err)
+	}
+	err = propertyError(dbus.Error{Name: "org.freedesktop.DBus.Error.InvalidArgs", Body: []interface{}{"No PIDs specified"}}, properties)
+	if err.Error() != "No PIDs specified" {
+		t.Errorf("expected the error to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+// +build linux
+
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UnifiedMountpoint is where the cgroup v2 unified hierarchy is mounted in
+// unified mode.
+const UnifiedMountpoint = "/sys/fs/cgroup"
+
+// UnifiedPath returns the path of the cgroup innerPath in the unified
+// hierarchy. Like with cgroup v1, a relative innerPath is relative to our
+// own cgroup.
+func UnifiedPath(innerPath string) (string, error) {
+	if filepath.IsAbs(innerPath) {
+		return filepath.Join(UnifiedMountpoint, innerPath), nil
+	}
+	cgroups, err := ParseCgroupFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	own, ok := cgroups[""]
+	if !ok {
+		return "", NewNotFoundError("unified")
+	}
+	return filepath.Join(UnifiedMountpoint, own, innerPath), nil
+}
+
+// FindThreadedAncestor returns the first of dir and its ancestors below the
+// root of the hierarchy at root which is not a regular domain cgroup, and
+// its cgroup.type, e.g. "threaded" or "domain invalid". Only domain cgroups
+// can hold a container whose cgroup is not threaded, writes to the other
+// controllers failing with EOPNOTSUPP. The cgroups which do not exist yet
+// are skipped. It returns an empty path if there is none.
+func FindThreadedAncestor(root, dir string) (string, string, error) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+"/"); dir = filepath.Dir(dir) {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.type"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", "", err
+		}
+		if t := strings.TrimSpace(string(data)); t != "domain" {
+			return dir, t, nil
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,43 @@
+// +build linux
+
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindThreadedAncestor(t *testing.T) {
+	root, err := ioutil.TempDir("", "unified")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for dir, cgType := range map[string]string{
+		"agent":         "domain threaded",
+		"agent/pool":    "threaded",
+		"system":        "domain",
+		"system/daemon": "domain",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, dir, "cgroup.type"), []byte(cgType+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, cgType, err := FindThreadedAncestor(root, filepath.Join(root, "agent/pool/container"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(root, "agent/pool") || cgType != "threaded" {
+		t.Fatalf("expected the threaded parent, got %q of type %q", path, cgType)
+	}
+	path, _, err = FindThreadedAncestor(root, filepath.Join(root, "system/daemon/container"))
+	if err != nil || path != "" {
+		t.Fatalf("expected only domain cgroups, got %q, %v", path, err)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/docker/go-units"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -21,6 +22,19 @@ const (
 	CgroupProcesses  = "cgroup.procs"
 )
 
+// cgroup2SuperMagic is the filesystem type of a cgroup v2 hierarchy.
+const cgroup2SuperMagic = 0x63677270
+
+// IsCgroup2UnifiedMode returns true if the host mounts the cgroup v2 unified
+// hierarchy on /sys/fs/cgroup rather than the cgroup v1 controllers.
+func IsCgroup2UnifiedMode() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs("/sys/fs/cgroup", &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
 // https://www.kernel.org/doc/Documentation/cgroup-v1/cgroups.txt
 func FindCgroupMountpoint(subsystem string) (string, error) {
 	mnt, _, err := FindCgroupMountpointAndRoot(subsystem)
@@ -422,20 +436,27 @@ func GetPids(path string) ([]int, error) {
 }
 
 // GetAllPids returns all pids, that were added to cgroup at path and to all its
-// subcgroups.
+// subcgroups. The subcgroups which the processes remove while they are walked
+// are skipped.
 func GetAllPids(path string) ([]int, error) {
 	var pids []int
 	// collect pids from all sub-cgroups
 	err := filepath.Walk(path, func(p string, info os.FileInfo, iErr error) error {
+		if iErr != nil {
+			if p != path && isRemovedCgroup(iErr) {
+				return nil
+			}
+			return iErr
+		}
 		dir, file := filepath.Split(p)
 		if file != CgroupProcesses {
 			return nil
 		}
-		if iErr != nil {
-			return iErr
-		}
 		cPids, err := readProcsFile(dir)
 		if err != nil {
+			if filepath.Clean(dir) != filepath.Clean(path) && isRemovedCgroup(err) {
+				return nil
+			}
 			return err
 		}
 		pids = append(pids, cPids...)
@@ -444,6 +465,19 @@ func GetAllPids(path string) ([]int, error) {
 	return pids, err
 }
 
+// isRemovedCgroup returns true if err is that of accessing a cgroup which was
+// removed, whose files are gone or, if they were opened before, fail with
+// ENODEV.
+func isRemovedCgroup(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == unix.ENODEV
+	}
+	return false
+}
+
 // WriteCgroupProc writes the specified pid into the cgroup's cgroup.procs file
 func WriteCgroupProc(dir string, pid int) error {
 	// Normally dir should not be empty, one case is that cgroup subsystem
@@ -460,3 +494,29 @@ func WriteCgroupProc(dir string, pid int) error {
 	}
 	return nil
 }
+
+// ParseCpusetList returns the ids of a list like "0-3,6", in order.
+func ParseCpusetList(list string) ([]int, error) {
+	var ids []int
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+	for _, r := range strings.Split(list, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset list %q", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpuset list %q", list)
+			}
+		}
+		for id := first; id <= last; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
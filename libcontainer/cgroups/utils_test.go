@@ -5,7 +5,11 @@ package cgroups
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -331,3 +335,53 @@ func TestGetClosestMountpointAncestor(t *testing.T) {
 		}
 	}
 }
+
+func TestGetAllPids(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for dir, procs := range map[string]string{
+		"":    "1\n2\n",
+		"a":   "3\n",
+		"a/b": "4\n",
+		"c":   "",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, dir, CgroupProcesses), []byte(procs), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// d stands for a sub-cgroup removed while the cgroups are walked.
+	if err := os.Mkdir(filepath.Join(root, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "removed"), filepath.Join(root, "d", CgroupProcesses)); err != nil {
+		t.Fatal(err)
+	}
+
+	pids, err := GetPids(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pids, []int{1, 2}) {
+		t.Errorf("expected the pids of the cgroup itself, got %v", pids)
+	}
+	pids, err = GetAllPids(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(pids)
+	if !reflect.DeepEqual(pids, []int{1, 2, 3, 4}) {
+		t.Errorf("expected the pids of the cgroup and its sub-cgroups, got %v", pids)
+	}
+	if _, err := GetAllPids(filepath.Join(root, "removed")); err == nil {
+		t.Error("expected an error for a missing cgroup")
+	}
+	if _, err := GetAllPids(filepath.Join(root, "d")); err == nil {
+		t.Error("expected an error for a cgroup whose processes cannot be read")
+	}
+}
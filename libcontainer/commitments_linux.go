@@ -0,0 +1,131 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+// pidMaxPath is where the highest pid of the host, plus one, is read from.
+var pidMaxPath = "/proc/sys/kernel/pid_max"
+
+func (l *LinuxFactory) Commitments() (*Commitments, error) {
+	if l.Root == "" {
+		return nil, newGenericError(fmt.Errorf("invalid root"), ConfigInvalid)
+	}
+	cm, err := hostCommitments()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "reading host capacity")
+	}
+	dirs, err := ioutil.ReadDir(l.Root)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "listing containers")
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		id := d.Name()
+		root := filepath.Join(l.Root, id)
+		state, err := l.loadState(root, id)
+		if err != nil {
+			// Destroyed meanwhile, or not a container.
+			if lerr, ok := err.(Error); ok && lerr.Code() == ContainerNotExists {
+				continue
+			}
+			return nil, err
+		}
+		status := storedStatus(root, state)
+		cm.Statuses[status]++
+		if status == Stopped {
+			continue
+		}
+		if err := cm.add(state.Config.Cgroups); err != nil {
+			return nil, newSystemErrorWithCausef(err, "adding up the limits of container %s", id)
+		}
+	}
+	return cm, nil
+}
+
+// storedStatus returns the status of the container with the given state
+// directory and state from its state and its init process only, without
+// reading its cgroups: a container frozen other than by Pause counts as
+// running.
+func storedStatus(root string, state *State) Status {
+	stat, err := system.Stat(state.InitProcessPid)
+	if err != nil || stat.StartTime != state.InitProcessStartTime || stat.State == system.Zombie || stat.State == system.Dead {
+		return Stopped
+	}
+	if state.Paused {
+		return Paused
+	}
+	if _, err := os.Stat(filepath.Join(root, execFifoFilename)); err == nil && stat.Name == initWaitName {
+		return Created
+	}
+	return Running
+}
+
+// hostCommitments returns commitments of nothing yet against the capacity
+// of the host.
+func hostCommitments() (*Commitments, error) {
+	mem, err := cgroups.HostMemTotal()
+	if err != nil {
+		return nil, err
+	}
+	cpus, err := cgroups.OnlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(pidMaxPath)
+	if err != nil {
+		return nil, err
+	}
+	pids, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", pidMaxPath, err)
+	}
+	return &Commitments{
+		Memory:   Commitment{Capacity: float64(mem)},
+		Cpu:      Commitment{Capacity: float64(cpus)},
+		Pids:     Commitment{Capacity: float64(pids)},
+		Statuses: make(map[Status]int),
+	}, nil
+}
+
+// add adds the limits of the cgroup config of a container to cm. The
+// percentages of the host are resolved on a copy, as the managers do.
+func (cm *Commitments) add(cg *configs.Cgroup) error {
+	var r configs.Resources
+	if cg != nil && cg.Resources != nil {
+		r = *cg.Resources
+	}
+	if err := cgroups.ResolvePercentages(&r); err != nil {
+		return err
+	}
+	cm.Memory.add(r.Memory > 0, float64(r.Memory))
+	period := r.CpuPeriod
+	if period == 0 {
+		period = 100000 // the CFS default
+	}
+	cm.Cpu.add(r.CpuQuota > 0, float64(r.CpuQuota)/float64(period))
+	cm.Pids.add(r.PidsLimit > 0, float64(r.PidsLimit))
+	return nil
+}
+
+func (c *Commitment) add(limited bool, limit float64) {
+	if !limited {
+		c.Unbounded++
+		return
+	}
+	c.Limited++
+	c.Committed += limit
+}
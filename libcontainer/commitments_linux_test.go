@@ -0,0 +1,110 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+func TestFactoryCommitments(t *testing.T) {
+	root, err := newTestRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	self, err := system.Stat(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// We stand in for the init of the running container, and a process
+	// named like an init waiting to be started for the created one.
+	dir, err := ioutil.TempDir("", "commitments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	waiting, waitingStarted, kill := startNamedScript(t, dir, initWaitName, "sleep 100")
+	defer kill()
+	for _, c := range []struct {
+		id        string
+		pid       int
+		started   uint64
+		resources *configs.Resources
+		paused    bool
+	}{
+		{"running", os.Getpid(), self.StartTime, &configs.Resources{Memory: 1 << 30, CpuQuota: 50000, CpuPeriod: 100000, PidsLimit: 100}, false},
+		{"created", waiting.Process.Pid, waitingStarted, &configs.Resources{Memory: -1}, false},
+		{"paused", os.Getpid(), self.StartTime, nil, true},
+		{"stopped", 0, 0, &configs.Resources{Memory: 1 << 30, CpuQuota: 200000, PidsLimit: 10}, false},
+	} {
+		writeTestState(t, root, c.id, &State{
+			BaseState: BaseState{
+				ID:                   c.id,
+				InitProcessPid:       c.pid,
+				InitProcessStartTime: c.started,
+				Config:               configs.Config{Cgroups: &configs.Cgroup{Resources: c.resources}},
+			},
+			Paused: c.paused,
+		})
+	}
+	if err := os.Mkdir(filepath.Join(root, "created", execFifoFilename), 0700); err != nil {
+		t.Fatal(err)
+	}
+	factory, err := New(root, Cgroupfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, err := factory.Commitments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Statuses[Running] != 1 || cm.Statuses[Created] != 1 || cm.Statuses[Paused] != 1 || cm.Statuses[Stopped] != 1 {
+		t.Fatalf("unexpected statuses %v", cm.Statuses)
+	}
+	for name, test := range map[string]struct {
+		got  Commitment
+		want float64
+	}{
+		"memory": {cm.Memory, 1 << 30},
+		"cpu":    {cm.Cpu, 0.5},
+		"pids":   {cm.Pids, 100},
+	} {
+		if test.got.Committed != test.want || test.got.Limited != 1 || test.got.Unbounded != 2 {
+			t.Fatalf("expected %s of 1 limited container at %v and 2 unbounded, got %+v", name, test.want, test.got)
+		}
+		if test.got.Capacity <= 0 {
+			t.Fatalf("expected the %s capacity of the host, got %+v", name, test.got)
+		}
+	}
+}
+
+func TestPauseRecordsState(t *testing.T) {
+	c, _, cleanup := newQuiesceContainer(t)
+	defer cleanup()
+
+	paused := func() bool {
+		state, err := (&LinuxFactory{}).loadState(c.root, c.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state.Paused
+	}
+	if err := c.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if !paused() {
+		t.Fatal("expected the pause to be recorded in the state")
+	}
+	if err := c.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if paused() {
+		t.Fatal("expected the resume to be recorded in the state")
+	}
+}
@@ -27,10 +27,36 @@ type Cgroup struct {
 	// This takes precedence over Path.
 	Paths map[string]string
 
+	// StrictStats makes getting the stats fail if the stats of any subsystem
+	// cannot be read, instead of reporting the error in the stats.
+	StrictStats bool `json:"strict_stats,omitempty"`
+
+	// Threaded makes the cgroup of the container a threaded cgroup of the
+	// cgroup v2 unified hierarchy, e.g. for a thread-pool workload, which can
+	// also be placed under a threaded subtree. Only the cpu and cpuset
+	// controllers apply to threaded cgroups, so no other resource can be
+	// limited.
+	Threaded bool `json:"threaded,omitempty"`
+
+	// SystemdProps are properties of the unit created for the container by
+	// the systemd cgroup driver, e.g. TasksMax or Slice, which override those
+	// translated from Resources. They are set again when the resources are
+	// updated, except for those which can only be set on a new unit.
+	SystemdProps []SystemdProperty `json:"systemd_props,omitempty"`
+
 	// Resources contains various cgroups settings to apply
 	*Resources
 }
 
+// SystemdProperty is a property of a systemd unit.
+type SystemdProperty struct {
+	Name string `json:"name"`
+	// Value is in the GVariant text format, with the type given unless it
+	// is a string or a boolean, e.g. "uint64 4096", "'custom.slice'" or
+	// "true".
+	Value string `json:"value"`
+}
+
 type Resources struct {
 	// If this is true allow access to any kind of device within the container.  If false, allow access only to devices explicitly listed in the allowed_devices list.
 	// Deprecated
@@ -45,6 +71,10 @@ type Resources struct {
 	// Memory limit (in bytes)
 	Memory int64 `json:"memory"`
 
+	// Memory limit as a percentage of the memory of the host, which Memory
+	// is set from when the cgroups are applied. Excludes Memory.
+	MemoryPercent float64 `json:"memory_percent,omitempty"`
+
 	// Memory reservation or soft_limit (in bytes)
 	MemoryReservation int64 `json:"memory_reservation"`
 
@@ -66,6 +96,15 @@ type Resources struct {
 	// CPU period to be used for hardcapping (in usecs). 0 to use system default.
 	CpuPeriod uint64 `json:"cpu_period"`
 
+	// CPU idle marks the cgroup as idle (1), so that its processes only get
+	// CPU time left over by other cgroups, or reverts it (0). Only exists on
+	// cgroup v2.
+	CPUIdle *int64 `json:"cpu_idle,omitempty"`
+
+	// CPU hardcap limit as a percentage of the online CPUs of the host, which
+	// CpuQuota is set from when the cgroups are applied. Excludes CpuQuota.
+	CpuPercent float64 `json:"cpu_percent,omitempty"`
+
 	// How many time CPU will use in realtime scheduling (in usecs).
 	CpuRtRuntime int64 `json:"cpu_rt_quota"`
 
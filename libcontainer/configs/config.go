@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -86,6 +89,14 @@ type Config struct {
 	// that the parent process dies.
 	ParentDeathSignal int `json:"parent_death_signal"`
 
+	// StopSignal is the signal which SIGTERM is replaced with when it is
+	// forwarded to the container. SIGTERM is forwarded as is if it is zero.
+	StopSignal int `json:"stop_signal,omitempty"`
+
+	// StopTimeout is how long the init process has to exit after the stop
+	// signal before the container is killed. A default is used if it is zero.
+	StopTimeout time.Duration `json:"stop_timeout,omitempty"`
+
 	// Path to a directory containing the container's root filesystem.
 	Rootfs string `json:"rootfs"`
 
@@ -103,6 +114,12 @@ type Config struct {
 	// The device nodes that should be automatically created within the container upon container start.  Note, make sure that the node is marked as allowed in the cgroup as well!
 	Devices []*Device `json:"devices"`
 
+	// UseDefaultDevices adds DefaultAutoCreatedDevices to Devices and
+	// DefaultAllowedDevices to the devices allowed by the cgroup when the
+	// container is created. Devices and rules of the config take precedence
+	// over the defaults they conflict with.
+	UseDefaultDevices bool `json:"use_default_devices,omitempty"`
+
 	MountLabel string `json:"mount_label"`
 
 	// Hostname optionally sets the container's hostname if provided
@@ -116,6 +133,11 @@ type Config struct {
 	// All capabilities not specified will be dropped from the processes capability mask
 	Capabilities *Capabilities `json:"capabilities"`
 
+	// AllowAmbientFallback drops the ambient capabilities of the processes
+	// of the container, with a warning, on kernels which do not support them
+	// rather than refusing to start them.
+	AllowAmbientFallback bool `json:"allow_ambient_fallback,omitempty"`
+
 	// Networks specifies the container's network setup to be created
 	Networks []*Network `json:"networks"`
 
@@ -142,6 +164,8 @@ type Config struct {
 	// for a process. Valid values are between the range [-1000, '1000'], where processes with
 	// higher scores are preferred for being killed.
 	// More information about kernel oom score calculation here: https://lwn.net/Articles/317814/
+	// The score is set for every process of the container, so that they never
+	// inherit the one of runc: leaving it unset resets it to 0.
 	OomScoreAdj int `json:"oom_score_adj"`
 
 	// UidMappings is an array of User ID mappings for User Namespaces
@@ -158,6 +182,12 @@ type Config struct {
 	// so that these files prevent any writes.
 	ReadonlyPaths []string `json:"readonly_paths"`
 
+	// StrictStateRoot makes creating the container fail when its mounts
+	// expose the state root of the factory, which is otherwise masked with
+	// an empty read-only tmpfs inside the container. It requires a mount
+	// namespace.
+	StrictStateRoot bool `json:"strict_state_root,omitempty"`
+
 	// Sysctl is a map of properties and their values. It is the equivalent of using
 	// sysctl -w my.property.name value in Linux.
 	Sysctl map[string]string `json:"sysctl"`
@@ -174,6 +204,10 @@ type Config struct {
 	// CommandHooks are serialized to JSON, but other hooks are not.
 	Hooks *Hooks
 
+	// HookOutput, if set, receives the combined output of the command hooks
+	// as they run.
+	HookOutput io.Writer `json:"-"`
+
 	// Version is the version of opencontainer specification that is supported.
 	Version string `json:"version"`
 
@@ -186,6 +220,84 @@ type Config struct {
 
 	// Rootless specifies whether the container is a rootless container.
 	Rootless bool `json:"rootless"`
+
+	// KillCgroupProcessesOnExit controls whether every process left in the
+	// container's cgroups is killed once the init process exits, when the
+	// container does not have a PID namespace of its own. When unset, they
+	// are only killed if the container joins an existing PID namespace.
+	KillCgroupProcessesOnExit *bool `json:"kill_cgroup_processes_on_exit,omitempty"`
+
+	// Devpts configures the private devpts instance which is mounted at
+	// /dev/pts when /dev is set up and no devpts mount is configured there.
+	Devpts *Devpts `json:"devpts,omitempty"`
+
+	// Dev configures the tmpfs which is mounted at /dev when /dev is set up
+	// and no mount is configured there, and how /dev is populated.
+	Dev *Dev `json:"dev,omitempty"`
+
+	// PreserveMountNSOnExit keeps the mount namespace of the container, and
+	// the tmpfs mounts in it, around after the init process exited until the
+	// container is destroyed, so that it can be inspected post-mortem.
+	PreserveMountNSOnExit bool `json:"preserve_mount_ns_on_exit,omitempty"`
+
+	// MaxLifetime is how long the container may run once it is created, that
+	// is from the time its init process was spawned rather than from when it
+	// was started, or zero for no limit. It is then stopped as by Stop: its
+	// init process is sent the StopSignal, and every process left after a
+	// grace period, or after the StopTimeout if shorter, SIGKILL.
+	MaxLifetime time.Duration `json:"max_lifetime,omitempty"`
+
+	// RetainedOutput is how many bytes of the most recent output of the init
+	// process are kept in memory by the process which started it, for
+	// Container.TailOutput, or zero to keep none. Only the output going
+	// through libcontainer is seen: that of the console created for a
+	// Terminal and of stdout and stderr which are not files.
+	RetainedOutput int `json:"retained_output,omitempty"`
+
+	// CgroupFileShim is a compatibility shim for kernels without cgroup
+	// namespaces: the /proc/<pid>/cgroup of the init process is replaced by
+	// a copy listing its cgroups as "/", as a cgroup namespace would, rather
+	// than by their paths on the host. The copy is taken at start, so other
+	// processes and later moves between cgroups are not reflected. It is
+	// refused when cgroup namespaces are available, which are to be used
+	// instead.
+	CgroupFileShim bool `json:"cgroup_file_shim,omitempty"`
+}
+
+// DefaultRetainedOutput is a sensible RetainedOutput, which keeps the last
+// 64KB of output.
+const DefaultRetainedOutput = 64 * 1024
+
+// Devpts configures the devpts instance of a container.
+type Devpts struct {
+	// PtmxMode is the mode of the ptmx device of the instance. Defaults to 0666.
+	PtmxMode os.FileMode `json:"ptmx_mode,omitempty"`
+
+	// BindPtmx bind mounts pts/ptmx onto /dev/ptmx rather than creating
+	// /dev/ptmx as a symlink to it, for setups that forbid symlinks in /dev.
+	BindPtmx bool `json:"bind_ptmx,omitempty"`
+}
+
+// Default sizes of the tmpfs mounted at /dev.
+const (
+	DefaultDevSize   = 64 * 1024 * 1024
+	DefaultDevInodes = 64 * 1024
+)
+
+// Dev configures the /dev of a container.
+type Dev struct {
+	// Size is the size of the tmpfs in bytes. Defaults to DefaultDevSize.
+	Size int64 `json:"size,omitempty"`
+
+	// Inodes is the number of inodes of the tmpfs. Defaults to
+	// DefaultDevInodes.
+	Inodes int64 `json:"inodes,omitempty"`
+
+	// NoPopulate leaves out the device nodes, the symlinks and the mqueue
+	// and shm mounts of /dev, for images which manage /dev themselves. Only
+	// /dev/null is still created, if configured, as paths are masked with
+	// it, and the devpts instance set up, as consoles are allocated from it.
+	NoPopulate bool `json:"no_populate,omitempty"`
 }
 
 type Hooks struct {
@@ -259,14 +371,119 @@ func (hooks Hooks) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// HookState is the payload provided to a hook on execution.
+// HookState is the payload provided to a hook on execution. It is written to
+// the stdin of the hook, and stdout and stderr are collected by runc. No other
+// descriptor is passed to the hook.
 type HookState specs.State
 
+// legacyHookStateVersion is the first spec version whose hooks expect the
+// state to carry the status and annotations of the container.
+var legacyHookStateVersion = SpecVersion{Major: 1, Minor: 0, Patch: 0, RC: 5}
+
+// MarshalJSON encodes the state in the shape expected by hooks written
+// against s.Version. Hooks for specs predating 1.0.0-rc5 only get the
+// ociVersion, id, pid and bundle fields.
+func (s HookState) MarshalJSON() ([]byte, error) {
+	if v, err := ParseSpecVersion(s.Version); err == nil && v.Less(legacyHookStateVersion) {
+		return json.Marshal(struct {
+			Version string `json:"ociVersion"`
+			ID      string `json:"id"`
+			Pid     int    `json:"pid,omitempty"`
+			Bundle  string `json:"bundle"`
+		}{
+			Version: s.Version,
+			ID:      s.ID,
+			Pid:     s.Pid,
+			Bundle:  s.Bundle,
+		})
+	}
+	return json.Marshal(specs.State(s))
+}
+
 type Hook interface {
 	// Run executes the hook with the provided state.
 	Run(HookState) error
 }
 
+// ResponseHook is a Hook which is able to pass a response back to the
+// runtime.
+type ResponseHook interface {
+	Hook
+
+	// RunWithResponse executes the hook with the provided state and returns
+	// its response, which is nil if the hook did not give one.
+	RunWithResponse(HookState) (*HookResponse, error)
+}
+
+// HookResponse is the data a hook may feed back to the runtime by writing it
+// as a JSON object to its stdout.
+type HookResponse struct {
+	// Warnings are logged by the runtime.
+	Warnings []string `json:"warnings,omitempty"`
+	// Annotations are merged into the annotations of the container.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// maxHookResponseErrorOutput is how much of a malformed hook response is
+// kept in a HookResponseError.
+const maxHookResponseErrorOutput = 256
+
+// HookResponseError is returned when a hook wrote a malformed response.
+type HookResponseError struct {
+	// Output is the response the hook wrote, truncated to a reasonable size.
+	Output []byte
+	Err    error
+}
+
+func newHookResponseError(out []byte, err error) *HookResponseError {
+	if len(out) > maxHookResponseErrorOutput {
+		out = out[:maxHookResponseErrorOutput]
+	}
+	return &HookResponseError{
+		Output: out,
+		Err:    err,
+	}
+}
+
+func (e *HookResponseError) Error() string {
+	return fmt.Sprintf("invalid hook response %q: %v", e.Output, e.Err)
+}
+
+// HookTimeoutError is returned when a command hook ran past its Timeout. The
+// hook is killed along with the processes it forked which are still in its
+// process group.
+type HookTimeoutError struct {
+	Timeout time.Duration
+	// Output is the output of the hook until it was killed, truncated to
+	// HookOutputMax.
+	Output string
+}
+
+func (e *HookTimeoutError) Error() string {
+	return fmt.Sprintf("hook ran past specified timeout of %.1fs, output: %s", e.Timeout.Seconds(), e.Output)
+}
+
+// ParseHookResponse parses what a hook wrote to stdout. Output that is not a
+// JSON object is not a response and is ignored, so that hooks which merely
+// print something keep working, and so are the fields of a response this
+// version does not know, which newer hooks may add.
+func ParseHookResponse(out []byte) (*HookResponse, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 || out[0] != '{' {
+		return nil, nil
+	}
+	var r HookResponse
+	if err := json.Unmarshal(out, &r); err != nil {
+		return nil, newHookResponseError(out, err)
+	}
+	for k := range r.Annotations {
+		if k == "" {
+			return nil, newHookResponseError(out, fmt.Errorf("empty annotation key"))
+		}
+	}
+	return &r, nil
+}
+
 // NewFunctionHook will call the provided function when the hook is run.
 func NewFunctionHook(f func(HookState) error) FuncHook {
 	return FuncHook{
@@ -283,11 +500,89 @@ func (f FuncHook) Run(s HookState) error {
 }
 
 type Command struct {
-	Path    string         `json:"path"`
-	Args    []string       `json:"args"`
-	Env     []string       `json:"env"`
-	Dir     string         `json:"dir"`
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	Env  []string `json:"env"`
+	Dir  string   `json:"dir"`
+	// Timeout is how long the hook may run before it is killed, along with
+	// the processes it forked, and fails with a HookTimeoutError.
 	Timeout *time.Duration `json:"timeout"`
+
+	// Sandbox confines the hook when it is run by a container. Hooks are
+	// executed directly, with the privileges of the runtime, without one.
+	Sandbox *HookSandbox `json:"sandbox,omitempty"`
+
+	// Output, if set, receives the combined output of the hook as it runs.
+	Output io.Writer `json:"-"`
+}
+
+// HookOutputMax is how much of the combined output of a command hook is kept
+// for the error it fails with.
+const HookOutputMax = 64 * 1024
+
+// HookOutputBuffer keeps the first HookOutputMax bytes of the combined output
+// of a command hook, and streams all of it to W if set. It is safe for the
+// concurrent writes of its stdout and stderr.
+type HookOutputBuffer struct {
+	W io.Writer
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// Write never fails, so that the hook is not disturbed: W is no longer
+// written to once it failed.
+func (o *HookOutputBuffer) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if room := HookOutputMax - o.buf.Len(); len(b) > room {
+		o.buf.Write(b[:room])
+		o.truncated = true
+	} else {
+		o.buf.Write(b)
+	}
+	if o.W != nil {
+		if _, err := o.W.Write(b); err != nil {
+			o.W = nil
+		}
+	}
+	return len(b), nil
+}
+
+func (o *HookOutputBuffer) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.truncated {
+		return o.buf.String() + "... (truncated)"
+	}
+	return o.buf.String()
+}
+
+// HookSandbox describes how a command hook is confined. The hook is run
+// through the container init in a hook-runner mode, which sets it up before
+// executing the hook.
+type HookSandbox struct {
+	// Capabilities are the capabilities the hook runs with, all others being
+	// dropped. The hook keeps ours if it is nil.
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+
+	// NoNewPrivileges keeps the hook from gaining privileges through the
+	// binaries it executes.
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty"`
+
+	// MountNamespace runs the hook in a mount namespace of its own, whose
+	// root only holds the hook binary, the bundle of the container and
+	// Paths, all bind-mounted read-only from the host.
+	MountNamespace bool `json:"mount_namespace,omitempty"`
+
+	// Paths are the absolute paths of the host made visible to the hook in
+	// its mount namespace, such as the directories of the libraries it
+	// needs. Mounts below them are not visible.
+	Paths []string `json:"paths,omitempty"`
+
+	// Rlimits are the resource limits of the hook.
+	Rlimits []Rlimit `json:"rlimits,omitempty"`
 }
 
 // NewCommandHook will execute the provided command when the hook is run.
@@ -302,27 +597,35 @@ type CommandHook struct {
 }
 
 func (c Command) Run(s HookState) error {
+	_, err := c.RunWithResponse(s)
+	return err
+}
+
+// RunWithResponse runs the command and parses its stdout as a HookResponse.
+func (c Command) RunWithResponse(s HookState) (*HookResponse, error) {
 	b, err := json.Marshal(s)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var stdout, stderr bytes.Buffer
+	var stdout bytes.Buffer
+	output := &HookOutputBuffer{W: c.Output}
 	cmd := exec.Cmd{
 		Path:   c.Path,
 		Args:   c.Args,
 		Env:    c.Env,
 		Stdin:  bytes.NewReader(b),
-		Stdout: &stdout,
-		Stderr: &stderr,
+		Stdout: io.MultiWriter(&stdout, output),
+		Stderr: output,
 	}
+	setHookProcessGroup(&cmd)
 	if err := cmd.Start(); err != nil {
-		return err
+		return nil, err
 	}
 	errC := make(chan error, 1)
 	go func() {
 		err := cmd.Wait()
 		if err != nil {
-			err = fmt.Errorf("error running hook: %v, stdout: %s, stderr: %s", err, stdout.String(), stderr.String())
+			err = fmt.Errorf("error running hook: %v, output: %s", err, output)
 		}
 		errC <- err
 	}()
@@ -334,10 +637,13 @@ func (c Command) Run(s HookState) error {
 	}
 	select {
 	case err := <-errC:
-		return err
+		if err != nil {
+			return nil, err
+		}
+		return ParseHookResponse(stdout.Bytes())
 	case <-timerCh:
-		cmd.Process.Kill()
-		cmd.Wait()
-		return fmt.Errorf("hook ran past specified timeout of %.1fs", c.Timeout.Seconds())
+		killHookProcessGroup(&cmd)
+		<-errC
+		return nil, &HookTimeoutError{Timeout: *c.Timeout, Output: output.String()}
 	}
 }
@@ -128,3 +128,22 @@ func TestHostRootGIDWithUSERNS(t *testing.T) {
 		t.Fatalf("expected gid 1000 with no USERNS but received %d", uid)
 	}
 }
+
+func TestNamespacesDedupe(t *testing.T) {
+	n := Namespaces{
+		{Type: NEWNET, Path: "/proc/1/ns/net"},
+		{Type: NEWPID},
+		{Type: NEWNET},
+		{Type: NEWNET, Path: "/var/run/netns/a"},
+	}
+	dups := n.Dedupe()
+	if len(dups) != 1 || dups[0] != NEWNET {
+		t.Fatalf("expected the net namespace to be reported once, got %v", dups)
+	}
+	if len(n) != 2 || n[0].Type != NEWPID || n[1].Path != "/var/run/netns/a" {
+		t.Fatalf("expected the last net namespace to be kept, got %+v", n)
+	}
+	if dups := n.Dedupe(); len(dups) != 0 {
+		t.Fatalf("expected no duplicates left, got %v", dups)
+	}
+}
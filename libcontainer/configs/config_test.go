@@ -1,10 +1,12 @@
 package configs_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -182,6 +184,189 @@ func TestCommandHookRunTimeout(t *testing.T) {
 	}
 }
 
+func TestCommandHookOutput(t *testing.T) {
+	state := configs.HookState{Version: "1.0.0", ID: "1"}
+	var streamed bytes.Buffer
+	cmdHook := configs.NewCommandHook(configs.Command{
+		Path:   "/bin/sh",
+		Args:   []string{"/bin/sh", "-c", "echo to-stdout; echo to-stderr >&2; exit 3"},
+		Output: &streamed,
+	})
+	err := cmdHook.Run(state)
+	if err == nil {
+		t.Fatal("expected the hook to fail")
+	}
+	for _, want := range []string{"exit status 3", "to-stdout", "to-stderr"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in the error: %v", want, err)
+		}
+		if want != "exit status 3" && !strings.Contains(streamed.String(), want) {
+			t.Errorf("expected %q to be streamed: %q", want, streamed.String())
+		}
+	}
+
+	// The output so far is reported along with the timeout.
+	timeout := 100 * time.Millisecond
+	cmdHook = configs.NewCommandHook(configs.Command{
+		Path:    "/bin/sh",
+		Args:    []string{"/bin/sh", "-c", "echo partial; exec sleep 5"},
+		Timeout: &timeout,
+	})
+	err = cmdHook.Run(state)
+	if err == nil || !strings.Contains(err.Error(), "timeout") || !strings.Contains(err.Error(), "partial") {
+		t.Fatalf("expected a timeout with the partial output, got %v", err)
+	}
+}
+
+func TestCommandHookTimeout(t *testing.T) {
+	state := configs.HookState{Version: "1.0.0", ID: "1"}
+	timeout := 100 * time.Millisecond
+	for _, script := range []string{
+		"exec sleep 5",
+		// The child ignores SIGTERM and keeps the output open.
+		"sh -c 'trap \"\" TERM; sleep 5; echo late' & wait",
+	} {
+		cmdHook := configs.NewCommandHook(configs.Command{
+			Path:    "/bin/sh",
+			Args:    []string{"/bin/sh", "-c", script},
+			Timeout: &timeout,
+		})
+		start := time.Now()
+		err := cmdHook.Run(state)
+		if _, ok := err.(*configs.HookTimeoutError); !ok {
+			t.Fatalf("%s: expected a HookTimeoutError, got %v", script, err)
+		}
+		if d := time.Since(start); d > 3*time.Second {
+			t.Fatalf("%s: expected the hook and its children to be killed, it took %v", script, d)
+		}
+		if strings.Contains(err.Error(), "late") {
+			t.Fatalf("%s: expected the child of the hook to be killed, got %v", script, err)
+		}
+	}
+}
+
+func TestHookOutputBufferTruncates(t *testing.T) {
+	var streamed bytes.Buffer
+	output := &configs.HookOutputBuffer{W: &streamed}
+	data := bytes.Repeat([]byte("x"), configs.HookOutputMax+10)
+	if n, err := output.Write(data); n != len(data) || err != nil {
+		t.Fatalf("expected the whole output to be accepted, got %d, %v", n, err)
+	}
+	if s := output.String(); !strings.HasSuffix(s, "... (truncated)") || len(s) != configs.HookOutputMax+len("... (truncated)") {
+		t.Fatalf("expected the output to be truncated to %d bytes, got %d", configs.HookOutputMax, len(s))
+	}
+	if streamed.Len() != len(data) {
+		t.Fatalf("expected the whole output to be streamed, got %d bytes", streamed.Len())
+	}
+}
+
+func TestCommandHookRunWithResponse(t *testing.T) {
+	state := configs.HookState{
+		Version: "1.0.0",
+		ID:      "1",
+		Pid:     1,
+		Bundle:  "/bundle",
+	}
+	for _, tc := range []struct {
+		output      string
+		annotations map[string]string
+		invalid     bool
+	}{
+		{output: ""},
+		{output: "not a response"},
+		{output: `{"warnings": ["careful"], "annotations": {"foo": "bar"}}`, annotations: map[string]string{"foo": "bar"}},
+		{output: `{"annotations": {"foo": 1}}`, invalid: true},
+		{output: `{"unknown": true, "annotations": {"foo": "bar"}}`, annotations: map[string]string{"foo": "bar"}},
+		{output: `{"annotations": {}`, invalid: true},
+	} {
+		cmdHook := configs.NewCommandHook(configs.Command{
+			Path: "/bin/sh",
+			Args: []string{"/bin/sh", "-c", "printf '%s' \"$0\"", tc.output},
+		})
+		resp, err := cmdHook.RunWithResponse(state)
+		if tc.invalid {
+			if _, ok := err.(*configs.HookResponseError); !ok {
+				t.Errorf("%q: expected a HookResponseError but got %v", tc.output, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: expected error to not occur but it was %+v", tc.output, err)
+			continue
+		}
+		var annotations map[string]string
+		if resp != nil {
+			annotations = resp.Annotations
+		}
+		if !reflect.DeepEqual(annotations, tc.annotations) {
+			t.Errorf("%q: expected annotations %v but got %v", tc.output, tc.annotations, annotations)
+		}
+	}
+}
+
+func TestHookStateMarshalLegacyVersion(t *testing.T) {
+	state := configs.HookState{
+		Version:     "1.0.0-rc2",
+		ID:          "1",
+		Status:      "running",
+		Pid:         1,
+		Bundle:      "/bundle",
+		Annotations: map[string]string{"foo": "bar"},
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := `{"ociVersion":"1.0.0-rc2","id":"1","pid":1,"bundle":"/bundle"}`
+	if string(b) != h {
+		t.Errorf("Expected hook state %s to equal %s", string(b), h)
+	}
+
+	state.Version = "1.0.0"
+	if b, err = json.Marshal(state); err != nil {
+		t.Fatal(err)
+	}
+	h = `{"ociVersion":"1.0.0","id":"1","status":"running","pid":1,"bundle":"/bundle","annotations":{"foo":"bar"}}`
+	if string(b) != h {
+		t.Errorf("Expected hook state %s to equal %s", string(b), h)
+	}
+}
+
+func TestParseSpecVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    configs.SpecVersion
+		invalid bool
+	}{
+		{version: "1.0.0", want: configs.SpecVersion{Major: 1}},
+		{version: "1.0.0-rc6-dev", want: configs.SpecVersion{Major: 1, RC: 6}},
+		{version: "1.2.3", want: configs.SpecVersion{Major: 1, Minor: 2, Patch: 3}},
+		{version: "1.0", invalid: true},
+		{version: "1.0.0-beta", invalid: true},
+		{version: "v1.0.0", invalid: true},
+	} {
+		v, err := configs.ParseSpecVersion(tc.version)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%q: expected error to occur but it was nil", tc.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: expected error to not occur but it was %+v", tc.version, err)
+			continue
+		}
+		if v != tc.want {
+			t.Errorf("%q: expected %+v but got %+v", tc.version, tc.want, v)
+		}
+	}
+	rc, _ := configs.ParseSpecVersion("1.0.0-rc5")
+	final, _ := configs.ParseSpecVersion("1.0.0")
+	if !rc.Less(final) || final.Less(rc) {
+		t.Error("Expected a release candidate to be older than the final release")
+	}
+}
+
 func TestHelperProcess(*testing.T) {
 	fmt.Println("Helper Process")
 	os.Exit(0)
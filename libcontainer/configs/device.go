@@ -48,6 +48,41 @@ func (d *Device) Mkdev() int {
 	return int((d.Major << 8) | (d.Minor & 0xff) | ((d.Minor & 0xfff00) << 12))
 }
 
+// MergeDefaultNodes returns devices followed by the defaults for which
+// devices holds no node with the same path.
+func MergeDefaultNodes(devices, defaults []*Device) []*Device {
+	merged := append([]*Device{}, devices...)
+	for _, d := range defaults {
+		if !containsDevice(devices, func(o *Device) bool { return o.Path == d.Path }) {
+			merged = append(merged, d)
+		}
+	}
+	return merged
+}
+
+// MergeDefaultRules returns rules followed by the defaults for which rules
+// holds no rule with the same type and numbers.
+func MergeDefaultRules(rules, defaults []*Device) []*Device {
+	merged := append([]*Device{}, rules...)
+	for _, d := range defaults {
+		if !containsDevice(rules, func(o *Device) bool {
+			return o.Type == d.Type && o.Major == d.Major && o.Minor == d.Minor
+		}) {
+			merged = append(merged, d)
+		}
+	}
+	return merged
+}
+
+func containsDevice(devices []*Device, match func(*Device) bool) bool {
+	for _, d := range devices {
+		if match(d) {
+			return true
+		}
+	}
+	return false
+}
+
 // deviceNumberString converts the device number to a string return result.
 func deviceNumberString(number int64) string {
 	if number == Wildcard {
@@ -0,0 +1,34 @@
+// +build linux freebsd
+
+package configs
+
+import "testing"
+
+func TestMergeDefaultNodes(t *testing.T) {
+	null := &Device{Path: "/dev/null", Type: 'c', Major: 1, Minor: 3, FileMode: 0600}
+	merged := MergeDefaultNodes([]*Device{null}, DefaultAutoCreatedDevices)
+	if len(merged) != len(DefaultAutoCreatedDevices) {
+		t.Fatalf("expected %d nodes, got %d", len(DefaultAutoCreatedDevices), len(merged))
+	}
+	for _, d := range merged {
+		if d.Path == "/dev/null" && d != null {
+			t.Fatal("expected the explicit /dev/null to override the default one")
+		}
+	}
+}
+
+func TestMergeDefaultRules(t *testing.T) {
+	deny := &Device{Type: 'c', Major: 10, Minor: 200, Permissions: "rwm"}
+	merged := MergeDefaultRules([]*Device{deny}, DefaultAllowedDevices)
+	if len(merged) != len(DefaultAllowedDevices) {
+		t.Fatalf("expected %d rules, got %d", len(DefaultAllowedDevices), len(merged))
+	}
+	if merged[0] != deny {
+		t.Fatal("expected the explicit rule to come first")
+	}
+	for _, d := range merged[1:] {
+		if d.Major == 10 && d.Minor == 200 {
+			t.Fatal("expected the explicit tuntap rule to override the default one")
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// +build linux
+
+package configs
+
+import (
+	"os/exec"
+	"syscall" // only for SysProcAttr
+
+	"golang.org/x/sys/unix"
+)
+
+// setHookProcessGroup has cmd started in a process group of its own, so
+// that the processes it forks can be killed along with it.
+func setHookProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killHookProcessGroup kills the process group started by cmd.
+func killHookProcessGroup(cmd *exec.Cmd) {
+	if err := unix.Kill(-cmd.Process.Pid, unix.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package configs
+
+import "os/exec"
+
+func setHookProcessGroup(cmd *exec.Cmd) {
+}
+
+// killHookProcessGroup only kills the process started by cmd, which has no
+// process group of its own.
+func killHookProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
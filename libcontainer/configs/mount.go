@@ -6,6 +6,13 @@ const (
 	EXT_COPYUP = 1 << iota
 )
 
+// MountOwner is the owner the files of a mount appear to have in the
+// container.
+type MountOwner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
 type Mount struct {
 	// Source path for the mount.
 	Source string `json:"source"`
@@ -31,6 +38,14 @@ type Mount struct {
 	// Extensions are additional flags that are specific to runc.
 	Extensions int `json:"extensions"`
 
+	// CopyUpLimit is the most bytes of file content copied up by EXT_COPYUP,
+	// or 0 for no limit.
+	CopyUpLimit int64 `json:"copyup_limit,omitempty"`
+
+	// ForcedOwner, if set, makes every file of a bind mount appear owned by
+	// a fixed uid and gid of the container, whoever owns them on the host.
+	ForcedOwner *MountOwner `json:"forced_owner,omitempty"`
+
 	// Optional Command to be run before Source is mounted.
 	PremountCmds []Command `json:"premount_cmds"`
 
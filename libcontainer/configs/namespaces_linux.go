@@ -7,12 +7,13 @@ import (
 )
 
 const (
-	NEWNET  NamespaceType = "NEWNET"
-	NEWPID  NamespaceType = "NEWPID"
-	NEWNS   NamespaceType = "NEWNS"
-	NEWUTS  NamespaceType = "NEWUTS"
-	NEWIPC  NamespaceType = "NEWIPC"
-	NEWUSER NamespaceType = "NEWUSER"
+	NEWNET    NamespaceType = "NEWNET"
+	NEWPID    NamespaceType = "NEWPID"
+	NEWNS     NamespaceType = "NEWNS"
+	NEWUTS    NamespaceType = "NEWUTS"
+	NEWIPC    NamespaceType = "NEWIPC"
+	NEWUSER   NamespaceType = "NEWUSER"
+	NEWCGROUP NamespaceType = "NEWCGROUP"
 )
 
 var (
@@ -35,6 +36,8 @@ func NsName(ns NamespaceType) string {
 		return "user"
 	case NEWUTS:
 		return "uts"
+	case NEWCGROUP:
+		return "cgroup"
 	}
 	return ""
 }
@@ -60,6 +63,11 @@ func IsNamespaceSupported(ns NamespaceType) bool {
 	return supported
 }
 
+// NamespaceTypes returns the namespace types in the order they are joined
+// by nsexec, whatever their order in the config. The user namespace comes
+// first, so that the others are joined with the privileges it grants, and
+// the pid namespace comes before the mount namespace, whose /proc may
+// belong to it. The cgroup namespace comes last.
 func NamespaceTypes() []NamespaceType {
 	return []NamespaceType{
 		NEWUSER, // Keep user NS always first, don't move it.
@@ -68,6 +76,7 @@ func NamespaceTypes() []NamespaceType {
 		NEWNET,
 		NEWPID,
 		NEWNS,
+		NEWCGROUP,
 	}
 }
 
@@ -76,6 +85,21 @@ func NamespaceTypes() []NamespaceType {
 type Namespace struct {
 	Type NamespaceType `json:"type"`
 	Path string        `json:"path"`
+
+	// File is an already opened namespace to be joined instead of Path. It is
+	// closed once the container's init process has joined it.
+	File *os.File `json:"-"`
+
+	// FromFile records that the namespace was joined through File, which
+	// cannot be persisted. A container loaded from its state can therefore
+	// not rejoin such a namespace by itself.
+	FromFile bool `json:"from_file,omitempty"`
+}
+
+// Joined returns true if the namespace is an existing one to be joined rather
+// than a new one to be created.
+func (n *Namespace) Joined() bool {
+	return n.Path != "" || n.File != nil || n.FromFile
 }
 
 func (n *Namespace) GetPath(pid int) string {
@@ -103,6 +127,32 @@ func (n *Namespaces) Add(t NamespaceType, path string) {
 	(*n)[i].Path = path
 }
 
+// Dedupe removes all the entries of each namespace type but the last one,
+// which is the one that takes effect, and returns the types which had more
+// than one entry.
+func (n *Namespaces) Dedupe() []NamespaceType {
+	var (
+		dups []NamespaceType
+		seen = make(map[NamespaceType]int)
+		kept Namespaces
+	)
+	for i := len(*n) - 1; i >= 0; i-- {
+		ns := (*n)[i]
+		seen[ns.Type]++
+		if seen[ns.Type] == 2 {
+			dups = append(dups, ns.Type)
+		}
+		if seen[ns.Type] > 1 {
+			continue
+		}
+		kept = append(Namespaces{ns}, kept...)
+	}
+	if len(dups) > 0 {
+		*n = kept
+	}
+	return dups
+}
+
 func (n *Namespaces) index(t NamespaceType) int {
 	for i, ns := range *n {
 		if ns.Type == t {
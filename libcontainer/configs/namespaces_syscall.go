@@ -2,19 +2,59 @@
 
 package configs
 
-import "golang.org/x/sys/unix"
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// nsGetNsType is the NS_GET_NSTYPE ioctl(2) request, which is only available
+// since Linux 4.11.
+const nsGetNsType = 0xb703
 
 func (n *Namespace) Syscall() int {
 	return namespaceInfo[n.Type]
 }
 
+// CheckPath verifies that the namespace path (or file) to be joined exists
+// and, if the kernel supports NS_GET_NSTYPE, that it refers to a namespace of
+// the expected type.
+func (n *Namespace) CheckPath() error {
+	f := n.File
+	if f == nil {
+		var err error
+		if f, err = os.Open(n.Path); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%s namespace path %q does not exist", NsName(n.Type), n.Path)
+			}
+			return fmt.Errorf("cannot open %s namespace path %q: %v", NsName(n.Type), n.Path, err)
+		}
+		defer f.Close()
+	}
+	t, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), nsGetNsType, 0)
+	if errno != 0 {
+		// Older kernels don't know about NS_GET_NSTYPE, so leave it to
+		// setns(2) to catch any mismatch.
+		if errno == unix.ENOTTY || errno == unix.EINVAL {
+			return nil
+		}
+		return fmt.Errorf("cannot get namespace type of %q: %v", f.Name(), errno)
+	}
+	if int(t) != n.Syscall() {
+		return fmt.Errorf("%s namespace path %q is not a %s namespace", NsName(n.Type), f.Name(), NsName(n.Type))
+	}
+	return nil
+}
+
 var namespaceInfo = map[NamespaceType]int{
-	NEWNET:  unix.CLONE_NEWNET,
-	NEWNS:   unix.CLONE_NEWNS,
-	NEWUSER: unix.CLONE_NEWUSER,
-	NEWIPC:  unix.CLONE_NEWIPC,
-	NEWUTS:  unix.CLONE_NEWUTS,
-	NEWPID:  unix.CLONE_NEWPID,
+	NEWNET:    unix.CLONE_NEWNET,
+	NEWNS:     unix.CLONE_NEWNS,
+	NEWUSER:   unix.CLONE_NEWUSER,
+	NEWIPC:    unix.CLONE_NEWIPC,
+	NEWUTS:    unix.CLONE_NEWUTS,
+	NEWPID:    unix.CLONE_NEWPID,
+	NEWCGROUP: unix.CLONE_NEWCGROUP,
 }
 
 // CloneFlags parses the container's Namespaces options to set the correct
@@ -22,7 +62,7 @@ var namespaceInfo = map[NamespaceType]int{
 func (n *Namespaces) CloneFlags() uintptr {
 	var flag int
 	for _, v := range *n {
-		if v.Path != "" {
+		if v.Joined() {
 			continue
 		}
 		flag |= namespaceInfo[v.Type]
@@ -2,18 +2,35 @@ package validate
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	selinux "github.com/opencontainers/selinux/go-selinux"
 )
 
+// maxSignal is the highest signal number, that of the last real-time signal.
+const maxSignal = 64
+
 type Validator interface {
 	Validate(*configs.Config) error
 }
 
+// Checker is a Validator which can also return the warnings about a config
+// rather than logging them.
+type Checker interface {
+	Validator
+	Check(*configs.Config) ([]configs.Warning, error)
+}
+
 func New() Validator {
 	return &ConfigValidator{}
 }
@@ -22,30 +39,81 @@ type ConfigValidator struct {
 }
 
 func (v *ConfigValidator) Validate(config *configs.Config) error {
+	warnings, err := v.Check(config)
+	for _, w := range warnings {
+		logrus.Warn(w.Message)
+	}
+	return err
+}
+
+// Check validates config like Validate, but returns the warnings about it
+// rather than logging them.
+func (v *ConfigValidator) Check(config *configs.Config) ([]configs.Warning, error) {
+	var warnings []configs.Warning
+	warn := func(w configs.Warning) {
+		warnings = append(warnings, w)
+	}
 	if err := v.rootfs(config); err != nil {
-		return err
+		return nil, err
+	}
+	if err := v.namespaces(config, warn); err != nil {
+		return nil, err
+	}
+	if err := v.version(config); err != nil {
+		return nil, err
 	}
 	if err := v.network(config); err != nil {
-		return err
+		return nil, err
 	}
 	if err := v.hostname(config); err != nil {
-		return err
+		return nil, err
 	}
 	if err := v.security(config); err != nil {
-		return err
+		return nil, err
+	}
+	if err := v.capabilities(config); err != nil {
+		return nil, err
+	}
+	if err := v.hooks(config); err != nil {
+		return nil, err
 	}
 	if err := v.usernamespace(config); err != nil {
-		return err
+		return nil, err
+	}
+	if err := v.mounts(config); err != nil {
+		return nil, err
+	}
+	if err := v.dev(config); err != nil {
+		return nil, err
 	}
 	if err := v.sysctl(config); err != nil {
-		return err
+		return nil, err
+	}
+	if err := v.resources(config); err != nil {
+		return nil, err
+	}
+	if err := v.cgroupPlacement(config); err != nil {
+		return nil, err
+	}
+	if err := v.cgroupFileShim(config); err != nil {
+		return nil, err
+	}
+	if config.StopSignal < 0 || config.StopSignal > maxSignal {
+		return nil, fmt.Errorf("invalid stop signal %d", config.StopSignal)
 	}
+	if config.StopTimeout < 0 {
+		return nil, fmt.Errorf("invalid stop timeout %s", config.StopTimeout)
+	}
+	if config.MaxLifetime < 0 {
+		return nil, fmt.Errorf("invalid max lifetime %s", config.MaxLifetime)
+	}
+	v.oomScoreAdj(config, warn)
 	if config.Rootless {
 		if err := v.rootless(config); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return warnings, nil
 }
 
 // rootfs validates if the rootfs is an absolute path and is not a symlink
@@ -70,6 +138,127 @@ func (v *ConfigValidator) rootfs(config *configs.Config) error {
 	return nil
 }
 
+// oomScoreAdj warns when the container is to be more protected from the OOM
+// killer than runc itself. Lowering the oom_score_adj inherited from runc
+// requires CAP_SYS_RESOURCE, so the container fails to start without it,
+// e.g. in a user namespace.
+func (v *ConfigValidator) oomScoreAdj(config *configs.Config, warn func(configs.Warning)) {
+	data, err := ioutil.ReadFile("/proc/self/oom_score_adj")
+	if err != nil {
+		return
+	}
+	own, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return
+	}
+	if config.OomScoreAdj < own {
+		warn(configs.Warning{
+			Code:      configs.WarnOomScoreAdj,
+			FieldPath: "oom_score_adj",
+			Message:   fmt.Sprintf("oom_score_adj %d is lower than the %d of runc, which requires CAP_SYS_RESOURCE", config.OomScoreAdj, own),
+		})
+	}
+}
+
+// version validates that the spec version of the config, which is reported
+// to hooks as the ociVersion of the state, is one we are able to honour.
+func (v *ConfigValidator) version(config *configs.Config) error {
+	if config.Version == "" {
+		return nil
+	}
+	sv, err := configs.ParseSpecVersion(config.Version)
+	if err != nil {
+		return err
+	}
+	if sv.Major != specs.VersionMajor {
+		return fmt.Errorf("unsupported spec version %q, expected major version %d", config.Version, specs.VersionMajor)
+	}
+	return nil
+}
+
+// namespaces validates that every namespace of config to be created is
+// supported by the kernel, and that the paths of those to be joined are
+// valid, after removing duplicates of a type, keeping the last one.
+func (v *ConfigValidator) namespaces(config *configs.Config, warn func(configs.Warning)) error {
+	if err := cgroupNamespace(config.Namespaces); err != nil {
+		return err
+	}
+	for _, t := range config.Namespaces.Dedupe() {
+		warn(configs.Warning{
+			Code:      configs.WarnDuplicateNamespace,
+			FieldPath: "namespaces",
+			Message:   fmt.Sprintf("%s namespace is listed more than once, only the last entry is used", configs.NsName(t)),
+		})
+	}
+	for _, ns := range config.Namespaces {
+		// nsexec takes paths starting with "fd:" for an inherited file
+		// descriptor, which a path given in the config cannot refer to.
+		if strings.HasPrefix(ns.Path, "fd:") {
+			return fmt.Errorf("%s namespace path %q is not a file", configs.NsName(ns.Type), ns.Path)
+		}
+		if ns.Path != "" || ns.File != nil {
+			if err := ns.CheckPath(); err != nil {
+				return err
+			}
+			continue
+		}
+		if ns.FromFile {
+			return fmt.Errorf("%s namespace was joined from a file which is no longer available", configs.NsName(ns.Type))
+		}
+		name := configs.NsName(ns.Type)
+		if name == "" {
+			return fmt.Errorf("unknown namespace type %q", ns.Type)
+		}
+		if !configs.IsNamespaceSupported(ns.Type) {
+			return fmt.Errorf("%s namespaces requested but not supported by this kernel (missing /proc/self/ns/%s)", name, name)
+		}
+	}
+	return nil
+}
+
+// cgroupNamespace refuses a cgroup namespace listed both to be created and
+// joined, as templates tend to produce. Unlike for the other types, the last
+// entry cannot simply be kept: creating one and joining one give the
+// container a different view of its cgroups.
+func cgroupNamespace(namespaces configs.Namespaces) error {
+	var created bool
+	path := ""
+	for _, ns := range namespaces {
+		if ns.Type != configs.NEWCGROUP {
+			continue
+		}
+		if !ns.Joined() {
+			created = true
+		} else if path == "" {
+			path = ns.Path
+			if ns.File != nil {
+				path = ns.File.Name()
+			}
+		}
+	}
+	if created && path != "" {
+		return fmt.Errorf("cgroup namespace is listed both to be created and to be joined at %q, keep only one of them", path)
+	}
+	return nil
+}
+
+// cgroupFileShim refuses the /proc/self/cgroup shim whenever the kernel
+// supports cgroup namespaces, and without a /proc mount to put it on.
+func (v *ConfigValidator) cgroupFileShim(config *configs.Config) error {
+	if !config.CgroupFileShim {
+		return nil
+	}
+	if config.Namespaces.Contains(configs.NEWCGROUP) || configs.IsNamespaceSupported(configs.NEWCGROUP) {
+		return fmt.Errorf("the cgroup file shim is only for kernels without cgroup namespaces, use a cgroup namespace instead")
+	}
+	for _, m := range config.Mounts {
+		if m.Device == "proc" && filepath.Clean(m.Destination) == "/proc" {
+			return nil
+		}
+	}
+	return fmt.Errorf("the cgroup file shim requires proc to be mounted at /proc")
+}
+
 func (v *ConfigValidator) network(config *configs.Config) error {
 	if !config.Namespaces.Contains(configs.NEWNET) {
 		if len(config.Networks) > 0 || len(config.Routes) > 0 {
@@ -92,6 +281,10 @@ func (v *ConfigValidator) security(config *configs.Config) error {
 		!config.Namespaces.Contains(configs.NEWNS) {
 		return fmt.Errorf("unable to restrict sys entries without a private MNT namespace")
 	}
+	// Without one, the container sees the state root as the host does.
+	if config.StrictStateRoot && !config.Namespaces.Contains(configs.NEWNS) {
+		return fmt.Errorf("unable to hide the state root without a private MNT namespace")
+	}
 	if config.ProcessLabel != "" && !selinux.GetEnabled() {
 		return fmt.Errorf("selinux label is specified in config, but selinux is disabled or not supported")
 	}
@@ -99,6 +292,41 @@ func (v *ConfigValidator) security(config *configs.Config) error {
 	return nil
 }
 
+// capabilities refuses ambient capabilities on kernels which do not support
+// them, unless the config allows them to be dropped.
+func (v *ConfigValidator) capabilities(config *configs.Config) error {
+	caps := config.Capabilities
+	if caps == nil || len(caps.Ambient) == 0 || config.AllowAmbientFallback || system.GetFeatures().AmbientCapabilities {
+		return nil
+	}
+	return fmt.Errorf("ambient capabilities require kernel >= 4.3")
+}
+
+// hooks validates the sandboxes of the command hooks, whose binary and
+// paths are bind-mounted at the same place in their mount namespace.
+func (v *ConfigValidator) hooks(config *configs.Config) error {
+	if config.Hooks == nil {
+		return nil
+	}
+	for _, hooks := range [][]configs.Hook{config.Hooks.Prestart, config.Hooks.Poststart, config.Hooks.Poststop} {
+		for _, hook := range hooks {
+			ch, ok := hook.(configs.CommandHook)
+			if !ok || ch.Sandbox == nil || !ch.Sandbox.MountNamespace {
+				continue
+			}
+			if !filepath.IsAbs(ch.Path) {
+				return fmt.Errorf("sandboxed hook path %q is not absolute", ch.Path)
+			}
+			for _, p := range ch.Sandbox.Paths {
+				if !filepath.IsAbs(p) {
+					return fmt.Errorf("sandboxed hook %s: path %q is not absolute", ch.Path, p)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (v *ConfigValidator) usernamespace(config *configs.Config) error {
 	if config.Namespaces.Contains(configs.NEWUSER) {
 		if _, err := os.Stat("/proc/self/ns/user"); os.IsNotExist(err) {
@@ -112,6 +340,42 @@ func (v *ConfigValidator) usernamespace(config *configs.Config) error {
 	return nil
 }
 
+// mounts validates the mounts with a forced owner, which must be bind mounts
+// and be owned by ids which the container maps.
+func (v *ConfigValidator) mounts(config *configs.Config) error {
+	for _, m := range config.Mounts {
+		o := m.ForcedOwner
+		if o == nil {
+			continue
+		}
+		if m.Device != "bind" {
+			return fmt.Errorf("only bind mounts can have a forced owner, not %s", m.Destination)
+		}
+		if config.Rootless {
+			return fmt.Errorf("rootless containers cannot have mounts with a forced owner")
+		}
+		if o.UID < 0 || o.GID < 0 {
+			return fmt.Errorf("invalid forced owner %d:%d of %s", o.UID, o.GID, m.Destination)
+		}
+		if _, err := config.HostUID(o.UID); err != nil {
+			return fmt.Errorf("forced owner of %s: %v", m.Destination, err)
+		}
+		if _, err := config.HostGID(o.GID); err != nil {
+			return fmt.Errorf("forced owner of %s: %v", m.Destination, err)
+		}
+	}
+	return nil
+}
+
+// dev validates the size and the number of inodes of the tmpfs mounted at
+// /dev, of which zero stands for the default.
+func (v *ConfigValidator) dev(config *configs.Config) error {
+	if d := config.Dev; d != nil && (d.Size < 0 || d.Inodes < 0) {
+		return fmt.Errorf("invalid size %d or inodes %d of /dev", d.Size, d.Inodes)
+	}
+	return nil
+}
+
 // sysctl validates that the specified sysctl keys are valid or not.
 // /proc/sys isn't completely namespaced and depending on which namespaces
 // are specified, a subset of sysctls are permitted.
@@ -153,6 +417,103 @@ func (v *ConfigValidator) sysctl(config *configs.Config) error {
 	return nil
 }
 
+// resources checks that limits are set either absolutely or as a percentage
+// of the host, that percentages are in range, and that cpu idle is supported.
+func (v *ConfigValidator) resources(config *configs.Config) error {
+	if config.Cgroups == nil || config.Cgroups.Resources == nil {
+		return nil
+	}
+	r := config.Cgroups.Resources
+	for _, limit := range []struct {
+		name     string
+		percent  float64
+		absolute bool
+	}{
+		{"memory", r.MemoryPercent, r.Memory != 0},
+		{"cpu", r.CpuPercent, r.CpuQuota != 0},
+	} {
+		if limit.percent < 0 || limit.percent > 100 {
+			return fmt.Errorf("%s percentage %g is not between 0 and 100", limit.name, limit.percent)
+		}
+		if limit.percent != 0 && limit.absolute {
+			return fmt.Errorf("%s limit cannot be set both as an absolute value and as a percentage", limit.name)
+		}
+	}
+	if r.CPUIdle != nil {
+		if *r.CPUIdle != 0 && *r.CPUIdle != 1 {
+			return fmt.Errorf("cpu idle %d is neither 0 nor 1", *r.CPUIdle)
+		}
+		if !cgroups.IsCgroup2UnifiedMode() {
+			return fmt.Errorf("cpu idle is only supported on cgroup v2")
+		}
+	}
+	return nil
+}
+
+// cgroupPlacement checks that the cgroup of the container can be created
+// where it is placed in the unified hierarchy, which it cannot under a
+// threaded subtree unless it is threaded itself, and that a threaded cgroup
+// only limits what threaded cgroups can.
+func (v *ConfigValidator) cgroupPlacement(config *configs.Config) error {
+	c := config.Cgroups
+	if c == nil {
+		return nil
+	}
+	if !cgroups.IsCgroup2UnifiedMode() {
+		if c.Threaded {
+			return fmt.Errorf("threaded cgroups are only supported on cgroup v2")
+		}
+		return nil
+	}
+	if c.Threaded {
+		if err := threadedResources(c.Resources); err != nil {
+			return err
+		}
+	}
+	// The cgroups joined by path were placed by someone else.
+	if c.Paths != nil {
+		return nil
+	}
+	innerPath := utils.CleanPath(c.Path)
+	if innerPath == "" {
+		innerPath = filepath.Join(utils.CleanPath(c.Parent), utils.CleanPath(c.Name))
+	}
+	dir, err := cgroups.UnifiedPath(innerPath)
+	if err != nil {
+		return fmt.Errorf("unable to find the cgroup of the container: %v", err)
+	}
+	ancestor, cgType, err := cgroups.FindThreadedAncestor(cgroups.UnifiedMountpoint, dir)
+	if err != nil {
+		return fmt.Errorf("unable to check the type of the cgroup of the container: %v", err)
+	}
+	if ancestor != "" && !c.Threaded {
+		return fmt.Errorf("cgroup %s is of type %q, so only a threaded cgroup can be placed in it", ancestor, cgType)
+	}
+	return nil
+}
+
+// threadedResources checks that r only sets the resources of the cpu and
+// cpuset controllers, the only ones of a threaded cgroup.
+func threadedResources(r *configs.Resources) error {
+	if r == nil {
+		return nil
+	}
+	for _, limit := range []struct {
+		controller string
+		set        bool
+	}{
+		{"memory", r.Memory != 0 || r.MemoryPercent != 0 || r.MemoryReservation != 0 || r.MemorySwap != 0 || r.KernelMemory != 0 || r.KernelMemoryTCP != 0 || r.OomKillDisable || r.MemorySwappiness != nil},
+		{"pids", r.PidsLimit != 0},
+		{"io", r.BlkioWeight != 0 || r.BlkioLeafWeight != 0 || len(r.BlkioWeightDevice) > 0 || len(r.BlkioThrottleReadBpsDevice) > 0 || len(r.BlkioThrottleWriteBpsDevice) > 0 || len(r.BlkioThrottleReadIOPSDevice) > 0 || len(r.BlkioThrottleWriteIOPSDevice) > 0},
+		{"hugetlb", len(r.HugetlbLimit) > 0},
+	} {
+		if limit.set {
+			return fmt.Errorf("a threaded cgroup only has the cpu and cpuset controllers, %s limits cannot be set", limit.controller)
+		}
+	}
+	return nil
+}
+
 func isSymbolicLink(path string) (bool, error) {
 	fi, err := os.Lstat(path)
 	if err != nil {
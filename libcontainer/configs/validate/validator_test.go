@@ -1,11 +1,19 @@
 package validate_test
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/configs/validate"
+	"github.com/opencontainers/runc/libcontainer/system"
 )
 
 func TestValidate(t *testing.T) {
@@ -66,6 +74,23 @@ func TestValidateNetworkRoutesWithoutNETNamespace(t *testing.T) {
 	}
 }
 
+func TestValidateNamespaceFdPath(t *testing.T) {
+	config := &configs.Config{
+		Rootfs: "/var",
+		Namespaces: configs.Namespaces(
+			[]configs.Namespace{
+				{Type: configs.NEWNET, Path: "fd:3"},
+			},
+		),
+	}
+
+	validator := validate.New()
+	err := validator.Validate(config)
+	if err == nil {
+		t.Error("Expected error to occur but it was nil")
+	}
+}
+
 func TestValidateHostname(t *testing.T) {
 	config := &configs.Config{
 		Rootfs:   "/var",
@@ -147,6 +172,22 @@ func TestValidateSecurityWithoutNEWNS(t *testing.T) {
 	}
 }
 
+func TestValidateStrictStateRootWithoutNEWNS(t *testing.T) {
+	config := &configs.Config{
+		Rootfs:          "/var",
+		StrictStateRoot: true,
+	}
+
+	validator := validate.New()
+	if err := validator.Validate(config); err == nil {
+		t.Error("Expected error to occur but it was nil")
+	}
+	config.Namespaces = configs.Namespaces([]configs.Namespace{{Type: configs.NEWNS}})
+	if err := validator.Validate(config); err != nil {
+		t.Errorf("Expected error to not occur: %+v", err)
+	}
+}
+
 func TestValidateUsernamespace(t *testing.T) {
 	if _, err := os.Stat("/proc/self/ns/user"); os.IsNotExist(err) {
 		t.Skip("userns is unsupported")
@@ -265,3 +306,358 @@ func TestValidateSysctlWithoutNETNamespace(t *testing.T) {
 		t.Error("Expected error to occur but it was nil")
 	}
 }
+
+func TestValidateNamespacePathMissing(t *testing.T) {
+	config := &configs.Config{
+		Rootfs: "/var",
+		Namespaces: configs.Namespaces(
+			[]configs.Namespace{
+				{
+					Type: configs.NEWNET,
+					Path: "/proc/self/ns/doesnotexist",
+				},
+			},
+		),
+	}
+
+	validator := validate.New()
+	err := validator.Validate(config)
+	if err == nil {
+		t.Error("Expected error to occur but it was nil")
+	}
+}
+
+func TestValidateNamespacePathWrongType(t *testing.T) {
+	config := &configs.Config{
+		Rootfs: "/var",
+		Namespaces: configs.Namespaces(
+			[]configs.Namespace{
+				{
+					Type: configs.NEWNET,
+					Path: "/proc/self/ns/uts",
+				},
+			},
+		),
+	}
+
+	validator := validate.New()
+	err := validator.Validate(config)
+	if err == nil {
+		t.Error("Expected error to occur but it was nil")
+	}
+}
+
+func TestValidateNamespaceFile(t *testing.T) {
+	f, err := os.Open("/proc/self/ns/net")
+	if err != nil {
+		t.Skip("cannot open network namespace:", err)
+	}
+	defer f.Close()
+	config := &configs.Config{
+		Rootfs: "/var",
+		Namespaces: configs.Namespaces(
+			[]configs.Namespace{
+				{
+					Type: configs.NEWNET,
+					File: f,
+				},
+			},
+		),
+	}
+
+	validator := validate.New()
+	if err := validator.Validate(config); err != nil {
+		t.Errorf("Expected error to not occur: %+v", err)
+	}
+	if config.Namespaces.CloneFlags() != 0 {
+		t.Error("Expected namespace joined from a file not to be cloned")
+	}
+
+	config.Namespaces[0].Type = configs.NEWUTS
+	if err := validator.Validate(config); err == nil {
+		t.Error("Expected error to occur but it was nil")
+	}
+}
+
+func TestValidateOomScoreAdjBelowOwn(t *testing.T) {
+	data, err := ioutil.ReadFile("/proc/self/oom_score_adj")
+	if err != nil {
+		t.Skip(err)
+	}
+	own, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own == -1000 {
+		t.Skip("oom_score_adj is already the lowest")
+	}
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	config := &configs.Config{
+		Rootfs:      "/var",
+		OomScoreAdj: -1000,
+	}
+	validator := validate.New()
+	if err := validator.Validate(config); err != nil {
+		t.Errorf("Expected error to not occur: %+v", err)
+	}
+	if !strings.Contains(buf.String(), "oom_score_adj") {
+		t.Errorf("Expected a warning about oom_score_adj, got %q", buf.String())
+	}
+}
+
+func TestValidateResourcePercentages(t *testing.T) {
+	validator := validate.New()
+	for _, r := range []configs.Resources{
+		{Memory: 1 << 20, MemoryPercent: 50},
+		{CpuQuota: 50000, CpuPercent: 50},
+		{MemoryPercent: 150},
+		{CpuPercent: -1},
+	} {
+		r := r
+		config := &configs.Config{
+			Rootfs:  "/var",
+			Cgroups: &configs.Cgroup{Resources: &r},
+		}
+		if err := validator.Validate(config); err == nil {
+			t.Errorf("Expected error to occur for %+v", r)
+		}
+	}
+	config := &configs.Config{
+		Rootfs:  "/var",
+		Cgroups: &configs.Cgroup{Resources: &configs.Resources{MemoryPercent: 50, CpuPercent: 25}},
+	}
+	if err := validator.Validate(config); err != nil {
+		t.Errorf("Expected error to not occur: %+v", err)
+	}
+}
+
+func TestValidateCPUIdle(t *testing.T) {
+	validator := validate.New()
+	for _, idle := range []int64{-1, 2} {
+		idle := idle
+		config := &configs.Config{
+			Rootfs:  "/var",
+			Cgroups: &configs.Cgroup{Resources: &configs.Resources{CPUIdle: &idle}},
+		}
+		if err := validator.Validate(config); err == nil {
+			t.Errorf("Expected error to occur for cpu idle %d", idle)
+		}
+	}
+	if cgroups.IsCgroup2UnifiedMode() {
+		t.Skip("cpu idle is supported on cgroup v2")
+	}
+	idle := int64(1)
+	config := &configs.Config{
+		Rootfs:  "/var",
+		Cgroups: &configs.Cgroup{Resources: &configs.Resources{CPUIdle: &idle}},
+	}
+	if err := validator.Validate(config); err == nil {
+		t.Error("Expected error to occur for cpu idle on cgroup v1")
+	}
+}
+
+func TestValidateMaxLifetime(t *testing.T) {
+	validator := validate.New()
+	config := &configs.Config{Rootfs: "/var", MaxLifetime: -time.Second}
+	if err := validator.Validate(config); err == nil {
+		t.Error("Expected error to occur for a negative max lifetime")
+	}
+}
+
+func TestValidateDuplicateNamespaces(t *testing.T) {
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	config := &configs.Config{
+		Rootfs: "/var",
+		Namespaces: configs.Namespaces{
+			{Type: configs.NEWUTS, Path: "/nonexistent"},
+			{Type: configs.NEWNS},
+			{Type: configs.NEWUTS},
+		},
+	}
+	validator := validate.New()
+	if err := validator.Validate(config); err != nil {
+		t.Errorf("Expected error to not occur: %+v", err)
+	}
+	if len(config.Namespaces) != 2 || config.Namespaces.PathOf(configs.NEWUTS) != "" {
+		t.Errorf("Expected the last uts namespace to be kept, got %+v", config.Namespaces)
+	}
+	if !strings.Contains(buf.String(), "uts namespace") {
+		t.Errorf("Expected a warning about the uts namespace, got %q", buf.String())
+	}
+}
+
+func TestCheckDuplicateNamespaces(t *testing.T) {
+	config := &configs.Config{
+		Rootfs: "/var",
+		Namespaces: configs.Namespaces{
+			{Type: configs.NEWUTS},
+			{Type: configs.NEWUTS},
+		},
+	}
+	warnings, err := validate.New().(validate.Checker).Check(config)
+	if err != nil {
+		t.Fatalf("Expected error to not occur: %+v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Code != configs.WarnDuplicateNamespace || warnings[0].FieldPath != "namespaces" {
+		t.Errorf("Expected a duplicate-namespace warning, got %+v", warnings)
+	}
+}
+
+func TestValidateCgroupNamespaceCreatedAndJoined(t *testing.T) {
+	config := &configs.Config{
+		Rootfs: "/var",
+		Namespaces: configs.Namespaces{
+			{Type: configs.NEWCGROUP},
+			{Type: configs.NEWCGROUP, Path: "/proc/self/ns/cgroup"},
+		},
+	}
+	validator := validate.New()
+	err := validator.Validate(config)
+	if err == nil || !strings.Contains(err.Error(), "/proc/self/ns/cgroup") {
+		t.Errorf("Expected the ambiguous cgroup namespace to be refused, got %v", err)
+	}
+}
+
+func TestValidateAmbientCapabilities(t *testing.T) {
+	config := &configs.Config{
+		Rootfs:       "/var",
+		Capabilities: &configs.Capabilities{Ambient: []string{"CAP_NET_BIND_SERVICE"}},
+	}
+	validator := validate.New()
+	err := validator.Validate(config)
+	if system.GetFeatures().AmbientCapabilities {
+		if err != nil {
+			t.Fatalf("expected ambient capabilities to be accepted: %v", err)
+		}
+	} else if err == nil || !strings.Contains(err.Error(), "kernel >= 4.3") {
+		t.Fatalf("expected ambient capabilities to be refused, got %v", err)
+	}
+	config.AllowAmbientFallback = true
+	if err := validator.Validate(config); err != nil {
+		t.Fatalf("expected ambient capabilities to be accepted with a fallback: %v", err)
+	}
+}
+
+func TestValidateStopSignal(t *testing.T) {
+	validator := validate.New()
+	for _, sig := range []int{-1, 65} {
+		config := &configs.Config{Rootfs: "/var", StopSignal: sig}
+		if err := validator.Validate(config); err == nil {
+			t.Errorf("expected stop signal %d to be rejected", sig)
+		}
+	}
+}
+
+func TestValidateStopTimeout(t *testing.T) {
+	config := &configs.Config{Rootfs: "/var", StopTimeout: -time.Second}
+	if err := validate.New().Validate(config); err == nil {
+		t.Fatal("expected a negative stop timeout to be rejected")
+	}
+}
+
+func TestValidateSandboxedHookPaths(t *testing.T) {
+	hook := configs.NewCommandHook(configs.Command{
+		Path:    "/usr/bin/hook",
+		Sandbox: &configs.HookSandbox{MountNamespace: true, Paths: []string{"lib"}},
+	})
+	config := &configs.Config{
+		Rootfs: "/var",
+		Hooks:  &configs.Hooks{Prestart: []configs.Hook{hook}},
+	}
+	if err := validate.New().Validate(config); err == nil {
+		t.Fatal("expected a relative sandbox path to be rejected")
+	}
+	hook.Sandbox.Paths = []string{"/lib"}
+	config.Hooks.Prestart[0] = hook
+	if err := validate.New().Validate(config); err != nil {
+		t.Fatalf("expected absolute sandbox paths to be accepted: %v", err)
+	}
+}
+
+func TestValidateThreadedCgroup(t *testing.T) {
+	config := &configs.Config{
+		Rootfs: "/var",
+		Cgroups: &configs.Cgroup{
+			Path:      "/threaded-test",
+			Threaded:  true,
+			Resources: &configs.Resources{Memory: 1 << 20},
+		},
+	}
+	err := validate.New().Validate(config)
+	if err == nil {
+		t.Fatal("expected a threaded cgroup limiting memory to be rejected")
+	}
+	if !cgroups.IsCgroup2UnifiedMode() && !strings.Contains(err.Error(), "cgroup v2") {
+		t.Fatalf("expected a threaded cgroup to require cgroup v2, got %v", err)
+	}
+	if cgroups.IsCgroup2UnifiedMode() && !strings.Contains(err.Error(), "memory") {
+		t.Fatalf("expected the memory limit to be rejected, got %v", err)
+	}
+}
+
+func TestValidateForcedOwner(t *testing.T) {
+	mount := &configs.Mount{
+		Source:      "/var/cache",
+		Destination: "/cache",
+		Device:      "bind",
+		ForcedOwner: &configs.MountOwner{UID: 1000, GID: 1000},
+	}
+	config := &configs.Config{
+		Rootfs: "/var",
+		Mounts: []*configs.Mount{mount},
+	}
+	if err := validate.New().Validate(config); err != nil {
+		t.Fatalf("expected a bind mount with a forced owner to be accepted: %v", err)
+	}
+	config.Namespaces = configs.Namespaces{{Type: configs.NEWUSER}}
+	config.UidMappings = []configs.IDMap{{ContainerID: 0, HostID: 100000, Size: 1000}}
+	config.GidMappings = []configs.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	if err := validate.New().Validate(config); err == nil {
+		t.Fatal("expected a forced owner the container does not map to be rejected")
+	}
+	config.Namespaces, config.UidMappings, config.GidMappings = nil, nil, nil
+	mount.Device = "tmpfs"
+	if err := validate.New().Validate(config); err == nil {
+		t.Fatal("expected a tmpfs with a forced owner to be rejected")
+	}
+}
+
+func TestValidateDev(t *testing.T) {
+	validator := validate.New()
+	config := &configs.Config{
+		Rootfs: "/var",
+		Dev:    &configs.Dev{Size: 1 << 20, Inodes: 1024},
+	}
+	if err := validator.Validate(config); err != nil {
+		t.Errorf("expected error to not occur: %+v", err)
+	}
+	config.Dev.Size = -1
+	if err := validator.Validate(config); err == nil {
+		t.Error("expected error to occur for a negative /dev size")
+	}
+}
+
+func TestValidateCgroupFileShim(t *testing.T) {
+	validator := validate.New()
+	config := &configs.Config{
+		Rootfs:         "/var",
+		CgroupFileShim: true,
+	}
+	err := validator.Validate(config)
+	if err == nil {
+		t.Fatal("expected error to occur")
+	}
+	if configs.IsNamespaceSupported(configs.NEWCGROUP) {
+		if !strings.Contains(err.Error(), "cgroup namespace") {
+			t.Errorf("expected the shim to be refused for cgroup namespaces, got %v", err)
+		}
+	} else if !strings.Contains(err.Error(), "/proc") {
+		t.Errorf("expected the shim to be refused without /proc, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package configs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SpecVersion is a parsed OCI runtime specification version of the form
+// "major.minor.patch" with an optional "-rcN" and "-dev" suffix.
+type SpecVersion struct {
+	Major int
+	Minor int
+	Patch int
+	// RC is the release candidate number, or 0 for a final release.
+	RC int
+}
+
+// ParseSpecVersion parses an OCI runtime specification version.
+func ParseSpecVersion(v string) (SpecVersion, error) {
+	var sv SpecVersion
+	s := strings.TrimSuffix(v, "-dev")
+	if i := strings.Index(s, "-"); i >= 0 {
+		rc := s[i+1:]
+		s = s[:i]
+		if !strings.HasPrefix(rc, "rc") {
+			return sv, fmt.Errorf("invalid spec version %q", v)
+		}
+		n, err := strconv.Atoi(rc[2:])
+		if err != nil || n <= 0 {
+			return sv, fmt.Errorf("invalid spec version %q", v)
+		}
+		sv.RC = n
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return sv, fmt.Errorf("invalid spec version %q", v)
+	}
+	for i, p := range []*int{&sv.Major, &sv.Minor, &sv.Patch} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return sv, fmt.Errorf("invalid spec version %q", v)
+		}
+		*p = n
+	}
+	return sv, nil
+}
+
+// Less returns true if v is an older version than o. Release candidates
+// sort before the final release they precede.
+func (v SpecVersion) Less(o SpecVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	if v.Patch != o.Patch {
+		return v.Patch < o.Patch
+	}
+	if v.RC == 0 || o.RC == 0 {
+		return v.RC != 0 && o.RC == 0
+	}
+	return v.RC < o.RC
+}
@@ -0,0 +1,53 @@
+package configs
+
+import "fmt"
+
+// WarningCode identifies the kind of a Warning, for callers to tell them
+// apart without matching their messages.
+type WarningCode string
+
+const (
+	// WarnOomScoreAdj reports an oom_score_adj lower than that of the
+	// runtime, which requires CAP_SYS_RESOURCE.
+	WarnOomScoreAdj WarningCode = "oom-score-adj"
+	// WarnDuplicateNamespace reports a namespace type listed more than
+	// once, of which only the last entry is used.
+	WarnDuplicateNamespace WarningCode = "duplicate-namespace"
+	// WarnAmbientCapsDropped reports ambient capabilities dropped because
+	// the kernel does not support them.
+	WarnAmbientCapsDropped WarningCode = "ambient-caps-dropped"
+	// WarnInitIncompatible reports a container init which is not fully
+	// compatible with the runtime.
+	WarnInitIncompatible WarningCode = "init-incompatible"
+	// WarnHookFailed reports a hook which failed without failing the
+	// container, as poststart hooks do.
+	WarnHookFailed WarningCode = "hook-failed"
+	// WarnHook reports a warning returned by a hook in its response.
+	WarnHook WarningCode = "hook"
+	// WarnDevicesNotEnforced reports device rules which the cgroups of the
+	// container cannot enforce.
+	WarnDevicesNotEnforced WarningCode = "devices-not-enforced"
+	// WarnCgroupRepairFailed reports that the cgroups of the container could
+	// not be set again once they drifted.
+	WarnCgroupRepairFailed WarningCode = "cgroup-repair-failed"
+	// WarnNamespaceUnavailable reports a namespace joined from a file which
+	// a loaded container can no longer reach.
+	WarnNamespaceUnavailable WarningCode = "namespace-unavailable"
+)
+
+// Warning is something which went wrong with a config, or with creating,
+// starting or updating a container from it, without failing.
+type Warning struct {
+	Code WarningCode `json:"code"`
+	// FieldPath is the path of the field of the config the warning is
+	// about, e.g. "capabilities.ambient", if any.
+	FieldPath string `json:"field_path,omitempty"`
+	Message   string `json:"message"`
+}
+
+func (w Warning) String() string {
+	if w.FieldPath == "" {
+		return fmt.Sprintf("%s: %s", w.Code, w.Message)
+	}
+	return fmt.Sprintf("%s (%s): %s", w.Code, w.FieldPath, w.Message)
+}
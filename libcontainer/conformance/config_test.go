@@ -0,0 +1,98 @@
+// +build linux,conformance
+
+package conformance
+
+import (
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+var environment = []string{
+	"HOME=/root",
+	"PATH=/bin",
+	"TERM=xterm",
+}
+
+const defaultMountFlags = unix.MS_NOEXEC | unix.MS_NOSUID | unix.MS_NODEV
+
+var capabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FOWNER",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+}
+
+// newConfig returns the config of a container running on rootfs in the
+// cgroup at cgroupPath for the variant v.
+func newConfig(rootfs, cgroupPath string, v variant) *configs.Config {
+	allowAllDevices := false
+	config := &configs.Config{
+		Rootfs: rootfs,
+		Capabilities: &configs.Capabilities{
+			Bounding:    capabilities,
+			Permitted:   capabilities,
+			Inheritable: capabilities,
+			Effective:   capabilities,
+		},
+		Namespaces: configs.Namespaces([]configs.Namespace{
+			{Type: configs.NEWNS},
+			{Type: configs.NEWUTS},
+			{Type: configs.NEWIPC},
+			{Type: configs.NEWPID},
+			{Type: configs.NEWNET},
+		}),
+		Cgroups: &configs.Cgroup{
+			Path: cgroupPath,
+			Resources: &configs.Resources{
+				AllowAllDevices: &allowAllDevices,
+				AllowedDevices:  configs.DefaultAllowedDevices,
+			},
+		},
+		Devices:  configs.DefaultAutoCreatedDevices,
+		Hostname: "conformance",
+		Mounts: []*configs.Mount{
+			{
+				Source:      "proc",
+				Destination: "/proc",
+				Device:      "proc",
+				Flags:       defaultMountFlags,
+			},
+			{
+				Source:      "tmpfs",
+				Destination: "/dev",
+				Device:      "tmpfs",
+				Flags:       unix.MS_NOSUID | unix.MS_STRICTATIME,
+				Data:        "mode=755",
+			},
+			{
+				Source:      "devpts",
+				Destination: "/dev/pts",
+				Device:      "devpts",
+				Flags:       unix.MS_NOSUID | unix.MS_NOEXEC,
+				Data:        "newinstance,ptmxmode=0666,mode=0620",
+			},
+			{
+				Source:      "sysfs",
+				Destination: "/sys",
+				Device:      "sysfs",
+				Flags:       defaultMountFlags | unix.MS_RDONLY,
+			},
+		},
+		Networks: []*configs.Network{
+			{
+				Type:    "loopback",
+				Address: "127.0.0.1/0",
+				Gateway: "localhost",
+			},
+		},
+	}
+	if v.userns {
+		config.UidMappings = []configs.IDMap{{HostID: 0, ContainerID: 0, Size: 1000}}
+		config.GidMappings = []configs.IDMap{{HostID: 0, ContainerID: 0, Size: 1000}}
+		config.Namespaces = append(config.Namespaces, configs.Namespace{Type: configs.NEWUSER})
+	}
+	return config
+}
@@ -0,0 +1,12 @@
+// +build linux,conformance
+
+// Package conformance runs the lifecycle of a container through the public
+// libcontainer API for a matrix of configurations: with and without a user
+// namespace, a terminal and cgroup v2. The rootfs is built from the busybox
+// binary of the host, or the one named by $CONFORMANCE_BUSYBOX, which has
+// to be statically linked.
+//
+// The tests need root and are only built with the conformance tag:
+//
+//	go test -tags conformance ./libcontainer/conformance
+package conformance
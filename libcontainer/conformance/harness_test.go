@@ -0,0 +1,341 @@
+// +build linux,conformance
+
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall" // only for WaitStatus
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitTimeout is how long a process may take to exit before the scenario
+// waiting for it fails.
+const waitTimeout = 30 * time.Second
+
+// variant is a point of the configuration matrix every scenario is run for.
+type variant struct {
+	userns   bool
+	terminal bool
+	cgroupV2 bool
+}
+
+var variants = func() []variant {
+	var vs []variant
+	for _, userns := range []bool{false, true} {
+		for _, terminal := range []bool{false, true} {
+			for _, cgroupV2 := range []bool{false, true} {
+				vs = append(vs, variant{userns: userns, terminal: terminal, cgroupV2: cgroupV2})
+			}
+		}
+	}
+	return vs
+}()
+
+func (v variant) String() string {
+	var parts []string
+	if v.userns {
+		parts = append(parts, "userns")
+	}
+	if v.terminal {
+		parts = append(parts, "terminal")
+	}
+	if v.cgroupV2 {
+		parts = append(parts, "cgroupv2")
+	} else {
+		parts = append(parts, "cgroupv1")
+	}
+	return strings.Join(parts, "+")
+}
+
+// skipUnsupported skips t if the host cannot run the variant. The cgroup
+// version is that of the host, so only one of the cgroup variants runs.
+func (v variant) skipUnsupported(t *testing.T) {
+	if v.cgroupV2 != hostCgroupV2() {
+		t.Skip("the host does not use this cgroup version")
+	}
+	if v.userns {
+		if _, err := os.Stat("/proc/self/ns/user"); os.IsNotExist(err) {
+			t.Skip("userns is unsupported")
+		}
+	}
+}
+
+func hostCgroupV2() bool {
+	_, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	return err == nil
+}
+
+var containerSeq int32
+
+// harness holds the container a scenario is run against.
+type harness struct {
+	t         *testing.T
+	v         variant
+	id        string
+	rootfs    string
+	container libcontainer.Container
+}
+
+func newHarness(t *testing.T, v variant) *harness {
+	v.skipUnsupported(t)
+	rootfs := newRootfs(t)
+	id := fmt.Sprintf("conformance-%d-%d", os.Getpid(), atomic.AddInt32(&containerSeq, 1))
+	config := newConfig(rootfs, filepath.Join("conformance", id), v)
+	container, err := factory.Create(id, config)
+	if err != nil {
+		os.RemoveAll(rootfs)
+		t.Fatal(err)
+	}
+	return &harness{t: t, v: v, id: id, rootfs: rootfs, container: container}
+}
+
+// cleanup tears the container down whatever state the scenario left it in.
+func (h *harness) cleanup() {
+	defer os.RemoveAll(h.rootfs)
+	if status, err := h.container.Status(); err == nil && status == libcontainer.Paused {
+		h.container.Resume()
+	}
+	h.container.Signal(unix.SIGKILL, true)
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = h.container.Destroy(); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	h.t.Errorf("cannot destroy container %s: %v", h.id, err)
+}
+
+// process is a container process along with the output it wrote.
+type process struct {
+	*libcontainer.Process
+	out  syncBuffer
+	done chan struct{}
+}
+
+func (h *harness) newProcess(args ...string) *process {
+	p := &process{
+		Process: &libcontainer.Process{
+			Cwd:  "/",
+			Args: args,
+			Env:  environment,
+		},
+		done: make(chan struct{}),
+	}
+	if h.v.terminal {
+		p.Terminal = true
+	} else {
+		p.Stdout = &p.out
+		p.Stderr = &p.out
+		close(p.done)
+	}
+	return p
+}
+
+// start creates the container with p as its init process.
+func (h *harness) start(p *process) {
+	if err := h.container.Start(p.Process); err != nil {
+		h.t.Fatal(err)
+	}
+	h.started(p)
+}
+
+// run runs p, as the init process if the container was not created yet.
+func (h *harness) run(p *process) {
+	if err := h.container.Run(p.Process); err != nil {
+		h.t.Fatal(err)
+	}
+	h.started(p)
+}
+
+// started collects the output of p from its terminal, if it has one.
+func (h *harness) started(p *process) {
+	if !h.v.terminal {
+		return
+	}
+	console, err := p.GetConsole()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	go func() {
+		defer close(p.done)
+		io.Copy(&p.out, console)
+	}()
+}
+
+// wait waits for p to exit and returns its wait status.
+func (h *harness) wait(p *process) syscall.WaitStatus {
+	type result struct {
+		state *os.ProcessState
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		state, err := p.Wait()
+		ch <- result{state, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.state == nil {
+			h.t.Fatalf("waiting for %v: %v", p.Args, r.err)
+		}
+		return r.state.Sys().(syscall.WaitStatus)
+	case <-time.After(waitTimeout):
+		h.t.Fatalf("%v did not exit within %s", p.Args, waitTimeout)
+	}
+	panic("unreachable")
+}
+
+// output returns everything p wrote once it has exited.
+func (h *harness) output(p *process) string {
+	select {
+	case <-p.done:
+	case <-time.After(waitTimeout):
+		h.t.Fatalf("the output of %v was not closed within %s", p.Args, waitTimeout)
+	}
+	return strings.TrimSpace(p.out.String())
+}
+
+func (h *harness) pid(p *process) int {
+	pid, err := p.Pid()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return pid
+}
+
+func (h *harness) assertStatus(want libcontainer.Status) {
+	status, err := h.container.Status()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if status != want {
+		h.t.Fatalf("expected the container to be %s, got %s", want, status)
+	}
+}
+
+func (h *harness) assertExited(p *process, code int) {
+	status := h.wait(p)
+	if !status.Exited() || status.ExitStatus() != code {
+		h.t.Fatalf("expected %v to exit with %d, got %#x", p.Args, code, status)
+	}
+}
+
+func (h *harness) assertKilled(p *process) {
+	status := h.wait(p)
+	if !status.Signaled() || status.Signal() != unix.SIGKILL {
+		h.t.Fatalf("expected %v to be killed, got %#x", p.Args, status)
+	}
+}
+
+// cgroupPaths returns the distinct cgroup directories of the container.
+func (h *harness) cgroupPaths() []string {
+	state, err := h.container.State()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	var paths []string
+	for _, path := range state.CgroupPaths {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		h.t.Fatal("the container has no cgroups")
+	}
+	return paths
+}
+
+// assertInCgroups checks that pid is in every cgroup of the container.
+func (h *harness) assertInCgroups(pid int) {
+	for _, path := range h.cgroupPaths() {
+		data, err := ioutil.ReadFile(filepath.Join(path, "cgroup.procs"))
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		found := false
+		for _, field := range strings.Fields(string(data)) {
+			if field == strconv.Itoa(pid) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			h.t.Fatalf("pid %d is not in cgroup %s: %q", pid, path, data)
+		}
+	}
+}
+
+// assertCgroupsRemoved checks that none of paths exists any more.
+func (h *harness) assertCgroupsRemoved(paths []string) {
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			h.t.Fatalf("cgroup %s was not removed: %v", path, err)
+		}
+	}
+}
+
+// freezerState returns the state of the freezer of the container, FROZEN
+// or THAWED, from freezer.state on cgroup v1 and cgroup.events on v2.
+func (h *harness) freezerState() string {
+	state, err := h.container.State()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if path, ok := state.CgroupPaths["freezer"]; ok && !h.v.cgroupV2 {
+		data, err := ioutil.ReadFile(filepath.Join(path, "freezer.state"))
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	for _, path := range h.cgroupPaths() {
+		data, err := ioutil.ReadFile(filepath.Join(path, "cgroup.events"))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "frozen 1" {
+				return "FROZEN"
+			}
+		}
+		return "THAWED"
+	}
+	h.t.Fatal("the container has no freezer")
+	return ""
+}
+
+// syncBuffer is a bytes.Buffer safe to write to from the goroutine copying
+// the output of a process while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,67 @@
+// +build linux,conformance
+
+package conformance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer"
+	_ "github.com/opencontainers/runc/libcontainer/nsenter"
+)
+
+// init runs the libcontainer initialization code because of the busybox style needs
+// to work around the go runtime and the issues with forking
+func init() {
+	if len(os.Args) < 2 || os.Args[1] != "init" {
+		return
+	}
+	runtime.GOMAXPROCS(1)
+	runtime.LockOSThread()
+	factory, err := libcontainer.New("")
+	if err != nil {
+		logrus.Fatalf("unable to initialize for container: %s", err)
+	}
+	if err := factory.StartInitialization(); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+var (
+	factory libcontainer.Factory
+	// busybox is the path of the busybox binary the rootfs is built from.
+	busybox string
+)
+
+func TestMain(m *testing.M) {
+	if os.Geteuid() != 0 {
+		fmt.Fprintln(os.Stderr, "conformance tests require root, skipping")
+		os.Exit(0)
+	}
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.InfoLevel)
+
+	var err error
+	busybox, err = findBusybox()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v, skipping\n", err)
+		os.Exit(0)
+	}
+	root, err := ioutil.TempDir("", "conformance")
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+	factory, err = libcontainer.New(root, libcontainer.Cgroupfs)
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+	ret := m.Run()
+	os.RemoveAll(root)
+	os.Exit(ret)
+}
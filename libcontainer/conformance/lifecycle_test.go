@@ -0,0 +1,245 @@
+// +build linux,conformance
+
+package conformance
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer"
+
+	"golang.org/x/sys/unix"
+)
+
+// scenarios are run for every variant, each against a new container.
+var scenarios = []struct {
+	name string
+	run  func(h *harness)
+}{
+	{"run-exit-status", testRunExitStatus},
+	{"create-start-exec", testCreateStartExec},
+	{"pause-resume", testPauseResume},
+	{"destroy-paused", testDestroyPaused},
+	{"signal-all", testSignalAll},
+	{"exec-after-init-exit", testExecAfterInitExit},
+	{"checkpoint-restore", testCheckpointRestore},
+}
+
+func TestLifecycle(t *testing.T) {
+	for _, v := range variants {
+		v := v
+		t.Run(v.String(), func(t *testing.T) {
+			for _, s := range scenarios {
+				s := s
+				t.Run(s.name, func(t *testing.T) {
+					h := newHarness(t, v)
+					defer h.cleanup()
+					s.run(h)
+				})
+			}
+		})
+	}
+}
+
+// testRunExitStatus runs an init process which exits on its own and checks
+// it is pid 1 of the container and its exit status is passed on.
+func testRunExitStatus(h *harness) {
+	init := h.newProcess("sh", "-c", "echo $$; exit 3")
+	h.run(init)
+	paths := h.cgroupPaths()
+	h.assertExited(init, 3)
+	if out := h.output(init); out != "1" {
+		h.t.Fatalf("expected the init process to be pid 1, got %q", out)
+	}
+	h.assertStatus(libcontainer.Stopped)
+	if err := h.container.Destroy(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertCgroupsRemoved(paths)
+}
+
+// testCreateStartExec creates the container, starts it and executes a
+// second process in it.
+func testCreateStartExec(h *harness) {
+	init := h.newProcess("sleep", "1000")
+	h.start(init)
+	h.assertStatus(libcontainer.Created)
+	h.assertInCgroups(h.pid(init))
+	if err := h.container.Exec(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertStatus(libcontainer.Running)
+
+	p := h.newProcess("sh", "-c", "cat /proc/self/uid_map; exit 7")
+	h.run(p)
+	h.assertExited(p, 7)
+	want := "0 0 4294967295"
+	if h.v.userns {
+		want = "0 0 1000"
+	}
+	if out := strings.Join(strings.Fields(h.output(p)), " "); out != want {
+		h.t.Fatalf("expected the uid map %q, got %q", want, out)
+	}
+
+	pids, err := h.container.Processes()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if len(pids) != 1 || pids[0] != h.pid(init) {
+		h.t.Fatalf("expected only the init process %d to be left, got %v", h.pid(init), pids)
+	}
+	if err := h.container.Signal(unix.SIGKILL, false); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertKilled(init)
+	h.assertStatus(libcontainer.Stopped)
+}
+
+// testPauseResume freezes and thaws a running container.
+func testPauseResume(h *harness) {
+	init := h.newProcess("sleep", "1000")
+	h.run(init)
+	if err := h.container.Pause(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertStatus(libcontainer.Paused)
+	if state := h.freezerState(); state != "FROZEN" {
+		h.t.Fatalf("expected the container to be frozen, got %s", state)
+	}
+	if err := h.container.Resume(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertStatus(libcontainer.Running)
+	if state := h.freezerState(); state != "THAWED" {
+		h.t.Fatalf("expected the container to be thawed, got %s", state)
+	}
+	h.assertInCgroups(h.pid(init))
+}
+
+// testDestroyPaused checks that a paused container is not destroyed
+// underneath its frozen processes, and is once they are gone.
+func testDestroyPaused(h *harness) {
+	init := h.newProcess("sleep", "1000")
+	h.run(init)
+	paths := h.cgroupPaths()
+	if err := h.container.Pause(); err != nil {
+		h.t.Fatal(err)
+	}
+	err := h.container.Destroy()
+	if lerr, ok := err.(libcontainer.Error); !ok || lerr.Code() != libcontainer.ContainerPaused {
+		h.t.Fatalf("expected destroying a paused container to fail with ContainerPaused, got %v", err)
+	}
+	h.assertStatus(libcontainer.Paused)
+	h.assertInCgroups(h.pid(init))
+
+	// The kill is only delivered once the container is thawed.
+	if err := h.container.Signal(unix.SIGKILL, false); err != nil {
+		h.t.Fatal(err)
+	}
+	if err := h.container.Resume(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertKilled(init)
+	if err := h.container.Destroy(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertCgroupsRemoved(paths)
+}
+
+// testSignalAll kills every process of the container at once. Signal reaps
+// the processes itself in that case, so they are not waited for.
+func testSignalAll(h *harness) {
+	init := h.newProcess("sleep", "1000")
+	h.run(init)
+	p := h.newProcess("sleep", "1000")
+	h.run(p)
+	h.assertInCgroups(h.pid(p))
+	if err := h.container.Signal(unix.SIGKILL, true); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertStatus(libcontainer.Stopped)
+	pids, err := h.container.Processes()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if len(pids) != 0 {
+		h.t.Fatalf("expected no process to be left, got %v", pids)
+	}
+}
+
+// testExecAfterInitExit checks that the container is reported stopped once
+// its init process is gone and that starting it again fails instead of
+// hanging.
+func testExecAfterInitExit(h *harness) {
+	init := h.newProcess("true")
+	h.run(init)
+	h.assertExited(init, 0)
+	h.assertStatus(libcontainer.Stopped)
+	pids, err := h.container.Processes()
+	if err == nil && len(pids) != 0 {
+		h.t.Fatalf("expected no process to be left, got %v", pids)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.container.Exec()
+	}()
+	select {
+	case err := <-errc:
+		if err == nil {
+			h.t.Fatal("expected exec after the init process exited to fail")
+		}
+	case <-time.After(waitTimeout):
+		h.t.Fatalf("exec after the init process exited did not return within %s", waitTimeout)
+	}
+}
+
+// testCheckpointRestore checkpoints a running container and restores it.
+func testCheckpointRestore(h *harness) {
+	if _, err := exec.LookPath("criu"); err != nil {
+		h.t.Skip("criu is not installed")
+	}
+	if h.v.terminal {
+		h.t.Skip("checkpointing a process with a terminal needs an external tty")
+	}
+	if h.v.userns {
+		if err := exec.Command("criu", "check", "--feature", "userns").Run(); err != nil {
+			h.t.Skip("criu cannot checkpoint a container with userns")
+		}
+	}
+	init := h.newProcess("sleep", "1000")
+	h.run(init)
+
+	imagesDir, err := ioutil.TempDir("", "conformance-criu")
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer os.RemoveAll(imagesDir)
+	opts := &libcontainer.CriuOpts{
+		ImagesDirectory: imagesDir,
+		WorkDirectory:   imagesDir,
+	}
+	if err := h.container.Checkpoint(opts); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertKilled(init)
+	h.assertStatus(libcontainer.Stopped)
+
+	if h.container, err = factory.Load(h.id); err != nil {
+		h.t.Fatal(err)
+	}
+	restored := h.newProcess()
+	if err := h.container.Restore(restored.Process, opts); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertStatus(libcontainer.Running)
+	h.assertInCgroups(h.pid(restored))
+	if err := h.container.Signal(unix.SIGKILL, false); err != nil {
+		h.t.Fatal(err)
+	}
+	h.assertKilled(restored)
+}
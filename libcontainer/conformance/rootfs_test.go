@@ -0,0 +1,108 @@
+// +build linux,conformance
+
+package conformance
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fallbackApplets are linked into the rootfs if busybox is too old to list
+// its applets.
+var fallbackApplets = []string{"cat", "echo", "ls", "ps", "sh", "sleep", "true"}
+
+// findBusybox returns the path of a statically linked busybox, which is
+// $CONFORMANCE_BUSYBOX, or else the first busybox in $PATH or of the
+// integration test image.
+func findBusybox() (string, error) {
+	path := os.Getenv("CONFORMANCE_BUSYBOX")
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath("busybox"); err != nil {
+			path = "/busybox/bin/busybox"
+		}
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("no usable busybox: %v", err)
+	}
+	defer f.Close()
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			return "", fmt.Errorf("busybox %s is dynamically linked", path)
+		}
+	}
+	return path, nil
+}
+
+// newRootfs builds a minimal rootfs holding nothing but busybox, its
+// applets and the mount points of the container.
+func newRootfs(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "conformance-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"bin", "dev", "etc", "proc", "sys", "tmp", "root"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := copyFile(busybox, filepath.Join(dir, "bin", "busybox"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, applet := range applets() {
+		// Applets are listed without their directory, all of them go to /bin.
+		if err := os.Symlink("busybox", filepath.Join(dir, "bin", filepath.Base(applet))); err != nil && !os.IsExist(err) {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"etc/passwd": "root:x:0:0:root:/root:/bin/sh\n",
+		"etc/group":  "root:x:0:\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// applets returns the applets of busybox.
+func applets() []string {
+	out, err := exec.Command(busybox, "--list").Output()
+	if err != nil {
+		return fallbackApplets
+	}
+	var list []string
+	for _, applet := range strings.Fields(string(out)) {
+		if applet != "busybox" {
+			list = append(list, applet)
+		}
+	}
+	return list
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -5,13 +5,21 @@ import (
 	"os"
 )
 
-// Console represents a pseudo TTY.
+// Console represents a pseudo TTY. Reading from it returns io.EOF once the
+// slave side has been closed by every process, and closing it more than once
+// is harmless.
 type Console interface {
 	io.ReadWriteCloser
 
 	// Path returns the filesystem path to the slave side of the pty.
 	Path() string
 
-	// Fd returns the fd for the master of the pty.
+	// File returns the master of the pty.
 	File() *os.File
+
+	// Fd returns the fd for the master of the pty.
+	Fd() uintptr
+
+	// Resize sets the window size of the pty.
+	Resize(width, height uint16) error
 }
@@ -2,12 +2,38 @@ package libcontainer
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sync"
 	"unsafe"
 
+	"github.com/opencontainers/runc/libcontainer/devices"
+
 	"golang.org/x/sys/unix"
 )
 
+// ptmxMajor and ptmxMinor are the device numbers of ptmx, through which
+// every pty master is opened, whether it is /dev/ptmx or the ptmx of a
+// devpts instance.
+const (
+	ptmxMajor = 5
+	ptmxMinor = 2
+)
+
+// CheckConsoleMaster returns an error unless f is the master of a pty, so
+// that a console received from a container cannot be any other file which
+// the container passed off as one.
+func CheckConsoleMaster(f *os.File) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || devices.Major(int(st.Rdev)) != ptmxMajor || devices.Minor(int(st.Rdev)) != ptmxMinor {
+		return fmt.Errorf("console %q is not a pty master", f.Name())
+	}
+	return nil
+}
+
 func ConsoleFromFile(f *os.File) Console {
 	return &linuxConsole{
 		master: f,
@@ -38,29 +64,76 @@ func newConsole() (Console, error) {
 type linuxConsole struct {
 	master    *os.File
 	slavePath string
+	closeOnce sync.Once
+	// activity records the data read and written, for the IdleTimeout of
+	// the process, if any.
+	activity *ioActivity
+	// output retains the data read, for Container.TailOutput, if the
+	// output of the process is retained.
+	output *outputRing
 }
 
 func (c *linuxConsole) File() *os.File {
 	return c.master
 }
 
+func (c *linuxConsole) Fd() uintptr {
+	return c.master.Fd()
+}
+
 func (c *linuxConsole) Path() string {
 	return c.slavePath
 }
 
 func (c *linuxConsole) Read(b []byte) (int, error) {
-	return c.master.Read(b)
+	n, err := c.master.Read(b)
+	c.activity.touch(n)
+	c.output.record(b[:n])
+	// Reading from the master fails with EIO rather than returning EOF once
+	// the slave has been closed.
+	if perr, ok := err.(*os.PathError); ok && perr.Err == unix.EIO {
+		err = io.EOF
+	}
+	return n, err
 }
 
 func (c *linuxConsole) Write(b []byte) (int, error) {
-	return c.master.Write(b)
+	n, err := c.master.Write(b)
+	c.activity.touch(n)
+	return n, err
 }
 
 func (c *linuxConsole) Close() error {
-	if m := c.master; m != nil {
-		return m.Close()
+	var err error
+	c.closeOnce.Do(func() {
+		if m := c.master; m != nil {
+			err = m.Close()
+		}
+	})
+	return err
+}
+
+func (c *linuxConsole) Resize(width, height uint16) error {
+	ws := winsize{row: height, col: width}
+	return ioctl(c.master.Fd(), unix.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// dropConsole forgets the console of process once it has exited, so that the
+// container does not keep every console it ever had until it is destroyed.
+// Closing it is left to the caller, which may still read what the process
+// wrote last.
+func (c *linuxContainer) dropConsole(process *Process) {
+	if process == nil || process.console == nil {
+		return
+	}
+	c.consolesMu.Lock()
+	defer c.consolesMu.Unlock()
+	for i, console := range c.consoles {
+		if console == process.console {
+			c.consoles = append(c.consoles[:i], c.consoles[i+1:]...)
+			return
+		}
 	}
-	return nil
 }
 
 // mount initializes the console inside the rootfs mounting with the specified mount label
@@ -107,6 +180,15 @@ func (c *linuxConsole) open(flag int) (*os.File, error) {
 	return os.NewFile(uintptr(r), c.slavePath), nil
 }
 
+// winsize is the struct winsize of the TIOCSWINSZ ioctl, which x/sys/unix
+// does not expose yet.
+type winsize struct {
+	row    uint16
+	col    uint16
+	xpixel uint16
+	ypixel uint16
+}
+
 func ioctl(fd uintptr, flag, data uintptr) error {
 	if _, _, err := unix.Syscall(unix.SYS_IOCTL, fd, flag, data); err != 0 {
 		return err
@@ -0,0 +1,67 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestConsole(t *testing.T) {
+	console, err := newConsole()
+	if err != nil {
+		t.Skipf("cannot create a pty: %v", err)
+	}
+	defer console.Close()
+	slave, err := console.(*linuxConsole).open(unix.O_RDWR | unix.O_NOCTTY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := console.Resize(80, 25); err != nil {
+		t.Fatal(err)
+	}
+	var ws winsize
+	if err := ioctl(slave.Fd(), unix.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		t.Fatal(err)
+	}
+	if ws.col != 80 || ws.row != 25 {
+		t.Fatalf("expected a 80x25 window but got %dx%d", ws.col, ws.row)
+	}
+
+	if _, err := slave.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := console.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello\r\n" {
+		t.Fatalf("unexpected output %q", buf[:n])
+	}
+
+	slave.Close()
+	if _, err := console.Read(buf); err != io.EOF {
+		t.Fatalf("expected EOF once the slave is closed but got %v", err)
+	}
+	if err := console.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := console.Close(); err != nil {
+		t.Fatalf("closing the console twice should be harmless: %v", err)
+	}
+}
+
+func TestDropConsole(t *testing.T) {
+	exited, running := &Process{console: &linuxConsole{}}, &Process{console: &linuxConsole{}}
+	c := &linuxContainer{consoles: []Console{exited.console, running.console}}
+	c.dropConsole(exited)
+	c.dropConsole(&Process{})
+	if len(c.consoles) != 1 || c.consoles[0] != running.console {
+		t.Fatalf("expected only the console of the running process to be kept, got %v", c.consoles)
+	}
+}
@@ -28,3 +28,7 @@ func (c *windowsConsole) Write(b []byte) (int, error) {
 func (c *windowsConsole) Close() error {
 	return nil
 }
+
+func (c *windowsConsole) Resize(width, height uint16) error {
+	return nil
+}
@@ -25,6 +25,9 @@ const (
 	Paused
 	// Stopped is the status that denotes the container does not have a created or running process.
 	Stopped
+	// StoppedWithStragglers is the status that denotes the container's init process has exited,
+	// but processes it left behind, e.g. by double-forking, are still in the container's cgroups.
+	StoppedWithStragglers
 )
 
 func (s Status) String() string {
@@ -39,6 +42,8 @@ func (s Status) String() string {
 		return "paused"
 	case Stopped:
 		return "stopped"
+	case StoppedWithStragglers:
+		return "stopped-with-stragglers"
 	default:
 		return "unknown"
 	}
@@ -120,6 +125,7 @@ type BaseContainer interface {
 	// ContainerNotExists - Container no longer exists,
 	// ConfigInvalid - config is invalid,
 	// ContainerPaused - Container is paused,
+	// ContainerStaleCreated - Container init died before it was started,
 	// SystemError - System error.
 	Start(process *Process) (err error)
 
@@ -152,15 +158,24 @@ type BaseContainer interface {
 	// Signal sends the provided signal code to the container's initial process.
 	//
 	// If all is specified the signal is sent to all processes in the container
-	// including the initial process.
+	// including the initial process, those in its cgroups being frozen while
+	// they are signalled. Processes which exit meanwhile are skipped. SIGKILL
+	// is first sent to the initial process of a container with a PID namespace
+	// of its own, as the kernel kills the others in it along with it, and
+	// then to the processes of its cgroups outside of that namespace.
 	//
 	// errors:
 	// SystemError - System error.
 	Signal(s os.Signal, all bool) error
 
 	// Exec signals the container to exec the users process at the end of the init.
+	// Of concurrent calls, including from other processes, only one does so.
 	//
 	// errors:
+	// ContainerAlreadyRunning - Container has already been started,
+	// ContainerStopped - Container has stopped,
+	// ContainerStaleCreated - Container init died before it was started,
+	// ContainerPaused - Container is paused,
 	// SystemError - System error.
 	Exec() error
 }
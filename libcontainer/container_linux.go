@@ -4,6 +4,7 @@ package libcontainer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall" // only for SysProcAttr and Signal
@@ -39,13 +41,57 @@ type linuxContainer struct {
 	config               *configs.Config
 	cgroupManager        cgroups.Manager
 	initArgs             []string
+	initBinary           *os.File
 	initProcess          parentProcess
 	initProcessStartTime uint64
 	criuPath             string
+	ownerHelperPath      string
+	traceSync            bool
+	denyHostBinary       bool
+	freezeTimeout        time.Duration
+	setnsRetries         int
+	setnsBackoff         time.Duration
+	metrics              MetricsSink
+	events               chan<- FactoryEvent
+	initInfo             *InitInfo
+	schedIdle            bool
+	schedPolicies        map[int]SchedPolicy
 	m                    sync.Mutex
 	criuVersion          int
+	pageServer           *PageServer
 	state                containerState
 	created              time.Time
+	stopMu               sync.Mutex
+	stopNotify           *stopNotifier
+	stopCause            string
+	exemptMu             sync.Mutex
+	exemptPids           map[int]uint64
+	hookAnnotations      map[string]string
+	passedMu             sync.Mutex
+	passedFiles          []*os.File
+	pidFile              string
+	consolesMu           sync.Mutex
+	consoles             []Console
+	eventsMu             sync.Mutex
+	eventSubs            []chan CgroupEvent
+	eventsStop           chan struct{}
+	warningsMu           sync.Mutex
+	warnings             []configs.Warning
+	quiesceC             chan struct{}
+	quiescing            bool
+	lifetime             *lifetime
+	deviceProfile        deviceProfile
+	driftWatch           driftWatch
+	output               *outputRing
+	exit                 *ExitStatus
+	execs                []execHandle
+	storedFds            []string
+	adopted              []*Process
+	adoptedInit          *initHandle
+	eventsCursor         *cgroupEventsCursor
+	// staleCreated is set by Load for a container whose init process died
+	// while waiting to be started, which must be destroyed.
+	staleCreated bool
 }
 
 // State represents a running container's state
@@ -67,6 +113,53 @@ type State struct {
 
 	// Container's standard descriptors (std{in,out,err}), needed for checkpoint and restore
 	ExternalDescriptors []string `json:"external_descriptors,omitempty"`
+
+	// SharedPidns specifies whether the container shares its PID namespace with
+	// other processes, i.e. it does not have PID isolation of its own.
+	SharedPidns bool `json:"shared_pidns"`
+
+	// HookAnnotations are the annotations which hooks have added to the
+	// container through their responses.
+	HookAnnotations map[string]string `json:"hook_annotations,omitempty"`
+
+	// PidFile is the pid file written for the init process, which is removed
+	// when the container is destroyed.
+	PidFile string `json:"pid_file,omitempty"`
+
+	// Init describes the init binary which set up the container, with a
+	// warning if it is not fully compatible with the runtime.
+	Init *InitInfo `json:"init,omitempty"`
+
+	// SchedIdle is set once the processes of the container have been demoted
+	// to SCHED_IDLE, which the processes executed later are started under.
+	SchedIdle bool `json:"sched_idle,omitempty"`
+
+	// SchedPolicies holds the scheduling policy each process of the container
+	// had before it was demoted to SCHED_IDLE, keyed by pid.
+	SchedPolicies map[int]SchedPolicy `json:"sched_policies,omitempty"`
+
+	// LifetimeDeadline is when the container is terminated for exceeding
+	// its MaxLifetime, if it has one.
+	LifetimeDeadline time.Time `json:"lifetime_deadline,omitempty"`
+
+	// LifetimeExceeded is set once the container is being terminated for
+	// exceeding its MaxLifetime.
+	LifetimeExceeded bool `json:"lifetime_exceeded,omitempty"`
+
+	// Exit is how the init process exited, once libcontainer waited for it.
+	// It is absent from the state written by older versions.
+	Exit *ExitStatus `json:"exit,omitempty"`
+
+	// StaleCreated is set once the init process is found to have died
+	// before the container was started.
+	StaleCreated bool `json:"stale_created,omitempty"`
+
+	// Paused is set while the container is paused through Pause.
+	Paused bool `json:"paused,omitempty"`
+
+	// StopCause is the cause Stop recorded for the exit of the init process
+	// it triggered, StopSignaled or StopKilled.
+	StopCause string `json:"stop_cause,omitempty"`
 }
 
 // Container is a libcontainer container object.
@@ -91,6 +184,14 @@ type Container interface {
 	// Systemerror - System error.
 	Restore(process *Process, criuOpts *CriuOpts) error
 
+	// StartPageServer starts a criu(8) page server receiving the memory pages of
+	// a checkpoint of the container streamed from another host. A following
+	// Restore waits for the page server to have received all pages.
+	//
+	// errors:
+	// Systemerror - System error.
+	StartPageServer(criuOpts *CriuOpts) (*PageServer, error)
+
 	// If the Container state is RUNNING or CREATED, sets the Container state to PAUSING and pauses
 	// the execution of any user processes. Asynchronously, when the container finished being paused the
 	// state is changed to PAUSED.
@@ -112,17 +213,284 @@ type Container interface {
 	// Systemerror - System error.
 	Resume() error
 
+	// Quiesce freezes the container, runs fn and thaws the container again,
+	// for instance to take a consistent snapshot of its filesystems. The
+	// container is thawed once fn returns or panics, or ctx is done, in which
+	// case fn is left running but its result is discarded. Concurrent calls
+	// are queued until the fn of the previous one has returned, and Pause and
+	// Resume are refused while the container is quiesced.
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// ContainerPaused - Container is paused,
+	// Systemerror - System error.
+	Quiesce(ctx context.Context, fn func() error) error
+
 	// NotifyOOM returns a read-only channel signaling when the container receives an OOM notification.
+	// On cgroup v2, it is sent when the oom counter of memory.events increases.
 	//
 	// errors:
 	// Systemerror - System error.
 	NotifyOOM() (<-chan struct{}, error)
 
 	// NotifyMemoryPressure returns a read-only channel signaling when the container reaches a given pressure level
+	// On cgroup v2, the levels are mapped to PSI triggers on memory.pressure, which fire at most once every two
+	// seconds. The channel is closed once the cgroup is removed, as it is when the container is destroyed.
 	//
 	// errors:
 	// Systemerror - System error.
 	NotifyMemoryPressure(level PressureLevel) (<-chan struct{}, error)
+
+	// NotifyCgroupEvents returns a read-only channel receiving the transitions
+	// of the cgroups of the container. On cgroup v2, the cgroup.events file is
+	// watched, reporting when the last process exits and freezes made by
+	// anyone, and the channel is closed once the cgroup is removed. On cgroup
+	// v1, only the freezes made by Pause and Resume are reported, and the
+	// channel is closed when the container is destroyed.
+	//
+	// errors:
+	// Systemerror - System error.
+	NotifyCgroupEvents() (<-chan CgroupEvent, error)
+
+	// VerifySecurity reads the security attributes the init process of the
+	// container has, its effective, bounding and ambient capabilities,
+	// no_new_privs, seccomp mode and filter count and uid and gid maps, and
+	// the state of its freezer cgroup, and returns those which differ from
+	// what its config asks for.
+	//
+	// errors:
+	// ContainerNotRunning - Container is not running,
+	// SystemError - System error.
+	VerifySecurity() ([]SecurityMismatch, error)
+
+	// VerifyProcessSecurity is VerifySecurity for a process started in the
+	// container, whose capabilities and no_new_privs are those of process.
+	//
+	// errors:
+	// ContainerNotRunning - Container is not running,
+	// NoProcessOps - The process has not been started,
+	// SystemError - System error.
+	VerifyProcessSecurity(process *Process) ([]SecurityMismatch, error)
+
+	// Warnings returns what went wrong without failing while the container
+	// was created, started and updated by this process, oldest first. Each
+	// is also reported as it occurs with a ContainerWarning event of the
+	// factory.
+	Warnings() []configs.Warning
+
+	// ReclaimMemory asks the kernel to reclaim bytes of memory from the
+	// cgroup of the container through memory.reclaim. If the kernel cannot
+	// reclaim as much, smaller amounts are asked for, until timeout passes
+	// if it is set. How much the memory usage of the container went down by
+	// is reported with a MemoryReclaimed event of the factory.
+	//
+	// errors:
+	// ErrNotSupported - Not on cgroup v2, or memory.reclaim is missing,
+	// ContainerNotRunning - Container is not running or created,
+	// SystemError - System error.
+	ReclaimMemory(bytes uint64, timeout time.Duration) error
+
+	// CheckDrift returns the cgroup files whose values differ from those set
+	// for the resources of the container, as when another agent rewrote
+	// them.
+	//
+	// errors:
+	// ContainerNotRunning - Container is not running or created,
+	// SystemError - System error.
+	CheckDrift() ([]cgroups.Drift, error)
+
+	// WatchCgroupDrift checks for drift, as CheckDrift does, every time one
+	// of the cgroup files of the container is written, until it stops. The
+	// drift is reported with a CgroupDrifted event of the factory. If repair
+	// is set, the cgroups are set again first, as by Set, and the files which
+	// still drift then are reported as unrepairable.
+	//
+	// errors:
+	// ContainerNotRunning - Container is not running or created,
+	// SystemError - System error.
+	WatchCgroupDrift(repair bool) error
+
+	// PrepareShutdown saves the handles to the container which outlive the
+	// caller, its init process if it started it and did not wait for it, the
+	// processes it started in the container, the names of the stored fds and
+	// the state of the cgroups last reported, for a new instance of the
+	// caller to adopt them with Factory.LoadAll. The report lists what was
+	// saved and what cannot be restored.
+	//
+	// errors:
+	// SystemError - System error.
+	PrepareShutdown() (*ShutdownReport, error)
+
+	// AdoptedProcesses returns the processes adopted from the handles saved
+	// by PrepareShutdown when the container was loaded by LoadAll, the init
+	// process first if it was adopted. Waiting for the init process records
+	// its exit as if the caller started it. Waiting for a process which is
+	// not a child of the caller returns ErrExitStatusLost once it exited;
+	// the exit of the init process is then recorded as Unknown.
+	AdoptedProcesses() []*Process
+
+	// NotifyLifetimeExceeded returns a read-only channel which is closed when
+	// the container is terminated for exceeding its MaxLifetime. The deadline
+	// is enforced by every process which started or loaded the container, as
+	// long as it runs.
+	//
+	// errors:
+	// Systemerror - System error.
+	NotifyLifetimeExceeded() (<-chan struct{}, error)
+
+	// WaitStopped blocks until the container's init process has exited and the
+	// container is stopped, or until ctx is done. It works for containers that
+	// were loaded as well as for containers started by the caller, and any
+	// number of callers may wait concurrently.
+	//
+	// errors:
+	// Systemerror - System error.
+	WaitStopped(ctx context.Context) error
+
+	// Stop sends the StopSignal of the config to the init process and waits
+	// for the container to stop, up to its StopTimeout or until ctx is done,
+	// whichever comes first. The processes of the container are then
+	// killed. Which of the two stopped the container is recorded as the
+	// Cause of its ExitStatus.
+	//
+	// errors:
+	// Systemerror - System error.
+	Stop(ctx context.Context) error
+
+	// TailOutput returns the last n bytes of the output of the init process
+	// retained for the container, as configured by RetainedOutput, or all of
+	// it if n is not positive. The output is only retained by the process
+	// which started the container, and is dropped when it is destroyed.
+	//
+	// errors:
+	// ConfigInvalid - The output of the container is not retained,
+	// Systemerror - System error.
+	TailOutput(n int) ([]byte, error)
+
+	// ExitStatus returns how the init process of the container exited, as
+	// recorded in its state by the process which waited for it, which may
+	// be another one than ours. It returns nil if the exit was not recorded,
+	// e.g. by an older version, or because the container was restored.
+	//
+	// errors:
+	// ContainerNotStopped - The init process has not exited,
+	// Systemerror - System error.
+	ExitStatus() (*ExitStatus, error)
+
+	// ExecBatch runs processes one after the other in the running container,
+	// joining its namespaces and cgroups once for all of them. They are
+	// forked by a single runner in the container, an init process which
+	// never leaves nsexec, and each is started with its own Env, Cwd, User
+	// and stdio. The batch stops at the first process which fails unless it
+	// has ContinueOnError set. The results of the processes which were run
+	// are returned in order. Cancelling ctx kills the runner and whatever
+	// process it is running. The processes cannot have a console, extra
+	// files, a HostBinary, an IdleTimeout or Rlimits of their own, nor leave
+	// any namespace of the container out.
+	//
+	// errors:
+	// ContainerNotRunning - Container is not running,
+	// ConfigInvalid - A process cannot be run in a batch,
+	// Systemerror - System error.
+	ExecBatch(ctx context.Context, processes []*Process) ([]ProcessResult, error)
+
+	// ExemptFromKill excludes the process pid from the processes that are
+	// killed when the init process of a container sharing its PID namespace
+	// exits, or when the container is destroyed. This is meant for processes
+	// that were deliberately placed in the container's cgroups by other
+	// tooling. The exemption ends when the process exits.
+	//
+	// errors:
+	// ContainerNotRunning - Container is not running or created,
+	// ConfigInvalid - pid is invalid or not running.
+	ExemptFromKill(pid int) error
+
+	// SetSchedIdle moves every process of the container to the SCHED_IDLE
+	// scheduling policy, or back to the policy it had before, so that a
+	// best-effort container can be demoted at runtime. Processes started
+	// while the container was demoted go back to SCHED_OTHER. Processes
+	// executed afterwards are started under the same policy.
+	//
+	// errors:
+	// ContainerNotRunning - Container is not running or created,
+	// Systemerror - System error.
+	SetSchedIdle(idle bool) error
+
+	// ReadCgroupFile returns the contents of the file name of the given
+	// controller in the container's cgroups, e.g. "memory.stat" of the
+	// "memory" controller. Only the controller's own files can be read.
+	//
+	// errors:
+	// ConfigInvalid - controller or name is invalid,
+	// Systemerror - System error.
+	ReadCgroupFile(controller, name string) ([]byte, error)
+
+	// WriteCgroupFile writes value to the file name of the given controller
+	// in the container's cgroups, for settings which the configuration
+	// doesn't cover. Only the controller's own files can be written, and
+	// every write is logged.
+	//
+	// errors:
+	// ConfigInvalid - controller or name is invalid,
+	// Systemerror - System error.
+	WriteCgroupFile(controller, name, value string) error
+
+	// WatchDeviceProfile allows the container access to the devices listed
+	// in the device profile at path, a JSON array of DeviceRule, and
+	// follows every change of the file until the container exits. Each
+	// change is applied to the devices cgroup, or to the device filter of
+	// the cgroup on cgroup v2, on top of the devices the config allows, and
+	// sent on the returned channel, which is closed once the container has
+	// exited. Changes are not waited for to be received after that. An
+	// invalid profile is reported without changing the devices.
+	//
+	// errors:
+	// ConfigInvalid - the config of the container allows all devices,
+	// Systemerror - System error.
+	WatchDeviceProfile(path string) (<-chan DevicesUpdate, error)
+
+	// ServeControl serves the control protocol of package control on listener
+	// until ctx is done, so that other processes can signal, pause, resume
+	// and get the status and stats of the container. Every method has to be
+	// allowed by an authorizer in authorizers, methods without one are
+	// refused.
+	//
+	// errors:
+	// Systemerror - System error.
+	ServeControl(ctx context.Context, listener net.Listener, authorizers map[string]ControlAuthorizer) error
+
+	// EnterPostmortem starts process in the mount namespace preserved for a
+	// stopped container with PreserveMountNSOnExit set, to inspect what the
+	// container left behind. The process joins no other namespace of the
+	// container nor its cgroups.
+	//
+	// errors:
+	// ConfigInvalid - the mount namespace of the container is not preserved,
+	// ContainerNotStopped - Container is still running,
+	// Systemerror - System error.
+	EnterPostmortem(process *Process) error
+
+	// StoreFd parks f in the fd store of the container under name, replacing
+	// any fd stored under it, so that it can be retrieved by a process which
+	// loads the container after we are gone. The fd store is a process
+	// holding the fds, started with the first one stored and stopped when
+	// the container is destroyed, which only processes of our uid can reach.
+	// The master of the console of an init process with Terminal set is
+	// stored as FdConsoleMaster.
+	//
+	// errors:
+	// ConfigInvalid - name is empty,
+	// Systemerror - System error.
+	StoreFd(name string, f *os.File) error
+
+	// RetrieveFd returns a duplicate of the fd stored under name in the fd
+	// store of the container.
+	//
+	// errors:
+	// ConfigInvalid - no fd is stored under name,
+	// Systemerror - System error.
+	RetrieveFd(name string) (*os.File, error)
 }
 
 // ID returns the container's unique ID
@@ -167,6 +535,11 @@ func (c *linuxContainer) Stats() (*Stats, error) {
 		switch iface.Type {
 		case "veth":
 			istats, err := getNetworkInterfaceStats(iface.HostInterfaceName)
+			if os.IsNotExist(err) {
+				// The interface is gone with the network namespace of the
+				// container, or was never created on this host.
+				continue
+			}
 			if err != nil {
 				return stats, newSystemErrorWithCausef(err, "getting network stats for interface %q", iface.HostInterfaceName)
 			}
@@ -183,11 +556,24 @@ func (c *linuxContainer) Set(config configs.Config) error {
 	if err != nil {
 		return err
 	}
-	if status == Stopped {
+	if status == Stopped || status == StoppedWithStragglers {
 		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
 	}
 	c.config = &config
-	return c.cgroupManager.Set(c.config)
+	err = c.cgroupManager.Set(c.config)
+	c.warnCgroups()
+	if err != nil {
+		return err
+	}
+	if err := c.reapplyDeviceProfile(); err != nil {
+		return err
+	}
+	// Limits relative to the host have been resolved into the config.
+	state, err := c.currentState()
+	if err != nil {
+		return err
+	}
+	return c.saveState(state)
 }
 
 func (c *linuxContainer) Start(process *Process) error {
@@ -197,6 +583,12 @@ func (c *linuxContainer) Start(process *Process) error {
 	if err != nil {
 		return err
 	}
+	if status == StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container has processes left in its cgroups"), ContainerNotStopped)
+	}
+	if status == Stopped && c.staleCreated {
+		return c.staleCreatedError()
+	}
 	if status == Stopped {
 		if err := c.createExecFifo(); err != nil {
 			return err
@@ -223,7 +615,7 @@ func (c *linuxContainer) Run(process *Process) error {
 		return err
 	}
 	if status == Stopped {
-		return c.exec()
+		return c.Exec()
 	}
 	return nil
 }
@@ -231,9 +623,66 @@ func (c *linuxContainer) Run(process *Process) error {
 func (c *linuxContainer) Exec() error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	return c.exec()
+	// Other processes may be starting the container too: whoever takes the
+	// lock first releases the init process, the others find it running.
+	lock, err := lockDir(c.root)
+	if err != nil {
+		return newSystemErrorWithCause(err, "locking container state directory")
+	}
+	defer lock.Close()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	switch status {
+	case Created:
+		return c.exec()
+	case Running:
+		return newGenericError(fmt.Errorf("cannot start an already running container"), ContainerAlreadyRunning)
+	case Paused:
+		return newGenericError(fmt.Errorf("cannot start a paused container"), ContainerPaused)
+	}
+	if c.staleCreated {
+		return c.staleCreatedError()
+	}
+	return newGenericError(fmt.Errorf("cannot start a container that has stopped"), ContainerStopped)
+}
+
+func (c *linuxContainer) staleCreatedError() error {
+	return newGenericError(fmt.Errorf("the init process of container %s died before the container was started, destroy it", c.id), ContainerStaleCreated)
 }
 
+// checkStaleCreated removes the exec fifo of a created container whose
+// init process is gone, as when the process which created it was killed
+// before it could clean up, and records in its state that it was never
+// started.
+func (c *linuxContainer) checkStaleCreated() error {
+	fifo := filepath.Join(c.root, execFifoFilename)
+	if _, err := os.Stat(fifo); err != nil {
+		return nil
+	}
+	if processAlive(c.initProcess.pid(), c.initProcessStartTime) {
+		return nil
+	}
+	logrus.Warnf("removing the exec fifo of container %s, whose init process died before it was started", c.id)
+	c.staleCreated = true
+	state, err := c.currentState()
+	if err != nil {
+		return err
+	}
+	if err := c.saveState(state); err != nil {
+		return newSystemErrorWithCause(err, "recording stale container")
+	}
+	if err := os.Remove(fifo); err != nil && !os.IsNotExist(err) {
+		return newSystemErrorWithCause(err, "removing stale exec fifo")
+	}
+	return nil
+}
+
+// exec releases the init process of a created container, which is waiting
+// for the exec fifo to be read, then removes the fifo as the container is
+// running from then on. If runc dies in between, runType finds the fifo
+// drained from the name of the init process and removes it.
 func (c *linuxContainer) exec() error {
 	path := filepath.Join(c.root, execFifoFilename)
 	f, err := os.OpenFile(path, os.O_RDONLY, 0)
@@ -249,67 +698,371 @@ func (c *linuxContainer) exec() error {
 		os.Remove(path)
 		return nil
 	}
-	return fmt.Errorf("cannot start an already running container")
+	return newGenericError(fmt.Errorf("container init exited before being started"), ContainerStopped)
 }
 
-func (c *linuxContainer) start(process *Process, isInit bool) error {
+func (c *linuxContainer) start(process *Process, isInit bool) (err error) {
+	if !isInit {
+		if err := trackActivity(process); err != nil {
+			return newGenericError(err, ConfigInvalid)
+		}
+	}
+	c.passFiles(process.ExtraFiles)
+	process.output = nil
+	if isInit && c.config.RetainedOutput > 0 {
+		process.output = newOutputRing(c.config.RetainedOutput)
+		c.output = process.output
+	}
+	var consoleSocket *os.File
+	if process.Terminal {
+		if process.ConsoleSocket != nil {
+			return newGenericError(fmt.Errorf("a process cannot have both a terminal and a console socket"), ConfigInvalid)
+		}
+		parent, child, err := newCredSockPair("console")
+		if err != nil {
+			return newSystemErrorWithCause(err, "creating console socket")
+		}
+		defer parent.Close()
+		process.ConsoleSocket = child
+		defer func() {
+			child.Close()
+			process.ConsoleSocket = nil
+		}()
+		consoleSocket = parent
+	}
+	if isInit {
+		if err := c.setupOwnedMounts(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if rerr := c.releaseOwnedMounts(); rerr != nil {
+					logrus.Warn(rerr)
+				}
+			}
+		}()
+	}
 	parent, err := c.newParentProcess(process, isInit)
 	if err != nil {
+		// A process refused for its configuration is not a system error.
+		if lerr, ok := err.(Error); ok && lerr.Code() == ConfigInvalid {
+			return lerr
+		}
 		return newSystemErrorWithCause(err, "creating new parent process")
 	}
-	if err := parent.start(); err != nil {
+	err = parent.start()
+	if isInit {
+		c.warnCgroups()
+	}
+	if err != nil {
 		// terminate the process to ensure that it properly is reaped.
 		if err := parent.terminate(); err != nil {
 			logrus.Warn(err)
 		}
+		// The container going away while we join it is not a system error.
+		if lerr, ok := err.(Error); ok && lerr.Code() == ContainerNotRunning {
+			return lerr
+		}
 		return newSystemErrorWithCause(err, "starting container process")
 	}
+	if consoleSocket != nil {
+		// The master has been sent by the time the process is started, so
+		// our end of the socket is the only one left open.
+		process.ConsoleSocket.Close()
+		master, err := recvConsole(consoleSocket, expectedSyncPeer(parent.pid(), c.config, process.User))
+		if err != nil {
+			if err := parent.terminate(); err != nil {
+				logrus.Warn(err)
+			}
+			if lerr, ok := err.(Error); ok {
+				return lerr
+			}
+			return newSystemErrorWithCause(err, "receiving console")
+		}
+		process.console = &linuxConsole{master: master, activity: process.activity, output: process.output}
+		c.consolesMu.Lock()
+		c.consoles = append(c.consoles, process.console)
+		c.consolesMu.Unlock()
+		if isInit {
+			if err := c.storeFd(FdConsoleMaster, master); err != nil {
+				if err := parent.terminate(); err != nil {
+					logrus.Warn(err)
+				}
+				return err
+			}
+		}
+	}
 	// generate a timestamp indicating when the container was started
 	c.created = time.Now().UTC()
 	if isInit {
 		c.state = &createdState{
 			c: c,
 		}
+		c.pidFile = process.PidFile
+		if c.config.MaxLifetime > 0 {
+			c.lifetime.deadline = c.created.Add(c.config.MaxLifetime)
+			c.lifetime = shareLifetime(c.root, c.lifetime)
+		}
 		state, err := c.updateState(parent)
 		if err != nil {
 			return err
 		}
 		c.initProcessStartTime = state.InitProcessStartTime
 
-		if c.config.Hooks != nil {
-			s := configs.HookState{
-				Version: c.config.Version,
-				ID:      c.id,
-				Pid:     parent.pid(),
-				Bundle:  utils.SearchLabels(c.config.Labels, "bundle"),
-			}
-			for i, hook := range c.config.Hooks.Poststart {
-				if err := hook.Run(s); err != nil {
-					if err := parent.terminate(); err != nil {
-						logrus.Warn(err)
-					}
-					return newSystemErrorWithCausef(err, "running poststart hook %d", i)
+		if c.config.PreserveMountNSOnExit {
+			if err := c.preserveMountNS(parent.pid()); err != nil {
+				if err := parent.terminate(); err != nil {
+					logrus.Warn(err)
 				}
+				return newSystemErrorWithCause(err, "preserving mount namespace")
 			}
 		}
+		c.armLifetime()
 	} else {
 		c.state = &runningState{
 			c: c,
 		}
+		c.trackExec(process, parent)
 	}
 	return nil
 }
 
 func (c *linuxContainer) Signal(s os.Signal, all bool) error {
+	// Killing the init process of a PID namespace kills every process in it,
+	// so the cgroups are only gone through for the processes outside of it.
+	if all && (s != unix.SIGKILL || c.sharesPidns()) {
+		return c.signalAllProcesses(s, nil)
+	}
+	// The processes of a frozen cgroup v1 do not die before they are thawed.
 	if all {
-		return signalAllProcesses(c.cgroupManager, s)
+		paused, err := c.isPaused()
+		if err != nil {
+			return err
+		}
+		if paused {
+			if err := c.freeze(configs.Thawed); err != nil {
+				return newSystemErrorWithCause(err, "thawing container to kill it")
+			}
+		}
 	}
 	if err := c.initProcess.signal(s); err != nil {
 		return newSystemErrorWithCause(err, "signaling init process")
 	}
+	// Processes which entered only the cgroups of the container, or left its
+	// PID namespace out, survive its init process.
+	if all {
+		return c.signalAllProcesses(s, nil)
+	}
+	return nil
+}
+
+func (c *linuxContainer) ExemptFromKill(pid int) error {
+	if pid <= 0 {
+		return newGenericError(fmt.Errorf("invalid pid %d", pid), ConfigInvalid)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+	// The start time tells the process from another one reusing its pid.
+	stat, err := system.Stat(pid)
+	if err != nil {
+		return newGenericError(fmt.Errorf("no process %d", pid), ConfigInvalid)
+	}
+	c.exemptMu.Lock()
+	defer c.exemptMu.Unlock()
+	if c.exemptPids == nil {
+		c.exemptPids = make(map[int]uint64)
+	}
+	c.exemptPids[pid] = stat.StartTime
+	return nil
+}
+
+func (c *linuxContainer) SetSchedIdle(idle bool) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+	pids, err := c.cgroupManager.GetAllPids()
+	if err != nil {
+		return newSystemErrorWithCause(err, "getting all container pids from cgroups")
+	}
+	if idle {
+		if c.schedPolicies == nil {
+			c.schedPolicies = make(map[int]SchedPolicy)
+		}
+		for _, pid := range pids {
+			if _, ok := c.schedPolicies[pid]; !ok && !c.schedIdle {
+				policy, priority, err := system.GetScheduler(pid)
+				if err == unix.ESRCH {
+					continue
+				}
+				if err != nil {
+					return newSystemErrorWithCausef(err, "getting the scheduling policy of pid %d", pid)
+				}
+				c.schedPolicies[pid] = SchedPolicy{Policy: policy, Priority: priority}
+			}
+			if err := setProcessScheduler(pid, SchedPolicy{Policy: system.SCHED_IDLE}); err != nil {
+				return newSystemErrorWithCausef(err, "setting the scheduling policy of pid %d", pid)
+			}
+		}
+	} else {
+		for _, pid := range pids {
+			policy, ok := c.schedPolicies[pid]
+			if !ok {
+				policy = SchedPolicy{Policy: system.SCHED_OTHER}
+			}
+			if err := setProcessScheduler(pid, policy); err != nil {
+				return newSystemErrorWithCausef(err, "setting the scheduling policy of pid %d", pid)
+			}
+		}
+		c.schedPolicies = nil
+	}
+	c.schedIdle = idle
+	state, err := c.currentState()
+	if err != nil {
+		return err
+	}
+	return c.saveState(state)
+}
+
+// SchedPolicy is a scheduling policy of sched_setscheduler(2) and its
+// static priority.
+type SchedPolicy struct {
+	Policy   int `json:"policy"`
+	Priority int `json:"priority,omitempty"`
+}
+
+// setProcessScheduler sets the scheduling policy of every thread of the
+// process pid. Threads exiting meanwhile are skipped.
+func setProcessScheduler(pid int, policy SchedPolicy) error {
+	return forEachThread(pid, func(tid int) error {
+		return system.SetSchedulerPriority(tid, policy.Policy, policy.Priority)
+	})
+}
+
+// forEachThread calls fn with the id of every thread of the process pid,
+// skipping the threads which exit meanwhile.
+func forEachThread(pid int, fn func(tid int) error) error {
+	tasks, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := fn(tid); err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
 	return nil
 }
 
+// killExemptPids returns the pids registered with ExemptFromKill whose
+// process is still running, forgetting the others.
+func (c *linuxContainer) killExemptPids() map[int]struct{} {
+	c.exemptMu.Lock()
+	defer c.exemptMu.Unlock()
+	exempt := make(map[int]struct{}, len(c.exemptPids))
+	for pid, started := range c.exemptPids {
+		if stat, err := system.Stat(pid); err != nil || stat.StartTime != started || stat.State == system.Zombie {
+			delete(c.exemptPids, pid)
+			continue
+		}
+		exempt[pid] = struct{}{}
+	}
+	return exempt
+}
+
+// signalAllProcesses sends s to the processes in the cgroups of the
+// container except those in exempt, and reports the processes of the caller
+// found there, which are spared, with CallerInContainerCgroup events.
+func (c *linuxContainer) signalAllProcesses(s os.Signal, exempt map[int]struct{}) error {
+	callers, err := signalAllProcesses(c.cgroupManager, s, exempt)
+	for _, pid := range callers {
+		sendFactoryEvent(c.events, FactoryEvent{Type: CallerInContainerCgroup, ID: c.id, Pid: pid})
+	}
+	return err
+}
+
+// sharesPidns returns true if the container does not have a PID namespace of
+// its own, either because it didn't ask for one or because it joins an
+// existing one. Killing the init process of such a container does not take
+// the rest of its processes with it.
+func (c *linuxContainer) sharesPidns() bool {
+	for _, ns := range c.config.Namespaces {
+		if ns.Type == configs.NEWPID {
+			return ns.Joined()
+		}
+	}
+	return true
+}
+
+// createsCgroupns returns true if the init process of config gets a new
+// cgroup namespace, which nsexec creates once told to by createCgroupns.
+func createsCgroupns(config *configs.Config) bool {
+	return createsNamespace(config, configs.NEWCGROUP)
+}
+
+// createsNamespace returns true if config creates a namespace of type t
+// rather than joining one.
+func createsNamespace(config *configs.Config, t configs.NamespaceType) bool {
+	for _, ns := range config.Namespaces {
+		if ns.Type == t {
+			return !ns.Joined()
+		}
+	}
+	return false
+}
+
+// hasStragglers returns true if processes are left in the container's cgroups
+// after its init process exited. This can only happen when the PID namespace
+// is shared, as the kernel otherwise kills them along with the init process.
+func (c *linuxContainer) hasStragglers() bool {
+	if c.config == nil || c.cgroupManager == nil || !c.sharesPidns() {
+		return false
+	}
+	pids, err := c.cgroupManager.GetAllPids()
+	return err == nil && len(pids) > 0
+}
+
+// killCgroupProcessesOnExit reports whether the processes left in the
+// container's cgroups should be killed once its init process exits. Unless
+// configured otherwise, this is only done when the container joins an
+// existing PID namespace.
+func (c *linuxContainer) killCgroupProcessesOnExit() bool {
+	if c.config.KillCgroupProcessesOnExit == nil {
+		return c.config.Namespaces.PathOf(configs.NEWPID) != ""
+	}
+	return *c.config.KillCgroupProcessesOnExit && c.sharesPidns()
+}
+
+// killCgroupProcessesOnDestroy reports whether Destroy kills the processes
+// in the container's cgroups: always when it has no PID namespace
+// configured, and when it shares one only if KillCgroupProcessesOnExit is
+// explicitly enabled.
+func (c *linuxContainer) killCgroupProcessesOnDestroy() bool {
+	if !c.config.Namespaces.Contains(configs.NEWPID) {
+		return true
+	}
+	return c.config.KillCgroupProcessesOnExit != nil && c.killCgroupProcessesOnExit()
+}
+
 func (c *linuxContainer) createExecFifo() error {
 	rootuid, err := c.Config().HostRootUID()
 	if err != nil {
@@ -341,11 +1094,27 @@ func (c *linuxContainer) deleteExecFifo() {
 	os.Remove(fifoName)
 }
 
-func (c *linuxContainer) newParentProcess(p *Process, doInit bool) (parentProcess, error) {
-	parentPipe, childPipe, err := utils.NewSockPair("init")
+func (c *linuxContainer) newParentProcess(p *Process, doInit bool) (_ parentProcess, err error) {
+	if p.HostBinary != "" {
+		if doInit {
+			return nil, newGenericError(fmt.Errorf("a host binary can only be executed in a running container"), ConfigInvalid)
+		}
+		if c.denyHostBinary {
+			return nil, newGenericError(fmt.Errorf("executing host binaries is denied"), ConfigInvalid)
+		}
+	}
+	parentPipe, childPipe, err := newInitPipe()
 	if err != nil {
 		return nil, newSystemErrorWithCause(err, "creating new init pipe")
 	}
+	defer func() {
+		// Nothing has been started yet, so we only need to make sure the
+		// pipes don't leak if we fail to build the parent process.
+		if err != nil {
+			parentPipe.Close()
+			childPipe.Close()
+		}
+	}()
 	cmd, err := c.commandTemplate(p, childPipe)
 	if err != nil {
 		return nil, newSystemErrorWithCause(err, "creating new command template")
@@ -365,14 +1134,28 @@ func (c *linuxContainer) newParentProcess(p *Process, doInit bool) (parentProces
 	cmd.ExtraFiles = append(cmd.ExtraFiles, rootDir)
 	cmd.Env = append(cmd.Env,
 		fmt.Sprintf("_LIBCONTAINER_STATEDIR=%d", stdioFdCount+len(cmd.ExtraFiles)-1))
-	return c.newInitProcess(p, cmd, parentPipe, childPipe, rootDir)
+	initProc, err := c.newInitProcess(p, cmd, parentPipe, childPipe, rootDir)
+	if err != nil {
+		rootDir.Close()
+		return nil, err
+	}
+	return initProc, nil
 }
 
 func (c *linuxContainer) commandTemplate(p *Process, childPipe *os.File) (*exec.Cmd, error) {
 	cmd := exec.Command(c.initArgs[0], c.initArgs[1:]...)
+	if c.initBinary != nil {
+		cmd.Path = initBinaryPath(c.initBinary)
+	}
 	cmd.Stdin = p.Stdin
 	cmd.Stdout = p.Stdout
 	cmd.Stderr = p.Stderr
+	if p.activity != nil && !p.Terminal {
+		trackStdio(cmd, p.activity)
+	}
+	if p.output != nil && !p.Terminal {
+		retainStdio(cmd, p.output)
+	}
 	cmd.Dir = c.config.Rootfs
 	if cmd.SysProcAttr == nil {
 		cmd.SysProcAttr = &syscall.SysProcAttr{}
@@ -397,57 +1180,178 @@ func (c *linuxContainer) commandTemplate(p *Process, childPipe *os.File) (*exec.
 	return cmd, nil
 }
 
+// readsTerminal returns true if the stdin of p is a terminal.
+func readsTerminal(p *Process) bool {
+	f, ok := p.Stdin.(*os.File)
+	if !ok {
+		return false
+	}
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
 func (c *linuxContainer) newInitProcess(p *Process, cmd *exec.Cmd, parentPipe, childPipe, rootDir *os.File) (*initProcess, error) {
 	cmd.Env = append(cmd.Env, "_LIBCONTAINER_INITTYPE="+string(initStandard))
+	// As for setns processes, the stub leads a process group of its own so
+	// that aborting the bootstrap also kills the stages nsexec forked, which
+	// stay in it until the init starts a session. One reading from our
+	// terminal has to stay in our group though, or it would be stopped by
+	// SIGTTIN.
+	cmd.SysProcAttr.Setpgid = !readsTerminal(p)
 	nsMaps := make(map[configs.NamespaceType]string)
+	var nsFiles []*os.File
 	for _, ns := range c.config.Namespaces {
-		if ns.Path != "" {
+		switch {
+		case ns.File != nil:
+			// The file is passed down to nsexec, which joins it directly.
+			cmd.ExtraFiles = append(cmd.ExtraFiles, ns.File)
+			nsMaps[ns.Type] = fmt.Sprintf("%s%d", nsFdPrefix, stdioFdCount+len(cmd.ExtraFiles)-1)
+			nsFiles = append(nsFiles, ns.File)
+		case ns.Path != "":
 			nsMaps[ns.Type] = ns.Path
 		}
 	}
-	_, sharePidns := nsMaps[configs.NEWPID]
-	data, err := c.bootstrapData(c.config.Namespaces.CloneFlags(), nsMaps)
+	config, err := c.newInitConfig(p)
 	if err != nil {
 		return nil, err
 	}
-	return &initProcess{
-		cmd:           cmd,
+	config.Config = c.ownedMountsConfig()
+	var (
+		stub containerSpawner
+		data io.Reader
+	)
+	if nsexecBootstrap {
+		if data, err = c.bootstrapData(c.config.Namespaces.CloneFlags(), nsMaps, config.Rlimits); err != nil {
+			return nil, err
+		}
+		stub = &stubbedProcess{stubCmd: cmd}
+	} else {
+		stub = newClonedProcess(cmd, c.config)
+	}
+	initProc := &initProcess{
+		stub:          stub,
+		env:           hostProcessEnv,
 		childPipe:     childPipe,
 		parentPipe:    parentPipe,
 		manager:       c.cgroupManager,
-		config:        c.newInitConfig(p),
+		config:        config,
 		container:     c,
 		process:       p,
 		bootstrapData: data,
-		sharePidns:    sharePidns,
+		sharePidns:    c.sharesPidns(),
 		rootDir:       rootDir,
-	}, nil
+		nsFiles:       nsFiles,
+		waitMode:      p.WaitMode,
+		pidFile:       p.PidFile,
+		exitFile:      p.ExitFile,
+	}
+	initProc.tracer = &syncTracer{enabled: c.traceSync, pid: initProc.pid, clock: initProc.env.clock}
+	return initProc, nil
 }
 
 func (c *linuxContainer) newSetnsProcess(p *Process, cmd *exec.Cmd, parentPipe, childPipe *os.File) (*setnsProcess, error) {
 	cmd.Env = append(cmd.Env, "_LIBCONTAINER_INITTYPE="+string(initSetns))
+	// The stages nsexec forks stay in the group of the stub until the
+	// container process starts a session, so a stub leading a group of its
+	// own can be aborted along with them.
+	cmd.SysProcAttr.Setpgid = !readsTerminal(p)
 	state, err := c.currentState()
 	if err != nil {
 		return nil, newSystemErrorWithCause(err, "getting container's current state")
 	}
+	nsPaths, hostUser := state.NamespacePaths, false
+	switch {
+	case p.CgroupOnly:
+		if p.Namespaces != nil {
+			return nil, newGenericError(fmt.Errorf("a process entering only the cgroups of the container cannot join some of its namespaces"), ConfigInvalid)
+		}
+		if p.HostBinary != "" {
+			return nil, newGenericError(fmt.Errorf("a process entering only the cgroups of the container cannot have a host binary"), ConfigInvalid)
+		}
+		if c.config.Rootless {
+			return nil, newGenericError(fmt.Errorf("the cgroups of a rootless container cannot be entered"), ConfigInvalid)
+		}
+		nsPaths = nil
+	case p.Namespaces != nil:
+		if nsPaths, hostUser, err = c.subsetNamespacePaths(p, nsPaths); err != nil {
+			return nil, err
+		}
+	case !joinsUserNS(p):
+		if nsPaths, hostUser, err = c.hostUserNamespacePaths(nsPaths); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSetnsBootstrap(nsPaths); err != nil {
+		return nil, newGenericError(err, ConfigInvalid)
+	}
+	config, err := c.newInitConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	config.HostUser = hostUser
+	config.CgroupOnly = p.CgroupOnly
 	// for setns process, we don't have to set cloneflags as the process namespaces
 	// will only be set via setns syscall
-	data, err := c.bootstrapData(0, state.NamespacePaths)
+	var extra []nl.NetlinkRequestData
+	if p.batch {
+		// The batch socket is the first of the extra files.
+		extra = append(extra, &Int32msg{
+			Type:  BatchAttr,
+			Value: uint32(stdioFdCount),
+		})
+	}
+	data, err := c.bootstrapData(0, nsPaths, config.Rlimits, extra...)
 	if err != nil {
 		return nil, err
 	}
-	return &setnsProcess{
-		cmd:           cmd,
+	var hostBinary *os.File
+	if p.HostBinary != "" {
+		if hostBinary, err = openHostBinary(p.HostBinary); err != nil {
+			return nil, newSystemErrorWithCause(err, "opening host binary")
+		}
+	}
+	setns := &setnsProcess{
+		stub:          &stubbedProcess{stubCmd: cmd},
+		hostBinary:    hostBinary,
+		env:           hostProcessEnv,
 		cgroupPaths:   c.cgroupManager.GetPaths(),
 		childPipe:     childPipe,
 		parentPipe:    parentPipe,
-		config:        c.newInitConfig(p),
+		config:        config,
 		process:       p,
 		bootstrapData: data,
-	}, nil
+		metrics:       c.metrics,
+		stopped: func() bool {
+			status, err := c.runType()
+			return err == nil && status == Stopped
+		},
+		exited: func() {
+			c.dropConsole(p)
+		},
+	}
+	// The stub is kept out of the cgroups of the container, along with the
+	// init, which is only moved into them right before it executes the
+	// process.
+	setns.config.LateCgroups = p.StubPriority != nil || len(p.StubCgroupPaths) > 0
+	setns.tracer = &syncTracer{enabled: c.traceSync, pid: setns.pid, clock: setns.env.clock}
+	return setns, nil
 }
 
-func (c *linuxContainer) newInitConfig(process *Process) *initConfig {
+func (c *linuxContainer) newInitConfig(process *Process) (*initConfig, error) {
+	if process.Umask != nil && *process.Umask > 0777 {
+		return nil, newGenericError(fmt.Errorf("invalid umask %#o: must be at most 0777", *process.Umask), ConfigInvalid)
+	}
+	caps := process.Capabilities
+	if caps == nil {
+		caps = c.config.Capabilities
+	}
+	caps, w, err := checkAmbient(caps, c.config.AllowAmbientFallback)
+	if err != nil {
+		return nil, err
+	}
+	if w != nil {
+		c.warn(*w)
+	}
 	cfg := &initConfig{
 		Config:           c.config,
 		Args:             process.Args,
@@ -455,7 +1359,7 @@ func (c *linuxContainer) newInitConfig(process *Process) *initConfig {
 		User:             process.User,
 		AdditionalGroups: process.AdditionalGroups,
 		Cwd:              process.Cwd,
-		Capabilities:     process.Capabilities,
+		Capabilities:     caps,
 		PassedFilesCount: len(process.ExtraFiles),
 		ContainerId:      c.ID(),
 		NoNewPrivileges:  c.config.NoNewPrivileges,
@@ -463,6 +1367,11 @@ func (c *linuxContainer) newInitConfig(process *Process) *initConfig {
 		AppArmorProfile:  c.config.AppArmorProfile,
 		ProcessLabel:     c.config.ProcessLabel,
 		Rlimits:          c.config.Rlimits,
+		HostBinary:       process.HostBinary != "",
+		ProtocolVersion:  syncProtocolVersion,
+		SchedIdle:        process.SchedIdle || c.schedIdle,
+		Umask:            process.Umask,
+		StateRootMasks:   exposedStateRoot(filepath.Dir(c.root), c.config),
 	}
 	if process.NoNewPrivileges != nil {
 		cfg.NoNewPrivileges = *process.NoNewPrivileges
@@ -477,30 +1386,44 @@ func (c *linuxContainer) newInitConfig(process *Process) *initConfig {
 		cfg.Rlimits = process.Rlimits
 	}
 	cfg.CreateConsole = process.ConsoleSocket != nil
-	return cfg
+	return cfg, nil
 }
 
 func (c *linuxContainer) Destroy() error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	return c.state.destroy()
+	if err := c.state.destroy(); err != nil {
+		return err
+	}
+	c.lifetime.disarm()
+	return nil
 }
 
 func (c *linuxContainer) Pause() error {
 	c.m.Lock()
 	defer c.m.Unlock()
+	if c.quiescing {
+		return newGenericError(fmt.Errorf("container is being quiesced"), ContainerPaused)
+	}
 	status, err := c.currentStatus()
 	if err != nil {
 		return err
 	}
 	switch status {
 	case Running, Created:
-		if err := c.cgroupManager.Freeze(configs.Frozen); err != nil {
+		if err := c.freeze(configs.Frozen); err != nil {
 			return err
 		}
-		return c.state.transition(&pausedState{
+		if err := c.state.transition(&pausedState{
 			c: c,
-		})
+		}); err != nil {
+			return err
+		}
+		state, err := c.currentState()
+		if err != nil {
+			return err
+		}
+		return c.saveState(state)
 	}
 	return newGenericError(fmt.Errorf("container not running or created: %s", status), ContainerNotRunning)
 }
@@ -508,6 +1431,9 @@ func (c *linuxContainer) Pause() error {
 func (c *linuxContainer) Resume() error {
 	c.m.Lock()
 	defer c.m.Unlock()
+	if c.quiescing {
+		return newGenericError(fmt.Errorf("container is being quiesced, not paused"), ContainerNotPaused)
+	}
 	status, err := c.currentStatus()
 	if err != nil {
 		return err
@@ -515,12 +1441,19 @@ func (c *linuxContainer) Resume() error {
 	if status != Paused {
 		return newGenericError(fmt.Errorf("container not paused"), ContainerNotPaused)
 	}
-	if err := c.cgroupManager.Freeze(configs.Thawed); err != nil {
+	if err := c.freeze(configs.Thawed); err != nil {
 		return err
 	}
-	return c.state.transition(&runningState{
+	if err := c.state.transition(&runningState{
 		c: c,
-	})
+	}); err != nil {
+		return err
+	}
+	state, err := c.currentState()
+	if err != nil {
+		return err
+	}
+	return c.saveState(state)
 }
 
 func (c *linuxContainer) NotifyOOM() (<-chan struct{}, error) {
@@ -694,6 +1627,9 @@ func (c *linuxContainer) Checkpoint(criuOpts *CriuOpts) error {
 	if c.config.Rootless {
 		return fmt.Errorf("cannot checkpoint a rootless container")
 	}
+	if dest := c.helperServedMount(); dest != "" {
+		return newGenericError(fmt.Errorf("cannot checkpoint a container whose mount %s is served by a FUSE helper", dest), ConfigInvalid)
+	}
 
 	if err := c.checkCriuVersion("1.5.2"); err != nil {
 		return err
@@ -703,6 +1639,23 @@ func (c *linuxContainer) Checkpoint(criuOpts *CriuOpts) error {
 		return fmt.Errorf("invalid directory to save checkpoint")
 	}
 
+	// Check the page server, if any, before anything is written.
+	var ps *criurpc.CriuPageServerInfo
+	if criuOpts.PageServer.Conn != nil || (criuOpts.PageServer.Address != "" && criuOpts.PageServer.Port != 0) {
+		// The page server looks up the parent image of a pre-dump relative
+		// to its own images directory.
+		if filepath.IsAbs(criuOpts.ParentImage) {
+			return fmt.Errorf("parent image must be relative to the page server's images directory")
+		}
+		if err := c.checkCriuVersion("3.0"); err != nil {
+			return err
+		}
+		var err error
+		if ps, err = checkPageServer(criuOpts.PageServer); err != nil {
+			return err
+		}
+	}
+
 	// Since a container can be C/R'ed multiple times,
 	// the checkpoint directory may already exist.
 	if err := os.Mkdir(criuOpts.ImagesDirectory, 0755); err != nil && !os.IsExist(err) {
@@ -752,14 +1705,6 @@ func (c *linuxContainer) Checkpoint(criuOpts *CriuOpts) error {
 		rpcOpts.FreezeCgroup = proto.String(fcg)
 	}
 
-	// append optional criu opts, e.g., page-server and port
-	if criuOpts.PageServer.Address != "" && criuOpts.PageServer.Port != 0 {
-		rpcOpts.Ps = &criurpc.CriuPageServerInfo{
-			Address: proto.String(criuOpts.PageServer.Address),
-			Port:    proto.Int32(criuOpts.PageServer.Port),
-		}
-	}
-
 	//pre-dump may need parentImage param to complete iterative migration
 	if criuOpts.ParentImage != "" {
 		rpcOpts.ParentImg = proto.String(criuOpts.ParentImage)
@@ -789,6 +1734,9 @@ func (c *linuxContainer) Checkpoint(criuOpts *CriuOpts) error {
 	} else {
 		t = criurpc.CriuReqType_DUMP
 	}
+
+	// append optional criu opts, e.g., page server address and port
+	rpcOpts.Ps = ps
 	req := &criurpc.CriuReq{
 		Type: &t,
 		Opts: &rpcOpts,
@@ -822,6 +1770,10 @@ func (c *linuxContainer) Checkpoint(criuOpts *CriuOpts) error {
 			c.addCriuDumpMount(req, m)
 		}
 
+		if err := c.dumpNetwork(req, criuOpts); err != nil {
+			return err
+		}
+
 		// Write the FD info to a file in the image directory
 		fdsJSON, err := json.Marshal(c.initProcess.externalDescriptors())
 		if err != nil {
@@ -834,13 +1786,54 @@ func (c *linuxContainer) Checkpoint(criuOpts *CriuOpts) error {
 		}
 	}
 
+	if thaw, err := c.preFreeze(criuOpts); err != nil {
+		return err
+	} else if thaw {
+		defer c.cgroupManager.Freeze(configs.Thawed, c.freezeTimeout)
+	}
+	if !criuOpts.PreDump && len(criuOpts.TmpfsSnapshots) > 0 {
+		if err := c.snapshotTmpfsMounts(criuOpts); err != nil {
+			return err
+		}
+	}
+	timer := startTimer(c.metrics)
 	err = c.criuSwrk(nil, req, criuOpts, false)
 	if err != nil {
 		return err
 	}
+	if !criuOpts.PreDump {
+		timer.observe(MetricCriuDump)
+	}
 	return nil
 }
 
+// preFreeze freezes the container for a checkpoint within the FreezeTimeout
+// of criuOpts, so that a process stuck in uninterruptible sleep fails the
+// checkpoint instead of hanging CRIU. It returns true if the container has
+// to be thawed again once the checkpoint is done. Without a FreezeTimeout,
+// the container is still frozen if tmpfs snapshots are to be taken, so that
+// they are consistent with the dump.
+func (c *linuxContainer) preFreeze(criuOpts *CriuOpts) (bool, error) {
+	timeout := criuOpts.FreezeTimeout
+	if timeout == 0 {
+		if criuOpts.PreDump || len(criuOpts.TmpfsSnapshots) == 0 {
+			return false, nil
+		}
+		timeout = c.freezeTimeout
+	}
+	if c.cgroupManager.GetPaths()["freezer"] == "" {
+		return false, nil
+	}
+	paused, err := c.isPaused()
+	if err != nil || paused {
+		return false, err
+	}
+	if err := c.cgroupManager.Freeze(configs.Frozen, timeout); err != nil {
+		return false, newSystemErrorWithCause(err, "freezing container for checkpoint")
+	}
+	return true, nil
+}
+
 func (c *linuxContainer) addCriuRestoreMount(req *criurpc.CriuReq, m *configs.Mount) {
 	mountDest := m.Destination
 	if strings.HasPrefix(mountDest, c.config.Rootfs) {
@@ -854,8 +1847,8 @@ func (c *linuxContainer) addCriuRestoreMount(req *criurpc.CriuReq, m *configs.Mo
 	req.Opts.ExtMnt = append(req.Opts.ExtMnt, extMnt)
 }
 
-func (c *linuxContainer) restoreNetwork(req *criurpc.CriuReq, criuOpts *CriuOpts) {
-	for _, iface := range c.config.Networks {
+func (c *linuxContainer) restoreNetwork(req *criurpc.CriuReq, criuOpts *CriuOpts, networks []*configs.Network) {
+	for _, iface := range networks {
 		switch iface.Type {
 		case "veth":
 			veth := new(criurpc.CriuVethPair)
@@ -875,9 +1868,10 @@ func (c *linuxContainer) restoreNetwork(req *criurpc.CriuReq, criuOpts *CriuOpts
 	}
 }
 
-func (c *linuxContainer) Restore(process *Process, criuOpts *CriuOpts) error {
+func (c *linuxContainer) Restore(process *Process, criuOpts *CriuOpts) (err error) {
 	c.m.Lock()
 	defer c.m.Unlock()
+	c.passFiles(process.ExtraFiles)
 
 	// TODO(avagin): Figure out how to make this work nicely. CRIU doesn't have
 	//               support for unprivileged restore at the moment.
@@ -888,6 +1882,11 @@ func (c *linuxContainer) Restore(process *Process, criuOpts *CriuOpts) error {
 	if err := c.checkCriuVersion("1.5.2"); err != nil {
 		return err
 	}
+	// Make sure all pages have arrived before restoring from them.
+	if ps := c.lastPageServer(); ps != nil {
+		ps.Wait()
+	}
+	c.forgetPageServer()
 	if criuOpts.WorkDirectory == "" {
 		criuOpts.WorkDirectory = filepath.Join(c.root, "criu.work")
 	}
@@ -950,9 +1949,30 @@ func (c *linuxContainer) Restore(process *Process, criuOpts *CriuOpts) error {
 		},
 	}
 
-	for _, m := range c.config.Mounts {
+	tmpfsDirs, unmountTmpfs, err := c.restoreTmpfsMounts(criuOpts)
+	if err != nil {
+		return err
+	}
+	defer unmountTmpfs()
+	// The mounts with a forced owner are restored from their views, as the
+	// init process mounts them.
+	if err := c.setupOwnedMounts(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := c.releaseOwnedMounts(); rerr != nil {
+				logrus.Warn(rerr)
+			}
+		}
+	}()
+	for _, m := range c.ownedMountsConfig().Mounts {
 		switch m.Device {
 		case "bind":
+			if dir, ok := tmpfsDirs[filepath.Clean(m.Destination)]; ok {
+				c.addCriuRestoreMount(req, &configs.Mount{Source: dir, Destination: m.Destination})
+				break
+			}
 			c.addCriuRestoreMount(req, m)
 			break
 		case "cgroup":
@@ -977,8 +1997,17 @@ func (c *linuxContainer) Restore(process *Process, criuOpts *CriuOpts) error {
 		c.addCriuRestoreMount(req, m)
 	}
 
+	var extraFiles []*os.File
+	networks, netns, err := c.remapNetwork(req, criuOpts)
+	if err != nil {
+		return err
+	}
+	if netns != nil {
+		defer netns.Close()
+		extraFiles = append(extraFiles, netns)
+	}
 	if criuOpts.EmptyNs&unix.CLONE_NEWNET == 0 {
-		c.restoreNetwork(req, criuOpts)
+		c.restoreNetwork(req, criuOpts, networks)
 	}
 
 	// append optional manage cgroups mode
@@ -1003,13 +2032,31 @@ func (c *linuxContainer) Restore(process *Process, criuOpts *CriuOpts) error {
 	}
 	for i := range fds {
 		if s := fds[i]; strings.Contains(s, "pipe:") {
+			fd := i
+			if i >= stdioFdCount {
+				// A passed pipe is taken from the ExtraFiles of process, if
+				// given, which CRIU gets after the swrk socket and the other
+				// extraFiles. Otherwise CRIU restores the pipe itself.
+				if i-stdioFdCount >= len(process.ExtraFiles) {
+					continue
+				}
+				extraFiles = append(extraFiles, process.ExtraFiles[i-stdioFdCount])
+				fd = stdioFdCount + len(extraFiles)
+			}
 			inheritFd := new(criurpc.InheritFd)
 			inheritFd.Key = proto.String(s)
-			inheritFd.Fd = proto.Int32(int32(i))
+			inheritFd.Fd = proto.Int32(int32(fd))
 			req.Opts.InheritFd = append(req.Opts.InheritFd, inheritFd)
 		}
 	}
-	return c.criuSwrk(process, req, criuOpts, true)
+	// The host ends are attached under their new names on network-unlock.
+	dumpedNetworks := c.config.Networks
+	c.config.Networks = networks
+	if err := c.criuSwrk(process, req, criuOpts, true, extraFiles...); err != nil {
+		c.config.Networks = dumpedNetworks
+		return err
+	}
+	return nil
 }
 
 func (c *linuxContainer) criuApplyCgroups(pid int, req *criurpc.CriuReq) error {
@@ -1039,7 +2086,7 @@ func (c *linuxContainer) criuApplyCgroups(pid int, req *criurpc.CriuReq) error {
 	return nil
 }
 
-func (c *linuxContainer) criuSwrk(process *Process, req *criurpc.CriuReq, opts *CriuOpts, applyCgroups bool) error {
+func (c *linuxContainer) criuSwrk(process *Process, req *criurpc.CriuReq, opts *CriuOpts, applyCgroups bool, extraFiles ...*os.File) error {
 	fds, err := unix.Socketpair(unix.AF_LOCAL, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return err
@@ -1068,7 +2115,11 @@ func (c *linuxContainer) criuSwrk(process *Process, req *criurpc.CriuReq, opts *
 		cmd.Stdout = process.Stdout
 		cmd.Stderr = process.Stderr
 	}
-	cmd.ExtraFiles = append(cmd.ExtraFiles, criuServer)
+	// CRIU only gets the swrk socket as fd 3, followed by extraFiles.
+	cmd.ExtraFiles = append([]*os.File{criuServer}, extraFiles...)
+	if err := c.scrubInheritedFds(); err != nil {
+		return err
+	}
 
 	if err := cmd.Start(); err != nil {
 		return err
@@ -1092,10 +2143,17 @@ func (c *linuxContainer) criuSwrk(process *Process, req *criurpc.CriuReq, opts *
 
 	var extFds []string
 	if process != nil {
-		extFds, err = getPipeFds(cmd.Process.Pid)
+		extFds, err = getPipeFds(cmd.Process.Pid, stdioFdCount)
+		if err != nil {
+			return err
+		}
+		// The restored process inherits the ExtraFiles of process in place
+		// of the pipes it was passed when dumped.
+		passed, err := fileTargets(process.ExtraFiles)
 		if err != nil {
 			return err
 		}
+		extFds = append(extFds, passed...)
 	}
 
 	logrus.Debugf("Using CRIU in %s mode", req.GetType().String())
@@ -1172,6 +2230,9 @@ func (c *linuxContainer) criuSwrk(process *Process, req *criurpc.CriuReq, opts *
 				return err
 			}
 			continue
+		case t == criurpc.CriuReqType_PAGE_SERVER:
+			ps := resp.GetPs()
+			c.pageServer = &PageServer{Port: ps.GetPort(), pid: int(ps.GetPid())}
 		case t == criurpc.CriuReqType_RESTORE:
 		case t == criurpc.CriuReqType_DUMP:
 		case t == criurpc.CriuReqType_PRE_DUMP:
@@ -1254,16 +2315,9 @@ func (c *linuxContainer) criuNotifications(resp *criurpc.CriuResp, process *Proc
 		}
 	case notify.GetScript() == "setup-namespaces":
 		if c.config.Hooks != nil {
-			s := configs.HookState{
-				Version: c.config.Version,
-				ID:      c.id,
-				Pid:     int(notify.GetPid()),
-				Bundle:  utils.SearchLabels(c.config.Labels, "bundle"),
-			}
-			for i, hook := range c.config.Hooks.Prestart {
-				if err := hook.Run(s); err != nil {
-					return newSystemErrorWithCausef(err, "running prestart hook %d", i)
-				}
+			s := c.newHookState("creating", int(notify.GetPid()))
+			if err := c.runHooks("prestart", c.config.Hooks.Prestart, s); err != nil {
+				return err
 			}
 		}
 	case notify.GetScript() == "post-restore":
@@ -1272,13 +2326,28 @@ func (c *linuxContainer) criuNotifications(resp *criurpc.CriuResp, process *Proc
 		if err != nil {
 			return err
 		}
-		process.ops = r
+		prevState, prevInit := c.state, c.initProcess
 		if err := c.state.transition(&restoredState{
 			imageDir: opts.ImagesDirectory,
 			c:        c,
 		}); err != nil {
 			return err
 		}
+		// CRIU resumes the restored tasks only once we have acknowledged
+		// this notification, so the process joins them while they are still
+		// stopped. Failing here makes CRIU abort the restore, so nothing is
+		// saved before.
+		if opts.PreResumeProcess != nil {
+			c.initProcess = r
+			if err := c.start(opts.PreResumeProcess, false); err != nil {
+				if opts.AbortOnPreResumeFailure {
+					c.state, c.initProcess = prevState, prevInit
+					return newSystemErrorWithCause(err, "starting process before resuming restored container")
+				}
+				logrus.Warnf("cannot start process before resuming restored container: %v", err)
+			}
+		}
+		process.setOps(r)
 		// create a timestamp indicating when the restored checkpoint was started
 		c.created = time.Now().UTC()
 		if _, err := c.updateState(r); err != nil {
@@ -1379,8 +2448,17 @@ func (c *linuxContainer) runType() (Status, error) {
 	}
 	// We'll create exec fifo and blocking on it after container is created,
 	// and delete it after start container.
-	if _, err := os.Stat(filepath.Join(c.root, execFifoFilename)); err == nil {
-		return Created, nil
+	fifo := filepath.Join(c.root, execFifoFilename)
+	if _, err := os.Stat(fifo); err == nil {
+		if stat.Name == initWaitName {
+			return Created, nil
+		}
+		// The init process was released by a start which did not get to
+		// remove the fifo.
+		logrus.Warnf("removing the exec fifo of running container %s", c.id)
+		if err := os.Remove(fifo); err != nil && !os.IsNotExist(err) {
+			return Stopped, newSystemErrorWithCause(err, "removing drained exec fifo")
+		}
 	}
 	return Running, nil
 }
@@ -1388,10 +2466,21 @@ func (c *linuxContainer) runType() (Status, error) {
 func (c *linuxContainer) isPaused() (bool, error) {
 	fcg := c.cgroupManager.GetPaths()["freezer"]
 	if fcg == "" {
+		// On cgroup v2, the container may have been frozen by someone else.
+		if path := cgroupEventsPath(c.cgroupManager.GetPaths()); path != "" {
+			events, err := readCgroupEvents(path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return false, nil
+				}
+				return false, newSystemErrorWithCause(err, "checking if container is paused")
+			}
+			return events.frozen, nil
+		}
 		// A container doesn't have a freezer cgroup
 		return false, nil
 	}
-	data, err := ioutil.ReadFile(filepath.Join(fcg, "freezer.state"))
+	frozen, err := freezerFrozen(fcg)
 	if err != nil {
 		// If freezer cgroup is not mounted, the container would just be not paused.
 		if os.IsNotExist(err) {
@@ -1399,7 +2488,7 @@ func (c *linuxContainer) isPaused() (bool, error) {
 		}
 		return false, newSystemErrorWithCause(err, "checking if container is paused")
 	}
-	return bytes.Equal(bytes.TrimSpace(data), []byte("FROZEN")), nil
+	return frozen, nil
 }
 
 func (c *linuxContainer) currentState() (*State, error) {
@@ -1413,6 +2502,9 @@ func (c *linuxContainer) currentState() (*State, error) {
 		startTime, _ = c.initProcess.startTime()
 		externalDescriptors = c.initProcess.externalDescriptors()
 	}
+	c.stopMu.Lock()
+	stopCause := c.stopCause
+	c.stopMu.Unlock()
 	state := &State{
 		BaseState: BaseState{
 			ID:                   c.ID(),
@@ -1425,6 +2517,18 @@ func (c *linuxContainer) currentState() (*State, error) {
 		CgroupPaths:         c.cgroupManager.GetPaths(),
 		NamespacePaths:      make(map[configs.NamespaceType]string),
 		ExternalDescriptors: externalDescriptors,
+		SharedPidns:         c.sharesPidns(),
+		HookAnnotations:     c.hookAnnotations,
+		PidFile:             c.pidFile,
+		Init:                c.initInfo,
+		SchedIdle:           c.schedIdle,
+		SchedPolicies:       c.schedPolicies,
+		LifetimeDeadline:    c.lifetime.deadlineAt(),
+		LifetimeExceeded:    c.lifetime.wasExceeded(),
+		Exit:                c.exit,
+		StaleCreated:        c.staleCreated,
+		Paused:              c.state != nil && c.state.status() == Paused,
+		StopCause:           stopCause,
 	}
 	if pid > 0 {
 		for _, ns := range c.config.Namespaces {
@@ -1443,8 +2547,9 @@ func (c *linuxContainer) currentState() (*State, error) {
 	return state, nil
 }
 
-// orderNamespacePaths sorts namespace paths into a list of paths that we
-// can setns in order.
+// orderNamespacePaths returns the namespaces of the config to join, from
+// the paths in namespaces, as "type:path" in the canonical order of
+// configs.NamespaceTypes.
 func (c *linuxContainer) orderNamespacePaths(namespaces map[configs.NamespaceType]string) ([]string, error) {
 	paths := []string{}
 
@@ -1460,9 +2565,15 @@ func (c *linuxContainer) orderNamespacePaths(namespaces map[configs.NamespaceTyp
 			if !configs.IsNamespaceSupported(ns) {
 				return nil, newSystemError(fmt.Errorf("namespace %s is not supported", ns))
 			}
-			// only set to join this namespace if it exists
-			if _, err := os.Lstat(p); err != nil {
-				return nil, newSystemErrorWithCausef(err, "running lstat on namespace path %q", p)
+			// namespace files were already checked when validating the config
+			if strings.HasPrefix(p, nsFdPrefix) {
+				paths = append(paths, fmt.Sprintf("%s:%s", configs.NsName(ns), p))
+				continue
+			}
+			// only set to join this namespace if it exists and is of the right type
+			nsPath := configs.Namespace{Type: ns, Path: p}
+			if err := nsPath.CheckPath(); err != nil {
+				return nil, newSystemError(err)
 			}
 			// do not allow namespace path with comma as we use it to separate
 			// the namespace paths
@@ -1494,7 +2605,7 @@ func encodeIDMapping(idMap []configs.IDMap) ([]byte, error) {
 // such as one that uses nsenter package to bootstrap the container's
 // init process correctly, i.e. with correct namespaces, uid/gid
 // mapping etc.
-func (c *linuxContainer) bootstrapData(cloneFlags uintptr, nsMaps map[configs.NamespaceType]string) (io.Reader, error) {
+func (c *linuxContainer) bootstrapData(cloneFlags uintptr, nsMaps map[configs.NamespaceType]string, rlimits []configs.Rlimit, extra ...nl.NetlinkRequestData) (io.Reader, error) {
 	// create the netlink message
 	r := nl.NewNetlinkRequest(int(InitMsg), 0)
 
@@ -1504,6 +2615,12 @@ func (c *linuxContainer) bootstrapData(cloneFlags uintptr, nsMaps map[configs.Na
 		Value: uint32(cloneFlags),
 	})
 
+	// write the attributes specific to the caller, ahead of the unpadded
+	// boolean ones
+	for _, attr := range extra {
+		r.AddData(attr)
+	}
+
 	// write custom namespace paths
 	if len(nsMaps) > 0 {
 		nsPaths, err := c.orderNamespacePaths(nsMaps)
@@ -1514,6 +2631,16 @@ func (c *linuxContainer) bootstrapData(cloneFlags uintptr, nsMaps map[configs.Na
 			Type:  NsPathsAttr,
 			Value: []byte(strings.Join(nsPaths, ",")),
 		})
+
+		// write how joining a busy namespace is retried
+		r.AddData(&Int32msg{
+			Type:  SetnsRetriesAttr,
+			Value: uint32(c.setnsRetries),
+		})
+		r.AddData(&Int32msg{
+			Type:  SetnsBackoffAttr,
+			Value: uint32(c.setnsBackoff / time.Microsecond),
+		})
 	}
 
 	// write namespace paths only when we are not joining an existing user ns
@@ -1558,7 +2685,8 @@ func (c *linuxContainer) bootstrapData(cloneFlags uintptr, nsMaps map[configs.Na
 		}
 	}
 
-	// write oom_score_adj
+	// write oom_score_adj, even when it is 0, so that the score of runc is
+	// never inherited
 	r.AddData(&Bytemsg{
 		Type:  OomScoreAdjAttr,
 		Value: []byte(fmt.Sprintf("%d", c.config.OomScoreAdj)),
@@ -1570,5 +2698,21 @@ func (c *linuxContainer) bootstrapData(cloneFlags uintptr, nsMaps map[configs.Na
 		Value: c.config.Rootless,
 	})
 
-	return bytes.NewReader(r.Serialize()), nil
+	// write the rlimits nsexec has to raise before leaving our user namespace
+	raised, err := raisedRlimits(rlimits)
+	if err != nil {
+		return nil, newGenericError(err, ConfigInvalid)
+	}
+	if len(raised) > 0 {
+		r.AddData(&Bytemsg{
+			Type:  RlimitsAttr,
+			Value: encodeRlimits(raised),
+		})
+	}
+
+	data, err := serializeBootstrapData(r)
+	if err != nil {
+		return nil, newSystemError(err)
+	}
+	return data, nil
 }
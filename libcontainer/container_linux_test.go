@@ -3,12 +3,26 @@
 package libcontainer
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/criurpc"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
 )
 
 type mockCgroupManager struct {
@@ -16,6 +30,10 @@ type mockCgroupManager struct {
 	allPids []int
 	stats   *cgroups.Stats
 	paths   map[string]string
+	froze   bool
+	thawed  bool
+	// freezeErr is returned by Freeze, as when there is no freezer.
+	freezeErr error
 }
 
 func (m *mockCgroupManager) GetPids() ([]int, error) {
@@ -46,13 +64,38 @@ func (m *mockCgroupManager) GetPaths() map[string]string {
 	return m.paths
 }
 
-func (m *mockCgroupManager) Freeze(state configs.FreezerState) error {
+func (m *mockCgroupManager) Freeze(state configs.FreezerState, timeout time.Duration) error {
+	if m.freezeErr != nil {
+		return m.freezeErr
+	}
+	if state == configs.Frozen {
+		m.froze = true
+	} else {
+		m.thawed = true
+	}
 	return nil
 }
 
+// forkingCgroupManager is a mockCgroupManager whose processes fork those
+// of forked once their pids have been read.
+type forkingCgroupManager struct {
+	mockCgroupManager
+	forked []int
+	reads  int
+}
+
+func (m *forkingCgroupManager) GetAllPids() ([]int, error) {
+	m.reads++
+	if m.reads == 1 {
+		return m.allPids, nil
+	}
+	return append(append([]int(nil), m.allPids...), m.forked...), nil
+}
+
 type mockProcess struct {
 	_pid    int
 	started uint64
+	signals []os.Signal
 }
 
 func (m *mockProcess) terminate() error {
@@ -75,7 +118,8 @@ func (m *mockProcess) wait() (*os.ProcessState, error) {
 	return nil, nil
 }
 
-func (m *mockProcess) signal(_ os.Signal) error {
+func (m *mockProcess) signal(s os.Signal) error {
+	m.signals = append(m.signals, s)
 	return nil
 }
 
@@ -130,6 +174,23 @@ func TestGetContainerStats(t *testing.T) {
 	}
 }
 
+func TestGetContainerStatsMissingInterface(t *testing.T) {
+	container := &linuxContainer{
+		id: "myid",
+		config: &configs.Config{
+			Networks: []*configs.Network{{Type: "veth", HostInterfaceName: "runc-missing0"}},
+		},
+		cgroupManager: &mockCgroupManager{stats: &cgroups.Stats{}},
+	}
+	stats, err := container.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Interfaces) != 0 {
+		t.Fatalf("expected the missing interface to be omitted, got %+v", stats.Interfaces)
+	}
+}
+
 func TestGetContainerState(t *testing.T) {
 	var (
 		pid                 = os.Getpid()
@@ -184,6 +245,9 @@ func TestGetContainerState(t *testing.T) {
 	if memPath := paths["memory"]; memPath != expectedMemoryPath {
 		t.Fatalf("expected memory path %q but received %q", expectedMemoryPath, memPath)
 	}
+	if state.SharedPidns {
+		t.Fatal("expected container with a new PID namespace not to share it")
+	}
 	for _, ns := range container.config.Namespaces {
 		path := state.NamespacePaths[ns.Type]
 		if path == "" {
@@ -216,3 +280,591 @@ func TestGetContainerState(t *testing.T) {
 		}
 	}
 }
+
+func TestKillCgroupProcessesOnExit(t *testing.T) {
+	disabled, enabled := false, true
+	for _, test := range []struct {
+		namespaces configs.Namespaces
+		kill       *bool
+		shared     bool
+		expected   bool
+		destroy    bool
+	}{
+		{namespaces: configs.Namespaces{{Type: configs.NEWPID}}, shared: false, expected: false, destroy: false},
+		{namespaces: configs.Namespaces{{Type: configs.NEWPID}}, kill: &enabled, shared: false, expected: false, destroy: false},
+		{namespaces: configs.Namespaces{{Type: configs.NEWPID, Path: "/proc/1/ns/pid"}}, shared: true, expected: true, destroy: false},
+		{namespaces: configs.Namespaces{{Type: configs.NEWPID, Path: "/proc/1/ns/pid"}}, kill: &enabled, shared: true, expected: true, destroy: true},
+		{namespaces: configs.Namespaces{{Type: configs.NEWNS}}, shared: true, expected: false, destroy: true},
+		{namespaces: configs.Namespaces{{Type: configs.NEWNS}}, kill: &enabled, shared: true, expected: true, destroy: true},
+		{namespaces: configs.Namespaces{{Type: configs.NEWNS}}, kill: &disabled, shared: true, expected: false, destroy: true},
+	} {
+		container := &linuxContainer{
+			config: &configs.Config{
+				Namespaces:                test.namespaces,
+				KillCgroupProcessesOnExit: test.kill,
+			},
+		}
+		if shared := container.sharesPidns(); shared != test.shared {
+			t.Errorf("%v: expected shared PID namespace %v but received %v", test.namespaces, test.shared, shared)
+		}
+		if kill := container.killCgroupProcessesOnExit(); kill != test.expected {
+			t.Errorf("%v: expected kill on exit %v but received %v", test.namespaces, test.expected, kill)
+		}
+		if destroy := container.killCgroupProcessesOnDestroy(); destroy != test.destroy {
+			t.Errorf("%v: expected kill on destroy %v but received %v", test.namespaces, test.destroy, destroy)
+		}
+	}
+}
+
+func TestStoppedWithStragglers(t *testing.T) {
+	for _, test := range []struct {
+		namespaces configs.Namespaces
+		pids       []int
+		expected   Status
+	}{
+		{namespaces: configs.Namespaces{{Type: configs.NEWNS}}, pids: []int{1, 2}, expected: StoppedWithStragglers},
+		{namespaces: configs.Namespaces{{Type: configs.NEWNS}}, expected: Stopped},
+		{namespaces: configs.Namespaces{{Type: configs.NEWPID}}, pids: []int{1, 2}, expected: Stopped},
+	} {
+		container := &linuxContainer{
+			id:            "myid",
+			config:        &configs.Config{Namespaces: test.namespaces},
+			cgroupManager: &mockCgroupManager{allPids: test.pids},
+		}
+		container.state = &stoppedState{c: container}
+		status, err := container.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != test.expected {
+			t.Errorf("%v with pids %v: expected status %s but received %s", test.namespaces, test.pids, test.expected, status)
+		}
+	}
+}
+
+func TestSignalAllProcessesSparesCaller(t *testing.T) {
+	// killed has a process group of its own while spared shares that of
+	// the test, which is in the cgroup too.
+	killed := exec.Command("sleep", "100")
+	killed.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	spared := exec.Command("sleep", "100")
+	for _, cmd := range []*exec.Cmd{killed, spared} {
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		spared.Process.Kill()
+		spared.Wait()
+	}()
+	events := make(chan FactoryEvent, 4)
+	m := &mockCgroupManager{allPids: []int{killed.Process.Pid, os.Getpid(), spared.Process.Pid}}
+	container := &linuxContainer{id: "myid", cgroupManager: m, events: events}
+	if err := container.signalAllProcesses(unix.SIGKILL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Kill(killed.Process.Pid, 0); err != unix.ESRCH {
+		t.Errorf("expected %d to be killed and reaped, got %v", killed.Process.Pid, err)
+	}
+	if err := unix.Kill(spared.Process.Pid, 0); err != nil {
+		t.Errorf("expected %d to be spared, got %v", spared.Process.Pid, err)
+	}
+	if m.froze {
+		t.Error("expected the cgroups holding the caller not to be frozen")
+	}
+	close(events)
+	var pids []int
+	for ev := range events {
+		if ev.Type != CallerInContainerCgroup || ev.ID != "myid" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+		pids = append(pids, ev.Pid)
+	}
+	if len(pids) != 2 || pids[0] != os.Getpid() || pids[1] != spared.Process.Pid {
+		t.Errorf("expected events for %d and %d, got %v", os.Getpid(), spared.Process.Pid, pids)
+	}
+
+	// Without the caller in the cgroup, its process group is fair game.
+	m = &mockCgroupManager{allPids: []int{spared.Process.Pid}}
+	container = &linuxContainer{id: "myid", cgroupManager: m}
+	if err := container.signalAllProcesses(unix.SIGKILL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Kill(spared.Process.Pid, 0); err != unix.ESRCH {
+		t.Errorf("expected %d to be killed and reaped, got %v", spared.Process.Pid, err)
+	}
+	if !m.froze {
+		t.Error("expected the cgroups to be frozen")
+	}
+}
+
+func TestSetProcessScheduler(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	if err := setProcessScheduler(cmd.Process.Pid, SchedPolicy{Policy: system.SCHED_IDLE}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", cmd.Process.Pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The policy is the 41st field, counting from after the command name.
+	fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+	if policy := fields[38]; policy != strconv.Itoa(system.SCHED_IDLE) {
+		t.Fatalf("expected the SCHED_IDLE policy, got %s", policy)
+	}
+}
+
+func TestKillExemptPidsForgetsExited(t *testing.T) {
+	c, _, cleanup := newQuiesceContainer(t)
+	defer cleanup()
+	cmd := exec.Command("sleep", "100")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	pid := cmd.Process.Pid
+	if err := c.ExemptFromKill(pid); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.killExemptPids()[pid]; !ok {
+		t.Fatalf("expected %d to be exempt", pid)
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	if _, ok := c.killExemptPids()[pid]; ok {
+		t.Fatalf("expected %d to be forgotten once exited", pid)
+	}
+	if len(c.exemptPids) != 0 {
+		t.Fatalf("expected no exemption left, got %v", c.exemptPids)
+	}
+	if err := c.ExemptFromKill(pid); err == nil {
+		t.Fatal("expected a pid without a process to be refused")
+	}
+}
+
+func TestSetSchedIdleRestoresPolicy(t *testing.T) {
+	c, m, cleanup := newQuiesceContainer(t)
+	defer cleanup()
+	// SCHED_BATCH, which is not real-time and so needs no privileges.
+	const schedBatch = 3
+	var cmds []*exec.Cmd
+	defer func() {
+		for _, cmd := range cmds {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}()
+	start := func() int {
+		cmd := exec.Command("sleep", "10")
+		if err := cmd.Start(); err != nil {
+			t.Skip(err)
+		}
+		cmds = append(cmds, cmd)
+		return cmd.Process.Pid
+	}
+	policy := func(pid int) int {
+		policy, _, err := system.GetScheduler(pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return policy
+	}
+
+	batch := start()
+	if err := setProcessScheduler(batch, SchedPolicy{Policy: schedBatch}); err != nil {
+		t.Fatal(err)
+	}
+	m.allPids = []int{batch}
+	if err := c.SetSchedIdle(true); err != nil {
+		t.Fatal(err)
+	}
+	if p := policy(batch); p != system.SCHED_IDLE {
+		t.Fatalf("expected the SCHED_IDLE policy, got %d", p)
+	}
+	// A process started while the container is demoted has no policy to go
+	// back to.
+	later := start()
+	if err := setProcessScheduler(later, SchedPolicy{Policy: system.SCHED_IDLE}); err != nil {
+		t.Fatal(err)
+	}
+	m.allPids = append(m.allPids, later)
+	if err := c.SetSchedIdle(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetSchedIdle(false); err != nil {
+		t.Fatal(err)
+	}
+	if p := policy(batch); p != schedBatch {
+		t.Fatalf("expected the SCHED_BATCH policy to be restored, got %d", p)
+	}
+	if p := policy(later); p != system.SCHED_OTHER {
+		t.Fatalf("expected the SCHED_OTHER policy, got %d", p)
+	}
+}
+
+func TestOrderNamespacePaths(t *testing.T) {
+	for _, tt := range []struct {
+		config []configs.NamespaceType
+		want   []string
+	}{
+		{
+			config: []configs.NamespaceType{configs.NEWNET, configs.NEWUSER},
+			want:   []string{"user", "net"},
+		},
+		{
+			config: []configs.NamespaceType{configs.NEWNS, configs.NEWPID, configs.NEWUSER},
+			want:   []string{"user", "pid", "mnt"},
+		},
+		{
+			config: []configs.NamespaceType{configs.NEWNET},
+			want:   []string{"net"},
+		},
+	} {
+		c := &linuxContainer{config: &configs.Config{}}
+		paths := make(map[configs.NamespaceType]string)
+		for _, ns := range tt.config {
+			if !configs.IsNamespaceSupported(ns) {
+				t.Skipf("%s namespaces are not supported", configs.NsName(ns))
+			}
+			p := "/proc/self/ns/" + configs.NsName(ns)
+			c.config.Namespaces = append(c.config.Namespaces, configs.Namespace{Type: ns, Path: p})
+			paths[ns] = p
+		}
+		got, err := c.orderNamespacePaths(paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("expected %v to be joined, got %v", tt.want, got)
+		}
+		for i, name := range tt.want {
+			if want := name + ":/proc/self/ns/" + name; got[i] != want {
+				t.Errorf("expected %s to be joined in position %d, got %v", want, i, got)
+			}
+		}
+	}
+}
+
+func TestNewInitConfigUmask(t *testing.T) {
+	c := &linuxContainer{id: "test", config: &configs.Config{}}
+	cfg, err := c.newInitConfig(&Process{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Umask != nil {
+		t.Fatalf("expected no umask to be set, got %#o", *cfg.Umask)
+	}
+	umask := uint32(0077)
+	cfg, err = c.newInitConfig(&Process{Umask: &umask})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Umask == nil || *cfg.Umask != 0077 {
+		t.Fatalf("expected a umask of 0077, got %v", cfg.Umask)
+	}
+	umask = 01000
+	_, err = c.newInitConfig(&Process{Umask: &umask})
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a ConfigInvalid error, got %v", err)
+	}
+}
+
+func TestContainerWarnings(t *testing.T) {
+	events := make(chan FactoryEvent, 1)
+	container := &linuxContainer{id: "myid", events: events}
+	w := configs.Warning{Code: configs.WarnHook, FieldPath: "hooks.prestart[0]", Message: "slow"}
+	container.warn(w)
+	if got := container.Warnings(); len(got) != 1 || got[0] != w {
+		t.Fatalf("expected %+v to be recorded, got %+v", w, got)
+	}
+	ev := <-events
+	if ev.Type != ContainerWarning || ev.ID != "myid" || ev.Warning == nil || *ev.Warning != w {
+		t.Fatalf("expected a warning event for %+v, got %+v", w, ev)
+	}
+	for i := 0; i < maxWarnings; i++ {
+		container.warn(configs.Warning{Code: configs.WarnHook, Message: strconv.Itoa(i)})
+	}
+	got := container.Warnings()
+	if len(got) != maxWarnings || got[0].Message != "0" {
+		t.Fatalf("expected the oldest warning to be dropped, got %d warnings starting with %+v", len(got), got[0])
+	}
+}
+
+func TestSignalAllProcessesWithoutFreezer(t *testing.T) {
+	var cmds []*exec.Cmd
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command("sleep", "100")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	m := &forkingCgroupManager{
+		mockCgroupManager: mockCgroupManager{
+			allPids:   []int{cmds[0].Process.Pid},
+			freezeErr: errors.New("no freezer"),
+		},
+		forked: []int{cmds[1].Process.Pid},
+	}
+	if _, err := signalAllProcesses(m, unix.SIGKILL, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range cmds {
+		if err := unix.Kill(cmd.Process.Pid, 0); err != unix.ESRCH {
+			t.Errorf("expected %d to be killed and reaped, got %v", cmd.Process.Pid, err)
+		}
+	}
+	if m.reads != 3 {
+		t.Errorf("expected the pids to be read until no new one shows up, got %d reads", m.reads)
+	}
+	if m.thawed {
+		t.Error("expected the cgroups not to be thawed when they could not be frozen")
+	}
+}
+
+func TestSignalAllProcessesSkipsExited(t *testing.T) {
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	live := exec.Command("sleep", "100")
+	live.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := live.Start(); err != nil {
+		t.Fatal(err)
+	}
+	m := &mockCgroupManager{allPids: []int{exited.Process.Pid, live.Process.Pid}}
+	if _, err := signalAllProcesses(m, unix.SIGKILL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Kill(live.Process.Pid, 0); err != unix.ESRCH {
+		t.Errorf("expected %d to be killed and reaped, got %v", live.Process.Pid, err)
+	}
+}
+
+func TestSignalAllKillsInitOfPidns(t *testing.T) {
+	for _, namespaces := range []configs.Namespaces{{{Type: configs.NEWPID}}, nil} {
+		// With a PID namespace of its own, other stands for a process of the
+		// cgroups outside of it, as one started with CgroupOnly is.
+		other := exec.Command("sleep", "100")
+		other.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := other.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			other.Process.Kill()
+			other.Wait()
+		}()
+		init := &mockProcess{}
+		container := &linuxContainer{
+			id:            "myid",
+			config:        &configs.Config{Namespaces: namespaces},
+			cgroupManager: &mockCgroupManager{allPids: []int{other.Process.Pid}},
+			initProcess:   init,
+		}
+		if err := container.Signal(unix.SIGKILL, true); err != nil {
+			t.Fatal(err)
+		}
+		// Without a PID namespace, init is killed along with the cgroups.
+		if namespaces != nil && (len(init.signals) != 1 || init.signals[0] != unix.SIGKILL) {
+			t.Fatalf("expected the init process to be killed, got %v", init.signals)
+		}
+		if err := unix.Kill(other.Process.Pid, 0); err != unix.ESRCH {
+			t.Errorf("expected %d to be killed and reaped, got %v", other.Process.Pid, err)
+		}
+	}
+}
+
+func TestSignalAllThawsPausedPidns(t *testing.T) {
+	c, m, cleanup := newQuiesceContainer(t)
+	defer cleanup()
+	init := &mockProcess{}
+	c.initProcess = init
+	c.config.Namespaces = configs.Namespaces{{Type: configs.NEWPID}}
+	if err := m.Freeze(configs.Frozen, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Signal(unix.SIGKILL, true); err != nil {
+		t.Fatal(err)
+	}
+	if m.frozen() {
+		t.Fatal("expected the container to be thawed to be killed")
+	}
+	if len(init.signals) != 1 || init.signals[0] != unix.SIGKILL {
+		t.Fatalf("expected the init process to be killed, got %v", init.signals)
+	}
+}
+
+// startNamedScript runs script with sh under name, which its process has in
+// /proc until it execs, and returns it with its start time. The process and
+// its children are killed by calling kill.
+func startNamedScript(t *testing.T, dir, name, script string, args ...string) (cmd *exec.Cmd, started uint64, kill func()) {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(path, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	kill = func() {
+		unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+		cmd.Wait()
+	}
+	// The name is that of the script once sh runs it.
+	for i := 0; i < 100; i++ {
+		stat, err := system.Stat(cmd.Process.Pid)
+		if err != nil {
+			kill()
+			t.Fatal(err)
+		}
+		if stat.Name == name {
+			return cmd, stat.StartTime, kill
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	kill()
+	t.Fatalf("process %d did not get the name %q", cmd.Process.Pid, name)
+	return nil, 0, nil
+}
+
+// newCreatedContainer returns a container in a new directory of dir whose
+// init stand-in waits on the exec fifo like an init process does, then
+// execs sleep once released.
+func newCreatedContainer(t *testing.T, dir string) (*linuxContainer, func()) {
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fifo := filepath.Join(root, execFifoFilename)
+	if err := unix.Mkfifo(fifo, 0622); err != nil {
+		t.Fatal(err)
+	}
+	cmd, started, kill := startNamedScript(t, dir, initWaitName, `echo 0 > "$1"; exec sleep 100`, fifo)
+	c := &linuxContainer{
+		id:                   "myid",
+		root:                 root,
+		config:               &configs.Config{},
+		cgroupManager:        &mockCgroupManager{},
+		initProcess:          &mockProcess{_pid: cmd.Process.Pid, started: started},
+		initProcessStartTime: started,
+	}
+	c.state = &createdState{c: c}
+	return c, kill
+}
+
+func TestExecOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-once")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, kill := newCreatedContainer(t, dir)
+	defer kill()
+	if status, err := c.Status(); err != nil || status != Created {
+		t.Fatalf("expected the container to be created, got %s, %v", status, err)
+	}
+
+	const starters = 20
+	errs := make(chan error, starters)
+	for i := 0; i < starters; i++ {
+		go func() {
+			errs <- c.Exec()
+		}()
+	}
+	started := 0
+	for i := 0; i < starters; i++ {
+		err := <-errs
+		if err == nil {
+			started++
+			continue
+		}
+		if lerr, ok := err.(Error); !ok || lerr.Code() != ContainerAlreadyRunning {
+			t.Errorf("expected the container to be already running, got %v", err)
+		}
+	}
+	if started != 1 {
+		t.Fatalf("expected the container to be started once, got %d", started)
+	}
+	if _, err := os.Stat(filepath.Join(c.root, execFifoFilename)); !os.IsNotExist(err) {
+		t.Fatalf("expected the exec fifo to be removed, got %v", err)
+	}
+	if status, err := c.Status(); err != nil || status != Running {
+		t.Fatalf("expected the container to be running, got %s, %v", status, err)
+	}
+}
+
+func TestRunTypeDrainedFifo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drained-fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, kill := newCreatedContainer(t, dir)
+	defer kill()
+	// Release the init as a start would, without removing the fifo.
+	fifo := filepath.Join(c.root, execFifoFilename)
+	if _, err := ioutil.ReadFile(fifo); err != nil {
+		t.Fatal(err)
+	}
+	pid := c.initProcess.pid()
+	for i := 0; i < 100; i++ {
+		if stat, err := system.Stat(pid); err == nil && stat.Name != initWaitName {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, err := c.Status(); err != nil || status != Running {
+		t.Fatalf("expected the container to be running, got %s, %v", status, err)
+	}
+	if _, err := os.Stat(fifo); !os.IsNotExist(err) {
+		t.Fatalf("expected the drained exec fifo to be removed, got %v", err)
+	}
+	err = c.Exec()
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ContainerAlreadyRunning {
+		t.Fatalf("expected the container to be already running, got %v", err)
+	}
+}
+
+func TestPostRestoreAbortsBeforeSavingState(t *testing.T) {
+	root, err := ioutil.TempDir("", "post-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := ioutil.WriteFile(filepath.Join(root, "checkpoint"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := &linuxContainer{id: "test", root: root, config: &configs.Config{}}
+	stopped := &stoppedState{c: c}
+	c.state = stopped
+	// A process which cannot be started.
+	pre := &Process{Args: []string{"true"}, Terminal: true, ConsoleSocket: os.Stdin}
+	resp := &criurpc.CriuResp{
+		Type: criurpc.CriuReqType_NOTIFY.Enum(),
+		Notify: &criurpc.CriuNotify{
+			Script: proto.String("post-restore"),
+			Pid:    proto.Int32(int32(os.Getpid())),
+		},
+	}
+	process := &Process{}
+	err = c.criuNotifications(resp, process, &CriuOpts{PreResumeProcess: pre, AbortOnPreResumeFailure: true}, nil, nil)
+	if err == nil {
+		t.Fatal("expected the restore to be aborted")
+	}
+	if _, err := os.Stat(filepath.Join(root, stateFilename)); !os.IsNotExist(err) {
+		t.Fatalf("expected no state to be saved, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "checkpoint")); err != nil {
+		t.Fatalf("expected the checkpoint marker to be kept: %v", err)
+	}
+	if c.state != stopped || c.initProcess != nil || process.ops != nil {
+		t.Fatalf("expected the container to be left stopped, got %T with init %v", c.state, c.initProcess)
+	}
+}
@@ -0,0 +1,200 @@
+// Package control implements the client side of the control protocol served
+// by Container.ServeControl, which lets unprivileged processes operate on a
+// container over a local socket without linking libcontainer.
+//
+// Requests and responses are JSON objects sent one after another over the
+// connection. Every request carries the protocol version, and responses
+// carry the id of the request they answer.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+)
+
+// Version is the version of the control protocol.
+const Version = 1
+
+// The methods of the control protocol.
+const (
+	MethodSignal = "signal"
+	MethodStats  = "stats"
+	MethodPause  = "pause"
+	MethodResume = "resume"
+	MethodStatus = "status"
+)
+
+// Request is a call of a method.
+type Request struct {
+	Version int             `json:"version"`
+	ID      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the result of the request with the same id.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// SignalParams are the parameters of the signal method.
+type SignalParams struct {
+	Signal int  `json:"signal"`
+	All    bool `json:"all"`
+}
+
+// Stats is the result of the stats method.
+type Stats struct {
+	Interfaces  []*NetworkInterface
+	CgroupStats *cgroups.Stats
+}
+
+// NetworkInterface holds the stats of a network interface of the container.
+type NetworkInterface struct {
+	Name string
+
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// Client is a connection to a control server. Calls are serialized, so a
+// Client can be shared between goroutines. Once a call was interrupted, or
+// failed to reach the server, every further call fails with its error.
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	enc    *json.Encoder
+	dec    *json.Decoder
+	nextID uint64
+	// err is the error which left the connection unusable, if any.
+	err error
+}
+
+// Dial connects to the control server listening on the unix socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client using conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(conn),
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Signal sends sig to the init process of the container, or to all of its
+// processes if all is true.
+func (c *Client) Signal(ctx context.Context, sig syscall.Signal, all bool) error {
+	return c.call(ctx, MethodSignal, SignalParams{Signal: int(sig), All: all}, nil)
+}
+
+// Stats returns the stats of the container.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.call(ctx, MethodStats, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Pause pauses the container.
+func (c *Client) Pause(ctx context.Context) error {
+	return c.call(ctx, MethodPause, nil, nil)
+}
+
+// Resume resumes the paused container.
+func (c *Client) Resume(ctx context.Context) error {
+	return c.call(ctx, MethodResume, nil, nil)
+}
+
+// Status returns the status of the container, e.g. "running".
+func (c *Client) Status(ctx context.Context) (string, error) {
+	var status string
+	if err := c.call(ctx, MethodStatus, nil, &status); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+func (c *Client) call(ctx context.Context, method string, params, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+
+	c.nextID++
+	req := Request{
+		Version: Version,
+		ID:      c.nextID,
+		Method:  method,
+	}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+
+	// Interrupt the exchange once ctx is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer c.conn.SetDeadline(time.Time{})
+
+	var resp Response
+	err := c.enc.Encode(req)
+	if err == nil {
+		err = c.dec.Decode(&resp)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		c.err = err
+		return err
+	}
+	if resp.ID != req.ID {
+		return errors.New("control: response does not match request")
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"syscall" // only for Signal
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/control"
+
+	"golang.org/x/sys/unix"
+)
+
+// ControlAuthorizer decides whether a peer may call a control method. peer
+// holds the credentials of the peer, or is nil if the listener is not a unix
+// socket. A non-nil error refuses the call and is returned to the peer.
+type ControlAuthorizer func(peer *unix.Ucred) error
+
+// AllowControl is a ControlAuthorizer allowing every peer.
+func AllowControl(peer *unix.Ucred) error {
+	return nil
+}
+
+// controlMethods maps the methods of the control protocol to the container
+// methods implementing them.
+var controlMethods = map[string]func(c *linuxContainer, params json.RawMessage) (interface{}, error){
+	control.MethodSignal: func(c *linuxContainer, params json.RawMessage) (interface{}, error) {
+		var p control.SignalParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, c.Signal(syscall.Signal(p.Signal), p.All)
+	},
+	control.MethodStats: func(c *linuxContainer, params json.RawMessage) (interface{}, error) {
+		return c.Stats()
+	},
+	control.MethodPause: func(c *linuxContainer, params json.RawMessage) (interface{}, error) {
+		return nil, c.Pause()
+	},
+	control.MethodResume: func(c *linuxContainer, params json.RawMessage) (interface{}, error) {
+		return nil, c.Resume()
+	},
+	control.MethodStatus: func(c *linuxContainer, params json.RawMessage) (interface{}, error) {
+		status, err := c.Status()
+		if err != nil {
+			return nil, err
+		}
+		return status.String(), nil
+	},
+}
+
+func (c *linuxContainer) ServeControl(ctx context.Context, listener net.Listener, authorizers map[string]ControlAuthorizer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.serveControlConn(ctx, conn, authorizers)
+		}()
+	}
+}
+
+// serveControlConn answers the requests of a single peer until it hangs up
+// or ctx is done.
+func (c *linuxContainer) serveControlConn(ctx context.Context, conn net.Conn, authorizers map[string]ControlAuthorizer) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		logrus.Warnf("cannot get control peer credentials: %v", err)
+		return
+	}
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req control.Request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp := control.Response{ID: req.ID}
+		result, err := c.handleControl(&req, peer, authorizers)
+		if err == nil && result != nil {
+			resp.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (c *linuxContainer) handleControl(req *control.Request, peer *unix.Ucred, authorizers map[string]ControlAuthorizer) (interface{}, error) {
+	if req.Version != control.Version {
+		return nil, fmt.Errorf("unsupported control protocol version %d", req.Version)
+	}
+	method, ok := controlMethods[req.Method]
+	if !ok {
+		return nil, fmt.Errorf("unknown control method %q", req.Method)
+	}
+	authorize := authorizers[req.Method]
+	if authorize == nil {
+		return nil, fmt.Errorf("control method %q is not allowed", req.Method)
+	}
+	if err := authorize(peer); err != nil {
+		return nil, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"id":     c.id,
+		"method": req.Method,
+	}).Debug("control request")
+	return method(c, req.Params)
+}
+
+// peerCredentials returns the credentials of the peer of conn, or nil if
+// conn is not a unix socket.
+func peerCredentials(conn net.Conn) (*unix.Ucred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, nil
+	}
+	f, err := uc.File()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	// Getting the descriptor puts the socket, which the duplicate shares its
+	// flags with, in blocking mode. Closing conn then no longer interrupts a
+	// read, so it is switched back.
+	defer unix.SetNonblock(fd, true)
+	return unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+}
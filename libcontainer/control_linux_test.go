@@ -0,0 +1,80 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/control"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestServeControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testservecontrol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "control.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := cgroups.NewStats()
+	stats.PidsStats.Current = 4
+	container := &linuxContainer{
+		id:            "myid",
+		config:        &configs.Config{},
+		cgroupManager: &mockCgroupManager{stats: stats},
+	}
+	var peer *unix.Ucred
+	authorizers := map[string]ControlAuthorizer{
+		control.MethodStats: func(p *unix.Ucred) error {
+			peer = p
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- container.ServeControl(ctx, l, authorizers)
+	}()
+
+	client, err := control.Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	got, err := client.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CgroupStats.PidsStats.Current != 4 {
+		t.Fatalf("expected 4 pids but received %d", got.CgroupStats.PidsStats.Current)
+	}
+	if peer == nil || int(peer.Pid) != os.Getpid() {
+		t.Fatalf("expected the peer to be this process, got %+v", peer)
+	}
+	if err := client.Pause(context.Background()); err == nil {
+		t.Fatal("expected pause to be refused without an authorizer")
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != context.Canceled {
+			t.Fatalf("expected ServeControl to be canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeControl did not return once canceled")
+	}
+}
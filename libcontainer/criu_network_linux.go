@@ -0,0 +1,264 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/criurpc"
+	"github.com/vishvananda/netlink"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	networkFilename = "network.json"
+	// externalNetnsKey is the key CRIU knows a network namespace left out of
+	// the images by.
+	externalNetnsKey = "extRootNetNS"
+	// criuNetnsFd is the descriptor of the network namespace to restore into
+	// in CRIU, right after the swrk socket.
+	criuNetnsFd = 4
+)
+
+// dumpedNetwork records the interfaces of the config dumped by a checkpoint.
+type dumpedNetwork struct {
+	Interfaces []string `json:"interfaces"`
+	// ExternalNetns is set if the network namespace, along with its
+	// interfaces, was left out of the images.
+	ExternalNetns bool `json:"external_netns,omitempty"`
+}
+
+// dumpNetwork writes the dumpedNetwork of a checkpoint into its images
+// directory. A network namespace the container joined is left out of the
+// images, if CRIU supports it, so that it can be restored into another one.
+func (c *linuxContainer) dumpNetwork(req *criurpc.CriuReq, criuOpts *CriuOpts) error {
+	var dumped dumpedNetwork
+	if criuOpts.EmptyNs&unix.CLONE_NEWNET == 0 {
+		for _, n := range c.config.Networks {
+			if n.Type == "veth" {
+				dumped.Interfaces = append(dumped.Interfaces, n.Name)
+			}
+		}
+		if path := c.config.Namespaces.PathOf(configs.NEWNET); path != "" && c.checkCriuVersion("3.11") == nil {
+			var st unix.Stat_t
+			if err := unix.Stat(path, &st); err != nil {
+				return err
+			}
+			req.Opts.External = append(req.Opts.External, fmt.Sprintf("net[%d]:%s", st.Ino, externalNetnsKey))
+			dumped.ExternalNetns = true
+		}
+	}
+	data, err := json.Marshal(dumped)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(criuOpts.ImagesDirectory, networkFilename), data, 0600)
+}
+
+// readDumpedNetwork reads the dumpedNetwork of the checkpoint in dir. For
+// one taken before it was recorded, the veth interfaces of config are
+// assumed to have been dumped along with their namespace.
+func readDumpedNetwork(dir string, config *configs.Config) (*dumpedNetwork, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, networkFilename))
+	if os.IsNotExist(err) {
+		dumped := &dumpedNetwork{}
+		for _, n := range config.Networks {
+			if n.Type == "veth" {
+				dumped.Interfaces = append(dumped.Interfaces, n.Name)
+			}
+		}
+		return dumped, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dumped dumpedNetwork
+	if err := json.Unmarshal(data, &dumped); err != nil {
+		return nil, err
+	}
+	return &dumped, nil
+}
+
+// checkNetworkRemap returns the network namespace remap restores into, if
+// any, or an error unless remap maps exactly the dumped interfaces.
+func checkNetworkRemap(dumped *dumpedNetwork, remap map[string]NetworkRemap) (string, error) {
+	var unmapped, unknown []string
+	seen := make(map[string]bool)
+	for _, name := range dumped.Interfaces {
+		seen[name] = true
+		if _, ok := remap[name]; !ok {
+			unmapped = append(unmapped, name)
+		}
+	}
+	for name := range remap {
+		if !seen[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unmapped) > 0 {
+		return "", newGenericError(fmt.Errorf("no network remap for the dumped interfaces: %s", strings.Join(unmapped, ", ")), ConfigInvalid)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", newGenericError(fmt.Errorf("network remap for interfaces not in the checkpoint: %s", strings.Join(unknown, ", ")), ConfigInvalid)
+	}
+	netns := ""
+	for i, name := range dumped.Interfaces {
+		r := remap[name]
+		if i > 0 && r.Netns != netns {
+			return "", newGenericError(fmt.Errorf("interfaces %s and %s are remapped to different network namespaces", dumped.Interfaces[0], name), ConfigInvalid)
+		}
+		netns = r.Netns
+	}
+	if netns != "" && !dumped.ExternalNetns {
+		return "", newGenericError(fmt.Errorf("the network namespace was dumped with the container and cannot be restored into %s", netns), ConfigInvalid)
+	}
+	return netns, nil
+}
+
+// remappedNetworks returns copies of networks with the host ends of the
+// veth pairs renamed as remap says.
+func remappedNetworks(networks []*configs.Network, remap map[string]NetworkRemap) []*configs.Network {
+	var remapped []*configs.Network
+	for _, n := range networks {
+		dup := *n
+		if r, ok := remap[n.Name]; ok && n.Type == "veth" && r.Peer != "" {
+			dup.HostInterfaceName = r.Peer
+		}
+		remapped = append(remapped, &dup)
+	}
+	return remapped
+}
+
+// remapNetwork applies the NetworkRemap of criuOpts to a restore, returning
+// the networks of the container as restored. If the network namespace was
+// left out of the images, it is returned, to be passed on to CRIU as
+// criuNetnsFd: without a remap it is the namespace the container joined,
+// whose interfaces are still there, otherwise the one it is remapped into,
+// where the interfaces are recreated.
+func (c *linuxContainer) remapNetwork(req *criurpc.CriuReq, criuOpts *CriuOpts) ([]*configs.Network, *os.File, error) {
+	dumped, err := readDumpedNetwork(criuOpts.ImagesDirectory, c.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(criuOpts.NetworkRemap) == 0 {
+		if !dumped.ExternalNetns {
+			return c.config.Networks, nil, nil
+		}
+		path := c.config.Namespaces.PathOf(configs.NEWNET)
+		if path == "" {
+			return nil, nil, newGenericError(fmt.Errorf("the network namespace was left out of the checkpoint and the container has none to join"), ConfigInvalid)
+		}
+		netns, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		inheritNetns(req)
+		return c.config.Networks, netns, nil
+	}
+	path, err := checkNetworkRemap(dumped, criuOpts.NetworkRemap)
+	if err != nil {
+		return nil, nil, err
+	}
+	networks := remappedNetworks(c.config.Networks, criuOpts.NetworkRemap)
+	if path == "" {
+		return networks, nil, nil
+	}
+	netns, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := recreateNetworks(networks, netns); err != nil {
+		netns.Close()
+		return nil, nil, newSystemErrorWithCausef(err, "recreating the interfaces in %s", path)
+	}
+	inheritNetns(req)
+	return networks, netns, nil
+}
+
+// inheritNetns has CRIU restore into criuNetnsFd the network namespace left
+// out of the images.
+func inheritNetns(req *criurpc.CriuReq) {
+	req.Opts.InheritFd = append(req.Opts.InheritFd, &criurpc.InheritFd{
+		Key: proto.String(externalNetnsKey),
+		Fd:  proto.Int32(criuNetnsFd),
+	})
+}
+
+// recreateNetworks creates the networks in netns and initializes them, as a
+// new container would, since CRIU does not restore the interfaces of a
+// network namespace left out of the images.
+func recreateNetworks(networks []*configs.Network, netns *os.File) (err error) {
+	var created []*network
+	defer func() {
+		if err != nil {
+			for _, n := range created {
+				netlink.LinkDel(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: n.HostInterfaceName}})
+			}
+		}
+	}()
+	var recreated []*network
+	for _, config := range networks {
+		n := &network{Network: *config}
+		if n.Type == "veth" {
+			if err := (&veth{}).createIn(n, func(child netlink.Link) error {
+				return netlink.LinkSetNsFd(child, int(netns.Fd()))
+			}); err != nil {
+				return err
+			}
+			created = append(created, n)
+		}
+		recreated = append(recreated, n)
+	}
+	return inNetns(netns, func() error {
+		for _, n := range recreated {
+			strategy, err := getStrategy(n.Type)
+			if err != nil {
+				return err
+			}
+			if err := strategy.initialize(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inNetns runs fn on a thread which has joined netns. It runs on a goroutine
+// of its own, locked to its thread: if the thread cannot join back its own
+// namespace, the goroutine exits still locked, and the runtime terminates the
+// thread rather than handing it to another goroutine.
+func inNetns(netns *os.File, fn func() error) error {
+	errC := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		self, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			errC <- err
+			return
+		}
+		defer self.Close()
+		if err := unix.Setns(int(netns.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errC <- err
+			return
+		}
+		err = fn()
+		if unix.Setns(int(self.Fd()), unix.CLONE_NEWNET) == nil {
+			runtime.UnlockOSThread()
+		}
+		errC <- err
+	}()
+	return <-errC
+}
@@ -0,0 +1,196 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/criurpc"
+
+	"golang.org/x/sys/unix"
+)
+
+// threadNetns returns the network namespace of the calling thread.
+func threadNetns() (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+}
+
+func TestInNetns(t *testing.T) {
+	// The thread which unshares the namespace is not given back.
+	var (
+		netns *os.File
+		want  string
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			return
+		}
+		netns, _ = os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		want, _ = threadNetns()
+	}()
+	<-done
+	if netns == nil || want == "" {
+		t.Skip("cannot create a network namespace")
+	}
+	defer netns.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	before, err := threadNetns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var joined string
+	if err := inNetns(netns, func() (err error) {
+		joined, err = threadNetns()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if joined != want {
+		t.Fatalf("expected fn to run in %s, got %s", want, joined)
+	}
+	if after, err := threadNetns(); err != nil || after != before {
+		t.Fatalf("expected the calling thread to stay in %s, got %s (%v)", before, after, err)
+	}
+}
+
+func TestCheckNetworkRemap(t *testing.T) {
+	dumped := &dumpedNetwork{Interfaces: []string{"eth0", "eth1"}}
+	remap := map[string]NetworkRemap{"eth0": {Peer: "vethA"}}
+	_, err := checkNetworkRemap(dumped, remap)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid || !strings.Contains(err.Error(), "eth1") {
+		t.Fatalf("expected eth1 to be reported as unmapped, got %v", err)
+	}
+
+	remap["eth1"] = NetworkRemap{Peer: "vethB"}
+	remap["eth2"] = NetworkRemap{Peer: "vethC"}
+	if _, err := checkNetworkRemap(dumped, remap); err == nil || !strings.Contains(err.Error(), "eth2") {
+		t.Fatalf("expected eth2 to be reported as not in the checkpoint, got %v", err)
+	}
+
+	delete(remap, "eth2")
+	netns, err := checkNetworkRemap(dumped, remap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netns != "" {
+		t.Fatalf("expected no network namespace, got %q", netns)
+	}
+
+	remap["eth0"] = NetworkRemap{Netns: "/run/netns/pod", Peer: "vethA"}
+	if _, err := checkNetworkRemap(dumped, remap); err == nil {
+		t.Fatal("expected interfaces remapped to different namespaces to be rejected")
+	}
+	remap["eth1"] = NetworkRemap{Netns: "/run/netns/pod", Peer: "vethB"}
+	if _, err := checkNetworkRemap(dumped, remap); err == nil {
+		t.Fatal("expected a namespace dumped with the container to be rejected")
+	}
+	dumped.ExternalNetns = true
+	if netns, err = checkNetworkRemap(dumped, remap); err != nil {
+		t.Fatal(err)
+	}
+	if netns != "/run/netns/pod" {
+		t.Fatalf("expected /run/netns/pod, got %q", netns)
+	}
+}
+
+func TestRemapNetworkWithoutRemap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &linuxContainer{config: &configs.Config{
+		Namespaces: configs.Namespaces{{Type: configs.NEWNET, Path: "/proc/self/ns/net"}},
+		Networks:   []*configs.Network{{Type: "veth", Name: "eth0", HostInterfaceName: "veth0"}},
+	}}
+	criuOpts := &CriuOpts{ImagesDirectory: dir}
+
+	for _, external := range []bool{false, true} {
+		data, err := json.Marshal(dumpedNetwork{Interfaces: []string{"eth0"}, ExternalNetns: external})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, networkFilename), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		req := &criurpc.CriuReq{Opts: &criurpc.CriuOpts{}}
+		networks, netns, err := c.remapNetwork(req, criuOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(networks) != 1 || networks[0].HostInterfaceName != "veth0" {
+			t.Fatalf("expected the networks to be kept, got %+v", networks)
+		}
+		if !external {
+			if netns != nil || len(req.Opts.InheritFd) != 0 {
+				t.Fatal("expected a namespace dumped with the container to be restored by CRIU")
+			}
+			continue
+		}
+		if netns == nil {
+			t.Fatal("expected the namespace the container joined to be passed on")
+		}
+		netns.Close()
+		if len(req.Opts.InheritFd) != 1 || req.Opts.InheritFd[0].GetKey() != externalNetnsKey || req.Opts.InheritFd[0].GetFd() != criuNetnsFd {
+			t.Fatalf("expected the namespace to be inherited as %s, got %v", externalNetnsKey, req.Opts.InheritFd)
+		}
+	}
+
+	c.config.Namespaces = configs.Namespaces{{Type: configs.NEWNET}}
+	if _, _, err := c.remapNetwork(&criurpc.CriuReq{Opts: &criurpc.CriuOpts{}}, criuOpts); err == nil {
+		t.Fatal("expected a container without a namespace to join to be refused")
+	}
+}
+
+func TestReadDumpedNetworkWithoutRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := &configs.Config{Networks: []*configs.Network{
+		{Type: "loopback"},
+		{Type: "veth", Name: "eth0"},
+	}}
+	dumped, err := readDumpedNetwork(dir, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped.Interfaces) != 1 || dumped.Interfaces[0] != "eth0" || dumped.ExternalNetns {
+		t.Fatalf("expected eth0 dumped with its namespace, got %+v", dumped)
+	}
+}
+
+func TestRemappedNetworks(t *testing.T) {
+	networks := []*configs.Network{
+		{Type: "loopback"},
+		{Type: "veth", Name: "eth0", HostInterfaceName: "veth0"},
+		{Type: "veth", Name: "eth1", HostInterfaceName: "veth1"},
+	}
+	remapped := remappedNetworks(networks, map[string]NetworkRemap{
+		"eth0": {Peer: "vethXYZ"},
+		"eth1": {Netns: "/run/netns/pod"},
+	})
+	if remapped[1].HostInterfaceName != "vethXYZ" {
+		t.Fatalf("expected eth0 to be renamed, got %q", remapped[1].HostInterfaceName)
+	}
+	if remapped[2].HostInterfaceName != "veth1" {
+		t.Fatalf("expected eth1 to keep its host name, got %q", remapped[2].HostInterfaceName)
+	}
+	if networks[1].HostInterfaceName != "veth0" {
+		t.Fatal("expected the networks of the config to be left alone")
+	}
+}
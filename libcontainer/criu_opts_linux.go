@@ -1,5 +1,10 @@
 package libcontainer
 
+import (
+	"os"
+	"time"
+)
+
 // cgroup restoring strategy provided by criu
 type cgMode uint32
 
@@ -11,8 +16,24 @@ const (
 )
 
 type CriuPageServerInfo struct {
-	Address string // IP address of CRIU page server
-	Port    int32  // port number of CRIU page server
+	Address string   // IP address of CRIU page server
+	Port    int32    // port number of CRIU page server
+	Conn    *os.File // socket connected to a CRIU page server, whose address is used instead of Address and Port
+}
+
+// TmpfsSnapshot selects a bind mount of a tmpfs directory, e.g. /run, whose
+// contents are saved in the images of a checkpoint and put on restore into a
+// fresh tmpfs, which is mounted there instead of the source of the mount.
+// CRIU leaves such external mounts alone.
+type TmpfsSnapshot struct {
+	// Destination is where the directory is mounted in the container.
+	Destination string
+	// MaxSize is the largest size the saved files may add up to, beyond
+	// which the checkpoint fails, or zero for no limit.
+	MaxSize int64
+	// Exclude lists filepath.Match patterns of the paths, relative to the
+	// directory, which are left out of the snapshot.
+	Exclude []string
 }
 
 type VethPairName struct {
@@ -20,6 +41,18 @@ type VethPairName struct {
 	HostInterfaceName      string
 }
 
+// NetworkRemap tells where an interface of a checkpoint goes on restore.
+type NetworkRemap struct {
+	// Netns is the path of an existing network namespace to restore the
+	// container into. The interfaces are then recreated there by
+	// libcontainer, which requires the container to have joined an existing
+	// network namespace, one CRIU leaves out of the images, when dumped.
+	Netns string `json:"netns,omitempty"`
+	// Peer is the new name of the host end of the veth pair of the
+	// interface, or empty to keep the one of the config.
+	Peer string `json:"peer,omitempty"`
+}
+
 type CriuOpts struct {
 	ImagesDirectory         string             // directory for storing image files
 	WorkDirectory           string             // directory to cd and write logs/pidfiles/stats to
@@ -34,4 +67,25 @@ type CriuOpts struct {
 	VethPairs               []VethPairName     // pass the veth to criu when restore
 	ManageCgroupsMode       cgMode             // dump or restore cgroup mode
 	EmptyNs                 uint32             // don't c/r properties for namespace from this mask
+
+	// PreResumeProcess is started in the restored container once CRIU has
+	// restored it but before its tasks are resumed, e.g. to have a debugging
+	// agent in place the moment the workload continues.
+	PreResumeProcess *Process
+	// AbortOnPreResumeFailure makes the restore fail, without resuming the
+	// restored tasks, if PreResumeProcess cannot be started. Otherwise the
+	// failure is only logged. The images are left intact either way.
+	AbortOnPreResumeFailure bool
+	// FreezeTimeout makes the checkpoint freeze the freezer cgroup of the
+	// container itself before handing it to CRIU, giving up and thawing it
+	// again if that takes longer. Zero leaves freezing to CRIU.
+	FreezeTimeout time.Duration
+	// TmpfsSnapshots lists the tmpfs bind mounts of the container whose
+	// contents are saved by a dump, while the container is frozen, and
+	// restored before CRIU restores the container.
+	TmpfsSnapshots []TmpfsSnapshot
+	// NetworkRemap maps the names of the interfaces of the config, as
+	// dumped, to where they are restored. Every dumped interface must be
+	// mapped, or the restore fails before CRIU is run.
+	NetworkRemap map[string]NetworkRemap
 }
@@ -0,0 +1,192 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/proto"
+	"github.com/opencontainers/runc/libcontainer/criurpc"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+// pageServerPidFile is the file of the state directory where the pid and the
+// start time of the last page server started for the container are written,
+// for a Restore run by another process to wait for it.
+const pageServerPidFile = "page-server.pid"
+
+// pageServerDialTimeout is how long a checkpoint waits for the page server
+// to accept the connection.
+const pageServerDialTimeout = 10 * time.Second
+
+// PageServer is a CRIU page server receiving the memory pages of a
+// checkpoint streamed from another host into a local images directory.
+type PageServer struct {
+	// Port is the port the page server listens on.
+	Port int32
+
+	pid       int
+	startTime uint64
+}
+
+// Wait blocks until the page server has received a complete dump, or a
+// complete pre-dump, and exited.
+func (p *PageServer) Wait() {
+	if err := waitPidfd(p.pid, p.startTime); err != nil {
+		for processAlive(p.pid, p.startTime) {
+			time.Sleep(stopPollInterval)
+		}
+	}
+}
+
+// Stop kills the page server, e.g. when the migration was aborted.
+func (p *PageServer) Stop() error {
+	if !processAlive(p.pid, p.startTime) {
+		return nil
+	}
+	return unix.Kill(p.pid, unix.SIGKILL)
+}
+
+// StartPageServer starts a CRIU page server receiving the pages of a
+// checkpoint into criuOpts.ImagesDirectory. It listens on the address and
+// port of criuOpts.PageServer, an empty address meaning all addresses and
+// a zero port any free port.
+//
+// Every dump or pre-dump needs its own page server, as it exits once the
+// dump is complete. Only the pages are streamed: the other images are still
+// written to the images directory of the checkpoint and have to be copied
+// over. A Restore of the container, even by another process, waits for the
+// last page server started for it to exit before restoring.
+func (c *linuxContainer) StartPageServer(criuOpts *CriuOpts) (*PageServer, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.config.Rootless {
+		return nil, fmt.Errorf("cannot receive a checkpoint for a rootless container")
+	}
+	if err := c.checkCriuVersion("1.5.2"); err != nil {
+		return nil, err
+	}
+	if criuOpts.ImagesDirectory == "" {
+		return nil, fmt.Errorf("invalid directory to receive checkpoint")
+	}
+	if err := os.MkdirAll(criuOpts.ImagesDirectory, 0755); err != nil {
+		return nil, err
+	}
+	if criuOpts.WorkDirectory == "" {
+		criuOpts.WorkDirectory = filepath.Join(c.root, "criu.work")
+	}
+	if err := os.Mkdir(criuOpts.WorkDirectory, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	workDir, err := os.Open(criuOpts.WorkDirectory)
+	if err != nil {
+		return nil, err
+	}
+	defer workDir.Close()
+	imageDir, err := os.Open(criuOpts.ImagesDirectory)
+	if err != nil {
+		return nil, err
+	}
+	defer imageDir.Close()
+
+	t := criurpc.CriuReqType_PAGE_SERVER
+	req := &criurpc.CriuReq{
+		Type: &t,
+		Opts: &criurpc.CriuOpts{
+			ImagesDirFd: proto.Int32(int32(imageDir.Fd())),
+			WorkDirFd:   proto.Int32(int32(workDir.Fd())),
+			LogLevel:    proto.Int32(4),
+			LogFile:     proto.String("page-server.log"),
+			Ps: &criurpc.CriuPageServerInfo{
+				Port: proto.Int32(criuOpts.PageServer.Port),
+			},
+		},
+	}
+	if criuOpts.PageServer.Address != "" {
+		req.Opts.Ps.Address = proto.String(criuOpts.PageServer.Address)
+	}
+	c.pageServer = nil
+	if err := c.criuSwrk(nil, req, criuOpts, false); err != nil {
+		return nil, err
+	}
+	if c.pageServer == nil {
+		return nil, fmt.Errorf("criu did not report the page server")
+	}
+	stat, err := system.Stat(c.pageServer.pid)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "getting page server start time")
+	}
+	c.pageServer.startTime = stat.StartTime
+	data := fmt.Sprintf("%d\n%d\n", c.pageServer.pid, c.pageServer.startTime)
+	if err := ioutil.WriteFile(filepath.Join(c.root, pageServerPidFile), []byte(data), 0600); err != nil {
+		c.pageServer.Stop()
+		return nil, err
+	}
+	return c.pageServer, nil
+}
+
+// lastPageServer returns the last page server started for the container,
+// by this process or another one, if any.
+func (c *linuxContainer) lastPageServer() *PageServer {
+	if c.pageServer != nil {
+		return c.pageServer
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.root, pageServerPidFile))
+	if err != nil {
+		return nil
+	}
+	ps := &PageServer{}
+	if _, err := fmt.Sscan(string(data), &ps.pid, &ps.startTime); err != nil {
+		logrus.Warnf("invalid page server pid file: %v", err)
+		return nil
+	}
+	return ps
+}
+
+// forgetPageServer forgets the last page server started for the container.
+func (c *linuxContainer) forgetPageServer() {
+	c.pageServer = nil
+	if err := os.Remove(filepath.Join(c.root, pageServerPidFile)); err != nil && !os.IsNotExist(err) {
+		logrus.Warn(err)
+	}
+}
+
+// checkPageServer returns the page server of ps for CRIU to connect to. The
+// page server of Conn is the peer it is connected to, otherwise it is
+// dialed, so that an unreachable page server fails the checkpoint early
+// instead of leaving a partial images directory behind.
+func checkPageServer(ps CriuPageServerInfo) (*criurpc.CriuPageServerInfo, error) {
+	if ps.Conn != nil {
+		conn, err := net.FileConn(ps.Conn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page server connection: %v", err)
+		}
+		defer conn.Close()
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if !ok {
+			return nil, fmt.Errorf("page server connection is not a TCP connection")
+		}
+		ps.Address, ps.Port = addr.IP.String(), int32(addr.Port)
+	} else {
+		addr := net.JoinHostPort(ps.Address, strconv.Itoa(int(ps.Port)))
+		conn, err := net.DialTimeout("tcp", addr, pageServerDialTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to page server: %v", err)
+		}
+		conn.Close()
+	}
+	return &criurpc.CriuPageServerInfo{
+		Address: proto.String(ps.Address),
+		Port:    proto.Int32(ps.Port),
+	}, nil
+}
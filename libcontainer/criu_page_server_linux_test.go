@@ -0,0 +1,113 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+func TestCheckPageServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := int32(l.Addr().(*net.TCPAddr).Port)
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	ps, err := checkPageServer(CriuPageServerInfo{Address: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.GetAddress() != "127.0.0.1" || ps.GetPort() != port || ps.Fd != nil {
+		t.Fatalf("expected CRIU to connect to 127.0.0.1:%d itself, got %v", port, ps)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	// The page server of a connection is its peer.
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+		}
+		accepted <- err
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	f, err := conn.(*net.TCPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if ps, err = checkPageServer(CriuPageServerInfo{Conn: f}); err != nil {
+		t.Fatal(err)
+	}
+	if ps.GetAddress() != "127.0.0.1" || ps.GetPort() != port {
+		t.Fatalf("expected the peer 127.0.0.1:%d, got %v", port, ps)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on the port any more.
+	l.Close()
+	if _, err := checkPageServer(CriuPageServerInfo{Address: "127.0.0.1", Port: port}); err == nil {
+		t.Fatal("expected checking a closed page server to fail")
+	}
+}
+
+func TestLastPageServerFromPidFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "pageserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	c := &linuxContainer{root: root}
+	if ps := c.lastPageServer(); ps != nil {
+		t.Fatalf("expected no page server, got %+v", ps)
+	}
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	stat, err := system.Stat(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As written by StartPageServer in another process.
+	data := []byte(fmt.Sprintf("%d\n%d\n", cmd.Process.Pid, stat.StartTime))
+	if err := ioutil.WriteFile(filepath.Join(root, pageServerPidFile), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ps := c.lastPageServer()
+	if ps == nil || ps.pid != cmd.Process.Pid || ps.startTime != stat.StartTime {
+		t.Fatalf("expected the page server %d, got %+v", cmd.Process.Pid, ps)
+	}
+	c.forgetPageServer()
+	if ps := c.lastPageServer(); ps != nil {
+		t.Fatalf("expected the page server to be forgotten, got %+v", ps)
+	}
+}
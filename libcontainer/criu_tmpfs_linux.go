@@ -0,0 +1,303 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tmpfsSnapshotsFilename = "tmpfs-snapshots.json"
+	// tmpfsMagic is the f_type statfs(2) reports for a tmpfs.
+	tmpfsMagic = 0x01021994
+)
+
+// tmpfsSnapshotImage records which image file holds the snapshot of the
+// tmpfs mounted at Destination.
+type tmpfsSnapshotImage struct {
+	Destination string `json:"destination"`
+	File        string `json:"file"`
+}
+
+// bindMount returns the bind mount of the container at dest, if any.
+func (c *linuxContainer) bindMount(dest string) *configs.Mount {
+	for _, m := range c.config.Mounts {
+		if m.Device == "bind" && filepath.Clean(m.Destination) == filepath.Clean(dest) {
+			return m
+		}
+	}
+	return nil
+}
+
+// snapshotTmpfsMounts saves the TmpfsSnapshots of criuOpts into its images
+// directory. The directories are read through the root of the init process,
+// so that they are seen as mounted in the container.
+func (c *linuxContainer) snapshotTmpfsMounts(criuOpts *CriuOpts) error {
+	var images []tmpfsSnapshotImage
+	for i, s := range criuOpts.TmpfsSnapshots {
+		if c.bindMount(s.Destination) == nil {
+			return newGenericError(fmt.Errorf("no bind mount at %s to snapshot", s.Destination), ConfigInvalid)
+		}
+		// Absolute symlinks below the root of the init process would be
+		// resolved against the host, so none are followed.
+		root := fmt.Sprintf("/proc/%d/root", c.initProcess.pid())
+		if err := checkNoSymlinks(root, filepath.Clean("/" + s.Destination)[1:]); err != nil {
+			return fmt.Errorf("%s is not a directory in the container: %v", s.Destination, err)
+		}
+		dir := filepath.Join(root, s.Destination)
+		var st unix.Statfs_t
+		if err := unix.Statfs(dir, &st); err != nil {
+			return err
+		}
+		if st.Type != tmpfsMagic {
+			return fmt.Errorf("%s is not a tmpfs in the container", s.Destination)
+		}
+		image := tmpfsSnapshotImage{Destination: s.Destination, File: fmt.Sprintf("tmpfs-%d.tar", i)}
+		f, err := os.Create(filepath.Join(criuOpts.ImagesDirectory, image.File))
+		if err != nil {
+			return err
+		}
+		err = snapshotTmpfs(dir, s, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("taking a snapshot of %s: %v", s.Destination, err)
+		}
+		images = append(images, image)
+	}
+	data, err := json.Marshal(images)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(criuOpts.ImagesDirectory, tmpfsSnapshotsFilename), data, 0644)
+}
+
+// restoreTmpfsMounts mounts a fresh tmpfs for each snapshot found in the
+// images directory of criuOpts and puts the snapshot into it, before CRIU
+// reopens the files of the restored processes. The sources of the bind
+// mounts are left alone: it returns the tmpfs directories by the
+// destination of their bind mount, for CRIU to mount instead, along with a
+// function unmounting them from the host once CRIU is done.
+func (c *linuxContainer) restoreTmpfsMounts(criuOpts *CriuOpts) (map[string]string, func(), error) {
+	data, err := ioutil.ReadFile(filepath.Join(criuOpts.ImagesDirectory, tmpfsSnapshotsFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, func() {}, nil
+		}
+		return nil, nil, err
+	}
+	var images []tmpfsSnapshotImage
+	if err := json.Unmarshal(data, &images); err != nil {
+		return nil, nil, err
+	}
+	staging := filepath.Join(c.root, "criu-tmpfs")
+	dirs := make(map[string]string)
+	cleanup := func() {
+		for _, dir := range dirs {
+			unix.Unmount(dir, unix.MNT_DETACH)
+			os.Remove(dir)
+		}
+		os.Remove(staging)
+	}
+	if err := os.Mkdir(staging, 0700); err != nil && !os.IsExist(err) {
+		return nil, nil, err
+	}
+	for i, image := range images {
+		if c.bindMount(image.Destination) == nil {
+			cleanup()
+			return nil, nil, newGenericError(fmt.Errorf("no bind mount at %s to restore its snapshot into", image.Destination), ConfigInvalid)
+		}
+		dir := filepath.Join(staging, strconv.Itoa(i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if err := unix.Mount("tmpfs", dir, "tmpfs", 0, "mode=755"); err != nil {
+			os.Remove(dir)
+			cleanup()
+			return nil, nil, err
+		}
+		dirs[filepath.Clean(image.Destination)] = dir
+		f, err := os.Open(filepath.Join(criuOpts.ImagesDirectory, filepath.Base(image.File)))
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		err = restoreTmpfs(f, dir)
+		f.Close()
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("restoring the snapshot of %s: %v", image.Destination, err)
+		}
+	}
+	return dirs, cleanup, nil
+}
+
+// snapshotTmpfs writes the contents of dir to w as a tar archive, leaving
+// out what s excludes and failing if the files exceed its MaxSize. Sockets
+// are left out too, as CRIU recreates the ones which are bound.
+func snapshotTmpfs(dir string, s TmpfsSnapshot, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	var size int64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		for _, pattern := range s.Exclude {
+			if ok, _ := filepath.Match(pattern, rel); ok {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+			if s.MaxSize > 0 && size > s.MaxSize {
+				return fmt.Errorf("files exceed the maximum size of %d bytes", s.MaxSize)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, fi.Size())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// restoreTmpfs extracts the tar archive read from r into dir. Entries which
+// would end up outside of dir, including through a symlink, are refused, and
+// so are those whose path is taken by a file of another type.
+func restoreTmpfs(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid entry %q", hdr.Name)
+		}
+		if err := checkNoSymlinks(dir, filepath.Dir(name)); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, name)
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(path, mode.Perm()); err != nil && !os.IsExist(err) {
+				return err
+			}
+			// An existing path must be a directory itself, not a symlink
+			// to one, as its mode and times are set below.
+			if fi, err := os.Lstat(path); err != nil {
+				return err
+			} else if !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory", path)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|unix.O_NOFOLLOW, mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(path)
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeFifo:
+			os.Remove(path)
+			if err := unix.Mkfifo(path, uint32(mode.Perm())); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			if err := os.Chmod(path, mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+				return err
+			}
+		}
+		ts := []unix.Timespec{unix.NsecToTimespec(hdr.ModTime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return err
+		}
+	}
+}
+
+// checkNoSymlinks returns an error if any component of rel, below dir, is
+// not a directory.
+func checkNoSymlinks(dir, rel string) error {
+	path := dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if name == "." || name == "" {
+			continue
+		}
+		path = filepath.Join(path, name)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTmpfsSnapshotRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "tmpfs-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "app", "cache"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "app", "pid"), []byte("42\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "app", "cache", "blob"), []byte("skip me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("app/pid", filepath.Join(src, "pid")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	s := TmpfsSnapshot{Exclude: []string{"app/cache"}}
+	if err := snapshotTmpfs(src, s, &buf); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := ioutil.TempDir("", "tmpfs-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if err := restoreTmpfs(&buf, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "pid"))
+	if err != nil || string(data) != "42\n" {
+		t.Fatalf("expected the pid file through the symlink, got %q: %v", data, err)
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "app", "pid")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected the mode of the pid file to be kept, got %v: %v", fi, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "app", "cache")); !os.IsNotExist(err) {
+		t.Fatalf("expected the excluded directory to be left out, got %v", err)
+	}
+}
+
+func TestTmpfsSnapshotMaxSize(t *testing.T) {
+	src, err := ioutil.TempDir("", "tmpfs-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := ioutil.WriteFile(filepath.Join(src, "big"), make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshotTmpfs(src, TmpfsSnapshot{MaxSize: 1024}, ioutil.Discard); err == nil {
+		t.Fatal("expected the snapshot to exceed its maximum size")
+	}
+	if err := snapshotTmpfs(src, TmpfsSnapshot{MaxSize: 1024, Exclude: []string{"big"}}, ioutil.Discard); err != nil {
+		t.Fatalf("expected excluded files not to count: %v", err)
+	}
+}
+
+func TestRestoreTmpfsRejectsEscape(t *testing.T) {
+	dst, err := ioutil.TempDir("", "tmpfs-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	outside, err := ioutil.TempDir("", "tmpfs-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	for _, entries := range [][]*tar.Header{
+		{{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644}},
+		{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp", Mode: 0777},
+			{Name: "link/escape", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		// The mode of a directory is not set through a symlink taking its
+		// path.
+		{
+			{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+			{Name: "dir", Typeflag: tar.TypeDir, Mode: 0777},
+		},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range entries {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		if err := restoreTmpfs(&buf, dst); err == nil {
+			t.Fatalf("expected %q to be rejected", entries[len(entries)-1].Name)
+		}
+	}
+	if fi, err := os.Stat(outside); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected the mode of %s to be left alone, got %v: %v", outside, fi, err)
+	}
+}
@@ -0,0 +1,69 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/devices"
+)
+
+// applyDefaultDevices adds the default devices to config if it sets
+// UseDefaultDevices: the nodes to create in the rootfs, and the rules to
+// allow them along with every major the pts driver uses on the host, which
+// the console of the container is a slave of. The devices and rules of
+// config win over defaults they conflict with.
+func applyDefaultDevices(config *configs.Config) error {
+	if !config.UseDefaultDevices {
+		return nil
+	}
+	config.Devices = configs.MergeDefaultNodes(config.Devices, configs.DefaultAutoCreatedDevices)
+	// Rootless containers cannot change their cgroups.
+	if config.Rootless || config.Cgroups == nil {
+		return nil
+	}
+	if config.Cgroups.Resources == nil {
+		config.Cgroups.Resources = &configs.Resources{}
+	}
+	allowed, err := defaultAllowedDevices()
+	if err != nil {
+		return err
+	}
+	r := config.Cgroups.Resources
+	switch {
+	case len(r.Devices) > 0:
+		// The rules are written in order, with Allow telling allow from deny.
+		for _, d := range allowed {
+			d.Allow = true
+		}
+		r.Devices = configs.MergeDefaultRules(r.Devices, allowed)
+	case r.AllowAllDevices == nil || !*r.AllowAllDevices:
+		allowAll := false
+		r.AllowAllDevices = &allowAll
+		r.AllowedDevices = configs.MergeDefaultRules(r.AllowedDevices, allowed)
+	}
+	return nil
+}
+
+// defaultAllowedDevices returns copies of DefaultAllowedDevices along with
+// rules for the majors of the pts driver not already covered, as they may be
+// allocated dynamically.
+func defaultAllowedDevices() ([]*configs.Device, error) {
+	var allowed []*configs.Device
+	for _, d := range configs.DefaultAllowedDevices {
+		dev := *d
+		allowed = append(allowed, &dev)
+	}
+	majors, err := devices.CharMajors("pts")
+	if err != nil {
+		return nil, err
+	}
+	for _, major := range majors {
+		allowed = configs.MergeDefaultRules(allowed, []*configs.Device{{
+			Type:        'c',
+			Major:       major,
+			Minor:       configs.Wildcard,
+			Permissions: "rwm",
+		}})
+	}
+	return allowed, nil
+}
@@ -0,0 +1,63 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestApplyDefaultDevices(t *testing.T) {
+	null := &configs.Device{Path: "/dev/null", Type: 'c', Major: 1, Minor: 3, FileMode: 0600}
+	config := &configs.Config{
+		UseDefaultDevices: true,
+		Devices:           []*configs.Device{null},
+		Cgroups:           &configs.Cgroup{Resources: &configs.Resources{}},
+	}
+	if err := applyDefaultDevices(config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Devices) != len(configs.DefaultAutoCreatedDevices) || config.Devices[0] != null {
+		t.Fatalf("expected the default nodes with the explicit /dev/null, got %v", config.Devices)
+	}
+	r := config.Cgroups.Resources
+	if r.AllowAllDevices == nil || *r.AllowAllDevices {
+		t.Fatal("expected all devices but the allowed ones to be denied")
+	}
+	if len(r.AllowedDevices) < len(configs.DefaultAllowedDevices) {
+		t.Fatalf("expected at least the default rules, got %d", len(r.AllowedDevices))
+	}
+	for _, d := range r.AllowedDevices {
+		for _, def := range configs.DefaultAllowedDevices {
+			if d == def {
+				t.Fatal("expected the default rules to be copied")
+			}
+		}
+	}
+}
+
+func TestApplyDefaultDevicesAppendsRules(t *testing.T) {
+	deny := &configs.Device{Type: 'c', Major: 10, Minor: 200, Permissions: "rwm"}
+	config := &configs.Config{
+		UseDefaultDevices: true,
+		Cgroups: &configs.Cgroup{Resources: &configs.Resources{
+			Devices: []*configs.Device{deny},
+		}},
+	}
+	if err := applyDefaultDevices(config); err != nil {
+		t.Fatal(err)
+	}
+	rules := config.Cgroups.Resources.Devices
+	if rules[0] != deny {
+		t.Fatal("expected the explicit rule to come first")
+	}
+	for _, d := range rules[1:] {
+		if !d.Allow {
+			t.Fatalf("expected the default rule %s to allow", d.CgroupString())
+		}
+		if d.Major == 10 && d.Minor == 200 {
+			t.Fatal("expected the explicit tuntap rule to override the default one")
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package devices
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall" //only for Stat_t
 
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -104,3 +108,46 @@ func getDevices(path string) ([]*configs.Device, error) {
 	}
 	return out, nil
 }
+
+// procDevices lists the majors of the drivers registered with the kernel.
+var procDevices = "/proc/devices"
+
+// CharMajors returns the majors registered for the character device driver
+// name, e.g. "pts", which may be allocated dynamically.
+func CharMajors(name string) ([]int64, error) {
+	f, err := os.Open(procDevices)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCharMajors(f, name)
+}
+
+func parseCharMajors(r io.Reader, name string) ([]int64, error) {
+	var (
+		majors []int64
+		chars  bool
+	)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch line {
+		case "Character devices:":
+			chars = true
+			continue
+		case "Block devices:":
+			chars = false
+			continue
+		}
+		fields := strings.Fields(line)
+		if !chars || len(fields) != 2 || fields[1] != name {
+			continue
+		}
+		major, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid major %q in %s", fields[0], procDevices)
+		}
+		majors = append(majors, major)
+	}
+	return majors, s.Err()
+}
@@ -5,6 +5,7 @@ package devices
 import (
 	"errors"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -61,3 +62,22 @@ func TestHostDevicesIoutilReadDirDeepFailure(t *testing.T) {
 		t.Fatalf("Unexpected error %v, expected %v", err, testError)
 	}
 }
+
+func TestParseCharMajors(t *testing.T) {
+	const devices = `Character devices:
+  1 mem
+  5 /dev/tty
+136 pts
+137 pts
+
+Block devices:
+136 pts
+`
+	majors, err := parseCharMajors(strings.NewReader(devices), "pts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(majors) != 2 || majors[0] != 136 || majors[1] != 137 {
+		t.Fatalf("expected the character majors 136 and 137, got %v", majors)
+	}
+}
@@ -0,0 +1,435 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups/devices"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf/devicefilter"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs2"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+// DeviceRule allows access to the devices it matches. A device profile, as
+// watched by WatchDeviceProfile, is a JSON array of them.
+type DeviceRule struct {
+	// Type is "b" for block devices or "c" for character devices.
+	Type string `json:"type"`
+	// Major is the major number of the devices, or nil for any.
+	Major *int64 `json:"major,omitempty"`
+	// Minor is the minor number of the devices, or nil for any.
+	Minor *int64 `json:"minor,omitempty"`
+	// Access is a combination of "r", "w" and "m".
+	Access string `json:"access"`
+}
+
+// DevicesUpdate is sent by WatchDeviceProfile for every change of the
+// device profile.
+type DevicesUpdate struct {
+	// Added are the rules which were allowed.
+	Added []DeviceRule
+	// Removed are the rules which were revoked.
+	Removed []DeviceRule
+	// Err is why the profile could not be applied. Nothing is changed for
+	// an invalid profile, otherwise Added and Removed tell how far it went.
+	Err error
+}
+
+// deviceProfile holds the rules applied from the device profile of a
+// container, which are applied again whenever its cgroups are set.
+type deviceProfile struct {
+	mu       sync.Mutex
+	watching bool
+	applied  []*configs.Device
+}
+
+func (c *linuxContainer) WatchDeviceProfile(path string) (<-chan DevicesUpdate, error) {
+	if c.config.Rootless {
+		return nil, fmt.Errorf("cannot update the devices of a rootless container")
+	}
+	if dir, _ := c.devicesCgroup(); dir == "" {
+		return nil, fmt.Errorf("container has no devices cgroup")
+	}
+	if !devicesAllowlisted(c.config.Cgroups.Resources) {
+		return nil, newGenericError(fmt.Errorf("container allows all devices"), ConfigInvalid)
+	}
+	c.deviceProfile.mu.Lock()
+	defer c.deviceProfile.mu.Unlock()
+	if c.deviceProfile.watching {
+		return nil, fmt.Errorf("a device profile is already watched")
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// The directory is watched, as profiles are usually replaced by renaming
+	// a new file over them.
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// The inotify descriptor goes through the runtime poller, so that closing
+	// it stops the watcher once the container has exited.
+	f := os.NewFile(uintptr(fd), "inotify")
+	update, err := c.applyDeviceProfile(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.deviceProfile.watching = true
+	ch := make(chan DevicesUpdate, 1)
+	if len(update.Added) > 0 || len(update.Removed) > 0 || update.Err != nil {
+		ch <- update
+	}
+	stopped := make(chan struct{})
+	go func() {
+		c.WaitStopped(context.Background())
+		close(stopped)
+		f.Close()
+	}()
+	go c.watchDeviceProfile(f, path, ch, stopped)
+	return ch, nil
+}
+
+// watchDeviceProfile applies the device profile at path every time it is
+// written, until f is closed or stopped is, which also stops it from
+// waiting for the receiver of ch.
+func (c *linuxContainer) watchDeviceProfile(f *os.File, path string, ch chan<- DevicesUpdate, stopped <-chan struct{}) {
+	defer close(ch)
+	defer func() {
+		c.deviceProfile.mu.Lock()
+		c.deviceProfile.watching = false
+		c.deviceProfile.mu.Unlock()
+	}()
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	name := filepath.Base(path)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		if !inotifyNamed(buf[:n], name) {
+			continue
+		}
+		c.deviceProfile.mu.Lock()
+		update, err := c.applyDeviceProfile(path)
+		c.deviceProfile.mu.Unlock()
+		if err != nil {
+			update.Err = err
+		}
+		select {
+		case ch <- update:
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// inotifyNamed returns true if any of the inotify events in buf is about
+// the file name.
+func inotifyNamed(buf []byte, name string) bool {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := unix.SizeofInotifyEvent + int(ev.Len)
+		if end > len(buf) {
+			break
+		}
+		if strings.TrimRight(string(buf[unix.SizeofInotifyEvent:end]), "\x00") == name {
+			return true
+		}
+		buf = buf[end:]
+	}
+	return false
+}
+
+// applyDeviceProfile reads the device profile at path and applies the
+// difference with the rules applied so far. A missing profile allows no
+// device, an invalid one changes nothing and is returned as an error. It is
+// called with the deviceProfile locked.
+func (c *linuxContainer) applyDeviceProfile(path string) (DevicesUpdate, error) {
+	rules, err := readDeviceProfile(path)
+	if err != nil {
+		return DevicesUpdate{}, err
+	}
+	return c.updateDevices(rules), nil
+}
+
+func readDeviceProfile(path string) ([]*configs.Device, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rules []DeviceRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid device profile %s: %v", path, err)
+	}
+	var devices []*configs.Device
+	for _, r := range rules {
+		d, err := r.device()
+		if err != nil {
+			return nil, fmt.Errorf("invalid device profile %s: %v", path, err)
+		}
+		if findDeviceRule(devices, d) == -1 {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// devicesCgroup returns the cgroup enforcing the device rules of the
+// container, and whether it is a cgroup v2 one, which enforces them with a
+// device filter.
+func (c *linuxContainer) devicesCgroup() (string, bool) {
+	paths := c.cgroupManager.GetPaths()
+	if dir, ok := paths[fs2.UnifiedKey]; ok {
+		return dir, true
+	}
+	return paths["devices"], false
+}
+
+// updateDevices moves the devices cgroup from the rules applied so far to
+// rules. The new rules are allowed first, so that a device kept by both is
+// never denied in between. A revoked rule is only denied the access which
+// no other rule for the same devices grants, as a cgroup v1 deny removes
+// the access from the exactly matching rule.
+func (c *linuxContainer) updateDevices(rules []*configs.Device) (update DevicesUpdate) {
+	dir, unified := c.devicesCgroup()
+	if unified {
+		return c.updateDeviceFilter(dir, rules)
+	}
+	p := &c.deviceProfile
+	for _, d := range rules {
+		if findDeviceRule(p.applied, d) != -1 {
+			continue
+		}
+		if err := writeDeviceRule(dir, "devices.allow", d); err != nil {
+			update.Err = err
+			return update
+		}
+		p.applied = append(p.applied, d)
+		update.Added = append(update.Added, newDeviceRule(d))
+	}
+	for i := 0; i < len(p.applied); {
+		d := p.applied[i]
+		if findDeviceRule(rules, d) != -1 {
+			i++
+			continue
+		}
+		granted := grantedAccess(allowedDevices(c.config.Cgroups.Resources), d) + grantedAccess(rules, d)
+		if denied := removeAccess(d.Permissions, granted); denied != "" {
+			deny := *d
+			deny.Permissions = denied
+			if err := writeDeviceRule(dir, "devices.deny", &deny); err != nil {
+				update.Err = err
+				return update
+			}
+		}
+		p.applied = append(p.applied[:i], p.applied[i+1:]...)
+		update.Removed = append(update.Removed, newDeviceRule(d))
+	}
+	return update
+}
+
+// updateDeviceFilter replaces the device filter of the cgroup v2 dir with
+// one enforcing the device rules of the config followed by rules. The new
+// filter is attached before the old one is detached, so that the rules are
+// changed at once.
+func (c *linuxContainer) updateDeviceFilter(dir string, rules []*configs.Device) (update DevicesUpdate) {
+	if err := loadDeviceFilter(dir, c.config.Cgroups.Resources, rules); err != nil {
+		update.Err = err
+		return update
+	}
+	p := &c.deviceProfile
+	for _, d := range rules {
+		if findDeviceRule(p.applied, d) == -1 {
+			update.Added = append(update.Added, newDeviceRule(d))
+		}
+	}
+	for _, d := range p.applied {
+		if findDeviceRule(rules, d) == -1 {
+			update.Removed = append(update.Removed, newDeviceRule(d))
+		}
+	}
+	p.applied = rules
+	return update
+}
+
+// loadDeviceFilter attaches a device filter enforcing the device rules of r
+// followed by rules to the cgroup v2 dir, replacing the one attached before.
+func loadDeviceFilter(dir string, r *configs.Resources, rules []*configs.Device) error {
+	if system.RunningInUserNS() {
+		return fmt.Errorf("device filters cannot be attached in a user namespace")
+	}
+	insts, license, err := devicefilter.DeviceFilter(append(devices.Rules(r), rules...))
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ebpf.LoadAttachCgroupDeviceFilter(insts, license, int(f.Fd()))
+}
+
+// reapplyDeviceProfile allows the rules of the device profile again, after
+// the cgroups of the container were set from its config.
+func (c *linuxContainer) reapplyDeviceProfile() error {
+	c.deviceProfile.mu.Lock()
+	defer c.deviceProfile.mu.Unlock()
+	dir, unified := c.devicesCgroup()
+	if unified {
+		if len(c.deviceProfile.applied) == 0 {
+			return nil
+		}
+		return loadDeviceFilter(dir, c.config.Cgroups.Resources, c.deviceProfile.applied)
+	}
+	for _, d := range c.deviceProfile.applied {
+		if err := writeDeviceRule(dir, "devices.allow", d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDeviceRule(dir, file string, d *configs.Device) error {
+	f, err := os.OpenFile(filepath.Join(dir, file), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(d.CgroupString() + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// device returns the cgroup rule of r, normalizing its access.
+func (r DeviceRule) device() (*configs.Device, error) {
+	d := &configs.Device{Major: configs.Wildcard, Minor: configs.Wildcard, Allow: true}
+	switch r.Type {
+	case "b", "c":
+		d.Type = rune(r.Type[0])
+	case "a":
+		return nil, fmt.Errorf("a device profile cannot allow all devices")
+	default:
+		return nil, fmt.Errorf("invalid device type %q", r.Type)
+	}
+	if r.Major != nil {
+		if *r.Major < 0 {
+			return nil, fmt.Errorf("invalid major %d", *r.Major)
+		}
+		d.Major = *r.Major
+	}
+	if r.Minor != nil {
+		if *r.Minor < 0 {
+			return nil, fmt.Errorf("invalid minor %d", *r.Minor)
+		}
+		d.Minor = *r.Minor
+	}
+	if r.Access == "" || strings.Trim(r.Access, "rwm") != "" {
+		return nil, fmt.Errorf("invalid device access %q", r.Access)
+	}
+	d.Permissions = removeAccess(r.Access, "")
+	return d, nil
+}
+
+func newDeviceRule(d *configs.Device) DeviceRule {
+	r := DeviceRule{Type: string(d.Type), Access: d.Permissions}
+	if d.Major != configs.Wildcard {
+		major := d.Major
+		r.Major = &major
+	}
+	if d.Minor != configs.Wildcard {
+		minor := d.Minor
+		r.Minor = &minor
+	}
+	return r
+}
+
+// findDeviceRule returns the index of the rule of devices which is the same
+// as d, or -1.
+func findDeviceRule(devices []*configs.Device, d *configs.Device) int {
+	for i, o := range devices {
+		if sameDevices(o, d) && o.Permissions == d.Permissions {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameDevices(a, b *configs.Device) bool {
+	return a.Type == b.Type && a.Major == b.Major && a.Minor == b.Minor
+}
+
+// grantedAccess returns the access the allowing rules of devices give to
+// exactly the devices of d.
+func grantedAccess(devices []*configs.Device, d *configs.Device) string {
+	var access string
+	for _, o := range devices {
+		if o.Allow && sameDevices(o, d) {
+			access += o.Permissions
+		}
+	}
+	return access
+}
+
+// removeAccess returns the access in access which is not in removed, in the
+// order "rwm".
+func removeAccess(access, removed string) string {
+	var rest string
+	for _, a := range "rwm" {
+		if strings.ContainsRune(access, a) && !strings.ContainsRune(removed, a) {
+			rest += string(a)
+		}
+	}
+	return rest
+}
+
+// devicesAllowlisted returns true if r denies every device which is not
+// explicitly allowed.
+func devicesAllowlisted(r *configs.Resources) bool {
+	if r == nil {
+		return false
+	}
+	if len(r.Devices) > 0 {
+		allowlisted := false
+		for _, d := range r.Devices {
+			if d.Type == 'a' {
+				allowlisted = !d.Allow
+			}
+		}
+		return allowlisted
+	}
+	return r.AllowAllDevices != nil && !*r.AllowAllDevices
+}
+
+// allowedDevices returns the rules of r allowing devices.
+func allowedDevices(r *configs.Resources) []*configs.Device {
+	if len(r.Devices) > 0 {
+		return r.Devices
+	}
+	allowed := make([]*configs.Device, 0, len(r.AllowedDevices))
+	for _, d := range r.AllowedDevices {
+		dev := *d
+		dev.Allow = true
+		allowed = append(allowed, &dev)
+	}
+	return allowed
+}
@@ -0,0 +1,233 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDeviceRuleValidation(t *testing.T) {
+	major := int64(195)
+	negative := int64(-1)
+	for _, r := range []DeviceRule{
+		{Type: "a", Access: "rwm"},
+		{Type: "x", Access: "rwm"},
+		{Type: "c", Major: &negative, Access: "rwm"},
+		{Type: "c", Major: &major, Access: ""},
+		{Type: "c", Major: &major, Access: "rx"},
+	} {
+		if _, err := r.device(); err == nil {
+			t.Errorf("expected %+v to be rejected", r)
+		}
+	}
+	d, err := DeviceRule{Type: "c", Major: &major, Access: "mwr"}.device()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.CgroupString(); s != "c 195:* rwm" {
+		t.Fatalf("expected c 195:* rwm, got %s", s)
+	}
+}
+
+func TestUpdateDevicesFromProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"devices.allow", "devices.deny"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowAll := false
+	c := &linuxContainer{
+		config: &configs.Config{Cgroups: &configs.Cgroup{Resources: &configs.Resources{
+			AllowAllDevices: &allowAll,
+			AllowedDevices:  []*configs.Device{{Type: 'c', Major: 195, Minor: 0, Permissions: "r"}},
+		}}},
+		cgroupManager: &mockCgroupManager{paths: map[string]string{"devices": dir}},
+	}
+	profile := filepath.Join(dir, "profile.json")
+	apply := func(data string) (DevicesUpdate, error) {
+		if err := ioutil.WriteFile(profile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return c.applyDeviceProfile(profile)
+	}
+	read := func(file string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	u, err := apply(`[{"type": "c", "major": 195, "minor": 0, "access": "rw"}, {"type": "c", "major": 195, "minor": 1, "access": "rwm"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Added) != 2 || len(u.Removed) != 0 {
+		t.Fatalf("expected two rules to be added, got %+v", u)
+	}
+	if got := read("devices.allow"); got != "c 195:0 rw\nc 195:1 rwm\n" {
+		t.Fatalf("unexpected rules allowed: %q", got)
+	}
+
+	if _, err := apply(`[{"type": "c", "major": 195, "access": "rwx"}]`); err == nil {
+		t.Fatal("expected an invalid profile to be rejected")
+	}
+	if len(c.deviceProfile.applied) != 2 || read("devices.deny") != "" {
+		t.Fatal("expected an invalid profile to leave the rules alone")
+	}
+
+	u, err = apply(`[{"type": "c", "major": 195, "minor": 1, "access": "rwm"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Added) != 0 || len(u.Removed) != 1 {
+		t.Fatalf("expected one rule to be removed, got %+v", u)
+	}
+	// The config still allows reading the device.
+	if got := read("devices.deny"); got != "c 195:0 w\n" {
+		t.Fatalf("unexpected rules denied: %q", got)
+	}
+}
+
+func TestWatchDeviceProfileStopsWhileBlocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"devices.allow", "devices.deny"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowAll := false
+	c := &linuxContainer{
+		config:        &configs.Config{Cgroups: &configs.Cgroup{Resources: &configs.Resources{AllowAllDevices: &allowAll}}},
+		cgroupManager: &mockCgroupManager{paths: map[string]string{"devices": dir}},
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO); err != nil {
+		unix.Close(fd)
+		t.Fatal(err)
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+	c.deviceProfile.watching = true
+	// Nobody receives the updates.
+	ch := make(chan DevicesUpdate)
+	stopped := make(chan struct{})
+	go c.watchDeviceProfile(f, filepath.Join(dir, "profile.json"), ch, stopped)
+	if err := ioutil.WriteFile(filepath.Join(dir, "profile.json"), []byte(`[{"type": "c", "major": 1, "minor": 3, "access": "rw"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(stopped)
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected the watcher not to wait for the update to be received once stopped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the watcher to stop")
+	}
+	c.deviceProfile.mu.Lock()
+	watching := c.deviceProfile.watching
+	c.deviceProfile.mu.Unlock()
+	if watching {
+		t.Fatal("expected another profile to be watchable once stopped")
+	}
+}
+
+func TestUpdateDeviceFilterFromProfile(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("attaching a device filter requires root")
+	}
+	var parent string
+	for _, dir := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		var st unix.Statfs_t
+		if err := unix.Statfs(dir, &st); err == nil && st.Type == 0x63677270 {
+			parent = dir
+			break
+		}
+	}
+	if parent == "" {
+		t.Skip("no cgroup v2 hierarchy")
+	}
+	dir, err := ioutil.TempDir(parent, "devices")
+	if err != nil {
+		t.Skipf("cannot create a cgroup: %v", err)
+	}
+	defer os.Remove(dir)
+	allowAll := false
+	c := &linuxContainer{
+		config:        &configs.Config{Cgroups: &configs.Cgroup{Resources: &configs.Resources{AllowAllDevices: &allowAll}}},
+		cgroupManager: &mockCgroupManager{paths: map[string]string{"": dir}},
+	}
+	// Reads /dev/null once moved to the cgroup.
+	readDevNull := func() error {
+		cmd := exec.Command("/bin/sh", "-c", "read x; exec cat /dev/null")
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(cmd.Process.Pid)), 0); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			t.Fatal(err)
+		}
+		stdin.Write([]byte("\n"))
+		stdin.Close()
+		return cmd.Wait()
+	}
+
+	if u := c.updateDevices(nil); u.Err != nil {
+		t.Skipf("cannot attach a device filter: %v", u.Err)
+	}
+	if err := readDevNull(); err == nil {
+		t.Fatal("expected the config to deny every device")
+	}
+	rules, err := (DeviceRule{Type: "c", Major: int64Ptr(1), Minor: int64Ptr(3), Access: "rw"}).device()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := c.updateDevices([]*configs.Device{rules})
+	if u.Err != nil || len(u.Added) != 1 || len(u.Removed) != 0 {
+		t.Fatalf("expected one rule to be added, got %+v", u)
+	}
+	if err := readDevNull(); err != nil {
+		t.Fatalf("expected the profile to allow /dev/null: %v", err)
+	}
+	u = c.updateDevices(nil)
+	if u.Err != nil || len(u.Added) != 0 || len(u.Removed) != 1 {
+		t.Fatalf("expected one rule to be removed, got %+v", u)
+	}
+	if err := readDevNull(); err == nil {
+		t.Fatal("expected the removed rule to be revoked")
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
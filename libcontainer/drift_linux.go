@@ -0,0 +1,214 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+// CgroupDrift is the drift of the cgroups of a container found by
+// WatchCgroupDrift.
+type CgroupDrift struct {
+	Files []cgroups.Drift
+	// Repaired is set if the cgroups were set again, and the files then read
+	// back as expected.
+	Repaired bool
+	// Unrepairable are the files which still drifted once the cgroups were
+	// set again, as when the kernel does not keep the value they are set
+	// to. They are only set again once written by someone else.
+	Unrepairable []cgroups.Drift
+}
+
+// driftWatch is the state of the watcher started by WatchCgroupDrift.
+type driftWatch struct {
+	mu       sync.Mutex
+	watching bool
+}
+
+func (c *linuxContainer) CheckDrift() ([]cgroups.Drift, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		return nil, newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+	checks, err := c.driftChecks()
+	if err != nil {
+		return nil, err
+	}
+	drift, err := cgroups.CheckDrift(checks)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "checking cgroup drift")
+	}
+	return drift, nil
+}
+
+// driftChecks returns the checks of the cgroup files set for the resources
+// of the container.
+func (c *linuxContainer) driftChecks() ([]cgroups.DriftCheck, error) {
+	m, ok := c.cgroupManager.(cgroups.DriftChecker)
+	if !ok {
+		return nil, fmt.Errorf("the cgroup manager of the container cannot check for drift")
+	}
+	return m.DriftChecks(c.config), nil
+}
+
+func (c *linuxContainer) WatchCgroupDrift(repair bool) error {
+	if c.config.Rootless {
+		return fmt.Errorf("cannot watch the cgroups of a rootless container")
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+	if _, err := c.driftChecks(); err != nil {
+		return err
+	}
+	c.driftWatch.mu.Lock()
+	defer c.driftWatch.mu.Unlock()
+	if c.driftWatch.watching {
+		return fmt.Errorf("cgroup drift is already watched")
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// The directories of the cgroups are watched, so that the files set for
+	// resources added later on are watched as well.
+	watched := make(map[string]bool)
+	for _, path := range c.cgroupManager.GetPaths() {
+		if path == "" || watched[path] {
+			continue
+		}
+		if _, err := unix.InotifyAddWatch(fd, path, unix.IN_MODIFY); err != nil {
+			unix.Close(fd)
+			return newSystemErrorWithCausef(err, "watching cgroup %s", path)
+		}
+		watched[path] = true
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	c.driftWatch.watching = true
+	go func() {
+		c.WaitStopped(context.Background())
+		f.Close()
+	}()
+	go c.watchCgroupDrift(f, repair)
+	return nil
+}
+
+// watchCgroupDrift checks for drift every time one of the files checked is
+// written, until f is closed or the cgroups are removed.
+func (c *linuxContainer) watchCgroupDrift(f *os.File, repair bool) {
+	defer func() {
+		c.driftWatch.mu.Lock()
+		c.driftWatch.watching = false
+		c.driftWatch.mu.Unlock()
+	}()
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	// The files may have drifted before they were watched.
+	if c.handleCgroupDrift(nil, repair) {
+		drainInotify(f, buf)
+	}
+	for {
+		n, err := f.Read(buf)
+		if err != nil || inotifyIgnored(buf[:n]) {
+			return
+		}
+		if c.handleCgroupDrift(buf[:n], repair) {
+			drainInotify(f, buf)
+		}
+	}
+}
+
+// drainInotify discards the events queued on f, which the cgroups being set
+// again caused.
+func drainInotify(f *os.File, buf []byte) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+	// The descriptor is non-blocking, reads fail once it is drained.
+	conn.Control(func(fd uintptr) {
+		for {
+			if _, err := unix.Read(int(fd), buf); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// handleCgroupDrift reports the drift of the cgroup files with a
+// CgroupDrifted event, setting the cgroups again first if repair is set,
+// and returns whether it did. If events are given, only the files they name
+// are checked. The files which still drift once the cgroups are set again
+// are reported as unrepairable.
+func (c *linuxContainer) handleCgroupDrift(events []byte, repair bool) (set bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	checks, err := c.driftChecks()
+	if err != nil {
+		return false
+	}
+	if events != nil {
+		var named []cgroups.DriftCheck
+		for _, check := range checks {
+			if inotifyNamed(events, filepath.Base(check.Path)) {
+				named = append(named, check)
+			}
+		}
+		checks = named
+	}
+	drift, err := cgroups.CheckDrift(checks)
+	if err != nil {
+		logrus.Debugf("checking the cgroup drift of container %s: %v", c.id, err)
+		return false
+	}
+	if len(drift) == 0 {
+		return false
+	}
+	report := &CgroupDrift{Files: drift}
+	if repair {
+		set = true
+		err := c.cgroupManager.Set(c.config)
+		c.warnCgroups()
+		if err == nil {
+			report.Unrepairable, err = cgroups.CheckDrift(checks)
+		}
+		switch {
+		case err != nil:
+			c.warn(configs.Warning{
+				Code:      configs.WarnCgroupRepairFailed,
+				FieldPath: "cgroups.resources",
+				Message:   fmt.Sprintf("setting the drifted cgroups again: %v", err),
+			})
+		case len(report.Unrepairable) > 0:
+			c.warn(configs.Warning{
+				Code:      configs.WarnCgroupRepairFailed,
+				FieldPath: "cgroups.resources",
+				Message:   fmt.Sprintf("the cgroups still drift once set again: %v", report.Unrepairable),
+			})
+		default:
+			report.Repaired = true
+		}
+	}
+	sendFactoryEvent(c.events, FactoryEvent{Type: CgroupDrifted, ID: c.id, Drift: report})
+	return set
+}
@@ -0,0 +1,159 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestCgroupDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, kill := newCreatedContainer(t, dir)
+	defer kill()
+
+	pids := filepath.Join(dir, "pids")
+	if err := os.Mkdir(pids, 0755); err != nil {
+		t.Fatal(err)
+	}
+	pidsMax := filepath.Join(pids, "pids.max")
+	if err := ioutil.WriteFile(pidsMax, []byte("10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.config.Cgroups = &configs.Cgroup{Resources: &configs.Resources{PidsLimit: 10}}
+	c.cgroupManager = &fs.Manager{Cgroups: &configs.Cgroup{}, Paths: map[string]string{"pids": pids}}
+	drift, err := c.CheckDrift()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+
+	if err := ioutil.WriteFile(pidsMax, []byte("max\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	drift, err = c.CheckDrift()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 1 || drift[0].File != "pids.max" || drift[0].Expected != "10" || drift[0].Actual != "max" {
+		t.Fatalf("expected pids.max to have drifted, got %v", drift)
+	}
+
+	events := make(chan FactoryEvent, 4)
+	c.events = events
+	if err := c.WatchCgroupDrift(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WatchCgroupDrift(true); err == nil {
+		t.Fatal("expected drift to be watched only once")
+	}
+	// The drift found before watching is repaired, then that of every write.
+	for i := 0; i < 2; i++ {
+		waitDriftRepaired(t, events, pidsMax, "10")
+		if err := ioutil.WriteFile(pidsMax, []byte("max\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// normalizingManager sets pids.max to max, as the kernel would normalize a
+// value the files are checked against.
+type normalizingManager struct {
+	*fs.Manager
+}
+
+func (m normalizingManager) Set(container *configs.Config) error {
+	return ioutil.WriteFile(filepath.Join(m.Paths["pids"], "pids.max"), []byte("max\n"), 0644)
+}
+
+func TestCgroupDriftUnrepairable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, kill := newCreatedContainer(t, dir)
+	defer kill()
+
+	pids := filepath.Join(dir, "pids")
+	if err := os.Mkdir(pids, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pids, "pids.max"), []byte("max\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.config.Cgroups = &configs.Cgroup{Resources: &configs.Resources{PidsLimit: 10}}
+	c.cgroupManager = normalizingManager{&fs.Manager{Cgroups: &configs.Cgroup{}, Paths: map[string]string{"pids": pids}}}
+	events := make(chan FactoryEvent, 4)
+	c.events = events
+	if err := c.WatchCgroupDrift(true); err != nil {
+		t.Fatal(err)
+	}
+	ev := waitDrifted(t, events, 5*time.Second)
+	if ev == nil {
+		t.Fatal("timed out waiting for the drift to be reported")
+	}
+	if ev.Drift.Repaired || len(ev.Drift.Unrepairable) != 1 || ev.Drift.Unrepairable[0].File != "pids.max" {
+		t.Fatalf("expected pids.max to be unrepairable, got %+v", ev.Drift)
+	}
+	// Setting the cgroups again does not trigger another repair.
+	if ev := waitDrifted(t, events, 200*time.Millisecond); ev != nil {
+		t.Fatalf("expected the repair to stop, got %+v", ev.Drift)
+	}
+}
+
+// waitDrifted returns the next CgroupDrifted event, or nil if there is none
+// within timeout.
+func waitDrifted(t *testing.T, events <-chan FactoryEvent, timeout time.Duration) *FactoryEvent {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == CgroupDrifted {
+				return &ev
+			}
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+// waitDriftRepaired waits for a repaired CgroupDrifted event and for path
+// to read expected. Writes are seen truncating the file before its content
+// is written, which may be repaired separately.
+func waitDriftRepaired(t *testing.T, events <-chan FactoryEvent, path, expected string) {
+	timeout := time.After(5 * time.Second)
+	var repaired bool
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != CgroupDrifted || ev.Drift == nil || !ev.Drift.Repaired || len(ev.Drift.Files) != 1 {
+				t.Fatalf("unexpected event %+v", ev)
+			}
+			repaired = true
+		case <-timeout:
+			t.Fatal("timed out waiting for the drift to be repaired")
+		case <-time.After(10 * time.Millisecond):
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repaired && strings.TrimSpace(string(data)) == expected {
+			return
+		}
+	}
+}
@@ -17,6 +17,9 @@ const (
 	ContainerNotStopped
 	ContainerNotRunning
 	ContainerNotPaused
+	ContainerAlreadyRunning
+	ContainerStopped
+	ContainerStaleCreated
 
 	// Process errors
 	NoProcessOps
@@ -25,6 +28,7 @@ const (
 	ConfigInvalid
 	ConsoleExists
 	SystemError
+	SyncProtocolError
 )
 
 func (c ErrorCode) String() string {
@@ -39,6 +43,8 @@ func (c ErrorCode) String() string {
 		return "Invalid configuration"
 	case SystemError:
 		return "System error"
+	case SyncProtocolError:
+		return "Sync protocol error"
 	case ContainerNotExists:
 		return "Container does not exist"
 	case ContainerNotStopped:
@@ -49,6 +55,12 @@ func (c ErrorCode) String() string {
 		return "Console exists for process"
 	case ContainerNotPaused:
 		return "Container is not paused"
+	case ContainerAlreadyRunning:
+		return "Container is already running"
+	case ContainerStopped:
+		return "Container has stopped"
+	case ContainerStaleCreated:
+		return "Container init died before the container was started"
 	case NoProcessOps:
 		return "No process operations"
 	default:
@@ -15,6 +15,7 @@ func TestErrorCode(t *testing.T) {
 		ConsoleExists:       "Console exists for process",
 		ContainerNotPaused:  "Container is not paused",
 		NoProcessOps:        "No process operations",
+		SyncProtocolError:   "Sync protocol error",
 	}
 
 	for code, expected := range codes {
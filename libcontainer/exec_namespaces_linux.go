@@ -0,0 +1,32 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// subsetNamespacePaths returns the namespaces of nsPaths which p joins when
+// it only joins those of its Namespaces, and whether it is left out of the
+// user namespace of the container. A process left out of the latter joins
+// the others with the privileges of the host, as with JoinUserNS.
+func (c *linuxContainer) subsetNamespacePaths(p *Process, nsPaths map[configs.NamespaceType]string) (map[configs.NamespaceType]string, bool, error) {
+	paths := make(map[configs.NamespaceType]string, len(p.Namespaces))
+	for _, t := range p.Namespaces {
+		if !c.config.Namespaces.Contains(t) {
+			return nil, false, newGenericError(fmt.Errorf("the container has no %s namespace of its own to join", configs.NsName(t)), ConfigInvalid)
+		}
+		paths[t] = nsPaths[t]
+	}
+	_, joinUser := paths[configs.NEWUSER]
+	if joinUser && !joinsUserNS(p) {
+		return nil, false, newGenericError(fmt.Errorf("the user namespace of the container cannot be both joined and left out"), ConfigInvalid)
+	}
+	if joinUser || !c.config.Namespaces.Contains(configs.NEWUSER) {
+		return paths, false, nil
+	}
+	paths[configs.NEWUSER] = nsPaths[configs.NEWUSER]
+	return c.hostUserNamespacePaths(paths)
+}
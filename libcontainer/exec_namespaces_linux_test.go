@@ -0,0 +1,53 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestSubsetNamespacePaths(t *testing.T) {
+	defer func(f func() (bool, error)) { hasHostUserCapabilities = f }(hasHostUserCapabilities)
+	hasHostUserCapabilities = func() (bool, error) { return true, nil }
+
+	c := &linuxContainer{config: &configs.Config{
+		Namespaces: configs.Namespaces{{Type: configs.NEWNS}, {Type: configs.NEWNET}, {Type: configs.NEWPID}},
+	}}
+	nsPaths := map[configs.NamespaceType]string{
+		configs.NEWNS:   "/proc/42/ns/mnt",
+		configs.NEWNET:  "/proc/42/ns/net",
+		configs.NEWPID:  "/proc/42/ns/pid",
+		configs.NEWUSER: "/proc/42/ns/user",
+	}
+	paths, hostUser, err := c.subsetNamespacePaths(&Process{Namespaces: []configs.NamespaceType{configs.NEWNET}}, nsPaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostUser || len(paths) != 1 || paths[configs.NEWNET] != nsPaths[configs.NEWNET] {
+		t.Fatalf("expected only the network namespace to be joined, got %v, %v", paths, hostUser)
+	}
+
+	_, _, err = c.subsetNamespacePaths(&Process{Namespaces: []configs.NamespaceType{configs.NEWUTS}}, nsPaths)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a namespace the container does not have to be refused, got %v", err)
+	}
+
+	// Leaving out the user namespace of the container requires the
+	// privileges of the host.
+	c.config.Namespaces = append(c.config.Namespaces, configs.Namespace{Type: configs.NEWUSER})
+	paths, hostUser, err = c.subsetNamespacePaths(&Process{Namespaces: []configs.NamespaceType{configs.NEWNET}}, nsPaths)
+	if err != nil || !hostUser || len(paths) != 1 {
+		t.Fatalf("expected the user namespace to be left out, got %v, %v, %v", paths, hostUser, err)
+	}
+	paths, hostUser, err = c.subsetNamespacePaths(&Process{Namespaces: []configs.NamespaceType{configs.NEWUSER, configs.NEWNET}}, nsPaths)
+	if err != nil || hostUser || len(paths) != 2 {
+		t.Fatalf("expected the user namespace to be joined, got %v, %v, %v", paths, hostUser, err)
+	}
+	joinUser := false
+	_, _, err = c.subsetNamespacePaths(&Process{Namespaces: []configs.NamespaceType{configs.NEWUSER}, JoinUserNS: &joinUser}, nsPaths)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a contradiction to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/syndtr/gocapability/capability"
+)
+
+// joinsUserNS returns whether p joins the user namespace of the container
+// it is executed in.
+func joinsUserNS(p *Process) bool {
+	return p.JoinUserNS == nil || *p.JoinUserNS
+}
+
+// hostUserCapabilities are those we need in our own user namespace to join
+// the namespaces of a container owned by its user namespace without joining
+// the latter.
+var hostUserCapabilities = []capability.Cap{capability.CAP_SYS_ADMIN, capability.CAP_SYS_CHROOT}
+
+// hasHostUserCapabilities is replaced in tests.
+var hasHostUserCapabilities = func() (bool, error) {
+	pid, err := capability.NewPid(os.Getpid())
+	if err != nil {
+		return false, err
+	}
+	for _, c := range hostUserCapabilities {
+		if !pid.Get(capability.EFFECTIVE, c) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// hostUserNamespacePaths returns the namespaces of nsPaths which a process
+// executed without joining the user namespace of the container joins, and
+// whether it is left out of one.
+func (c *linuxContainer) hostUserNamespacePaths(nsPaths map[configs.NamespaceType]string) (map[configs.NamespaceType]string, bool, error) {
+	if _, ok := nsPaths[configs.NEWUSER]; !ok {
+		return nsPaths, false, nil
+	}
+	if c.config.Rootless {
+		return nil, false, newGenericError(fmt.Errorf("a process cannot be executed outside the user namespace of a rootless container"), ConfigInvalid)
+	}
+	ok, err := hasHostUserCapabilities()
+	if err != nil {
+		return nil, false, newSystemErrorWithCause(err, "checking capabilities")
+	}
+	if !ok {
+		return nil, false, newGenericError(fmt.Errorf("executing a process outside the user namespace of the container requires CAP_SYS_ADMIN and CAP_SYS_CHROOT"), ConfigInvalid)
+	}
+	paths := make(map[configs.NamespaceType]string, len(nsPaths)-1)
+	for t, path := range nsPaths {
+		if t != configs.NEWUSER {
+			paths[t] = path
+		}
+	}
+	return paths, true, nil
+}
+
+// auditHostUserExec records that the process pid was executed in the
+// container id without joining its user namespace, so with the privileges
+// of the host.
+func auditHostUserExec(id string, pid int, args []string) {
+	logrus.WithFields(logrus.Fields{
+		"container":  id,
+		"pid":        pid,
+		"args":       args,
+		"privileged": true,
+	}).Warn("executed process outside the user namespace of the container")
+}
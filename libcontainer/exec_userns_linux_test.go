@@ -0,0 +1,49 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestHostUserNamespacePaths(t *testing.T) {
+	defer func(f func() (bool, error)) { hasHostUserCapabilities = f }(hasHostUserCapabilities)
+	hasCaps := true
+	hasHostUserCapabilities = func() (bool, error) { return hasCaps, nil }
+
+	c := &linuxContainer{config: &configs.Config{}}
+	nsPaths := map[configs.NamespaceType]string{
+		configs.NEWNS:   "/proc/42/ns/mnt",
+		configs.NEWPID:  "/proc/42/ns/pid",
+		configs.NEWUSER: "/proc/42/ns/user",
+	}
+	paths, hostUser, err := c.hostUserNamespacePaths(nsPaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hostUser || len(paths) != 2 || paths[configs.NEWUSER] != "" || paths[configs.NEWPID] != nsPaths[configs.NEWPID] {
+		t.Fatalf("expected only the user namespace to be left out, got %v", paths)
+	}
+
+	// A container without a user namespace is joined as usual.
+	delete(nsPaths, configs.NEWUSER)
+	if paths, hostUser, err = c.hostUserNamespacePaths(nsPaths); err != nil || hostUser || len(paths) != 2 {
+		t.Fatalf("expected the namespaces to be left alone, got %v, %v, %v", paths, hostUser, err)
+	}
+	nsPaths[configs.NEWUSER] = "/proc/42/ns/user"
+
+	hasCaps = false
+	_, _, err = c.hostUserNamespacePaths(nsPaths)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a caller without the capabilities to be refused, got %v", err)
+	}
+
+	hasCaps = true
+	c.config.Rootless = true
+	_, _, err = c.hostUserNamespacePaths(nsPaths)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a rootless container to refuse, got %v", err)
+	}
+}
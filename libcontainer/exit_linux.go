@@ -0,0 +1,80 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// recordExit records how the init process exited in the state of the
+// container, unless it was destroyed meanwhile. The output of the process
+// is left out, it is only ever written to the exit file.
+func (c *linuxContainer) recordExit(exit *ExitStatus) {
+	if exit == nil {
+		return
+	}
+	recorded := *exit
+	recorded.Output = ""
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.exit = &recorded
+	if _, err := os.Stat(filepath.Join(c.root, stateFilename)); err != nil {
+		return
+	}
+	state, err := c.currentState()
+	if err == nil {
+		err = c.saveState(state)
+	}
+	if err != nil {
+		logrus.Warnf("recording the exit of the init process of container %s: %v", c.id, err)
+	}
+}
+
+func (c *linuxContainer) ExitStatus() (*ExitStatus, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status != Stopped && status != StoppedWithStragglers {
+		return nil, newGenericError(fmt.Errorf("the init process of the container has not exited"), ContainerNotStopped)
+	}
+	// The exit may have been recorded by another process since we loaded
+	// the container.
+	if c.exit == nil {
+		exit, err := c.loadExit()
+		if err != nil {
+			return nil, newSystemErrorWithCause(err, "reading exit status")
+		}
+		c.exit = exit
+	}
+	if c.exit == nil {
+		return nil, nil
+	}
+	exit := *c.exit
+	return &exit, nil
+}
+
+// loadExit returns the exit recorded in the state file of the container, if
+// any.
+func (c *linuxContainer) loadExit() (*ExitStatus, error) {
+	f, err := os.Open(filepath.Join(c.root, stateFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var state State
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, err
+	}
+	return state.Exit, nil
+}
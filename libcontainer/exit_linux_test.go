@@ -0,0 +1,39 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadExitFromOlderState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &linuxContainer{root: dir}
+	if err := ioutil.WriteFile(filepath.Join(dir, stateFilename), []byte(`{"id":"old","init_process_pid":42}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exit, err := c.loadExit()
+	if err != nil || exit != nil {
+		t.Fatalf("expected no exit in an older state, got %+v, %v", exit, err)
+	}
+
+	data, err := json.Marshal(State{Exit: &ExitStatus{Pid: 42, Status: 137, Signal: 9, OOMKilled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, stateFilename), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	exit, err = c.loadExit()
+	if err != nil || exit == nil || exit.Status != 137 || exit.Signal != 9 || !exit.OOMKilled {
+		t.Fatalf("expected the recorded exit, got %+v, %v", exit, err)
+	}
+}
@@ -31,6 +31,50 @@ type Factory interface {
 	// System error
 	Load(id string) (Container, error)
 
+	// LoadAll loads every container of the factory, adopting the handles
+	// saved for them by Container.PrepareShutdown, and re-arming their OOM
+	// watchers, waiters and lifetime timers. Containers removed while they
+	// are being loaded are skipped.
+	//
+	// errors:
+	// System error
+	LoadAll() ([]Container, error)
+
+	// GC destroys the containers whose init process died before they were
+	// started, as when the process which created them was killed, and
+	// returns their ids. Starting such a container fails with
+	// ContainerStaleCreated.
+	//
+	// errors:
+	// System error
+	GC() ([]string, error)
+
+	// Rename changes the id of the existing container oldID to newID, whether
+	// it is running or not, by renaming its state and the cgroups named after
+	// it. newID must have the same format as the ids given to Create.
+	//
+	// Containers loaded before the rename keep the old id and must be loaded
+	// again.
+	//
+	// errors:
+	// ContainerNotExists - the container oldID does not exist
+	// IdInUse - newID is already in use by a container
+	// InvalidIdFormat - newID has incorrect format
+	// ContainerPaused - the container is paused
+	// Systemerror - System error
+	//
+	// On error, the container is left with the id oldID.
+	Rename(oldID, newID string) error
+
+	// Commitments adds up the resource limits of the containers which are not
+	// stopped, from their stored configs rather than their cgroups, against
+	// the capacity of the host. Only the containers paused through Pause are
+	// counted as paused.
+	//
+	// errors:
+	// Systemerror - System error
+	Commitments() (*Commitments, error)
+
 	// StartInitialization is an internal API to libcontainer used during the reexec of the
 	// container.
 	//
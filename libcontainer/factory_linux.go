@@ -5,15 +5,21 @@ package libcontainer
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/mount"
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/cgroups/fs"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs2"
 	"github.com/opencontainers/runc/libcontainer/cgroups/rootless"
 	"github.com/opencontainers/runc/libcontainer/cgroups/systemd"
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -26,6 +32,9 @@ import (
 const (
 	stateFilename    = "state.json"
 	execFifoFilename = "exec.fifo"
+	// initWaitName is the name of the init process while it waits on the
+	// exec fifo, as set by nsexec too.
+	initWaitName = "runc:[2:INIT]"
 )
 
 var idRegex = regexp.MustCompile(`^[\w+-\.]+$`)
@@ -61,9 +70,16 @@ func SystemdCgroups(l *LinuxFactory) error {
 
 // Cgroupfs is an options func to configure a LinuxFactory to return
 // containers that use the native cgroups filesystem implementation to
-// create and manage cgroups.
+// create and manage cgroups. The cgroup v2 implementation is used on hosts
+// in unified mode.
 func Cgroupfs(l *LinuxFactory) error {
 	l.NewCgroupsManager = func(config *configs.Cgroup, paths map[string]string) cgroups.Manager {
+		if cgroups.IsCgroup2UnifiedMode() {
+			return &fs2.Manager{
+				Cgroups: config,
+				Paths:   paths,
+			}
+		}
 		return &fs.Manager{
 			Cgroups: config,
 			Paths:   paths,
@@ -109,6 +125,104 @@ func CriuPath(criupath string) func(*LinuxFactory) error {
 	}
 }
 
+// OwnerHelperPath returns an option func to configure a LinuxFactory with
+// the FUSE helper serving the mounts with a forced owner when idmapped
+// mounts are not available, see startOwnerHelper.
+func OwnerHelperPath(path string) func(*LinuxFactory) error {
+	return func(l *LinuxFactory) error {
+		l.OwnerHelperPath = path
+		return nil
+	}
+}
+
+// TraceSync is an options func to configure a LinuxFactory to log every
+// synchronisation message exchanged with a container's init process while it
+// is being started. This is only useful for debugging.
+func TraceSync(l *LinuxFactory) error {
+	l.TraceSync = true
+	return nil
+}
+
+// DenyHostBinary is an options func to configure a LinuxFactory to refuse
+// processes which execute a binary from the host, see Process.HostBinary.
+func DenyHostBinary(l *LinuxFactory) error {
+	l.DenyHostBinary = true
+	return nil
+}
+
+// DefaultFreezeTimeout is how long freezing or thawing the cgroups of a
+// container may take by default before it is given up.
+const DefaultFreezeTimeout = 30 * time.Second
+
+// FreezeTimeout returns an options func to configure a LinuxFactory with how
+// long freezing or thawing the cgroups of a container, e.g. to pause it, may
+// take before it is given up. Zero waits forever.
+func FreezeTimeout(timeout time.Duration) func(*LinuxFactory) error {
+	return func(l *LinuxFactory) error {
+		if timeout < 0 {
+			return newGenericError(fmt.Errorf("invalid freeze timeout %s", timeout), ConfigInvalid)
+		}
+		l.FreezeTimeout = timeout
+		return nil
+	}
+}
+
+// DefaultSetnsRetries and DefaultSetnsBackoff bound how long joining a
+// namespace of a container which setns reports as busy is retried by default.
+// MaxSetnsBackoff is the longest nsexec waits between two attempts, which the
+// backoff can only be set up to; longer doubled waits are capped to it.
+const (
+	DefaultSetnsRetries = 5
+	DefaultSetnsBackoff = 10 * time.Millisecond
+	MaxSetnsBackoff     = time.Second
+)
+
+// SetnsRetry returns an options func to configure a LinuxFactory with how
+// many times joining a namespace of a container is retried when setns fails
+// with EBUSY, which is transient while the namespace is being torn down or
+// detached from. The backoff doubles after each attempt. Zero retries fail
+// right away.
+func SetnsRetry(retries int, backoff time.Duration) func(*LinuxFactory) error {
+	return func(l *LinuxFactory) error {
+		if err := checkSetnsRetry(retries, backoff); err != nil {
+			return newGenericError(err, ConfigInvalid)
+		}
+		l.SetnsRetries = retries
+		l.SetnsBackoff = backoff
+		return nil
+	}
+}
+
+// checkSetnsRetry refuses retries and a backoff which do not fit the
+// bootstrap data, or which nsexec would not wait for.
+func checkSetnsRetry(retries int, backoff time.Duration) error {
+	if retries < 0 || retries > math.MaxInt32 {
+		return fmt.Errorf("invalid setns retries %d", retries)
+	}
+	if backoff < 0 || backoff > MaxSetnsBackoff {
+		return fmt.Errorf("invalid setns backoff %s, must be between 0 and %s", backoff, MaxSetnsBackoff)
+	}
+	return nil
+}
+
+// Metrics returns an options func to configure a LinuxFactory with a sink
+// for the latencies measured by its containers.
+func Metrics(sink MetricsSink) func(*LinuxFactory) error {
+	return func(l *LinuxFactory) error {
+		l.Metrics = sink
+		return nil
+	}
+}
+
+// FactoryEvents returns an options func to configure a LinuxFactory with a
+// channel receiving the events of its containers, such as renames.
+func FactoryEvents(ch chan<- FactoryEvent) func(*LinuxFactory) error {
+	return func(l *LinuxFactory) error {
+		l.Events = ch
+		return nil
+	}
+}
+
 // New returns a linux based container factory based in the root directory and
 // configures the factory with the provided option funcs.
 func New(root string, options ...func(*LinuxFactory) error) (Factory, error) {
@@ -118,10 +232,14 @@ func New(root string, options ...func(*LinuxFactory) error) (Factory, error) {
 		}
 	}
 	l := &LinuxFactory{
-		Root:      root,
-		InitArgs:  []string{"/proc/self/exe", "init"},
-		Validator: validate.New(),
-		CriuPath:  "criu",
+		Root:            root,
+		InitArgs:        []string{"/proc/self/exe", "init"},
+		Validator:       validate.New(),
+		CriuPath:        "criu",
+		OwnerHelperPath: "bindfs",
+		FreezeTimeout:   DefaultFreezeTimeout,
+		SetnsRetries:    DefaultSetnsRetries,
+		SetnsBackoff:    DefaultSetnsBackoff,
 	}
 	Cgroupfs(l)
 	for _, opt := range options {
@@ -129,6 +247,9 @@ func New(root string, options ...func(*LinuxFactory) error) (Factory, error) {
 			return nil, err
 		}
 	}
+	if err := l.checkInitBinary(); err != nil {
+		return nil, err
+	}
 	return l, nil
 }
 
@@ -145,11 +266,45 @@ type LinuxFactory struct {
 	// containers.
 	CriuPath string
 
+	// OwnerHelperPath is the path to the bindfs-like FUSE helper serving the
+	// mounts with a forced owner when idmapped mounts are not available.
+	OwnerHelperPath string
+
 	// Validator provides validation to container configurations.
 	Validator validate.Validator
 
 	// NewCgroupsManager returns an initialized cgroups manager for a single container.
 	NewCgroupsManager func(config *configs.Cgroup, paths map[string]string) cgroups.Manager
+
+	// TraceSync logs the synchronisation messages exchanged with the init
+	// process of containers while they are started.
+	TraceSync bool
+
+	// DenyHostBinary refuses processes which execute a binary from the host
+	// instead of one from the container.
+	DenyHostBinary bool
+
+	// FreezeTimeout is how long freezing or thawing the cgroups of a
+	// container may take, or zero to wait forever.
+	FreezeTimeout time.Duration
+
+	// SetnsRetries is how many times joining a namespace of a container is
+	// retried when setns fails with EBUSY, waiting SetnsBackoff at first and
+	// twice as long after each attempt.
+	SetnsRetries int
+	SetnsBackoff time.Duration
+
+	// Metrics receives the latencies of starting processes, running hooks
+	// and dumping containers, if set.
+	Metrics MetricsSink
+
+	// Events receives the events of the containers of the factory, if set.
+	// They are dropped while the receiver is not keeping up.
+	Events chan<- FactoryEvent
+
+	// initBinary is a copy of the running binary which processes are started
+	// from when /proc/self/exe cannot be executed.
+	initBinary *os.File
 }
 
 func (l *LinuxFactory) Create(id string, config *configs.Config) (Container, error) {
@@ -159,9 +314,24 @@ func (l *LinuxFactory) Create(id string, config *configs.Config) (Container, err
 	if err := l.validateID(id); err != nil {
 		return nil, err
 	}
-	if err := l.Validator.Validate(config); err != nil {
+	if err := applyDefaultDevices(config); err != nil {
+		return nil, newGenericError(err, SystemError)
+	}
+	warnings, err := l.validate(config)
+	if err != nil {
+		return nil, newGenericError(err, ConfigInvalid)
+	}
+	if err := checkBootstrap(config); err != nil {
 		return nil, newGenericError(err, ConfigInvalid)
 	}
+	if paths := exposedStateRoot(l.Root, config); config.StrictStateRoot && len(paths) > 0 {
+		return nil, newGenericError(fmt.Errorf("mounts expose the state root %s at %s", l.Root, strings.Join(paths, ", ")), ConfigInvalid)
+	}
+	for i := range config.Namespaces {
+		if config.Namespaces[i].File != nil {
+			config.Namespaces[i].FromFile = true
+		}
+	}
 	uid, err := config.HostRootUID()
 	if err != nil {
 		return nil, newGenericError(err, SystemError)
@@ -186,17 +356,44 @@ func (l *LinuxFactory) Create(id string, config *configs.Config) (Container, err
 		RootlessCgroups(l)
 	}
 	c := &linuxContainer{
-		id:            id,
-		root:          containerRoot,
-		config:        config,
-		initArgs:      l.InitArgs,
-		criuPath:      l.CriuPath,
-		cgroupManager: l.NewCgroupsManager(config.Cgroups, nil),
+		id:              id,
+		root:            containerRoot,
+		config:          config,
+		initArgs:        l.InitArgs,
+		initBinary:      l.initBinary,
+		criuPath:        l.CriuPath,
+		ownerHelperPath: l.OwnerHelperPath,
+		traceSync:       l.TraceSync,
+		denyHostBinary:  l.DenyHostBinary,
+		freezeTimeout:   l.FreezeTimeout,
+		setnsRetries:    l.SetnsRetries,
+		setnsBackoff:    l.SetnsBackoff,
+		metrics:         l.Metrics,
+		events:          l.Events,
+		cgroupManager:   l.NewCgroupsManager(config.Cgroups, nil),
+		lifetime:        &lifetime{},
 	}
 	c.state = &stoppedState{c: c}
+	for _, w := range warnings {
+		c.warn(w)
+	}
 	return c, nil
 }
 
+// validate validates config, and returns the warnings about it if the
+// Validator of the factory can return them rather than log them. The setns
+// retries of the factory are checked along, as they may have been set
+// without SetnsRetry.
+func (l *LinuxFactory) validate(config *configs.Config) ([]configs.Warning, error) {
+	if err := checkSetnsRetry(l.SetnsRetries, l.SetnsBackoff); err != nil {
+		return nil, err
+	}
+	if v, ok := l.Validator.(validate.Checker); ok {
+		return v.Check(config)
+	}
+	return nil, l.Validator.Validate(config)
+}
+
 func (l *LinuxFactory) Load(id string) (Container, error) {
 	if l.Root == "" {
 		return nil, newGenericError(fmt.Errorf("invalid root"), ConfigInvalid)
@@ -221,18 +418,128 @@ func (l *LinuxFactory) Load(id string) (Container, error) {
 		id:                   id,
 		config:               &state.Config,
 		initArgs:             l.InitArgs,
+		initBinary:           l.initBinary,
 		criuPath:             l.CriuPath,
+		ownerHelperPath:      l.OwnerHelperPath,
+		traceSync:            l.TraceSync,
+		denyHostBinary:       l.DenyHostBinary,
+		freezeTimeout:        l.FreezeTimeout,
+		setnsRetries:         l.SetnsRetries,
+		setnsBackoff:         l.SetnsBackoff,
+		metrics:              l.Metrics,
+		events:               l.Events,
 		cgroupManager:        l.NewCgroupsManager(state.Config.Cgroups, state.CgroupPaths),
 		root:                 containerRoot,
 		created:              state.Created,
-	}
+		hookAnnotations:      state.HookAnnotations,
+		initInfo:             state.Init,
+		schedIdle:            state.SchedIdle,
+		schedPolicies:        state.SchedPolicies,
+		pidFile:              state.PidFile,
+		exit:                 state.Exit,
+		stopCause:            state.StopCause,
+		staleCreated:         state.StaleCreated,
+	}
+	c.lifetime = shareLifetime(containerRoot, &lifetime{deadline: state.LifetimeDeadline, exceeded: state.LifetimeExceeded})
 	c.state = &loadedState{c: c}
+	if err := c.checkStaleCreated(); err != nil {
+		return nil, err
+	}
 	if err := c.refreshState(); err != nil {
 		return nil, err
 	}
+	c.resolveNamespaceFiles(state.NamespacePaths)
+	// The deadline is enforced again, whoever started the container.
+	c.armLifetime()
 	return c, nil
 }
 
+// resolveNamespaceFiles points the namespaces which were joined from a file
+// at the namespace of the init process, which is where the file led to, as
+// long as the init is alive. Otherwise nothing is left to rejoin, and a
+// warning reports it.
+func (c *linuxContainer) resolveNamespaceFiles(paths map[configs.NamespaceType]string) {
+	status, err := c.currentStatus()
+	if err != nil {
+		status = Stopped
+	}
+	for i := range c.config.Namespaces {
+		ns := &c.config.Namespaces[i]
+		if !ns.FromFile || ns.Path != "" {
+			continue
+		}
+		if p := paths[ns.Type]; status != Stopped && p != "" {
+			ns.Path = p
+			continue
+		}
+		c.warn(configs.Warning{
+			Code:      configs.WarnNamespaceUnavailable,
+			FieldPath: "namespaces",
+			Message:   fmt.Sprintf("%s namespace was joined from a file which is no longer available", configs.NsName(ns.Type)),
+		})
+	}
+}
+
+func (l *LinuxFactory) LoadAll() ([]Container, error) {
+	containers, err := l.loadAll()
+	if err != nil {
+		return nil, err
+	}
+	var loaded []Container
+	for _, c := range containers {
+		if err := c.adoptHandles(); err != nil {
+			logrus.Warnf("adopting the handles of container %s: %v", c.id, err)
+		}
+		loaded = append(loaded, c)
+	}
+	return loaded, nil
+}
+
+func (l *LinuxFactory) GC() ([]string, error) {
+	containers, err := l.loadAll()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, c := range containers {
+		if !c.staleCreated {
+			continue
+		}
+		if err := c.Destroy(); err != nil {
+			return removed, err
+		}
+		removed = append(removed, c.id)
+	}
+	return removed, nil
+}
+
+// loadAll loads every container in the root of the factory, skipping those
+// removed meanwhile.
+func (l *LinuxFactory) loadAll() ([]*linuxContainer, error) {
+	if l.Root == "" {
+		return nil, newGenericError(fmt.Errorf("invalid root"), ConfigInvalid)
+	}
+	entries, err := ioutil.ReadDir(l.Root)
+	if err != nil {
+		return nil, newGenericError(err, SystemError)
+	}
+	var containers []*linuxContainer
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		c, err := l.Load(e.Name())
+		if err != nil {
+			if lerr, ok := err.(Error); ok && lerr.Code() == ContainerNotExists {
+				continue
+			}
+			return nil, err
+		}
+		containers = append(containers, c.(*linuxContainer))
+	}
+	return containers, nil
+}
+
 func (l *LinuxFactory) Type() string {
 	return "libcontainer"
 }
@@ -246,13 +553,25 @@ func (l *LinuxFactory) StartInitialization() (err error) {
 		envInitPipe    = os.Getenv("_LIBCONTAINER_INITPIPE")
 		envStateDir    = os.Getenv("_LIBCONTAINER_STATEDIR")
 		envConsole     = os.Getenv("_LIBCONTAINER_CONSOLE")
+		envFdStore     = os.Getenv("_LIBCONTAINER_FDSTORE")
 	)
 
+	// The fd store of a container is started with no init pipe.
+	if envFdStore != "" {
+		return runFdStore(envFdStore)
+	}
+	// Neither is the holder of the user namespace of an idmapped mount.
+	if os.Getenv("_LIBCONTAINER_IDMAPNS") != "" {
+		return runIdmapNS()
+	}
+
 	// Get the INITPIPE.
 	pipefd, err = strconv.Atoi(envInitPipe)
 	if err != nil {
 		return fmt.Errorf("unable to convert _LIBCONTAINER_INITPIPE=%s to int: %s", envInitPipe, err)
 	}
+	// The process we execute must never be able to speak to the parent.
+	unix.CloseOnExec(pipefd)
 
 	var (
 		pipe = os.NewFile(uintptr(pipefd), "pipe")
@@ -284,7 +603,7 @@ func (l *LinuxFactory) StartInitialization() (err error) {
 	defer func() {
 		// We have an error during the initialization of the container's init,
 		// send it back to the parent process in the form of an initError.
-		if werr := utils.WriteJSON(pipe, syncT{procError}); werr != nil {
+		if werr := utils.WriteJSON(pipe, syncT{Type: procError}); werr != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return
 		}
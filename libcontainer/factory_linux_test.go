@@ -5,9 +5,11 @@ package libcontainer
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/mount"
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -206,3 +208,95 @@ type unserializableHook struct{}
 func (unserializableHook) Run(configs.HookState) error {
 	return nil
 }
+
+func TestFactoryGCStaleCreated(t *testing.T) {
+	root, err := newTestRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// The init process of both containers is gone.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	state := &State{BaseState: BaseState{
+		InitProcessPid:       cmd.Process.Pid,
+		InitProcessStartTime: 1,
+		Config:               configs.Config{Cgroups: &configs.Cgroup{}},
+	}}
+	for _, id := range []string{"stale", "stopped"} {
+		if err := os.Mkdir(filepath.Join(root, id), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := marshal(filepath.Join(root, id, stateFilename), state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fifo := filepath.Join(root, "stale", execFifoFilename)
+	if err := unix.Mkfifo(fifo, 0622); err != nil {
+		t.Fatal(err)
+	}
+	factory, err := New(root, Cgroupfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container, err := factory.Load("stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fifo); !os.IsNotExist(err) {
+		t.Fatalf("expected the exec fifo to be removed, got %v", err)
+	}
+	if status, err := container.Status(); err != nil || status != Stopped {
+		t.Fatalf("expected the container to be stopped, got %s (%v)", status, err)
+	}
+	for name, err := range map[string]error{
+		"Exec":  container.Exec(),
+		"Start": container.Start(&Process{Args: []string{"true"}}),
+	} {
+		if lerr, ok := err.(Error); !ok || lerr.Code() != ContainerStaleCreated {
+			t.Fatalf("expected %s to fail with ContainerStaleCreated, got %v", name, err)
+		}
+	}
+
+	removed, err := factory.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"stale"}) {
+		t.Fatalf("expected only the stale container to be removed, got %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(root, "stale")); !os.IsNotExist(err) {
+		t.Fatalf("expected the stale container to be destroyed, got %v", err)
+	}
+	if _, err := factory.Load("stopped"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetnsRetryBounds(t *testing.T) {
+	for _, tc := range []struct {
+		retries int
+		backoff time.Duration
+		valid   bool
+	}{
+		{DefaultSetnsRetries, DefaultSetnsBackoff, true},
+		{0, 0, true},
+		{DefaultSetnsRetries, MaxSetnsBackoff, true},
+		{-1, DefaultSetnsBackoff, false},
+		{DefaultSetnsRetries, -time.Millisecond, false},
+		{DefaultSetnsRetries, MaxSetnsBackoff + time.Microsecond, false},
+		{DefaultSetnsRetries, time.Hour, false},
+	} {
+		l := &LinuxFactory{}
+		err := SetnsRetry(tc.retries, tc.backoff)(l)
+		if tc.valid && err != nil {
+			t.Errorf("expected %d retries with a %s backoff to be valid, got %v", tc.retries, tc.backoff, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %d retries with a %s backoff to be refused", tc.retries, tc.backoff)
+		}
+	}
+}
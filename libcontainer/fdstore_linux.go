@@ -0,0 +1,394 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall" // only for SysProcAttr
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups/systemd"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// FdConsoleMaster is the name the master of the console of an init
+	// process with Terminal set is stored under in the fd store.
+	FdConsoleMaster = "console-master"
+
+	fdStoreFilename = "fdstore.sock"
+	// fdStoreMsgSize bounds the JSON of the messages of the fd store.
+	fdStoreMsgSize = 4096
+)
+
+// The operations of the fd store protocol.
+const (
+	fdStoreStore    = "store"
+	fdStoreRetrieve = "retrieve"
+	fdStoreStop     = "stop"
+)
+
+var (
+	// errNoFdStore is returned when the container has no fd store running.
+	errNoFdStore = errors.New("the container has no fd store")
+	// errFdNotStored is returned when no fd is stored under the name
+	// retrieved.
+	errFdNotStored = errors.New("fd not stored")
+)
+
+// fdStoreRequest is sent to the fd store, along with the fd to store for
+// fdStoreStore.
+type fdStoreRequest struct {
+	Op   string `json:"op"`
+	Name string `json:"name,omitempty"`
+}
+
+// fdStoreResponse answers an fdStoreRequest, along with the fd retrieved for
+// fdStoreRetrieve.
+type fdStoreResponse struct {
+	Error string `json:"error,omitempty"`
+	// Missing is set when no fd is stored under the name retrieved.
+	Missing bool `json:"missing,omitempty"`
+}
+
+func (c *linuxContainer) StoreFd(name string, f *os.File) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.storeFd(name, f)
+}
+
+// storeFd stores f under name, starting the fd store if the container has
+// none yet.
+func (c *linuxContainer) storeFd(name string, f *os.File) error {
+	if name == "" {
+		return newGenericError(fmt.Errorf("an fd cannot be stored without a name"), ConfigInvalid)
+	}
+	req := fdStoreRequest{Op: fdStoreStore, Name: name}
+	_, err := c.fdStoreCall(req, f)
+	if err == errNoFdStore {
+		if err := c.startFdStore(); err != nil {
+			return newSystemErrorWithCause(err, "starting fd store")
+		}
+		_, err = c.fdStoreCall(req, f)
+	}
+	if err != nil {
+		return newSystemErrorWithCause(err, fmt.Sprintf("storing fd %q", name))
+	}
+	for _, n := range c.storedFds {
+		if n == name {
+			return nil
+		}
+	}
+	c.storedFds = append(c.storedFds, name)
+	return nil
+}
+
+func (c *linuxContainer) RetrieveFd(name string) (*os.File, error) {
+	f, err := c.fdStoreCall(fdStoreRequest{Op: fdStoreRetrieve, Name: name}, nil)
+	if err == errNoFdStore || err == errFdNotStored {
+		return nil, newGenericError(fmt.Errorf("no fd named %q is stored", name), ConfigInvalid)
+	}
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, fmt.Sprintf("retrieving fd %q", name))
+	}
+	return f, nil
+}
+
+// stopFdStore stops the fd store of the container, if it has one, closing
+// the fds stored in it.
+func (c *linuxContainer) stopFdStore() error {
+	if _, err := c.fdStoreCall(fdStoreRequest{Op: fdStoreStop}, nil); err != nil && err != errNoFdStore {
+		return err
+	}
+	return nil
+}
+
+// startFdStore starts the fd store of the container, an init started with
+// _LIBCONTAINER_FDSTORE set to the unix socket it serves in the state
+// directory, where every message is a JSON object carrying at most one fd.
+// It runs in a session of its own so as to outlive us, and is only waited
+// for to be reaped if it exits before we do. Under systemd, it is moved to a
+// scope of its own, as the cgroup of a service is killed as a whole when it
+// stops with KillMode=control-group.
+func (c *linuxContainer) startFdStore() error {
+	path := filepath.Join(c.root, fdStoreFilename)
+	l, err := listenFdStore(path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	cmd := exec.Command(c.initArgs[0], c.initArgs[1:]...)
+	if c.initBinary != nil {
+		cmd.Path = initBinaryPath(c.initBinary)
+	}
+	cmd.Dir = "/"
+	cmd.ExtraFiles = []*os.File{l}
+	cmd.Env = []string{fmt.Sprintf("_LIBCONTAINER_FDSTORE=%d", stdioFdCount)}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	go cmd.Wait()
+	if systemd.UseSystemd() {
+		pid := cmd.Process.Pid
+		if err := systemd.StartScope(fmt.Sprintf("runc-fdstore-%d.scope", pid), pid); err != nil {
+			logrus.Warnf("unable to move the fd store of %s out of our cgroup: %v", c.id, err)
+		}
+	}
+	return nil
+}
+
+// listenFdStore creates the socket of an fd store at path. Only our uid can
+// connect to it. A socket already at path is left behind by an fd store
+// which died, as a new one is only started when nothing answers there.
+func listenFdStore(path string) (*os.File, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	l := os.NewFile(uintptr(fd), path)
+	if err := unix.Bind(fd, &unix.SockaddrUnix{Name: path}); err != nil {
+		l.Close()
+		return nil, err
+	}
+	// Nobody can connect before the socket listens.
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := unix.Listen(fd, 8); err != nil {
+		l.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return l, nil
+}
+
+// dialFdStore connects to the socket of the fd store at path.
+func dialFdStore(path string) (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	conn := os.NewFile(uintptr(fd), path)
+	if err := unix.Connect(fd, &unix.SockaddrUnix{Name: path}); err != nil {
+		conn.Close()
+		if err == unix.ENOENT || err == unix.ECONNREFUSED {
+			return nil, errNoFdStore
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// fdStoreCall sends req to the fd store of the container, along with f if
+// not nil, and returns the fd sent back, if any.
+func (c *linuxContainer) fdStoreCall(req fdStoreRequest, f *os.File) (*os.File, error) {
+	conn, err := dialFdStore(filepath.Join(c.root, fdStoreFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := sendFdStoreMsg(conn, req, f); err != nil {
+		return nil, err
+	}
+	var resp fdStoreResponse
+	got, err := recvFdStoreMsg(conn, &resp)
+	if err == io.EOF {
+		// The fd store hangs up on peers which do not have its uid.
+		return nil, fmt.Errorf("the fd store hung up")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Missing || resp.Error != "" {
+		if got != nil {
+			got.Close()
+		}
+		if resp.Missing {
+			return nil, errFdNotStored
+		}
+		return nil, errors.New(resp.Error)
+	}
+	return got, nil
+}
+
+func sendFdStoreMsg(conn *os.File, v interface{}, f *os.File) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var oob []byte
+	if f != nil {
+		oob = unix.UnixRights(int(f.Fd()))
+	}
+	return unix.Sendmsg(int(conn.Fd()), data, oob, nil, 0)
+}
+
+// recvFdStoreMsg decodes the next message on conn into v, and returns the
+// fd it carried, if any. It returns io.EOF once the peer hung up.
+func recvFdStoreMsg(conn *os.File, v interface{}) (*os.File, error) {
+	data := make([]byte, fdStoreMsgSize)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, flags, _, err := unix.Recvmsg(int(conn.Fd()), data, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 && oobn == 0 {
+		return nil, io.EOF
+	}
+	var f *os.File
+	if oobn > 0 {
+		scms, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, err
+		}
+		for i := range scms {
+			fds, err := unix.ParseUnixRights(&scms[i])
+			if err != nil {
+				continue
+			}
+			for _, fd := range fds {
+				if f != nil {
+					unix.Close(fd)
+					continue
+				}
+				f = os.NewFile(uintptr(fd), "fdstore")
+			}
+		}
+	}
+	if flags&(unix.MSG_TRUNC|unix.MSG_CTRUNC) != 0 {
+		err = fmt.Errorf("fd store message truncated")
+	} else {
+		err = json.Unmarshal(data[:n], v)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// runFdStore is the init of an fd store, serving the socket passed as
+// envFdStore. It only returns on error.
+func runFdStore(envFdStore string) error {
+	fd, err := strconv.Atoi(envFdStore)
+	if err != nil {
+		return fmt.Errorf("unable to convert _LIBCONTAINER_FDSTORE=%s to int: %s", envFdStore, err)
+	}
+	os.Clearenv()
+	if err := serveFdStore(os.NewFile(uintptr(fd), "fdstore")); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// serveFdStore holds the fds stored through the listening socket l until it
+// is told to stop. The peers must have our uid. The socket is removed once
+// we stop serving it.
+func serveFdStore(l *os.File) error {
+	defer l.Close()
+	path := ""
+	if sa, err := unix.Getsockname(int(l.Fd())); err == nil {
+		if addr, ok := sa.(*unix.SockaddrUnix); ok {
+			path = addr.Name
+		}
+	}
+	defer func() {
+		if path != "" {
+			os.Remove(path)
+		}
+	}()
+	owner := os.Geteuid()
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for {
+		fd, _, err := unix.Accept4(int(l.Fd()), unix.SOCK_CLOEXEC)
+		if err != nil {
+			if err == unix.EINTR || err == unix.ECONNABORTED {
+				continue
+			}
+			return err
+		}
+		conn := os.NewFile(uintptr(fd), "fdstore-conn")
+		stop := serveFdStoreConn(conn, owner, files, path)
+		conn.Close()
+		if stop {
+			// Already removed before answering.
+			path = ""
+			return nil
+		}
+	}
+}
+
+// serveFdStoreConn answers the requests of a single peer until it hangs up,
+// and returns whether it asked the fd store to stop. The socket at path is
+// removed before a stop is answered, so that a new fd store can be started
+// there as soon as it returns.
+func serveFdStoreConn(conn *os.File, owner int, files map[string]*os.File, path string) bool {
+	cred, err := unix.GetsockoptUcred(int(conn.Fd()), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil || int(cred.Uid) != owner {
+		return false
+	}
+	for {
+		var req fdStoreRequest
+		f, err := recvFdStoreMsg(conn, &req)
+		if err != nil {
+			return false
+		}
+		var (
+			resp fdStoreResponse
+			out  *os.File
+		)
+		switch req.Op {
+		case fdStoreStore:
+			if f == nil || req.Name == "" {
+				resp.Error = "an fd and a name are needed to store an fd"
+				break
+			}
+			if old := files[req.Name]; old != nil {
+				old.Close()
+			}
+			files[req.Name] = f
+			f = nil
+		case fdStoreRetrieve:
+			out = files[req.Name]
+			resp.Missing = out == nil
+		case fdStoreStop:
+			if path != "" {
+				os.Remove(path)
+			}
+		default:
+			resp.Error = fmt.Sprintf("unknown fd store operation %q", req.Op)
+		}
+		if f != nil {
+			f.Close()
+		}
+		if err := sendFdStoreMsg(conn, resp, out); err != nil {
+			return req.Op == fdStoreStop
+		}
+		if req.Op == fdStoreStop {
+			return true
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFdStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "fdstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	c := &linuxContainer{id: "test", root: root}
+	if _, err := c.RetrieveFd("pipe"); err == nil {
+		t.Fatal("expected retrieving from a container with no fd store to fail")
+	}
+
+	l, err := listenFdStore(filepath.Join(root, fdStoreFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- serveFdStore(l) }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := c.StoreFd("pipe", r); err != nil {
+		t.Fatal(err)
+	}
+	// The fd store holds its own copy.
+	r.Close()
+	got, err := c.RetrieveFd("pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Close()
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	if _, err := got.Read(buf); err != nil || buf[0] != 'x' {
+		t.Fatalf("expected to read from the retrieved pipe, got %q, %v", buf, err)
+	}
+
+	_, err = c.RetrieveFd("missing")
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a ConfigInvalid error, got %v", err)
+	}
+	if err := c.StoreFd("", w); err == nil {
+		t.Fatal("expected an fd without a name to be refused")
+	}
+
+	if err := c.stopFdStore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, fdStoreFilename)); !os.IsNotExist(err) {
+		t.Fatalf("expected the fd store to remove its socket, got %v", err)
+	}
+	if _, err := c.RetrieveFd("pipe"); err == nil {
+		t.Fatal("expected the fd store to be stopped")
+	}
+	if err := c.stopFdStore(); err != nil {
+		t.Fatalf("expected stopping a stopped fd store to succeed: %v", err)
+	}
+}
+
+func TestFdStoreReplacesStaleSocket(t *testing.T) {
+	root, err := ioutil.TempDir("", "fdstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	path := filepath.Join(root, fdStoreFilename)
+	// The socket of an fd store which died without removing it.
+	stale, err := listenFdStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+	c := &linuxContainer{id: "test", root: root}
+	if _, err := c.RetrieveFd("pipe"); err == nil {
+		t.Fatal("expected retrieving from a dead fd store to fail")
+	}
+
+	l, err := listenFdStore(path)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- serveFdStore(l) }()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if err := c.StoreFd("pipe", r); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.stopFdStore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the fd store to remove its socket, got %v", err)
+	}
+}
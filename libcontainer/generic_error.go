@@ -6,6 +6,7 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/stacktrace"
 )
 
@@ -90,3 +91,33 @@ func (e *genericError) Code() ErrorCode {
 func (e *genericError) Detail(w io.Writer) error {
 	return errorTemplate.Execute(w, e)
 }
+
+// IsHookResponseError returns true if err was caused by a hook writing a
+// malformed response.
+func IsHookResponseError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *configs.HookResponseError:
+			return true
+		case *genericError:
+			err = e.Err
+		default:
+			return false
+		}
+	}
+}
+
+// IsHookTimeoutError returns true if err was caused by a hook running past
+// its timeout.
+func IsHookTimeoutError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *configs.HookTimeoutError:
+			return true
+		case *genericError:
+			err = e.Err
+		default:
+			return false
+		}
+	}
+}
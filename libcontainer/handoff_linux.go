@@ -0,0 +1,378 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall" // only for WaitStatus
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/opencontainers/runc/libcontainer/utils"
+
+	"golang.org/x/sys/unix"
+)
+
+// handoffFilename is the file of the state directory PrepareShutdown saves
+// the live handles of the container to, for LoadAll to adopt them.
+const handoffFilename = "handoff.json"
+
+// ErrExitStatusLost is returned by waiting for a process adopted by LoadAll
+// once it exited, if it is not a child of the caller: its exit status went
+// to its parent.
+var ErrExitStatusLost = errors.New("the exit status of the adopted process is lost")
+
+// ShutdownReport is what PrepareShutdown saved of the live handles of a
+// container, and what it could not.
+type ShutdownReport struct {
+	// WaitInit is set if the init process is adopted, as it was started by
+	// the caller and has not been waited for.
+	WaitInit bool
+
+	// Execs are the pids of the processes started in the container which
+	// are adopted.
+	Execs []int
+
+	// StoredFds are the names of the fds in the fd store of the container,
+	// which outlives the caller.
+	StoredFds []string
+
+	// Lost describes every handle which cannot be restored, such as output
+	// retained in memory, for the caller to log.
+	Lost []string
+}
+
+// handoff is the content of the handoff file.
+type handoff struct {
+	Init         *initHandle         `json:"init,omitempty"`
+	Execs        []execHandle        `json:"execs,omitempty"`
+	StoredFds    []string            `json:"stored_fds,omitempty"`
+	CgroupEvents *cgroupEventsCursor `json:"cgroup_events,omitempty"`
+	Warnings     []configs.Warning   `json:"warnings,omitempty"`
+}
+
+// initHandle is an init process which has not been waited for.
+type initHandle struct {
+	Pid        int      `json:"pid"`
+	StartTime  uint64   `json:"start_time"`
+	ExitFile   string   `json:"exit_file,omitempty"`
+	WaitMode   WaitMode `json:"wait_mode,omitempty"`
+	SharePidns bool     `json:"share_pidns,omitempty"`
+	// OOMBaseline is the number of OOM kills counted by the memory cgroup
+	// when the process started, if OOMCounted is set.
+	OOMBaseline uint64 `json:"oom_baseline,omitempty"`
+	OOMCounted  bool   `json:"oom_counted,omitempty"`
+}
+
+// execHandle is a process started in the container.
+type execHandle struct {
+	Pid       int      `json:"pid"`
+	StartTime uint64   `json:"start_time"`
+	Args      []string `json:"args,omitempty"`
+	// lost describes the handles of the process which cannot be restored.
+	lost []string
+}
+
+// cgroupEventsCursor is the state of the cgroups of the container last
+// known to the receivers of NotifyCgroupEvents. Populated is only known on
+// cgroup v2.
+type cgroupEventsCursor struct {
+	Populated bool `json:"populated"`
+	Frozen    bool `json:"frozen"`
+}
+
+func (c *linuxContainer) PrepareShutdown() (*ShutdownReport, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	h := &handoff{
+		Init:      c.pendingInit(),
+		StoredFds: c.storedFds,
+		Warnings:  c.Warnings(),
+	}
+	report := &ShutdownReport{
+		WaitInit:  h.Init != nil,
+		StoredFds: c.storedFds,
+	}
+	c.execs = liveExecs(c.execs)
+	for _, e := range c.execs {
+		h.Execs = append(h.Execs, e)
+		report.Execs = append(report.Execs, e.Pid)
+		report.Lost = append(report.Lost, e.lost...)
+	}
+	if c.output != nil {
+		report.Lost = append(report.Lost, "the output of the init process retained in memory")
+	}
+	cursor, err := c.cgroupEventsCursor()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "reading cgroup events")
+	}
+	h.CgroupEvents = cursor
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "encoding handles")
+	}
+	if err := utils.AtomicWriteFile(filepath.Join(c.root, handoffFilename), data, 0600); err != nil {
+		return nil, newSystemErrorWithCause(err, "writing handles")
+	}
+	return report, nil
+}
+
+func (c *linuxContainer) AdoptedProcesses() []*Process {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return append([]*Process(nil), c.adopted...)
+}
+
+// pendingInit returns the init process of the container if the caller has
+// to wait for it, as it started it or adopted it and did not reap it.
+func (c *linuxContainer) pendingInit() *initHandle {
+	if h := c.adoptedInit; h != nil {
+		if processExists(h.Pid, h.StartTime) {
+			return h
+		}
+		return nil
+	}
+	p, ok := c.initProcess.(*initProcess)
+	if !ok {
+		return nil
+	}
+	startTime, err := p.startTime()
+	if err != nil || !processExists(p.pid(), startTime) {
+		return nil
+	}
+	h := &initHandle{
+		Pid:        p.pid(),
+		StartTime:  startTime,
+		ExitFile:   p.exitFile,
+		WaitMode:   p.waitMode,
+		SharePidns: p.sharePidns,
+	}
+	if p.oom != nil {
+		h.OOMBaseline, h.OOMCounted = p.oom.baseline, p.oom.counted
+	}
+	return h
+}
+
+// trackExec records process, which parent started in the container, for
+// PrepareShutdown, forgetting those which were reaped since.
+func (c *linuxContainer) trackExec(process *Process, parent parentProcess) {
+	startTime, err := parent.startTime()
+	if err != nil {
+		logrus.Debugf("getting the start time of process %d: %v", parent.pid(), err)
+		return
+	}
+	h := execHandle{Pid: parent.pid(), StartTime: startTime, Args: process.Args}
+	if process.output != nil {
+		h.lost = append(h.lost, fmt.Sprintf("the output of process %d retained in memory", h.Pid))
+	}
+	if process.console != nil {
+		h.lost = append(h.lost, fmt.Sprintf("the console of process %d", h.Pid))
+	} else if copiesStdio(process) {
+		h.lost = append(h.lost, fmt.Sprintf("the stdio of process %d, copied through the caller", h.Pid))
+	}
+	c.execs = append(liveExecs(c.execs), h)
+}
+
+// copiesStdio returns true if the stdio of process is copied by the caller
+// rather than handed to the process.
+func copiesStdio(process *Process) bool {
+	for _, s := range []interface{}{process.Stdin, process.Stdout, process.Stderr} {
+		if s == nil {
+			continue
+		}
+		if _, ok := s.(*os.File); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// liveExecs returns those of execs which have not been reaped.
+func liveExecs(execs []execHandle) []execHandle {
+	var live []execHandle
+	for _, e := range execs {
+		if processExists(e.Pid, e.StartTime) {
+			live = append(live, e)
+		}
+	}
+	return live
+}
+
+// processExists returns true if pid still refers to the process started at
+// startTime, even if it exited and waits to be reaped.
+func processExists(pid int, startTime uint64) bool {
+	stat, err := system.Stat(pid)
+	return err == nil && stat.StartTime == startTime && stat.State != system.Dead
+}
+
+// cgroupEventsCursor returns the state of the cgroups of the container the
+// receivers of NotifyCgroupEvents know of by now, or nil if they are gone.
+func (c *linuxContainer) cgroupEventsCursor() (*cgroupEventsCursor, error) {
+	if path := cgroupEventsPath(c.cgroupManager.GetPaths()); path != "" {
+		events, err := readCgroupEvents(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return &cgroupEventsCursor{Populated: events.populated, Frozen: events.frozen}, nil
+	}
+	paused, err := c.isPaused()
+	if err != nil {
+		return nil, err
+	}
+	return &cgroupEventsCursor{Frozen: paused}, nil
+}
+
+// adoptHandles adopts the handles PrepareShutdown saved for the container,
+// if any, which only the first caller gets.
+func (c *linuxContainer) adoptHandles() error {
+	path := filepath.Join(c.root, handoffFilename)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	var h handoff
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.storedFds = h.StoredFds
+	c.eventsMu.Lock()
+	c.eventsCursor = h.CgroupEvents
+	c.eventsMu.Unlock()
+	c.warningsMu.Lock()
+	c.warnings = append(h.Warnings, c.warnings...)
+	c.warningsMu.Unlock()
+	if ih := h.Init; ih != nil && processExists(ih.Pid, ih.StartTime) {
+		c.adoptedInit = ih
+		c.adopted = append(c.adopted, c.adoptInit(ih))
+	}
+	for _, e := range h.Execs {
+		if !processExists(e.Pid, e.StartTime) {
+			continue
+		}
+		c.execs = append(c.execs, e)
+		p := &Process{Args: e.Args}
+		p.setOps(&adoptedProcess{nonChildProcess: nonChildProcess{processPid: e.Pid, processStartTime: e.StartTime}})
+		c.adopted = append(c.adopted, p)
+	}
+	return nil
+}
+
+// adoptInit returns the Process the init process of h is waited for with,
+// which records its exit as when it was started.
+func (c *linuxContainer) adoptInit(h *initHandle) *Process {
+	var oom *oomWatcher
+	if !c.config.Rootless {
+		oom = resumeOOMWatch(c.cgroupManager.GetPaths(), h.OOMBaseline, h.OOMCounted)
+	}
+	p := &Process{}
+	p.setOps(&adoptedProcess{
+		nonChildProcess: nonChildProcess{processPid: h.Pid, processStartTime: h.StartTime},
+		exited: func(state *os.ProcessState) error {
+			return c.adoptedInitExited(h, oom, state)
+		},
+	})
+	return p
+}
+
+// adoptedInitExited does for the adopted init process of h what waiting for
+// an init process does once it is reaped. state is nil if the status of the
+// process was lost.
+func (c *linuxContainer) adoptedInitExited(h *initHandle, oom *oomWatcher, state *os.ProcessState) error {
+	c.notifyStopped(h.Pid)
+	c.lifetime.disarm()
+	if h.SharePidns && c.killCgroupProcessesOnExit() {
+		c.signalAllProcesses(unix.SIGKILL, c.killExemptPids())
+	}
+	if h.WaitMode == WaitCgroupEmpty {
+		if err := waitCgroupEmpty(c.cgroupManager); err != nil {
+			return newSystemErrorWithCause(err, "waiting for the container's cgroups to be empty")
+		}
+	}
+	if state == nil {
+		exit := &ExitStatus{
+			Pid:     h.Pid,
+			Status:  -1,
+			Exited:  time.Now(),
+			Cause:   c.exitCause(),
+			Unknown: true,
+		}
+		c.recordExit(exit)
+		if err := writeExitFile(h.ExitFile, exit); err != nil {
+			return newSystemErrorWithCause(err, "writing exit file")
+		}
+		return nil
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return newSystemError(fmt.Errorf("unexpected wait status %T", state.Sys()))
+	}
+	exit := &ExitStatus{
+		Pid:       h.Pid,
+		Status:    utils.ExitStatus(unix.WaitStatus(ws)),
+		Exited:    time.Now(),
+		OOMKilled: oom.killed(ws),
+		Cause:     c.exitCause(),
+	}
+	if ws.Signaled() {
+		exit.Signal = int(ws.Signal())
+	}
+	c.recordExit(exit)
+	if err := writeExitFile(h.ExitFile, exit); err != nil {
+		return newSystemErrorWithCause(err, "writing exit file")
+	}
+	return nil
+}
+
+// adoptedProcess is a process started in a container before the caller was
+// restarted. It is waited for as a child if it still is one, as after an
+// in-place restart, and through a pidfd otherwise.
+type adoptedProcess struct {
+	nonChildProcess
+	// exited is called with the state of the process once it is reaped, or
+	// with nil once it exited if its status is lost.
+	exited func(*os.ProcessState) error
+}
+
+func (p *adoptedProcess) wait() (*os.ProcessState, error) {
+	if processExists(p.processPid, p.processStartTime) {
+		if proc, err := os.FindProcess(p.processPid); err == nil {
+			state, err := proc.Wait()
+			if !isNoChildren(err) {
+				if err == nil && p.exited != nil {
+					err = p.exited(state)
+				}
+				return state, err
+			}
+		}
+		if err := waitPidfd(p.processPid, p.processStartTime); err != nil {
+			logrus.Debugf("cannot wait on pidfd for pid %d: %v", p.processPid, err)
+			for processAlive(p.processPid, p.processStartTime) {
+				time.Sleep(stopPollInterval)
+			}
+		}
+	}
+	if p.exited != nil {
+		if err := p.exited(nil); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrExitStatusLost
+}
@@ -0,0 +1,175 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCgroupEventsChanges(t *testing.T) {
+	old := cgroupEvents{populated: true}
+	if changes := cgroupEventsChanges(old, old); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", changes)
+	}
+	changes := cgroupEventsChanges(old, cgroupEvents{frozen: true})
+	expected := []CgroupEvent{{Type: CgroupFrozen, Value: true}, {Type: CgroupPopulated, Value: false}}
+	if len(changes) != len(expected) || changes[0] != expected[0] || changes[1] != expected[1] {
+		t.Fatalf("expected %+v, got %+v", expected, changes)
+	}
+}
+
+func TestWatchCgroupEventsSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cgroup.events")
+	if err := ioutil.WriteFile(path, []byte("populated 0\nfrozen 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := watchCgroupEvents(path, &cgroupEvents{populated: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, _ := receiveCgroupEvent(t, ch); ev != (CgroupEvent{Type: CgroupPopulated, Value: false}) {
+		t.Fatalf("expected the change since the cursor, got %+v", ev)
+	}
+	os.Remove(path)
+	if ev, ok := receiveCgroupEvent(t, ch); ok {
+		t.Fatalf("expected the channel to be closed, got %+v", ev)
+	}
+}
+
+func TestPrepareShutdownAdopt(t *testing.T) {
+	root, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	cmd := exec.Command("sleep", "100")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	stat, err := system.Stat(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &linuxContainer{
+		root:          root,
+		config:        &configs.Config{},
+		cgroupManager: &mockCgroupManager{},
+		initProcess:   &mockProcess{},
+		execs: []execHandle{{
+			Pid:       cmd.Process.Pid,
+			StartTime: stat.StartTime,
+			Args:      []string{"sleep", "100"},
+			lost:      []string{"the stdio"},
+		}},
+		storedFds: []string{FdConsoleMaster},
+	}
+	report, err := c.PrepareShutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.WaitInit || len(report.Execs) != 1 || report.Execs[0] != cmd.Process.Pid {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.StoredFds) != 1 || len(report.Lost) != 1 || report.Lost[0] != "the stdio" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	adopter := &linuxContainer{
+		root:          root,
+		config:        &configs.Config{},
+		cgroupManager: &mockCgroupManager{},
+	}
+	if err := adopter.adoptHandles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, handoffFilename)); !os.IsNotExist(err) {
+		t.Fatalf("expected the handoff file to be removed, got %v", err)
+	}
+	if len(adopter.storedFds) != 1 || adopter.storedFds[0] != FdConsoleMaster {
+		t.Fatalf("expected the stored fds to be adopted, got %v", adopter.storedFds)
+	}
+	adopted := adopter.AdoptedProcesses()
+	if len(adopted) != 1 {
+		t.Fatalf("expected 1 adopted process, got %d", len(adopted))
+	}
+	pid, err := adopted[0].Pid()
+	if err != nil || pid != cmd.Process.Pid {
+		t.Fatalf("expected pid %d, got %d (%v)", cmd.Process.Pid, pid, err)
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	// The process is still a child of the test, so its status is not lost.
+	state, err := adopted[0].Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Success() {
+		t.Fatal("expected the killed process to fail")
+	}
+}
+
+func TestAdoptedInitNotAChild(t *testing.T) {
+	root, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// The sleep is orphaned once the shell exits, so it is not a child of
+	// the test, as an init process adopted after a restart need not be.
+	out, err := exec.Command("sh", "-c", "sleep 100 >/dev/null 2>&1 & echo $!").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := system.Stat(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitFile := filepath.Join(root, "exit.json")
+	c := &linuxContainer{
+		root:          root,
+		config:        &configs.Config{Rootless: true},
+		cgroupManager: &mockCgroupManager{},
+	}
+	p := c.adoptInit(&initHandle{Pid: pid, StartTime: stat.StartTime, ExitFile: exitFile})
+	if err := unix.Kill(pid, unix.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Wait(); err != ErrExitStatusLost {
+		t.Fatalf("expected the exit status to be lost, got %v", err)
+	}
+	// What follows the exit of the init process is still done.
+	data, err := ioutil.ReadFile(exitFile)
+	if err != nil {
+		t.Fatalf("expected the exit file to be written: %v", err)
+	}
+	var exit ExitStatus
+	if err := json.Unmarshal(data, &exit); err != nil {
+		t.Fatal(err)
+	}
+	if !exit.Unknown || exit.Pid != pid || c.exit == nil || !c.exit.Unknown {
+		t.Fatalf("expected an unknown exit status to be recorded, got %+v and %+v", exit, c.exit)
+	}
+}
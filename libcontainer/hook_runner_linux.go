@@ -0,0 +1,263 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall" // only for Exec
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/utils"
+
+	"golang.org/x/sys/unix"
+)
+
+// hookRunnerConfig tells the init in hook-runner mode how to set up the
+// sandbox of a hook before executing it.
+type hookRunnerConfig struct {
+	// Path is the binary of the hook, run with the Args and Env of the
+	// initConfig.
+	Path string `json:"path"`
+	// Root is the directory the root of the mount namespace of the hook is
+	// built in, if it has one.
+	Root string `json:"root,omitempty"`
+	// Mounts are the paths of the host bind-mounted read-only in Root.
+	Mounts []string `json:"mounts,omitempty"`
+}
+
+// runSandboxedHook runs the command hook cmd confined as its Sandbox asks.
+// Rather than being executed directly, the hook is run through nsexec,
+// which puts it in a mount namespace of its own if it needs one, and the
+// init in hook-runner mode, which sets up the sandbox and executes it.
+func (c *linuxContainer) runSandboxedHook(cmd configs.Command, s configs.HookState) (*configs.HookResponse, error) {
+	sandbox := cmd.Sandbox
+	state, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	config := &initConfig{
+		Args:            cmd.Args,
+		Env:             cmd.Env,
+		Cwd:             cmd.Dir,
+		Capabilities:    sandbox.Capabilities,
+		NoNewPrivileges: sandbox.NoNewPrivileges,
+		Hook:            &hookRunnerConfig{Path: cmd.Path},
+	}
+	var cloneFlags uintptr
+	if sandbox.MountNamespace {
+		// The tmpfs the root is made of only exists in the namespace of the
+		// hook, the directory is merely where it is mounted.
+		root, err := ioutil.TempDir("", "runc-hook")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(root)
+		config.Hook.Root = root
+		config.Hook.Mounts = append([]string{cmd.Path}, sandbox.Paths...)
+		if s.Bundle != "" {
+			config.Hook.Mounts = append(config.Hook.Mounts, s.Bundle)
+		}
+		cloneFlags = unix.CLONE_NEWNS
+	}
+	data, err := c.bootstrapData(cloneFlags, nil, sandbox.Rlimits)
+	if err != nil {
+		return nil, err
+	}
+
+	parentPipe, childPipe, err := newInitPipe()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "creating hook pipe")
+	}
+	defer parentPipe.Close()
+	var stdout bytes.Buffer
+	output := &configs.HookOutputBuffer{W: cmd.Output}
+	tmpl, err := c.commandTemplate(&Process{
+		Stdin:  bytes.NewReader(state),
+		Stdout: io.MultiWriter(&stdout, output),
+		Stderr: output,
+	}, childPipe)
+	if err != nil {
+		childPipe.Close()
+		return nil, err
+	}
+	tmpl.Dir = ""
+	tmpl.Env = append(tmpl.Env, "_LIBCONTAINER_INITTYPE="+string(initHook))
+	stub := &stubbedProcess{stubCmd: tmpl}
+	err = stub.start()
+	childPipe.Close()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "starting hook runner")
+	}
+	if err := startHookRunner(stub, parentPipe, data, config, sandbox.Rlimits); err != nil {
+		stub.kill()
+		stub.waitContainer()
+		return nil, err
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		state, err := stub.waitContainer()
+		if err == nil && !state.Success() {
+			err = &exec.ExitError{ProcessState: state}
+		}
+		if err != nil {
+			err = fmt.Errorf("error running hook: %v, output: %s", err, output)
+		}
+		errC <- err
+	}()
+	var timerCh <-chan time.Time
+	if cmd.Timeout != nil {
+		timer := time.NewTimer(*cmd.Timeout)
+		defer timer.Stop()
+		timerCh = timer.C
+	}
+	select {
+	case err := <-errC:
+		if err != nil {
+			return nil, err
+		}
+		return configs.ParseHookResponse(stdout.Bytes())
+	case <-timerCh:
+		killHookGroup(stub)
+		<-errC
+		return nil, &configs.HookTimeoutError{Timeout: *cmd.Timeout, Output: output.String()}
+	}
+}
+
+// killHookGroup kills the hook run by stub along with the processes it
+// forked, which are in the process group it leads as the container process.
+func killHookGroup(stub *stubbedProcess) {
+	if stub.containerProc != nil {
+		if err := unix.Kill(-stub.containerProc.Pid, unix.SIGKILL); err == nil {
+			return
+		}
+	}
+	stub.kill()
+}
+
+// startHookRunner hands the bootstrap data and config to the stub, and
+// returns once the hook runner executed the hook.
+func startHookRunner(stub *stubbedProcess, pipe *os.File, data io.Reader, config *initConfig, rlimits []configs.Rlimit) error {
+	if _, err := io.Copy(pipe, data); err != nil {
+		return newSystemErrorWithCause(err, "copying bootstrap data to pipe")
+	}
+	if err := stub.execSetns(pipe); err != nil {
+		return newSystemErrorWithCause(err, "executing hook runner")
+	}
+	if err := setupRlimits(rlimits, stub.pid()); err != nil {
+		return newSystemErrorWithCause(err, "setting rlimits for hook")
+	}
+	if err := utils.WriteJSON(pipe, config); err != nil {
+		return newSystemErrorWithCause(err, "writing config to pipe")
+	}
+	// The pipe is closed on exec, the runner only writes to it on failure.
+	return parseSync(pipe, func(*syncT) error {
+		return newSystemError(fmt.Errorf("invalid JSON payload from hook runner"))
+	})
+}
+
+// linuxHookInit is the init in hook-runner mode, which sets up the sandbox
+// of a hook and executes it.
+type linuxHookInit struct {
+	config *initConfig
+}
+
+func (l *linuxHookInit) Init() error {
+	hook := l.config.Hook
+	if hook == nil {
+		return fmt.Errorf("no hook to run")
+	}
+	if hook.Root != "" {
+		if err := setupHookRoot(hook.Root, hook.Mounts); err != nil {
+			return newSystemErrorWithCause(err, "setting up hook root")
+		}
+	}
+	if l.config.Cwd != "" {
+		if err := unix.Chdir(l.config.Cwd); err != nil {
+			return newSystemErrorWithCausef(err, "changing to hook directory %s", l.config.Cwd)
+		}
+	}
+	if l.config.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return err
+		}
+	}
+	if l.config.Capabilities != nil {
+		w, err := newContainerCapList(l.config.Capabilities)
+		if err != nil {
+			return err
+		}
+		if err := w.ApplyBoundingSet(); err != nil {
+			return err
+		}
+		if err := w.ApplyCaps(); err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(hook.Path, l.config.Args, l.config.Env)
+}
+
+// setupHookRoot makes a tmpfs mounted on root, holding the given paths of
+// the host bind-mounted read-only, the root of our mount namespace.
+func setupHookRoot(root string, paths []string) error {
+	// Nothing done in the namespace must reach the host.
+	if err := unix.Mount("", "/", "", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=755"); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := bindHookPath(root, p); err != nil {
+			return fmt.Errorf("mounting %s: %v", p, err)
+		}
+	}
+	if err := unix.Mount("", root, "", unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return err
+	}
+	return pivotRoot(root)
+}
+
+// bindHookPath bind-mounts p read-only at the same path below root. Only
+// the mount p is on is bound, so that what is mounted below it on the host
+// cannot be written to through the bind mount.
+func bindHookPath(root, p string) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(root, p)
+	if fi.IsDir() {
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dest, os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	if err := unix.Mount(p, dest, "", unix.MS_BIND, ""); err != nil {
+		return err
+	}
+	// The flags locked on the source mount have to be kept for the remount
+	// to be allowed.
+	var st unix.Statfs_t
+	if err := unix.Statfs(p, &st); err != nil {
+		return err
+	}
+	flags := uintptr(st.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	return unix.Mount("", dest, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|flags, "")
+}
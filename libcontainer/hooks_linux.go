@@ -0,0 +1,182 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"golang.org/x/sys/unix"
+)
+
+// newHookState returns the state to pass to the hooks of the container.
+func (c *linuxContainer) newHookState(status string, pid int) configs.HookState {
+	version := c.config.Version
+	if version == "" {
+		version = specs.Version
+	}
+	bundle, annotations := utils.Annotations(c.config.Labels)
+	for k, v := range c.hookAnnotations {
+		annotations[k] = v
+	}
+	return configs.HookState{
+		Version:     version,
+		ID:          c.id,
+		Status:      status,
+		Pid:         pid,
+		Bundle:      bundle,
+		Annotations: annotations,
+	}
+}
+
+// runHooks runs the given hooks in order. Annotations returned by a hook are
+// merged into the container, so that they are seen by the hooks that follow
+// and recorded in the container state.
+func (c *linuxContainer) runHooks(name string, hooks []configs.Hook, s configs.HookState) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	if err := c.scrubInheritedFds(); err != nil {
+		return newSystemErrorWithCause(err, "marking inherited descriptors close-on-exec")
+	}
+	for i, hook := range hooks {
+		if err := c.runHookAt(name, i, hook, &s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnHooks runs the given hooks like runHooks, except that a hook failing
+// does not stop the ones after it, as the OCI runtime spec has it for the
+// poststart hooks. The failures are logged and returned as warnings.
+func (c *linuxContainer) warnHooks(name string, hooks []configs.Hook, s configs.HookState) []string {
+	if len(hooks) == 0 {
+		return nil
+	}
+	if err := c.scrubInheritedFds(); err != nil {
+		err = newSystemErrorWithCausef(err, "marking inherited descriptors close-on-exec before %s hooks", name)
+		c.warn(configs.Warning{Code: configs.WarnHookFailed, FieldPath: "hooks." + name, Message: err.Error()})
+		return []string{err.Error()}
+	}
+	var warnings []string
+	for i, hook := range hooks {
+		if err := c.runHookAt(name, i, hook, &s); err != nil {
+			c.warn(configs.Warning{Code: configs.WarnHookFailed, FieldPath: hookField(name, i), Message: err.Error()})
+			warnings = append(warnings, err.Error())
+		}
+	}
+	return warnings
+}
+
+// hookField returns the field path of the i-th of the name hooks.
+func hookField(name string, i int) string {
+	return fmt.Sprintf("hooks.%s[%d]", name, i)
+}
+
+// runHookAt runs hook, the i-th of the name hooks, and merges the
+// annotations it returns into the container and s.
+func (c *linuxContainer) runHookAt(name string, i int, hook configs.Hook, s *configs.HookState) error {
+	timer := startTimer(c.metrics)
+	resp, err := c.runHook(hook, *s)
+	if err != nil {
+		return newSystemErrorWithCausef(err, "running %s hook %d", name, i)
+	}
+	timer.observeHook(name, i)
+	if resp == nil {
+		return nil
+	}
+	for _, w := range resp.Warnings {
+		c.warn(configs.Warning{Code: configs.WarnHook, FieldPath: hookField(name, i), Message: w})
+	}
+	if len(resp.Annotations) == 0 {
+		return nil
+	}
+	if c.hookAnnotations == nil {
+		c.hookAnnotations = make(map[string]string)
+	}
+	if s.Annotations == nil {
+		s.Annotations = make(map[string]string)
+	}
+	for k, v := range resp.Annotations {
+		c.hookAnnotations[k] = v
+		s.Annotations[k] = v
+	}
+	return nil
+}
+
+// runHook runs hook, through the hook runner if it is a command hook with a
+// sandbox, and returns its response if it gave one. The output of command
+// hooks goes to the HookOutput of the config unless they have their own.
+func (c *linuxContainer) runHook(hook configs.Hook, s configs.HookState) (*configs.HookResponse, error) {
+	if ch, ok := hook.(configs.CommandHook); ok {
+		if ch.Output == nil {
+			ch.Output = c.config.HookOutput
+		}
+		if ch.Sandbox != nil {
+			return c.runSandboxedHook(ch.Command, s)
+		}
+		hook = ch
+	}
+	if rh, ok := hook.(configs.ResponseHook); ok {
+		return rh.RunWithResponse(s)
+	}
+	return nil, hook.Run(s)
+}
+
+// passFiles records files as passed to a process of the container, for
+// scrubInheritedFds. Files closed since they were passed are forgotten.
+func (c *linuxContainer) passFiles(files []*os.File) {
+	c.passedMu.Lock()
+	defer c.passedMu.Unlock()
+	kept := c.passedFiles[:0]
+	for _, f := range c.passedFiles {
+		if f.Fd() != ^uintptr(0) {
+			kept = append(kept, f)
+		}
+	}
+	c.passedFiles = kept
+next:
+	for _, f := range files {
+		for _, p := range c.passedFiles {
+			if p == f {
+				continue next
+			}
+		}
+		c.passedFiles = append(c.passedFiles, f)
+	}
+}
+
+// scrubInheritedFds marks the files passed to the processes of the container
+// and the namespace files of its config close-on-exec, so that hooks and
+// CRIU only get the descriptors explicitly handed to them. Those runc was
+// started with, such as the ones passed with --preserve-fds, would otherwise
+// leak into them; the processes already got them through ExtraFiles. The
+// other descriptors of the process are left alone, as they are not ours to
+// change.
+func (c *linuxContainer) scrubInheritedFds() error {
+	c.passedMu.Lock()
+	files := append([]*os.File(nil), c.passedFiles...)
+	c.passedMu.Unlock()
+	if c.config != nil {
+		for _, ns := range c.config.Namespaces {
+			if ns.File != nil {
+				files = append(files, ns.File)
+			}
+		}
+	}
+	for _, f := range files {
+		fd := f.Fd()
+		if fd == ^uintptr(0) {
+			continue
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_FCNTL, fd, unix.F_SETFD, unix.FD_CLOEXEC); errno != 0 && errno != unix.EBADF {
+			return errno
+		}
+	}
+	return nil
+}
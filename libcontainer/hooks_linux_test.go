@@ -0,0 +1,87 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRunHooksDoesNotLeakFds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Opened without O_CLOEXEC, like a descriptor runc was started with,
+	// and moved out of the way of those the hook opens itself.
+	open := func(fd int) *os.File {
+		tmp, err := unix.Open("/dev/null", unix.O_RDONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(tmp)
+		if err := unix.Dup3(tmp, fd, 0); err != nil {
+			t.Fatal(err)
+		}
+		return os.NewFile(uintptr(fd), "/dev/null")
+	}
+	passed := open(200)
+	defer passed.Close()
+	other := open(201)
+	defer other.Close()
+
+	out := filepath.Join(dir, "fds")
+	hook := configs.NewCommandHook(configs.Command{
+		Path: "/bin/sh",
+		Args: []string{"sh", "-c", `ls /proc/self/fd > "$0"`, out},
+	})
+	c := &linuxContainer{id: "hooks", config: &configs.Config{}}
+	c.passFiles([]*os.File{passed})
+	if err := c.runHooks("prestart", []configs.Hook{hook}, configs.HookState{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range strings.Fields(string(data)) {
+		if f == strconv.Itoa(int(passed.Fd())) {
+			t.Fatalf("descriptor %d leaked into the hook: %s", passed.Fd(), data)
+		}
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(int(passed.Fd()), &st); err != nil {
+		t.Fatalf("descriptor %d should still be open: %v", passed.Fd(), err)
+	}
+	// The descriptors which were not passed to the container are not ours
+	// to change.
+	if flags, _, errno := unix.Syscall(unix.SYS_FCNTL, other.Fd(), unix.F_GETFD, 0); errno != 0 || flags&unix.FD_CLOEXEC != 0 {
+		t.Fatalf("expected descriptor %d to be left alone, got flags %#x: %v", other.Fd(), flags, errno)
+	}
+}
+
+func TestRunHooksStreamsOutput(t *testing.T) {
+	var out bytes.Buffer
+	hook := configs.NewCommandHook(configs.Command{
+		Path: "/bin/sh",
+		Args: []string{"sh", "-c", "echo hello from the hook"},
+	})
+	c := &linuxContainer{id: "hooks", config: &configs.Config{HookOutput: &out}}
+	if err := c.runHooks("prestart", []configs.Hook{hook}, configs.HookState{}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello from the hook\n" {
+		t.Fatalf("expected the output of the hook to be streamed, got %q", out.String())
+	}
+}
@@ -0,0 +1,51 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runc/libcontainer/utils"
+
+	"golang.org/x/sys/unix"
+)
+
+// openHostBinary opens the host binary at path to be executed in a container.
+// It is opened O_PATH, so that it can only be executed and never read or
+// written through the descriptor the container process receives.
+func openHostBinary(path string) (*os.File, error) {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "fstat", Path: path, Err: err}
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFREG {
+		unix.Close(fd)
+		return nil, fmt.Errorf("host binary %s is not a regular file", path)
+	}
+	if st.Mode&0111 == 0 {
+		unix.Close(fd)
+		return nil, fmt.Errorf("host binary %s is not executable", path)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// receiveHostBinary asks the parent for the host binary to execute and
+// receives its descriptor over pipe.
+func receiveHostBinary(pipe *os.File) (*os.File, error) {
+	if err := writeSync(pipe, procHostBinary); err != nil {
+		return nil, err
+	}
+	f, err := utils.RecvFd(pipe)
+	if err != nil {
+		return nil, fmt.Errorf("receiving host binary: %v", err)
+	}
+	// Don't leak the descriptor into the executed binary.
+	unix.CloseOnExec(int(f.Fd()))
+	return f, nil
+}
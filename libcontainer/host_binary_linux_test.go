@@ -0,0 +1,89 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/utils"
+)
+
+func TestOpenHostBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testhostbinary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "exe")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain")
+	if err := ioutil.WriteFile(plain, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := openHostBinary(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// O_PATH descriptors can't be read from.
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the host binary not to be readable")
+	}
+	for _, path := range []string{dir, plain, filepath.Join(dir, "missing")} {
+		if f, err := openHostBinary(path); err == nil {
+			f.Close()
+			t.Fatalf("expected opening %s as host binary to fail", path)
+		}
+	}
+}
+
+func TestReceiveHostBinary(t *testing.T) {
+	parent, child, err := utils.NewSockPair("init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	defer child.Close()
+	f, err := openHostBinary("/bin/sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := &setnsProcess{parentPipe: parent, hostBinary: f}
+	done := make(chan error, 1)
+	go func() {
+		done <- parseSync(parent, func(sync *syncT) error {
+			if sync.Type != procHostBinary {
+				t.Errorf("unexpected sync %s", sync.Type)
+			}
+			return utils.SendFd(p.parentPipe, p.hostBinary)
+		})
+	}()
+	received, err := receiveHostBinary(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer received.Close()
+	child.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var orig, got os.FileInfo
+	if orig, err = os.Stat("/bin/sh"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = os.Stat(filepath.Join("/proc/self/fd", strconv.Itoa(int(received.Fd())))); err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(orig, got) {
+		t.Fatal("expected the received descriptor to refer to the host binary")
+	}
+}
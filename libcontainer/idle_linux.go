@@ -0,0 +1,205 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExecEventType is the type of an ExecEvent.
+type ExecEventType string
+
+const (
+	// ExecReaped reports that a process was killed for going without I/O
+	// for longer than its IdleTimeout.
+	ExecReaped ExecEventType = "reaped"
+)
+
+// ExecEvent is something which happened to a process executed in a running
+// container.
+type ExecEvent struct {
+	Type ExecEventType
+	// Idle is how long the process went without I/O, for ExecReaped.
+	Idle time.Duration
+}
+
+// idleKillDelay is how long an idle process has to exit after SIGHUP before
+// its process group is killed.
+var idleKillDelay = 5 * time.Second
+
+// ioActivity records when data last went through the console or the stdio
+// of a process, in either direction.
+type ioActivity struct {
+	// last is in nanoseconds since the epoch, and accessed atomically.
+	last int64
+}
+
+func newIOActivity() *ioActivity {
+	a := &ioActivity{}
+	a.touch(1)
+	return a
+}
+
+// touch records that n bytes just went through. It is safe to call on a
+// nil ioActivity.
+func (a *ioActivity) touch(n int) {
+	if a != nil && n > 0 {
+		atomic.StoreInt64(&a.last, time.Now().UnixNano())
+	}
+}
+
+func (a *ioActivity) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+// activityReader and activityWriter record the activity of the stdio of a
+// process which are not files, which os/exec copies the data from and to
+// anyway.
+type activityReader struct {
+	r io.Reader
+	a *ioActivity
+}
+
+func (r *activityReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.a.touch(n)
+	return n, err
+}
+
+type activityWriter struct {
+	w io.Writer
+	a *ioActivity
+}
+
+func (w *activityWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.a.touch(n)
+	return n, err
+}
+
+// trackActivity sets up the activity of p to be tracked if it has an
+// IdleTimeout. Only the I/O going through libcontainer can be, that of the
+// console created for a Terminal and of stdio which are not files.
+func trackActivity(p *Process) error {
+	p.activity = nil
+	if p.IdleTimeout <= 0 {
+		return nil
+	}
+	if !p.Terminal {
+		if p.ConsoleSocket != nil {
+			return fmt.Errorf("the console of a process with an idle timeout must be created with Terminal")
+		}
+		for _, stdio := range []interface{}{p.Stdin, p.Stdout, p.Stderr} {
+			if _, ok := stdio.(*os.File); ok {
+				return fmt.Errorf("the stdio of a process with an idle timeout cannot be files")
+			}
+		}
+	}
+	p.activity = newIOActivity()
+	return nil
+}
+
+// trackStdio records the activity of the stdio of cmd in a.
+func trackStdio(cmd *exec.Cmd, a *ioActivity) {
+	if cmd.Stdin != nil {
+		cmd.Stdin = &activityReader{r: cmd.Stdin, a: a}
+	}
+	if cmd.Stdout != nil {
+		cmd.Stdout = &activityWriter{w: cmd.Stdout, a: a}
+	}
+	if cmd.Stderr != nil {
+		cmd.Stderr = &activityWriter{w: cmd.Stderr, a: a}
+	}
+}
+
+// idleReaper kills the process group of a process which went without I/O
+// for longer than its timeout.
+type idleReaper struct {
+	activity *ioActivity
+	timeout  time.Duration
+	pgid     int
+	signals  signaller
+	events   chan<- ExecEvent
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	stopped  bool
+	exited   chan struct{}
+	stopOnce sync.Once
+}
+
+func startIdleReaper(activity *ioActivity, timeout time.Duration, pgid int, signals signaller, events chan<- ExecEvent) *idleReaper {
+	r := &idleReaper{
+		activity: activity,
+		timeout:  timeout,
+		pgid:     pgid,
+		signals:  signals,
+		events:   events,
+		exited:   make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.timer = time.AfterFunc(timeout, r.check)
+	r.mu.Unlock()
+	return r
+}
+
+// check reaps the process if it has been idle for long enough, or checks
+// again once it would have been.
+func (r *idleReaper) check() {
+	idle := r.activity.idle()
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return
+	}
+	if idle < r.timeout {
+		r.timer = time.AfterFunc(r.timeout-idle, r.check)
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	r.reap(idle)
+}
+
+func (r *idleReaper) reap(idle time.Duration) {
+	logrus.WithFields(logrus.Fields{
+		"pgid": r.pgid,
+		"idle": idle,
+	}).Info("reaping idle process")
+	if err := r.signals.kill(-r.pgid, unix.SIGHUP); err != nil && err != unix.ESRCH {
+		logrus.Warnf("sending SIGHUP to idle process group %d: %v", r.pgid, err)
+	}
+	select {
+	case <-r.exited:
+	case <-time.After(idleKillDelay):
+		if err := r.signals.kill(-r.pgid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+			logrus.Warnf("killing idle process group %d: %v", r.pgid, err)
+		}
+	}
+	if r.events != nil {
+		select {
+		case r.events <- ExecEvent{Type: ExecReaped, Idle: idle}:
+		default:
+		}
+	}
+}
+
+// stop is called once the process exited. A reap in progress does not
+// wait to send SIGKILL any longer.
+func (r *idleReaper) stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.timer.Stop()
+	r.mu.Unlock()
+	r.stopOnce.Do(func() { close(r.exited) })
+}
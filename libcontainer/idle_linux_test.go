@@ -0,0 +1,126 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"syscall" // only for Signal
+	"testing"
+	"time"
+)
+
+type signalRecord struct {
+	pid int
+	sig syscall.Signal
+}
+
+// chanSignaller passes the signals sent on to a channel, as the reaper
+// sends them from a goroutine of its own.
+type chanSignaller chan signalRecord
+
+func (c chanSignaller) kill(pid int, sig syscall.Signal) error {
+	c <- signalRecord{pid: pid, sig: sig}
+	return nil
+}
+
+func expectSignal(t *testing.T, signals chanSignaller, want signalRecord) {
+	select {
+	case got := <-signals:
+		if got != want {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %v to be sent", want)
+	}
+}
+
+func TestIdleReaper(t *testing.T) {
+	defer func(d time.Duration) { idleKillDelay = d }(idleKillDelay)
+	idleKillDelay = 10 * time.Millisecond
+	signals := make(chanSignaller, 2)
+	events := make(chan ExecEvent, 1)
+	r := startIdleReaper(newIOActivity(), 50*time.Millisecond, 4242, signals, events)
+	defer r.stop()
+
+	expectSignal(t, signals, signalRecord{pid: -4242, sig: syscall.SIGHUP})
+	expectSignal(t, signals, signalRecord{pid: -4242, sig: syscall.SIGKILL})
+	select {
+	case ev := <-events:
+		if ev.Type != ExecReaped || ev.Idle < 50*time.Millisecond {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an ExecReaped event")
+	}
+}
+
+func TestIdleReaperPostponedByActivity(t *testing.T) {
+	signals := make(chanSignaller, 2)
+	a := newIOActivity()
+	r := startIdleReaper(a, 100*time.Millisecond, 4242, signals, nil)
+	w := &activityWriter{w: &bytes.Buffer{}, a: a}
+	for i := 0; i < 10; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, err := w.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.stop()
+	select {
+	case s := <-signals:
+		t.Fatalf("expected an active process not to be reaped, got %v", s)
+	default:
+	}
+}
+
+func TestIdleReaperSparesExitedProcess(t *testing.T) {
+	defer func(d time.Duration) { idleKillDelay = d }(idleKillDelay)
+	idleKillDelay = time.Minute
+	signals := make(chanSignaller, 2)
+	events := make(chan ExecEvent, 1)
+	r := startIdleReaper(newIOActivity(), 10*time.Millisecond, 4242, signals, events)
+	expectSignal(t, signals, signalRecord{pid: -4242, sig: syscall.SIGHUP})
+	// The process exits on SIGHUP.
+	r.stop()
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an ExecReaped event")
+	}
+	select {
+	case s := <-signals:
+		t.Fatalf("expected no SIGKILL after the process exited, got %v", s)
+	default:
+	}
+}
+
+func TestTrackActivity(t *testing.T) {
+	p := &Process{IdleTimeout: time.Minute, Stdout: os.Stdout}
+	if err := trackActivity(p); err == nil {
+		t.Fatal("expected stdio which are files to be refused")
+	}
+	p = &Process{IdleTimeout: time.Minute, ConsoleSocket: os.Stdin}
+	if err := trackActivity(p); err == nil {
+		t.Fatal("expected a console socket to be refused")
+	}
+
+	var out bytes.Buffer
+	p = &Process{IdleTimeout: time.Minute, Stdout: &out}
+	if err := trackActivity(p); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("echo")
+	cmd.Stdout = p.Stdout
+	trackStdio(cmd, p.activity)
+	if _, ok := cmd.Stdout.(*activityWriter); !ok {
+		t.Fatalf("expected the stdout to be tracked, got %T", cmd.Stdout)
+	}
+	if cmd.Stdin != nil || cmd.Stderr != nil {
+		t.Fatal("expected unset stdio to be left unset")
+	}
+	if p.Stdout != &out {
+		t.Fatal("expected the stdio of the process to be left alone")
+	}
+}
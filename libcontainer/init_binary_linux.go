@@ -0,0 +1,88 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+// selfExe is the path the init process is re-executed from by default.
+const selfExe = "/proc/self/exe"
+
+// checkSelfExe returns an error if the binary behind /proc/self/exe can no
+// longer be executed, either because it sits on a noexec mount or because it
+// has been deleted, as happens when it is replaced on an overlay.
+func checkSelfExe() error {
+	if err := unix.Faccessat(unix.AT_FDCWD, selfExe, unix.X_OK, 0); err != nil {
+		return err
+	}
+	target, err := os.Readlink(selfExe)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(target, " (deleted)") {
+		return fmt.Errorf("%s has been deleted", strings.TrimSuffix(target, " (deleted)"))
+	}
+	return nil
+}
+
+// cloneSelfExe copies the running binary into a sealed memfd, which can be
+// executed regardless of where the original binary came from.
+func cloneSelfExe() (*os.File, error) {
+	src, err := os.Open(selfExe)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	fd, err := system.MemfdCreate("runc_cloned:"+selfExe, system.MFD_CLOEXEC|system.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "memfd:runc_cloned")
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return nil, err
+	}
+	seals := system.F_SEAL_SEAL | system.F_SEAL_SHRINK | system.F_SEAL_GROW | system.F_SEAL_WRITE
+	if err := system.AddSeals(f.Fd(), seals); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkInitBinary makes sure that processes re-executing the running binary
+// can be started. If /proc/self/exe cannot be executed, the binary is copied
+// once into a sealed memfd which every container process is then started
+// from, instead of failing much later when a container is started.
+func (l *LinuxFactory) checkInitBinary() error {
+	if len(l.InitArgs) == 0 || l.InitArgs[0] != selfExe {
+		return nil
+	}
+	err := checkSelfExe()
+	if err == nil {
+		return nil
+	}
+	logrus.Debugf("cannot execute %s (%v), starting processes from a copy of it", selfExe, err)
+	f, err := cloneSelfExe()
+	if err != nil {
+		return newSystemErrorWithCause(err, "copying init binary")
+	}
+	l.initBinary = f
+	return nil
+}
+
+// initBinaryPath returns the path under which f can be executed by a child
+// of this process. The child's own fd table is rearranged before it execs,
+// so the descriptor is referred to through our pid rather than /proc/self.
+func initBinaryPath(f *os.File) string {
+	return fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), f.Fd())
+}
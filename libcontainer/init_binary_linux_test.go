@@ -0,0 +1,40 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+func TestCheckSelfExe(t *testing.T) {
+	if err := checkSelfExe(); err != nil {
+		t.Fatalf("expected the test binary to be executable: %v", err)
+	}
+}
+
+func TestCloneSelfExe(t *testing.T) {
+	f, err := cloneSelfExe()
+	if err != nil {
+		t.Skipf("cannot clone binary into a memfd: %v", err)
+	}
+	defer f.Close()
+
+	seals, err := system.GetSeals(f.Fd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seals&system.F_SEAL_WRITE == 0 || seals&system.F_SEAL_SEAL == 0 {
+		t.Fatalf("expected the copy to be sealed, got seals %#x", seals)
+	}
+	if _, err := f.WriteAt([]byte{0}, 0); err == nil {
+		t.Fatal("expected writing to the sealed copy to fail")
+	}
+
+	cmd := exec.Command(initBinaryPath(f), "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("cannot execute the copy: %v", err)
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"net"
 	"os"
 	"strings"
-	"syscall" // only for Errno
+	"syscall" // only for Errno and Signal
 	"unsafe"
 
 	"github.com/Sirupsen/logrus"
@@ -28,6 +28,7 @@ type initType string
 const (
 	initSetns    initType = "setns"
 	initStandard initType = "standard"
+	initHook     initType = "hook"
 )
 
 type pid struct {
@@ -59,8 +60,17 @@ type initConfig struct {
 	PassedFilesCount int                   `json:"passed_files_count"`
 	ContainerId      string                `json:"containerid"`
 	Rlimits          []configs.Rlimit      `json:"rlimits"`
+	Umask            *uint32               `json:"umask,omitempty"`
 	CreateConsole    bool                  `json:"create_console"`
 	Rootless         bool                  `json:"rootless"`
+	HostBinary       bool                  `json:"host_binary"`
+	ProtocolVersion  int                   `json:"protocol_version"`
+	SchedIdle        bool                  `json:"sched_idle"`
+	LateCgroups      bool                  `json:"late_cgroups"`
+	HostUser         bool                  `json:"host_user"`
+	CgroupOnly       bool                  `json:"cgroup_only"`
+	Hook             *hookRunnerConfig     `json:"hook,omitempty"`
+	StateRootMasks   []string              `json:"state_root_masks,omitempty"`
 }
 
 type initer interface {
@@ -75,6 +85,13 @@ func newContainerInit(t initType, pipe *os.File, consoleSocket *os.File, stateDi
 	if err := populateProcessEnvironment(config.Env); err != nil {
 		return nil, err
 	}
+	// A parent which predates the negotiation does not offer it, and would
+	// not understand procVersion.
+	if t == initStandard && config.ProtocolVersion > 0 {
+		if err := reportInitInfo(pipe, config.ProtocolVersion); err != nil {
+			return nil, err
+		}
+	}
 	switch t {
 	case initSetns:
 		return &linuxSetnsInit{
@@ -82,6 +99,10 @@ func newContainerInit(t initType, pipe *os.File, consoleSocket *os.File, stateDi
 			consoleSocket: consoleSocket,
 			config:        config,
 		}, nil
+	case initHook:
+		return &linuxHookInit{
+			config: config,
+		}, nil
 	case initStandard:
 		return &linuxStandardInit{
 			pipe:          pipe,
@@ -94,6 +115,23 @@ func newContainerInit(t initType, pipe *os.File, consoleSocket *os.File, stateDi
 	return nil, fmt.Errorf("unknown init type %q", t)
 }
 
+// reportInitInfo sends the parent the protocol version negotiated from the
+// one it offered and the build of the init.
+func reportInitInfo(pipe io.Writer, offered int) error {
+	version := syncProtocolVersion
+	if offered < version {
+		version = offered
+	}
+	return utils.WriteJSON(pipe, syncT{
+		Type: procVersion,
+		Init: &InitInfo{
+			ProtocolVersion: version,
+			Version:         BuildVersion,
+			Commit:          BuildCommit,
+		},
+	})
+}
+
 // populateProcessEnvironment loads the provided environment variables into the
 // current processes's environment.
 func populateProcessEnvironment(env []string) error {
@@ -109,8 +147,21 @@ func populateProcessEnvironment(env []string) error {
 	return nil
 }
 
-// finalizeNamespace drops the caps, sets the correct user
-// and working dir, and closes any leaked file descriptors
+// setSchedIdle moves the calling thread, which executes the process, to
+// SCHED_IDLE if the process was asked to be started under it. It is called
+// before seccomp is set up, which may deny sched_setscheduler(2).
+func setSchedIdle(config *initConfig) error {
+	if !config.SchedIdle {
+		return nil
+	}
+	if err := system.SetScheduler(0, system.SCHED_IDLE); err != nil {
+		return newSystemErrorWithCause(err, "setting SCHED_IDLE")
+	}
+	return nil
+}
+
+// finalizeNamespace drops the caps, sets the correct user,
+// working dir and umask, and closes any leaked file descriptors
 // before executing the command inside the namespace
 func finalizeNamespace(config *initConfig) error {
 	// Ensure that all unwanted fds we may have accidentally
@@ -152,6 +203,9 @@ func finalizeNamespace(config *initConfig) error {
 			return fmt.Errorf("chdir to cwd (%q) set in config.json failed: %v", config.Cwd, err)
 		}
 	}
+	if config.Umask != nil {
+		unix.Umask(int(*config.Umask))
+	}
 	return nil
 }
 
@@ -211,6 +265,15 @@ func syncParentReady(pipe io.ReadWriter) error {
 	return nil
 }
 
+// syncParentCgroups asks the parent to move us into the cgroups of the
+// container and waits for it to be done.
+func syncParentCgroups(pipe io.ReadWriter) error {
+	if err := writeSync(pipe, procCgroups); err != nil {
+		return err
+	}
+	return readSync(pipe, procResume)
+}
+
 // syncParentHooks sends to the given pipe a JSON payload which indicates that
 // the parent should execute pre-start hooks. It then waits for the parent to
 // indicate that it is cleared to resume.
@@ -337,8 +400,9 @@ func fixStdioPermissions(config *initConfig, u *user.ExecUser) error {
 		// Skip chown if s.Gid is actually an unmapped gid in the host. While
 		// this is a bit dodgy if it just so happens that the console _is_
 		// owned by overflow_gid, there's no way for us to disambiguate this as
-		// a userspace program.
-		if _, err := config.Config.HostGID(int(s.Gid)); err != nil {
+		// a userspace program. A process outside the user namespace of the
+		// container sees the gids of the host.
+		if _, err := config.Config.HostGID(int(s.Gid)); err != nil && !config.HostUser {
 			continue
 		}
 
@@ -401,15 +465,6 @@ func setupRoute(config *configs.Config) error {
 	return nil
 }
 
-func setupRlimits(limits []configs.Rlimit, pid int) error {
-	for _, rlimit := range limits {
-		if err := system.Prlimit(pid, rlimit.Type, unix.Rlimit{Max: rlimit.Hard, Cur: rlimit.Soft}); err != nil {
-			return fmt.Errorf("error setting rlimit type %v: %v", rlimit.Type, err)
-		}
-	}
-	return nil
-}
-
 const _P_PID = 1
 
 type siginfo struct {
@@ -450,33 +505,62 @@ func isNoChildren(err error) bool {
 }
 
 // signalAllProcesses freezes then iterates over all the processes inside the
-// manager's cgroups sending the signal s to them.
+// manager's cgroups sending the signal s to them, except those in exempt.
 // If s is SIGKILL then it will wait for each process to exit.
 // For all other signals it will check if the process is ready to report its
 // exit status and only if it is will a wait be performed.
-func signalAllProcesses(m cgroups.Manager, s os.Signal) error {
-	var procs []*os.Process
-	if err := m.Freeze(configs.Frozen); err != nil {
-		logrus.Warn(err)
-	}
+//
+// The processes of the caller found in the cgroups are never signalled,
+// and the cgroups are not frozen then, as that would freeze the caller
+// itself. They are returned so that the broken layout can be reported.
+//
+// If the cgroups cannot be frozen, as without a freezer controller, the
+// processes which forked while being signalled are signalled in turn until
+// no new one shows up, for up to maxSignalPasses passes.
+func signalAllProcesses(m cgroups.Manager, s os.Signal, exempt map[int]struct{}) ([]int, error) {
 	pids, err := m.GetAllPids()
 	if err != nil {
-		m.Freeze(configs.Thawed)
-		return err
+		return nil, err
 	}
-	for _, pid := range pids {
-		p, err := os.FindProcess(pid)
-		if err != nil {
+	frozen := false
+	callers := callerPids(pids)
+	if len(callers) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"pids":    callers,
+			"cgroups": m.GetPaths(),
+			"signal":  s,
+		}).Warn("processes of the caller are in the cgroups of the container, not signalling them")
+	} else if err := m.Freeze(configs.Frozen, DefaultFreezeTimeout); err != nil {
+		logrus.Warnf("signalling the processes of the container without freezing them: %v", err)
+	} else {
+		frozen = true
+		// Read again, as processes may have forked before being frozen.
+		if pids, err = m.GetAllPids(); err != nil {
+			m.Freeze(configs.Thawed, DefaultFreezeTimeout)
+			return nil, err
+		}
+	}
+	signalled := make(map[int]*os.Process)
+	signalPids(pids, s, exempt, callers, signalled)
+	for pass := 1; !frozen && pass < maxSignalPasses; pass++ {
+		if pids, err = m.GetAllPids(); err != nil {
 			logrus.Warn(err)
-			continue
+			break
+		}
+		if signalPids(pids, s, exempt, callers, signalled) == 0 {
+			break
 		}
-		procs = append(procs, p)
-		if err := p.Signal(s); err != nil {
+	}
+	if frozen {
+		if err := m.Freeze(configs.Thawed, DefaultFreezeTimeout); err != nil {
 			logrus.Warn(err)
 		}
 	}
-	if err := m.Freeze(configs.Thawed); err != nil {
-		logrus.Warn(err)
+	procs := make([]*os.Process, 0, len(signalled))
+	for _, p := range signalled {
+		if p != nil {
+			procs = append(procs, p)
+		}
 	}
 
 	for _, p := range procs {
@@ -498,5 +582,85 @@ func signalAllProcesses(m cgroups.Manager, s os.Signal) error {
 			}
 		}
 	}
-	return nil
+	return callers, nil
+}
+
+// maxSignalPasses bounds how many times signalAllProcesses reads the pids
+// of cgroups it could not freeze, for a fork bomb not to keep it busy.
+const maxSignalPasses = 10
+
+// signalPids sends s to those of pids not in exempt, callers or signalled,
+// adds them to signalled and returns how many there were. Those which have
+// exited in the meantime are added as nil, there is nothing to wait for.
+func signalPids(pids []int, s os.Signal, exempt map[int]struct{}, callers []int, signalled map[int]*os.Process) int {
+	n := 0
+	for _, pid := range pids {
+		if _, ok := exempt[pid]; ok {
+			continue
+		}
+		if _, ok := signalled[pid]; ok || containsPid(callers, pid) {
+			continue
+		}
+		n++
+		if err := signalPid(pid, s); err != nil {
+			if err != unix.ESRCH {
+				logrus.Warn(err)
+			}
+			signalled[pid] = nil
+			continue
+		}
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			logrus.Warn(err)
+			continue
+		}
+		signalled[pid] = p
+	}
+	return n
+}
+
+// signalPid sends s to pid, failing with unix.ESRCH if it has exited.
+func signalPid(pid int, s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", s)
+	}
+	return unix.Kill(pid, sig)
+}
+
+// callerPids returns the pids among pids which belong to the caller: its
+// own and its parent's, as when a daemon was started in the wrong cgroups,
+// and then those of its process group too. The process group alone is not
+// enough, as the processes of a container whose init reads the terminal of
+// the caller are legitimately in it.
+func callerPids(pids []int) []int {
+	self, parent := os.Getpid(), os.Getppid()
+	var callers []int
+	for _, pid := range pids {
+		if pid == self || pid == parent {
+			callers = append(callers, pid)
+		}
+	}
+	if len(callers) == 0 {
+		return nil
+	}
+	pgrp := unix.Getpgrp()
+	for _, pid := range pids {
+		if pid == self || pid == parent {
+			continue
+		}
+		if pgid, err := unix.Getpgid(pid); err == nil && pgid == pgrp {
+			callers = append(callers, pid)
+		}
+	}
+	return callers
+}
+
+func containsPid(pids []int, pid int) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
 }
@@ -2,9 +2,11 @@ package integration
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runc/libcontainer/cgroups/systemd"
@@ -114,6 +117,36 @@ func TestIPCHost(t *testing.T) {
 	}
 }
 
+func TestCgroupNamespace(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	if _, err := os.Stat("/proc/self/ns/cgroup"); os.IsNotExist(err) {
+		t.Skip("cgroupns is unsupported")
+	}
+
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	config.Namespaces = append(config.Namespaces, configs.Namespace{Type: configs.NEWCGROUP})
+	buffers, exitCode, err := runContainer(config, "", "cat", "/proc/self/cgroup")
+	ok(t, err)
+
+	if exitCode != 0 {
+		t.Fatalf("exit code not 0. code %d stderr %q", exitCode, buffers.Stderr)
+	}
+
+	// The cgroup namespace is rooted at the cgroups of the container.
+	for _, line := range strings.Split(strings.TrimSpace(buffers.Stdout.String()), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || parts[2] != "/" {
+			t.Fatalf("expected the container to be in the root of its cgroups, got %q", line)
+		}
+	}
+}
+
 func TestIPCJoinPath(t *testing.T) {
 	if testing.Short() {
 		return
@@ -848,6 +881,116 @@ func TestPassExtraFiles(t *testing.T) {
 	}
 }
 
+func TestPassExtraFilesListener(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+
+	rootfs, err := newRootfs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+
+	container, err := factory.Create("test", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Destroy()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	lfile, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := libcontainer.Process{
+		Cwd:        "/",
+		Args:       []string{"sleep", "30"},
+		Env:        []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+		ExtraFiles: []*os.File{lfile},
+	}
+	err = container.Run(&process)
+	// Only the container holds the listener from now on.
+	lfile.Close()
+	l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		process.Signal(unix.SIGKILL)
+		process.Wait()
+	}()
+
+	pid, err := process.Pid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/3", pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(target, "socket:") {
+		t.Fatalf("expected the listener to be fd 3 of the container, got %q", target)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected the listener passed to the container to still accept connections: %v", err)
+	}
+	conn.Close()
+}
+
+func TestExecInUmask(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+	config := newTemplateConfig(rootfs)
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	process := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(process)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+
+	umask := uint32(0077)
+	buffers := newStdBuffers()
+	ps := &libcontainer.Process{
+		Cwd:    "/",
+		Args:   []string{"sh", "-c", "umask"},
+		Env:    standardEnvironment,
+		Stdout: buffers.Stdout,
+		Stderr: buffers.Stderr,
+		Umask:  &umask,
+	}
+	err = container.Run(ps)
+	ok(t, err)
+	waitProcess(ps, t)
+	stdinW.Close()
+	waitProcess(process, t)
+
+	if out := strings.TrimSpace(buffers.Stdout.String()); out != "0077" {
+		t.Fatalf("expected a umask of 0077, got %q: %s", out, buffers.Stderr)
+	}
+}
+
 func TestMountCmds(t *testing.T) {
 	if testing.Short() {
 		return
@@ -1224,6 +1367,110 @@ func TestHook(t *testing.T) {
 	}
 }
 
+func TestSandboxedHook(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+
+	bundle, err := newTestBundle()
+	ok(t, err)
+	defer remove(bundle)
+
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	config.Labels = append(config.Labels, fmt.Sprintf("bundle=%s", bundle))
+	// The hook reports its bounding set, what it sees of the host, and
+	// whether it can write to the bundle.
+	script := `printf '{"annotations":{"caps":"%s","root":"%s","write":"%s"}}' ` +
+		`"$(sed -n 's/^CapBnd:[[:space:]]*//p' /proc/self/status)" "$(ls / | tr '\n' ' ')" "$(touch $0/test 2>/dev/null && echo yes)"`
+	config.Hooks = &configs.Hooks{
+		Prestart: []configs.Hook{
+			configs.NewCommandHook(configs.Command{
+				Path: "/bin/sh",
+				Args: []string{"sh", "-c", script, bundle},
+				Env:  []string{"PATH=/bin:/usr/bin"},
+				Sandbox: &configs.HookSandbox{
+					Capabilities:    &configs.Capabilities{Bounding: []string{"CAP_NET_ADMIN"}},
+					NoNewPrivileges: true,
+					MountNamespace:  true,
+					Paths:           []string{"/bin", "/usr", "/lib", "/lib64", "/proc"},
+				},
+			}),
+		},
+	}
+
+	container, err := factory.Create("test", config)
+	ok(t, err)
+	defer container.Destroy()
+
+	pconfig := libcontainer.Process{
+		Cwd:  "/",
+		Args: []string{"true"},
+		Env:  standardEnvironment,
+	}
+	err = container.Run(&pconfig)
+	ok(t, err)
+	waitProcess(&pconfig, t)
+
+	state, err := container.State()
+	ok(t, err)
+	annotations := state.HookAnnotations
+	if annotations["caps"] != "0000000000001000" {
+		t.Fatalf("expected the hook to only keep CAP_NET_ADMIN, got %q", annotations["caps"])
+	}
+	if strings.Contains(annotations["root"], "etc") {
+		t.Fatalf("expected the hook not to see /etc, got %q", annotations["root"])
+	}
+	if annotations["write"] != "" {
+		t.Fatal("expected the bundle to be read-only in the hook")
+	}
+}
+
+func TestSandboxedHookTimeout(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	timeout := 200 * time.Millisecond
+	config.Hooks = &configs.Hooks{
+		Prestart: []configs.Hook{
+			configs.NewCommandHook(configs.Command{
+				Path: "/bin/sh",
+				// The child ignores SIGTERM and keeps the output open.
+				Args:    []string{"sh", "-c", `sh -c 'trap "" TERM; sleep 5' & wait`},
+				Env:     []string{"PATH=/bin:/usr/bin"},
+				Timeout: &timeout,
+				Sandbox: &configs.HookSandbox{NoNewPrivileges: true},
+			}),
+		},
+	}
+
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	start := time.Now()
+	err = container.Run(&libcontainer.Process{
+		Cwd:  "/",
+		Args: []string{"true"},
+		Env:  standardEnvironment,
+	})
+	if !libcontainer.IsHookTimeoutError(err) {
+		t.Fatalf("expected a hook timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Fatalf("expected the hook and its children to be killed, it took %v", d)
+	}
+}
+
 func TestSTDIOPermissions(t *testing.T) {
 	if testing.Short() {
 		return
@@ -1751,3 +1998,757 @@ func TestTmpfsCopyUp(t *testing.T) {
 		t.Fatalf("/etc/passwd not copied up as expected: %v", outputLs)
 	}
 }
+
+func TestRenameRunningContainer(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	config.Cgroups.Path = "integration/rename-old"
+	container, err := newContainerWithName("rename-old", config)
+	ok(t, err)
+	// The container is destroyed under the id it has by then.
+	destroy := container.Destroy
+	defer func() { destroy() }()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	process := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(process)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+	pid, err := process.Pid()
+	ok(t, err)
+
+	ok(t, factory.Rename("rename-old", "rename-new"))
+	renamed, err := factory.Load("rename-new")
+	ok(t, err)
+	destroy = renamed.Destroy
+
+	status, err := renamed.Status()
+	ok(t, err)
+	if status != libcontainer.Running {
+		t.Fatalf("expected the renamed container to be running, got %s", status)
+	}
+	state, err := renamed.State()
+	ok(t, err)
+	for subsystem, path := range state.CgroupPaths {
+		if filepath.Base(path) != "rename-new" {
+			t.Fatalf("expected the %s cgroup to be renamed, got %s", subsystem, path)
+		}
+	}
+	cgroups, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	ok(t, err)
+	if strings.Contains(string(cgroups), "rename-old") {
+		t.Fatalf("expected the process to be moved out of the old cgroups, got %s", cgroups)
+	}
+
+	stdinW.Close()
+	waitProcess(process, t)
+}
+
+func TestTailOutput(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	config.RetainedOutput = 4
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	var stdout bytes.Buffer
+	exitFile := filepath.Join(rootfs, "exit.json")
+	process := &libcontainer.Process{
+		Cwd:            "/",
+		Args:           []string{"echo", "hello"},
+		Env:            standardEnvironment,
+		Stdout:         &stdout,
+		ExitFile:       exitFile,
+		ExitFileOutput: true,
+	}
+	ok(t, container.Run(process))
+	waitProcess(process, t)
+
+	if stdout.String() != "hello\n" {
+		t.Fatalf("expected the output to be passed on, got %q", stdout.String())
+	}
+	tail, err := container.TailOutput(0)
+	ok(t, err)
+	if string(tail) != "llo\n" {
+		t.Fatalf("expected the last 4 bytes of output to be retained, got %q", tail)
+	}
+	data, err := ioutil.ReadFile(exitFile)
+	ok(t, err)
+	var exit libcontainer.ExitStatus
+	ok(t, json.Unmarshal(data, &exit))
+	if exit.Output != "llo\n" {
+		t.Fatalf("expected the output to be included in the exit file, got %q", exit.Output)
+	}
+
+	ok(t, container.Destroy())
+	if _, err := container.TailOutput(0); err == nil {
+		t.Fatal("expected the output to be dropped on destroy")
+	}
+}
+
+func TestExecOutsideUserNS(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	if _, err := os.Stat("/proc/self/ns/user"); os.IsNotExist(err) {
+		t.Skip("userns is unsupported")
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	config.UidMappings = []configs.IDMap{{HostID: 0, ContainerID: 0, Size: 1000}}
+	config.GidMappings = []configs.IDMap{{HostID: 0, ContainerID: 0, Size: 1000}}
+	config.Namespaces = append(config.Namespaces, configs.Namespace{Type: configs.NEWUSER})
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+
+	exec := func(file string, joinUserNS bool) string {
+		var stdout bytes.Buffer
+		p := &libcontainer.Process{
+			Cwd:        "/",
+			Args:       []string{"cat", file},
+			Env:        standardEnvironment,
+			Stdout:     &stdout,
+			JoinUserNS: &joinUserNS,
+		}
+		ok(t, container.Run(p))
+		waitProcess(p, t)
+		return stdout.String()
+	}
+	// The uid_map seen from /proc tells the user namespace of the process.
+	if uidMap := strings.Fields(exec("/proc/self/uid_map", true)); len(uidMap) != 3 || uidMap[2] != "1000" {
+		t.Fatalf("expected the process to be in the user namespace of the container, got %v", uidMap)
+	}
+	if uidMap := strings.Fields(exec("/proc/self/uid_map", false)); len(uidMap) != 3 || uidMap[2] != "4294967295" {
+		t.Fatalf("expected the process to be in the user namespace of the host, got %v", uidMap)
+	}
+	// The other namespaces and the cgroups are joined all the same.
+	state, err := container.State()
+	ok(t, err)
+	if cgroup := exec("/proc/self/cgroup", false); !strings.Contains(cgroup, filepath.Base(state.CgroupPaths["memory"])) {
+		t.Fatalf("expected the process to be in the cgroups of the container, got %s", cgroup)
+	}
+	if status := exec("/proc/1/status", false); !strings.Contains(status, "PPid:\t0") {
+		t.Fatalf("expected the process to be in the pid namespace of the container, got %s", status)
+	}
+
+	stdinW.Close()
+	waitProcess(init, t)
+}
+
+func TestExecCgroupOnly(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	config.OomScoreAdj = 200
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+
+	err = container.Run(&libcontainer.Process{
+		Cwd:        "/",
+		Args:       []string{"true"},
+		HostBinary: "/bin/true",
+		CgroupOnly: true,
+	})
+	if lerr, isErr := err.(libcontainer.Error); !isErr || lerr.Code() != libcontainer.ConfigInvalid {
+		t.Fatalf("expected a host binary to be refused, got %v", err)
+	}
+
+	// The process is run from our filesystem, and waits for its stdin to
+	// be closed.
+	sidecarR, sidecarW, err := os.Pipe()
+	ok(t, err)
+	var stdout bytes.Buffer
+	sidecar := &libcontainer.Process{
+		Cwd:        "/",
+		Args:       []string{"sh", "-c", "readlink /proc/self/ns/pid; cat /proc/self/oom_score_adj /proc/self/cgroup; cat >/dev/null"},
+		Env:        standardEnvironment,
+		Stdin:      sidecarR,
+		Stdout:     &stdout,
+		CgroupOnly: true,
+	}
+	err = container.Run(sidecar)
+	sidecarR.Close()
+	defer sidecarW.Close()
+	ok(t, err)
+	pid, err := sidecar.Pid()
+	ok(t, err)
+	pids, err := container.Processes()
+	ok(t, err)
+	found := false
+	for _, p := range pids {
+		found = found || p == pid
+	}
+	if !found {
+		t.Fatalf("expected the process %d to be among those of the container, got %v", pid, pids)
+	}
+	sidecarW.Close()
+	waitProcess(sidecar, t)
+
+	pidns, err := os.Readlink("/proc/self/ns/pid")
+	ok(t, err)
+	state, err := container.State()
+	ok(t, err)
+	lines := strings.SplitN(stdout.String(), "\n", 3)
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+	if lines[0] != pidns {
+		t.Fatalf("expected the process to stay in our pid namespace %s, got %s", pidns, lines[0])
+	}
+	if lines[1] != "200" {
+		t.Fatalf("expected the oom_score_adj of the container, got %s", lines[1])
+	}
+	if !strings.Contains(lines[2], filepath.Base(state.CgroupPaths["memory"])) {
+		t.Fatalf("expected the process to be in the cgroups of the container, got %s", lines[2])
+	}
+
+	stdinW.Close()
+	waitProcess(init, t)
+}
+
+func TestExecBatch(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+
+	_, err = container.ExecBatch(context.Background(), []*libcontainer.Process{{Args: []string{"true"}, Terminal: true}})
+	if lerr, isErr := err.(libcontainer.Error); !isErr || lerr.Code() != libcontainer.ConfigInvalid {
+		t.Fatalf("expected a console to be refused, got %v", err)
+	}
+
+	// Every process has its own env, cwd, user and stdio, and the batch
+	// stops at the first which fails.
+	var env, status, after bytes.Buffer
+	results, err := container.ExecBatch(context.Background(), []*libcontainer.Process{
+		{Cwd: "/proc/self", Args: []string{"cat", "environ"}, Env: append([]string{"BATCH=1"}, standardEnvironment...), Stdout: &env},
+		{Cwd: "/", Env: standardEnvironment, Args: []string{"cat", "/proc/self/status"}, User: "1000:1000", Stdout: &status},
+		{Cwd: "/", Env: standardEnvironment, Args: []string{"sh", "-c", "exit 3"}},
+		{Cwd: "/", Env: standardEnvironment, Args: []string{"echo", "after"}, Stdout: &after},
+	})
+	ok(t, err)
+	if len(results) != 3 {
+		t.Fatalf("expected the batch to stop at the third process, got %+v", results)
+	}
+	if results[0].Failed() || results[1].Failed() || results[2].ExitCode != 3 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if !strings.Contains(env.String(), "BATCH=1") {
+		t.Fatalf("expected the env of the process, got %q", env.String())
+	}
+	if !strings.Contains(status.String(), "Uid:\t1000\t") {
+		t.Fatalf("expected the process to run as 1000, got %q", status.String())
+	}
+	if after.Len() != 0 {
+		t.Fatalf("expected the last process not to run, got %q", after.String())
+	}
+
+	results, err = container.ExecBatch(context.Background(), []*libcontainer.Process{
+		{Cwd: "/", Env: standardEnvironment, Args: []string{"sh", "-c", "exit 1"}, ContinueOnError: true},
+		{Cwd: "/", Env: standardEnvironment, Args: []string{"echo", "after"}, Stdout: &after},
+	})
+	ok(t, err)
+	if len(results) != 2 || results[0].ExitCode != 1 || after.String() != "after\n" {
+		t.Fatalf("expected the batch to go on, got %+v and %q", results, after.String())
+	}
+
+	// The whole batch is killed with its context.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	started := time.Now()
+	results, err = container.ExecBatch(ctx, []*libcontainer.Process{
+		{Cwd: "/", Env: standardEnvironment, Args: []string{"sleep", "30"}},
+		{Cwd: "/", Env: standardEnvironment, Args: []string{"true"}},
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the batch to be cancelled, got %v", err)
+	}
+	if len(results) != 0 || time.Since(started) > 10*time.Second {
+		t.Fatalf("expected the batch to be killed, got %+v after %s", results, time.Since(started))
+	}
+}
+
+func TestExecNetNamespaceOnly(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+
+	err = container.Run(&libcontainer.Process{
+		Cwd:        "/",
+		Args:       []string{"true"},
+		Namespaces: []configs.NamespaceType{configs.NEWCGROUP},
+	})
+	if lerr, isErr := err.(libcontainer.Error); !isErr || lerr.Code() != libcontainer.ConfigInvalid {
+		t.Fatalf("expected a namespace the container does not have to be refused, got %v", err)
+	}
+
+	// The process is run from our filesystem.
+	var stdout bytes.Buffer
+	process := &libcontainer.Process{
+		Cwd:        "/",
+		Args:       []string{"sh", "-c", "readlink /proc/self/ns/net; cat /proc/self/mounts"},
+		Env:        standardEnvironment,
+		Stdout:     &stdout,
+		Namespaces: []configs.NamespaceType{configs.NEWNET},
+	}
+	err = container.Run(process)
+	ok(t, err)
+	waitProcess(process, t)
+
+	state, err := container.State()
+	ok(t, err)
+	netns, err := os.Readlink(state.NamespacePaths[configs.NEWNET])
+	ok(t, err)
+	mounts, err := ioutil.ReadFile("/proc/self/mounts")
+	ok(t, err)
+	lines := strings.SplitN(stdout.String(), "\n", 2)
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+	if lines[0] != netns {
+		t.Fatalf("expected the network namespace of the container %s, got %s", netns, lines[0])
+	}
+	if lines[1] != string(mounts) {
+		t.Fatalf("expected the mounts of the host, got %q", lines[1])
+	}
+}
+
+func TestExitStatusRecorded(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	container, err := newContainerWithName("exit-status", config)
+	ok(t, err)
+	defer container.Destroy()
+
+	init := &libcontainer.Process{
+		Cwd:  "/",
+		Args: []string{"sh", "-c", "exit 3"},
+		Env:  standardEnvironment,
+	}
+	ok(t, container.Run(init))
+	if _, err := init.Wait(); err == nil {
+		t.Fatal("expected the init process to fail")
+	}
+
+	// The exit is read back from the state by another instance.
+	loaded, err := factory.Load("exit-status")
+	ok(t, err)
+	exit, err := loaded.ExitStatus()
+	ok(t, err)
+	if exit == nil || exit.Status != 3 || exit.Signal != 0 || exit.OOMKilled {
+		t.Fatalf("unexpected exit status %+v", exit)
+	}
+	state, err := loaded.State()
+	ok(t, err)
+	if state.Exit == nil || state.Exit.Status != 3 {
+		t.Fatalf("expected the exit in the state, got %+v", state.Exit)
+	}
+}
+
+func TestMountForcedOwner(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	cache, err := ioutil.TempDir("", "cache")
+	ok(t, err)
+	defer os.RemoveAll(cache)
+	cached := filepath.Join(cache, "cached")
+	ok(t, ioutil.WriteFile(cached, []byte("cached"), 0644))
+	ok(t, os.Chown(cache, 4242, 4242))
+	ok(t, os.Chown(cached, 4242, 4242))
+
+	config := newTemplateConfig(rootfs)
+	config.Mounts = append(config.Mounts, &configs.Mount{
+		Source:      cache,
+		Destination: "/cache",
+		Device:      "bind",
+		Flags:       unix.MS_BIND | unix.MS_REC,
+		ForcedOwner: &configs.MountOwner{UID: 1000, GID: 1000},
+	})
+	container, err := newContainerWithName("forced-owner", config)
+	ok(t, err)
+	destroyed := false
+	defer func() {
+		if !destroyed {
+			container.Destroy()
+		}
+	}()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+	pid, err := init.Pid()
+	ok(t, err)
+
+	var st unix.Stat_t
+	ok(t, unix.Stat(fmt.Sprintf("/proc/%d/root/cache/cached", pid), &st))
+	if st.Uid != 1000 || st.Gid != 1000 {
+		t.Fatalf("expected the cached file to be owned by 1000:1000 in the container, got %d:%d", st.Uid, st.Gid)
+	}
+	ok(t, unix.Stat(cached, &st))
+	if st.Uid != 4242 || st.Gid != 4242 {
+		t.Fatalf("expected the cached file to keep its owner on the host, got %d:%d", st.Uid, st.Gid)
+	}
+
+	stdinW.Close()
+	waitProcess(init, t)
+	destroyed = true
+	ok(t, container.Destroy())
+	// Destroying the container must leave the source alone.
+	if _, err := os.Stat(cached); err != nil {
+		t.Fatalf("expected the cached file to survive the container: %v", err)
+	}
+}
+
+func TestDevTmpfs(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	// Leave /dev and its sub-mounts, but devpts, to the runtime.
+	var mounts []*configs.Mount
+	for _, m := range config.Mounts {
+		if m.Destination != "/dev" && m.Destination != "/dev/shm" {
+			mounts = append(mounts, m)
+		}
+	}
+	config.Mounts = mounts
+	config.Dev = &configs.Dev{Size: 1 << 20}
+	container, err := newContainerWithName("dev-tmpfs", config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+	pid, err := init.Pid()
+	ok(t, err)
+
+	mountinfo, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/mountinfo", pid))
+	ok(t, err)
+	fstypes := make(map[string]string)
+	for _, line := range strings.Split(string(mountinfo), "\n") {
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if f == "-" && i+1 < len(fields) && len(fields) > 4 {
+				fstypes[fields[4]] = fields[i+1] + " " + strings.Join(fields[i+2:], " ")
+			}
+		}
+	}
+	if dev := fstypes["/dev"]; !strings.HasPrefix(dev, "tmpfs ") || !strings.Contains(dev, "size=1024k") {
+		t.Fatalf("expected a tmpfs of 1024k at /dev, got %q", dev)
+	}
+	if shm := fstypes["/dev/shm"]; !strings.HasPrefix(shm, "tmpfs ") {
+		t.Fatalf("expected a tmpfs at /dev/shm, got %q", shm)
+	}
+	if mqueue := fstypes["/dev/mqueue"]; !strings.HasPrefix(mqueue, "mqueue ") {
+		t.Fatalf("expected mqueue at /dev/mqueue, got %q", mqueue)
+	}
+	root := fmt.Sprintf("/proc/%d/root", pid)
+	if fi, err := os.Stat(filepath.Join(root, "dev/null")); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		t.Fatalf("expected /dev/null to be a device, got %v, %v", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "dev/stdin")); err != nil || target != "/proc/self/fd/0" {
+		t.Fatalf("expected /dev/stdin to link to /proc/self/fd/0, got %q, %v", target, err)
+	}
+	// The image is left alone.
+	if _, err := os.Lstat(filepath.Join(rootfs, "dev/stdin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the /dev of the rootfs to be left alone, got %v", err)
+	}
+
+	stdinW.Close()
+	waitProcess(init, t)
+}
+
+func TestVerifySecurity(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(rootfs)
+	config.NoNewPrivileges = true
+	container, err := newContainerWithName("verify-security", config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+
+	mismatches, err := container.VerifySecurity()
+	ok(t, err)
+	if len(mismatches) != 0 {
+		t.Fatalf("expected the init process to be set up as configured, got %v", mismatches)
+	}
+
+	// A user other than root only keeps its ambient capabilities.
+	execR, execW, err := os.Pipe()
+	ok(t, err)
+	process := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"cat"},
+		Env:   standardEnvironment,
+		User:  "1000:1000",
+		Stdin: execR,
+	}
+	err = container.Start(process)
+	execR.Close()
+	defer execW.Close()
+	ok(t, err)
+	mismatches, err = container.VerifyProcessSecurity(process)
+	ok(t, err)
+	if len(mismatches) != 0 {
+		t.Fatalf("expected the process to be set up as configured, got %v", mismatches)
+	}
+
+	noNewPrivs := false
+	process.NoNewPrivileges = &noNewPrivs
+	mismatches, err = container.VerifyProcessSecurity(process)
+	ok(t, err)
+	if len(mismatches) != 1 || mismatches[0].Field != "NoNewPrivs" || mismatches[0].Actual != "1" {
+		t.Fatalf("expected no_new_privs to differ, got %v", mismatches)
+	}
+
+	execW.Close()
+	waitProcess(process, t)
+	stdinW.Close()
+	waitProcess(init, t)
+}
+
+func TestNotifyMemoryPressure(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+	// dd is the memory hog, filling the page cache of the container with a
+	// file larger than its memory limit.
+	if _, err := os.Lstat(filepath.Join(rootfs, "bin", "dd")); err != nil {
+		t.Skip("the rootfs has no dd")
+	}
+
+	config := newTemplateConfig(rootfs)
+	config.Cgroups.Resources.Memory = 16 << 20
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	init := &libcontainer.Process{
+		Cwd:  "/",
+		Args: []string{"sleep", "60"},
+		Env:  standardEnvironment,
+	}
+	ok(t, container.Run(init))
+	ch, err := container.NotifyMemoryPressure(libcontainer.LowPressure)
+	ok(t, err)
+
+	hog := &libcontainer.Process{
+		Cwd:  "/",
+		Args: []string{"dd", "if=/dev/zero", "of=/hog", "bs=1M", "count=64"},
+		Env:  standardEnvironment,
+	}
+	ok(t, container.Run(hog))
+	select {
+	case _, open := <-ch:
+		if !open {
+			t.Fatal("expected a pressure event, got the channel closed")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("no memory pressure event received")
+	}
+	waitProcess(hog, t)
+
+	ok(t, init.Signal(unix.SIGKILL))
+	init.Wait()
+	ok(t, container.Destroy())
+	// The events reported meanwhile are drained before the channel closes.
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case _, open := <-ch:
+			if !open {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected the channel to be closed once the container is destroyed")
+		}
+	}
+}
+
+func TestStateRootMasked(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+	config := newTemplateConfig(rootfs)
+	config.Mounts = append(config.Mounts, &configs.Mount{
+		Source:      "/run",
+		Destination: "/run",
+		Device:      "bind",
+		Flags:       unix.MS_BIND | unix.MS_REC,
+	})
+
+	// The state root of the factory is /run/libctTests, holding the state
+	// directory of the container itself.
+	buffers, exitCode, err := runContainer(config, "", "ls", "-A", "/run/libctTests")
+	if err != nil {
+		t.Fatalf("%s: %s", buffers, err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("exit code not 0. code %d stderr %q", exitCode, buffers.Stderr)
+	}
+	if out := strings.TrimSpace(buffers.Stdout.String()); out != "" {
+		t.Fatalf("expected the state root to be empty, got %q", out)
+	}
+
+	config.StrictStateRoot = true
+	if _, err := newContainer(config); err == nil {
+		t.Fatal("expected creating a container exposing the state root to fail")
+	} else if e, ok := err.(libcontainer.Error); !ok || e.Code() != libcontainer.ConfigInvalid {
+		t.Fatalf("expected a ConfigInvalid error, got %v", err)
+	}
+}
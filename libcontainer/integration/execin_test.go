@@ -580,3 +580,54 @@ func TestExecInUserns(t *testing.T) {
 		t.Errorf("execin userns(%s), wanted %s", out, initUserns)
 	}
 }
+
+func TestInitPipeClosedBeforeExec(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+	config := newTemplateConfig(rootfs)
+	container, err := newContainer(config)
+	ok(t, err)
+	defer container.Destroy()
+
+	stdinR, stdinW, err := os.Pipe()
+	ok(t, err)
+	init := &libcontainer.Process{
+		Cwd:   "/",
+		Args:  []string{"sh", "-c", "readlink /proc/$$/fd/*; cat"},
+		Env:   standardEnvironment,
+		Stdin: stdinR,
+	}
+	initBuffers := newStdBuffers()
+	init.Stdout = initBuffers.Stdout
+	init.Stderr = initBuffers.Stderr
+	err = container.Run(init)
+	stdinR.Close()
+	defer stdinW.Close()
+	ok(t, err)
+
+	buffers := newStdBuffers()
+	ps := &libcontainer.Process{
+		Cwd:    "/",
+		Args:   []string{"sh", "-c", "readlink /proc/$$/fd/*"},
+		Env:    standardEnvironment,
+		Stdin:  buffers.Stdin,
+		Stdout: buffers.Stdout,
+		Stderr: buffers.Stderr,
+	}
+	err = container.Run(ps)
+	ok(t, err)
+	waitProcess(ps, t)
+	stdinW.Close()
+	waitProcess(init, t)
+
+	// Neither process may have kept an end of its init pipe.
+	for _, out := range []string{initBuffers.Stdout.String(), buffers.Stdout.String()} {
+		if strings.Contains(out, "socket:") {
+			t.Fatalf("unexpected socket among the descriptors of the container process: %q", out)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// LifetimeExceeded is the cause recorded in the ExitStatus of a container
+// which was terminated for running longer than its MaxLifetime.
+const LifetimeExceeded = "LifetimeExceeded"
+
+// lifetimeGracePeriod is how long a container which exceeded its MaxLifetime
+// has at most to exit after the stop signal before its processes are killed.
+var lifetimeGracePeriod = 10 * time.Second
+
+// lifetime tracks the deadline of a container with a MaxLifetime. A nil
+// lifetime has no deadline.
+type lifetime struct {
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	exceeded bool
+	done     chan struct{}
+	// root is the state directory the lifetime is shared for, if any.
+	root string
+}
+
+// lifetimes holds the lifetimes with a deadline of the containers started or
+// loaded by this process, by state directory, so that loading a container
+// again shares the timer armed for it rather than arming another.
+var lifetimes = struct {
+	sync.Mutex
+	m map[string]*lifetime
+}{m: make(map[string]*lifetime)}
+
+// shareLifetime returns the lifetime of the container whose state directory
+// is root: l, unless one with a deadline is already shared for it.
+func shareLifetime(root string, l *lifetime) *lifetime {
+	if l.deadline.IsZero() {
+		return l
+	}
+	lifetimes.Lock()
+	defer lifetimes.Unlock()
+	if shared := lifetimes.m[root]; shared != nil {
+		if l.exceeded {
+			shared.expire()
+		}
+		return shared
+	}
+	l.root = root
+	lifetimes.m[root] = l
+	return l
+}
+
+// unshare stops sharing l, once its timer is disarmed or fired.
+func (l *lifetime) unshare() {
+	lifetimes.Lock()
+	defer lifetimes.Unlock()
+	if l.root != "" && lifetimes.m[l.root] == l {
+		delete(lifetimes.m, l.root)
+	}
+}
+
+// arm calls expired once the deadline has passed, unless it is disarmed
+// before. It does nothing without a deadline or if it is already armed.
+func (l *lifetime) arm(expired func()) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.deadline.IsZero() || l.timer != nil {
+		return
+	}
+	l.timer = time.AfterFunc(l.deadline.Sub(time.Now()), func() {
+		l.unshare()
+		expired()
+	})
+}
+
+func (l *lifetime) disarm() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.unshare()
+}
+
+// deadlineAt returns the deadline, or the zero time without one.
+func (l *lifetime) deadlineAt() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.deadline
+}
+
+// expire records that the deadline passed and releases the receivers of
+// notify.
+func (l *lifetime) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.exceeded {
+		return
+	}
+	l.exceeded = true
+	if l.done != nil {
+		close(l.done)
+	}
+}
+
+// notify returns a channel which is closed once the deadline passed.
+func (l *lifetime) notify() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done == nil {
+		l.done = make(chan struct{})
+		if l.exceeded {
+			close(l.done)
+		}
+	}
+	return l.done
+}
+
+func (l *lifetime) wasExceeded() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.exceeded
+}
+
+func (c *linuxContainer) NotifyLifetimeExceeded() (<-chan struct{}, error) {
+	return c.lifetime.notify(), nil
+}
+
+// armLifetime enforces the MaxLifetime of the container from now on. It is
+// enforced once per process, however many times the container is loaded.
+func (c *linuxContainer) armLifetime() {
+	c.lifetime.arm(c.lifetimeExpired)
+}
+
+// lifetimeExpired stops the container once its MaxLifetime is over, giving
+// it no more than the grace period to exit before it is killed.
+func (c *linuxContainer) lifetimeExpired() {
+	c.m.Lock()
+	status, err := c.currentStatus()
+	c.m.Unlock()
+	if err != nil || status == Stopped {
+		return
+	}
+	logrus.Infof("container %s exceeded its max lifetime of %s, terminating it", c.id, c.config.MaxLifetime)
+	c.lifetime.expire()
+	// Whoever records the exit of the container then knows why it exited.
+	c.m.Lock()
+	if _, err := os.Stat(filepath.Join(c.root, stateFilename)); err == nil {
+		state, err := c.currentState()
+		if err == nil {
+			err = c.saveState(state)
+		}
+		if err != nil {
+			logrus.Warnf("recording the exceeded lifetime of container %s: %v", c.id, err)
+		}
+	}
+	c.m.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), lifetimeGracePeriod)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		logrus.Warnf("stopping container %s: %v", c.id, err)
+	}
+}
@@ -0,0 +1,126 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLifetimeArm(t *testing.T) {
+	l := &lifetime{deadline: time.Now().Add(10 * time.Millisecond)}
+	fired := make(chan struct{})
+	l.arm(func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the timer to fire after the deadline")
+	}
+
+	l = &lifetime{deadline: time.Now().Add(50 * time.Millisecond)}
+	l.arm(func() { t.Error("expected a disarmed timer not to fire") })
+	l.disarm()
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestLifetimeNotify(t *testing.T) {
+	l := &lifetime{}
+	before := l.notify()
+	l.expire()
+	l.expire()
+	for _, ch := range []<-chan struct{}{before, l.notify()} {
+		select {
+		case <-ch:
+		default:
+			t.Fatal("expected the channel to be closed once the lifetime was exceeded")
+		}
+	}
+	if !l.wasExceeded() {
+		t.Fatal("expected the lifetime to be exceeded")
+	}
+}
+
+func TestLifetimeExpiredKillsAfterGracePeriod(t *testing.T) {
+	defer func(d time.Duration) { lifetimeGracePeriod = d }(lifetimeGracePeriod)
+	lifetimeGracePeriod = 100 * time.Millisecond
+
+	root, err := ioutil.TempDir("", "lifetime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	cmd, started := startIgnoringTerm(t)
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	c := &linuxContainer{
+		id:                   "lifetime",
+		root:                 root,
+		config:               &configs.Config{MaxLifetime: time.Second},
+		cgroupManager:        &mockCgroupManager{allPids: []int{cmd.Process.Pid}},
+		initProcess:          &nonChildProcess{processPid: cmd.Process.Pid, processStartTime: started},
+		initProcessStartTime: started,
+		lifetime:             &lifetime{},
+	}
+	c.state = &runningState{c: c}
+	if err := ioutil.WriteFile(filepath.Join(root, stateFilename), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	exceeded, err := c.NotifyLifetimeExceeded()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.lifetimeExpired()
+	select {
+	case <-exceeded:
+	default:
+		t.Fatal("expected the lifetime exceeded notification")
+	}
+	if err := unix.Kill(cmd.Process.Pid, 0); err != unix.ESRCH {
+		t.Fatalf("expected the init process ignoring SIGTERM to be killed, got %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, stateFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.LifetimeExceeded {
+		t.Fatal("expected the exceeded lifetime to be saved in the state")
+	}
+}
+
+func TestLifetimeSharedPerContainer(t *testing.T) {
+	deadline := time.Now().Add(50 * time.Millisecond)
+	first := shareLifetime("/run/lifetime-test", &lifetime{deadline: deadline})
+	// As when the container is loaded again by the same process.
+	second := shareLifetime("/run/lifetime-test", &lifetime{deadline: deadline})
+	if first != second {
+		t.Fatal("expected the lifetime of a container to be shared")
+	}
+	fired := make(chan struct{}, 2)
+	first.arm(func() { fired <- struct{}{} })
+	second.arm(func() { fired <- struct{}{} })
+	<-fired
+	select {
+	case <-fired:
+		t.Fatal("expected a single timer per container")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if third := shareLifetime("/run/lifetime-test", &lifetime{deadline: deadline, exceeded: true}); third == first || !third.wasExceeded() {
+		t.Fatal("expected a fired lifetime to be shared no more")
+	}
+	lifetimes.Lock()
+	delete(lifetimes.m, "/run/lifetime-test")
+	lifetimes.Unlock()
+}
@@ -3,6 +3,11 @@
 package libcontainer
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+
 	"github.com/vishvananda/netlink/nl"
 	"golang.org/x/sys/unix"
 )
@@ -10,16 +15,51 @@ import (
 // list of known message types we want to send to bootstrap program
 // The number is randomly chosen to not conflict with known netlink types
 const (
-	InitMsg         uint16 = 62000
-	CloneFlagsAttr  uint16 = 27281
-	NsPathsAttr     uint16 = 27282
-	UidmapAttr      uint16 = 27283
-	GidmapAttr      uint16 = 27284
-	SetgroupAttr    uint16 = 27285
-	OomScoreAdjAttr uint16 = 27286
-	RootlessAttr    uint16 = 27287
+	InitMsg          uint16 = 62000
+	CloneFlagsAttr   uint16 = 27281
+	NsPathsAttr      uint16 = 27282
+	UidmapAttr       uint16 = 27283
+	GidmapAttr       uint16 = 27284
+	SetgroupAttr     uint16 = 27285
+	OomScoreAdjAttr  uint16 = 27286
+	RootlessAttr     uint16 = 27287
+	SetnsRetriesAttr uint16 = 27288
+	SetnsBackoffAttr uint16 = 27289
+	RlimitsAttr      uint16 = 27290
+	BatchAttr        uint16 = 27291
 )
 
+// createCgroupns is sent on the init pipe once the init process is in the
+// cgroups of the container, for nsexec to create its cgroup namespace, so
+// that its root is the cgroup of the container. It must be kept in sync with
+// CREATECGROUPNS in nsenter/nsexec.c.
+const createCgroupns byte = 0x80
+
+// nsFdPrefix marks an entry of the NsPathsAttr list as a file descriptor
+// number, inherited by nsexec, rather than a path to open.
+const nsFdPrefix = "fd:"
+
+// maxBootstrapDataSize is the largest serialized bootstrap message (including
+// the netlink header) that nsexec will accept. It must be kept in sync with
+// BOOTSTRAP_DATA_MAX in nsenter/nsexec.c.
+const maxBootstrapDataSize = 1 << 20
+
+// serializeBootstrapData serializes the bootstrap netlink message, failing
+// early if nsexec would refuse to read it.
+func serializeBootstrapData(r *nl.NetlinkRequest) (io.Reader, error) {
+	for _, attr := range r.Data {
+		// nla_len is only 16 bits wide, so larger attributes can't be encoded.
+		if l := attr.Len(); l > math.MaxUint16 {
+			return nil, fmt.Errorf("bootstrap attribute too large (%d bytes, max %d)", l, math.MaxUint16)
+		}
+	}
+	data := r.Serialize()
+	if len(data) > maxBootstrapDataSize {
+		return nil, fmt.Errorf("bootstrap data too large (%d bytes, max %d)", len(data), maxBootstrapDataSize)
+	}
+	return bytes.NewReader(data), nil
+}
+
 type Int32msg struct {
 	Type  uint16
 	Value uint32
@@ -0,0 +1,68 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// bootstrapRequestOfSize returns a bootstrap request that serializes to
+// exactly size bytes, padded out with uid map attributes that each fit in
+// nla_len. size must be 4-byte aligned.
+func bootstrapRequestOfSize(t *testing.T, size int) *nl.NetlinkRequest {
+	const chunk = 1 << 15
+	r := nl.NewNetlinkRequest(int(InitMsg), 0)
+	for left := size - unix.NLMSG_HDRLEN; left > 0; {
+		n := left
+		if n > chunk {
+			n = chunk
+		}
+		// Bytemsg adds a NUL terminator and pads the attribute to NLA_ALIGNTO.
+		r.AddData(&Bytemsg{
+			Type:  UidmapAttr,
+			Value: make([]byte, n-unix.NLA_HDRLEN-1),
+		})
+		left -= n
+	}
+	if got := len(r.Serialize()); got != size {
+		t.Fatalf("expected bootstrap request of %d bytes, got %d", size, got)
+	}
+	return r
+}
+
+func TestSerializeBootstrapDataAtLimit(t *testing.T) {
+	r := bootstrapRequestOfSize(t, maxBootstrapDataSize)
+	data, err := serializeBootstrapData(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != maxBootstrapDataSize {
+		t.Fatalf("expected %d bytes of bootstrap data, got %d", maxBootstrapDataSize, len(b))
+	}
+}
+
+func TestSerializeBootstrapDataTooLarge(t *testing.T) {
+	r := bootstrapRequestOfSize(t, maxBootstrapDataSize+unix.NLA_ALIGNTO)
+	if _, err := serializeBootstrapData(r); err == nil {
+		t.Fatal("expected an error for bootstrap data larger than the maximum")
+	}
+}
+
+func TestSerializeBootstrapDataAttributeTooLarge(t *testing.T) {
+	r := nl.NewNetlinkRequest(int(InitMsg), 0)
+	r.AddData(&Bytemsg{
+		Type:  NsPathsAttr,
+		Value: make([]byte, 1<<16),
+	})
+	if _, err := serializeBootstrapData(r); err == nil {
+		t.Fatal("expected an error for an attribute that does not fit in nla_len")
+	}
+}
@@ -0,0 +1,61 @@
+package libcontainer
+
+import (
+	"strconv"
+	"time"
+)
+
+// MetricsSink receives the latencies measured by libcontainer, e.g. to feed
+// histograms of an embedding daemon. Observe is called with one of the
+// Metric names, the latency in seconds and labels, which may be nil. Only
+// successful operations are observed.
+type MetricsSink interface {
+	Observe(name string, value float64, labels map[string]string)
+}
+
+const (
+	// MetricInitStart is how long starting the init process of a container
+	// took, until it waits to execute the user process.
+	MetricInitStart = "init_start_seconds"
+	// MetricExecStart is how long starting a process in a running container
+	// took, until the process was executed.
+	MetricExecStart = "exec_start_seconds"
+	// MetricCgroupApply is how long placing the init process in its cgroups
+	// took.
+	MetricCgroupApply = "cgroup_apply_seconds"
+	// MetricHook is how long a hook took, labelled with the phase of the
+	// hook, e.g. "prestart", and its index in that phase.
+	MetricHook = "hook_seconds"
+	// MetricCriuDump is how long CRIU took to dump a container, pre-dumps
+	// excluded.
+	MetricCriuDump = "criu_dump_seconds"
+)
+
+// metricTimer measures the latency of an operation for a MetricsSink.
+// Without a sink, it neither reads the clock nor allocates.
+type metricTimer struct {
+	sink  MetricsSink
+	start time.Time
+}
+
+func startTimer(sink MetricsSink) metricTimer {
+	if sink == nil {
+		return metricTimer{}
+	}
+	return metricTimer{sink: sink, start: time.Now()}
+}
+
+func (t metricTimer) observe(name string) {
+	if t.sink != nil {
+		t.sink.Observe(name, time.Since(t.start).Seconds(), nil)
+	}
+}
+
+func (t metricTimer) observeHook(phase string, index int) {
+	if t.sink != nil {
+		t.sink.Observe(MetricHook, time.Since(t.start).Seconds(), map[string]string{
+			"phase": phase,
+			"index": strconv.Itoa(index),
+		})
+	}
+}
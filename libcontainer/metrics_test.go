@@ -0,0 +1,36 @@
+package libcontainer
+
+import (
+	"reflect"
+	"testing"
+)
+
+type fakeMetricsSink struct {
+	names  []string
+	labels []map[string]string
+}
+
+func (f *fakeMetricsSink) Observe(name string, value float64, labels map[string]string) {
+	f.names = append(f.names, name)
+	f.labels = append(f.labels, labels)
+}
+
+func TestMetricTimerWithoutSink(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		timer := startTimer(nil)
+		timer.observe(MetricExecStart)
+		timer.observeHook("prestart", 0)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations without a sink, got %v", allocs)
+	}
+}
+
+func TestMetricTimerObservesHook(t *testing.T) {
+	sink := &fakeMetricsSink{}
+	startTimer(sink).observeHook("poststop", 2)
+	want := map[string]string{"phase": "poststop", "index": "2"}
+	if !reflect.DeepEqual(sink.names, []string{MetricHook}) || !reflect.DeepEqual(sink.labels[0], want) {
+		t.Fatalf("expected %s with labels %v, got %v %v", MetricHook, want, sink.names, sink.labels)
+	}
+}
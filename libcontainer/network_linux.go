@@ -25,6 +25,7 @@ var strategies = map[string]networkStrategy{
 type networkStrategy interface {
 	create(*network, int) error
 	initialize(*network) error
+	destroy(*network) error
 	detach(*configs.Network) error
 	attach(*configs.Network) error
 }
@@ -96,6 +97,10 @@ func (l *loopback) initialize(config *network) error {
 	return netlink.LinkSetUp(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo"}})
 }
 
+func (l *loopback) destroy(config *network) error {
+	return nil
+}
+
 func (l *loopback) attach(n *configs.Network) (err error) {
 	return nil
 }
@@ -148,6 +153,15 @@ func (v *veth) attach(n *configs.Network) (err error) {
 }
 
 func (v *veth) create(n *network, nspid int) (err error) {
+	return v.createIn(n, func(child netlink.Link) error {
+		return netlink.LinkSetNsPid(child, nspid)
+	})
+}
+
+// createIn creates the veth pair of n, attaching the host end and handing
+// the other one, under a temporary name, to setNs to be moved into the
+// network namespace of the container.
+func (v *veth) createIn(n *network, setNs func(netlink.Link) error) (err error) {
 	tmpName, err := v.generateTempPeerName()
 	if err != nil {
 		return err
@@ -178,7 +192,13 @@ func (v *veth) create(n *network, nspid int) (err error) {
 	if err != nil {
 		return err
 	}
-	return netlink.LinkSetNsPid(child, nspid)
+	return setNs(child)
+}
+
+// destroy deletes the veth pair of n through its host end, for a container
+// which failed to start.
+func (v *veth) destroy(n *network) error {
+	return netlink.LinkDel(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: n.HostInterfaceName}})
 }
 
 func (v *veth) generateTempPeerName() (string, error) {
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -67,16 +69,19 @@ func registerMemoryEvent(cgDir string, evName string, arg string) (<-chan struct
 // notifyOnOOM returns channel on which you can expect event about OOM,
 // if process died without OOM this channel will be closed.
 func notifyOnOOM(paths map[string]string) (<-chan struct{}, error) {
-	dir := paths[oomCgroupName]
+	dir := memoryCgroupDir(paths)
 	if dir == "" {
 		return nil, fmt.Errorf("path %q missing", oomCgroupName)
 	}
+	if cgroups.IsCgroup2UnifiedMode() {
+		return notifyOnOOMUnified(dir)
+	}
 
 	return registerMemoryEvent(dir, "memory.oom_control", "")
 }
 
 func notifyMemoryPressure(paths map[string]string, level PressureLevel) (<-chan struct{}, error) {
-	dir := paths[oomCgroupName]
+	dir := memoryCgroupDir(paths)
 	if dir == "" {
 		return nil, fmt.Errorf("path %q missing", oomCgroupName)
 	}
@@ -84,7 +89,59 @@ func notifyMemoryPressure(paths map[string]string, level PressureLevel) (<-chan
 	if level > CriticalPressure {
 		return nil, fmt.Errorf("invalid pressure level %d", level)
 	}
+	if cgroups.IsCgroup2UnifiedMode() {
+		return notifyMemoryPressureUnified(dir, psiTriggers[level])
+	}
 
 	levelStr := []string{"low", "medium", "critical"}[level]
 	return registerMemoryEvent(dir, "memory.pressure_level", levelStr)
 }
+
+// psiTriggers are the PSI triggers standing in for the pressure levels of
+// cgroup v1 on cgroup v2: the time some or all of the tasks of the cgroup
+// were stalled on memory within a window, in microseconds. A window of 2s
+// is the shortest unprivileged callers may use.
+var psiTriggers = []string{
+	LowPressure:      "some 150000 2000000",
+	MediumPressure:   "some 500000 2000000",
+	CriticalPressure: "full 500000 2000000",
+}
+
+// notifyMemoryPressureUnified is notifyMemoryPressure for cgroup v2, which
+// has no memory.pressure_level. The trigger is registered with
+// memory.pressure, which reports it with POLLPRI, at most once per window,
+// and with POLLERR once the cgroup is removed.
+func notifyMemoryPressureUnified(dir, trigger string) (<-chan struct{}, error) {
+	f, err := os.OpenFile(filepath.Join(dir, "memory.pressure"), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	// The trigger must be written with its terminating NUL.
+	if _, err := f.Write(append([]byte(trigger), 0)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	ch := make(chan struct{})
+	go func() {
+		defer func() {
+			close(ch)
+			f.Close()
+		}()
+		fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLPRI}}
+		for {
+			if _, err := unix.Poll(fds, -1); err != nil {
+				if err == unix.EINTR {
+					continue
+				}
+				return
+			}
+			if fds[0].Revents&(unix.POLLERR|unix.POLLNVAL) != 0 {
+				return
+			}
+			if fds[0].Revents&unix.POLLPRI != 0 {
+				ch <- struct{}{}
+			}
+		}
+	}()
+	return ch, nil
+}
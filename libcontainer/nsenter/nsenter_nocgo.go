@@ -0,0 +1,6 @@
+// +build linux,!cgo
+
+// Package nsenter links in the nsexec shim, which needs cgo. Without it the
+// package is empty, and libcontainer clones container processes into their
+// namespaces from Go, refusing configs which join existing ones.
+package nsenter
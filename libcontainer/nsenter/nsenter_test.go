@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/opencontainers/runc/libcontainer"
@@ -79,6 +80,84 @@ func TestNsenterValidPaths(t *testing.T) {
 	p.Wait()
 }
 
+func TestNsenterFdPath(t *testing.T) {
+	// The holder sets the hostname of a new uts namespace, which is then
+	// passed to nsenter as a file descriptor rather than a path.
+	holder := &exec.Cmd{
+		Path:        os.Args[0],
+		Args:        []string{"nsenter-uts-holder"},
+		SysProcAttr: &syscall.SysProcAttr{Cloneflags: unix.CLONE_NEWUTS},
+		Stderr:      os.Stderr,
+	}
+	holderIn, err := holder.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holderOut, err := holder.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.Start(); err != nil {
+		t.Fatalf("holder failed to start %v", err)
+	}
+	defer holder.Wait()
+	defer holderIn.Close()
+	if _, err := holderOut.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("holder failed to set its hostname %v", err)
+	}
+	ns, err := os.Open(fmt.Sprintf("/proc/%d/ns/uts", holder.Process.Pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+
+	parent, child, err := newPipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe %v", err)
+	}
+	var out bytes.Buffer
+	cmd := &exec.Cmd{
+		Path:       os.Args[0],
+		Args:       []string{"nsenter-hostname"},
+		ExtraFiles: []*os.File{child, ns},
+		Env:        []string{"_LIBCONTAINER_INITPIPE=3"},
+		Stdout:     &out,
+		Stderr:     os.Stderr,
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("nsenter failed to start %v", err)
+	}
+	r := nl.NewNetlinkRequest(int(libcontainer.InitMsg), 0)
+	r.AddData(&libcontainer.Int32msg{
+		Type:  libcontainer.CloneFlagsAttr,
+		Value: 0,
+	})
+	r.AddData(&libcontainer.Bytemsg{
+		Type:  libcontainer.NsPathsAttr,
+		Value: []byte("uts:fd:4"),
+	})
+	if _, err := io.Copy(parent, bytes.NewReader(r.Serialize())); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := json.NewDecoder(parent)
+	var pid *pid
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("nsenter exits with a non-zero exit status")
+	}
+	if err := decoder.Decode(&pid); err != nil {
+		t.Fatalf("%v", err)
+	}
+	p, err := os.FindProcess(pid.Pid)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	p.Wait()
+	if got := strings.TrimSpace(out.String()); got != fdTestHostname {
+		t.Fatalf("expected hostname %q of the joined namespace, got %q", fdTestHostname, got)
+	}
+}
+
 func TestNsenterInvalidPaths(t *testing.T) {
 	args := []string{"nsenter-exec"}
 	parent, child, err := newPipe()
@@ -159,7 +238,107 @@ func TestNsenterIncorrectPathType(t *testing.T) {
 	}
 }
 
+// startNsenterWithPadding starts nsenter with a bootstrap message that has
+// been padded with unused uid maps (no user namespace is requested) so that
+// the whole message is size bytes long.
+func startNsenterWithPadding(t *testing.T, size int) (*exec.Cmd, *os.File, <-chan error) {
+	parent, child, err := newPipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe %v", err)
+	}
+	cmd := &exec.Cmd{
+		Path:       os.Args[0],
+		Args:       []string{"nsenter-exec"},
+		ExtraFiles: []*os.File{child},
+		Env:        []string{"_LIBCONTAINER_INITPIPE=3"},
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure writes fail rather than block if nsenter bails out early.
+	child.Close()
+
+	r := nl.NewNetlinkRequest(int(libcontainer.InitMsg), 0)
+	r.AddData(&libcontainer.Int32msg{
+		Type:  libcontainer.CloneFlagsAttr,
+		Value: uint32(unix.CLONE_NEWNET),
+	})
+	for left := size - len(r.Serialize()); left > 0; {
+		n := left
+		if n > 1<<15 {
+			n = 1 << 15
+		}
+		r.AddData(&libcontainer.Bytemsg{
+			Type:  libcontainer.UidmapAttr,
+			Value: make([]byte, n-unix.NLA_HDRLEN-1),
+		})
+		left -= n
+	}
+	data := r.Serialize()
+	if len(data) != size {
+		t.Fatalf("expected bootstrap data of %d bytes, got %d", size, len(data))
+	}
+	copyErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(parent, bytes.NewReader(data))
+		copyErr <- err
+	}()
+	return cmd, parent, copyErr
+}
+
+func TestNsenterLargeBootstrapData(t *testing.T) {
+	// Well beyond the default socket buffer size, but within the maximum.
+	const size = 1 << 20
+	cmd, parent, copyErr := startNsenterWithPadding(t, size)
+	defer parent.Close()
+
+	if err := <-copyErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("nsenter exits with a non-zero exit status: %v", err)
+	}
+	var pid *pid
+	if err := json.NewDecoder(parent).Decode(&pid); err != nil {
+		t.Fatal(err)
+	}
+	p, err := os.FindProcess(pid.Pid)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	p.Wait()
+}
+
+func TestNsenterBootstrapDataTooLarge(t *testing.T) {
+	const size = 1<<20 + 4
+	cmd, parent, copyErr := startNsenterWithPadding(t, size)
+	defer parent.Close()
+
+	if err := cmd.Wait(); err == nil {
+		t.Fatalf("nsenter exits with a zero exit status")
+	}
+	parent.Close()
+	<-copyErr
+}
+
+// fdTestHostname is the hostname of the namespace TestNsenterFdPath joins.
+const fdTestHostname = "nsenter-fd"
+
 func init() {
+	switch os.Args[0] {
+	case "nsenter-uts-holder":
+		if err := unix.Sethostname([]byte(fdTestHostname)); err != nil {
+			os.Exit(1)
+		}
+		os.Stdout.Write([]byte{0})
+		// Keep the namespace alive until the test closes stdin.
+		ioutil.ReadAll(os.Stdin)
+		os.Exit(0)
+	case "nsenter-hostname":
+		name, _ := os.Hostname()
+		fmt.Println(name)
+		os.Exit(0)
+	}
 	if strings.HasPrefix(os.Args[0], "nsenter-") {
 		os.Exit(0)
 	}
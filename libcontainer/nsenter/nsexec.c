@@ -13,12 +13,15 @@
 #include <stdlib.h>
 #include <stdbool.h>
 #include <string.h>
+#include <time.h>
 #include <unistd.h>
 
 #include <sys/ioctl.h>
 #include <sys/prctl.h>
+#include <sys/resource.h>
 #include <sys/socket.h>
 #include <sys/types.h>
+#include <sys/wait.h>
 
 #include <linux/limits.h>
 #include <linux/netlink.h>
@@ -40,6 +43,13 @@ enum sync_t {
 	SYNC_ERR = 0xFF, /* Fatal error, no turning back. The error code follows. */
 };
 
+/*
+ * Sent by the parent on the init pipe once the container process is in its
+ * cgroups, for [stage 2: JUMP_INIT] to create the cgroup namespace. Must be
+ * kept in sync with createCgroupns in message_linux.go.
+ */
+#define CREATECGROUPNS 0x80
+
 /* longjmp() arguments. */
 #define JUMP_PARENT 0x00
 #define JUMP_CHILD  0xA0
@@ -48,6 +58,12 @@ enum sync_t {
 /* JSON buffer. */
 #define JSON_MAX 4096
 
+/*
+ * Maximum size of the bootstrap netlink message (including its header). This
+ * must be kept in sync with maxBootstrapDataSize in libcontainer/message_linux.go.
+ */
+#define BOOTSTRAP_DATA_MAX (1 << 20)
+
 /* Assume the stack grows down, so arguments should be above it. */
 struct clone_t {
 	/*
@@ -75,6 +91,12 @@ struct nlconfig_t {
 	uint8_t is_rootless;
 	char *oom_score_adj;
 	size_t oom_score_adj_len;
+	uint32_t setns_retries;
+	uint32_t setns_backoff;
+	char *rlimits;
+	size_t rlimits_len;
+	/* The batch socket of the runner of a batch, which is never 0. */
+	uint32_t batch_fd;
 };
 
 /*
@@ -89,6 +111,17 @@ struct nlconfig_t {
 #define SETGROUP_ATTR		27285
 #define OOM_SCORE_ADJ_ATTR	27286
 #define ROOTLESS_ATTR	    27287
+#define SETNS_RETRIES_ATTR	27288
+#define SETNS_BACKOFF_ATTR	27289
+#define RLIMITS_ATTR		27290
+#define BATCH_ATTR		27291
+
+/*
+ * Prefix of NS_PATHS_ATTR entries which are inherited file descriptors rather
+ * than paths. This must be kept in sync with nsFdPrefix in
+ * libcontainer/message_linux.go.
+ */
+#define NS_FD_PREFIX "fd:"
 
 /*
  * Use the raw syscall for versions of glibc which don't include a function for
@@ -219,6 +252,34 @@ static void update_oom_score_adj(char *data, size_t len)
 		bail("failed to update /proc/self/oom_score_adj");
 }
 
+/*
+ * Sets the rlimits sent by the parent on ourselves. They raise hard limits,
+ * which needs CAP_SYS_RESOURCE in the user namespace of runc, so this has to
+ * be done before the children enter their own and then inherit the limits.
+ * Each rlimit is the type, soft and hard limit in native 64 bit integers, as
+ * encoded by encodeRlimits in libcontainer/rlimit_linux.go.
+ */
+static void setup_rlimits(char *data, size_t len)
+{
+	size_t i;
+
+	/* The attribute is null-terminated like any other byte one. */
+	for (i = 0; i + 3 * sizeof(uint64_t) <= len; i += 3 * sizeof(uint64_t)) {
+		uint64_t limit[3];
+		struct rlimit old, new;
+
+		memcpy(limit, data + i, sizeof(limit));
+		new.rlim_cur = limit[1] == UINT64_MAX ? RLIM_INFINITY : (rlim_t) limit[1];
+		new.rlim_max = limit[2] == UINT64_MAX ? RLIM_INFINITY : (rlim_t) limit[2];
+		if (getrlimit(limit[0], &old) < 0)
+			bail("failed to get rlimit type %llu", (unsigned long long) limit[0]);
+		if (setrlimit(limit[0], &new) < 0)
+			bail("failed to raise the hard limit of rlimit type %llu from %llu to %llu",
+			     (unsigned long long) limit[0], (unsigned long long) old.rlim_max,
+			     (unsigned long long) limit[2]);
+	}
+}
+
 /* A dummy function that just jumps to the given jumpval. */
 static int child_func(void *arg) __attribute__ ((noinline));
 static int child_func(void *arg)
@@ -291,6 +352,29 @@ static uint8_t readint8(char *buf)
 	return *(uint8_t *) buf;
 }
 
+/*
+ * read_all reads exactly count bytes from fd, unless EOF or an error is hit
+ * first. The bootstrap payload can be larger than the socket buffer, in which
+ * case a single read(2) will only return part of it.
+ */
+static ssize_t read_all(int fd, void *buf, size_t count)
+{
+	size_t total = 0;
+
+	while (total < count) {
+		ssize_t n = read(fd, (char *)buf + total, count - total);
+		if (n < 0) {
+			if (errno == EINTR)
+				continue;
+			return -1;
+		}
+		if (n == 0)
+			break;
+		total += n;
+	}
+	return total;
+}
+
 static void nl_parse(int fd, struct nlconfig_t *config)
 {
 	size_t len, size;
@@ -298,7 +382,7 @@ static void nl_parse(int fd, struct nlconfig_t *config)
 	char *data, *current;
 
 	/* Retrieve the netlink header. */
-	len = read(fd, &hdr, NLMSG_HDRLEN);
+	len = read_all(fd, &hdr, NLMSG_HDRLEN);
 	if (len != NLMSG_HDRLEN)
 		bail("invalid netlink header length %zu", len);
 
@@ -308,13 +392,18 @@ static void nl_parse(int fd, struct nlconfig_t *config)
 	if (hdr.nlmsg_type != INIT_MSG)
 		bail("unexpected msg type %d", hdr.nlmsg_type);
 
+	if (hdr.nlmsg_len < NLMSG_HDRLEN)
+		bail("invalid netlink message length %u", hdr.nlmsg_len);
+	if (hdr.nlmsg_len > BOOTSTRAP_DATA_MAX)
+		bail("bootstrap data too large (%u bytes, max %d)", hdr.nlmsg_len, BOOTSTRAP_DATA_MAX);
+
 	/* Retrieve data. */
 	size = NLMSG_PAYLOAD(&hdr, 0);
 	current = data = malloc(size);
 	if (!data)
 		bail("failed to allocate %zu bytes of memory for nl_payload", size);
 
-	len = read(fd, data, size);
+	len = read_all(fd, data, size);
 	if (len != size)
 		bail("failed to read netlink payload, %zu != %zu", len, size);
 
@@ -354,6 +443,19 @@ static void nl_parse(int fd, struct nlconfig_t *config)
 		case SETGROUP_ATTR:
 			config->is_setgroup = readint8(current);
 			break;
+		case SETNS_RETRIES_ATTR:
+			config->setns_retries = readint32(current);
+			break;
+		case SETNS_BACKOFF_ATTR:
+			config->setns_backoff = readint32(current);
+			break;
+		case RLIMITS_ATTR:
+			config->rlimits = current;
+			config->rlimits_len = payload_len;
+			break;
+		case BATCH_ATTR:
+			config->batch_fd = readint32(current);
+			break;
 		default:
 			bail("unknown netlink message type %d", nlattr->nla_type);
 		}
@@ -362,13 +464,150 @@ static void nl_parse(int fd, struct nlconfig_t *config)
 	}
 }
 
+/*
+ * The stdio of a process of a batch which are sent along with its init pipe.
+ * These must be kept in sync with libcontainer/batch_linux.go.
+ */
+#define BATCH_STDIN  (1 << 0)
+#define BATCH_STDOUT (1 << 1)
+#define BATCH_STDERR (1 << 2)
+#define BATCH_FDS_MAX 4
+
+static void batch_reply(int batchfd, int32_t value)
+{
+	if (write(batchfd, &value, sizeof(value)) != sizeof(value))
+		bail("failed to write to batch socket");
+}
+
+/*
+ * Runs the processes of a batch, which the parent sends us over the batch
+ * socket one at a time, each with its init pipe and the stdio it has. Each is
+ * forked from us, in the namespaces we joined once for all of them, and
+ * returns to the Go runtime as a setns process which gets its config from the
+ * parent over its init pipe. We reply with its pid once it is forked and with
+ * its wait status once it exited. We exit once the parent hangs up, so this
+ * only returns in the processes we fork.
+ */
+static void run_batch(int pipenum, int batchfd)
+{
+	/* The parent is not listening on the sync pipe anymore. */
+	syncfd = -1;
+
+	for (;;) {
+		uint8_t mask;
+		int fds[BATCH_FDS_MAX], nfds = 0, i, n, status;
+		char control[CMSG_SPACE(sizeof(fds))];
+		struct iovec iov = {
+			.iov_base = &mask,
+			.iov_len = sizeof(mask),
+		};
+		struct msghdr msg = {
+			.msg_iov = &iov,
+			.msg_iovlen = 1,
+			.msg_control = control,
+			.msg_controllen = sizeof(control),
+		};
+		struct cmsghdr *cmsg;
+		pid_t child;
+
+		n = recvmsg(batchfd, &msg, MSG_CMSG_CLOEXEC);
+		if (n < 0) {
+			if (errno == EINTR)
+				continue;
+			bail("failed to receive batch process");
+		}
+		if (n == 0)
+			exit(0);
+		cmsg = CMSG_FIRSTHDR(&msg);
+		if (cmsg && cmsg->cmsg_level == SOL_SOCKET && cmsg->cmsg_type == SCM_RIGHTS) {
+			nfds = (cmsg->cmsg_len - CMSG_LEN(0)) / sizeof(int);
+			memcpy(fds, CMSG_DATA(cmsg), nfds * sizeof(int));
+		}
+		if (nfds < 1 || msg.msg_flags & (MSG_TRUNC | MSG_CTRUNC))
+			bail("invalid batch process message");
+
+		child = fork();
+		if (child < 0)
+			bail("failed to fork batch process");
+		if (child == 0) {
+			/* The stdio which are not set are those we got, /dev/null. */
+			if (dup2(fds[0], pipenum) < 0)
+				bail("failed to dup batch init pipe");
+			for (i = 0, n = 1; i < 3; i++) {
+				if (!(mask & (1 << i)))
+					continue;
+				if (n >= nfds)
+					bail("missing batch process stdio %d", i);
+				if (dup2(fds[n++], i) < 0)
+					bail("failed to dup batch process stdio %d", i);
+			}
+			for (i = 0; i < nfds; i++)
+				close(fds[i]);
+			close(batchfd);
+			return;
+		}
+
+		for (i = 0; i < nfds; i++)
+			close(fds[i]);
+		batch_reply(batchfd, child);
+		while (waitpid(child, &status, 0) < 0) {
+			if (errno != EINTR)
+				bail("failed to wait for batch process");
+		}
+		batch_reply(batchfd, status);
+	}
+}
+
 void nl_free(struct nlconfig_t *config)
 {
 	free(config->data);
 }
 
-void join_namespaces(char *nslist)
+/*
+ * Tells the parent over the init pipe which namespace could not be joined and
+ * why, so that it can tell a container going away from a real failure. This
+ * must be kept in sync with nsError in libcontainer/process_linux.go.
+ */
+static void report_ns_error(int pipenum, const char *type)
+{
+	int saved_errno = errno;
+	char buf[JSON_MAX];
+	int len;
+
+	len = snprintf(buf, JSON_MAX, "{\"setns\": {\"type\": \"%s\", \"errno\": %d}}\n", type, saved_errno);
+	if (len > 0 && len < JSON_MAX) {
+		if (write(pipenum, buf, len) != len)
+			fprintf(stderr, "nsenter: failed: write(setns error)\n");
+	}
+	errno = saved_errno;
+}
+
+/*
+ * The backoff between two setns attempts doubles after each of them, but is
+ * capped so that neither the shift nor a large backoff can overflow. This must
+ * be kept in sync with MaxSetnsBackoff in libcontainer/factory_linux.go.
+ */
+#define SETNS_MAX_BACKOFF_US 1000000ULL
+
+static void setns_sleep(uint32_t backoff, uint32_t tries)
+{
+	uint64_t delay = backoff;
+	struct timespec ts;
+
+	while (tries-- > 0 && delay < SETNS_MAX_BACKOFF_US)
+		delay <<= 1;
+	if (delay > SETNS_MAX_BACKOFF_US)
+		delay = SETNS_MAX_BACKOFF_US;
+
+	ts.tv_sec = delay / 1000000;
+	ts.tv_nsec = (delay % 1000000) * 1000;
+	while (nanosleep(&ts, &ts) < 0 && errno == EINTR)
+		;
+}
+
+void join_namespaces(int pipenum, struct nlconfig_t *config)
 {
+	char *nslist = config->namespaces;
 	int num = 0, i;
 	char *saveptr = NULL;
 	char *namespace = strtok_r(nslist, ",", &saveptr);
@@ -403,10 +642,26 @@ void join_namespaces(char *nslist)
 		if (!path)
 			bail("failed to parse %s", namespace);
 		*path++ = '\0';
+		strncpy(ns->type, namespace, PATH_MAX - 1);
+		ns->type[PATH_MAX - 1] = '\0';
 
-		fd = open(path, O_RDONLY);
-		if (fd < 0)
-			bail("failed to open %s", path);
+		/*
+		 * 'ns:fd:N' refers to a namespace file we inherited as fd N, which
+		 * we can join directly without going through a path.
+		 */
+		if (!strncmp(path, NS_FD_PREFIX, strlen(NS_FD_PREFIX))) {
+			char *endptr;
+
+			fd = strtol(path + strlen(NS_FD_PREFIX), &endptr, 10);
+			if (*endptr != '\0' || fd < 0)
+				bail("failed to parse namespace fd %s", path);
+		} else {
+			fd = open(path, O_RDONLY);
+			if (fd < 0) {
+				report_ns_error(pipenum, ns->type);
+				bail("failed to open %s", path);
+			}
+		}
 
 		ns->fd = fd;
 		ns->ns = nsflag(namespace);
@@ -422,9 +677,20 @@ void join_namespaces(char *nslist)
 
 	for (i = 0; i < num; i++) {
 		struct namespace_t ns = namespaces[i];
+		uint32_t tries = 0;
 
-		if (setns(ns.fd, ns.ns) < 0)
-			bail("failed to setns to %s", ns.path);
+		/*
+		 * EBUSY is transient, e.g. while the stub of a previous exec has
+		 * not finished detaching, so it is retried with a doubling backoff.
+		 */
+		while (setns(ns.fd, ns.ns) < 0) {
+			if (errno != EBUSY || tries >= config->setns_retries) {
+				report_ns_error(pipenum, ns.type);
+				bail("failed to setns to %s", ns.path);
+			}
+			setns_sleep(config->setns_backoff, tries);
+			tries++;
+		}
 
 		close(ns.fd);
 	}
@@ -458,6 +724,13 @@ void nsexec(void)
 	 */
 	update_oom_score_adj(config.oom_score_adj, config.oom_score_adj_len);
 
+	/*
+	 * Raise the rlimits which setupRlimits could not once the container
+	 * process is in a user namespace. We are still in the one of runc here,
+	 * and our children inherit the limits, leaving setupRlimits to lower them.
+	 */
+	setup_rlimits(config.rlimits, config.rlimits_len);
+
 	/*
 	 * Make the process non-dumpable, to avoid various race conditions that
 	 * could cause processes in namespaces we're joining to access host
@@ -707,7 +980,7 @@ void nsexec(void)
 			 * using cmsg(3) but that's just annoying.
 			 */
 			if (config.namespaces)
-				join_namespaces(config.namespaces);
+				join_namespaces(pipenum, &config);
 
 			/*
 			 * Unshare all of the namespaces. Now, it should be noted that this
@@ -718,8 +991,12 @@ void nsexec(void)
 			 * Note that we don't merge this with clone() because there were
 			 * some old kernel versions where clone(CLONE_PARENT | CLONE_NEWPID)
 			 * was broken, so we'll just do it the long way anyway.
+			 *
+			 * The cgroup namespace is left to [stage 2: JUMP_INIT], as its
+			 * root has to be the cgroup of the container, which we are not in
+			 * yet.
 			 */
-			if (unshare(config.cloneflags) < 0)
+			if (unshare(config.cloneflags & ~CLONE_NEWCGROUP) < 0)
 				bail("failed to unshare namespaces");
 
 			/*
@@ -848,6 +1125,28 @@ void nsexec(void)
 			/* Close sync pipes. */
 			close(sync_grandchild_pipe[0]);
 
+			/*
+			 * Wait for the parent to put us in the cgroups of the container,
+			 * which it does once it has our pid, before creating the cgroup
+			 * namespace.
+			 */
+			if (config.cloneflags & CLONE_NEWCGROUP) {
+				uint8_t value;
+				if (read(pipenum, &value, sizeof(value)) != sizeof(value))
+					bail("failed to read the cgroup namespace sync byte");
+				if (value != CREATECGROUPNS)
+					bail("received unknown cgroup namespace sync byte %#x", value);
+				if (unshare(CLONE_NEWCGROUP) < 0)
+					bail("failed to unshare cgroup namespace");
+			}
+
+			/*
+			 * The runner of a batch never returns to the Go runtime, the
+			 * processes it forks do.
+			 */
+			if (config.batch_fd > 0)
+				run_batch(pipenum, config.batch_fd);
+
 			/* Free netlink data. */
 			nl_free(&config);
 
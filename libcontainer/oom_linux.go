@@ -0,0 +1,191 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall" // only for WaitStatus
+	"unsafe"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+
+	"golang.org/x/sys/unix"
+)
+
+// OOMKilledError is returned when waiting for the init process of a
+// container which the OOM killer killed, instead of a plain "signal:
+// killed" exec.ExitError.
+type OOMKilledError struct {
+	*exec.ExitError
+}
+
+func (e *OOMKilledError) Error() string {
+	return e.ExitError.Error() + " (out of memory)"
+}
+
+// oomWatcher watches the memory cgroup of an init process from its start,
+// to tell whether the OOM killer is what killed it.
+type oomWatcher struct {
+	paths map[string]string
+	// baseline is the number of OOM kills counted by the cgroup before the
+	// init process started, if counted is set.
+	baseline uint64
+	counted  bool
+
+	mu       sync.Mutex
+	notified bool
+}
+
+// watchOOM starts watching the memory cgroup of paths. Failing to register
+// for its OOM notifications is not fatal, the kill counter of the cgroup
+// is enough on kernels which have one.
+func watchOOM(paths map[string]string) *oomWatcher {
+	baseline, counted := oomKillCount(paths)
+	return resumeOOMWatch(paths, baseline, counted)
+}
+
+// resumeOOMWatch is watchOOM for an init process whose memory cgroup
+// counted baseline OOM kills when it started, if counted is set.
+func resumeOOMWatch(paths map[string]string, baseline uint64, counted bool) *oomWatcher {
+	w := &oomWatcher{paths: paths, baseline: baseline, counted: counted}
+	ch, err := notifyOnOOM(paths)
+	if err != nil {
+		logrus.Debugf("registering for OOM notifications: %v", err)
+		return w
+	}
+	go func() {
+		for range ch {
+			w.mu.Lock()
+			w.notified = true
+			w.mu.Unlock()
+		}
+	}()
+	return w
+}
+
+// killed returns whether the OOM killer killed the init process, which
+// exited with ws: it must have been killed with SIGKILL while the cgroup
+// counted a new OOM kill or, on kernels which do not count them, while an
+// OOM notification was received.
+func (w *oomWatcher) killed(ws syscall.WaitStatus) bool {
+	if w == nil || !ws.Signaled() || ws.Signal() != unix.SIGKILL {
+		return false
+	}
+	if count, ok := oomKillCount(w.paths); ok && w.counted {
+		return count > w.baseline
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.notified
+}
+
+// memoryCgroupDir returns the directory of the memory cgroup of paths, that
+// of the unified hierarchy on cgroup v2.
+func memoryCgroupDir(paths map[string]string) string {
+	if dir := paths[oomCgroupName]; dir != "" {
+		return dir
+	}
+	return paths[""]
+}
+
+// oomEventsFile returns the file of dir counting OOM kills.
+func oomEventsFile(dir string) string {
+	if cgroups.IsCgroup2UnifiedMode() {
+		return filepath.Join(dir, "memory.events")
+	}
+	return filepath.Join(dir, "memory.oom_control")
+}
+
+// oomKillCount returns how many processes the OOM killer killed in the
+// memory cgroup of paths, and false if it does not count them, which
+// kernels older than 4.13 do not.
+func oomKillCount(paths map[string]string) (uint64, bool) {
+	dir := memoryCgroupDir(paths)
+	if dir == "" {
+		return 0, false
+	}
+	return readMemoryEvent(oomEventsFile(dir), "oom_kill")
+}
+
+// readMemoryEvent reads the counter name from a file of flat keyed values
+// such as memory.oom_control or memory.events.
+func readMemoryEvent(path, name string) (uint64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == name {
+			n, err := strconv.ParseUint(fields[1], 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// notifyOnOOMUnified is notifyOnOOM for cgroup v2, which has no
+// cgroup.event_control: memory.events is watched with inotify instead, and
+// a notification sent whenever its oom counter increases.
+func notifyOnOOMUnified(dir string) (<-chan struct{}, error) {
+	path := filepath.Join(dir, "memory.events")
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := unix.InotifyAddWatch(fd, path, unix.IN_MODIFY); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	ooms, _ := readMemoryEvent(path, "oom")
+	ch := make(chan struct{})
+	go func() {
+		defer func() {
+			close(ch)
+			f.Close()
+		}()
+		buf := make([]byte, 64*unix.SizeofInotifyEvent)
+		for {
+			n, err := f.Read(buf)
+			if err != nil || inotifyIgnored(buf[:n]) {
+				return
+			}
+			count, ok := readMemoryEvent(path, "oom")
+			if !ok {
+				return
+			}
+			if count > ooms {
+				ooms = count
+				ch <- struct{}{}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// inotifyIgnored returns true if any of the inotify events in buf reports
+// that the watch was removed, as it is along with the cgroup.
+func inotifyIgnored(buf []byte) bool {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		if ev.Mask&unix.IN_IGNORED != 0 {
+			return true
+		}
+		end := unix.SizeofInotifyEvent + int(ev.Len)
+		if end > len(buf) {
+			break
+		}
+		buf = buf[end:]
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestOOMWatcherKilled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	paths := map[string]string{oomCgroupName: dir}
+	events := oomEventsFile(dir)
+	if err := ioutil.WriteFile(events, []byte("oom_kill_disable 0\nunder_oom 0\noom_kill 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		exited  = syscall.WaitStatus(0)
+		killed  = syscall.WaitStatus(syscall.SIGKILL)
+		stopped = syscall.WaitStatus(syscall.SIGTERM)
+	)
+	w := &oomWatcher{paths: paths}
+	w.baseline, w.counted = oomKillCount(paths)
+	if !w.counted || w.baseline != 1 {
+		t.Fatalf("expected a baseline of 1 kill, got %d, %v", w.baseline, w.counted)
+	}
+	if w.killed(killed) {
+		t.Fatal("expected a kill counted before the start not to be reported")
+	}
+	if err := ioutil.WriteFile(events, []byte("oom_kill_disable 0\nunder_oom 0\noom_kill 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.killed(killed) {
+		t.Fatal("expected a new kill to be reported")
+	}
+	if w.killed(exited) || w.killed(stopped) {
+		t.Fatal("expected only a SIGKILL to be reported")
+	}
+
+	// Kernels which do not count the kills fall back to the notifications.
+	if err := ioutil.WriteFile(events, []byte("oom_kill_disable 0\nunder_oom 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = &oomWatcher{paths: paths}
+	w.baseline, w.counted = oomKillCount(paths)
+	if w.counted || w.killed(killed) {
+		t.Fatal("expected no kill to be reported without counter nor notification")
+	}
+	w.notified = true
+	if !w.killed(killed) {
+		t.Fatal("expected a notified kill to be reported")
+	}
+
+	var none *oomWatcher
+	if none.killed(killed) {
+		t.Fatal("expected an unwatched init process not to be reported")
+	}
+}
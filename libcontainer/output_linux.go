@@ -0,0 +1,101 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// exitFileOutputMax bounds the output included in the exit file.
+const exitFileOutputMax = 4 * 1024
+
+// outputRing retains the last bytes of output of a process, overwriting the
+// oldest ones once full.
+type outputRing struct {
+	mu  sync.Mutex
+	buf []byte
+	// next is where the next byte goes, and full is set once buf wrapped.
+	next int
+	full bool
+}
+
+func newOutputRing(size int) *outputRing {
+	return &outputRing{buf: make([]byte, size)}
+}
+
+// record retains b. It is safe to call on a nil outputRing.
+func (r *outputRing) record(b []byte) {
+	if r == nil || len(b) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(b) >= len(r.buf) {
+		copy(r.buf, b[len(b)-len(r.buf):])
+		r.next, r.full = 0, true
+		return
+	}
+	n := copy(r.buf[r.next:], b)
+	if n < len(b) {
+		copy(r.buf, b[n:])
+		r.full = true
+	}
+	r.next = (r.next + len(b)) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// tail returns a copy of the last n bytes retained, or all of them if n is
+// not positive.
+func (r *outputRing) tail(n int) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []byte
+	if r.full {
+		out = append(append(out, r.buf[r.next:]...), r.buf[:r.next]...)
+	} else {
+		out = append(out, r.buf[:r.next]...)
+	}
+	if n > 0 && n < len(out) {
+		out = out[len(out)-n:]
+	}
+	return out
+}
+
+// retainWriter retains the output written through it in a ring.
+type retainWriter struct {
+	w io.Writer
+	r *outputRing
+}
+
+func (w *retainWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.r.record(b[:n])
+	return n, err
+}
+
+// retainStdio retains the stdout and stderr of cmd in r. Those which are
+// files are handed to the process as they are, so their output never goes
+// through us and cannot be retained.
+func retainStdio(cmd *exec.Cmd, r *outputRing) {
+	if _, ok := cmd.Stdout.(*os.File); !ok && cmd.Stdout != nil {
+		cmd.Stdout = &retainWriter{w: cmd.Stdout, r: r}
+	}
+	if _, ok := cmd.Stderr.(*os.File); !ok && cmd.Stderr != nil {
+		cmd.Stderr = &retainWriter{w: cmd.Stderr, r: r}
+	}
+}
+
+func (c *linuxContainer) TailOutput(n int) ([]byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.output == nil {
+		return nil, newGenericError(fmt.Errorf("the output of the container is not retained"), ConfigInvalid)
+	}
+	return c.output.tail(n), nil
+}
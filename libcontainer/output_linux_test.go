@@ -0,0 +1,61 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestOutputRing(t *testing.T) {
+	r := newOutputRing(8)
+	r.record([]byte("abc"))
+	if got := string(r.tail(0)); got != "abc" {
+		t.Fatalf("expected abc, got %q", got)
+	}
+	r.record([]byte("defgh"))
+	r.record([]byte("ij"))
+	if got := string(r.tail(0)); got != "cdefghij" {
+		t.Fatalf("expected the oldest bytes to be overwritten, got %q", got)
+	}
+	if got := string(r.tail(3)); got != "hij" {
+		t.Fatalf("expected hij, got %q", got)
+	}
+	r.record([]byte("0123456789"))
+	if got := string(r.tail(100)); got != "23456789" {
+		t.Fatalf("expected the end of a write larger than the ring, got %q", got)
+	}
+}
+
+func TestRetainStdio(t *testing.T) {
+	var out bytes.Buffer
+	r := newOutputRing(64)
+	cmd := exec.Command("/bin/sh", "-c", "echo out; echo err >&2")
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	cmd.Stdout = &out
+	cmd.Stderr = devNull
+	retainStdio(cmd, r)
+	if cmd.Stderr != devNull {
+		t.Fatal("expected a file to be handed to the process as it is")
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "out\n" || string(r.tail(0)) != "out\n" {
+		t.Fatalf("expected the output to be passed on and retained, got %q and %q", out.String(), r.tail(0))
+	}
+}
+
+func TestTailOutputNotRetained(t *testing.T) {
+	c := &linuxContainer{}
+	_, err := c.TailOutput(0)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected a ConfigInvalid error, got %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall" // only for SysProcAttr, SysProcIDMap and Stat_t
+	"time"
+	"unsafe"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/mount"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ownedMountsDir is the directory of the state directory where the
+	// views of the mounts with a forced owner are mounted.
+	ownedMountsDir = "owned"
+	// ownerHelperTimeout is how long the FUSE helper may take to mount a
+	// view.
+	ownerHelperTimeout = 10 * time.Second
+)
+
+// The new mount API, which golang.org/x/sys/unix does not have yet. The
+// syscalls have the same number on every architecture but alpha, ia64 and
+// mips, where they fail and the FUSE helper is used instead.
+const (
+	sysOpenTree     = 428
+	sysMoveMount    = 429
+	sysMountSetattr = 442
+
+	openTreeClone       = 0x1
+	atEmptyPath         = 0x1000
+	atRecursive         = 0x8000
+	moveMountFEmptyPath = 0x4
+	mountAttrIdmap      = 0x100000
+)
+
+// mountAttr is struct mount_attr, as of MOUNT_ATTR_SIZE_VER0.
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// ownedMountPath returns where the view of the mount index of the container
+// in the state directory root is mounted.
+func ownedMountPath(root string, index int) string {
+	return filepath.Join(root, ownedMountsDir, strconv.Itoa(index))
+}
+
+// ownerHelperPidFile returns the file where the pid and the start time of
+// the FUSE helper serving the view at path are written.
+func ownerHelperPidFile(path string) string {
+	return path + ".pid"
+}
+
+// setupOwnedMounts mounts the views of the mounts of the container with a
+// forced owner, which the init process bind mounts instead of their
+// sources, and where every file appears as the forced owner. A view is an
+// idmapped mount of the source when all its files have the same owner, as
+// an idmapping cannot squash several owners into one, and the kernel and
+// the filesystem of the source support them. It is otherwise served by the
+// FUSE helper of the factory.
+func (c *linuxContainer) setupOwnedMounts() error {
+	for i, m := range c.config.Mounts {
+		if m.ForcedOwner == nil {
+			continue
+		}
+		if err := c.setupOwnedMount(i, m); err != nil {
+			if rerr := c.releaseOwnedMounts(); rerr != nil {
+				logrus.Warn(rerr)
+			}
+			return newSystemErrorWithCause(err, fmt.Sprintf("setting up the forced owner of %s", m.Destination))
+		}
+	}
+	return nil
+}
+
+func (c *linuxContainer) setupOwnedMount(index int, m *configs.Mount) error {
+	uid, err := c.config.HostUID(m.ForcedOwner.UID)
+	if err != nil {
+		return err
+	}
+	gid, err := c.config.HostGID(m.ForcedOwner.GID)
+	if err != nil {
+		return err
+	}
+	// The init process may be in a user namespace, which has to be able to
+	// look the views up.
+	path := ownedMountPath(c.root, index)
+	if err := os.MkdirAll(path, 0711); err != nil {
+		return err
+	}
+	ierr := c.idmapMount(m, path, uid, gid)
+	if ierr == nil {
+		return nil
+	}
+	logrus.Debugf("idmapping %s: %v, falling back to %s", m.Source, ierr, c.ownerHelperPath)
+	if err := c.startOwnerHelper(m, path, uid, gid); err != nil {
+		return fmt.Errorf("idmapping: %v, %s: %v", ierr, c.ownerHelperPath, err)
+	}
+	return nil
+}
+
+// idmapMount mounts at path an idmapped view of the source of m, where the
+// files of the owner of the source appear owned by uid and gid. It fails if
+// some file of the source has another owner.
+func (c *linuxContainer) idmapMount(m *configs.Mount, path string, uid, gid int) error {
+	var st unix.Stat_t
+	if err := unix.Stat(m.Source, &st); err != nil {
+		return &os.PathError{Op: "stat", Path: m.Source, Err: err}
+	}
+	recursive := m.Flags&unix.MS_REC != 0
+	if err := checkSingleOwner(m.Source, st.Uid, st.Gid, recursive); err != nil {
+		return err
+	}
+	userns, err := c.idmapUserNamespace(int(st.Uid), uid, int(st.Gid), gid)
+	if err != nil {
+		return err
+	}
+	defer userns.Close()
+	fd, err := openTree(m.Source, recursive)
+	if err != nil {
+		return &os.PathError{Op: "open_tree", Path: m.Source, Err: err}
+	}
+	defer unix.Close(fd)
+	if err := setIdmap(fd, int(userns.Fd()), recursive); err != nil {
+		return &os.PathError{Op: "mount_setattr", Path: m.Source, Err: err}
+	}
+	if err := moveMount(fd, path); err != nil {
+		return &os.PathError{Op: "move_mount", Path: path, Err: err}
+	}
+	return nil
+}
+
+// singleOwnerCheckLimit is how many files under the source of a mount
+// checkSingleOwner looks at.
+var singleOwnerCheckLimit = 4096
+
+// errSingleOwnerCheckDone stops the walk of checkSingleOwner.
+var errSingleOwnerCheckDone = fmt.Errorf("single owner check done")
+
+// checkSingleOwner returns an error if a file under source, including the
+// mounts under it if recursive, is not owned by uid and gid, as it would
+// appear owned by the overflow ids in an idmapped view. Only the first
+// singleOwnerCheckLimit files are looked at, and files created or chowned
+// meanwhile are missed: the check only spots the sources which obviously
+// have several owners.
+func checkSingleOwner(source string, uid, gid uint32, recursive bool) error {
+	var dev uint64
+	checked := 0
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if checked == singleOwnerCheckLimit {
+			return errSingleOwnerCheckDone
+		}
+		checked++
+		st := info.Sys().(*syscall.Stat_t)
+		if path == source {
+			dev = st.Dev
+		} else if !recursive && info.IsDir() && st.Dev != dev {
+			// Mounts under the source are not part of the view.
+			return filepath.SkipDir
+		}
+		if st.Uid != uid || st.Gid != gid {
+			return fmt.Errorf("%s is owned by %d:%d rather than %d:%d like %s", path, st.Uid, st.Gid, uid, gid, source)
+		}
+		return nil
+	})
+	if err == errSingleOwnerCheckDone {
+		return nil
+	}
+	return err
+}
+
+// idmapUserNamespace returns a user namespace mapping ownerUID and ownerGID
+// to uid and gid, for an idmapped mount. It is created by starting the init
+// with _LIBCONTAINER_IDMAPNS, which holds it until its stdin is closed.
+func (c *linuxContainer) idmapUserNamespace(ownerUID, uid, ownerGID, gid int) (*os.File, error) {
+	cmd := exec.Command(c.initArgs[0], c.initArgs[1:]...)
+	if c.initBinary != nil {
+		cmd.Path = initBinaryPath(c.initBinary)
+	}
+	cmd.Dir = "/"
+	cmd.Env = []string{"_LIBCONTAINER_IDMAPNS=1"}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  unix.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: ownerUID, HostID: uid, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: ownerGID, HostID: gid, Size: 1}},
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		return nil, err
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+	// The mappings are written before the init is executed.
+	return os.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid))
+}
+
+// runIdmapNS holds the user namespace of an idmapped mount, see
+// idmapUserNamespace.
+func runIdmapNS() error {
+	os.Clearenv()
+	io.Copy(ioutil.Discard, os.Stdin)
+	os.Exit(0)
+	return nil
+}
+
+func openTree(path string, recursive bool) (int, error) {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+	dirfd := unix.AT_FDCWD
+	flags := openTreeClone | unix.O_CLOEXEC
+	if recursive {
+		flags |= atRecursive
+	}
+	fd, _, errno := unix.Syscall(sysOpenTree, uintptr(dirfd), uintptr(unsafe.Pointer(p)), uintptr(flags))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+func setIdmap(fd, userns int, recursive bool) error {
+	empty, err := unix.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	flags := atEmptyPath
+	if recursive {
+		flags |= atRecursive
+	}
+	attr := mountAttr{attrSet: mountAttrIdmap, usernsFd: uint64(userns)}
+	_, _, errno := unix.Syscall6(sysMountSetattr, uintptr(fd), uintptr(unsafe.Pointer(empty)), uintptr(flags),
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func moveMount(fd int, path string) error {
+	empty, err := unix.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	dirfd := unix.AT_FDCWD
+	_, _, errno := unix.Syscall6(sysMoveMount, uintptr(fd), uintptr(unsafe.Pointer(empty)),
+		uintptr(dirfd), uintptr(unsafe.Pointer(p)), moveMountFEmptyPath, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// startOwnerHelper has the FUSE helper of the factory serve at path a view
+// of the source of m where every file is owned by uid and gid. It is run
+// like bindfs, in the foreground until the view is unmounted:
+//
+//	<helper> -f -o allow_other --force-user=<uid> --force-group=<gid> <source> <path>
+//
+// The helper runs in a session of its own so as to outlive us, and is
+// stopped when the container is destroyed, through the pid file written
+// next to the view.
+func (c *linuxContainer) startOwnerHelper(m *configs.Mount, path string, uid, gid int) error {
+	cmd := exec.Command(c.ownerHelperPath, "-f", "-o", "allow_other",
+		fmt.Sprintf("--force-user=%d", uid), fmt.Sprintf("--force-group=%d", gid), m.Source, path)
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	pid := cmd.Process.Pid
+	stat, err := system.Stat(pid)
+	if err == nil {
+		err = ioutil.WriteFile(ownerHelperPidFile(path), []byte(fmt.Sprintf("%d\n%d\n", pid, stat.StartTime)), 0600)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	timeout := time.After(ownerHelperTimeout)
+	for {
+		if mounted, err := mount.Mounted(path); err != nil || mounted {
+			return err
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = fmt.Errorf("exited")
+			}
+			return fmt.Errorf("serving %s: %v", path, err)
+		case <-timeout:
+			return fmt.Errorf("timed out serving %s", path)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// stopOwnerHelper kills the FUSE helper serving the view at path, if any.
+func stopOwnerHelper(path string) error {
+	pidFile := ownerHelperPidFile(path)
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var pid int
+	var startTime uint64
+	if _, err := fmt.Sscan(string(data), &pid, &startTime); err != nil {
+		return fmt.Errorf("invalid pid file %s: %v", pidFile, err)
+	}
+	// The helper may have exited and its pid been reused.
+	if stat, err := system.Stat(pid); err == nil && stat.StartTime == startTime {
+		if err := unix.Kill(pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
+	return os.Remove(pidFile)
+}
+
+// releaseOwnedMounts unmounts the views of the mounts with a forced owner
+// and stops their FUSE helpers. The views are only removed once unmounted,
+// so that the files of their sources cannot be removed along with the
+// state directory.
+func (c *linuxContainer) releaseOwnedMounts() error {
+	dir := filepath.Join(c.root, ownedMountsDir)
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// The entries are not stat'ed, which fails for views whose FUSE helper
+	// has died.
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, ".pid") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := unix.Unmount(path, unix.MNT_DETACH); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+			return &os.PathError{Op: "unmount forced owner view", Path: path, Err: err}
+		}
+		if err := stopOwnerHelper(path); err != nil {
+			logrus.Warn(err)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ownedMountsConfig returns the config the init process is started with,
+// where the sources of the mounts with a forced owner are their views.
+func (c *linuxContainer) ownedMountsConfig() *configs.Config {
+	config := c.config
+	for i, m := range c.config.Mounts {
+		if m.ForcedOwner == nil {
+			continue
+		}
+		if config == c.config {
+			copied := *c.config
+			copied.Mounts = append([]*configs.Mount(nil), c.config.Mounts...)
+			config = &copied
+		}
+		owned := *m
+		owned.Source = ownedMountPath(c.root, i)
+		config.Mounts[i] = &owned
+	}
+	return config
+}
+
+// helperServedMount returns the destination of the first mount of the
+// container whose view is served by a FUSE helper, if any.
+func (c *linuxContainer) helperServedMount() string {
+	for i, m := range c.config.Mounts {
+		if m.ForcedOwner == nil {
+			continue
+		}
+		if _, err := os.Stat(ownerHelperPidFile(ownedMountPath(c.root, i))); err == nil {
+			return m.Destination
+		}
+	}
+	return ""
+}
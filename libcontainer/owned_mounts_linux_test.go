@@ -0,0 +1,119 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+func TestOwnedMountsConfig(t *testing.T) {
+	plain := &configs.Mount{Source: "/var/lib", Destination: "/lib", Device: "bind"}
+	owned := &configs.Mount{
+		Source:      "/var/cache",
+		Destination: "/cache",
+		Device:      "bind",
+		ForcedOwner: &configs.MountOwner{UID: 1000, GID: 1000},
+	}
+	c := &linuxContainer{root: "/run/test", config: &configs.Config{Mounts: []*configs.Mount{plain}}}
+	if c.ownedMountsConfig() != c.config {
+		t.Fatal("expected a config without forced owners to be left alone")
+	}
+	c.config.Mounts = append(c.config.Mounts, owned)
+	config := c.ownedMountsConfig()
+	if config.Mounts[0] != plain {
+		t.Fatal("expected the mounts without a forced owner to be kept")
+	}
+	if src := config.Mounts[1].Source; src != "/run/test/owned/1" {
+		t.Fatalf("expected the view as the source, got %s", src)
+	}
+	if owned.Source != "/var/cache" {
+		t.Fatal("expected the config of the container to be left alone")
+	}
+}
+
+func TestCheckSingleOwner(t *testing.T) {
+	root, err := ioutil.TempDir("", "owned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(root, "a", "b", "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	if err := checkSingleOwner(root, uid, gid, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(file, 1000, 1000); err != nil {
+		t.Skip(err)
+	}
+	if err := checkSingleOwner(root, uid, gid, true); err == nil {
+		t.Fatal("expected a file with another owner to be refused")
+	}
+	// The walk stops before reaching the file.
+	defer func(limit int) { singleOwnerCheckLimit = limit }(singleOwnerCheckLimit)
+	singleOwnerCheckLimit = 3
+	if err := checkSingleOwner(root, uid, gid, true); err != nil {
+		t.Fatalf("expected the walk to stop before the file, got %v", err)
+	}
+}
+
+func TestReleaseOwnedMountsStopsHelper(t *testing.T) {
+	root, err := ioutil.TempDir("", "owned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	c := &linuxContainer{root: root, config: &configs.Config{Mounts: []*configs.Mount{{
+		Source:      "/var/cache",
+		Destination: "/cache",
+		Device:      "bind",
+		ForcedOwner: &configs.MountOwner{UID: 1000, GID: 1000},
+	}}}}
+	path := ownedMountPath(root, 0)
+	if err := os.MkdirAll(path, 0711); err != nil {
+		t.Fatal(err)
+	}
+	if dest := c.helperServedMount(); dest != "" {
+		t.Fatalf("expected no mount served by a helper, got %s", dest)
+	}
+
+	helper := exec.Command("sleep", "60")
+	if err := helper.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Process.Kill()
+	stat, err := system.Stat(helper.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pidFile := ownerHelperPidFile(path)
+	if err := ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n%d\n", helper.Process.Pid, stat.StartTime)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if dest := c.helperServedMount(); dest != "/cache" {
+		t.Fatalf("expected /cache to be served by a helper, got %q", dest)
+	}
+
+	if err := c.releaseOwnedMounts(); err != nil {
+		t.Fatal(err)
+	}
+	if err := helper.Wait(); err == nil {
+		t.Fatal("expected the helper to be killed")
+	}
+	if _, err := os.Stat(filepath.Join(root, ownedMountsDir)); !os.IsNotExist(err) {
+		t.Fatalf("expected the views to be removed, got %v", err)
+	}
+}
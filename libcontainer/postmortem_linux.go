@@ -0,0 +1,141 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountNSFilename is the file of the state directory the mount namespace of
+// the container is bind mounted on when PreserveMountNSOnExit is set.
+const mountNSFilename = "mntns"
+
+func (c *linuxContainer) mountNSPath() string {
+	return filepath.Join(c.root, mountNSFilename)
+}
+
+// preserveMountNS bind mounts the mount namespace of pid into the state
+// directory, which keeps it alive after every process in it has exited.
+// The kernel refuses to mount it where it would propagate, which it would
+// from a shared mount such as /run usually is, into the copy of that mount
+// in the namespace itself: the file is made a private mount of its own
+// first.
+func (c *linuxContainer) preserveMountNS(pid int) error {
+	path := c.mountNSPath()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := unix.Mount(path, path, "", unix.MS_BIND, ""); err != nil {
+		os.Remove(path)
+		return &os.PathError{Op: "bind mount", Path: path, Err: err}
+	}
+	if err := unix.Mount("", path, "", unix.MS_PRIVATE, ""); err != nil {
+		c.releaseMountNS()
+		os.Remove(path)
+		return &os.PathError{Op: "make mount private", Path: path, Err: err}
+	}
+	if err := unix.Mount(fmt.Sprintf("/proc/%d/ns/mnt", pid), path, "", unix.MS_BIND, ""); err != nil {
+		c.releaseMountNS()
+		os.Remove(path)
+		return &os.PathError{Op: "bind mount mount namespace", Path: path, Err: err}
+	}
+	return nil
+}
+
+// releaseMountNS unmounts the preserved mount namespace and the private
+// mount under it, if any, so that it goes away along with the state
+// directory.
+func (c *linuxContainer) releaseMountNS() error {
+	path := c.mountNSPath()
+	for i := 0; i < 2; i++ {
+		if err := unix.Unmount(path, unix.MNT_DETACH); err != nil {
+			if err == unix.EINVAL || err == unix.ENOENT {
+				return nil
+			}
+			return &os.PathError{Op: "unmount mount namespace", Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+func (c *linuxContainer) EnterPostmortem(process *Process) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if !c.config.PreserveMountNSOnExit {
+		return newGenericError(fmt.Errorf("the mount namespace of the container is not preserved"), ConfigInvalid)
+	}
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status != Stopped && status != StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container is still running"), ContainerNotStopped)
+	}
+	if process.Terminal || process.HostBinary != "" {
+		return newGenericError(fmt.Errorf("a post-mortem process cannot have a terminal or a host binary"), ConfigInvalid)
+	}
+	if _, err := os.Stat(c.mountNSPath()); err != nil {
+		return newSystemErrorWithCause(err, "looking up preserved mount namespace")
+	}
+	parent, err := c.newPostmortemProcess(process)
+	if err != nil {
+		return newSystemErrorWithCause(err, "creating post-mortem process")
+	}
+	if err := parent.start(); err != nil {
+		if err := parent.terminate(); err != nil {
+			logrus.Warn(err)
+		}
+		return newSystemErrorWithCause(err, "starting post-mortem process")
+	}
+	return nil
+}
+
+// newPostmortemProcess returns a setns process joining nothing but the
+// preserved mount namespace.
+func (c *linuxContainer) newPostmortemProcess(p *Process) (_ *setnsProcess, err error) {
+	parentPipe, childPipe, err := newInitPipe()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "creating new init pipe")
+	}
+	defer func() {
+		if err != nil {
+			parentPipe.Close()
+			childPipe.Close()
+		}
+	}()
+	cmd, err := c.commandTemplate(p, childPipe)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "creating new command template")
+	}
+	cmd.Env = append(cmd.Env, "_LIBCONTAINER_INITTYPE="+string(initSetns))
+	data, err := c.bootstrapData(0, map[configs.NamespaceType]string{
+		configs.NEWNS: c.mountNSPath(),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	config, err := c.newInitConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	setns := &setnsProcess{
+		stub:          &stubbedProcess{stubCmd: cmd},
+		env:           hostProcessEnv,
+		childPipe:     childPipe,
+		parentPipe:    parentPipe,
+		config:        config,
+		process:       p,
+		bootstrapData: data,
+	}
+	setns.tracer = &syncTracer{enabled: c.traceSync, pid: setns.pid, clock: setns.env.clock}
+	return setns, nil
+}
@@ -0,0 +1,87 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPreserveMountNS(t *testing.T) {
+	testPreserveMountNS(t, false)
+}
+
+func TestPreserveMountNSOnSharedMount(t *testing.T) {
+	testPreserveMountNS(t, true)
+}
+
+func testPreserveMountNS(t *testing.T, shared bool) {
+	if os.Geteuid() != 0 {
+		t.Skip("preserving a mount namespace requires root")
+	}
+	root, err := ioutil.TempDir("", "postmortem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if shared {
+		// Like /run, which the state directory usually is in.
+		if err := unix.Mount(root, root, "", unix.MS_BIND, ""); err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Unmount(root, unix.MNT_DETACH)
+		if err := unix.Mount("", root, "", unix.MS_SHARED, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("sleep", "100")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: unix.CLONE_NEWNS}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot create a mount namespace: %v", err)
+	}
+	var want unix.Stat_t
+	if err := unix.Stat(fmt.Sprintf("/proc/%d/ns/mnt", cmd.Process.Pid), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &linuxContainer{root: root, config: &configs.Config{PreserveMountNSOnExit: true}}
+	err = c.preserveMountNS(cmd.Process.Pid)
+	cmd.Process.Kill()
+	cmd.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(c.mountNSPath(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Ino != want.Ino || st.Dev != want.Dev {
+		t.Fatal("expected the mount namespace to outlive the process")
+	}
+
+	if err := c.releaseMountNS(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Stat(c.mountNSPath(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Ino == want.Ino && st.Dev == want.Dev {
+		t.Fatal("expected the mount namespace to be released")
+	}
+	if mounted, err := mount.Mounted(c.mountNSPath()); err != nil || mounted {
+		t.Fatalf("expected nothing to be left mounted, got %v, %v", mounted, err)
+	}
+	if err := c.releaseMountNS(); err != nil {
+		t.Fatalf("releasing the mount namespace twice should be harmless: %v", err)
+	}
+}
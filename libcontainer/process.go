@@ -1,14 +1,80 @@
 package libcontainer
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 )
 
+// WaitMode controls what waiting for the init process of a container waits for.
+type WaitMode int
+
+const (
+	// WaitInit waits for the init process to exit.
+	WaitInit WaitMode = iota
+	// WaitCgroupEmpty additionally waits for every process left in the
+	// container's cgroups to exit, for workloads whose processes escape the
+	// init process by double-forking.
+	WaitCgroupEmpty
+)
+
+// ExitStatus describes how the init process of a container exited.
+type ExitStatus struct {
+	// Pid is the pid the init process had.
+	Pid int `json:"pid"`
+
+	// Status is the exit code of the process, or 128 plus the number of the
+	// signal that killed it.
+	Status int `json:"status"`
+
+	// Signal is the signal that killed the process, if any.
+	Signal int `json:"signal,omitempty"`
+
+	// Exited is when the exit was noticed.
+	Exited time.Time `json:"exited"`
+
+	// Cause is why libcontainer terminated the container, if it did, e.g.
+	// LifetimeExceeded.
+	Cause string `json:"cause,omitempty"`
+
+	// Output is the tail of the output of the process, truncated to its
+	// last few KB, if requested with ExitFileOutput. It is only written to
+	// the ExitFile.
+	Output string `json:"output,omitempty"`
+
+	// OOMKilled is set if the OOM killer killed the init process: it was
+	// killed with SIGKILL while the memory cgroup of the container counted
+	// an OOM kill. Waiting for it then returns an OOMKilledError.
+	OOMKilled bool `json:"oom_killed,omitempty"`
+
+	// Unknown is set if the status of the process was lost, as for an init
+	// process adopted after a restart which no longer is a child of the
+	// caller. Status is then -1.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// NetworkInterfaceInfo describes a network interface created for a
+// container, as it is configured in the network namespace of the container.
+type NetworkInterfaceInfo struct {
+	// Type is that of the network, e.g. "veth" or "loopback".
+	Type string
+	// Name is the name of the interface in the container, and HostName that
+	// of its peer on the host, if any.
+	Name     string
+	HostName string
+	// MacAddress is read from the interface, and is empty for one which has
+	// none.
+	MacAddress string
+	// Addresses are in CIDR notation, IPv4 first.
+	Addresses []string
+}
+
 type processOperations interface {
 	wait() (*os.ProcessState, error)
 	signal(sig os.Signal) error
@@ -65,19 +131,217 @@ type Process struct {
 	// If Rlimits are not set, the container will inherit rlimits from the parent process
 	Rlimits []configs.Rlimit
 
+	// Umask is the file mode creation mask the process is executed with. It
+	// must be at most 0777. If Umask is nil, the init process keeps the 0022
+	// umask the rootfs is set up with, and an exec'd process inherits the
+	// umask of the caller.
+	Umask *uint32
+
+	// SchedIdle starts the process under the SCHED_IDLE scheduling policy,
+	// so that it only runs when no other process wants the CPU.
+	SchedIdle bool
+
+	// StubPriority is the nice value of the stub which joins the namespaces
+	// of the container and of the init it forks, until the process is
+	// executed with our own nice value. It keeps them from being starved by
+	// a loaded container. It is ignored for the init process.
+	StubPriority *int
+
+	// StubCgroupPaths maps cgroup subsystems to the cgroups the stub and
+	// init are placed in instead of ours. Unlike the cgroups of the
+	// container, which the process is only moved to right before it is
+	// executed when either this or StubPriority is set, their limits are not
+	// shared with the container. It is ignored for the init process.
+	StubCgroupPaths map[string]string
+
 	// ConsoleSocket provides the masterfd console.
 	ConsoleSocket *os.File
 
-	ops processOperations
+	// Terminal runs the process with a new pseudo TTY as its stdio, whose
+	// master is returned by GetConsole once the process has been started. It
+	// cannot be combined with ConsoleSocket.
+	Terminal bool
+
+	// WaitMode controls what Wait waits for when the process is the init
+	// process of the container. It is ignored for other processes.
+	WaitMode WaitMode
+
+	// HostBinary is the path of a binary on the host which is executed instead
+	// of looking up Args[0] in the container, e.g. to run a static shell in a
+	// container whose image has none. The binary is passed to the process as
+	// a descriptor, so it never appears in the container's filesystem. It
+	// can only be used for processes executed in a running container.
+	HostBinary string
+
+	// JoinUserNS controls whether a process executed in a running container
+	// joins its user namespace, which it does if JoinUserNS is nil. A process
+	// which does not still joins its other namespaces and cgroups, but runs
+	// with the privileges of the host: its User is a user of the host rather
+	// than of the container, and the caller must have CAP_SYS_ADMIN and
+	// CAP_SYS_CHROOT. Such processes are logged as privileged. It is ignored
+	// for the init process and in rootless containers, which refuse it.
+	JoinUserNS *bool
+
+	// Namespaces are the namespaces of a running container which a process
+	// executed in it joins, all of them if Namespaces is nil, e.g. only its
+	// network namespace for a debugging tool which keeps our filesystem. The
+	// process still enters the cgroups of the container. Only namespaces the
+	// container has of its own can be listed. A process which leaves out the
+	// user namespace of the container runs with the privileges of the host,
+	// as with JoinUserNS. It is ignored for the init process.
+	Namespaces []configs.NamespaceType
+
+	// CgroupOnly executes a process in the cgroups of a running container
+	// while leaving it in our namespaces, e.g. for a monitoring sidecar. Only
+	// the rlimits and the oom_score_adj of the container are applied to it
+	// besides, and it runs as our user with our privileges, from our
+	// filesystem. It is still counted among the processes of the container.
+	// It cannot be combined with HostBinary, and is refused by rootless
+	// containers, whose cgroups are not entered.
+	CgroupOnly bool
+
+	// ContinueOnError makes a batch run by ExecBatch go on with its next
+	// process when this one fails, rather than stopping there. It is ignored
+	// outside of batches.
+	ContinueOnError bool
+
+	// PidFile is the path of a file to which the pid of the init process and
+	// its start time are written, one per line, once it has been created. It
+	// is removed when the container is destroyed. It is ignored for other
+	// processes.
+	PidFile string
+
+	// ExitFile is the path of a file to which the ExitStatus of the init
+	// process is written when Wait returns. It is ignored for other processes.
+	ExitFile string
+
+	// ExitFileOutput includes the tail of the output retained for the
+	// container, if any, in the ExitFile. The output is otherwise never
+	// written to disk.
+	ExitFileOutput bool
+
+	// StartTimeout is how long a process executed in a running container
+	// may take to start. The process is killed if it is not started by then,
+	// e.g. because the stub joining the namespaces of the container hangs.
+	// Zero waits forever. It is ignored for the init process.
+	StartTimeout time.Duration
+
+	// ForwardSignals are the signals received by the calling process which
+	// are relayed to the init process from the time it is started until Wait
+	// returns. SIGCHLD, SIGKILL and SIGSTOP, as well as the signals the Go
+	// runtime uses internally, are never forwarded. In a container sharing
+	// our PID namespace, the other processes are still killed when a
+	// forwarded signal makes the init process exit. It is ignored for other
+	// processes.
+	ForwardSignals []os.Signal
+
+	// NetworkReady is called once the network interfaces of the container
+	// are created and configured in its network namespace, but before the
+	// init process is let run, e.g. to publish the addresses of the
+	// container. It runs after the prestart hooks. An error fails the start,
+	// tearing the interfaces down. It is ignored for other processes.
+	NetworkReady func(ifaces []NetworkInterfaceInfo) error
+
+	// IdleTimeout is how long a process executed in a running container may
+	// go without any data read or written on its console, or on its stdio,
+	// before its process group is sent SIGHUP and, if still there a few
+	// seconds later, SIGKILL. Only the I/O going through libcontainer is
+	// seen, so the console must be created with Terminal and read and
+	// written through the Console rather than its File, and stdio which are
+	// files are refused. Zero never reaps the process. It is ignored for the
+	// init process.
+	IdleTimeout time.Duration
+
+	// Events receives an ExecEvent of type ExecReaped when the process is
+	// reaped for its IdleTimeout. Events are dropped rather than blocking.
+	Events chan<- ExecEvent
+
+	// Warnings are set by Start and Run to what went wrong without failing
+	// the process, such as the poststart hooks of the container which failed.
+	Warnings []string
+
+	ops      processOperations
+	waiter   *processWaiter
+	console  Console
+	activity *ioActivity
+	output   *outputRing
+	// batch is set for the runner of a batch, which forks its processes.
+	batch bool
+}
+
+// ProcessResult describes how a process of a batch run by ExecBatch ended.
+type ProcessResult struct {
+	// Pid is the pid the process had in the PID namespace of the container.
+	Pid int
+
+	// ExitCode is the exit code of the process, or 128 plus the number of
+	// the signal that killed it.
+	ExitCode int
+
+	// Err is why the process could not be initialised, if it could not, in
+	// which case it exited without being executed.
+	Err error
+}
+
+// Failed returns whether the process could not be started or exited with a
+// non-zero code.
+func (r ProcessResult) Failed() bool {
+	return r.Err != nil || r.ExitCode != 0
+}
+
+// setOps binds the process to ops, through which it is waited for and
+// signalled once started.
+func (p *Process) setOps(ops processOperations) {
+	p.ops = ops
+	p.waiter = &processWaiter{done: make(chan struct{})}
+}
+
+// processWaiter waits for a process in a goroutine of its own, started by
+// the first wait, so that the process is still reaped when its waiters give
+// up. Every wait gets the result of that one.
+type processWaiter struct {
+	once  sync.Once
+	done  chan struct{}
+	state *os.ProcessState
+	err   error
+}
+
+func (w *processWaiter) start(ops processOperations) <-chan struct{} {
+	w.once.Do(func() {
+		go func() {
+			w.state, w.err = ops.wait()
+			close(w.done)
+		}()
+	})
+	return w.done
 }
 
 // Wait waits for the process to exit.
 // Wait releases any resources associated with the Process
 func (p Process) Wait() (*os.ProcessState, error) {
-	if p.ops == nil {
+	return p.WaitWithContext(context.Background())
+}
+
+// WaitWithContext waits like Wait for the process to exit, or for ctx to be
+// done, in which case the process is left running and ctx.Err() is
+// returned. The process is reaped once it exits all the same, and waiting
+// for it again returns how it exited.
+func (p Process) WaitWithContext(ctx context.Context) (*os.ProcessState, error) {
+	if p.ops == nil || p.waiter == nil {
 		return nil, newGenericError(fmt.Errorf("invalid process"), NoProcessOps)
 	}
-	return p.ops.wait()
+	done := p.waiter.start(p.ops)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// Prefer the exit of the process if both happened.
+		select {
+		case <-done:
+		default:
+			return nil, ctx.Err()
+		}
+	}
+	return p.waiter.state, p.waiter.err
 }
 
 // Pid returns the process ID
@@ -90,6 +354,16 @@ func (p Process) Pid() (int, error) {
 	return p.ops.pid(), nil
 }
 
+// GetConsole returns the console of a process started with Terminal. The
+// container closes the console when it is destroyed, unless the process has
+// already exited, in which case closing it is left to the caller.
+func (p Process) GetConsole() (Console, error) {
+	if p.console == nil {
+		return nil, newGenericError(fmt.Errorf("process has no console"), NoProcessOps)
+	}
+	return p.console, nil
+}
+
 // Signal sends a signal to the Process.
 func (p Process) Signal(sig os.Signal) error {
 	if p.ops == nil {
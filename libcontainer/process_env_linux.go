@@ -0,0 +1,158 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall" // only for Signal
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+// processEnv holds everything the parent side of a container process uses
+// to talk to the host. The real implementations are in hostProcessEnv,
+// tests replace them to drive initProcess and setnsProcess without starting
+// any process.
+type processEnv struct {
+	clock      clock
+	procfs     procfs
+	signals    signaller
+	cgroups    cgroupEnterer
+	peers      peerChecker
+	priorities prioritizer
+	notifier   notifier
+}
+
+// hostProcessEnv is the processEnv backed by the host.
+var hostProcessEnv = processEnv{
+	clock:      hostClock{},
+	procfs:     hostProcfs{},
+	signals:    hostSignaller{},
+	cgroups:    hostCgroupEnterer{},
+	peers:      hostPeerChecker{},
+	priorities: hostPrioritizer{},
+	notifier:   hostNotifier{},
+}
+
+// clock tells the current time.
+type clock interface {
+	Now() time.Time
+}
+
+// procfs reads information about a process from /proc.
+type procfs interface {
+	// startTime returns the start time of the process.
+	startTime(pid int) (uint64, error)
+	// pipeFds returns the targets of the first count descriptors of the
+	// process, its standard and passed ones.
+	pipeFds(pid, count int) ([]string, error)
+	// netns opens the network namespace of the process.
+	netns(pid int) (*os.File, error)
+}
+
+// signaller sends signals to processes.
+type signaller interface {
+	kill(pid int, sig syscall.Signal) error
+}
+
+// cgroupEnterer moves a process into existing cgroups.
+type cgroupEnterer interface {
+	enterPid(paths map[string]string, pid int) error
+}
+
+// prioritizer gets and sets nice values.
+type prioritizer interface {
+	// getPriority returns the nice value of the calling thread.
+	getPriority() (int, error)
+	// setPriority sets the nice value of every thread of the process pid.
+	setPriority(pid, nice int) error
+}
+
+// notifier relays the signals received by the calling process.
+type notifier interface {
+	notify(c chan<- os.Signal, sigs ...os.Signal)
+	stop(c chan<- os.Signal)
+}
+
+// containerSpawner starts the nsexec stub and receives the container
+// process it forks off over the init pipe.
+type containerSpawner interface {
+	// start starts the stub.
+	start() error
+	// execSetns waits for the stub and reads the container process from pipe.
+	execSetns(pipe *os.File) error
+	// pid returns the pid of the container process, or that of the stub as
+	// long as the container process isn't known.
+	pid() int
+	// waitContainer waits for the container process to exit.
+	waitContainer() (*os.ProcessState, error)
+	// kill sends a SIGKILL to the container process or the stub.
+	kill() error
+	// abort sends a SIGKILL to the stub and the stages it forked, so that
+	// execSetns returns. Unlike the other methods, it may be called while
+	// another one is running.
+	abort()
+}
+
+type hostClock struct{}
+
+func (hostClock) Now() time.Time {
+	return time.Now()
+}
+
+type hostProcfs struct{}
+
+func (hostProcfs) startTime(pid int) (uint64, error) {
+	stat, err := system.Stat(pid)
+	return stat.StartTime, err
+}
+
+func (hostProcfs) pipeFds(pid, count int) ([]string, error) {
+	return getPipeFds(pid, count)
+}
+
+func (hostProcfs) netns(pid int) (*os.File, error) {
+	return os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+}
+
+type hostSignaller struct{}
+
+func (hostSignaller) kill(pid int, sig syscall.Signal) error {
+	return unix.Kill(pid, sig)
+}
+
+type hostCgroupEnterer struct{}
+
+func (hostCgroupEnterer) enterPid(paths map[string]string, pid int) error {
+	return cgroups.EnterPid(paths, pid)
+}
+
+type hostPrioritizer struct{}
+
+func (hostPrioritizer) getPriority() (int, error) {
+	// The system call returns 20 minus the nice value, to keep it positive.
+	prio, err := unix.Getpriority(unix.PRIO_PROCESS, 0)
+	return 20 - prio, err
+}
+
+func (hostPrioritizer) setPriority(pid, nice int) error {
+	return forEachThread(pid, func(tid int) error {
+		return unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+	})
+}
+
+type hostNotifier struct{}
+
+func (hostNotifier) notify(c chan<- os.Signal, sigs ...os.Signal) {
+	signal.Notify(c, sigs...)
+}
+
+func (hostNotifier) stop(c chan<- os.Signal) {
+	signal.Stop(c)
+}
@@ -0,0 +1,357 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/utils"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+type fakeProcfs struct {
+	start uint64
+	fds   []string
+	err   error
+	// count is the number of descriptors last asked for.
+	count int
+}
+
+func (f *fakeProcfs) startTime(pid int) (uint64, error) {
+	return f.start, f.err
+}
+
+func (f *fakeProcfs) pipeFds(pid, count int) ([]string, error) {
+	f.count = count
+	return f.fds, f.err
+}
+
+// netns opens the network namespace of the test, as pid is not a process.
+func (f *fakeProcfs) netns(pid int) (*os.File, error) {
+	return os.Open("/proc/self/ns/net")
+}
+
+type fakeSignaller struct {
+	sent []syscall.Signal
+	pids []int
+	err  error
+}
+
+func (f *fakeSignaller) kill(pid int, sig syscall.Signal) error {
+	f.sent = append(f.sent, sig)
+	f.pids = append(f.pids, pid)
+	return f.err
+}
+
+type fakeCgroupEnterer struct {
+	entered map[string]string
+	byPid   map[int]map[string]string
+	err     error
+}
+
+func (f *fakeCgroupEnterer) enterPid(paths map[string]string, pid int) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.entered = paths
+	if f.byPid == nil {
+		f.byPid = make(map[int]map[string]string)
+	}
+	f.byPid[pid] = paths
+	return nil
+}
+
+type fakePrioritizer struct {
+	nice int
+	set  map[int]int
+}
+
+func (f *fakePrioritizer) getPriority() (int, error) {
+	return f.nice, nil
+}
+
+func (f *fakePrioritizer) setPriority(pid, nice int) error {
+	if f.set == nil {
+		f.set = make(map[int]int)
+	}
+	f.set[pid] = nice
+	return nil
+}
+
+// fakePeerChecker records the peer the sync messages are expected from
+// without checking them, as the fakeChild runs in our own process.
+type fakePeerChecker struct {
+	peer *syncPeer
+}
+
+func (f *fakePeerChecker) reader(pipe *os.File, peer syncPeer) io.Reader {
+	f.peer = &peer
+	return pipe
+}
+
+type fakeNotifier struct {
+	mu       sync.Mutex
+	c        chan<- os.Signal
+	notified []os.Signal
+	stopped  bool
+}
+
+func (f *fakeNotifier) notify(c chan<- os.Signal, sigs ...os.Signal) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.c = c
+	f.notified = sigs
+}
+
+func (f *fakeNotifier) stop(c chan<- os.Signal) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+}
+
+// fakeSpawner stands in for the nsexec stub, pretending it reported a
+// container process with the given pid.
+type fakeSpawner struct {
+	containerPid int
+	startErr     error
+	execErr      error
+	started      bool
+	reported     bool
+	killed       bool
+	waited       bool
+	// hang, if set, blocks execSetns until the stub is aborted.
+	hang chan struct{}
+}
+
+func (f *fakeSpawner) start() error {
+	f.started = f.startErr == nil
+	return f.startErr
+}
+
+func (f *fakeSpawner) execSetns(pipe *os.File) error {
+	if f.hang != nil {
+		<-f.hang
+		return fmt.Errorf("stub killed")
+	}
+	if f.execErr != nil {
+		return f.execErr
+	}
+	f.reported = true
+	return nil
+}
+
+func (f *fakeSpawner) pid() int {
+	if f.reported {
+		return f.containerPid
+	}
+	return f.containerPid - 1
+}
+
+func (f *fakeSpawner) waitContainer() (*os.ProcessState, error) {
+	f.waited = true
+	return nil, nil
+}
+
+func (f *fakeSpawner) kill() error {
+	f.killed = true
+	return nil
+}
+
+func (f *fakeSpawner) abort() {
+	if f.hang != nil {
+		close(f.hang)
+	}
+}
+
+type fakeCgroupManager struct {
+	mockCgroupManager
+	applyErr  error
+	setErr    error
+	applied   int
+	destroyed bool
+}
+
+func (m *fakeCgroupManager) Apply(pid int) error {
+	if m.applyErr != nil {
+		return m.applyErr
+	}
+	m.applied = pid
+	return nil
+}
+
+func (m *fakeCgroupManager) Set(container *configs.Config) error {
+	return m.setErr
+}
+
+func (m *fakeCgroupManager) Destroy() error {
+	m.destroyed = true
+	return nil
+}
+
+// fakeChild plays the container side of the init pipe. It reads the config
+// sent by the parent, then sends each message of its script and records the
+// reply to it. A procError in the script is followed by errMsg. With
+// negotiate, it first reports its InitInfo like an init negotiating the
+// protocol version does.
+type fakeChild struct {
+	script    []syncType
+	negotiate bool
+	errMsg    string
+	config    *initConfig
+	responses []syncType
+	done      chan struct{}
+
+	// cgroupns makes the child expect createCgroupns before the config, as
+	// nsexec does when creating a cgroup namespace. The value is recorded
+	// in cgroupnsValue.
+	cgroupns      bool
+	cgroupnsValue byte
+}
+
+func (c *fakeChild) run(pipe *os.File) {
+	defer close(c.done)
+	defer pipe.Close()
+	if c.cgroupns {
+		b := make([]byte, 1)
+		if _, err := pipe.Read(b); err != nil {
+			return
+		}
+		c.cgroupnsValue = b[0]
+	}
+	dec := json.NewDecoder(pipe)
+	if err := dec.Decode(&c.config); err != nil {
+		return
+	}
+	if c.negotiate && c.config.ProtocolVersion > 0 {
+		if err := reportInitInfo(pipe, c.config.ProtocolVersion); err != nil {
+			return
+		}
+	}
+	for _, s := range c.script {
+		if err := writeSync(pipe, s); err != nil {
+			return
+		}
+		if s == procError {
+			utils.WriteJSON(pipe, &genericError{Message: c.errMsg})
+			return
+		}
+		var resp syncT
+		if err := dec.Decode(&resp); err != nil {
+			return
+		}
+		c.responses = append(c.responses, resp.Type)
+	}
+}
+
+// processHarness drives initProcess and setnsProcess against fakes for
+// everything on the host side and a fakeChild on the other end of the pipe.
+type processHarness struct {
+	t       *testing.T
+	spawner *fakeSpawner
+	procfs  *fakeProcfs
+	signals *fakeSignaller
+	cgroups *fakeCgroupEnterer
+	peers   *fakePeerChecker
+	prios   *fakePrioritizer
+	notes   *fakeNotifier
+	manager *fakeCgroupManager
+	child   *fakeChild
+	parent  *os.File
+}
+
+func newProcessHarness(t *testing.T, script ...syncType) *processHarness {
+	parent, child, err := newInitPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &processHarness{
+		t:       t,
+		spawner: &fakeSpawner{containerPid: 4242},
+		procfs:  &fakeProcfs{start: 1234, fds: []string{"/dev/null", "pipe:[1]", "pipe:[2]"}},
+		signals: &fakeSignaller{},
+		cgroups: &fakeCgroupEnterer{},
+		peers:   &fakePeerChecker{},
+		prios:   &fakePrioritizer{nice: 3},
+		notes:   &fakeNotifier{},
+		manager: &fakeCgroupManager{},
+		child:   &fakeChild{script: script, done: make(chan struct{})},
+		parent:  parent,
+	}
+	go h.child.run(child)
+	return h
+}
+
+func (h *processHarness) env() processEnv {
+	return processEnv{
+		clock:      &fakeClock{},
+		procfs:     h.procfs,
+		signals:    h.signals,
+		cgroups:    h.cgroups,
+		peers:      h.peers,
+		priorities: h.prios,
+		notifier:   h.notes,
+	}
+}
+
+func (h *processHarness) initProcess(config *configs.Config) *initProcess {
+	return &initProcess{
+		stub:          h.spawner,
+		env:           h.env(),
+		parentPipe:    h.parent,
+		config:        &initConfig{Config: config},
+		manager:       h.manager,
+		container:     &linuxContainer{id: "harness", config: config},
+		process:       &Process{},
+		bootstrapData: strings.NewReader(""),
+	}
+}
+
+func (h *processHarness) setnsProcess(cgroupPaths map[string]string) *setnsProcess {
+	return &setnsProcess{
+		stub:        h.spawner,
+		env:         h.env(),
+		parentPipe:  h.parent,
+		cgroupPaths: cgroupPaths,
+		config:      &initConfig{Config: &configs.Config{}},
+		process:     &Process{},
+	}
+}
+
+// wait waits for the fake child to be done with the pipe.
+func (h *processHarness) wait() {
+	select {
+	case <-h.child.done:
+	case <-time.After(5 * time.Second):
+		h.t.Fatal("the fake child did not finish")
+	}
+}
+
+func TestSyncTracerUsesClock(t *testing.T) {
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracer := &syncTracer{enabled: true, pid: func() int { return 1 }, clock: &fakeClock{now: now}}
+	tracer.trace(syncSent, procRun)
+	if !strings.Contains(buf.String(), now.Format(time.RFC3339Nano)) {
+		t.Fatalf("expected the trace to be stamped with %s: %s", now, buf.String())
+	}
+}
@@ -3,6 +3,7 @@
 package libcontainer
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,12 +12,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
-	"syscall" // only for Signal
+	"sync"
+	"syscall" // only for Signal, WaitStatus and Errno
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
-	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/vishvananda/netlink"
 
 	"golang.org/x/sys/unix"
 )
@@ -44,8 +48,183 @@ type parentProcess interface {
 	setExternalDescriptors(fds []string)
 }
 
+// stubbedProcess is a container process which is started through the nsexec
+// stub: the stub performs the setns calls, forks off the container process,
+// reports its pid over the init pipe and exits. As the container process is
+// cloned with CLONE_PARENT, it is our child just like the stub.
+type stubbedProcess struct {
+	// stubCmd is the command of the stub process.
+	stubCmd *exec.Cmd
+	// stubState is the state of the stub process once it has been reaped.
+	stubState *os.ProcessState
+	// containerProc is the container process, once the stub reported it.
+	containerProc *os.Process
+}
+
+// start starts the stub.
+func (p *stubbedProcess) start() error {
+	return p.stubCmd.Start()
+}
+
+// execSetns waits for the stub to exit and reads the pid of the container
+// process it reported from pipe.
+func (p *stubbedProcess) execSetns(pipe *os.File) error {
+	status, err := p.stubCmd.Process.Wait()
+	if err != nil {
+		p.releaseStub()
+		return newSystemErrorWithCause(err, "waiting on setns process to finish")
+	}
+	p.stubState = status
+	if !status.Success() {
+		// The stub may have failed after reporting the container process,
+		// which must not be left behind then. Only look for the pid if it is
+		// already there, as the container process may hold the pipe open.
+		// It may also have reported the namespace it failed to join.
+		var report stubReport
+		if pipeReadable(pipe) && json.NewDecoder(pipe).Decode(&report) == nil {
+			if report.Pid > 0 {
+				if proc, err := os.FindProcess(report.Pid); err == nil {
+					proc.Kill()
+					proc.Wait()
+				}
+			}
+		}
+		p.releaseStub()
+		if report.Setns != nil {
+			return report.Setns
+		}
+		return newSystemError(&exec.ExitError{ProcessState: status})
+	}
+	proc, err := readContainerProc(pipe)
+	if err != nil {
+		p.releaseStub()
+		return newSystemErrorWithCause(err, "reading pid from init pipe")
+	}
+	p.containerProc = proc
+	return nil
+}
+
+// releaseStub waits for the copying of the stdio of the stub, which the
+// container process inherited, to finish and closes the descriptors held
+// for it. The stub has already been reaped, so the error is meaningless.
+func (p *stubbedProcess) releaseStub() {
+	p.stubCmd.Wait()
+}
+
+// pid returns the pid of the container process, or that of the stub as long
+// as the container process isn't known.
+func (p *stubbedProcess) pid() int {
+	if p.containerProc != nil {
+		return p.containerProc.Pid
+	}
+	return p.stubCmd.Process.Pid
+}
+
+// waitContainer waits for the container process to exit.
+func (p *stubbedProcess) waitContainer() (*os.ProcessState, error) {
+	if p.containerProc == nil {
+		if p.stubState != nil {
+			return p.stubState, nil
+		}
+		// The stub was never waited for, so let exec.Cmd take care of it.
+		err := p.stubCmd.Wait()
+		p.stubState = p.stubCmd.ProcessState
+		return p.stubState, err
+	}
+	state, err := p.containerProc.Wait()
+	p.releaseStub()
+	if err != nil {
+		return state, err
+	}
+	if !state.Success() {
+		return state, &exec.ExitError{ProcessState: state}
+	}
+	return state, nil
+}
+
+// kill sends a SIGKILL to the container process, or to the stub as long as
+// the container process isn't known.
+func (p *stubbedProcess) kill() error {
+	if p.containerProc != nil {
+		return p.containerProc.Kill()
+	}
+	if p.stubCmd.Process == nil || p.stubState != nil {
+		return nil
+	}
+	return p.stubCmd.Process.Kill()
+}
+
+// abort kills the stub, along with the stages it forked if it leads a
+// process group. os.Process can be killed while it is waited for, and is
+// done with once it has been reaped.
+func (p *stubbedProcess) abort() {
+	if attr := p.stubCmd.SysProcAttr; attr != nil && attr.Setpgid {
+		unix.Kill(-p.stubCmd.Process.Pid, unix.SIGKILL)
+	}
+	p.stubCmd.Process.Kill()
+}
+
+// killReported kills and reaps the container processes reported on pipe
+// once it has been shut down, as nobody is going to wait for them.
+func killReported(pipe io.Reader) {
+	dec := json.NewDecoder(pipe)
+	for {
+		var report stubReport
+		if err := dec.Decode(&report); err != nil {
+			return
+		}
+		if report.Pid <= 0 {
+			continue
+		}
+		if proc, err := os.FindProcess(report.Pid); err == nil {
+			proc.Kill()
+			proc.Wait()
+		}
+	}
+}
+
+// stubReport is what the stub reports over the init pipe: the pid of the
+// container process, or the namespace it failed to join.
+type stubReport struct {
+	Pid   int      `json:"pid"`
+	Setns *nsError `json:"setns"`
+}
+
+// nsError is reported by the stub when joining a namespace of the container
+// failed. It must be kept in sync with report_ns_error in nsenter/nsexec.c.
+type nsError struct {
+	Type  string        `json:"type"`
+	Errno syscall.Errno `json:"errno"`
+}
+
+func (e *nsError) Error() string {
+	return fmt.Sprintf("joining the %s namespace: %v", e.Type, e.Errno)
+}
+
+// readContainerProc reads the pid of the container process reported by the
+// stub from pipe.
+func readContainerProc(pipe io.Reader) (*os.Process, error) {
+	var pid *pid
+	if err := json.NewDecoder(pipe).Decode(&pid); err != nil {
+		return nil, err
+	}
+	if pid == nil || pid.Pid <= 0 {
+		return nil, fmt.Errorf("invalid pid reported by stub")
+	}
+	return os.FindProcess(pid.Pid)
+}
+
+// pipeReadable returns true if reading from f would not block.
+func pipeReadable(f *os.File) bool {
+	fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, 0)
+	return err == nil && n > 0
+}
+
 type setnsProcess struct {
-	cmd           *exec.Cmd
+	stub          containerSpawner
+	env           processEnv
+	hostBinary    *os.File
 	parentPipe    *os.File
 	childPipe     *os.File
 	cgroupPaths   map[string]string
@@ -53,11 +232,21 @@ type setnsProcess struct {
 	fds           []string
 	process       *Process
 	bootstrapData io.Reader
+	tracer        *syncTracer
+	// stopped tells whether the init process of the container is gone.
+	stopped func() bool
+	// exited is called once the process has been waited for.
+	exited func()
+	// nice is our nice value, which the process gets back from the stub
+	// when it has a StubPriority.
+	nice    int
+	metrics MetricsSink
+	// idle reaps the process once it went without I/O for its IdleTimeout.
+	idle *idleReaper
 }
 
 func (p *setnsProcess) startTime() (uint64, error) {
-	stat, err := system.Stat(p.pid())
-	return stat.StartTime, err
+	return p.env.procfs.startTime(p.pid())
 }
 
 func (p *setnsProcess) signal(sig os.Signal) error {
@@ -65,47 +254,108 @@ func (p *setnsProcess) signal(sig os.Signal) error {
 	if !ok {
 		return errors.New("os: unsupported signal type")
 	}
-	return unix.Kill(p.pid(), s)
+	return p.env.signals.kill(p.pid(), s)
 }
 
 func (p *setnsProcess) start() (err error) {
+	timer := startTimer(p.metrics)
 	defer p.parentPipe.Close()
-	err = p.cmd.Start()
+	if p.hostBinary != nil {
+		defer p.hostBinary.Close()
+	}
+	err = p.stub.start()
 	p.childPipe.Close()
 	if err != nil {
 		return newSystemErrorWithCause(err, "starting setns process")
 	}
+	progress := &startProgress{step: "setns process started"}
+	if timeout := p.process.StartTimeout; timeout > 0 {
+		stop := progress.deadline(timeout, func() {
+			p.stub.abort()
+			// Wake up whatever is waiting for the process on the pipe.
+			unix.Shutdown(int(p.parentPipe.Fd()), unix.SHUT_RDWR)
+		})
+		defer func() {
+			if stop() {
+				// The stub may have reported the container process before
+				// it was killed.
+				killReported(p.parentPipe)
+				err = newSystemError(fmt.Errorf("process did not start within %s, the last step done was: %s", timeout, progress.last()))
+			}
+		}()
+	}
+	if err := p.isolateStub(); err != nil {
+		return newSystemErrorWithCause(err, "isolating setns process")
+	}
 	if p.bootstrapData != nil {
 		if _, err := io.Copy(p.parentPipe, p.bootstrapData); err != nil {
 			return newSystemErrorWithCause(err, "copying bootstrap data to pipe")
 		}
+		progress.done("bootstrap data copied")
 	}
 	if err = p.execSetns(); err != nil {
+		// Namespaces of a container which is stopping fail to be joined,
+		// usually with EINVAL or EBUSY, which doesn't tell what happened.
+		if nserr, ok := err.(*nsError); ok && p.stopped != nil && p.stopped() {
+			return newGenericError(fmt.Errorf("container stopped while joining its %s namespace", nserr.Type), ContainerNotRunning)
+		}
 		return newSystemErrorWithCause(err, "executing setns process")
 	}
-	// We can't join cgroups if we're in a rootless container.
-	if !p.config.Rootless && len(p.cgroupPaths) > 0 {
-		if err := cgroups.EnterPid(p.cgroupPaths, p.pid()); err != nil {
-			return newSystemErrorWithCausef(err, "adding pid %d to cgroups", p.pid())
+	progress.done(fmt.Sprintf("pid %d received", p.pid()))
+	if !p.config.LateCgroups {
+		if err := p.enterCgroups(); err != nil {
+			return err
 		}
 	}
-	// set rlimits, this has to be done here because we lose permissions
-	// to raise the limits once we enter a user-namespace
+	// set rlimits, those raising our hard limits have been set by nsexec
+	// already, as we lose permissions to raise them once it enters a
+	// user-namespace
 	if err := setupRlimits(p.config.Rlimits, p.pid()); err != nil {
 		return newSystemErrorWithCause(err, "setting rlimits for process")
 	}
+	// The runner of a batch stays in nsexec, which forks the processes of
+	// the batch, so it never reads a config.
+	if p.process != nil && p.process.batch {
+		return nil
+	}
 	if err := utils.WriteJSON(p.parentPipe, p.config); err != nil {
 		return newSystemErrorWithCause(err, "writing config to pipe")
 	}
+	progress.done("config written")
 
-	ierr := parseSync(p.parentPipe, func(sync *syncT) error {
+	enteredCgroups := !p.config.LateCgroups
+	pipe := p.env.peers.reader(p.parentPipe, expectedSyncPeer(p.pid(), p.config.Config, p.config.User))
+	ierr := parseSync(pipe, func(sync *syncT) error {
+		p.tracer.trace(syncReceived, sync.Type)
+		progress.done(fmt.Sprintf("%s received", sync.Type))
 		switch sync.Type {
-		case procReady:
-			// This shouldn't happen.
-			panic("unexpected procReady in setns")
-		case procHooks:
-			// This shouldn't happen.
-			panic("unexpected procHooks in setns")
+		case procReady, procHooks:
+			// Only the init of a container sends these.
+			err := newGenericError(fmt.Errorf("unexpected %s from setns process", sync.Type), SyncProtocolError)
+			if werr := p.tracer.refuseSync(p.parentPipe, err); werr != nil {
+				logrus.Warnf("refusing %s: %v", sync.Type, werr)
+			}
+			return err
+		case procHostBinary:
+			if p.hostBinary == nil {
+				return newSystemError(fmt.Errorf("host binary requested but none was given"))
+			}
+			if err := utils.SendFd(p.parentPipe, p.hostBinary); err != nil {
+				return newSystemErrorWithCause(err, "sending host binary")
+			}
+			return nil
+		case procCgroups:
+			if enteredCgroups {
+				return newSystemError(fmt.Errorf("cgroups requested but they were already entered"))
+			}
+			if err := p.enterCgroups(); err != nil {
+				return err
+			}
+			enteredCgroups = true
+			if err := p.tracer.writeSync(p.parentPipe, procResume); err != nil {
+				return newSystemErrorWithCause(err, "writing syncT 'resume'")
+			}
+			return nil
 		default:
 			return newSystemError(fmt.Errorf("invalid JSON payload from child"))
 		}
@@ -116,9 +366,113 @@ func (p *setnsProcess) start() (err error) {
 	}
 	// Must be done after Shutdown so the child will exit and we can wait for it.
 	if ierr != nil {
+		// A process which timed out may not exit on its own.
+		if progress.timedOut() {
+			p.stub.kill()
+		}
 		p.wait()
 		return ierr
 	}
+	// The process must not be left running outside of the container's
+	// cgroups, e.g. by an older init which doesn't know about LateCgroups.
+	if !enteredCgroups {
+		return newSystemError(fmt.Errorf("process was not moved into the cgroups of the container"))
+	}
+	timer.observe(MetricExecStart)
+	if p.config.HostUser {
+		auditHostUserExec(p.config.ContainerId, p.pid(), p.config.Args)
+	}
+	if p.process != nil && p.process.activity != nil {
+		// nsexec made the process the leader of a session of its own.
+		p.idle = startIdleReaper(p.process.activity, p.process.IdleTimeout, p.pid(), p.env.signals, p.process.Events)
+	}
+	return nil
+}
+
+// startProgress records how far the start of a process went, to tell where
+// it got stuck if it took too long.
+type startProgress struct {
+	mu      sync.Mutex
+	step    string
+	expired bool
+}
+
+func (s *startProgress) done(step string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.step = step
+}
+
+func (s *startProgress) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.step
+}
+
+func (s *startProgress) timedOut() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expired
+}
+
+// deadline calls expired once timeout is over. The returned stop function
+// cancels it, and returns whether expired was called, waiting for it to
+// return if needed.
+func (s *startProgress) deadline(timeout time.Duration, expired func()) (stop func() bool) {
+	fired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		defer close(fired)
+		s.mu.Lock()
+		s.expired = true
+		s.mu.Unlock()
+		expired()
+	})
+	return func() bool {
+		if timer.Stop() {
+			return false
+		}
+		<-fired
+		return true
+	}
+}
+
+// isolateStub gives the stub the StubPriority of the process and moves it
+// into its StubCgroupPaths. The stub is still waiting for its bootstrap
+// data, so this happens before it does any work, and the processes it forks
+// inherit both.
+func (p *setnsProcess) isolateStub() error {
+	if p.process.StubPriority != nil {
+		nice, err := p.env.priorities.getPriority()
+		if err != nil {
+			return err
+		}
+		p.nice = nice
+		if err := p.env.priorities.setPriority(p.stub.pid(), *p.process.StubPriority); err != nil {
+			return err
+		}
+	}
+	if len(p.process.StubCgroupPaths) > 0 {
+		if err := p.env.cgroups.enterPid(p.process.StubCgroupPaths, p.stub.pid()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enterCgroups moves the container process into the cgroups of the
+// container, and back to our nice value if the stub had another one.
+func (p *setnsProcess) enterCgroups() error {
+	// We can't join cgroups if we're in a rootless container.
+	if !p.config.Rootless && len(p.cgroupPaths) > 0 {
+		if err := p.env.cgroups.enterPid(p.cgroupPaths, p.pid()); err != nil {
+			return newSystemErrorWithCausef(err, "adding pid %d to cgroups", p.pid())
+		}
+	}
+	if p.process.StubPriority != nil {
+		if err := p.env.priorities.setPriority(p.pid(), p.nice); err != nil {
+			return newSystemErrorWithCausef(err, "restoring the priority of pid %d", p.pid())
+		}
+	}
 	return nil
 }
 
@@ -127,36 +481,17 @@ func (p *setnsProcess) start() (err error) {
 // before the go runtime boots, we wait on the process to die and receive the child's pid
 // over the provided pipe.
 func (p *setnsProcess) execSetns() error {
-	status, err := p.cmd.Process.Wait()
-	if err != nil {
-		p.cmd.Wait()
-		return newSystemErrorWithCause(err, "waiting on setns process to finish")
-	}
-	if !status.Success() {
-		p.cmd.Wait()
-		return newSystemError(&exec.ExitError{ProcessState: status})
-	}
-	var pid *pid
-	if err := json.NewDecoder(p.parentPipe).Decode(&pid); err != nil {
-		p.cmd.Wait()
-		return newSystemErrorWithCause(err, "reading pid from init pipe")
-	}
-	process, err := os.FindProcess(pid.Pid)
-	if err != nil {
+	if err := p.stub.execSetns(p.parentPipe); err != nil {
 		return err
 	}
-	p.cmd.Process = process
-	p.process.ops = p
+	p.process.setOps(p)
 	return nil
 }
 
 // terminate sends a SIGKILL to the forked process for the setns routine then waits to
 // avoid the process becoming a zombie.
 func (p *setnsProcess) terminate() error {
-	if p.cmd.Process == nil {
-		return nil
-	}
-	err := p.cmd.Process.Kill()
+	err := p.stub.kill()
 	if _, werr := p.wait(); err == nil {
 		err = werr
 	}
@@ -164,14 +499,18 @@ func (p *setnsProcess) terminate() error {
 }
 
 func (p *setnsProcess) wait() (*os.ProcessState, error) {
-	err := p.cmd.Wait()
-
-	// Return actual ProcessState even on Wait error
-	return p.cmd.ProcessState, err
+	state, err := p.stub.waitContainer()
+	if p.idle != nil {
+		p.idle.stop()
+	}
+	if p.exited != nil {
+		p.exited()
+	}
+	return state, err
 }
 
 func (p *setnsProcess) pid() int {
-	return p.cmd.Process.Pid
+	return p.stub.pid()
 }
 
 func (p *setnsProcess) externalDescriptors() []string {
@@ -183,7 +522,8 @@ func (p *setnsProcess) setExternalDescriptors(newFds []string) {
 }
 
 type initProcess struct {
-	cmd           *exec.Cmd
+	stub          containerSpawner
+	env           processEnv
 	parentPipe    *os.File
 	childPipe     *os.File
 	config        *initConfig
@@ -194,10 +534,17 @@ type initProcess struct {
 	bootstrapData io.Reader
 	sharePidns    bool
 	rootDir       *os.File
+	nsFiles       []*os.File
+	waitMode      WaitMode
+	pidFile       string
+	exitFile      string
+	tracer        *syncTracer
+	relay         *signalRelay
+	oom           *oomWatcher
 }
 
 func (p *initProcess) pid() int {
-	return p.cmd.Process.Pid
+	return p.stub.pid()
 }
 
 func (p *initProcess) externalDescriptors() []string {
@@ -210,49 +557,52 @@ func (p *initProcess) externalDescriptors() []string {
 // over the provided pipe.
 // This is called by initProcess.start function
 func (p *initProcess) execSetns() error {
-	status, err := p.cmd.Process.Wait()
-	if err != nil {
-		p.cmd.Wait()
+	if err := p.stub.execSetns(p.parentPipe); err != nil {
 		return err
 	}
-	if !status.Success() {
-		p.cmd.Wait()
-		return &exec.ExitError{ProcessState: status}
-	}
-	var pid *pid
-	if err := json.NewDecoder(p.parentPipe).Decode(&pid); err != nil {
-		p.cmd.Wait()
-		return err
-	}
-	process, err := os.FindProcess(pid.Pid)
-	if err != nil {
-		return err
-	}
-	p.cmd.Process = process
-	p.process.ops = p
+	p.process.setOps(p)
 	return nil
 }
 
-func (p *initProcess) start() error {
+func (p *initProcess) start() (err error) {
+	timer := startTimer(p.container.metrics)
 	defer p.parentPipe.Close()
-	err := p.cmd.Start()
-	p.process.ops = p
+	// The namespace files have been joined (or failed to be) by the time we
+	// return, so they are of no more use.
+	defer func() {
+		for _, f := range p.nsFiles {
+			f.Close()
+		}
+	}()
+	err = p.stub.start()
+	p.process.setOps(p)
 	p.childPipe.Close()
 	p.rootDir.Close()
 	if err != nil {
 		p.process.ops = nil
 		return newSystemErrorWithCause(err, "starting init process command")
 	}
-	if _, err := io.Copy(p.parentPipe, p.bootstrapData); err != nil {
-		return newSystemErrorWithCause(err, "copying bootstrap data to pipe")
+	if p.bootstrapData != nil {
+		if _, err := io.Copy(p.parentPipe, p.bootstrapData); err != nil {
+			return newSystemErrorWithCause(err, "copying bootstrap data to pipe")
+		}
 	}
 	if err := p.execSetns(); err != nil {
 		return newSystemErrorWithCause(err, "running exec setns process for init")
 	}
-	// Save the standard descriptor names before the container process
-	// can potentially move them (e.g., via dup2()).  If we don't do this now,
-	// we won't know at checkpoint time which file descriptor to look up.
-	fds, err := getPipeFds(p.pid())
+	if err := p.writePidFile(); err != nil {
+		return newSystemErrorWithCause(err, "writing pid file")
+	}
+	defer func() {
+		if err != nil {
+			p.removePidFile()
+		}
+	}()
+	// Save the standard and passed descriptor names before the container
+	// process can potentially move them (e.g., via dup2()).  If we don't do
+	// this now, we won't know at checkpoint time which file descriptor to
+	// look up.
+	fds, err := p.env.procfs.pipeFds(p.pid(), stdioFdCount+p.config.PassedFilesCount)
 	if err != nil {
 		return newSystemErrorWithCausef(err, "getting pipe fds for pid %d", p.pid())
 	}
@@ -260,12 +610,32 @@ func (p *initProcess) start() error {
 	// Do this before syncing with child so that no children can escape the
 	// cgroup. We don't need to worry about not doing this and not being root
 	// because we'd be using the rootless cgroup manager in that case.
+	applyTimer := startTimer(p.container.metrics)
 	if err := p.manager.Apply(p.pid()); err != nil {
 		return newSystemErrorWithCause(err, "applying cgroup configuration for process")
 	}
+	applyTimer.observe(MetricCgroupApply)
+	if !p.config.Rootless {
+		p.oom = watchOOM(p.manager.GetPaths())
+	}
+	// Now that the process is in the cgroups of the container, nsexec can
+	// create its cgroup namespace, rooted at them.
+	if createsCgroupns(p.config.Config) {
+		if _, err := p.parentPipe.Write([]byte{createCgroupns}); err != nil {
+			return newSystemErrorWithCause(err, "sending synchronization value to init process")
+		}
+	}
+	// Leave a breadcrumb for tools which only know about the cgroup.
+	if !p.config.Rootless {
+		ident := cgroups.NewIdentity(p.container.id, utils.SearchLabels(p.config.Config.Labels, "bundle"))
+		if err := cgroups.WriteIdentity(p.manager.GetPaths(), ident); err != nil {
+			logrus.Warn(err)
+		}
+	}
 	defer func() {
 		if err != nil {
 			// TODO: should not be the responsibility to call here
+			p.destroyNetworkInterfaces()
 			p.manager.Destroy()
 		}
 	}()
@@ -278,13 +648,19 @@ func (p *initProcess) start() error {
 	var (
 		sentRun    bool
 		sentResume bool
+		initInfo   *InitInfo
 	)
 
-	ierr := parseSync(p.parentPipe, func(sync *syncT) error {
+	pipe := p.env.peers.reader(p.parentPipe, expectedSyncPeer(p.pid(), p.config.Config, p.config.User))
+	ierr := parseSync(pipe, func(sync *syncT) error {
+		p.tracer.trace(syncReceived, sync.Type)
 		switch sync.Type {
+		case procVersion:
+			initInfo = sync.Init
 		case procReady:
-			// set rlimits, this has to be done here because we lose permissions
-			// to raise the limits once we enter a user-namespace
+			// set rlimits, those raising our hard limits have been set by
+			// nsexec already, as we lose permissions to raise them once it
+			// enters a user-namespace
 			if err := setupRlimits(p.config.Rlimits, p.pid()); err != nil {
 				return newSystemErrorWithCause(err, "setting rlimits for ready process")
 			}
@@ -296,21 +672,23 @@ func (p *initProcess) start() error {
 				}
 
 				if p.config.Config.Hooks != nil {
-					s := configs.HookState{
-						Version: p.container.config.Version,
-						ID:      p.container.id,
-						Pid:     p.pid(),
-						Bundle:  utils.SearchLabels(p.config.Config.Labels, "bundle"),
-					}
-					for i, hook := range p.config.Config.Hooks.Prestart {
-						if err := hook.Run(s); err != nil {
-							return newSystemErrorWithCausef(err, "running prestart hook %d", i)
-						}
+					s := p.container.newHookState("creating", p.pid())
+					if err := p.container.runHooks("prestart", p.config.Config.Hooks.Prestart, s); err != nil {
+						return err
 					}
 				}
 			}
+			if ready := p.process.NetworkReady; ready != nil {
+				ifaces, err := p.networkInterfaces()
+				if err != nil {
+					return newSystemErrorWithCause(err, "reading network interfaces")
+				}
+				if err := ready(ifaces); err != nil {
+					return newSystemErrorWithCause(err, "running network ready callback")
+				}
+			}
 			// Sync with child.
-			if err := writeSync(p.parentPipe, procRun); err != nil {
+			if err := p.tracer.writeSync(p.parentPipe, procRun); err != nil {
 				return newSystemErrorWithCause(err, "writing syncT 'run'")
 			}
 			sentRun = true
@@ -320,20 +698,13 @@ func (p *initProcess) start() error {
 				return newSystemErrorWithCause(err, "setting cgroup config for procHooks process")
 			}
 			if p.config.Config.Hooks != nil {
-				s := configs.HookState{
-					Version: p.container.config.Version,
-					ID:      p.container.id,
-					Pid:     p.pid(),
-					Bundle:  utils.SearchLabels(p.config.Config.Labels, "bundle"),
-				}
-				for i, hook := range p.config.Config.Hooks.Prestart {
-					if err := hook.Run(s); err != nil {
-						return newSystemErrorWithCausef(err, "running prestart hook %d", i)
-					}
+				s := p.container.newHookState("creating", p.pid())
+				if err := p.container.runHooks("prestart", p.config.Config.Hooks.Prestart, s); err != nil {
+					return err
 				}
 			}
 			// Sync with child.
-			if err := writeSync(p.parentPipe, procResume); err != nil {
+			if err := p.tracer.writeSync(p.parentPipe, procResume); err != nil {
 				return newSystemErrorWithCause(err, "writing syncT 'resume'")
 			}
 			sentResume = true
@@ -345,49 +716,186 @@ func (p *initProcess) start() error {
 	})
 
 	if !sentRun {
+		if lerr, ok := ierr.(Error); ok && lerr.Code() == SyncProtocolError {
+			return lerr
+		}
 		return newSystemErrorWithCause(ierr, "container init")
 	}
+	p.container.initInfo = checkInitInfo(initInfo)
+	if w := p.container.initInfo.Warning; w != "" {
+		p.container.warn(configs.Warning{Code: configs.WarnInitIncompatible, Message: w})
+	}
 	if p.config.Config.Namespaces.Contains(configs.NEWNS) && !sentResume {
 		return newSystemError(fmt.Errorf("could not synchronise after executing prestart hooks with container process"))
 	}
+	// The container is running by now, so failing poststart hooks only
+	// warn.
+	if ierr == nil && p.config.Config.Hooks != nil {
+		s := p.container.newHookState("running", p.pid())
+		p.process.Warnings = append(p.process.Warnings, p.container.warnHooks("poststart", p.config.Config.Hooks.Poststart, s)...)
+	}
 	if err := unix.Shutdown(int(p.parentPipe.Fd()), unix.SHUT_WR); err != nil {
 		return newSystemErrorWithCause(err, "shutting down init pipe")
 	}
 
 	// Must be done after Shutdown so the child will exit and we can wait for it.
 	if ierr != nil {
-		p.wait()
+		// The container is locked while it is started, so the exit cannot
+		// be recorded in its state.
+		p.waitExited()
 		return ierr
 	}
+	timer.observe(MetricInitStart)
+	p.relay = startSignalRelay(p.env.notifier, p.process.ForwardSignals, p.signal)
 	return nil
 }
 
 func (p *initProcess) wait() (*os.ProcessState, error) {
-	err := p.cmd.Wait()
+	state, err := p.waitExited()
+	p.container.dropConsole(p.process)
+	// The exit is recorded however the process exited, as long as it was
+	// reaped, which waiting fails for with a non-zero status too.
+	exit, xerr := p.exitStatus(state)
+	if xerr != nil {
+		return state, newSystemErrorWithCause(xerr, "getting exit status")
+	}
+	p.container.recordExit(exit)
+	if ee, ok := err.(*exec.ExitError); ok && exit != nil && exit.OOMKilled {
+		err = &OOMKilledError{ee}
+	}
+	if xerr := p.writeExitFile(exit); xerr != nil && err == nil {
+		err = newSystemErrorWithCause(xerr, "writing exit file")
+	}
+	return state, err
+}
+
+// waitExited waits for the init process to exit, and for the cgroups of the
+// container to be empty with WaitCgroupEmpty.
+func (p *initProcess) waitExited() (*os.ProcessState, error) {
+	state, err := p.waitInit()
 	if err != nil {
-		return p.cmd.ProcessState, err
+		return state, err
 	}
-	// we should kill all processes in cgroup when init is died if we use host PID namespace
-	if p.sharePidns {
-		signalAllProcesses(p.manager, unix.SIGKILL)
+	if p.waitMode == WaitCgroupEmpty {
+		if err := waitCgroupEmpty(p.manager); err != nil {
+			return state, newSystemErrorWithCause(err, "waiting for the container's cgroups to be empty")
+		}
 	}
-	return p.cmd.ProcessState, nil
+	return state, nil
 }
 
-func (p *initProcess) terminate() error {
-	if p.cmd.Process == nil {
+// writePidFile writes the pid and start time of the init process to the
+// pid file, if any.
+func (p *initProcess) writePidFile() error {
+	if p.pidFile == "" {
 		return nil
 	}
-	err := p.cmd.Process.Kill()
-	if _, werr := p.wait(); err == nil {
+	startTime, err := p.startTime()
+	if err != nil {
+		return err
+	}
+	return utils.AtomicWriteFile(p.pidFile, []byte(fmt.Sprintf("%d\n%d\n", p.pid(), startTime)), 0644)
+}
+
+// removePidFile removes the pid file, if any, of an init process which
+// failed to start.
+func (p *initProcess) removePidFile() {
+	if p.pidFile == "" {
+		return
+	}
+	if err := os.Remove(p.pidFile); err != nil && !os.IsNotExist(err) {
+		logrus.Warn(err)
+	}
+}
+
+// exitStatus returns how the init process exited, from its state once
+// waited for, or nil if there is none.
+func (p *initProcess) exitStatus(state *os.ProcessState) (*ExitStatus, error) {
+	if state == nil {
+		return nil, nil
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return nil, fmt.Errorf("unexpected wait status %T", state.Sys())
+	}
+	exit := &ExitStatus{
+		Pid:       p.pid(),
+		Status:    utils.ExitStatus(unix.WaitStatus(ws)),
+		Exited:    p.env.clock.Now(),
+		OOMKilled: p.oom.killed(ws),
+	}
+	if ws.Signaled() {
+		exit.Signal = int(ws.Signal())
+	}
+	exit.Cause = p.container.exitCause()
+	if p.process.ExitFileOutput && p.process.output != nil {
+		exit.Output = string(p.process.output.tail(exitFileOutputMax))
+	}
+	return exit, nil
+}
+
+// writeExitFile writes how the init process exited to the exit file, if any.
+func (p *initProcess) writeExitFile(exit *ExitStatus) error {
+	return writeExitFile(p.exitFile, exit)
+}
+
+func writeExitFile(path string, exit *ExitStatus) error {
+	if path == "" || exit == nil {
+		return nil
+	}
+	data, err := json.Marshal(exit)
+	if err != nil {
+		return err
+	}
+	return utils.AtomicWriteFile(path, data, 0644)
+}
+
+// waitInit waits for the init process itself to exit.
+func (p *initProcess) waitInit() (*os.ProcessState, error) {
+	state, err := p.stub.waitContainer()
+	p.relay.stop()
+	p.container.notifyStopped(p.pid())
+	p.container.lifetime.disarm()
+	if err != nil {
+		return state, err
+	}
+	// we should kill all processes in cgroup when init is died if we use host PID namespace
+	if p.sharePidns && p.container.killCgroupProcessesOnExit() {
+		p.container.signalAllProcesses(unix.SIGKILL, p.container.killExemptPids())
+	}
+	return state, nil
+}
+
+func (p *initProcess) terminate() error {
+	err := p.killGroup()
+	if _, werr := p.waitInit(); err == nil {
 		err = werr
 	}
+	p.removePidFile()
+	return err
+}
+
+// killGroup sends a SIGKILL to the init process, then to everything in the
+// cgroups of the container, so that the processes it already forked go with
+// it in a shared pid namespace. The init process is killed through its pid,
+// which cannot be reused before it is reaped, rather than through a process
+// group, which it leaves for a session of its own.
+func (p *initProcess) killGroup() error {
+	err := p.stub.kill()
+	pids, perr := p.manager.GetAllPids()
+	if perr != nil {
+		return perr
+	}
+	for _, pid := range pids {
+		if kerr := p.env.signals.kill(pid, unix.SIGKILL); kerr != nil && kerr != unix.ESRCH {
+			logrus.Warn(kerr)
+		}
+	}
 	return err
 }
 
 func (p *initProcess) startTime() (uint64, error) {
-	stat, err := system.Stat(p.pid())
-	return stat.StartTime, err
+	return p.env.procfs.startTime(p.pid())
 }
 
 func (p *initProcess) sendConfig() error {
@@ -414,23 +922,85 @@ func (p *initProcess) createNetworkInterfaces() error {
 	return nil
 }
 
+// networkInterfaces describes the interfaces created by
+// createNetworkInterfaces, which the init process configured by now. Their
+// MAC addresses are read from the links in the network namespace of the init
+// process, as the kernel may have picked them.
+func (p *initProcess) networkInterfaces() ([]NetworkInterfaceInfo, error) {
+	var ifaces []NetworkInterfaceInfo
+	for _, n := range p.config.Networks {
+		iface := NetworkInterfaceInfo{
+			Type:     n.Type,
+			Name:     n.Name,
+			HostName: n.HostInterfaceName,
+		}
+		for _, addr := range []string{n.Address, n.IPv6Address} {
+			if addr != "" {
+				iface.Addresses = append(iface.Addresses, addr)
+			}
+		}
+		ifaces = append(ifaces, iface)
+	}
+	if len(ifaces) == 0 {
+		return nil, nil
+	}
+	netns, err := p.env.procfs.netns(p.pid())
+	if err != nil {
+		return nil, err
+	}
+	defer netns.Close()
+	err = inNetns(netns, func() error {
+		for i := range ifaces {
+			link, err := netlink.LinkByName(ifaces[i].Name)
+			if err != nil {
+				return err
+			}
+			// Like the net package, the all-zero address of e.g. the
+			// loopback is taken for none.
+			if mac := link.Attrs().HardwareAddr; !bytes.Equal(mac, make([]byte, len(mac))) {
+				ifaces[i].MacAddress = mac.String()
+			}
+		}
+		return nil
+	})
+	return ifaces, err
+}
+
+// destroyNetworkInterfaces tears down the networks created by
+// createNetworkInterfaces, which would be left behind on the host if the
+// init process failed to start.
+func (p *initProcess) destroyNetworkInterfaces() {
+	for _, n := range p.config.Networks {
+		strategy, err := getStrategy(n.Type)
+		if err != nil {
+			logrus.Warn(err)
+			continue
+		}
+		if err := strategy.destroy(n); err != nil {
+			logrus.Warnf("destroying network %s: %v", n.Name, err)
+		}
+	}
+}
+
 func (p *initProcess) signal(sig os.Signal) error {
 	s, ok := sig.(syscall.Signal)
 	if !ok {
 		return errors.New("os: unsupported signal type")
 	}
-	return unix.Kill(p.pid(), s)
+	return p.env.signals.kill(p.pid(), s)
 }
 
 func (p *initProcess) setExternalDescriptors(newFds []string) {
 	p.fds = newFds
 }
 
-func getPipeFds(pid int) ([]string, error) {
-	fds := make([]string, 3)
+// getPipeFds returns the targets of the first count descriptors of the
+// process pid: its stdio, followed by the ExtraFiles passed to it.
+func getPipeFds(pid, count int) ([]string, error) {
+	fds := make([]string, count)
 
 	dirPath := filepath.Join("/proc", strconv.Itoa(pid), "/fd")
-	for i := 0; i < 3; i++ {
+	for i := 0; i < count; i++ {
 		// XXX: This breaks if the path is not a valid symlink (which can
 		//      happen in certain particularly unlucky mount namespace setups).
 		f := filepath.Join(dirPath, strconv.Itoa(i))
@@ -449,6 +1019,19 @@ func getPipeFds(pid int) ([]string, error) {
 	return fds, nil
 }
 
+// fileTargets returns the targets of our descriptors of files.
+func fileTargets(files []*os.File) ([]string, error) {
+	var targets []string
+	for _, f := range files {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", strconv.Itoa(int(f.Fd()))))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
 // InitializeIO creates pipes for use with the process's stdio and returns the
 // opposite side for each. Do not use this if you want to have a pseudoterminal
 // set up for you by libcontainer (TODO: fix that too).
@@ -0,0 +1,904 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/vishvananda/netlink"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStubCrashesAfterReportingPid(t *testing.T) {
+	container := exec.Command("sleep", "100")
+	if err := container.Start(); err != nil {
+		t.Fatal(err)
+	}
+	parent, child, err := utils.NewSockPair("init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	stub := exec.Command("/bin/sh", "-c", `printf '{"pid": %s}' "$0" >&3; exit 1`, strconv.Itoa(container.Process.Pid))
+	stub.ExtraFiles = []*os.File{child}
+	if err := stub.Start(); err != nil {
+		t.Fatal(err)
+	}
+	child.Close()
+
+	p := &stubbedProcess{stubCmd: stub}
+	err = p.execSetns(parent)
+	if gerr, ok := err.(*genericError); !ok {
+		t.Fatalf("expected a system error, got %v", err)
+	} else if _, ok := gerr.Err.(*exec.ExitError); !ok {
+		t.Fatalf("expected the stub's exit error as the cause, got %v", gerr.Err)
+	}
+	if p.containerProc != nil {
+		t.Fatal("expected no container process after the stub failed")
+	}
+	// The reported container process must have been killed and reaped.
+	if err := unix.Kill(container.Process.Pid, 0); err != unix.ESRCH {
+		t.Fatalf("expected the container process to be gone, got %v", err)
+	}
+}
+
+func TestContainerProcessDiesImmediately(t *testing.T) {
+	container := exec.Command("/bin/sh", "-c", "exit 3")
+	if err := container.Start(); err != nil {
+		t.Fatal(err)
+	}
+	parent, child, err := utils.NewSockPair("init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	stub := exec.Command("/bin/sh", "-c", `printf '{"pid": %s}' "$0" >&3`, strconv.Itoa(container.Process.Pid))
+	stub.ExtraFiles = []*os.File{child}
+	if err := stub.Start(); err != nil {
+		t.Fatal(err)
+	}
+	child.Close()
+
+	p := &stubbedProcess{stubCmd: stub}
+	if err := p.execSetns(parent); err != nil {
+		t.Fatal(err)
+	}
+	if p.pid() != container.Process.Pid {
+		t.Fatalf("expected pid %d but received %d", container.Process.Pid, p.pid())
+	}
+	state, err := p.waitContainer()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("expected an exit error, got %v", err)
+	}
+	if status := state.Sys().(syscall.WaitStatus); status.ExitStatus() != 3 {
+		t.Fatalf("expected exit status 3 but received %d", status.ExitStatus())
+	}
+	if !p.stubState.Success() {
+		t.Fatal("expected the stub to have exited successfully")
+	}
+}
+
+func TestStubReportsNamespaceError(t *testing.T) {
+	parent, child, err := utils.NewSockPair("init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	stub := exec.Command("/bin/sh", "-c", `printf '{"setns": {"type": "mnt", "errno": 16}}\n' >&3; exit 1`)
+	stub.ExtraFiles = []*os.File{child}
+	if err := stub.Start(); err != nil {
+		t.Fatal(err)
+	}
+	child.Close()
+
+	p := &stubbedProcess{stubCmd: stub}
+	err = p.execSetns(parent)
+	nserr, ok := err.(*nsError)
+	if !ok {
+		t.Fatalf("expected the namespace error, got %v", err)
+	}
+	if nserr.Type != "mnt" || nserr.Errno != unix.EBUSY {
+		t.Fatalf("unexpected namespace error %+v", nserr)
+	}
+}
+
+func TestStubAbortKillsForkedStages(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stub := exec.Command("/bin/sh", "-c", `sleep 100 & echo $! >&3; wait`)
+	stub.ExtraFiles = []*os.File{w}
+	stub.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := stub.Start(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	var forked int
+	if _, err := fmt.Fscan(r, &forked); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &stubbedProcess{stubCmd: stub}
+	p.abort()
+	stub.Wait()
+	// The forked process is left to whoever inherited it to be reaped.
+	for i := 0; ; i++ {
+		if stat, err := system.Stat(forked); err != nil || stat.State == system.Zombie {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("expected process %d forked by the stub to be killed", forked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKillReportedAfterTimeout(t *testing.T) {
+	container := exec.Command("sleep", "100")
+	if err := container.Start(); err != nil {
+		t.Fatal(err)
+	}
+	parent, child, err := utils.NewSockPair("init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	fmt.Fprintf(child, `{"pid": %d}`, container.Process.Pid)
+	child.Close()
+	unix.Shutdown(int(parent.Fd()), unix.SHUT_RDWR)
+
+	killReported(parent)
+	if err := unix.Kill(container.Process.Pid, 0); err != unix.ESRCH {
+		t.Fatalf("expected the reported process to be gone, got %v", err)
+	}
+}
+
+func TestInitProcessStartSync(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		ns        configs.Namespaces
+		script    []syncType
+		responses []syncType
+	}{
+		{
+			name:      "ready",
+			script:    []syncType{procReady},
+			responses: []syncType{procRun},
+		},
+		{
+			name:      "hooks then ready",
+			ns:        configs.Namespaces{{Type: configs.NEWNS}},
+			script:    []syncType{procHooks, procReady},
+			responses: []syncType{procResume, procRun},
+		},
+	} {
+		h := newProcessHarness(t, test.script...)
+		p := h.initProcess(&configs.Config{Namespaces: test.ns})
+		if err := p.start(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		h.wait()
+		if !reflect.DeepEqual(h.child.responses, test.responses) {
+			t.Fatalf("%s: expected responses %v but received %v", test.name, test.responses, h.child.responses)
+		}
+		if h.child.config == nil {
+			t.Fatalf("%s: expected the config to be sent to the child", test.name)
+		}
+		if h.manager.applied != 4242 {
+			t.Fatalf("%s: expected pid 4242 to be put in the cgroups, got %d", test.name, h.manager.applied)
+		}
+		if !reflect.DeepEqual(p.externalDescriptors(), h.procfs.fds) {
+			t.Fatalf("%s: expected descriptors %v but received %v", test.name, h.procfs.fds, p.externalDescriptors())
+		}
+		if p.process.ops != p {
+			t.Fatalf("%s: expected the process to be bound to the init process", test.name)
+		}
+		if h.spawner.waited {
+			t.Fatalf("%s: expected the container process not to be waited for", test.name)
+		}
+	}
+}
+
+// cmdOps waits for and signals a plain command.
+type cmdOps struct {
+	cmd   *exec.Cmd
+	waits int
+}
+
+func (o *cmdOps) wait() (*os.ProcessState, error) {
+	o.waits++
+	err := o.cmd.Wait()
+	if _, ok := err.(*exec.ExitError); ok {
+		err = nil
+	}
+	return o.cmd.ProcessState, err
+}
+
+func (o *cmdOps) signal(sig os.Signal) error {
+	return o.cmd.Process.Signal(sig)
+}
+
+func (o *cmdOps) pid() int {
+	return o.cmd.Process.Pid
+}
+
+func TestProcessWaitWithContext(t *testing.T) {
+	ops := &cmdOps{cmd: exec.Command("sleep", "10")}
+	if err := ops.cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	p := &Process{}
+	p.setOps(ops)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.WaitWithContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait to be given up, got %v", err)
+	}
+	if err := p.Signal(unix.SIGKILL); err != nil {
+		t.Fatalf("expected the process to be left running: %v", err)
+	}
+	// Nobody waits any more, the process is reaped all the same.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(fmt.Sprintf("/proc/%d", ops.pid())); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the process to be reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	state, err := p.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := state.Sys().(syscall.WaitStatus); !status.Signaled() || status.Signal() != unix.SIGKILL {
+		t.Fatalf("expected the process to be killed, got %v", state)
+	}
+	if again, err := p.WaitWithContext(context.Background()); err != nil || again != state {
+		t.Fatalf("expected waiting again to return the same state, got %v, %v", again, err)
+	}
+	if ops.waits != 1 {
+		t.Fatalf("expected the process to be waited for once, got %d", ops.waits)
+	}
+}
+
+func TestInitProcessCreatesCgroupns(t *testing.T) {
+	h := newProcessHarness(t, procReady)
+	h.child.cgroupns = true
+	p := h.initProcess(&configs.Config{Namespaces: configs.Namespaces{{Type: configs.NEWCGROUP}}})
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	if h.child.cgroupnsValue != createCgroupns {
+		t.Fatalf("expected createCgroupns to be sent before the config, got %#x", h.child.cgroupnsValue)
+	}
+	if h.child.config == nil {
+		t.Fatal("expected the config to follow")
+	}
+
+	// A joined cgroup namespace is left to nsexec to join by its path.
+	h = newProcessHarness(t, procReady)
+	p = h.initProcess(&configs.Config{Namespaces: configs.Namespaces{{Type: configs.NEWCGROUP, Path: "/proc/1/ns/cgroup"}}})
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	if h.child.config == nil {
+		t.Fatal("expected the config to be sent with nothing before it")
+	}
+}
+
+func TestInitProcessRecordsPassedFiles(t *testing.T) {
+	h := newProcessHarness(t, procReady)
+	h.procfs.fds = append(h.procfs.fds, "pipe:[3]", "socket:[4]")
+	p := h.initProcess(&configs.Config{})
+	p.config.PassedFilesCount = 2
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	if h.procfs.count != 5 {
+		t.Fatalf("expected the stdio and 2 passed descriptors to be recorded, got %d", h.procfs.count)
+	}
+}
+
+func TestInitProcessRunsPoststartHooks(t *testing.T) {
+	var states []configs.HookState
+	config := &configs.Config{
+		Labels: []string{"bundle=/bundle"},
+		Hooks: &configs.Hooks{Poststart: []configs.Hook{
+			configs.NewFunctionHook(func(configs.HookState) error { return errors.New("boom") }),
+			configs.NewFunctionHook(func(s configs.HookState) error {
+				states = append(states, s)
+				return nil
+			}),
+		}},
+	}
+	h := newProcessHarness(t, procReady)
+	p := h.initProcess(config)
+	if err := p.start(); err != nil {
+		t.Fatalf("expected a failing poststart hook not to fail the start: %v", err)
+	}
+	h.wait()
+	if len(states) != 1 {
+		t.Fatalf("expected the hooks after the failing one to run, got %d", len(states))
+	}
+	if s := states[0]; s.ID != "harness" || s.Pid != 4242 || s.Bundle != "/bundle" || s.Status != "running" {
+		t.Fatalf("unexpected hook state %+v", s)
+	}
+	if len(p.process.Warnings) != 1 || !strings.Contains(p.process.Warnings[0], "poststart hook 0") {
+		t.Fatalf("expected the failing hook to be warned about, got %q", p.process.Warnings)
+	}
+
+	// Nothing is started when the init fails.
+	states = nil
+	h = newProcessHarness(t, procError)
+	p = h.initProcess(config)
+	if err := p.start(); err == nil {
+		t.Fatal("expected start to fail")
+	}
+	h.wait()
+	if len(states) != 0 || len(p.process.Warnings) != 0 {
+		t.Fatal("expected no poststart hook to run")
+	}
+}
+
+func TestInitProcessDestroysNetworksOnFailure(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating network interfaces needs root")
+	}
+	bridge, err := utils.GenerateRandomName("lcbr", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := utils.GenerateRandomName("lcveth", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: bridge}}); err != nil {
+		t.Skipf("cannot create a bridge: %v", err)
+	}
+	defer netlink.LinkDel(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: bridge}})
+
+	h := newProcessHarness(t)
+	// The peer is moved into our own network namespace.
+	h.spawner.containerPid = os.Getpid()
+	p := h.initProcess(&configs.Config{Networks: []*configs.Network{
+		{Type: "loopback"},
+		{Type: "veth", Bridge: bridge, HostInterfaceName: host, Mtu: 1500},
+	}})
+	// Sending the config fails once the interfaces are created.
+	h.parent.Close()
+	if err := p.start(); err == nil {
+		t.Fatal("expected start to fail")
+	}
+	h.wait()
+	if len(p.config.Networks) != 2 {
+		t.Fatalf("expected both networks to be created, got %d", len(p.config.Networks))
+	}
+	for _, name := range []string{host, p.config.Networks[1].TempVethPeerName} {
+		if _, err := netlink.LinkByName(name); err == nil {
+			netlink.LinkDel(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: name}})
+			t.Fatalf("expected %s to be deleted", name)
+		}
+	}
+	if !h.manager.destroyed {
+		t.Fatal("expected the cgroups to be destroyed")
+	}
+}
+
+func TestGetPipeFds(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	cmd := exec.Command("sleep", "10")
+	cmd.ExtraFiles = []*os.File{r}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	fds, err := getPipeFds(cmd.Process.Pid, stdioFdCount+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := fileTargets([]*os.File{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fds) != 4 || fds[3] != targets[0] || !strings.HasPrefix(fds[3], "pipe:") {
+		t.Fatalf("expected the passed pipe %v to be the fourth descriptor, got %v", targets, fds)
+	}
+}
+
+func TestProcessStartMetrics(t *testing.T) {
+	sink := &fakeMetricsSink{}
+	h := newProcessHarness(t, procHooks, procReady)
+	config := &configs.Config{
+		Namespaces: configs.Namespaces{{Type: configs.NEWNS}},
+		Hooks: &configs.Hooks{
+			Prestart: []configs.Hook{configs.NewFunctionHook(func(configs.HookState) error { return nil })},
+		},
+	}
+	p := h.initProcess(config)
+	p.container.metrics = sink
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	want := []string{MetricCgroupApply, MetricHook, MetricInitStart}
+	if !reflect.DeepEqual(sink.names, want) {
+		t.Fatalf("expected %v to be observed, got %v", want, sink.names)
+	}
+	if labels := sink.labels[1]; labels["phase"] != "prestart" || labels["index"] != "0" {
+		t.Fatalf("expected the hook to be labelled with its phase and index, got %v", labels)
+	}
+
+	sink = &fakeMetricsSink{}
+	h = newProcessHarness(t)
+	setns := h.setnsProcess(nil)
+	setns.metrics = sink
+	if err := setns.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	if !reflect.DeepEqual(sink.names, []string{MetricExecStart}) {
+		t.Fatalf("expected %s to be observed, got %v", MetricExecStart, sink.names)
+	}
+}
+
+func TestInitProcessStartErrors(t *testing.T) {
+	boom := errors.New("boom")
+	for _, test := range []struct {
+		name   string
+		ns     configs.Namespaces
+		script []syncType
+		inject func(h *processHarness)
+		waited bool
+	}{
+		{
+			name:   "stub does not start",
+			inject: func(h *processHarness) { h.spawner.startErr = boom },
+		},
+		{
+			name:   "stub fails",
+			inject: func(h *processHarness) { h.spawner.execErr = boom },
+		},
+		{
+			name:   "descriptors cannot be read",
+			inject: func(h *processHarness) { h.procfs.err = boom },
+		},
+		{
+			name:   "cgroups cannot be applied",
+			inject: func(h *processHarness) { h.manager.applyErr = boom },
+		},
+		{
+			name:   "cgroups cannot be set",
+			script: []syncType{procReady},
+			inject: func(h *processHarness) { h.manager.setErr = boom },
+		},
+		{
+			name:   "cgroups cannot be set before hooks",
+			ns:     configs.Namespaces{{Type: configs.NEWNS}},
+			script: []syncType{procHooks},
+			inject: func(h *processHarness) { h.manager.setErr = boom },
+		},
+		{
+			name:   "child fails before ready",
+			script: []syncType{procError},
+			inject: func(h *processHarness) { h.child.errMsg = "boom" },
+		},
+		{
+			name:   "child fails after ready",
+			script: []syncType{procReady, procError},
+			inject: func(h *processHarness) { h.child.errMsg = "boom" },
+			waited: true,
+		},
+		{
+			name:   "child fails after hooks",
+			ns:     configs.Namespaces{{Type: configs.NEWNS}},
+			script: []syncType{procHooks, procError},
+			inject: func(h *processHarness) { h.child.errMsg = "boom" },
+		},
+		{
+			name: "child exits before ready",
+		},
+		{
+			name:   "child skips hooks",
+			ns:     configs.Namespaces{{Type: configs.NEWNS}},
+			script: []syncType{procReady},
+		},
+		{
+			name:   "child sends garbage",
+			script: []syncType{"procBogus"},
+		},
+	} {
+		h := newProcessHarness(t, test.script...)
+		if test.inject != nil {
+			test.inject(h)
+		}
+		p := h.initProcess(&configs.Config{Namespaces: test.ns})
+		if err := p.start(); err == nil {
+			t.Fatalf("%s: expected start to fail", test.name)
+		}
+		h.wait()
+		if h.spawner.waited != test.waited {
+			t.Fatalf("%s: expected the container process to be waited for: %v", test.name, test.waited)
+		}
+	}
+}
+
+func TestSetnsProcessStart(t *testing.T) {
+	h := newProcessHarness(t)
+	paths := map[string]string{"cpu": "/sys/fs/cgroup/cpu/harness"}
+	p := h.setnsProcess(paths)
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	if !reflect.DeepEqual(h.cgroups.entered, paths) {
+		t.Fatalf("expected cgroups %v to be entered but received %v", paths, h.cgroups.entered)
+	}
+	if p.pid() != 4242 || p.process.ops != p {
+		t.Fatal("expected the process to be bound to the container process")
+	}
+	if err := p.signal(unix.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.signals.sent, []syscall.Signal{unix.SIGTERM}) {
+		t.Fatalf("expected SIGTERM to be sent but received %v", h.signals.sent)
+	}
+	if start, err := p.startTime(); err != nil || start != h.procfs.start {
+		t.Fatalf("expected start time %d but received %d (%v)", h.procfs.start, start, err)
+	}
+}
+
+func TestSetnsProcessLateCgroups(t *testing.T) {
+	h := newProcessHarness(t, procCgroups)
+	paths := map[string]string{"cpu": "/sys/fs/cgroup/cpu/harness"}
+	stubPaths := map[string]string{"cpu": "/sys/fs/cgroup/cpu/stubs"}
+	p := h.setnsProcess(paths)
+	nice := 10
+	p.process.StubPriority = &nice
+	p.process.StubCgroupPaths = stubPaths
+	p.config.LateCgroups = true
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	stub := h.spawner.containerPid - 1
+	if !reflect.DeepEqual(h.cgroups.byPid[stub], stubPaths) || h.prios.set[stub] != nice {
+		t.Fatalf("expected the stub to be isolated, got cgroups %v and priorities %v", h.cgroups.byPid, h.prios.set)
+	}
+	// The process itself still ends up confined to the container.
+	if !reflect.DeepEqual(h.cgroups.byPid[h.spawner.containerPid], paths) {
+		t.Fatalf("expected the process to enter cgroups %v, got %v", paths, h.cgroups.byPid)
+	}
+	if h.prios.set[h.spawner.containerPid] != h.prios.nice {
+		t.Fatalf("expected the process to get our nice value back, got %v", h.prios.set)
+	}
+	if !reflect.DeepEqual(h.child.responses, []syncType{procResume}) {
+		t.Fatalf("expected procCgroups to be answered, got %v", h.child.responses)
+	}
+
+	// An init which doesn't ask for the cgroups is not left outside of them.
+	h = newProcessHarness(t)
+	p = h.setnsProcess(paths)
+	p.config.LateCgroups = true
+	if err := p.start(); err == nil {
+		t.Fatal("expected start to fail when the process did not enter the cgroups")
+	}
+	h.wait()
+}
+
+func TestSetnsProcessStartErrors(t *testing.T) {
+	boom := errors.New("boom")
+	for _, test := range []struct {
+		name   string
+		script []syncType
+		inject func(h *processHarness)
+		waited bool
+	}{
+		{
+			name:   "stub does not start",
+			inject: func(h *processHarness) { h.spawner.startErr = boom },
+		},
+		{
+			name:   "stub fails",
+			inject: func(h *processHarness) { h.spawner.execErr = boom },
+		},
+		{
+			name:   "cgroups cannot be entered",
+			inject: func(h *processHarness) { h.cgroups.err = boom },
+		},
+		{
+			name:   "child fails",
+			script: []syncType{procError},
+			inject: func(h *processHarness) { h.child.errMsg = "boom" },
+			waited: true,
+		},
+	} {
+		h := newProcessHarness(t, test.script...)
+		test.inject(h)
+		p := h.setnsProcess(map[string]string{"cpu": "/sys/fs/cgroup/cpu/harness"})
+		if err := p.start(); err == nil {
+			t.Fatalf("%s: expected start to fail", test.name)
+		}
+		h.wait()
+		if h.spawner.waited != test.waited {
+			t.Fatalf("%s: expected the container process to be waited for: %v", test.name, test.waited)
+		}
+	}
+}
+
+func TestSetnsProcessContainerStopped(t *testing.T) {
+	for _, stopped := range []bool{true, false} {
+		h := newProcessHarness(t)
+		h.spawner.execErr = &nsError{Type: "pid", Errno: unix.EINVAL}
+		p := h.setnsProcess(nil)
+		p.stopped = func() bool { return stopped }
+		err := p.start()
+		lerr, ok := err.(Error)
+		if !ok {
+			t.Fatalf("expected a libcontainer error, got %v", err)
+		}
+		want := SystemError
+		if stopped {
+			want = ContainerNotRunning
+		}
+		if lerr.Code() != want {
+			t.Fatalf("stopped %v: expected %v, got %v", stopped, want, lerr.Code())
+		}
+		if !strings.Contains(err.Error(), "pid namespace") {
+			t.Fatalf("expected the namespace to be named, got %q", err)
+		}
+		h.wait()
+	}
+}
+
+func TestInitProcessPidAndExitFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pidfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := newProcessHarness(t, procReady)
+	p := h.initProcess(&configs.Config{})
+	p.pidFile = filepath.Join(dir, "pid")
+	p.exitFile = filepath.Join(dir, "exit")
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	data, err := ioutil.ReadFile(p.pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "4242\n1234\n" {
+		t.Fatalf("unexpected pid file content %q", data)
+	}
+
+	cmd := exec.Command("sh", "-c", "exit 3")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected the command to fail")
+	}
+	exit, err := p.exitStatus(cmd.ProcessState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.writeExitFile(exit); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(p.exitFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exit = &ExitStatus{}
+	if err := json.Unmarshal(data, exit); err != nil {
+		t.Fatal(err)
+	}
+	if exit.Pid != 4242 || exit.Status != 3 || exit.Signal != 0 || exit.Cause != "" {
+		t.Fatalf("unexpected exit status %+v", exit)
+	}
+
+	p.container.lifetime = &lifetime{}
+	p.container.lifetime.expire()
+	if exit, err = p.exitStatus(cmd.ProcessState); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.writeExitFile(exit); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = ioutil.ReadFile(p.exitFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, exit); err != nil {
+		t.Fatal(err)
+	}
+	if exit.Cause != LifetimeExceeded {
+		t.Fatalf("expected the exceeded lifetime to be recorded, got %+v", exit)
+	}
+
+	// The pid file of an init process which fails to start is removed.
+	h = newProcessHarness(t, procReady)
+	h.manager.applyErr = errors.New("boom")
+	p = h.initProcess(&configs.Config{})
+	p.pidFile = filepath.Join(dir, "pid2")
+	if err := p.start(); err == nil {
+		t.Fatal("expected the start to fail")
+	}
+	h.wait()
+	if _, err := os.Stat(p.pidFile); !os.IsNotExist(err) {
+		t.Fatalf("expected the pid file to be removed, got %v", err)
+	}
+}
+
+func TestInitProcessNegotiatesProtocolVersion(t *testing.T) {
+	defer func(version string) { BuildVersion = version }(BuildVersion)
+	BuildVersion = "1.2.3"
+
+	h := newProcessHarness(t, procReady)
+	h.child.negotiate = true
+	p := h.initProcess(&configs.Config{})
+	p.config.ProtocolVersion = syncProtocolVersion
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	info := p.container.initInfo
+	if info.ProtocolVersion != syncProtocolVersion || info.Version != "1.2.3" || info.Warning != "" {
+		t.Fatalf("unexpected init info %+v", info)
+	}
+	annotations := info.Annotations()
+	if annotations[InitProtocolAnnotation] != "1" || annotations[InitVersionAnnotation] != "1.2.3" {
+		t.Fatalf("unexpected init annotations %v", annotations)
+	}
+}
+
+func TestInitProcessWarnsAboutOldInit(t *testing.T) {
+	h := newProcessHarness(t, procReady)
+	p := h.initProcess(&configs.Config{})
+	p.config.ProtocolVersion = syncProtocolVersion
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	info := p.container.initInfo
+	if info.ProtocolVersion != 0 || info.Warning == "" {
+		t.Fatalf("expected a warning about the old init, got %+v", info)
+	}
+}
+
+func TestInitProcessTerminateKillsInitAndCgroup(t *testing.T) {
+	h := newProcessHarness(t)
+	h.manager.allPids = []int{4242, 4343}
+	p := h.initProcess(&configs.Config{})
+	if err := p.terminate(); err != nil {
+		t.Fatal(err)
+	}
+	if !h.spawner.killed {
+		t.Fatal("expected the init process to be killed")
+	}
+	// The cgroups are swept even though killing the init process succeeded,
+	// and no process group, which may have been reused, is signalled.
+	if !reflect.DeepEqual(h.signals.pids, []int{4242, 4343}) {
+		t.Fatalf("expected the processes of the cgroup to be killed, killed %v", h.signals.pids)
+	}
+}
+
+func TestNonChildProcessTerminate(t *testing.T) {
+	cmd := exec.Command("sleep", "100")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	fd, err := system.PidfdOpen(cmd.Process.Pid)
+	if err != nil {
+		cmd.Process.Kill()
+		t.Skipf("pidfds are unsupported: %v", err)
+	}
+	unix.Close(fd)
+	stat, err := system.Stat(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &nonChildProcess{processPid: cmd.Process.Pid, processStartTime: stat.StartTime}
+	if err := p.terminate(); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+	status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !status.Signaled() || status.Signal() != unix.SIGKILL {
+		t.Fatalf("expected the process to be killed, got %v", cmd.ProcessState)
+	}
+}
+
+func TestSetnsProcessStartTimeout(t *testing.T) {
+	h := newProcessHarness(t)
+	h.spawner.hang = make(chan struct{})
+	p := h.setnsProcess(nil)
+	p.bootstrapData = strings.NewReader("")
+	p.process.StartTimeout = 10 * time.Millisecond
+	err := p.start()
+	if err == nil || !strings.Contains(err.Error(), "bootstrap data copied") {
+		t.Fatalf("expected a timeout after the bootstrap data was copied, got %v", err)
+	}
+	h.wait()
+
+	// A process which starts in time is left alone.
+	h = newProcessHarness(t)
+	p = h.setnsProcess(nil)
+	p.process.StartTimeout = time.Minute
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+}
+
+func TestInitProcessNetworkReady(t *testing.T) {
+	config := &configs.Config{Networks: []*configs.Network{
+		{Type: "loopback", Name: "lo", Address: "127.0.0.1/8"},
+	}}
+	var ifaces []NetworkInterfaceInfo
+	h := newProcessHarness(t, procReady)
+	p := h.initProcess(config)
+	p.process.NetworkReady = func(i []NetworkInterfaceInfo) error {
+		ifaces = i
+		return nil
+	}
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NetworkInterfaceInfo{{Type: "loopback", Name: "lo", MacAddress: lo.HardwareAddr.String(), Addresses: []string{"127.0.0.1/8"}}}
+	if !reflect.DeepEqual(ifaces, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, ifaces)
+	}
+
+	// The init process is not let run when the callback fails.
+	h = newProcessHarness(t, procReady)
+	p = h.initProcess(config)
+	p.process.NetworkReady = func([]NetworkInterfaceInfo) error {
+		return errors.New("boom")
+	}
+	if err := p.start(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the callback to fail the start, got %v", err)
+	}
+	h.wait()
+	if len(h.child.responses) != 0 {
+		t.Fatalf("expected no response to the child, got %v", h.child.responses)
+	}
+}
@@ -0,0 +1,114 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func (c *linuxContainer) Quiesce(ctx context.Context, fn func() error) error {
+	sem := c.quiesceSem()
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := c.quiesceFreeze(); err != nil {
+		<-sem
+		return err
+	}
+	start := time.Now()
+	logrus.WithField("id", c.id).Info("container quiesced")
+
+	errC, returned := make(chan error, 1), make(chan struct{})
+	go func() {
+		defer close(returned)
+		defer func() {
+			if r := recover(); r != nil {
+				errC <- fmt.Errorf("quiesce callback panicked: %v", r)
+			}
+		}()
+		errC <- fn()
+	}()
+	var err error
+	select {
+	case err = <-errC:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if terr := c.quiesceThaw(); terr != nil {
+		logrus.WithField("id", c.id).Error(terr)
+		if err == nil {
+			err = terr
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"id":       c.id,
+		"duration": time.Since(start),
+	}).Info("container thawed")
+	// The next call waits for fn to return, even past ctx, so that it never
+	// runs along with the fn of a later call.
+	select {
+	case <-returned:
+		<-sem
+	default:
+		go func() {
+			<-returned
+			<-sem
+		}()
+	}
+	return err
+}
+
+// quiesceSem returns the semaphore which queues Quiesce calls.
+func (c *linuxContainer) quiesceSem() chan struct{} {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.quiesceC == nil {
+		c.quiesceC = make(chan struct{}, 1)
+	}
+	return c.quiesceC
+}
+
+// quiesceFreeze freezes a running or created container for Quiesce. A
+// container which is already paused is left alone, as it would otherwise be
+// thawed behind the back of whoever paused it.
+func (c *linuxContainer) quiesceFreeze() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	switch status {
+	case Running, Created:
+	case Paused:
+		return newGenericError(fmt.Errorf("container paused, refusing to quiesce it"), ContainerPaused)
+	default:
+		return newGenericError(fmt.Errorf("container not running or created: %s", status), ContainerNotRunning)
+	}
+	if err := c.cgroupManager.Freeze(configs.Frozen, c.freezeTimeout); err != nil {
+		// Don't leave the container partially frozen.
+		if err := c.cgroupManager.Freeze(configs.Thawed, c.freezeTimeout); err != nil {
+			logrus.Warn(err)
+		}
+		return newSystemErrorWithCause(err, "freezing container")
+	}
+	c.quiescing = true
+	return nil
+}
+
+func (c *linuxContainer) quiesceThaw() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.quiescing = false
+	if err := c.cgroupManager.Freeze(configs.Thawed, c.freezeTimeout); err != nil {
+		return newSystemErrorWithCause(err, "thawing container")
+	}
+	return nil
+}
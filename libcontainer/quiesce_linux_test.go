@@ -0,0 +1,166 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+// freezerCgroupManager keeps the freezer state in a freezer.state file, the
+// way isPaused expects to find it.
+type freezerCgroupManager struct {
+	mockCgroupManager
+}
+
+func (m *freezerCgroupManager) Freeze(state configs.FreezerState, timeout time.Duration) error {
+	return ioutil.WriteFile(filepath.Join(m.paths["freezer"], "freezer.state"), []byte(state), 0644)
+}
+
+func (m *freezerCgroupManager) frozen() bool {
+	data, _ := ioutil.ReadFile(filepath.Join(m.paths["freezer"], "freezer.state"))
+	return string(data) == string(configs.Frozen)
+}
+
+func newQuiesceContainer(t *testing.T) (*linuxContainer, *freezerCgroupManager, func()) {
+	dir, err := ioutil.TempDir("", "testquiesce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := system.Stat(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &freezerCgroupManager{mockCgroupManager{paths: map[string]string{"freezer": dir}}}
+	if err := m.Freeze(configs.Thawed, 0); err != nil {
+		t.Fatal(err)
+	}
+	c := &linuxContainer{
+		id:                   "myid",
+		root:                 dir,
+		config:               &configs.Config{},
+		cgroupManager:        m,
+		initProcess:          &mockProcess{_pid: os.Getpid(), started: stat.StartTime},
+		initProcessStartTime: stat.StartTime,
+	}
+	c.state = &runningState{c: c}
+	return c, m, func() { os.RemoveAll(dir) }
+}
+
+func TestQuiesce(t *testing.T) {
+	c, m, cleanup := newQuiesceContainer(t)
+	defer cleanup()
+
+	err := c.Quiesce(context.Background(), func() error {
+		if !m.frozen() {
+			t.Error("expected the container to be frozen while quiesced")
+		}
+		if err := c.Resume(); err == nil {
+			t.Error("expected resuming a quiesced container to fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.frozen() {
+		t.Fatal("expected the container to be thawed after quiescing")
+	}
+
+	if err := c.Quiesce(context.Background(), func() error { panic("oops") }); err == nil {
+		t.Fatal("expected a panicking callback to return an error")
+	}
+	if m.frozen() {
+		t.Fatal("expected the container to be thawed after the callback panicked")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	if err := c.Quiesce(ctx, func() error { <-block; return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if m.frozen() {
+		t.Fatal("expected the container to be thawed after the deadline")
+	}
+
+	// The next call waits for the callback left running.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	called := false
+	if err := c.Quiesce(ctx, func() error { called = true; return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("expected the call to wait for the running callback, got %v", err)
+	}
+	if called {
+		t.Fatal("expected the callback not to run along with the previous one")
+	}
+	close(block)
+	if err := c.Quiesce(context.Background(), func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuiescePaused(t *testing.T) {
+	c, m, cleanup := newQuiesceContainer(t)
+	defer cleanup()
+
+	if err := c.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	err := c.Quiesce(context.Background(), func() error {
+		called = true
+		return nil
+	})
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ContainerPaused {
+		t.Fatalf("expected a ContainerPaused error, got %v", err)
+	}
+	if called {
+		t.Fatal("expected the callback not to be run for a paused container")
+	}
+	if !m.frozen() {
+		t.Fatal("expected the paused container to stay frozen")
+	}
+}
+
+func TestQuiesceQueued(t *testing.T) {
+	c, _, cleanup := newQuiesceContainer(t)
+	defer cleanup()
+
+	var (
+		mu      sync.Mutex
+		running int
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.Quiesce(context.Background(), func() error {
+				mu.Lock()
+				running++
+				if running > 1 {
+					t.Error("expected quiesce calls not to interleave")
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,117 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNotSupported is returned by ReclaimMemory on cgroup v1, and on kernels
+// without memory.reclaim, which came with 5.19.
+var ErrNotSupported = errors.New("proactive memory reclaim is not supported")
+
+// MemoryReclaim is how much memory ReclaimMemory asked the kernel to
+// reclaim from a container, and how much its memory usage went down by.
+type MemoryReclaim struct {
+	Requested uint64
+	Reclaimed uint64
+	// Duration is how long the reclaim took, retries included.
+	Duration time.Duration
+}
+
+func (c *linuxContainer) ReclaimMemory(bytes uint64, timeout time.Duration) error {
+	if bytes == 0 {
+		return newGenericError(fmt.Errorf("nothing to reclaim"), ConfigInvalid)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+	path := cgroupEventsPath(c.cgroupManager.GetPaths())
+	if path == "" {
+		return ErrNotSupported
+	}
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(filepath.Join(dir, "memory.reclaim")); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotSupported
+		}
+		return newSystemErrorWithCause(err, "checking for memory.reclaim")
+	}
+	reclaim, err := reclaimMemory(dir, bytes, timeout, func(amount uint64) error {
+		return ioutil.WriteFile(filepath.Join(dir, "memory.reclaim"), []byte(strconv.FormatUint(amount, 10)), 0)
+	})
+	if reclaim != nil {
+		sendFactoryEvent(c.events, FactoryEvent{Type: MemoryReclaimed, ID: c.id, Reclaim: reclaim})
+	}
+	if err != nil {
+		return newSystemErrorWithCause(err, "reclaiming memory")
+	}
+	return nil
+}
+
+// reclaimMemory asks write to reclaim bytes of memory from the cgroup dir,
+// halving the amount every time the kernel fails to reclaim all of it with
+// EAGAIN, until the amount falls below a page or timeout passes, if set.
+// The reclaim is returned whenever memory.current could be read before.
+func reclaimMemory(dir string, bytes uint64, timeout time.Duration, write func(uint64) error) (*MemoryReclaim, error) {
+	before, err := readMemoryCurrent(dir)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	amount := bytes
+	for {
+		err = write(amount)
+		if !isEAGAIN(err) {
+			break
+		}
+		amount /= 2
+		if amount < uint64(os.Getpagesize()) || (timeout > 0 && time.Since(start) >= timeout) {
+			break
+		}
+	}
+	reclaim := &MemoryReclaim{Requested: bytes, Duration: time.Since(start)}
+	after, rerr := readMemoryCurrent(dir)
+	if rerr != nil {
+		if err == nil {
+			err = rerr
+		}
+		return reclaim, err
+	}
+	if after < before {
+		reclaim.Reclaimed = before - after
+	}
+	return reclaim, err
+}
+
+// isEAGAIN returns true if err is the EAGAIN the kernel fails a write to
+// memory.reclaim with when it reclaimed less than asked.
+func isEAGAIN(err error) bool {
+	if perr, ok := err.(*os.PathError); ok {
+		err = perr.Err
+	}
+	return err == unix.EAGAIN
+}
+
+func readMemoryCurrent(dir string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
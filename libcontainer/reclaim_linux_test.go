@@ -0,0 +1,92 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReclaimMemoryRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reclaim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("8388608\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var asked []uint64
+	reclaim, err := reclaimMemory(dir, 4<<20, 0, func(amount uint64) error {
+		asked = append(asked, amount)
+		if amount > 1<<20 {
+			return &os.PathError{Op: "write", Path: "memory.reclaim", Err: unix.EAGAIN}
+		}
+		return ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("7340032\n"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 3 || asked[2] != 1<<20 {
+		t.Fatalf("expected the amount to be halved down to 1MiB, got %v", asked)
+	}
+	if reclaim.Requested != 4<<20 || reclaim.Reclaimed != 1<<20 {
+		t.Fatalf("unexpected reclaim %+v", reclaim)
+	}
+
+	// Giving up still reports how much was reclaimed meanwhile.
+	reclaim, err = reclaimMemory(dir, 4<<20, time.Nanosecond, func(amount uint64) error {
+		return &os.PathError{Op: "write", Path: "memory.reclaim", Err: unix.EAGAIN}
+	})
+	if !isEAGAIN(err) || reclaim == nil || reclaim.Reclaimed != 0 {
+		t.Fatalf("expected EAGAIN and an empty reclaim, got %+v, %v", reclaim, err)
+	}
+}
+
+func TestReclaimMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reclaim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, kill := newCreatedContainer(t, dir)
+	defer kill()
+	if err := c.ReclaimMemory(1<<20, 0); err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported without cgroup v2, got %v", err)
+	}
+
+	cgroup := filepath.Join(dir, "cgroup")
+	if err := os.Mkdir(cgroup, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{
+		"cgroup.events":  "populated 1\nfrozen 0\n",
+		"memory.current": "8388608\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(cgroup, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.cgroupManager = &mockCgroupManager{paths: map[string]string{"": cgroup}}
+	if err := c.ReclaimMemory(1<<20, 0); err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported without memory.reclaim, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(cgroup, "memory.reclaim"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan FactoryEvent, 1)
+	c.events = events
+	if err := c.ReclaimMemory(1<<20, 0); err != nil {
+		t.Fatal(err)
+	}
+	ev := <-events
+	if ev.Type != MemoryReclaimed || ev.ID != c.id || ev.Reclaim == nil || ev.Reclaim.Requested != 1<<20 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
@@ -0,0 +1,395 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/opencontainers/runc/libcontainer/utils"
+
+	"golang.org/x/sys/unix"
+)
+
+// FactoryEventType is the type of a FactoryEvent.
+type FactoryEventType string
+
+const (
+	// ContainerIDReleased reports that the container which had the id of the
+	// event was renamed to its Peer.
+	ContainerIDReleased FactoryEventType = "id-released"
+	// ContainerIDAssigned reports that the container which had the Peer of
+	// the event was renamed to its id.
+	ContainerIDAssigned FactoryEventType = "id-assigned"
+	// CallerInContainerCgroup reports that a process of the caller, the Pid
+	// of the event, was found in the cgroups of the container while
+	// signalling all its processes, and was spared. The cgroup layout
+	// of the caller is likely wrong, e.g. a misconfigured systemd slice.
+	CallerInContainerCgroup FactoryEventType = "caller-in-cgroup"
+	// ContainerWarning reports the Warning of the event, as it is recorded
+	// for Container.Warnings.
+	ContainerWarning FactoryEventType = "warning"
+	// MemoryReclaimed reports the Reclaim of the event, made by
+	// Container.ReclaimMemory.
+	MemoryReclaimed FactoryEventType = "memory-reclaimed"
+	// CgroupDrifted reports the Drift of the cgroups of a container, as seen
+	// by Container.WatchCgroupDrift.
+	CgroupDrifted FactoryEventType = "cgroup-drifted"
+)
+
+// FactoryEvent is something which happened to the containers of a factory.
+type FactoryEvent struct {
+	Type FactoryEventType
+	ID   string
+	// Peer is the other id of a renamed container.
+	Peer string
+	// Pid is the process of the caller of a CallerInContainerCgroup event.
+	Pid int
+	// Warning is that of a ContainerWarning event.
+	Warning *configs.Warning
+	// Reclaim is that of a MemoryReclaimed event.
+	Reclaim *MemoryReclaim
+	// Drift is that of a CgroupDrifted event.
+	Drift *CgroupDrift
+}
+
+// cgroupMoveAttempts bounds how many times the processes left in a cgroup
+// being renamed are moved, as they may keep forking meanwhile.
+const cgroupMoveAttempts = 16
+
+// Rename renames the container oldID to newID. Renames are serialised by a
+// lock on the root of the factory, and newID is claimed by renaming the state
+// directory without replacing any, so the bind mounts it holds go along with
+// it. The state directory is locked meanwhile, like by Exec. The cgroups
+// created for the container and named after it are renamed by moving its
+// processes to new ones, so that it keeps running throughout. This is only
+// supported by the cgroupfs manager of cgroup v1: with others, a container
+// whose cgroups still exist cannot be renamed.
+func (l *LinuxFactory) Rename(oldID, newID string) error {
+	if l.Root == "" {
+		return newGenericError(fmt.Errorf("invalid root"), ConfigInvalid)
+	}
+	if err := l.validateID(newID); err != nil {
+		return err
+	}
+	if oldID == newID {
+		return nil
+	}
+	lock, err := lockDir(l.Root)
+	if err != nil {
+		return newSystemErrorWithCause(err, "locking factory root")
+	}
+	defer lock.Close()
+
+	oldRoot, newRoot := filepath.Join(l.Root, oldID), filepath.Join(l.Root, newID)
+	// The lock is on the directory itself, so it is held on newRoot once it
+	// has been renamed, and newRoot cannot be locked before it is claimed.
+	dirLock, err := lockDir(oldRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newGenericError(fmt.Errorf("container %q does not exist", oldID), ContainerNotExists)
+		}
+		return newSystemErrorWithCause(err, "locking container state directory")
+	}
+	defer dirLock.Close()
+	state, err := l.loadState(oldRoot, oldID)
+	if err != nil {
+		return err
+	}
+	renamed := *state
+	renamed.ID = newID
+	moveCgroups := l.renameCgroups(&renamed, oldID, newID)
+	if moveCgroups {
+		manager := l.NewCgroupsManager(state.Config.Cgroups, state.CgroupPaths)
+		if _, ok := manager.(*fs.Manager); !ok {
+			exist, err := cgroupsExist(state.CgroupPaths)
+			if err != nil {
+				return newSystemErrorWithCause(err, "checking cgroups")
+			}
+			if exist {
+				return newGenericError(fmt.Errorf("cannot rename a container whose cgroups are not managed by cgroupfs"), ConfigInvalid)
+			}
+			moveCgroups = false
+		}
+	}
+	if moveCgroups {
+		c := &linuxContainer{cgroupManager: l.NewCgroupsManager(state.Config.Cgroups, state.CgroupPaths)}
+		paused, err := c.isPaused()
+		if err != nil {
+			return err
+		}
+		if paused {
+			return newGenericError(fmt.Errorf("cannot rename a paused container"), ContainerPaused)
+		}
+	}
+
+	if err := renameStateDir(oldRoot, newRoot); err != nil {
+		if err == unix.EEXIST {
+			return newGenericError(fmt.Errorf("container with id exists: %v", newID), IdInUse)
+		}
+		return newSystemErrorWithCause(err, "renaming state directory")
+	}
+	rollback := func() {
+		if err := os.Rename(newRoot, oldRoot); err != nil {
+			logrus.Warnf("renaming state directory of container %s back: %v", oldID, err)
+		}
+	}
+	if moveCgroups {
+		bundle := utils.SearchLabels(state.Config.Labels, "bundle")
+		if err := l.moveCgroups(state.CgroupPaths, renamed.CgroupPaths, &renamed.Config, cgroups.NewIdentity(newID, bundle)); err != nil {
+			l.restoreCgroups(oldID, state, &renamed, bundle)
+			rollback()
+			return newSystemErrorWithCause(err, "renaming cgroups")
+		}
+		rollback = func() {
+			l.restoreCgroups(oldID, state, &renamed, bundle)
+			if err := os.Rename(newRoot, oldRoot); err != nil {
+				logrus.Warnf("renaming state directory of container %s back: %v", oldID, err)
+			}
+		}
+	}
+	if err := writeStateFile(newRoot, &renamed); err != nil {
+		rollback()
+		return newSystemErrorWithCause(err, "saving renamed state")
+	}
+	l.sendEvent(FactoryEvent{Type: ContainerIDReleased, ID: oldID, Peer: newID})
+	l.sendEvent(FactoryEvent{Type: ContainerIDAssigned, ID: newID, Peer: oldID})
+	return nil
+}
+
+// renameCgroups rewrites the cgroups of s, the state of the container oldID,
+// for newID, and returns whether they have to be moved. Only the cgroups
+// created for the container and named after it are: those it joined and
+// those of rootless containers are kept.
+func (l *LinuxFactory) renameCgroups(s *State, oldID, newID string) bool {
+	if s.Config.Cgroups == nil || s.Config.Cgroups.Paths != nil || s.Rootless {
+		return false
+	}
+	cg := *s.Config.Cgroups
+	switch {
+	case cg.Path != "" && filepath.Base(cg.Path) == oldID:
+		cg.Path = filepath.Join(filepath.Dir(cg.Path), newID)
+	case cg.Path == "" && cg.Name == oldID:
+		cg.Name = newID
+	default:
+		return false
+	}
+	s.Config.Cgroups = &cg
+	paths := make(map[string]string, len(s.CgroupPaths))
+	for subsystem, path := range s.CgroupPaths {
+		if filepath.Base(path) == oldID {
+			path = filepath.Join(filepath.Dir(path), newID)
+		}
+		paths[subsystem] = path
+	}
+	s.CgroupPaths = paths
+	return true
+}
+
+// moveCgroups creates the cgroups in to with the resources of config, moves
+// the processes of those in from to them and removes the latter. A cgroup
+// missing from from is skipped, as there is nothing to move.
+func (l *LinuxFactory) moveCgroups(from, to map[string]string, config *configs.Config, ident cgroups.Identity) error {
+	dirs := make(map[string]string)
+	for subsystem, path := range from {
+		if to[subsystem] == path {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		dirs[path] = to[subsystem]
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+	// A cgroup with children could not be removed once emptied.
+	for old := range dirs {
+		if nested, err := hasChildCgroups(old); err != nil {
+			return err
+		} else if nested {
+			return fmt.Errorf("cgroup %s has child cgroups", old)
+		}
+	}
+	for old, path := range dirs {
+		if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err := copyCpuset(old, path); err != nil {
+			return err
+		}
+	}
+	if err := l.NewCgroupsManager(config.Cgroups, to).Set(config); err != nil {
+		return err
+	}
+	for old, path := range dirs {
+		if err := moveCgroupProcs(old, path); err != nil {
+			return err
+		}
+	}
+	if err := cgroups.WriteIdentity(to, ident); err != nil {
+		return err
+	}
+	for old := range dirs {
+		if err := os.Remove(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreCgroups moves the processes of a container whose cgroups were
+// being renamed from those of renamed back to those of its state s.
+func (l *LinuxFactory) restoreCgroups(id string, s, renamed *State, bundle string) {
+	if err := l.moveCgroups(renamed.CgroupPaths, s.CgroupPaths, &s.Config, cgroups.NewIdentity(id, bundle)); err != nil {
+		logrus.Warnf("moving container %s back to its cgroups: %v", id, err)
+	}
+}
+
+// cgroupsExist returns whether any of the cgroups at paths exists.
+func cgroupsExist(paths map[string]string) (bool, error) {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// hasChildCgroups returns whether the cgroup at path has any child cgroup.
+func hasChildCgroups(path string) (bool, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// copyCpuset gives the cpuset cgroup at path the cpus and memory nodes of
+// that at old, without which no process could be moved to it.
+func copyCpuset(old, path string) error {
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		data, err := ioutil.ReadFile(filepath.Join(old, file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, file), data, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveCgroupProcs moves the processes of the cgroup from to the cgroup to,
+// until none is left.
+func moveCgroupProcs(from, to string) error {
+	procs := filepath.Join(to, cgroups.CgroupProcesses)
+	for i := 0; i < cgroupMoveAttempts; i++ {
+		pids, err := cgroups.GetPids(from)
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		for _, pid := range pids {
+			if err := ioutil.WriteFile(procs, []byte(strconv.Itoa(pid)), 0); err != nil {
+				// The process exited meanwhile.
+				if perr, ok := err.(*os.PathError); ok && perr.Err == unix.ESRCH {
+					continue
+				}
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("processes are still being forked in cgroup %s", from)
+}
+
+// renameStateDir renames the state directory old to path, failing with
+// EEXIST if path exists. Filesystems without renameat2(2) support fall back
+// to checking first, which is racy but serialised with the other renames.
+func renameStateDir(old, path string) error {
+	err := system.RenameNoReplace(old, path)
+	if err != unix.EINVAL && err != unix.ENOSYS {
+		return err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return unix.EEXIST
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(old, path)
+}
+
+// writeStateFile replaces the state file in the state directory root with
+// s, atomically.
+func writeStateFile(root string, s *State) error {
+	tmp, err := ioutil.TempFile(root, stateFilename)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := utils.WriteJSON(tmp, s); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(root, stateFilename))
+}
+
+// lockDir takes an exclusive lock on dir, such as the factory root renames
+// are serialised by, which is held until the returned file is closed.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// sendEvent passes ev to the Events channel of the factory, if any, unless
+// the receiver is not keeping up.
+func (l *LinuxFactory) sendEvent(ev FactoryEvent) {
+	sendFactoryEvent(l.Events, ev)
+}
+
+func sendFactoryEvent(ch chan<- FactoryEvent, ev FactoryEvent) {
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- ev:
+	default:
+	}
+}
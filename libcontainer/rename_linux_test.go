@@ -0,0 +1,194 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func newRenameTestFactory(t *testing.T, events chan<- FactoryEvent) (*LinuxFactory, string) {
+	root, err := newTestRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory, err := New(root, Cgroupfs, FactoryEvents(events))
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+	return factory.(*LinuxFactory), root
+}
+
+func writeTestState(t *testing.T, root, id string, s *State) {
+	if err := os.Mkdir(filepath.Join(root, id), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := marshal(filepath.Join(root, id, stateFilename), s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFactoryRename(t *testing.T) {
+	events := make(chan FactoryEvent, 2)
+	factory, root := newRenameTestFactory(t, events)
+	defer os.RemoveAll(root)
+	// The cgroups of a stopped container are gone, only their paths are
+	// renamed.
+	cgroupRoot := filepath.Join(root, "cgroup")
+	writeTestState(t, root, "old", &State{
+		BaseState: BaseState{
+			ID: "old",
+			Config: configs.Config{
+				Rootfs:  "/mycontainer/root",
+				Cgroups: &configs.Cgroup{Path: "/parent/old", Resources: &configs.Resources{}},
+			},
+		},
+		CgroupPaths: map[string]string{
+			"memory": filepath.Join(cgroupRoot, "memory", "parent", "old"),
+			"cpu":    filepath.Join(cgroupRoot, "cpu", "shared"),
+		},
+	})
+	if err := factory.Rename("old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old")); !os.IsNotExist(err) {
+		t.Fatalf("expected the old state directory to be gone, got %v", err)
+	}
+	state, err := factory.loadState(filepath.Join(root, "new"), "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ID != "new" || state.Config.Rootfs != "/mycontainer/root" {
+		t.Fatalf("unexpected renamed state %+v", state.BaseState)
+	}
+	if state.Config.Cgroups.Path != "/parent/new" {
+		t.Fatalf("expected the cgroup path to be renamed, got %q", state.Config.Cgroups.Path)
+	}
+	if p := state.CgroupPaths["memory"]; p != filepath.Join(cgroupRoot, "memory", "parent", "new") {
+		t.Fatalf("expected the memory cgroup to be renamed, got %q", p)
+	}
+	if p := state.CgroupPaths["cpu"]; p != filepath.Join(cgroupRoot, "cpu", "shared") {
+		t.Fatalf("expected the cpu cgroup not named after the container to be kept, got %q", p)
+	}
+	for _, want := range []FactoryEvent{
+		{Type: ContainerIDReleased, ID: "old", Peer: "new"},
+		{Type: ContainerIDAssigned, ID: "new", Peer: "old"},
+	} {
+		select {
+		case ev := <-events:
+			if ev != want {
+				t.Fatalf("expected %+v, got %+v", want, ev)
+			}
+		default:
+			t.Fatalf("expected %+v to be sent", want)
+		}
+	}
+}
+
+func TestFactoryRenameLocksStateDir(t *testing.T) {
+	factory, root := newRenameTestFactory(t, nil)
+	defer os.RemoveAll(root)
+	writeTestState(t, root, "old", &State{BaseState: BaseState{ID: "old"}})
+	lock, err := lockDir(filepath.Join(root, "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := make(chan error, 1)
+	go func() { renamed <- factory.Rename("old", "new") }()
+	select {
+	case err := <-renamed:
+		lock.Close()
+		t.Fatalf("expected the rename to wait for the state directory lock, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	lock.Close()
+	if err := <-renamed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFactoryRenameRefusesSystemdCgroups(t *testing.T) {
+	root, err := newTestRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	factory, err := New(root, SystemdCgroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := filepath.Join(root, "cgroup", "memory", "system.slice", "runc-old.scope")
+	if err := os.MkdirAll(scope, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestState(t, root, "old", &State{
+		BaseState: BaseState{
+			ID: "old",
+			Config: configs.Config{
+				Cgroups: &configs.Cgroup{Name: "old", Parent: "system.slice", ScopePrefix: "runc", Resources: &configs.Resources{}},
+			},
+		},
+		CgroupPaths: map[string]string{"memory": scope},
+	})
+	err = factory.Rename("old", "new")
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ConfigInvalid {
+		t.Fatalf("expected ConfigInvalid, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old", stateFilename)); err != nil {
+		t.Fatalf("expected the state directory to be left alone: %v", err)
+	}
+
+	// Once its scope is gone, only its name is changed.
+	if err := os.Remove(scope); err != nil {
+		t.Fatal(err)
+	}
+	if err := factory.Rename("old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	state, err := factory.(*LinuxFactory).loadState(filepath.Join(root, "new"), "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Config.Cgroups.Name != "new" {
+		t.Fatalf("expected the scope to be renamed, got %q", state.Config.Cgroups.Name)
+	}
+}
+
+func TestFactoryRenameIdInUse(t *testing.T) {
+	events := make(chan FactoryEvent, 2)
+	factory, root := newRenameTestFactory(t, events)
+	defer os.RemoveAll(root)
+	writeTestState(t, root, "old", &State{BaseState: BaseState{ID: "old"}})
+	writeTestState(t, root, "new", &State{BaseState: BaseState{ID: "new"}})
+
+	err := factory.Rename("old", "new")
+	if lerr, ok := err.(Error); !ok || lerr.Code() != IdInUse {
+		t.Fatalf("expected IdInUse, got %v", err)
+	}
+	for _, id := range []string{"old", "new"} {
+		state, err := factory.loadState(filepath.Join(root, id), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.ID != id {
+			t.Fatalf("expected the state of %s to be left alone, got %q", id, state.ID)
+		}
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no event, got %+v", <-events)
+	}
+
+	err = factory.Rename("missing", "other")
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ContainerNotExists {
+		t.Fatalf("expected ContainerNotExists, got %v", err)
+	}
+	err = factory.Rename("old", "../new")
+	if lerr, ok := err.(Error); !ok || lerr.Code() != InvalidIdFormat {
+		t.Fatalf("expected InvalidIdFormat, got %v", err)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 
 	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
 )
 
 func newRestoredProcess(pid int, fds []string) (*restoredProcess, error) {
@@ -93,8 +95,34 @@ func (p *nonChildProcess) pid() int {
 	return p.processPid
 }
 
+// terminate kills the process and waits for it to exit. As it is not our
+// child it is neither reaped nor can its pid be trusted, so it is signalled
+// and watched through a pidfd.
 func (p *nonChildProcess) terminate() error {
-	return newGenericError(fmt.Errorf("restored process cannot be terminated"), SystemError)
+	fd, err := system.PidfdOpen(p.processPid)
+	if err != nil {
+		if err == unix.ESRCH {
+			return nil
+		}
+		return newSystemErrorWithCause(err, "opening pidfd of restored process")
+	}
+	defer unix.Close(fd)
+	// The pid may have been recycled before we managed to open it.
+	if !processAlive(p.processPid, p.processStartTime) {
+		return nil
+	}
+	if err := system.PidfdSendSignal(fd, unix.SIGKILL); err != nil {
+		if err == unix.ESRCH {
+			return nil
+		}
+		return newSystemErrorWithCause(err, "killing restored process")
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != unix.EINTR {
+			return err
+		}
+	}
 }
 
 func (p *nonChildProcess) wait() (*os.ProcessState, error) {
@@ -0,0 +1,102 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/syndtr/gocapability/capability"
+	"github.com/vishvananda/netlink/nl"
+
+	"golang.org/x/sys/unix"
+)
+
+var rlimitNames = map[int]string{
+	unix.RLIMIT_AS:         "RLIMIT_AS",
+	unix.RLIMIT_CORE:       "RLIMIT_CORE",
+	unix.RLIMIT_CPU:        "RLIMIT_CPU",
+	unix.RLIMIT_DATA:       "RLIMIT_DATA",
+	unix.RLIMIT_FSIZE:      "RLIMIT_FSIZE",
+	unix.RLIMIT_LOCKS:      "RLIMIT_LOCKS",
+	unix.RLIMIT_MEMLOCK:    "RLIMIT_MEMLOCK",
+	unix.RLIMIT_MSGQUEUE:   "RLIMIT_MSGQUEUE",
+	unix.RLIMIT_NICE:       "RLIMIT_NICE",
+	unix.RLIMIT_NOFILE:     "RLIMIT_NOFILE",
+	unix.RLIMIT_NPROC:      "RLIMIT_NPROC",
+	unix.RLIMIT_RSS:        "RLIMIT_RSS",
+	unix.RLIMIT_RTPRIO:     "RLIMIT_RTPRIO",
+	unix.RLIMIT_RTTIME:     "RLIMIT_RTTIME",
+	unix.RLIMIT_SIGPENDING: "RLIMIT_SIGPENDING",
+	unix.RLIMIT_STACK:      "RLIMIT_STACK",
+}
+
+func rlimitName(t int) string {
+	if name, ok := rlimitNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("rlimit type %d", t)
+}
+
+// canRaiseRlimits is replaced in tests.
+var canRaiseRlimits = func() (bool, error) {
+	pid, err := capability.NewPid(os.Getpid())
+	if err != nil {
+		return false, err
+	}
+	return pid.Get(capability.EFFECTIVE, capability.CAP_SYS_RESOURCE), nil
+}
+
+// raisedRlimits returns the limits above our own hard limits. The container
+// process inherits ours, and cannot be given higher ones by setupRlimits once
+// in a user namespace, where CAP_SYS_RESOURCE no longer counts for it, so
+// nsexec raises them on itself before cloning the container process.
+func raisedRlimits(limits []configs.Rlimit) ([]configs.Rlimit, error) {
+	var raised []configs.Rlimit
+	for _, l := range limits {
+		var cur unix.Rlimit
+		if err := unix.Getrlimit(l.Type, &cur); err != nil {
+			return nil, fmt.Errorf("getting %s: %v", rlimitName(l.Type), err)
+		}
+		if l.Hard <= cur.Max {
+			continue
+		}
+		ok, err := canRaiseRlimits()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot raise the hard limit of %s from %d to %d without CAP_SYS_RESOURCE", rlimitName(l.Type), cur.Max, l.Hard)
+		}
+		raised = append(raised, l)
+	}
+	return raised, nil
+}
+
+// encodeRlimits encodes limits for the RlimitsAttr of the bootstrap data, as
+// the type, soft and hard limit of each in native 64 bit integers. This must
+// be kept in sync with setup_rlimits in nsenter/nsexec.c.
+func encodeRlimits(limits []configs.Rlimit) []byte {
+	native := nl.NativeEndian()
+	b := make([]byte, 24*len(limits))
+	for i, l := range limits {
+		native.PutUint64(b[24*i:], uint64(l.Type))
+		native.PutUint64(b[24*i+8:], l.Soft)
+		native.PutUint64(b[24*i+16:], l.Hard)
+	}
+	return b
+}
+
+func setupRlimits(limits []configs.Rlimit, pid int) error {
+	for _, rlimit := range limits {
+		if err := system.Prlimit(pid, rlimit.Type, unix.Rlimit{Max: rlimit.Hard, Cur: rlimit.Soft}); err != nil {
+			if cur, gerr := system.GetPrlimit(pid, rlimit.Type); gerr == nil && rlimit.Hard > cur.Max {
+				return fmt.Errorf("error raising the hard limit of %s from %d to %d: %v", rlimitName(rlimit.Type), cur.Max, rlimit.Hard, err)
+			}
+			return fmt.Errorf("error setting %s to %d:%d: %v", rlimitName(rlimit.Type), rlimit.Soft, rlimit.Hard, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/vishvananda/netlink/nl"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRaisedRlimits(t *testing.T) {
+	var cur unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &cur); err != nil {
+		t.Fatal(err)
+	}
+	if cur.Max == math.MaxUint64 || cur.Max < 2 {
+		t.Skipf("cannot test with a hard limit of %d", cur.Max)
+	}
+	defer func(f func() (bool, error)) { canRaiseRlimits = f }(canRaiseRlimits)
+	canRaiseRlimits = func() (bool, error) { return false, nil }
+
+	lower := configs.Rlimit{Type: unix.RLIMIT_NOFILE, Hard: cur.Max - 1, Soft: cur.Max - 1}
+	raised, err := raisedRlimits([]configs.Rlimit{lower})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raised) != 0 {
+		t.Fatalf("expected no limit to be raised, got %v", raised)
+	}
+
+	higher := configs.Rlimit{Type: unix.RLIMIT_NOFILE, Hard: cur.Max + 1, Soft: cur.Max + 1}
+	_, err = raisedRlimits([]configs.Rlimit{lower, higher})
+	if err == nil || !strings.Contains(err.Error(), "RLIMIT_NOFILE") {
+		t.Fatalf("expected an error naming RLIMIT_NOFILE, got %v", err)
+	}
+
+	canRaiseRlimits = func() (bool, error) { return true, nil }
+	raised, err = raisedRlimits([]configs.Rlimit{lower, higher})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raised) != 1 || raised[0] != higher {
+		t.Fatalf("expected only the higher limit to be raised, got %v", raised)
+	}
+}
+
+func TestEncodeRlimits(t *testing.T) {
+	b := encodeRlimits([]configs.Rlimit{
+		{Type: unix.RLIMIT_NOFILE, Soft: 1024, Hard: 4096},
+		{Type: unix.RLIMIT_CORE, Soft: math.MaxUint64, Hard: math.MaxUint64},
+	})
+	if len(b) != 48 {
+		t.Fatalf("expected 48 bytes, got %d", len(b))
+	}
+	native := nl.NativeEndian()
+	if native.Uint64(b[0:]) != unix.RLIMIT_NOFILE || native.Uint64(b[8:]) != 1024 || native.Uint64(b[16:]) != 4096 {
+		t.Fatalf("unexpected encoding of RLIMIT_NOFILE: %v", b[:24])
+	}
+	if native.Uint64(b[24:]) != unix.RLIMIT_CORE || native.Uint64(b[40:]) != math.MaxUint64 {
+		t.Fatalf("unexpected encoding of RLIMIT_CORE: %v", b[24:])
+	}
+}
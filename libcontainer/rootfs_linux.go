@@ -10,9 +10,11 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/mount"
 	"github.com/docker/docker/pkg/symlink"
 	"github.com/mrunalp/fileutils"
@@ -46,6 +48,11 @@ func prepareRootfs(pipe io.ReadWriter, config *configs.Config) (err error) {
 	}
 
 	setupDev := needsSetupDev(config)
+	if setupDev {
+		if err := setupDevTmpfs(config); err != nil {
+			return newSystemErrorWithCause(err, "mounting tmpfs on /dev")
+		}
+	}
 	for _, m := range config.Mounts {
 		for _, precmd := range m.PremountCmds {
 			if err := mountCmd(precmd); err != nil {
@@ -64,16 +71,31 @@ func prepareRootfs(pipe io.ReadWriter, config *configs.Config) (err error) {
 		}
 	}
 
+	if config.CgroupFileShim {
+		if err := mountCgroupFileShim(config.Rootfs); err != nil {
+			return newSystemErrorWithCause(err, "mounting the cgroup file shim")
+		}
+	}
+
+	populateDev := setupDev && (config.Dev == nil || !config.Dev.NoPopulate)
 	if setupDev {
-		if err := createDevices(config); err != nil {
+		if err := createDevices(config, populateDev); err != nil {
 			return newSystemErrorWithCause(err, "creating device nodes")
 		}
+		if err := setupDevpts(config); err != nil {
+			return newSystemErrorWithCause(err, "setting up devpts")
+		}
 		if err := setupPtmx(config); err != nil {
 			return newSystemErrorWithCause(err, "setting up ptmx")
 		}
+	}
+	if populateDev {
 		if err := setupDevSymlinks(config.Rootfs); err != nil {
 			return newSystemErrorWithCause(err, "setting up /dev symlinks")
 		}
+		if err := setupDevSubmounts(config); err != nil {
+			return newSystemErrorWithCause(err, "setting up /dev sub-mounts")
+		}
 	}
 
 	// Signal the parent to run the pre-start hooks.
@@ -186,6 +208,15 @@ func mountToRootfs(m *configs.Mount, rootfs, mountLabel string) error {
 				return err
 			}
 		}
+		if copyUp && m.CopyUpLimit > 0 {
+			size, err := copyUpSize(dest)
+			if err != nil {
+				return newSystemErrorWithCause(err, "tmpcopyup: failed to measure "+dest)
+			}
+			if size > m.CopyUpLimit {
+				return fmt.Errorf("tmpcopyup: %s holds %d bytes, more than the limit of %d bytes", m.Destination, size, m.CopyUpLimit)
+			}
+		}
 		if copyUp {
 			tmpDir, err = ioutil.TempDir("/tmp", "runctmpdir")
 			if err != nil {
@@ -334,6 +365,48 @@ func mountToRootfs(m *configs.Mount, rootfs, mountLabel string) error {
 	return nil
 }
 
+// mountCgroupFileShim bind mounts, read-only, a copy of /proc/self/cgroup
+// listing every cgroup as "/" over the /proc/<pid>/cgroup of the process in
+// the proc mounted in rootfs. The copy is an unlinked file, so that nothing
+// is left behind.
+func mountCgroupFileShim(rootfs string) error {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "cgroup-shim")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(cgroupShimContent(data)); err != nil {
+		return err
+	}
+	if err := f.Chmod(0444); err != nil {
+		return err
+	}
+	dest := filepath.Join(rootfs, "proc", strconv.Itoa(unix.Getpid()), "cgroup")
+	if err := unix.Mount(fmt.Sprintf("/proc/self/fd/%d", f.Fd()), dest, "", unix.MS_BIND, ""); err != nil {
+		return err
+	}
+	return unix.Mount("", dest, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, "")
+}
+
+// cgroupShimContent returns data, the content of a /proc/<pid>/cgroup, with
+// the path of every cgroup replaced by "/".
+func cgroupShimContent(data []byte) []byte {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if parts := strings.SplitN(line, ":", 3); len(parts) == 3 {
+			lines = append(lines, parts[0]+":"+parts[1]+":/\n")
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
 func getCgroupMounts(m *configs.Mount) ([]*configs.Mount, error) {
 	mounts, err := cgroups.GetCgroupMounts(false)
 	if err != nil {
@@ -459,11 +532,15 @@ func reOpenDevNull() error {
 	return nil
 }
 
-// Create the device nodes in the container.
-func createDevices(config *configs.Config) error {
+// Create the device nodes in the container. Unless all is set, only
+// /dev/null is, which paths are masked with.
+func createDevices(config *configs.Config, all bool) error {
 	useBindMount := system.RunningInUserNS() || config.Namespaces.Contains(configs.NEWUSER)
 	oldMask := unix.Umask(0000)
 	for _, node := range config.Devices {
+		if !all && node.Path != "/dev/null" {
+			continue
+		}
 		// containers running in a user namespace are not allowed to mknod
 		// devices so we can just bind mount it from the host.
 		if err := createDeviceNode(config.Rootfs, node, useBindMount); err != nil {
@@ -618,17 +695,153 @@ func setReadonly() error {
 	return unix.Mount("/", "/", "bind", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_REC, "")
 }
 
+// mountsAt returns true if the configuration mounts something at dest.
+func mountsAt(config *configs.Config, dest string) bool {
+	for _, m := range config.Mounts {
+		if libcontainerUtils.CleanPath(m.Destination) == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// setupDevTmpfs mounts a tmpfs at /dev, unless the configuration mounts
+// something there itself. It is owned by the root of the container.
+func setupDevTmpfs(config *configs.Config) error {
+	if mountsAt(config, "/dev") {
+		return nil
+	}
+	dest := filepath.Join(config.Rootfs, "dev")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	return unix.Mount("tmpfs", dest, "tmpfs", unix.MS_NOSUID|unix.MS_STRICTATIME, label.FormatMountLabel(devTmpfsData(config), config.MountLabel))
+}
+
+// devTmpfsData returns the mount options of the tmpfs mounted at /dev.
+func devTmpfsData(config *configs.Config) string {
+	size, inodes := int64(configs.DefaultDevSize), int64(configs.DefaultDevInodes)
+	if d := config.Dev; d != nil {
+		if d.Size != 0 {
+			size = d.Size
+		}
+		if d.Inodes != 0 {
+			inodes = d.Inodes
+		}
+	}
+	// The ids are those of the user namespace of the container, so the
+	// tmpfs is owned by its root whatever the mappings.
+	return fmt.Sprintf("mode=755,size=%d,nr_inodes=%d,uid=0,gid=0", size, inodes)
+}
+
+// setupDevSubmounts mounts a tmpfs at /dev/shm and, for a container with an
+// IPC namespace of its own, mqueue at /dev/mqueue, unless the configuration
+// mounts something there itself. Without an IPC namespace, mqueue would
+// expose the message queues of the host, and it is skipped on kernels built
+// without it.
+func setupDevSubmounts(config *configs.Config) error {
+	submounts := []*configs.Mount{{
+		Source:      "shm",
+		Destination: "/dev/shm",
+		Device:      "tmpfs",
+		Flags:       defaultMountFlags,
+		Data:        "mode=1777,size=65536k",
+	}}
+	if createsNamespace(config, configs.NEWIPC) {
+		submounts = append(submounts, &configs.Mount{
+			Source:      "mqueue",
+			Destination: "/dev/mqueue",
+			Device:      "mqueue",
+			Flags:       defaultMountFlags,
+		})
+	}
+	for _, m := range submounts {
+		if mountsAt(config, m.Destination) {
+			continue
+		}
+		err := mountToRootfs(m, config.Rootfs, config.MountLabel)
+		if err == unix.ENODEV && m.Device == "mqueue" {
+			logrus.Debugf("not mounting %s: %v", m.Destination, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("mounting %s: %v", m.Destination, err)
+		}
+	}
+	return nil
+}
+
+// setupDevpts mounts a private devpts instance at /dev/pts, unless the
+// configuration mounts devpts there itself, so that the container's ptys are
+// isolated from those of the host and of other containers.
+func setupDevpts(config *configs.Config) error {
+	for _, m := range config.Mounts {
+		if m.Device == "devpts" && libcontainerUtils.CleanPath(m.Destination) == "/dev/pts" {
+			return nil
+		}
+	}
+	dest := filepath.Join(config.Rootfs, "dev/pts")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	return unix.Mount("devpts", dest, "devpts", unix.MS_NOSUID|unix.MS_NOEXEC, label.FormatMountLabel(devptsData(config), config.MountLabel))
+}
+
+// devptsData returns the mount options of the devpts instance of a container.
+func devptsData(config *configs.Config) string {
+	ptmxMode := os.FileMode(0666)
+	if config.Devpts != nil && config.Devpts.PtmxMode != 0 {
+		ptmxMode = config.Devpts.PtmxMode
+	}
+	data := fmt.Sprintf("newinstance,ptmxmode=%#o,mode=0620", ptmxMode.Perm())
+	// The tty group can only be set if it is mapped into the container.
+	if _, err := config.HostGID(5); err == nil {
+		data += ",gid=5"
+	}
+	return data
+}
+
 func setupPtmx(config *configs.Config) error {
 	ptmx := filepath.Join(config.Rootfs, "dev/ptmx")
 	if err := os.Remove(ptmx); err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if config.Devpts != nil && config.Devpts.BindPtmx {
+		f, err := os.OpenFile(ptmx, os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := unix.Mount(filepath.Join(config.Rootfs, "dev/pts/ptmx"), ptmx, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind mount dev ptmx %s", err)
+		}
+		return nil
+	}
 	if err := os.Symlink("pts/ptmx", ptmx); err != nil {
 		return fmt.Errorf("symlink dev ptmx %s", err)
 	}
 	return nil
 }
 
+// copyUpSize returns how many bytes of file content copying up dir copies.
+// Symlinks are not followed.
+func copyUpSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 // pivotRoot will call pivot_root such that rootfs becomes the new root
 // filesystem, and everything else is cleaned up.
 func pivotRoot(rootfs string) error {
@@ -638,13 +851,13 @@ func pivotRoot(rootfs string) error {
 	// with pivot_root this allows us to pivot without creating directories in
 	// the rootfs. Shout-outs to the LXC developers for giving us this idea.
 
-	oldroot, err := unix.Open("/", unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	oldroot, err := unix.Open("/", unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
 	if err != nil {
 		return err
 	}
 	defer unix.Close(oldroot)
 
-	newroot, err := unix.Open(rootfs, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	newroot, err := unix.Open(rootfs, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
 	if err != nil {
 		return err
 	}
@@ -747,6 +960,38 @@ func remountReadonly(m *configs.Mount) error {
 	return fmt.Errorf("unable to mount %s as readonly max retries reached", dest)
 }
 
+// exposedStateRoot returns the paths inside the container at which the bind
+// mounts of config expose the state root, which holds the state of every
+// container of the factory. There are none without a mount namespace, where
+// the mounts are not set up and a mask would cover the host's own paths.
+func exposedStateRoot(root string, config *configs.Config) []string {
+	if !config.Namespaces.Contains(configs.NEWNS) {
+		return nil
+	}
+	root = resolveHostPath(root)
+	var paths []string
+	for _, m := range config.Mounts {
+		if m.Device != "bind" {
+			continue
+		}
+		rel, err := filepath.Rel(resolveHostPath(m.Source), root)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		paths = append(paths, filepath.Join(libcontainerUtils.CleanPath(m.Destination), rel))
+	}
+	return paths
+}
+
+// resolveHostPath returns path with its symlinks resolved, or only cleaned
+// if it cannot be.
+func resolveHostPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
 // maskPath masks the top of the specified path inside a container to avoid
 // security issues from processes reading information from non-namespace aware
 // mounts ( proc/kcore ).
@@ -3,6 +3,9 @@
 package libcontainer
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -91,3 +94,123 @@ func TestNeedsSetupDevStrangeSourceDest(t *testing.T) {
 		t.Fatal("expected needsSetupDev to be true, got false")
 	}
 }
+
+func TestDevptsData(t *testing.T) {
+	for _, test := range []struct {
+		config   *configs.Config
+		expected string
+	}{
+		{
+			config:   &configs.Config{},
+			expected: "newinstance,ptmxmode=0666,mode=0620,gid=5",
+		},
+		{
+			config:   &configs.Config{Devpts: &configs.Devpts{PtmxMode: 0600}},
+			expected: "newinstance,ptmxmode=0600,mode=0620,gid=5",
+		},
+		{
+			config: &configs.Config{
+				Namespaces:  configs.Namespaces{{Type: configs.NEWUSER}},
+				GidMappings: []configs.IDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+			},
+			expected: "newinstance,ptmxmode=0666,mode=0620",
+		},
+		{
+			config: &configs.Config{
+				Namespaces:  configs.Namespaces{{Type: configs.NEWUSER}},
+				GidMappings: []configs.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			},
+			expected: "newinstance,ptmxmode=0666,mode=0620,gid=5",
+		},
+	} {
+		if data := devptsData(test.config); data != test.expected {
+			t.Errorf("expected devpts options %q but received %q", test.expected, data)
+		}
+	}
+}
+
+func TestDevTmpfsData(t *testing.T) {
+	for _, test := range []struct {
+		dev      *configs.Dev
+		expected string
+	}{
+		{
+			expected: "mode=755,size=67108864,nr_inodes=65536,uid=0,gid=0",
+		},
+		{
+			dev:      &configs.Dev{Size: 1 << 20},
+			expected: "mode=755,size=1048576,nr_inodes=65536,uid=0,gid=0",
+		},
+		{
+			dev:      &configs.Dev{Inodes: 100, NoPopulate: true},
+			expected: "mode=755,size=67108864,nr_inodes=100,uid=0,gid=0",
+		},
+	} {
+		if data := devTmpfsData(&configs.Config{Dev: test.dev}); data != test.expected {
+			t.Errorf("expected /dev options %q but received %q", test.expected, data)
+		}
+	}
+}
+
+func TestCopyUpSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copyup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "b"), make([]byte, 20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/proc/kcore", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	size, err := copyUpSize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 120 {
+		t.Fatalf("expected 120 bytes to be copied up, got %d", size)
+	}
+}
+
+func TestCgroupShimContent(t *testing.T) {
+	data := "12:memory:/user.slice/container\n1:name=systemd:/user.slice/container\n0::/user.slice/container\n"
+	expected := "12:memory:/\n1:name=systemd:/\n0::/\n"
+	if got := string(cgroupShimContent([]byte(data))); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestExposedStateRoot(t *testing.T) {
+	config := &configs.Config{
+		Mounts: []*configs.Mount{
+			{Source: "proc", Destination: "/proc", Device: "proc"},
+			{Source: "/run", Destination: "/host/run", Device: "bind"},
+			{Source: "/run/libct", Destination: "/libct/", Device: "bind"},
+			{Source: "/run/libct/foo", Destination: "/foo", Device: "bind"},
+			{Source: "/run/lib", Destination: "/lib", Device: "bind"},
+		},
+	}
+	// Without a mount namespace, masking would cover the host's paths.
+	if paths := exposedStateRoot("/run/libct", config); len(paths) != 0 {
+		t.Fatalf("expected no paths without a mount namespace, got %v", paths)
+	}
+	config.Namespaces = configs.Namespaces([]configs.Namespace{{Type: configs.NEWNS}})
+	paths := exposedStateRoot("/run/libct", config)
+	expected := []string{"/host/run/libct", "/libct"}
+	if len(paths) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+	for i := range paths {
+		if paths[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, paths)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/syndtr/gocapability/capability"
+)
+
+// SecurityMismatch is a security attribute of a process of a container
+// which differs from what its config asks for.
+type SecurityMismatch struct {
+	// Field names the attribute after the line of /proc/[pid]/status or the
+	// file it is read from, e.g. "CapEff" or "uid_map".
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (m SecurityMismatch) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", m.Field, m.Expected, m.Actual)
+}
+
+func (c *linuxContainer) VerifySecurity() ([]SecurityMismatch, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if err := c.checkVerifiable(); err != nil {
+		return nil, err
+	}
+	return c.verifySecurity(c.initProcess.pid(), c.config.Capabilities, c.config.NoNewPrivileges)
+}
+
+func (c *linuxContainer) VerifyProcessSecurity(process *Process) ([]SecurityMismatch, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if err := c.checkVerifiable(); err != nil {
+		return nil, err
+	}
+	pid, err := process.Pid()
+	if err != nil {
+		return nil, newGenericError(err, NoProcessOps)
+	}
+	caps := process.Capabilities
+	if caps == nil {
+		caps = c.config.Capabilities
+	}
+	noNewPrivs := c.config.NoNewPrivileges
+	if process.NoNewPrivileges != nil {
+		noNewPrivs = *process.NoNewPrivileges
+	}
+	return c.verifySecurity(pid, caps, noNewPrivs)
+}
+
+// checkVerifiable refuses a container which is not running. The init process
+// of a created container has not yet applied all of its security attributes
+// before it executes the user process.
+func (c *linuxContainer) checkVerifiable() error {
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	switch status {
+	case Stopped, StoppedWithStragglers:
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	case Created:
+		return newGenericError(fmt.Errorf("container not started"), ContainerNotRunning)
+	}
+	return nil
+}
+
+// verifySecurity compares the security attributes of process pid with those
+// it was started with, caps and noNewPrivs, and the rest of the config.
+func (c *linuxContainer) verifySecurity(pid int, caps *configs.Capabilities, noNewPrivs bool) ([]SecurityMismatch, error) {
+	// The ambient capabilities were dropped at start if the kernel does not
+	// support them and the config allows it.
+	caps, _, err := checkAmbient(caps, c.config.AllowAmbientFallback)
+	if err != nil {
+		return nil, err
+	}
+	status, err := readProcStatus(pid)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "reading process status")
+	}
+	// Whatever runc runs with is inherited by the processes it starts.
+	self, err := readProcStatus(os.Getpid())
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "reading own status")
+	}
+	var mismatches []SecurityMismatch
+	check := func(field, expected, actual string) {
+		if expected != actual {
+			mismatches = append(mismatches, SecurityMismatch{Field: field, Expected: expected, Actual: actual})
+		}
+	}
+
+	expected, err := c.expectedCaps(status, caps)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"CapEff", "CapBnd", "CapAmb"} {
+		actual, err := parseCapMask(status[field])
+		if err != nil {
+			return nil, newSystemErrorWithCausef(err, "parsing %s", field)
+		}
+		check(field, formatCapMask(expected[field]), formatCapMask(actual))
+	}
+	if actual, ok := status["NoNewPrivs"]; ok {
+		expected := "0"
+		if noNewPrivs || self["NoNewPrivs"] == "1" {
+			expected = "1"
+		}
+		check("NoNewPrivs", expected, actual)
+	}
+	if actual, ok := status["Seccomp"]; ok {
+		expected := self["Seccomp"]
+		if c.config.Seccomp != nil {
+			expected = "2"
+		}
+		check("Seccomp", expected, actual)
+	}
+	// Seccomp_filters is only reported by kernels since 5.9.
+	if actual, ok := status["Seccomp_filters"]; ok {
+		expected := self["Seccomp_filters"]
+		if c.config.Seccomp != nil {
+			n, err := strconv.Atoi(expected)
+			if err != nil {
+				return nil, newSystemErrorWithCause(err, "parsing own seccomp filter count")
+			}
+			expected = strconv.Itoa(n + 1)
+		}
+		check("Seccomp_filters", expected, actual)
+	}
+	for _, m := range []struct {
+		file     string
+		mappings []configs.IDMap
+	}{
+		{"uid_map", c.config.UidMappings},
+		{"gid_map", c.config.GidMappings},
+	} {
+		expected, ok, err := expectedIDMap(c.config, m.file, m.mappings)
+		if err != nil {
+			return nil, newSystemErrorWithCausef(err, "reading own %s", m.file)
+		}
+		if !ok {
+			continue
+		}
+		actual, err := readIDMap(fmt.Sprintf("/proc/%d/%s", pid, m.file))
+		if err != nil {
+			return nil, newSystemErrorWithCausef(err, "reading %s", m.file)
+		}
+		check(m.file, expected, actual)
+	}
+	actual, err := c.freezerState()
+	if err != nil {
+		return nil, err
+	}
+	var freezer configs.FreezerState
+	if c.config.Cgroups != nil && c.config.Cgroups.Resources != nil {
+		freezer = c.config.Cgroups.Resources.Freezer
+	}
+	switch {
+	case freezer != configs.Undefined:
+		check("freezer", string(freezer), actual)
+	case actual != string(configs.Frozen) && actual != string(configs.Thawed):
+		// Nothing was asked of the freezer, but it must not be stuck.
+		check("freezer", string(configs.Thawed), actual)
+	}
+	return mismatches, nil
+}
+
+// expectedCaps returns the capability sets a process started with caps has
+// according to status, as execve computes them for a binary without file
+// capabilities: root in the container gets its inheritable and bounding
+// capabilities, any other user its ambient ones only.
+func (c *linuxContainer) expectedCaps(status map[string]string, caps *configs.Capabilities) (map[string]uint64, error) {
+	var bounding, inheritable, ambient []string
+	if caps != nil {
+		bounding, inheritable, ambient = caps.Bounding, caps.Inheritable, caps.Ambient
+	}
+	bnd, err := capMask(bounding)
+	if err != nil {
+		return nil, err
+	}
+	inh, err := capMask(inheritable)
+	if err != nil {
+		return nil, err
+	}
+	amb, err := capMask(ambient)
+	if err != nil {
+		return nil, err
+	}
+	// The second of the uids is the effective one, on the host.
+	uids := strings.Fields(status["Uid"])
+	if len(uids) < 2 {
+		return nil, newSystemError(fmt.Errorf("unexpected Uid line %q", status["Uid"]))
+	}
+	rootUID, err := c.config.HostRootUID()
+	if err != nil {
+		return nil, newGenericError(err, ConfigInvalid)
+	}
+	eff := amb
+	if uids[1] == strconv.Itoa(rootUID) {
+		eff = bnd | inh | amb
+	}
+	return map[string]uint64{"CapEff": eff, "CapBnd": bnd, "CapAmb": amb}, nil
+}
+
+// capMask returns the mask of the named capabilities, as in the Cap lines
+// of /proc/[pid]/status.
+func capMask(names []string) (uint64, error) {
+	var mask uint64
+	for _, name := range names {
+		cap, ok := capabilityMap[name]
+		if !ok {
+			return 0, newGenericError(fmt.Errorf("unknown capability %q", name), ConfigInvalid)
+		}
+		mask |= 1 << uint(cap)
+	}
+	return mask, nil
+}
+
+func parseCapMask(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// formatCapMask returns the names of the capabilities of mask, in the order
+// of their values, or "none".
+func formatCapMask(mask uint64) string {
+	var names []string
+	for i := uint(0); i < 64; i++ {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		name := capability.Cap(i).String()
+		if name == "unknown" {
+			names = append(names, strconv.Itoa(int(i)))
+			continue
+		}
+		names = append(names, "CAP_"+strings.ToUpper(name))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// expectedIDMap returns the id map file of a process of a container with
+// config should have, from mappings if the container has a user namespace
+// of its own, or from runc's own otherwise. It returns false if it cannot
+// tell, as for a user namespace joined from a path.
+func expectedIDMap(config *configs.Config, file string, mappings []configs.IDMap) (string, bool, error) {
+	if config.Namespaces.Contains(configs.NEWUSER) {
+		if config.Namespaces.PathOf(configs.NEWUSER) != "" {
+			return "", false, nil
+		}
+		var lines []string
+		for _, m := range mappings {
+			lines = append(lines, fmt.Sprintf("%d %d %d", m.ContainerID, m.HostID, m.Size))
+		}
+		return strings.Join(lines, "; "), true, nil
+	}
+	own, err := readIDMap(filepath.Join("/proc/self", file))
+	return own, err == nil, err
+}
+
+// readIDMap reads the uid_map or gid_map file path, with the mappings
+// separated by semicolons.
+func readIDMap(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "; "), nil
+}
+
+// freezerState returns the state of the freezer cgroup of the container, or
+// that of the cgroup on cgroup v2, which is only FROZEN or THAWED.
+func (c *linuxContainer) freezerState() (string, error) {
+	if fcg := c.cgroupManager.GetPaths()["freezer"]; fcg != "" {
+		data, err := ioutil.ReadFile(filepath.Join(fcg, "freezer.state"))
+		if err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", newSystemErrorWithCause(err, "reading freezer state")
+		}
+	}
+	paused, err := c.isPaused()
+	if err != nil {
+		return "", err
+	}
+	if paused {
+		return string(configs.Frozen), nil
+	}
+	return string(configs.Thawed), nil
+}
+
+// readProcStatus reads the fields of /proc/[pid]/status.
+func readProcStatus(pid int) (map[string]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	status := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 2)
+		if len(parts) == 2 {
+			status[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	return status, s.Err()
+}
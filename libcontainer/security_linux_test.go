@@ -0,0 +1,80 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestFormatCapMask(t *testing.T) {
+	mask, err := capMask([]string{"CAP_KILL", "CAP_CHOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatCapMask(mask); got != "CAP_CHOWN,CAP_KILL" {
+		t.Fatalf("expected the capabilities in order, got %q", got)
+	}
+	if got := formatCapMask(0); got != "none" {
+		t.Fatalf("expected none, got %q", got)
+	}
+	if got := formatCapMask(1 << 63); got != "63" {
+		t.Fatalf("expected an unknown capability by number, got %q", got)
+	}
+	if _, err := capMask([]string{"CAP_BOGUS"}); err == nil {
+		t.Fatal("expected an unknown capability to be refused")
+	}
+}
+
+func TestExpectedIDMap(t *testing.T) {
+	config := &configs.Config{
+		Namespaces:  configs.Namespaces{{Type: configs.NEWUSER}},
+		UidMappings: []configs.IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+	}
+	got, ok, err := expectedIDMap(config, "uid_map", config.UidMappings)
+	if err != nil || !ok || got != "0 1000 1; 1 100000 65536" {
+		t.Fatalf("expected the mappings of the config, got %q, %v, %v", got, ok, err)
+	}
+	config.Namespaces = configs.Namespaces{{Type: configs.NEWUSER, Path: "/proc/1/ns/user"}}
+	if _, ok, err := expectedIDMap(config, "uid_map", config.UidMappings); ok || err != nil {
+		t.Fatalf("expected a joined user namespace not to be checked, got %v, %v", ok, err)
+	}
+	config.Namespaces = nil
+	own, err := readIDMap("/proc/self/uid_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := expectedIDMap(config, "uid_map", nil); err != nil || !ok || got != own {
+		t.Fatalf("expected the map of runc, %q, got %q, %v, %v", own, got, ok, err)
+	}
+}
+
+func TestVerifySecurityRefusesCreated(t *testing.T) {
+	root, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	waiting, started, kill := startNamedScript(t, root, initWaitName, "sleep 100")
+	defer kill()
+	if err := os.Mkdir(filepath.Join(root, execFifoFilename), 0700); err != nil {
+		t.Fatal(err)
+	}
+	c := &linuxContainer{
+		id:                   "verify",
+		root:                 root,
+		config:               &configs.Config{},
+		cgroupManager:        &mockCgroupManager{},
+		initProcess:          &nonChildProcess{processPid: waiting.Process.Pid, processStartTime: started},
+		initProcessStartTime: started,
+	}
+	c.state = &createdState{c: c}
+	_, err = c.VerifySecurity()
+	if lerr, ok := err.(Error); !ok || lerr.Code() != ContainerNotRunning {
+		t.Fatalf("expected a created container to be refused, got %v", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/keys"
 	"github.com/opencontainers/runc/libcontainer/seccomp"
 	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/opencontainers/selinux/go-selinux/label"
 
 	"golang.org/x/sys/unix"
@@ -28,6 +29,19 @@ func (l *linuxSetnsInit) getSessionRingName() string {
 }
 
 func (l *linuxSetnsInit) Init() error {
+	if l.config.CgroupOnly {
+		return l.execCgroupOnly()
+	}
+	// The namespaces have already been joined, so the binary is received in
+	// the container without ever being visible in its filesystem.
+	var hostBinary *os.File
+	if l.config.HostBinary {
+		f, err := receiveHostBinary(l.pipe)
+		if err != nil {
+			return err
+		}
+		hostBinary = f
+	}
 	if !l.config.Config.NoNewKeyring {
 		// do not inherit the parent's session keyring
 		if _, err := keys.JoinSessionKeyring(l.getSessionRingName()); err != nil {
@@ -47,6 +61,16 @@ func (l *linuxSetnsInit) Init() error {
 			return err
 		}
 	}
+	if err := setSchedIdle(l.config); err != nil {
+		return err
+	}
+	// This is done before loading the seccomp profile, which may not allow
+	// the sync.
+	if l.config.LateCgroups {
+		if err := syncParentCgroups(l.pipe); err != nil {
+			return err
+		}
+	}
 	if l.config.Config.Seccomp != nil {
 		if err := seccomp.InitSeccomp(l.config.Config.Seccomp); err != nil {
 			return err
@@ -61,5 +85,39 @@ func (l *linuxSetnsInit) Init() error {
 	if err := label.SetProcessLabel(l.config.ProcessLabel); err != nil {
 		return err
 	}
+	if hostBinary != nil {
+		return system.Fexecve(hostBinary.Fd(), l.config.Args[0:], os.Environ())
+	}
+	return system.Execv(l.config.Args[0], l.config.Args[0:], os.Environ())
+}
+
+// execCgroupOnly executes the process of a CgroupOnly config, which joined
+// no namespace and keeps our privileges, once it entered the cgroups of the
+// container.
+func (l *linuxSetnsInit) execCgroupOnly() error {
+	if l.config.CreateConsole {
+		if err := setupConsole(l.consoleSocket, l.config, false); err != nil {
+			return err
+		}
+		if err := system.Setctty(); err != nil {
+			return err
+		}
+	}
+	if l.config.LateCgroups {
+		if err := syncParentCgroups(l.pipe); err != nil {
+			return err
+		}
+	}
+	if err := utils.CloseExecFrom(l.config.PassedFilesCount + stdioFdCount); err != nil {
+		return err
+	}
+	if l.config.Cwd != "" {
+		if err := unix.Chdir(l.config.Cwd); err != nil {
+			return fmt.Errorf("chdir to cwd (%q) failed: %v", l.config.Cwd, err)
+		}
+	}
+	if l.config.Umask != nil {
+		unix.Umask(int(*l.config.Umask))
+	}
 	return system.Execv(l.config.Args[0], l.config.Args[0:], os.Environ())
 }
@@ -0,0 +1,171 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardBufferSize is how many signals may be pending while one is being
+// forwarded, so that bursts are not lost.
+const forwardBufferSize = 2048
+
+// runtimeSignals are the signals the Go runtime sends to its own threads.
+// SIGURG preempts goroutines and reaches every handler installed with
+// signal.Notify, although it was never sent to us.
+var runtimeSignals = map[os.Signal]struct{}{
+	unix.SIGURG: {},
+}
+
+// neverForwarded are the signals which are not relayed to the init process:
+// the host has no business signalling it about our children, and SIGKILL
+// and SIGSTOP cannot be caught anyway.
+var neverForwarded = map[os.Signal]struct{}{
+	unix.SIGCHLD: {},
+	unix.SIGKILL: {},
+	unix.SIGSTOP: {},
+}
+
+// signalRelay relays signals received by the calling process to an init
+// process until it is stopped.
+type signalRelay struct {
+	notifier notifier
+	signals  chan os.Signal
+	once     sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// startSignalRelay relays the signals among sigs which can be forwarded to
+// send. It returns nil if there are none.
+func startSignalRelay(n notifier, sigs []os.Signal, send func(os.Signal) error) *signalRelay {
+	var forwarded []os.Signal
+	for _, s := range sigs {
+		_, never := neverForwarded[s]
+		_, runtime := runtimeSignals[s]
+		if !never && !runtime {
+			forwarded = append(forwarded, s)
+		}
+	}
+	if len(forwarded) == 0 {
+		return nil
+	}
+	r := &signalRelay{
+		notifier: n,
+		signals:  make(chan os.Signal, forwardBufferSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	n.notify(r.signals, forwarded...)
+	go func() {
+		defer close(r.stopped)
+		for {
+			select {
+			case <-r.done:
+				return
+			case s := <-r.signals:
+				if err := send(s); err != nil {
+					logrus.WithField("signal", s).Warnf("forwarding signal to init process: %v", err)
+				}
+			}
+		}
+	}()
+	return r
+}
+
+// stop stops relaying signals, and returns once no more will be sent.
+func (r *signalRelay) stop() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.notifier.stop(r.signals)
+		close(r.done)
+	})
+	<-r.stopped
+}
+
+// ForwardOptions configures ForwardSignals.
+type ForwardOptions struct {
+	// Signals are the signals to forward. Every signal but SIGCHLD, which is
+	// about the children of the calling process, is forwarded if it is empty.
+	Signals []os.Signal
+
+	// ForwardRuntimeSignals forwards the signals which the Go runtime uses
+	// internally, such as SIGURG, which are filtered out by default.
+	ForwardRuntimeSignals bool
+
+	// All sends the signals to every process of the container rather than
+	// to its init process only.
+	All bool
+}
+
+// ForwardSignals forwards the signals the calling process receives to
+// container until it stops or ctx is done. SIGTERM is replaced with the
+// StopSignal of the container config if it sets one. A signal received once
+// the container has stopped is dropped rather than sent to a process which
+// may have reused the pid of its init.
+func ForwardSignals(ctx context.Context, container Container, opts ForwardOptions) error {
+	signals := make(chan os.Signal, forwardBufferSize)
+	signal.Notify(signals, opts.Signals...)
+	defer signal.Stop(signals)
+	return forwardSignals(ctx, container, opts, signals)
+}
+
+func forwardSignals(ctx context.Context, container Container, opts ForwardOptions, signals <-chan os.Signal) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- container.WaitStopped(ctx)
+	}()
+	config := container.Config()
+	stop := stopSignal(&config)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-stopped:
+			return err
+		case s := <-signals:
+			if _, ok := runtimeSignals[s]; ok && !opts.ForwardRuntimeSignals {
+				continue
+			}
+			if s == unix.SIGCHLD && len(opts.Signals) == 0 {
+				continue
+			}
+			if s == unix.SIGTERM {
+				s = stop
+			}
+			if err := signalRunning(container, s, opts.All); err != nil {
+				if lerr, ok := err.(Error); ok && lerr.Code() == ContainerNotRunning {
+					logrus.WithField("signal", s).Debug("not forwarding signal to stopped container")
+					continue
+				}
+				logrus.WithField("signal", s).Warnf("forwarding signal: %v", err)
+			}
+		}
+	}
+}
+
+// signalRunning sends s to container unless it has stopped, in which case
+// a ContainerNotRunning error is returned. The status of the container tells
+// a pid reused since its init exited by the start time of the process.
+func signalRunning(container Container, s os.Signal, all bool) error {
+	status, err := container.Status()
+	if err != nil {
+		return err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+	return container.Signal(s, all)
+}
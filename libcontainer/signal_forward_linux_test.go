@@ -0,0 +1,136 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+// recordingContainer records the signals sent to the container before
+// sending them.
+type recordingContainer struct {
+	*linuxContainer
+	sent []os.Signal
+}
+
+func (c *recordingContainer) Signal(s os.Signal, all bool) error {
+	c.sent = append(c.sent, s)
+	return c.linuxContainer.Signal(s, all)
+}
+
+func TestForwardSignals(t *testing.T) {
+	root, err := ioutil.TempDir("", "forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	cmd := exec.Command("sleep", "100")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	stat, err := system.Stat(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &linuxContainer{
+		id:                   "forward",
+		root:                 root,
+		config:               &configs.Config{StopSignal: int(unix.SIGKILL)},
+		cgroupManager:        &mockCgroupManager{},
+		initProcess:          &nonChildProcess{processPid: cmd.Process.Pid, processStartTime: stat.StartTime},
+		initProcessStartTime: stat.StartTime,
+	}
+	c.state = &runningState{c: c}
+	rc := &recordingContainer{linuxContainer: c}
+
+	signals := make(chan os.Signal, 3)
+	// Neither SIGURG nor SIGCHLD is forwarded by default, SIGTERM is
+	// replaced with the stop signal.
+	signals <- unix.SIGURG
+	signals <- unix.SIGCHLD
+	signals <- unix.SIGTERM
+	done := make(chan error, 1)
+	go func() {
+		done <- forwardSignals(context.Background(), rc, ForwardOptions{}, signals)
+	}()
+	if err := cmd.Wait(); err == nil {
+		t.Fatal("expected sleep to be killed")
+	}
+	if ws := cmd.ProcessState.Sys().(syscall.WaitStatus); ws.Signal() != unix.SIGKILL {
+		t.Fatalf("expected sleep to be killed by the stop signal, got %v", ws)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected forwarding to stop along with the container")
+	}
+	if len(rc.sent) != 1 || rc.sent[0] != unix.SIGKILL {
+		t.Fatalf("expected only the stop signal to be forwarded, got %v", rc.sent)
+	}
+	if err := signalRunning(c, unix.SIGTERM, false); err == nil {
+		t.Fatal("expected no signal to be sent to a stopped container")
+	}
+}
+
+func TestSignalRelay(t *testing.T) {
+	n := &fakeNotifier{}
+	sent := make(chan os.Signal, 1)
+	r := startSignalRelay(n, []os.Signal{unix.SIGTERM, unix.SIGCHLD, unix.SIGKILL, unix.SIGURG}, func(s os.Signal) error {
+		sent <- s
+		return nil
+	})
+	if len(n.notified) != 1 || n.notified[0] != unix.SIGTERM {
+		t.Fatalf("expected only SIGTERM to be relayed, got %v", n.notified)
+	}
+	n.c <- unix.SIGTERM
+	select {
+	case s := <-sent:
+		if s != unix.SIGTERM {
+			t.Fatalf("expected SIGTERM to be sent, got %v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the signal to be relayed")
+	}
+	r.stop()
+	r.stop()
+	if !n.stopped {
+		t.Fatal("expected the relay to stop being notified")
+	}
+	if r := startSignalRelay(n, []os.Signal{unix.SIGCHLD}, nil); r != nil {
+		t.Fatal("expected no relay without signals to forward")
+	}
+}
+
+func TestInitProcessForwardsSignals(t *testing.T) {
+	h := newProcessHarness(t, procReady)
+	p := h.initProcess(&configs.Config{})
+	p.process.ForwardSignals = []os.Signal{unix.SIGINT}
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	if len(h.notes.notified) != 1 || h.notes.notified[0] != unix.SIGINT {
+		t.Fatalf("expected SIGINT to be relayed once started, got %v", h.notes.notified)
+	}
+	if _, err := p.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !h.notes.stopped {
+		t.Fatal("expected relaying to stop once the init process was waited for")
+	}
+}
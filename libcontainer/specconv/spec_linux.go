@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/seccomp"
 	libcontainerUtils "github.com/opencontainers/runc/libcontainer/utils"
@@ -28,6 +30,7 @@ var namespaceMapping = map[specs.LinuxNamespaceType]configs.NamespaceType{
 	specs.UserNamespace:    configs.NEWUSER,
 	specs.IPCNamespace:     configs.NEWIPC,
 	specs.UTSNamespace:     configs.NEWUTS,
+	specs.CgroupNamespace:  configs.NEWCGROUP,
 }
 
 var mountPropagationMapping = map[string]int{
@@ -206,7 +209,11 @@ func CreateLibcontainerConfig(opts *CreateOpts) (*configs.Config, error) {
 		}
 	}
 	for _, m := range spec.Mounts {
-		config.Mounts = append(config.Mounts, createLibcontainerMount(cwd, m))
+		mount, err := createLibcontainerMount(cwd, m)
+		if err != nil {
+			return nil, err
+		}
+		config.Mounts = append(config.Mounts, mount)
 	}
 	if err := createDevices(spec, config); err != nil {
 		return nil, err
@@ -247,12 +254,35 @@ func CreateLibcontainerConfig(opts *CreateOpts) (*configs.Config, error) {
 	}
 	createHooks(spec, config)
 	config.MountLabel = spec.Linux.MountLabel
-	config.Version = specs.Version
+	// Hooks are handed the state in the format of the spec version of the
+	// bundle, so honour it.
+	config.Version = spec.Version
+	if config.Version == "" {
+		config.Version = specs.Version
+	}
 	return config, nil
 }
 
-func createLibcontainerMount(cwd string, m specs.Mount) *configs.Mount {
-	flags, pgflags, data, ext := parseMountOptions(m.Options)
+// copyUpLimitOption is the mount option setting the CopyUpLimit of a mount.
+const copyUpLimitOption = "tmpcopyup-limit="
+
+func createLibcontainerMount(cwd string, m specs.Mount) (*configs.Mount, error) {
+	var (
+		options     []string
+		copyUpLimit int64
+	)
+	for _, o := range m.Options {
+		if !strings.HasPrefix(o, copyUpLimitOption) {
+			options = append(options, o)
+			continue
+		}
+		limit, err := units.RAMInBytes(strings.TrimPrefix(o, copyUpLimitOption))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid mount option %s for %s", o, m.Destination)
+		}
+		copyUpLimit = limit
+	}
+	flags, pgflags, data, ext := parseMountOptions(options)
 	source := m.Source
 	if m.Type == "bind" {
 		if !filepath.IsAbs(source) {
@@ -267,7 +297,32 @@ func createLibcontainerMount(cwd string, m specs.Mount) *configs.Mount {
 		Flags:            flags,
 		PropagationFlags: pgflags,
 		Extensions:       ext,
+		CopyUpLimit:      copyUpLimit,
+	}, nil
+}
+
+// systemdPropertyPrefix prefixes the annotations which are properties of the
+// systemd unit of the container, e.g. "org.systemd.property.TasksMax".
+const systemdPropertyPrefix = "org.systemd.property."
+
+// systemdProperties returns the systemd properties of annotations, sorted by
+// name.
+func systemdProperties(annotations map[string]string) []configs.SystemdProperty {
+	var keys []string
+	for k := range annotations {
+		if strings.HasPrefix(k, systemdPropertyPrefix) && k != systemdPropertyPrefix {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var props []configs.SystemdProperty
+	for _, k := range keys {
+		props = append(props, configs.SystemdProperty{
+			Name:  strings.TrimPrefix(k, systemdPropertyPrefix),
+			Value: annotations[k],
+		})
 	}
+	return props
 }
 
 func createCgroupConfig(opts *CreateOpts) (*configs.Cgroup, error) {
@@ -306,6 +361,7 @@ func createCgroupConfig(opts *CreateOpts) (*configs.Cgroup, error) {
 			c.ScopePrefix = parts[1]
 			c.Name = parts[2]
 		}
+		c.SystemdProps = systemdProperties(spec.Annotations)
 	} else {
 		if myCgroupPath == "" {
 			c.Name = name
@@ -5,6 +5,7 @@ package specconv
 import (
 	"testing"
 
+	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/configs/validate"
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
@@ -33,6 +34,47 @@ func TestLinuxCgroupsPathSpecified(t *testing.T) {
 	}
 }
 
+func TestLinuxCgroupSystemdProperties(t *testing.T) {
+	spec := &specs.Spec{
+		Annotations: map[string]string{
+			"org.systemd.property.TasksMax": "uint64 100",
+			"org.systemd.property.Slice":    "'custom.slice'",
+			"org.systemd.property.":         "ignored",
+			"org.example.TasksMax":          "ignored",
+		},
+	}
+	opts := &CreateOpts{
+		CgroupName:       "ContainerID",
+		UseSystemdCgroup: true,
+		Spec:             spec,
+	}
+
+	cgroup, err := createCgroupConfig(opts)
+	if err != nil {
+		t.Fatalf("Couldn't create Cgroup config: %v", err)
+	}
+	expected := []configs.SystemdProperty{
+		{Name: "Slice", Value: "'custom.slice'"},
+		{Name: "TasksMax", Value: "uint64 100"},
+	}
+	if len(cgroup.SystemdProps) != len(expected) {
+		t.Fatalf("expected systemd properties %v, got %v", expected, cgroup.SystemdProps)
+	}
+	for i, p := range expected {
+		if cgroup.SystemdProps[i] != p {
+			t.Errorf("expected systemd properties %v, got %v", expected, cgroup.SystemdProps)
+		}
+	}
+
+	opts.UseSystemdCgroup = false
+	if cgroup, err = createCgroupConfig(opts); err != nil {
+		t.Fatalf("Couldn't create Cgroup config: %v", err)
+	}
+	if len(cgroup.SystemdProps) != 0 {
+		t.Errorf("expected no systemd properties without the systemd driver, got %v", cgroup.SystemdProps)
+	}
+}
+
 func TestLinuxCgroupsPathNotSpecified(t *testing.T) {
 	spec := &specs.Spec{}
 	opts := &CreateOpts{
@@ -118,3 +160,32 @@ func TestRootlessSpecconvValidate(t *testing.T) {
 		t.Errorf("Expected specconv to produce valid rootless container config: %v", err)
 	}
 }
+
+func TestCopyUpLimitOption(t *testing.T) {
+	m, err := createLibcontainerMount("/", specs.Mount{
+		Destination: "/run",
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Options:     []string{"nosuid", "tmpcopyup", "tmpcopyup-limit=1m", "mode=755"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CopyUpLimit != 1024*1024 {
+		t.Fatalf("expected a copy up limit of 1MiB, got %d", m.CopyUpLimit)
+	}
+	if m.Extensions&configs.EXT_COPYUP == 0 {
+		t.Fatal("expected the mount to be copied up")
+	}
+	if m.Data != "mode=755" {
+		t.Fatalf("expected the limit not to be passed to the filesystem, got %q", m.Data)
+	}
+
+	if _, err := createLibcontainerMount("/", specs.Mount{
+		Destination: "/run",
+		Type:        "tmpfs",
+		Options:     []string{"tmpcopyup-limit=lots"},
+	}); err == nil {
+		t.Fatal("expected an invalid limit to be refused")
+	}
+}
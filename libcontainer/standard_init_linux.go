@@ -123,6 +123,13 @@ func (l *linuxStandardInit) Init() error {
 			return err
 		}
 	}
+	if l.config.Config.Namespaces.Contains(configs.NEWNS) {
+		for _, path := range l.config.StateRootMasks {
+			if err := maskPath(path); err != nil {
+				return newSystemErrorWithCausef(err, "masking the state root at %q", path)
+			}
+		}
+	}
 	pdeath, err := system.GetParentDeathSignal()
 	if err != nil {
 		return err
@@ -132,6 +139,9 @@ func (l *linuxStandardInit) Init() error {
 			return err
 		}
 	}
+	if err := setSchedIdle(l.config); err != nil {
+		return err
+	}
 	// Tell our parent that we're ready to Execv. This must be done before the
 	// Seccomp rules have been applied, because we need to be able to read and
 	// write to a socket.
@@ -166,6 +176,11 @@ func (l *linuxStandardInit) Init() error {
 	if err != nil {
 		return err
 	}
+	// The name tells the init waiting on the exec fifo apart from the
+	// process of the container once it has been released, see runType.
+	if err := system.SetName(initWaitName); err != nil {
+		return newSystemErrorWithCause(err, "setting init process name")
+	}
 	// close the pipe to signal that we have completed our init.
 	l.pipe.Close()
 	// wait for the fifo to be opened on the other side before
@@ -8,8 +8,8 @@ import (
 	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
-	"github.com/opencontainers/runc/libcontainer/utils"
 
 	"golang.org/x/sys/unix"
 )
@@ -39,13 +39,42 @@ type containerState interface {
 }
 
 func destroy(c *linuxContainer) error {
-	if !c.config.Namespaces.Contains(configs.NEWPID) {
-		if err := signalAllProcesses(c.cgroupManager, unix.SIGKILL); err != nil {
+	if c.killCgroupProcessesOnDestroy() {
+		if err := c.signalAllProcesses(unix.SIGKILL, c.killExemptPids()); err != nil {
+			logrus.Warn(err)
+		}
+	}
+	cgroups.RemoveIdentity(c.cgroupManager.GetPaths())
+	c.consolesMu.Lock()
+	for _, console := range c.consoles {
+		console.Close()
+	}
+	c.consoles = nil
+	c.consolesMu.Unlock()
+	c.output = nil
+	if err := c.stopFdStore(); err != nil {
+		logrus.Warn(err)
+	}
+	c.closeCgroupEvents()
+	if c.config.PreserveMountNSOnExit {
+		if err := c.releaseMountNS(); err != nil {
+			logrus.Warn(err)
+		}
+	}
+	if c.pidFile != "" {
+		if err := os.Remove(c.pidFile); err != nil && !os.IsNotExist(err) {
 			logrus.Warn(err)
 		}
 	}
 	err := c.cgroupManager.Destroy()
-	if rerr := os.RemoveAll(c.root); err == nil {
+	// The state directory is only removed once the views of the mounts with
+	// a forced owner are gone from it, or the files of their sources would
+	// be removed along with it.
+	if oerr := c.releaseOwnedMounts(); oerr != nil {
+		if err == nil {
+			err = oerr
+		}
+	} else if rerr := os.RemoveAll(c.root); err == nil {
 		err = rerr
 	}
 	c.initProcess = nil
@@ -58,16 +87,12 @@ func destroy(c *linuxContainer) error {
 
 func runPoststopHooks(c *linuxContainer) error {
 	if c.config.Hooks != nil {
-		s := configs.HookState{
-			Version: c.config.Version,
-			ID:      c.id,
-			Bundle:  utils.SearchLabels(c.config.Labels, "bundle"),
-		}
-		for _, hook := range c.config.Hooks.Poststop {
-			if err := hook.Run(s); err != nil {
-				return err
-			}
+		// The poststop hooks are told how the container was to be stopped.
+		s := c.newHookState("stopped", 0)
+		for k, v := range StopAnnotations(c.config) {
+			s.Annotations[k] = v
 		}
+		return c.runHooks("poststop", c.config.Hooks.Poststop, s)
 	}
 	return nil
 }
@@ -78,6 +103,9 @@ type stoppedState struct {
 }
 
 func (b *stoppedState) status() Status {
+	if b.c != nil && b.c.hasStragglers() {
+		return StoppedWithStragglers
+	}
 	return Stopped
 }
 
@@ -188,7 +216,7 @@ func (p *pausedState) destroy() error {
 		return err
 	}
 	if t != Running && t != Created {
-		if err := p.c.cgroupManager.Freeze(configs.Thawed); err != nil {
+		if err := p.c.cgroupManager.Freeze(configs.Thawed, p.c.freezeTimeout); err != nil {
 			return err
 		}
 		return destroy(p.c)
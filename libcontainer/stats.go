@@ -13,3 +13,29 @@ type NetworkInterface struct {
 	TxErrors  uint64
 	TxDropped uint64
 }
+
+// Commitment is how much of a resource of the host the limits of the
+// containers of a factory add up to.
+type Commitment struct {
+	// Committed is the sum of the limits of the containers which have one.
+	Committed float64
+	// Capacity is how much of the resource the host has.
+	Capacity float64
+	// Limited is how many containers have a limit, and Unbounded how many
+	// have none, which Committed does not account for.
+	Limited   int
+	Unbounded int
+}
+
+// Commitments are the resources of the host promised to the containers of
+// a factory which are not stopped.
+type Commitments struct {
+	// Memory is in bytes.
+	Memory Commitment
+	// Cpu is in cores, from the CFS quotas.
+	Cpu Commitment
+	// Pids is in processes, against the pid_max of the host.
+	Pids Commitment
+	// Statuses counts the containers by status, stopped ones included.
+	Statuses map[Status]int
+}
@@ -0,0 +1,139 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall" // only for Signal
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/configs"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultStopTimeout is how long Stop waits for the init process to exit
+// after the stop signal if the config does not set a StopTimeout.
+const DefaultStopTimeout = 10 * time.Second
+
+// Causes recorded in the ExitStatus of a container terminated by Stop.
+const (
+	// StopSignaled is recorded if the init process exited after the stop
+	// signal.
+	StopSignaled = "StopSignaled"
+	// StopKilled is recorded if the processes of the container had to be
+	// killed after the stop timeout.
+	StopKilled = "StopKilled"
+)
+
+// Annotations of the OCI state describing how the container is stopped.
+const (
+	StopSignalAnnotation  = "org.opencontainers.runc.stop.signal"
+	StopTimeoutAnnotation = "org.opencontainers.runc.stop.timeout"
+)
+
+// stopSignal returns the signal config asks its init process to be stopped
+// with, SIGTERM by default.
+func stopSignal(config *configs.Config) syscall.Signal {
+	if config.StopSignal != 0 {
+		return syscall.Signal(config.StopSignal)
+	}
+	return unix.SIGTERM
+}
+
+func stopTimeout(config *configs.Config) time.Duration {
+	if config.StopTimeout > 0 {
+		return config.StopTimeout
+	}
+	return DefaultStopTimeout
+}
+
+// StopAnnotations returns the annotations describing the stop signal and
+// timeout of config in the OCI state, so that supervisors other than runc
+// stop the container the same way.
+func StopAnnotations(config *configs.Config) map[string]string {
+	return map[string]string{
+		StopSignalAnnotation:  strconv.Itoa(int(stopSignal(config))),
+		StopTimeoutAnnotation: stopTimeout(config).String(),
+	}
+}
+
+func (c *linuxContainer) Stop(ctx context.Context) error {
+	c.m.Lock()
+	status, err := c.currentStatus()
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+	if status == Stopped {
+		return nil
+	}
+	if status != StoppedWithStragglers {
+		c.setStopCause(StopSignaled)
+		if err := c.Signal(stopSignal(c.config), false); err != nil {
+			return err
+		}
+		wctx, cancel := context.WithTimeout(ctx, stopTimeout(c.config))
+		err := c.WaitStopped(wctx)
+		cancel()
+		switch {
+		case err == nil:
+			// Processes left behind by an init sharing our PID namespace
+			// are not worth recording that the init had to be killed.
+			return c.killStragglers()
+		case err != context.DeadlineExceeded:
+			return err
+		}
+		logrus.Infof("container %s did not stop within %s, killing it", c.id, stopTimeout(c.config))
+	}
+	c.setStopCause(StopKilled)
+	return c.Signal(unix.SIGKILL, true)
+}
+
+// killStragglers kills the processes left in the cgroups of the container
+// once its init process has exited.
+func (c *linuxContainer) killStragglers() error {
+	c.m.Lock()
+	status, err := c.currentStatus()
+	c.m.Unlock()
+	if err != nil || status != StoppedWithStragglers {
+		return err
+	}
+	return c.Signal(unix.SIGKILL, true)
+}
+
+// setStopCause records the cause of the exit of the init process Stop is
+// about to trigger, in the state of the container too, so that it is known
+// to whoever waits for the init process once this one has exited.
+func (c *linuxContainer) setStopCause(cause string) {
+	c.stopMu.Lock()
+	c.stopCause = cause
+	c.stopMu.Unlock()
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, err := os.Stat(filepath.Join(c.root, stateFilename)); err != nil {
+		return
+	}
+	state, err := c.currentState()
+	if err == nil {
+		err = c.saveState(state)
+	}
+	if err != nil {
+		logrus.Warnf("recording the stop cause of container %s: %v", c.id, err)
+	}
+}
+
+// exitCause returns the cause to record in the ExitStatus of the container,
+// if libcontainer terminated it.
+func (c *linuxContainer) exitCause() string {
+	if c.lifetime.wasExceeded() {
+		return LifetimeExceeded
+	}
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	return c.stopCause
+}
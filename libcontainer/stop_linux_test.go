@@ -0,0 +1,139 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+// startIgnoringTerm starts sleep ignoring SIGTERM, as inherited from the
+// shell which execs it, and returns it with its start time once SIGTERM is
+// ignored.
+func startIgnoringTerm(t *testing.T) (*exec.Cmd, uint64) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cmd := exec.Command("/bin/sh", "-c", "trap '' TERM; echo >&3; exec sleep 100 3>&-")
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The shell writes to the pipe once the trap is set up.
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatal(err)
+	}
+	stat, err := system.Stat(cmd.Process.Pid)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatal(err)
+	}
+	return cmd, stat.StartTime
+}
+
+func testStop(t *testing.T, config *configs.Config) *linuxContainer {
+	root, err := ioutil.TempDir("", "stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	cmd, started := startIgnoringTerm(t)
+	defer cmd.Process.Kill()
+	// Reap the process as soon as it exits, as a parent would.
+	go cmd.Wait()
+	c := &linuxContainer{
+		id:                   "stop",
+		root:                 root,
+		config:               config,
+		cgroupManager:        &mockCgroupManager{allPids: []int{cmd.Process.Pid}},
+		initProcess:          &nonChildProcess{processPid: cmd.Process.Pid, processStartTime: started},
+		initProcessStartTime: started,
+	}
+	c.state = &runningState{c: c}
+	state, err := c.currentState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.saveState(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The cause is recorded for those loading the container later on.
+	if state, err = (&LinuxFactory{}).loadState(root, c.id); err != nil {
+		t.Fatal(err)
+	}
+	if state.StopCause != c.exitCause() {
+		t.Fatalf("expected the stop cause %q in the state, got %q", c.exitCause(), state.StopCause)
+	}
+	return c
+}
+
+func TestStopSignal(t *testing.T) {
+	// sleep ignores SIGTERM but not SIGINT.
+	c := testStop(t, &configs.Config{StopSignal: 2})
+	if cause := c.exitCause(); cause != StopSignaled {
+		t.Fatalf("expected the container to stop on the stop signal, got %q", cause)
+	}
+}
+
+func TestStopKill(t *testing.T) {
+	c := testStop(t, &configs.Config{StopTimeout: 100 * time.Millisecond})
+	if cause := c.exitCause(); cause != StopKilled {
+		t.Fatalf("expected the container to be killed, got %q", cause)
+	}
+}
+
+func TestStopAnnotationsOnlyForPoststopHooks(t *testing.T) {
+	root, err := ioutil.TempDir("", "stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	var poststop configs.HookState
+	c := &linuxContainer{
+		id:   "stop",
+		root: root,
+		config: &configs.Config{Hooks: &configs.Hooks{Poststop: []configs.Hook{
+			configs.NewFunctionHook(func(s configs.HookState) error {
+				poststop = s
+				return nil
+			}),
+		}}},
+	}
+	if _, ok := c.newHookState("creating", 1).Annotations[StopSignalAnnotation]; ok {
+		t.Fatal("expected no stop annotations for the hooks but the poststop ones")
+	}
+	if err := runPoststopHooks(c); err != nil {
+		t.Fatal(err)
+	}
+	if poststop.Annotations[StopSignalAnnotation] != "15" {
+		t.Fatalf("expected the stop annotations for the poststop hooks, got %v", poststop.Annotations)
+	}
+}
+
+func TestStopAnnotations(t *testing.T) {
+	annotations := StopAnnotations(&configs.Config{})
+	if annotations[StopSignalAnnotation] != "15" || annotations[StopTimeoutAnnotation] != "10s" {
+		t.Fatalf("expected SIGTERM and the default timeout, got %v", annotations)
+	}
+	annotations = StopAnnotations(&configs.Config{StopSignal: 3, StopTimeout: time.Minute})
+	if annotations[StopSignalAnnotation] != "3" || annotations[StopTimeoutAnnotation] != "1m0s" {
+		t.Fatalf("expected SIGQUIT and a minute, got %v", annotations)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/opencontainers/runc/libcontainer/utils"
 )
 
@@ -16,6 +18,8 @@ type syncType string
 //
 // [  child  ] <-> [   parent   ]
 //
+// procVersion -->
+//
 // procHooks   --> [run hooks]
 //             <-- procResume
 //
@@ -26,22 +30,72 @@ type syncType string
 //
 // procReady   --> [final setup]
 //             <-- procRun
+//
+// procHostBinary -->
+//    [recv(fd)] <-- [send(fd)]
+//
+// procCgroups --> [enter cgroups]
+//             <-- procResume
 const (
 	procError  syncType = "procError"
 	procReady  syncType = "procReady"
 	procRun    syncType = "procRun"
 	procHooks  syncType = "procHooks"
 	procResume syncType = "procResume"
+
+	procHostBinary syncType = "procHostBinary"
+
+	// procCgroups is sent by a setns init with LateCgroups set, to be moved
+	// into the cgroups of the container before it executes the process.
+	procCgroups syncType = "procCgroups"
+
+	// procVersion is sent first by an init supporting protocol negotiation,
+	// if the parent offered it, along with the InitInfo of the init.
+	procVersion syncType = "procVersion"
 )
 
 type syncT struct {
 	Type syncType `json:"type"`
+	// Init is only set for procVersion.
+	Init *InitInfo `json:"init,omitempty"`
+}
+
+// syncTracer logs the synchronisation messages exchanged between the parent
+// and the child when enabled. It is meant for debugging hung container
+// starts without having to strace the runtime.
+type syncTracer struct {
+	enabled bool
+	pid     func() int
+	clock   clock
+}
+
+const (
+	syncSent     = "sent"
+	syncReceived = "received"
+)
+
+func (t *syncTracer) trace(direction string, sync syncType) {
+	if t == nil || !t.enabled {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"timestamp": t.clock.Now().UTC().Format(time.RFC3339Nano),
+		"direction": direction,
+		"type":      sync,
+		"pid":       t.pid(),
+	}).Info("init sync")
+}
+
+// writeSync writes sync to the pipe and traces it.
+func (t *syncTracer) writeSync(pipe io.Writer, sync syncType) error {
+	t.trace(syncSent, sync)
+	return writeSync(pipe, sync)
 }
 
 // writeSync is used to write to a synchronisation pipe. An error is returned
 // if there was a problem writing the payload.
 func writeSync(pipe io.Writer, sync syncType) error {
-	if err := utils.WriteJSON(pipe, syncT{sync}); err != nil {
+	if err := utils.WriteJSON(pipe, syncT{Type: sync}); err != nil {
 		return err
 	}
 	return nil
@@ -50,29 +104,36 @@ func writeSync(pipe io.Writer, sync syncType) error {
 // readSync is used to read from a synchronisation pipe. An error is returned
 // if we got a genericError, the pipe was closed, or we got an unexpected flag.
 func readSync(pipe io.Reader, expected syncType) error {
+	dec := json.NewDecoder(pipe)
 	var procSync syncT
-	if err := json.NewDecoder(pipe).Decode(&procSync); err != nil {
+	if err := dec.Decode(&procSync); err != nil {
 		if err == io.EOF {
 			return fmt.Errorf("parent closed synchronisation channel")
 		}
-
-		if procSync.Type == procError {
-			var ierr genericError
-
-			if err := json.NewDecoder(pipe).Decode(&ierr); err != nil {
-				return fmt.Errorf("failed reading error from parent: %v", err)
-			}
-
-			return &ierr
-		}
-
-		if procSync.Type != expected {
-			return fmt.Errorf("invalid synchronisation flag from parent")
+		return err
+	}
+	if procSync.Type == procError {
+		var ierr genericError
+		if err := dec.Decode(&ierr); err != nil {
+			return fmt.Errorf("failed reading error from parent: %v", err)
 		}
+		return &ierr
+	}
+	if procSync.Type != expected {
+		return fmt.Errorf("invalid synchronisation flag from parent")
 	}
 	return nil
 }
 
+// refuseSync answers a sync message of the child with err, so that the
+// child fails rather than waiting for a response which never comes.
+func (t *syncTracer) refuseSync(pipe io.Writer, err Error) error {
+	if werr := t.writeSync(pipe, procError); werr != nil {
+		return werr
+	}
+	return utils.WriteJSON(pipe, err)
+}
+
 // parseSync runs the given callback function on each syncT received from the
 // child. It will return once io.EOF is returned from the given pipe.
 func parseSync(pipe io.Reader, fn func(*syncT) error) error {
@@ -95,8 +156,7 @@ func parseSync(pipe io.Reader, fn func(*syncT) error) error {
 			if ierr != nil {
 				return ierr
 			}
-			// Programmer error.
-			panic("No error following JSON procError payload.")
+			return newGenericError(fmt.Errorf("no error following JSON procError payload"), SyncProtocolError)
 		}
 
 		if err := fn(&sync); err != nil {
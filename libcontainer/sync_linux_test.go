@@ -0,0 +1,62 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/utils"
+)
+
+func TestSetnsProcessRefusesInitSync(t *testing.T) {
+	h := newProcessHarness(t, procReady)
+	p := h.setnsProcess(nil)
+	err := p.start()
+	if lerr, ok := err.(Error); !ok || lerr.Code() != SyncProtocolError {
+		t.Fatalf("expected a SyncProtocolError, got %v", err)
+	}
+	h.wait()
+	if len(h.child.responses) != 1 || h.child.responses[0] != procError {
+		t.Fatalf("expected the child to be answered with procError, got %v", h.child.responses)
+	}
+}
+
+func TestReadSync(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSync(&buf, procRun); err != nil {
+		t.Fatal(err)
+	}
+	if err := readSync(&buf, procRun); err != nil {
+		t.Fatalf("expected procRun to be accepted: %v", err)
+	}
+
+	buf.Reset()
+	if err := writeSync(&buf, procResume); err != nil {
+		t.Fatal(err)
+	}
+	if err := readSync(&buf, procRun); err == nil {
+		t.Fatal("expected procResume to be rejected in place of procRun")
+	}
+
+	buf.Reset()
+	var tracer *syncTracer
+	if err := tracer.refuseSync(&buf, newGenericError(bytes.ErrTooLarge, SyncProtocolError)); err != nil {
+		t.Fatal(err)
+	}
+	err := readSync(&buf, procRun)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != SyncProtocolError {
+		t.Fatalf("expected the refusal to be returned, got %v", err)
+	}
+}
+
+func TestParseSyncWithoutError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := utils.WriteJSON(&buf, syncT{Type: procError}); err != nil {
+		t.Fatal(err)
+	}
+	err := parseSync(&buf, func(*syncT) error { return nil })
+	if lerr, ok := err.(Error); !ok || lerr.Code() != SyncProtocolError {
+		t.Fatalf("expected a SyncProtocolError, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/pkg/symlink"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/user"
+	"github.com/opencontainers/runc/libcontainer/utils"
+
+	"golang.org/x/sys/unix"
+)
+
+// newInitPipe creates the init pipe. The parent end has SO_PASSCRED set, so
+// that the kernel attaches the credentials of the sender to every message we
+// receive. SO_PEERCRED would not do, as it reports whoever created the pair.
+func newInitPipe() (parent *os.File, child *os.File, err error) {
+	return newCredSockPair("init")
+}
+
+// newCredSockPair creates a socket pair like utils.NewSockPair, with
+// SO_PASSCRED set on the parent end.
+func newCredSockPair(name string) (parent *os.File, child *os.File, err error) {
+	parent, child, err = utils.NewSockPair(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := unix.SetsockoptInt(int(parent.Fd()), unix.SOL_SOCKET, unix.SO_PASSCRED, 1); err != nil {
+		parent.Close()
+		child.Close()
+		return nil, nil, err
+	}
+	return parent, child, nil
+}
+
+// syncPeer describes the container process which is expected to send the
+// sync messages on the other end of the init pipe.
+type syncPeer struct {
+	pid  int
+	uids []int
+}
+
+// expectedSyncPeer returns the syncPeer for the container process pid. It
+// runs with our uid, or as the root of its user namespace once nsexec has
+// set it up, and as processUser, the User of the process, once it has
+// dropped its privileges: a failing exec is still reported after that.
+func expectedSyncPeer(pid int, config *configs.Config, processUser string) syncPeer {
+	peer := syncPeer{pid: pid, uids: []int{os.Geteuid()}}
+	if config == nil {
+		return peer
+	}
+	if config.Namespaces.Contains(configs.NEWUSER) {
+		if uid, err := config.HostRootUID(); err == nil {
+			peer.uids = peer.withUid(uid)
+		}
+	}
+	if uid, err := processHostUID(config, processUser); err == nil {
+		peer.uids = peer.withUid(uid)
+	}
+	return peer
+}
+
+func (peer syncPeer) withUid(uid int) []int {
+	for _, u := range peer.uids {
+		if u == uid {
+			return peer.uids
+		}
+	}
+	return append(peer.uids, uid)
+}
+
+// processHostUID returns the host uid the process runs as once it has
+// switched to processUser. Names are looked up in the passwd file of the
+// rootfs, as the container process will do; files which cannot be resolved
+// within the rootfs are treated as missing.
+func processHostUID(config *configs.Config, processUser string) (int, error) {
+	passwdPath, _ := symlink.FollowSymlinkInScope(filepath.Join(config.Rootfs, "/etc/passwd"), config.Rootfs)
+	groupPath, _ := symlink.FollowSymlinkInScope(filepath.Join(config.Rootfs, "/etc/group"), config.Rootfs)
+	execUser, err := user.GetExecUserPath(processUser, nil, passwdPath, groupPath)
+	if err != nil {
+		return -1, err
+	}
+	return config.HostUID(execUser.Uid)
+}
+
+// check returns an error unless the control messages received along with a
+// message carry the credentials of the peer.
+func (peer syncPeer) check(oob []byte) error {
+	scms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return err
+	}
+	var cred *unix.Ucred
+	for i := range scms {
+		if c, err := unix.ParseUnixCredentials(&scms[i]); err == nil {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return fmt.Errorf("sync message received without credentials")
+	}
+	if int(cred.Pid) != peer.pid {
+		return fmt.Errorf("sync message received from pid %d instead of the container process %d", cred.Pid, peer.pid)
+	}
+	for _, uid := range peer.uids {
+		if int(cred.Uid) == uid {
+			return nil
+		}
+	}
+	return fmt.Errorf("sync message received from uid %d instead of one of %v", cred.Uid, peer.uids)
+}
+
+// credReader reads the parent end of an init pipe created by newInitPipe,
+// checking that every message was sent by the peer. Once a message fails the
+// check, the pipe is not read any further.
+type credReader struct {
+	pipe *os.File
+	peer syncPeer
+	err  error
+}
+
+func (r *credReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	oob := make([]byte, unix.CmsgSpace(unix.SizeofUcred))
+	var (
+		n, oobn int
+		err     error
+	)
+	for {
+		n, oobn, _, _, err = unix.Recvmsg(int(r.pipe.Fd()), p, oob, 0)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	if err := r.peer.check(oob[:oobn]); err != nil {
+		r.err = newGenericError(err, SyncProtocolError)
+		return 0, r.err
+	}
+	return n, nil
+}
+
+// recvConsole receives the master of the console of the container process
+// from socket, the parent end of a pair created by newCredSockPair. It is
+// only accepted if it was sent by peer and is a pty master.
+func recvConsole(socket *os.File, peer syncPeer) (*os.File, error) {
+	name := make([]byte, utils.MaxNameLen)
+	oob := make([]byte, unix.CmsgSpace(4)+unix.CmsgSpace(unix.SizeofUcred))
+	n, oobn, flags, _, err := unix.Recvmsg(int(socket.Fd()), name, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	scms, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range scms {
+		if rights, err := unix.ParseUnixRights(&scms[i]); err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	if len(fds) != 1 || n >= utils.MaxNameLen || flags&unix.MSG_CTRUNC != 0 {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+		return nil, newGenericError(fmt.Errorf("invalid console message with %d fds", len(fds)), SyncProtocolError)
+	}
+	f := os.NewFile(uintptr(fds[0]), string(name[:n]))
+	if err := peer.check(oob[:oobn]); err != nil {
+		f.Close()
+		return nil, newGenericError(err, SyncProtocolError)
+	}
+	if err := CheckConsoleMaster(f); err != nil {
+		f.Close()
+		return nil, newGenericError(err, SyncProtocolError)
+	}
+	return f, nil
+}
+
+// peerChecker wraps the parent end of an init pipe so that the sync
+// messages read from it are checked to come from the peer.
+type peerChecker interface {
+	reader(pipe *os.File, peer syncPeer) io.Reader
+}
+
+type hostPeerChecker struct{}
+
+func (hostPeerChecker) reader(pipe *os.File, peer syncPeer) io.Reader {
+	return &credReader{pipe: pipe, peer: peer}
+}
@@ -0,0 +1,142 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/utils"
+)
+
+func TestCredReaderChecksPeer(t *testing.T) {
+	parent, child, err := newInitPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	defer child.Close()
+
+	if err := writeSync(child, procReady); err != nil {
+		t.Fatal(err)
+	}
+	r := &credReader{pipe: parent, peer: syncPeer{pid: os.Getpid(), uids: []int{os.Geteuid()}}}
+	buf := make([]byte, 64)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("expected a message from the peer to be accepted: %v", err)
+	}
+
+	if err := writeSync(child, procReady); err != nil {
+		t.Fatal(err)
+	}
+	r.peer.pid = os.Getpid() + 1
+	_, err = r.Read(buf)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != SyncProtocolError {
+		t.Fatalf("expected a SyncProtocolError for a message from another pid, got %v", err)
+	}
+	if _, rerr := r.Read(buf); rerr != err {
+		t.Fatalf("expected the pipe not to be read after a failed check, got %v", rerr)
+	}
+}
+
+func TestInitProcessRejectsSyncFromOtherProcess(t *testing.T) {
+	h := newProcessHarness(t, procReady)
+	p := h.initProcess(&configs.Config{})
+	// The fake child runs in our process rather than in the container one.
+	p.env.peers = hostPeerChecker{}
+	err := p.start()
+	if lerr, ok := err.(Error); !ok || lerr.Code() != SyncProtocolError {
+		t.Fatalf("expected a SyncProtocolError, got %v", err)
+	}
+	h.wait()
+}
+
+func TestSetnsProcessExpectsContainerProcess(t *testing.T) {
+	h := newProcessHarness(t)
+	p := h.setnsProcess(nil)
+	p.config.Config.Namespaces = configs.Namespaces{{Type: configs.NEWUSER}}
+	p.config.Config.UidMappings = []configs.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	h.wait()
+	peer := h.peers.peer
+	if peer == nil || peer.pid != h.spawner.containerPid {
+		t.Fatalf("expected the sync messages to be checked against pid %d, got %+v", h.spawner.containerPid, peer)
+	}
+	if len(peer.uids) != 2 || peer.uids[0] != os.Geteuid() || peer.uids[1] != 100000 {
+		t.Fatalf("expected our uid and the host root uid, got %v", peer.uids)
+	}
+}
+
+func TestSyncPeerAcceptsProcessUser(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "sync-peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The passwd file of the host must not be used to resolve names.
+	if err := os.Symlink("/etc/passwd", filepath.Join(rootfs, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	config := &configs.Config{
+		Rootfs:      rootfs,
+		Namespaces:  configs.Namespaces{{Type: configs.NEWUSER}},
+		UidMappings: []configs.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+	}
+	peer := expectedSyncPeer(1, config, "1000")
+	if len(peer.uids) != 3 || peer.uids[1] != 100000 || peer.uids[2] != 101000 {
+		t.Fatalf("expected the host uids of root and of the process user, got %v", peer.uids)
+	}
+	if peer := expectedSyncPeer(1, config, "root"); len(peer.uids) != 2 {
+		t.Fatalf("expected root to be resolved in the rootfs only, got %v", peer.uids)
+	}
+}
+
+func TestRecvConsole(t *testing.T) {
+	self := syncPeer{pid: os.Getpid(), uids: []int{os.Geteuid()}}
+	send := func(f *os.File, peer syncPeer) (*os.File, error) {
+		parent, child, err := newCredSockPair("console")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer parent.Close()
+		defer child.Close()
+		if err := utils.SendFd(child, f); err != nil {
+			t.Fatal(err)
+		}
+		return recvConsole(parent, peer)
+	}
+
+	regular, err := ioutil.TempFile("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(regular.Name())
+	defer regular.Close()
+	_, err = send(regular, self)
+	if lerr, ok := err.(Error); !ok || lerr.Code() != SyncProtocolError {
+		t.Fatalf("expected a SyncProtocolError for a regular file, got %v", err)
+	}
+
+	console, err := newConsole()
+	if err != nil {
+		t.Skipf("cannot create a pty: %v", err)
+	}
+	defer console.Close()
+	_, err = send(console.File(), syncPeer{pid: os.Getpid() + 1, uids: self.uids})
+	if lerr, ok := err.(Error); !ok || lerr.Code() != SyncProtocolError {
+		t.Fatalf("expected a SyncProtocolError for a console from another pid, got %v", err)
+	}
+	master, err := send(console.File(), self)
+	if err != nil {
+		t.Fatalf("expected the pty master to be accepted: %v", err)
+	}
+	master.Close()
+}
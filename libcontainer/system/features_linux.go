@@ -0,0 +1,33 @@
+// +build linux
+
+package system
+
+import (
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Features describes the optional kernel features which are probed for at
+// runtime rather than assumed from the kernel version.
+type Features struct {
+	// AmbientCapabilities is whether the kernel supports PR_CAP_AMBIENT,
+	// which was added in 4.3.
+	AmbientCapabilities bool
+}
+
+var (
+	featuresOnce sync.Once
+	features     Features
+)
+
+// GetFeatures probes the kernel for the optional features it supports. The
+// probe only runs once, its result being cached for the life of the process.
+func GetFeatures() Features {
+	featuresOnce.Do(func() {
+		// Kernels without ambient capabilities reject the option with EINVAL.
+		err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_IS_SET, 0, 0, 0)
+		features.AmbientCapabilities = err != unix.EINVAL
+	})
+	return features
+}
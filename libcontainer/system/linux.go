@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"syscall" // only for exec
+	"syscall" // only for exec and Signal
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -55,6 +55,29 @@ func Execv(cmd string, args []string, env []string) error {
 	return syscall.Exec(name, args, env)
 }
 
+// AT_EMPTY_PATH makes execveat(2) execute the file its descriptor refers to.
+// It is not yet exposed by x/sys/unix.
+const AT_EMPTY_PATH = 0x1000
+
+// Fexecve executes the file referred to by fd, which may have been opened
+// with O_PATH, in place of the current process.
+func Fexecve(fd uintptr, args []string, env []string) error {
+	path, err := unix.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	argv, err := syscall.SlicePtrFromStrings(args)
+	if err != nil {
+		return err
+	}
+	envv, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		return err
+	}
+	_, _, e := unix.Syscall6(unix.SYS_EXECVEAT, fd, uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])), AT_EMPTY_PATH, 0)
+	return e
+}
+
 func Prlimit(pid, resource int, limit unix.Rlimit) error {
 	_, _, err := unix.RawSyscall6(unix.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&limit)), uintptr(unsafe.Pointer(&limit)), 0, 0)
 	if err != 0 {
@@ -63,6 +86,16 @@ func Prlimit(pid, resource int, limit unix.Rlimit) error {
 	return nil
 }
 
+// GetPrlimit returns the resource limit of the process pid.
+func GetPrlimit(pid, resource int) (unix.Rlimit, error) {
+	var limit unix.Rlimit
+	_, _, err := unix.RawSyscall6(unix.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), 0, uintptr(unsafe.Pointer(&limit)), 0, 0)
+	if err != 0 {
+		return limit, err
+	}
+	return limit, nil
+}
+
 func SetParentDeathSignal(sig uintptr) error {
 	if err := unix.Prctl(unix.PR_SET_PDEATHSIG, sig, 0, 0, 0); err != nil {
 		return err
@@ -94,6 +127,13 @@ func ClearKeepCaps() error {
 	return nil
 }
 
+// SetName sets the name of the calling thread, as shown in the comm field of
+// /proc/[pid]/stat. It is truncated to 15 bytes.
+func SetName(name string) error {
+	b := append([]byte(name), 0)
+	return unix.Prctl(unix.PR_SET_NAME, uintptr(unsafe.Pointer(&b[0])), 0, 0, 0)
+}
+
 func Setctty() error {
 	if err := unix.IoctlSetInt(0, unix.TIOCSCTTY, 0); err != nil {
 		return err
@@ -134,3 +174,146 @@ func RunningInUserNS() bool {
 func SetSubreaper(i int) error {
 	return unix.Prctl(PR_SET_CHILD_SUBREAPER, uintptr(i), 0, 0, 0)
 }
+
+// SYS_PIDFD_OPEN is the pidfd_open(2) syscall number. It is shared by all
+// architectures since Linux 5.3, but is not yet exposed by x/sys/unix.
+const SYS_PIDFD_OPEN = 434
+
+// PidfdOpen returns a file descriptor referring to the process pid. The
+// descriptor becomes readable once the process has exited, whether or not
+// the calling process is its parent. ENOSYS is returned on kernels which do
+// not support pidfds.
+func PidfdOpen(pid int) (int, error) {
+	fd, _, err := unix.Syscall(SYS_PIDFD_OPEN, uintptr(pid), 0, 0)
+	if err != 0 {
+		return -1, err
+	}
+	return int(fd), nil
+}
+
+// SYS_PIDFD_SEND_SIGNAL is the pidfd_send_signal(2) syscall number, shared by
+// all architectures since Linux 5.1.
+const SYS_PIDFD_SEND_SIGNAL = 424
+
+// PidfdSendSignal sends sig to the process referred to by the pidfd fd,
+// which unlike kill(2) cannot hit another process reusing its pid.
+func PidfdSendSignal(fd int, sig syscall.Signal) error {
+	_, _, err := unix.Syscall6(SYS_PIDFD_SEND_SIGNAL, uintptr(fd), uintptr(sig), 0, 0, 0, 0)
+	if err != 0 {
+		return err
+	}
+	return nil
+}
+
+// Scheduling policies of sched_setscheduler(2).
+const (
+	SCHED_OTHER = 0
+	SCHED_IDLE  = 5
+)
+
+// schedParam is the struct sched_param of sched_setscheduler(2).
+type schedParam struct {
+	priority int32
+}
+
+// SetScheduler sets the scheduling policy of the thread tid, or of the
+// calling thread if tid is 0, with a static priority of 0 as the policies
+// which are not real-time require.
+func SetScheduler(tid int, policy int) error {
+	return SetSchedulerPriority(tid, policy, 0)
+}
+
+// SetSchedulerPriority sets the scheduling policy and the static priority of
+// the thread tid, or of the calling thread if tid is 0.
+func SetSchedulerPriority(tid int, policy int, priority int) error {
+	param := schedParam{priority: int32(priority)}
+	_, _, err := unix.RawSyscall(unix.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(policy), uintptr(unsafe.Pointer(&param)))
+	if err != 0 {
+		return err
+	}
+	return nil
+}
+
+// GetScheduler returns the scheduling policy, including its
+// SCHED_RESET_ON_FORK flag, and the static priority of the thread tid, or of
+// the calling thread if tid is 0.
+func GetScheduler(tid int) (policy int, priority int, err error) {
+	r, _, errno := unix.RawSyscall(unix.SYS_SCHED_GETSCHEDULER, uintptr(tid), 0, 0)
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	var param schedParam
+	if _, _, errno := unix.RawSyscall(unix.SYS_SCHED_GETPARAM, uintptr(tid), uintptr(unsafe.Pointer(&param)), 0); errno != 0 {
+		return 0, 0, errno
+	}
+	return int(r), int(param.priority), nil
+}
+
+// memfd_create(2) flags and file sealing fcntl(2) commands, which are not yet
+// exposed by x/sys/unix.
+const (
+	MFD_CLOEXEC       = 0x1
+	MFD_ALLOW_SEALING = 0x2
+
+	F_ADD_SEALS = 1033
+	F_GET_SEALS = 1034
+
+	F_SEAL_SEAL   = 0x1
+	F_SEAL_SHRINK = 0x2
+	F_SEAL_GROW   = 0x4
+	F_SEAL_WRITE  = 0x8
+)
+
+// MemfdCreate creates an anonymous file with the given name, which is only
+// used for debugging, and returns a file descriptor referring to it.
+func MemfdCreate(name string, flags int) (int, error) {
+	p, err := unix.BytePtrFromString(name)
+	if err != nil {
+		return -1, err
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_MEMFD_CREATE, uintptr(unsafe.Pointer(p)), uintptr(flags), 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// AddSeals adds the given seals to the memfd fd.
+func AddSeals(fd uintptr, seals int) error {
+	if _, _, errno := unix.Syscall(unix.SYS_FCNTL, fd, F_ADD_SEALS, uintptr(seals)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// GetSeals returns the seals of the memfd fd.
+func GetSeals(fd uintptr) (int, error) {
+	seals, _, errno := unix.Syscall(unix.SYS_FCNTL, fd, F_GET_SEALS, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(seals), nil
+}
+
+// RENAME_NOREPLACE makes renameat2(2) fail with EEXIST instead of replacing
+// an existing target.
+const RENAME_NOREPLACE = 0x1
+
+// RenameNoReplace renames oldpath to newpath unless newpath already exists,
+// atomically. EINVAL is returned by filesystems which do not support it.
+func RenameNoReplace(oldpath, newpath string) error {
+	oldp, err := unix.BytePtrFromString(oldpath)
+	if err != nil {
+		return err
+	}
+	newp, err := unix.BytePtrFromString(newpath)
+	if err != nil {
+		return err
+	}
+	dirfd := unix.AT_FDCWD
+	_, _, errno := unix.Syscall6(unix.SYS_RENAMEAT2, uintptr(dirfd), uintptr(unsafe.Pointer(oldp)), uintptr(dirfd), uintptr(unsafe.Pointer(newp)), RENAME_NOREPLACE, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
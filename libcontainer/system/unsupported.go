@@ -7,3 +7,14 @@ package system
 func RunningInUserNS() bool {
 	return false
 }
+
+// Features is a stub for non-Linux systems.
+type Features struct {
+	AmbientCapabilities bool
+}
+
+// GetFeatures is a stub for non-Linux systems
+// Always returns no features
+func GetFeatures() Features {
+	return Features{}
+}
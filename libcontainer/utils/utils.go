@@ -59,6 +59,42 @@ func WriteJSON(w io.Writer, v interface{}) error {
 	return err
 }
 
+// AtomicWriteFile writes data to the file at path so that readers either see
+// its previous content or all of data, even after a crash. The data is
+// written to a temporary file which is renamed over path, and both the file
+// and its directory are synced.
+func AtomicWriteFile(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 // CleanPath makes a path safe for use with filepath.Join. This is done by not
 // only cleaning the path, but also (if the path is relative) adding a leading
 // '/' and cleaning it (then removing the leading '/'). This ensures that a
@@ -0,0 +1,73 @@
+package libcontainer
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// syncProtocolVersion is the version of the synchronisation protocol spoken
+// between the parent and the container init. It is bumped whenever a sync
+// message is added or changes meaning.
+const syncProtocolVersion = 1
+
+// minSyncProtocolVersion is the oldest protocol version of a container init
+// which is still fully compatible. Starting a container with an older init
+// records a warning in its InitInfo.
+const minSyncProtocolVersion = 1
+
+// BuildVersion and BuildCommit describe the build of the binary embedding
+// libcontainer. They are set by the program, e.g. from its -ldflags, and
+// reported by the container init to the parent.
+var (
+	BuildVersion string
+	BuildCommit  string
+)
+
+// Annotations of the OCI state describing the init of a container.
+const (
+	InitProtocolAnnotation = "org.opencontainers.runc.init.protocol"
+	InitVersionAnnotation  = "org.opencontainers.runc.init.version"
+	InitCommitAnnotation   = "org.opencontainers.runc.init.commit"
+)
+
+// InitInfo describes the init binary which set up a container, as it
+// reported itself to the parent.
+type InitInfo struct {
+	// ProtocolVersion is the synchronisation protocol version negotiated
+	// with the init, or zero if the init predates the negotiation.
+	ProtocolVersion int `json:"protocol_version"`
+
+	// Version and Commit describe the build of the init binary.
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+
+	// Warning explains how the init is not compatible with the parent which
+	// started the container, if it is not.
+	Warning string `json:"warning,omitempty"`
+}
+
+// Annotations returns the annotations describing the init in the OCI state.
+func (i *InitInfo) Annotations() map[string]string {
+	annotations := map[string]string{
+		InitProtocolAnnotation: strconv.Itoa(i.ProtocolVersion),
+	}
+	if i.Version != "" {
+		annotations[InitVersionAnnotation] = i.Version
+	}
+	if i.Commit != "" {
+		annotations[InitCommitAnnotation] = i.Commit
+	}
+	return annotations
+}
+
+// checkInitInfo returns the InitInfo an init reported, which is nil if it
+// predates the negotiation, with a Warning if the init is too old.
+func checkInitInfo(info *InitInfo) *InitInfo {
+	if info == nil {
+		return &InitInfo{Warning: "the container init predates protocol version negotiation"}
+	}
+	if info.ProtocolVersion < minSyncProtocolVersion {
+		info.Warning = fmt.Sprintf("the container init speaks protocol version %d, older than the oldest compatible version %d", info.ProtocolVersion, minSyncProtocolVersion)
+	}
+	return info
+}
@@ -0,0 +1,261 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/system"
+
+	"golang.org/x/sys/unix"
+)
+
+// stopPollInterval is how often the init process is checked when neither
+// pidfds nor cgroup.events notifications are available.
+const stopPollInterval = time.Second
+
+// cgroupEmptyPollInterval is how often the processes of a cgroup are listed
+// while waiting for it to become empty without cgroup.events notifications.
+const cgroupEmptyPollInterval = 100 * time.Millisecond
+
+// stopNotifier releases every WaitStopped caller once the init process it
+// was created for has exited.
+type stopNotifier struct {
+	pid       int
+	startTime uint64
+	once      sync.Once
+	done      chan struct{}
+}
+
+func (n *stopNotifier) release() {
+	n.once.Do(func() { close(n.done) })
+}
+
+func (c *linuxContainer) WaitStopped(ctx context.Context) error {
+	c.m.Lock()
+	status, err := c.currentStatus()
+	if err != nil {
+		c.m.Unlock()
+		return err
+	}
+	if status == Stopped || status == StoppedWithStragglers {
+		c.m.Unlock()
+		return nil
+	}
+	startTime, err := c.initProcess.startTime()
+	if err != nil {
+		c.m.Unlock()
+		return newSystemErrorWithCause(err, "getting init process start time")
+	}
+	n := c.stopNotifierFor(c.initProcess.pid(), startTime)
+	c.m.Unlock()
+
+	select {
+	case <-n.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.refreshState()
+}
+
+// stopNotifierFor returns the notifier for the given init process, starting
+// a watcher for it if this is the first waiter.
+func (c *linuxContainer) stopNotifierFor(pid int, startTime uint64) *stopNotifier {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	if n := c.stopNotify; n != nil && n.pid == pid && n.startTime == startTime {
+		return n
+	}
+	n := &stopNotifier{
+		pid:       pid,
+		startTime: startTime,
+		done:      make(chan struct{}),
+	}
+	c.stopNotify = n
+	go c.watchStop(n)
+	return n
+}
+
+// notifyStopped is called once an init process we own has been reaped so
+// that waiters are released without depending on the watcher.
+func (c *linuxContainer) notifyStopped(pid int) {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	if n := c.stopNotify; n != nil && n.pid == pid {
+		n.release()
+	}
+}
+
+// watchStop blocks until the process tracked by n has exited and then
+// releases its waiters. A pidfd is preferred, then the cgroup v2
+// cgroup.events "populated" flag, and polling is only used as a last resort.
+func (c *linuxContainer) watchStop(n *stopNotifier) {
+	defer n.release()
+	err := waitPidfd(n.pid, n.startTime)
+	if err == nil {
+		return
+	}
+	logrus.Debugf("cannot wait on pidfd for pid %d: %v", n.pid, err)
+	if path := cgroupEventsPath(c.cgroupManager.GetPaths()); path != "" {
+		err := waitUnpopulated(path)
+		if err == nil {
+			return
+		}
+		logrus.Debugf("cannot watch %s: %v", path, err)
+	}
+	for processAlive(n.pid, n.startTime) {
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// waitCgroupEmpty blocks until no process is left in the cgroups of m.
+func waitCgroupEmpty(m cgroups.Manager) error {
+	if path := cgroupEventsPath(m.GetPaths()); path != "" {
+		err := waitUnpopulated(path)
+		if err == nil {
+			return nil
+		}
+		logrus.Debugf("cannot watch %s: %v", path, err)
+	}
+	for {
+		pids, err := m.GetAllPids()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		time.Sleep(cgroupEmptyPollInterval)
+	}
+}
+
+// processAlive returns true if pid still refers to the process started at
+// startTime and it has not yet exited.
+func processAlive(pid int, startTime uint64) bool {
+	stat, err := system.Stat(pid)
+	if err != nil {
+		return false
+	}
+	return stat.StartTime == startTime && stat.State != system.Zombie && stat.State != system.Dead
+}
+
+// waitPidfd blocks until the process has exited using a pidfd.
+func waitPidfd(pid int, startTime uint64) error {
+	fd, err := system.PidfdOpen(pid)
+	if err != nil {
+		if err == unix.ESRCH {
+			return nil
+		}
+		return err
+	}
+	defer unix.Close(fd)
+	// The pid may have been recycled before we managed to open it.
+	if !processAlive(pid, startTime) {
+		return nil
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// cgroupEventsPath returns the path of the cgroup.events file of the first
+// cgroup v2 path in paths, or "" if there is none.
+func cgroupEventsPath(paths map[string]string) string {
+	for _, p := range paths {
+		path := filepath.Join(p, "cgroup.events")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// waitUnpopulated blocks until the cgroup.events file at path reports that
+// the cgroup no longer contains any processes, or the cgroup is removed.
+func waitUnpopulated(path string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if _, err := unix.InotifyAddWatch(fd, path, unix.IN_MODIFY); err != nil {
+		return err
+	}
+	buf := make([]byte, unix.SizeofInotifyEvent+unix.NAME_MAX+1)
+	for {
+		populated, err := cgroupPopulated(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !populated {
+			return nil
+		}
+		if _, err := unix.Read(fd, buf); err != nil && err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+// cgroupPopulated parses the "populated" key of a cgroup.events file.
+func cgroupPopulated(path string) (bool, error) {
+	events, err := readCgroupEvents(path)
+	return events.populated, err
+}
+
+// cgroupEvents holds the keys of a cgroup.events file.
+type cgroupEvents struct {
+	populated bool
+	frozen    bool
+}
+
+// readCgroupEvents parses the cgroup.events file at path. The "frozen" key
+// is only there on kernels supporting the cgroup v2 freezer.
+func readCgroupEvents(path string) (cgroupEvents, error) {
+	var events cgroupEvents
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return events, err
+	}
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "populated":
+			events.populated = fields[1] != "0"
+			found = true
+		case "frozen":
+			events.frozen = fields[1] != "0"
+		}
+	}
+	if !found {
+		return events, fmt.Errorf("no populated key in %s", path)
+	}
+	return events, nil
+}
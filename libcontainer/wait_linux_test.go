@@ -0,0 +1,77 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitUnpopulated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testwaitunpopulated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	eventsPath := filepath.Join(dir, "cgroup.events")
+	if err := ioutil.WriteFile(eventsPath, []byte("populated 1\nfrozen 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if path := cgroupEventsPath(map[string]string{"": dir}); path != eventsPath {
+		t.Fatalf("expected cgroup.events path %q, got %q", eventsPath, path)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitUnpopulated(eventsPath)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("waitUnpopulated returned early: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Update the file in place, as the kernel does, rather than truncating it
+	// first and racing with the watcher reading it.
+	f, err := os.OpenFile(eventsPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte("populated 0"), 0)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitUnpopulated did not return once the cgroup was unpopulated")
+	}
+}
+
+func TestStopNotifierReleasesAllWaiters(t *testing.T) {
+	n := &stopNotifier{done: make(chan struct{})}
+	const waiters = 5
+	released := make(chan struct{}, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			<-n.done
+			released <- struct{}{}
+		}()
+	}
+	n.release()
+	n.release()
+	for i := 0; i < waiters; i++ {
+		select {
+		case <-released:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d waiters were released", i, waiters)
+		}
+	}
+}
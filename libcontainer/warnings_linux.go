@@ -0,0 +1,46 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// maxWarnings bounds how many warnings a container keeps, as updating it
+// repeatedly may warn every time. The oldest ones are dropped.
+const maxWarnings = 64
+
+func (c *linuxContainer) Warnings() []configs.Warning {
+	c.warningsMu.Lock()
+	defer c.warningsMu.Unlock()
+	return append([]configs.Warning(nil), c.warnings...)
+}
+
+// warn logs w, records it for Warnings and reports it with a
+// ContainerWarning event.
+func (c *linuxContainer) warn(w configs.Warning) {
+	fields := logrus.Fields{"id": c.id, "code": w.Code}
+	if w.FieldPath != "" {
+		fields["field"] = w.FieldPath
+	}
+	logrus.WithFields(fields).Warn(w.Message)
+	c.warningsMu.Lock()
+	c.warnings = append(c.warnings, w)
+	if n := len(c.warnings) - maxWarnings; n > 0 {
+		c.warnings = append([]configs.Warning(nil), c.warnings[n:]...)
+	}
+	c.warningsMu.Unlock()
+	sendFactoryEvent(c.events, FactoryEvent{Type: ContainerWarning, ID: c.id, Warning: &w})
+}
+
+// warnCgroups records the warnings of the cgroup manager of the container,
+// if it reports any.
+func (c *linuxContainer) warnCgroups() {
+	if m, ok := c.cgroupManager.(cgroups.Warner); ok {
+		for _, w := range m.TakeWarnings() {
+			c.warn(w)
+		}
+	}
+}
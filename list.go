@@ -42,6 +42,8 @@ type containerState struct {
 	Annotations map[string]string `json:"annotations,omitempty"`
 	// The owner of the state directory (the owner of the container).
 	Owner string `json:"owner"`
+	// OOMKilled is set once the OOM killer killed the init process.
+	OOMKilled bool `json:"oomKilled,omitempty"`
 }
 
 var listCommand = cli.Command{
@@ -154,10 +156,18 @@ func getContainers(context *cli.Context) ([]containerState, error) {
 				continue
 			}
 			pid := state.BaseState.InitProcessPid
-			if containerStatus == libcontainer.Stopped {
+			if containerStatus == libcontainer.Stopped || containerStatus == libcontainer.StoppedWithStragglers {
 				pid = 0
 			}
 			bundle, annotations := utils.Annotations(state.Config.Labels)
+			for k, v := range state.HookAnnotations {
+				annotations[k] = v
+			}
+			if state.Init != nil {
+				for k, v := range state.Init.Annotations() {
+					annotations[k] = v
+				}
+			}
 			s = append(s, containerState{
 				Version:        state.BaseState.Config.Version,
 				ID:             state.BaseState.ID,
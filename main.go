@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/urfave/cli"
 )
@@ -47,6 +48,9 @@ value for "bundle" is the current directory.`
 )
 
 func main() {
+	libcontainer.BuildVersion = version
+	libcontainer.BuildCommit = gitCommit
+
 	app := cli.NewApp()
 	app.Name = "runc"
 	app.Usage = usage
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -82,6 +83,11 @@ using the runc checkpoint command.`,
 			Name:  "empty-ns",
 			Usage: "create a namespace, but don't restore its properties",
 		},
+		cli.StringFlag{
+			Name:  "network-remap",
+			Value: "",
+			Usage: `JSON object mapping the dumped interfaces to where they are restored, e.g. {"eth0": {"netns": "/run/netns/pod", "peer": "veth1"}}`,
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, exactArgs); err != nil {
@@ -97,6 +103,11 @@ using the runc checkpoint command.`,
 			return err
 		}
 		options := criuOptions(context)
+		if remap := context.String("network-remap"); remap != "" {
+			if err := json.Unmarshal([]byte(remap), &options.NetworkRemap); err != nil {
+				return fmt.Errorf("invalid network remap: %v", err)
+			}
+		}
 		status, err := startContainer(context, spec, CT_ACT_RESTORE, options)
 		if err != nil {
 			return err
@@ -123,5 +134,6 @@ func criuOptions(context *cli.Context) *libcontainer.CriuOpts {
 		ShellJob:                context.Bool("shell-job"),
 		FileLocks:               context.Bool("file-locks"),
 		PreDump:                 context.Bool("pre-dump"),
+		FreezeTimeout:           context.Duration("freeze-timeout"),
 	}
 }
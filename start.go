@@ -32,7 +32,7 @@ your host.`,
 		switch status {
 		case libcontainer.Created:
 			return container.Exec()
-		case libcontainer.Stopped:
+		case libcontainer.Stopped, libcontainer.StoppedWithStragglers:
 			return errors.New("cannot start a container that has stopped")
 		case libcontainer.Running:
 			return errors.New("cannot start an already running container")
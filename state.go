@@ -36,10 +36,21 @@ instance of a container.`,
 			return err
 		}
 		pid := state.BaseState.InitProcessPid
-		if containerStatus == libcontainer.Stopped {
+		if containerStatus == libcontainer.Stopped || containerStatus == libcontainer.StoppedWithStragglers {
 			pid = 0
 		}
 		bundle, annotations := utils.Annotations(state.Config.Labels)
+		for k, v := range libcontainer.StopAnnotations(&state.Config) {
+			annotations[k] = v
+		}
+		for k, v := range state.HookAnnotations {
+			annotations[k] = v
+		}
+		if state.Init != nil {
+			for k, v := range state.Init.Annotations() {
+				annotations[k] = v
+			}
+		}
 		cs := containerState{
 			Version:        state.BaseState.Config.Version,
 			ID:             state.BaseState.ID,
@@ -49,6 +60,7 @@ instance of a container.`,
 			Rootfs:         state.BaseState.Config.Rootfs,
 			Created:        state.BaseState.Created,
 			Annotations:    annotations,
+			OOMKilled:      state.Exit != nil && state.Exit.OOMKilled,
 		}
 		data, err := json.MarshalIndent(cs, "", "  ")
 		if err != nil {
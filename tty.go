@@ -74,6 +74,10 @@ func (t *tty) recvtty(process *libcontainer.Process, socket *os.File) error {
 	if err != nil {
 		return err
 	}
+	if err = libcontainer.CheckConsoleMaster(f); err != nil {
+		f.Close()
+		return err
+	}
 	if err = libcontainer.SaneTerminal(f); err != nil {
 		return err
 	}
@@ -133,5 +137,5 @@ func (t *tty) resize() error {
 	if err != nil {
 		return err
 	}
-	return term.SetWinsize(t.console.File().Fd(), ws)
+	return t.console.Resize(ws.Width, ws.Height)
 }
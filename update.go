@@ -5,10 +5,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 
 	"github.com/docker/go-units"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/urfave/cli"
 )
@@ -16,6 +19,7 @@ import (
 func i64Ptr(i int64) *int64   { return &i }
 func u64Ptr(i uint64) *uint64 { return &i }
 func u16Ptr(i uint16) *uint16 { return &i }
+func u32Ptr(i uint32) *uint32 { return &i }
 
 var updateCommand = cli.Command{
 	Name:      "update",
@@ -35,7 +39,8 @@ The accepted format is as follow (unchanged values can be omitted):
     "reservation": 0,
     "swap": 0,
     "kernel": 0,
-    "kernelTCP": 0
+    "kernelTCP": 0,
+    "percent": 0
   },
   "cpu": {
     "shares": 0,
@@ -44,13 +49,30 @@ The accepted format is as follow (unchanged values can be omitted):
     "realtimeRuntime": 0,
     "realtimePeriod": 0,
     "cpus": "",
-    "mems": ""
+    "mems": "",
+    "percent": 0,
+    "idle": 0,
+    "schedIdle": false
   },
   "blockIO": {
     "weight": 0
+  },
+  "network": {
+    "classID": 0,
+    "priorities": [
+      {
+        "name": "",
+        "priority": 0
+      }
+    ]
   }
 }
 
+The memory "percent" is relative to the memory of the host and the cpu
+"percent" to its online CPUs; they exclude "limit" and "quota" respectively.
+The cpu "idle" sets cpu.idle, which only exists on cgroup v2, and "schedIdle"
+moves the processes of the container to the SCHED_IDLE scheduling policy.
+
 Note: if data is to be read from a file or the standard input, all
 other options are ignored.
 `,
@@ -60,6 +82,14 @@ other options are ignored.
 			Name:  "blkio-weight",
 			Usage: "Specifies per cgroup weight, range is from 10 to 1000",
 		},
+		cli.StringFlag{
+			Name:  "cpu-idle",
+			Usage: "Mark the cgroup as idle (1) or not (0), on cgroup v2 only",
+		},
+		cli.StringFlag{
+			Name:  "cpu-percent",
+			Usage: "CPU hardcap limit as a percentage of the online CPUs of the host",
+		},
 		cli.StringFlag{
 			Name:  "cpu-period",
 			Usage: "CPU CFS period to be used for hardcapping (in usecs). 0 to use system default",
@@ -100,6 +130,10 @@ other options are ignored.
 			Name:  "memory",
 			Usage: "Memory limit (in bytes)",
 		},
+		cli.StringFlag{
+			Name:  "memory-percent",
+			Usage: "Memory limit as a percentage of the memory of the host",
+		},
 		cli.StringFlag{
 			Name:  "memory-reservation",
 			Usage: "Memory reservation or soft_limit (in bytes)",
@@ -108,6 +142,14 @@ other options are ignored.
 			Name:  "memory-swap",
 			Usage: "Total memory usage (memory + swap); set '-1' to enable unlimited swap",
 		},
+		cli.StringFlag{
+			Name:  "net-cls-classid",
+			Usage: "Class identifier tagged on the network packets of the container",
+		},
+		cli.StringFlag{
+			Name:  "sched-idle",
+			Usage: "Move the processes of the container to SCHED_IDLE (true) or back (false)",
+		},
 		cli.IntFlag{
 			Name:  "pids-limit",
 			Usage: "Maximum number of pids allowed in the container",
@@ -145,7 +187,25 @@ other options are ignored.
 			Pids: &specs.LinuxPids{
 				Limit: 0,
 			},
+			Network: &specs.LinuxNetwork{},
+		}
+
+		// The settings which are not part of the runtime spec.
+		var extensions struct {
+			Memory *struct {
+				Percent float64 `json:"percent"`
+			} `json:"memory"`
+			CPU *struct {
+				Percent   float64 `json:"percent"`
+				Idle      *int64  `json:"idle"`
+				SchedIdle *bool   `json:"schedIdle"`
+			} `json:"cpu"`
 		}
+		var (
+			memoryPercent, cpuPercent float64
+			cpuIdle                   *int64
+			schedIdle                 *bool
+		)
 
 		config := container.Config()
 
@@ -163,10 +223,24 @@ other options are ignored.
 					return err
 				}
 			}
-			err = json.NewDecoder(f).Decode(&r)
+			data, err := ioutil.ReadAll(f)
 			if err != nil {
 				return err
 			}
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &extensions); err != nil {
+				return err
+			}
+			if extensions.Memory != nil {
+				memoryPercent = extensions.Memory.Percent
+			}
+			if extensions.CPU != nil {
+				cpuPercent = extensions.CPU.Percent
+				cpuIdle = extensions.CPU.Idle
+				schedIdle = extensions.CPU.SchedIdle
+			}
 		} else {
 			if val := context.Int("blkio-weight"); val != 0 {
 				r.BlockIO.Weight = u16Ptr(uint16(val))
@@ -235,7 +309,43 @@ other options are ignored.
 					*pair.dest = v
 				}
 			}
+			for _, pair := range []struct {
+				opt  string
+				dest *float64
+			}{
+				{"memory-percent", &memoryPercent},
+				{"cpu-percent", &cpuPercent},
+			} {
+				if val := context.String(pair.opt); val != "" {
+					var err error
+					*pair.dest, err = strconv.ParseFloat(val, 64)
+					if err != nil {
+						return fmt.Errorf("invalid value for %s: %s", pair.opt, err)
+					}
+				}
+			}
+			if val := context.String("cpu-idle"); val != "" {
+				idle, err := strconv.ParseInt(val, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid value for cpu-idle: %s", err)
+				}
+				cpuIdle = &idle
+			}
+			if val := context.String("sched-idle"); val != "" {
+				idle, err := strconv.ParseBool(val)
+				if err != nil {
+					return fmt.Errorf("invalid value for sched-idle: %s", err)
+				}
+				schedIdle = &idle
+			}
 			r.Pids.Limit = int64(context.Int("pids-limit"))
+			if val := context.String("net-cls-classid"); val != "" {
+				classid, err := strconv.ParseUint(val, 0, 32)
+				if err != nil {
+					return fmt.Errorf("invalid value for net-cls-classid: %s", err)
+				}
+				r.Network.ClassID = u32Ptr(uint32(classid))
+			}
 		}
 
 		// Update the value
@@ -253,7 +363,59 @@ other options are ignored.
 		config.Cgroups.Resources.MemoryReservation = *r.Memory.Reservation
 		config.Cgroups.Resources.MemorySwap = *r.Memory.Swap
 		config.Cgroups.Resources.PidsLimit = r.Pids.Limit
+		// A limit set absolutely replaces one set as a percentage, and the
+		// other way around.
+		for _, limit := range []struct {
+			name     string
+			percent  float64
+			absolute *int64
+			dest     *float64
+		}{
+			{"memory", memoryPercent, &config.Cgroups.Resources.Memory, &config.Cgroups.Resources.MemoryPercent},
+			{"cpu", cpuPercent, &config.Cgroups.Resources.CpuQuota, &config.Cgroups.Resources.CpuPercent},
+		} {
+			if limit.percent < 0 || limit.percent > 100 {
+				return fmt.Errorf("%s percentage %g is not between 0 and 100", limit.name, limit.percent)
+			}
+			switch {
+			case limit.percent != 0 && *limit.absolute != 0:
+				return fmt.Errorf("%s limit cannot be set both as an absolute value and as a percentage", limit.name)
+			case limit.percent != 0:
+				*limit.dest = limit.percent
+			case *limit.absolute != 0:
+				*limit.dest = 0
+			}
+		}
+		if cpuIdle != nil {
+			if *cpuIdle != 0 && *cpuIdle != 1 {
+				return fmt.Errorf("cpu idle %d is neither 0 nor 1", *cpuIdle)
+			}
+			if !cgroups.IsCgroup2UnifiedMode() {
+				return fmt.Errorf("cpu idle is only supported on cgroup v2")
+			}
+			config.Cgroups.Resources.CPUIdle = cpuIdle
+		}
+		if r.Network != nil {
+			if r.Network.ClassID != nil {
+				config.Cgroups.Resources.NetClsClassid = *r.Network.ClassID
+			}
+			if r.Network.Priorities != nil {
+				config.Cgroups.Resources.NetPrioIfpriomap = nil
+				for _, p := range r.Network.Priorities {
+					config.Cgroups.Resources.NetPrioIfpriomap = append(config.Cgroups.Resources.NetPrioIfpriomap, &configs.IfPrioMap{
+						Interface: p.Name,
+						Priority:  int64(p.Priority),
+					})
+				}
+			}
+		}
 
-		return container.Set(config)
+		if err := container.Set(config); err != nil {
+			return err
+		}
+		if schedIdle != nil {
+			return container.SetSchedIdle(*schedIdle)
+		}
+		return nil
 	},
 }
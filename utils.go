@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/urfave/cli"
 )
@@ -42,12 +43,20 @@ func checkArgs(context *cli.Context, expected, checkType int) error {
 	return nil
 }
 
+// hookResponseExitCode is the exit status used when a hook wrote a malformed
+// response, so that callers can tell a misbehaving hook from other failures.
+const hookResponseExitCode = 3
+
 // fatal prints the error's details if it is a libcontainer specific error type
-// then exits the program with an exit status of 1.
+// then exits the program with an exit status of hookResponseExitCode if a hook
+// wrote a malformed response, or 1 otherwise.
 func fatal(err error) {
 	// make sure the error is written to the logger
 	logrus.Error(err)
 	fmt.Fprintln(os.Stderr, err)
+	if libcontainer.IsHookResponseError(err) {
+		os.Exit(hookResponseExitCode)
+	}
 	os.Exit(1)
 }
 